@@ -0,0 +1,92 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// An AliasOption configures the handler returned by NewAliasHandler.
+type AliasOption interface {
+	applyToAlias(*aliasConfig)
+}
+
+// WithDeprecationHeader adds a "Deprecation: true" response header to calls
+// made through an alias, so that clients and observability tools can find
+// callers still using the old path.
+func WithDeprecationHeader() AliasOption {
+	return &deprecationHeaderOption{}
+}
+
+// NewAliasHandler keeps a renamed package or service answering on its old
+// path during a migration. Requests whose path starts with the alias prefix
+// are rewritten to use the path prefix instead, and then served by the
+// handler. Both prefixes typically end with a slash, like the path returned
+// by generated handler constructors.
+//
+// For example, if the acme.ping.v1 package was renamed to connect.ping.v1:
+//
+//	path, handler := pingv1connect.NewPingServiceHandler(&pingServer{})
+//	mux.Handle(path, handler)
+//	mux.Handle(connect.NewAliasHandler(
+//	  "/acme.ping.v1.PingService/",
+//	  path,
+//	  handler,
+//	  connect.WithDeprecationHeader(),
+//	))
+//
+// NewAliasHandler returns the alias prefix and the wrapped handler, so its
+// results can be passed directly to http.ServeMux.Handle.
+func NewAliasHandler(
+	alias string,
+	path string,
+	handler http.Handler,
+	options ...AliasOption,
+) (string, http.Handler) {
+	var config aliasConfig
+	for _, opt := range options {
+		opt.applyToAlias(&config)
+	}
+	return alias, http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		if !strings.HasPrefix(request.URL.Path, alias) {
+			http.NotFound(responseWriter, request)
+			return
+		}
+		if config.Deprecated {
+			responseWriter.Header().Set("Deprecation", "true")
+		}
+		// Like http.StripPrefix, shallow-copy the request rather than mutating
+		// the caller's copy.
+		aliased := new(http.Request)
+		*aliased = *request
+		aliased.URL = new(url.URL)
+		*aliased.URL = *request.URL
+		aliased.URL.Path = path + strings.TrimPrefix(request.URL.Path, alias)
+		aliased.URL.RawPath = ""
+		handler.ServeHTTP(responseWriter, aliased)
+	})
+}
+
+type aliasConfig struct {
+	Deprecated bool
+}
+
+type deprecationHeaderOption struct{}
+
+func (o *deprecationHeaderOption) applyToAlias(config *aliasConfig) {
+	config.Deprecated = true
+}
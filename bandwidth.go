@@ -0,0 +1,174 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A BandwidthLimiter is a token bucket that limits the rate at which handlers
+// send and receive message bytes, so bulk transfers can't starve
+// latency-sensitive RPCs sharing the same server. Messages are measured by
+// the size of their binary Protobuf encoding; other messages aren't limited.
+//
+// Rather than failing RPCs that exceed the rate, handlers wait before sending
+// a message (or after receiving one) until the bucket has refilled. Messages
+// larger than the burst are allowed, but the wait afterwards is
+// correspondingly long. A BandwidthLimiter is safe to use concurrently, and a
+// nil *BandwidthLimiter imposes no limit.
+type BandwidthLimiter struct {
+	rate  float64 // bytes per second
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewBandwidthLimiter constructs a BandwidthLimiter that allows an average of
+// bytesPerSecond, with bursts of up to burst bytes. If burst isn't positive,
+// it defaults to one second's worth of bytes.
+func NewBandwidthLimiter(bytesPerSecond, burst int64) *BandwidthLimiter {
+	if burst <= 0 {
+		burst = bytesPerSecond
+	}
+	return &BandwidthLimiter{
+		rate:   float64(bytesPerSecond),
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+// wait takes n bytes from the bucket, waiting until they've been refilled if
+// the bucket runs dry. It returns early if the context ends.
+func (l *BandwidthLimiter) wait(ctx context.Context, clock Clock, n int64) error {
+	if l == nil || n <= 0 || l.rate <= 0 {
+		return nil
+	}
+	delay := l.reserve(clock.Now(), float64(n))
+	if delay <= 0 {
+		return nil
+	}
+	return wrapIfContextError(sleep(ctx, clock, delay))
+}
+
+// reserve refills the bucket, takes n bytes, and returns how long the caller
+// must wait for the bucket to be non-negative again.
+func (l *BandwidthLimiter) reserve(now time.Time, n float64) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+	l.tokens -= n
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// bandwidthLimit applies a handler's bandwidth limits to its streams.
+type bandwidthLimit struct {
+	shared    *BandwidthLimiter
+	perStream int64 // bytes per second, zero if unlimited
+	clock     Clock
+}
+
+// wrap limits a stream's Sender and Receiver. Each stream gets its own
+// bucket for the per-stream limit.
+func (b *bandwidthLimit) wrap(ctx context.Context, sender Sender, receiver Receiver) (Sender, Receiver) {
+	limiters := make([]*BandwidthLimiter, 0, 2)
+	if b.perStream > 0 {
+		limiters = append(limiters, NewBandwidthLimiter(b.perStream, 0))
+	}
+	if b.shared != nil {
+		limiters = append(limiters, b.shared)
+	}
+	stream := &bandwidthStream{ctx: ctx, clock: b.clock, limiters: limiters}
+	return &bandwidthSender{Sender: sender, stream: stream},
+		&bandwidthReceiver{Receiver: receiver, stream: stream}
+}
+
+type bandwidthStream struct {
+	ctx      context.Context
+	clock    Clock
+	limiters []*BandwidthLimiter
+}
+
+func (s *bandwidthStream) wait(message any) error {
+	size := messageSize(message)
+	for _, limiter := range s.limiters {
+		if err := limiter.wait(s.ctx, s.clock, size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bandwidthSender waits for capacity before sending each message.
+type bandwidthSender struct {
+	Sender
+
+	stream *bandwidthStream
+}
+
+func (s *bandwidthSender) Send(msg any) error {
+	if err := s.stream.wait(msg); err != nil {
+		return err
+	}
+	return s.Sender.Send(msg)
+}
+
+func (s *bandwidthSender) sendUncompressed(msg any) error {
+	if err := s.stream.wait(msg); err != nil {
+		return err
+	}
+	return sendUncompressed(s.Sender, msg)
+}
+
+func (s *bandwidthSender) sendBatch(messages []any) error {
+	for _, msg := range messages {
+		if err := s.stream.wait(msg); err != nil {
+			return err
+		}
+	}
+	return sendBatch(s.Sender, messages)
+}
+
+// bandwidthReceiver pays for each message after it arrives, since its size
+// isn't known in advance. Waiting delays the next read, so the client's sends
+// are throttled by flow control.
+type bandwidthReceiver struct {
+	Receiver
+
+	stream *bandwidthStream
+}
+
+func (r *bandwidthReceiver) Receive(msg any) error {
+	if err := r.Receiver.Receive(msg); err != nil {
+		return err
+	}
+	return r.stream.wait(msg)
+}
+
+func (r *bandwidthReceiver) readAhead() {
+	readAhead(r.Receiver)
+}
@@ -19,6 +19,7 @@ import (
 	"compress/gzip"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -67,12 +68,47 @@ func BenchmarkConnect(b *testing.B) {
 			}
 		})
 	})
+	b.Run("unary_small", func(b *testing.B) {
+		// For tiny messages, allocations in the wrappers dominate.
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				_, _ = client.Ping(
+					context.Background(),
+					connect.NewRequest(&pingv1.PingRequest{Number: 42}),
+				)
+			}
+		})
+	})
 }
 
 type ping struct {
 	Text string `json:"text"`
 }
 
+func BenchmarkServeMux(b *testing.B) {
+	// Emulate a gateway that hosts hundreds of services, some of which
+	// override individual procedures.
+	const services = 500
+	noop := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	mux := connect.NewServeMux()
+	requests := make([]*http.Request, 0, services)
+	for i := 0; i < services; i++ {
+		prefix := fmt.Sprintf("/acme.tenant%d.v1.TenantService/", i)
+		mux.Handle(prefix, noop)
+		if i%10 == 0 {
+			mux.Handle(prefix+"Override", noop)
+		}
+		requests = append(requests, httptest.NewRequest(http.MethodPost, prefix+"Get", nil))
+	}
+	writer := httptest.NewRecorder()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mux.ServeHTTP(writer, requests[i%len(requests)])
+	}
+}
+
 func BenchmarkREST(b *testing.B) {
 	handler := func(writer http.ResponseWriter, request *http.Request) {
 		defer request.Body.Close()
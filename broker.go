@@ -0,0 +1,163 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// A BrokerRequester sends a payload to a subject on a message broker and
+// waits for a single reply. It's the request/reply primitive offered by most
+// brokers: for example, a NATS connection's RequestWithContext method
+// satisfies it with a small adapter.
+type BrokerRequester interface {
+	Request(ctx context.Context, subject string, payload []byte) ([]byte, error)
+}
+
+// NewBrokerHTTPClient returns an HTTPClient that carries RPCs over a message
+// broker's request/reply mechanism instead of a network connection. Pass it to
+// generated client constructors in place of an *http.Client; use any base URL,
+// since only the URL's path is used. Each call is published to a subject named
+// after its procedure, with the leading slash removed (for example,
+// "connect.ping.v1.PingService/Ping").
+//
+// Calls are sent and received as complete HTTP/1.1 messages, so the whole
+// request must be sent before the server sees it, and the whole response must
+// be written before the client sees it. This suits unary, client streaming,
+// and server streaming RPCs with modestly-sized streams. Bidirectional
+// streams require full-duplex HTTP/2 and aren't supported.
+//
+// Serve calls on the other side of the broker with ServeBrokerRequest.
+func NewBrokerHTTPClient(requester BrokerRequester) HTTPClient {
+	return &brokerHTTPClient{requester: requester}
+}
+
+// ServeBrokerRequest decodes a payload received from a message broker (sent
+// by a client using NewBrokerHTTPClient), serves it with the handler, and
+// returns the encoded reply. The handler is typically the http.ServeMux that
+// would otherwise serve network requests. The context is used as the request
+// context, so it should carry any deadline or cancellation from the broker.
+func ServeBrokerRequest(ctx context.Context, handler http.Handler, payload []byte) ([]byte, error) {
+	request, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(payload)))
+	if err != nil {
+		return nil, fmt.Errorf("read brokered request: %w", err)
+	}
+	request = request.WithContext(ctx)
+	writer := &brokerResponseWriter{header: make(http.Header)}
+	handler.ServeHTTP(writer, request)
+	_ = request.Body.Close()
+	return writer.encode()
+}
+
+type brokerHTTPClient struct {
+	requester BrokerRequester
+}
+
+func (c *brokerHTTPClient) Do(request *http.Request) (*http.Response, error) {
+	var payload bytes.Buffer
+	if err := request.Write(&payload); err != nil {
+		return nil, fmt.Errorf("encode brokered request: %w", err)
+	}
+	subject := strings.TrimPrefix(request.URL.Path, "/")
+	reply, err := c.requester.Request(request.Context(), subject, payload.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	response, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(reply)), request)
+	if err != nil {
+		return nil, fmt.Errorf("read brokered response: %w", err)
+	}
+	return response, nil
+}
+
+// brokerResponseWriter buffers a complete response, including trailers.
+type brokerResponseWriter struct {
+	header      http.Header
+	wroteHeader bool
+	status      int
+	body        bytes.Buffer
+}
+
+func (w *brokerResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *brokerResponseWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.body.Write(data)
+}
+
+func (w *brokerResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = status
+}
+
+func (w *brokerResponseWriter) encode() ([]byte, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	header := make(http.Header, len(w.header))
+	trailer := make(http.Header)
+	for key, values := range w.header {
+		if strings.HasPrefix(key, http.TrailerPrefix) {
+			key = http.CanonicalHeaderKey(strings.TrimPrefix(key, http.TrailerPrefix))
+			trailer[key] = append(trailer[key], values...)
+			continue
+		}
+		header[key] = values
+	}
+	// Trailers may also be announced up front, with their values set after
+	// the body is written.
+	for _, declared := range header.Values("Trailer") {
+		for _, key := range strings.Split(declared, ",") {
+			key = http.CanonicalHeaderKey(strings.TrimSpace(key))
+			if values, ok := header[key]; ok {
+				trailer[key] = append(trailer[key], values...)
+				delete(header, key)
+			}
+		}
+	}
+	header.Del("Trailer")
+	response := &http.Response{
+		StatusCode:    w.status,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(&w.body),
+		ContentLength: int64(w.body.Len()),
+	}
+	if len(trailer) > 0 {
+		// Trailers require chunked encoding.
+		response.ContentLength = -1
+		response.TransferEncoding = []string{"chunked"}
+		response.Trailer = trailer
+	}
+	var reply bytes.Buffer
+	if err := response.Write(&reply); err != nil {
+		return nil, fmt.Errorf("encode brokered response: %w", err)
+	}
+	return reply.Bytes(), nil
+}
@@ -26,6 +26,10 @@ const (
 
 type bufferPool struct {
 	sync.Pool
+
+	// budget, if set, bounds the bytes of messages buffered by everyone using
+	// the pool.
+	budget *MemoryBudget
 }
 
 func newBufferPool() *bufferPool {
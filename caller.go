@@ -0,0 +1,43 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import "context"
+
+// Caller describes the client that sent an RPC. Handlers and interceptors can
+// use it for telemetry or to work around quirks of specific clients.
+type Caller struct {
+	// UserAgent is the client's User-Agent header, if any.
+	UserAgent string
+	// Protocol is the protocol the client used: ProtocolConnect, ProtocolGRPC,
+	// or ProtocolGRPCWeb.
+	Protocol string
+	// Codec is the name of the codec the client used, like "proto" or "json".
+	Codec string
+}
+
+type callerContextKey struct{}
+
+// CallerFromContext returns a description of the client that sent the RPC
+// being handled. It's available in handlers and in handler-side interceptors,
+// but not in clients.
+func CallerFromContext(ctx context.Context) (Caller, bool) {
+	caller, ok := ctx.Value(callerContextKey{}).(Caller)
+	return caller, ok
+}
+
+func withCaller(ctx context.Context, caller Caller) context.Context {
+	return context.WithValue(ctx, callerContextKey{}, caller)
+}
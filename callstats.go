@@ -0,0 +1,231 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// CallStats breaks down the latency of a unary client call, so regressions can
+// be attributed to the network, serialization, or the server. Clients collect
+// CallStats only when constructed with WithCallStats; retrieve them with
+// Response.CallStats or Error.CallStats.
+//
+// If the call was retried, the network and serialization timings describe
+// the final attempt, and Total covers every attempt. Timings for phases that
+// didn't happen, like DNS resolution on a reused connection, are zero.
+type CallStats struct {
+	// Total is the time from the start of the call, before any interceptors,
+	// until the response or error was returned.
+	Total time.Duration
+	// Queue is the time spent waiting for a connection from the pool, excluding
+	// the time spent establishing a new one.
+	Queue time.Duration
+	// DNS, Connect, and TLS are the times spent resolving the server's address,
+	// dialing it, and completing the TLS handshake for a new connection.
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	// ReusedConnection reports whether the call used a pooled connection.
+	ReusedConnection bool
+	// TimeToFirstByte is the time from obtaining a connection until the first
+	// byte of the response arrived: sending the request, the server's
+	// processing, and network latency.
+	TimeToFirstByte time.Duration
+	// Marshal and Unmarshal are the times spent serializing the request and
+	// deserializing the response with the client's Codec.
+	Marshal   time.Duration
+	Unmarshal time.Duration
+}
+
+type callStatsContextKey struct{}
+
+// callStatsRecorder accumulates CallStats. The net/http hooks run on the
+// transport's goroutines, so all fields are guarded by mu.
+type callStatsRecorder struct {
+	clock Clock
+
+	mu    sync.Mutex
+	start time.Time
+	stats CallStats
+
+	getConn, gotConn, dnsStart, connectStart, tlsStart time.Time
+}
+
+// withCallStats starts recording a call's stats, timed with the clock. The
+// returned context traces the HTTP requests made with it.
+func withCallStats(ctx context.Context, clock Clock) (context.Context, *callStatsRecorder) {
+	recorder := &callStatsRecorder{clock: clock, start: clock.Now()}
+	ctx = context.WithValue(ctx, callStatsContextKey{}, recorder)
+	return httptrace.WithClientTrace(ctx, recorder.trace()), recorder
+}
+
+func callStatsFromContext(ctx context.Context) *callStatsRecorder {
+	recorder, _ := ctx.Value(callStatsContextKey{}).(*callStatsRecorder)
+	return recorder
+}
+
+// startAttempt discards the network and serialization timings of earlier
+// attempts.
+func (r *callStatsRecorder) startAttempt() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats = CallStats{}
+	r.getConn, r.gotConn = time.Time{}, time.Time{}
+}
+
+func (r *callStatsRecorder) finish() *CallStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := r.stats
+	stats.Total = r.since(r.start)
+	if !r.getConn.IsZero() && !r.gotConn.IsZero() {
+		stats.Queue = r.gotConn.Sub(r.getConn) - stats.DNS - stats.Connect - stats.TLS
+		if stats.Queue < 0 {
+			stats.Queue = 0
+		}
+	}
+	return &stats
+}
+
+// attachToResponse adds the stats to a successful call's response.
+func (r *callStatsRecorder) attachToResponse(response interface{ setCallStats(*CallStats) }) {
+	response.setCallStats(r.finish())
+}
+
+// attachToError returns the failed call's error with the stats attached. The
+// *Error may be shared, like a sentinel returned by an interceptor or an error
+// reused across retries and hedged attempts, so the stats go in a new value
+// wrapping the error rather than in the *Error itself.
+func (r *callStatsRecorder) attachToError(err error) error {
+	stats := r.finish()
+	if _, ok := asError(err); !ok {
+		return err
+	}
+	return &callStatsError{error: err, stats: stats}
+}
+
+func (r *callStatsRecorder) since(start time.Time) time.Duration {
+	return r.clock.Now().Sub(start)
+}
+
+func (r *callStatsRecorder) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn: func(string) {
+			r.update(func() { r.getConn = r.clock.Now() })
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			r.update(func() {
+				r.gotConn = r.clock.Now()
+				r.stats.ReusedConnection = info.Reused
+			})
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			r.update(func() { r.dnsStart = r.clock.Now() })
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			r.update(func() { r.stats.DNS += r.since(r.dnsStart) })
+		},
+		ConnectStart: func(string, string) {
+			r.update(func() { r.connectStart = r.clock.Now() })
+		},
+		ConnectDone: func(string, string, error) {
+			r.update(func() { r.stats.Connect += r.since(r.connectStart) })
+		},
+		TLSHandshakeStart: func() {
+			r.update(func() { r.tlsStart = r.clock.Now() })
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			r.update(func() { r.stats.TLS += r.since(r.tlsStart) })
+		},
+		GotFirstResponseByte: func() {
+			r.update(func() {
+				if !r.gotConn.IsZero() {
+					r.stats.TimeToFirstByte = r.since(r.gotConn)
+				}
+			})
+		},
+	}
+}
+
+func (r *callStatsRecorder) update(update func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	update()
+}
+
+// callStatsCodec wraps the codec to time serialization, if the context is
+// recording CallStats.
+func callStatsCodec(ctx context.Context, codec Codec) Codec {
+	recorder := callStatsFromContext(ctx)
+	if recorder == nil {
+		return codec
+	}
+	return &timingCodec{Codec: codec, recorder: recorder}
+}
+
+type timingCodec struct {
+	Codec
+
+	recorder *callStatsRecorder
+}
+
+func (c *timingCodec) Marshal(message any) ([]byte, error) {
+	start := c.recorder.clock.Now()
+	data, err := c.Codec.Marshal(message)
+	elapsed := c.recorder.since(start)
+	c.recorder.update(func() { c.recorder.stats.Marshal += elapsed })
+	return data, err
+}
+
+func (c *timingCodec) Unmarshal(data []byte, message any) error {
+	start := c.recorder.clock.Now()
+	err := c.Codec.Unmarshal(data, message)
+	elapsed := c.recorder.since(start)
+	c.recorder.update(func() { c.recorder.stats.Unmarshal += elapsed })
+	return err
+}
+
+// callStatsError attaches CallStats to an error that wraps an *Error. It's
+// transparent except to errors.As, which finds a copy of the *Error with the
+// stats set.
+type callStatsError struct {
+	error
+
+	stats *CallStats
+}
+
+func (e *callStatsError) Unwrap() error {
+	return e.error
+}
+
+func (e *callStatsError) As(target any) bool {
+	connectErr, ok := target.(**Error)
+	if !ok {
+		return false
+	}
+	inner, ok := asError(e.error)
+	if !ok {
+		return false
+	}
+	withStats := *inner
+	withStats.callStats = e.stats
+	*connectErr = &withStats
+	return true
+}
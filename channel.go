@@ -0,0 +1,107 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// SendChannel adapts a ServerStream to a channel, for handlers that structure
+// their work as a pipeline of goroutines. Messages written to the returned
+// channel are sent on the stream in order. Once the handler is done, it must
+// close the channel and call wait, which returns the first error from Send.
+// The handler shouldn't call the stream's Send or SendBatch methods while the
+// channel is open.
+//
+// After Send fails, further messages written to the channel are discarded, so
+// writers never block forever. Writers that want to stop early should watch
+// the handler's context, which is canceled when the client goes away.
+func SendChannel[Res any](stream *ServerStream[Res]) (messages chan<- *Res, wait func() error) {
+	channel := make(chan *Res)
+	done := make(chan struct{})
+	var sendErr error
+	go func() {
+		defer close(done)
+		for msg := range channel {
+			if sendErr != nil {
+				continue
+			}
+			sendErr = stream.Send(msg)
+		}
+	}()
+	return channel, func() error {
+		<-done
+		return sendErr
+	}
+}
+
+// ReceiveChannel adapts a ServerStreamForClient to a channel, for clients that
+// structure their work as a pipeline of goroutines. Messages received from the
+// stream are delivered on the returned channel, which is closed when the
+// stream ends. Once the channel is closed, err returns the stream's error, or
+// nil if the stream ended successfully. Unlike the stream's Msg method, each
+// message on the channel is a new value that the caller owns.
+//
+// If the context ends before the stream does, ReceiveChannel closes the
+// stream, so callers that stop reading early don't leak the goroutine. The
+// caller shouldn't use the stream directly while the channel is open.
+func ReceiveChannel[Res any](
+	ctx context.Context,
+	stream *ServerStreamForClient[Res],
+) (messages <-chan *Res, err func() error) {
+	channel := make(chan *Res)
+	var (
+		mu         sync.Mutex
+		receiveErr error
+	)
+	go func() {
+		defer close(channel)
+		for stream.Receive() {
+			select {
+			case channel <- cloneMessage(stream.Msg()):
+			case <-ctx.Done():
+				_ = stream.Close()
+				mu.Lock()
+				receiveErr = wrapIfContextError(ctx.Err())
+				mu.Unlock()
+				return
+			}
+		}
+		mu.Lock()
+		receiveErr = stream.Err()
+		mu.Unlock()
+	}()
+	return channel, func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		return receiveErr
+	}
+}
+
+// cloneMessage returns a copy of msg that doesn't share memory with the
+// original. Protobuf messages mustn't be copied by value, so we use
+// proto.Clone when we can.
+func cloneMessage[T any](msg *T) *T {
+	if message, ok := any(msg).(proto.Message); ok {
+		if clone, ok := any(proto.Clone(message)).(*T); ok {
+			return clone
+		}
+	}
+	clone := *msg
+	return &clone
+}
@@ -0,0 +1,95 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"net/http"
+	"strings"
+)
+
+const (
+	// Clients constructed with WithChecksums send this header to ask handlers
+	// to checksum their responses.
+	headerAcceptChecksum = "Accept-Message-Checksum"
+	// Unary Connect messages aren't enveloped, so their checksum is sent as a
+	// hex-encoded header.
+	headerMessageCRC32C = "Message-Crc32c"
+
+	checksumCRC32C = "crc32c"
+	checksumSize   = 4
+
+	// flagEnvelopeChecksum indicates that the last four bytes of the envelope's
+	// data are a big-endian CRC32C of the preceding bytes. The checksum covers
+	// the data as it appears on the wire, after any compression.
+	flagEnvelopeChecksum = 0b00000100
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+func checksumData(data []byte) uint32 {
+	return crc32.Checksum(data, crc32cTable)
+}
+
+// acceptsChecksums reports whether the client asked for checksummed
+// responses.
+func acceptsChecksums(header http.Header) bool {
+	for _, name := range strings.Split(header.Get(headerAcceptChecksum), ",") {
+		if strings.TrimSpace(name) == checksumCRC32C {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyEnvelopeChecksum strips the trailing checksum from the envelope's data
+// and verifies it.
+func verifyEnvelopeChecksum(env *envelope) *Error {
+	data := env.Data.Bytes()
+	if len(data) < checksumSize {
+		return errorf(CodeInvalidArgument, "protocol error: checksummed envelope has only %d bytes", len(data))
+	}
+	payload := data[:len(data)-checksumSize]
+	want := binary.BigEndian.Uint32(data[len(payload):])
+	if got := checksumData(payload); got != want {
+		return errorf(CodeDataLoss, "message checksum mismatch: got %08x, expected %08x", got, want)
+	}
+	env.Data.Truncate(len(payload))
+	env.Flags &^= flagEnvelopeChecksum
+	return nil
+}
+
+// verifyUnaryChecksum verifies an unenveloped message against a hex-encoded
+// checksum from headerMessageCRC32C.
+func verifyUnaryChecksum(data *bytes.Buffer, encoded string) *Error {
+	decoded, err := hex.DecodeString(encoded)
+	if err != nil || len(decoded) != checksumSize {
+		return errorf(CodeInvalidArgument, "protocol error: invalid %s header %q", headerMessageCRC32C, encoded)
+	}
+	want := binary.BigEndian.Uint32(decoded)
+	if got := checksumData(data.Bytes()); got != want {
+		return errorf(CodeDataLoss, "message checksum mismatch: got %08x, expected %08x", got, want)
+	}
+	return nil
+}
+
+func encodeUnaryChecksum(data []byte) string {
+	var encoded [checksumSize]byte
+	binary.BigEndian.PutUint32(encoded[:], checksumData(data))
+	return hex.EncodeToString(encoded[:])
+}
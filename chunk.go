@@ -0,0 +1,137 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"errors"
+	"io"
+)
+
+// DefaultChunkSize is the chunk size NewChunkWriter uses when it's given a
+// non-positive size. It's comfortably below the default message size limits
+// of both connect-go and grpc-go.
+const DefaultChunkSize = 64 * 1024 // 64 KiB
+
+// NewChunkWriter adapts a stream's Send method to an io.Writer, so that large
+// payloads (multi-gigabyte uploads or downloads, for example) can be streamed
+// as a sequence of messages rather than materialized as a single bytes field.
+// Each write is split into chunks of at most chunkSize bytes, and wrap builds
+// the message that carries each chunk. Typically, wrap sets a bytes field:
+//
+//	writer := connect.NewChunkWriter(stream.Send, 0, func(chunk []byte) *filev1.UploadRequest {
+//		return &filev1.UploadRequest{Data: chunk}
+//	})
+//	_, err := io.Copy(writer, file)
+//
+// Messages are marshaled before Send returns, so wrap may use the chunk
+// without copying it. The writer doesn't close the stream; callers still
+// call CloseAndReceive, CloseSend, or return from the handler as usual.
+func NewChunkWriter[T any](send func(*T) error, chunkSize int, wrap func(chunk []byte) *T) io.Writer {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	return &chunkWriter[T]{send: send, size: chunkSize, wrap: wrap}
+}
+
+// NewChunkReader is the inverse of NewChunkWriter: it adapts a function that
+// receives messages to an io.Reader over the concatenation of their chunks.
+// Receive should return io.EOF once the stream ends successfully, and unwrap
+// returns the chunk carried by each message. ReceiveFunc adapts streams with
+// Receive, Msg, and Err methods, and BidiStream.Receive can be used
+// directly:
+//
+//	reader := connect.NewChunkReader(
+//		connect.ReceiveFunc[filev1.UploadRequest](stream),
+//		(*filev1.UploadRequest).GetData,
+//	)
+//	_, err := io.Copy(file, reader)
+//
+// The reader holds at most one message at a time, so memory use doesn't grow
+// with the size of the payload.
+func NewChunkReader[T any](receive func() (*T, error), unwrap func(*T) []byte) io.Reader {
+	return &chunkReader[T]{receive: receive, unwrap: unwrap}
+}
+
+// ReceiveFunc adapts a stream with Receive, Msg, and Err methods (like
+// ClientStream and ServerStreamForClient) to a function that returns the next
+// message, or io.EOF once the stream ends successfully. It's most useful with
+// NewChunkReader.
+func ReceiveFunc[T any](stream interface {
+	Receive() bool
+	Msg() *T
+	Err() error
+}) func() (*T, error) {
+	return func() (*T, error) {
+		if stream.Receive() {
+			return stream.Msg(), nil
+		}
+		if err := stream.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+}
+
+type chunkWriter[T any] struct {
+	send func(*T) error
+	size int
+	wrap func([]byte) *T
+}
+
+func (w *chunkWriter[T]) Write(data []byte) (int, error) {
+	var written int
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > w.size {
+			chunk = chunk[:w.size]
+		}
+		if err := w.send(w.wrap(chunk)); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		data = data[len(chunk):]
+	}
+	return written, nil
+}
+
+type chunkReader[T any] struct {
+	receive func() (*T, error)
+	unwrap  func(*T) []byte
+	pending []byte
+	err     error
+}
+
+func (r *chunkReader[T]) Read(data []byte) (int, error) {
+	// Senders may send empty chunks, so keep receiving until we have some data
+	// or the stream ends.
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		msg, err := r.receive()
+		if errors.Is(err, io.EOF) {
+			// Callers compare against io.EOF directly, so unwrap it.
+			r.err = io.EOF
+			continue
+		} else if err != nil {
+			r.err = err
+			continue
+		}
+		r.pending = r.unwrap(msg)
+	}
+	n := copy(data, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
@@ -19,6 +19,10 @@ import (
 	"errors"
 	"io"
 	"net/http"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
 // Client is a reusable, concurrency-safe client for a single procedure.
@@ -30,9 +34,16 @@ import (
 // or gRPC-Web protocols, use the WithGRPC or WithGRPCWeb options.
 type Client[Req, Res any] struct {
 	config         *clientConfig
-	callUnary      func(context.Context, *Request[Req]) (*Response[Res], error)
+	callUnary      func(context.Context, AnyRequest) (*Response[Res], error)
 	protocolClient protocolClient
+	httpClient     HTTPClient // nil when using a custom Transport
+	url            string
+	unarySpec      Spec
 	err            error
+
+	// Only set if the client was constructed with a fallback protocol.
+	fallbackClient protocolClient
+	fellBack       int32 // atomic, 1 once a call has needed the fallback
 }
 
 // NewClient constructs a new Client.
@@ -44,31 +55,97 @@ func NewClient[Req, Res any](httpClient HTTPClient, url string, options ...Clien
 		return client
 	}
 	client.config = config
-	protocolClient, protocolErr := client.config.Protocol.NewClient(
-		&protocolClientParams{
-			CompressionName: config.RequestCompressionName,
-			CompressionPools: newReadOnlyCompressionPools(
-				config.CompressionPools,
-				config.CompressionNames,
-			),
-			Codec:            config.Codec,
-			Protobuf:         config.protobuf(),
-			CompressMinBytes: config.CompressMinBytes,
-			HTTPClient:       httpClient,
-			URL:              url,
-			BufferPool:       config.BufferPool,
-		},
-	)
-	if protocolErr != nil {
-		client.err = protocolErr
-		return client
+	if config.RoundTripMiddleware != nil {
+		httpClient = config.RoundTripMiddleware(httpClient)
+	}
+	params := &protocolClientParams{
+		CompressionName: config.RequestCompressionName,
+		CompressionPools: newReadOnlyCompressionPools(
+			config.CompressionPools,
+			config.acceptCompressionNames(),
+		),
+		Codec:            config.codec(),
+		Protobuf:         config.protobuf(),
+		CompressMinBytes: config.CompressMinBytes,
+		SendMaxBytes:     config.SendMaxBytes,
+		ReadMaxBytes:     config.ReadMaxBytes,
+		HTTPClient:       httpClient,
+		URL:              url,
+		BufferPool:       config.BufferPool,
+		Clock:            config.Clock,
+		Checksums:        config.Checksums,
+		UserAgent:        config.UserAgent,
+		Types:            config.Types,
+
+		DetectHTTP2Unavailable: config.Transport == nil && config.usesFallback(),
+	}
+	var protocolClient protocolClient = &transportClient{transport: config.Transport}
+	if config.Transport == nil {
+		var protocolErr error
+		protocolClient, protocolErr = client.config.Protocol.NewClient(params)
+		if protocolErr != nil {
+			client.err = protocolErr
+			return client
+		}
 	}
 	client.protocolClient = protocolClient
+	if config.Transport == nil {
+		client.httpClient = httpClient
+		client.url = url
+	}
 	// Rather than applying unary interceptors along the hot path, we can do it
 	// once at client creation.
 	unarySpec := config.newSpec(StreamTypeUnary)
+	client.unarySpec = unarySpec
+	callUnary := newUnaryCall[Res](config, protocolClient, unarySpec)
+	if config.Transport == nil && config.usesFallback() {
+		fallbackClient, fallbackErr := config.FallbackProtocol.NewClient(params)
+		if fallbackErr != nil {
+			client.err = fallbackErr
+			return client
+		}
+		client.fallbackClient = fallbackClient
+		callUnary = withUnaryFallback(
+			callUnary,
+			newUnaryCall[Res](config, fallbackClient, unarySpec),
+			&client.fellBack,
+		)
+	}
+	if slowLog := config.SlowRequestLog; slowLog != nil {
+		callUnary = withSlowRequestLog(callUnary, slowLog, unarySpec, config.Peer)
+	}
+	if config.Timeout > 0 || config.DefaultTimeout > 0 {
+		callUnary = withUnaryTimeout(callUnary, config)
+	}
+	client.callUnary = callUnary
+	return client
+}
+
+func newUnaryCall[Res any](
+	config *clientConfig,
+	protocolClient protocolClient,
+	unarySpec Spec,
+) func(context.Context, AnyRequest) (*Response[Res], error) {
+	// The protocol writes the same reserved headers for every unary call, so
+	// one snapshot is enough to catch changes by callers and interceptors.
+	reserved := make(http.Header)
+	protocolClient.WriteRequestHeader(StreamTypeUnary, reserved)
+	reserved = reservedHeaders(reserved)
 	unaryFunc := UnaryFunc(func(ctx context.Context, request AnyRequest) (AnyResponse, error) {
-		sender, receiver := protocolClient.NewStream(ctx, unarySpec, request.Header())
+		if recorder := callStatsFromContext(ctx); recorder != nil {
+			recorder.startAttempt()
+		}
+		// Below the interceptors, so they can't get around the check.
+		if key := changedReservedHeader(request.Header(), reserved); key != "" {
+			return nil, errReservedHeader(key)
+		}
+		if validator := config.validator(); validator != nil {
+			// Validate before opening the stream, so we don't send anything.
+			if err := validate(validator, request.Any()); err != nil {
+				return nil, err
+			}
+		}
+		sender, receiver := protocolClient.NewStream(ctx, unarySpec, outgoingHeader(ctx, request.Header()))
 		// Send always returns an io.EOF unless the error is from the client-side.
 		// We want the user to continue to call Receive in those cases to get the
 		// full error from the server-side.
@@ -88,25 +165,96 @@ func NewClient[Req, Res any](httpClient HTTPClient, url string, options ...Clien
 		}
 		return response, receiver.Close()
 	})
+	// Like gRPC, retry each attempt below the interceptors, so interceptors
+	// only see the outcome of the final attempt.
+	if retry := config.Retry; retry != nil {
+		unaryFunc = retry.WrapUnary(unaryFunc)
+	}
 	if interceptor := config.Interceptor; interceptor != nil {
 		unaryFunc = interceptor.WrapUnary(unaryFunc)
 	}
-	client.callUnary = func(ctx context.Context, request *Request[Req]) (*Response[Res], error) {
+	return func(ctx context.Context, request AnyRequest) (*Response[Res], error) {
+		if key := conflictingReservedHeader(request.Header(), nil); key != "" {
+			return nil, errReservedHeader(key)
+		}
+		// Requests may be reused, possibly with another protocol, so remove the
+		// protocol's headers once the call is done.
+		defer restoreReservedHeaders(request.Header(), nil)
 		// To make the specification and RPC headers visible to the full interceptor
 		// chain (as though they were supplied by the caller), we'll add them here.
-		request.spec = unarySpec
 		protocolClient.WriteRequestHeader(StreamTypeUnary, request.Header())
+		var recorder *callStatsRecorder
+		if config.CallStats {
+			ctx, recorder = withCallStats(ctx, config.Clock)
+		}
 		response, err := unaryFunc(ctx, request)
 		if err != nil {
+			if recorder != nil {
+				err = recorder.attachToError(err)
+			}
 			return nil, err
 		}
 		typed, ok := response.(*Response[Res])
 		if !ok {
 			return nil, errorf(CodeInternal, "unexpected client response type %T", response)
 		}
+		if recorder != nil {
+			recorder.attachToResponse(typed)
+		}
 		return typed, nil
 	}
-	return client
+}
+
+// withUnaryFallback retries calls that fail because HTTP/2 is unavailable
+// using the fallback protocol. Once any call has fallen back, later calls use
+// the fallback protocol directly.
+func withUnaryFallback[Res any](
+	primary, fallback func(context.Context, AnyRequest) (*Response[Res], error),
+	fellBack *int32,
+) func(context.Context, AnyRequest) (*Response[Res], error) {
+	return func(ctx context.Context, request AnyRequest) (*Response[Res], error) {
+		if atomic.LoadInt32(fellBack) == 1 {
+			return fallback(ctx, request)
+		}
+		// The primary protocol adds its own headers, so keep a pristine copy.
+		header := request.Header().Clone()
+		response, err := primary(ctx, request)
+		if err == nil || !errors.Is(err, errHTTP2Unavailable) {
+			return response, err
+		}
+		atomic.StoreInt32(fellBack, 1)
+		for key := range request.Header() {
+			delete(request.Header(), key)
+		}
+		mergeHeaders(request.Header(), header)
+		return fallback(ctx, request)
+	}
+}
+
+func withSlowRequestLog[Res any](
+	call func(context.Context, AnyRequest) (*Response[Res], error),
+	slowLog *slowRequestLog,
+	unarySpec Spec,
+	peer string,
+) func(context.Context, AnyRequest) (*Response[Res], error) {
+	return func(ctx context.Context, request AnyRequest) (*Response[Res], error) {
+		defer slowLog.start(ctx, unarySpec, peer)()
+		return call(ctx, request)
+	}
+}
+
+func withUnaryTimeout[Res any](
+	call func(context.Context, AnyRequest) (*Response[Res], error),
+	config *clientConfig,
+) func(context.Context, AnyRequest) (*Response[Res], error) {
+	return func(ctx context.Context, request AnyRequest) (*Response[Res], error) {
+		if timeout := config.timeout(ctx); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = withTimeout(ctx, config.Clock, timeout)
+			defer cancel()
+		}
+		return call(ctx, request)
+	}
 }
 
 // CallUnary calls a request-response procedure.
@@ -114,6 +262,13 @@ func (c *Client[Req, Res]) CallUnary(ctx context.Context, request *Request[Req])
 	if c.err != nil {
 		return nil, c.err
 	}
+	request.spec = c.unarySpec
+	if c.config.IdempotencyKey {
+		var err error
+		if ctx, err = withOutgoingIdempotencyKey(ctx, request.Header()); err != nil {
+			return nil, err
+		}
+	}
 	return c.callUnary(ctx, request)
 }
 
@@ -131,7 +286,38 @@ func (c *Client[Req, Res]) CallServerStream(ctx context.Context, request *Reques
 	if c.err != nil {
 		return nil, c.err
 	}
-	sender, receiver := c.newStream(ctx, StreamTypeServer)
+	protocolClient := c.protocolClient
+	if c.fallbackClient != nil && atomic.LoadInt32(&c.fellBack) == 1 {
+		protocolClient = c.fallbackClient
+	}
+	receiver, err := c.startServerStream(ctx, protocolClient, request)
+	if err != nil {
+		return nil, err
+	}
+	stream := &ServerStreamForClient[Res]{receiver: receiver}
+	if c.config.lazyUnmarshal() {
+		stream.lazy = &lazyMessage{}
+	}
+	if protocolClient != c.fallbackClient && c.fallbackClient != nil {
+		stream.fallback = func() (Receiver, error) {
+			atomic.StoreInt32(&c.fellBack, 1)
+			return c.startServerStream(ctx, c.fallbackClient, request)
+		}
+	}
+	return stream, nil
+}
+
+func (c *Client[Req, Res]) startServerStream(
+	ctx context.Context,
+	protocolClient protocolClient,
+	request *Request[Req],
+) (Receiver, error) {
+	if validator := c.config.validator(); validator != nil {
+		if err := validate(validator, request.Msg); err != nil {
+			return nil, err
+		}
+	}
+	sender, receiver := c.newStreamWith(ctx, protocolClient, StreamTypeServer)
 	mergeHeaders(sender.Header(), request.header)
 	// Send always returns an io.EOF unless the error is from the client-side.
 	// We want the user to continue to call Receive in those cases to get the
@@ -142,9 +328,11 @@ func (c *Client[Req, Res]) CallServerStream(ctx context.Context, request *Reques
 		return nil, err
 	}
 	if err := sender.Close(nil); err != nil {
+		// Closing the receiver releases the call's timeout and response body.
+		_ = receiver.Close()
 		return nil, err
 	}
-	return &ServerStreamForClient[Res]{receiver: receiver}, nil
+	return receiver, nil
 }
 
 // CallBidiStream calls a bidirectional streaming procedure.
@@ -156,22 +344,77 @@ func (c *Client[Req, Res]) CallBidiStream(ctx context.Context) *BidiStreamForCli
 	return &BidiStreamForClient[Req, Res]{sender: sender, receiver: receiver}
 }
 
+// Preflight checks that the server is reachable at the procedure's URL,
+// without calling the procedure. Call it when a program starts to catch
+// misconfigured URLs and TLS problems early; see the package-level Preflight
+// function for details. Clients constructed with WithTransport have no
+// connection to check, so Preflight always succeeds.
+func (c *Client[Req, Res]) Preflight(ctx context.Context) error {
+	if c.err != nil {
+		return c.err
+	}
+	if c.httpClient == nil {
+		return nil
+	}
+	return Preflight(ctx, c.httpClient, c.url)
+}
+
 func (c *Client[Req, Res]) newStream(ctx context.Context, streamType StreamType) (Sender, Receiver) {
+	return c.newStreamWith(ctx, c.protocolClient, streamType)
+}
+
+func (c *Client[Req, Res]) newStreamWith(
+	ctx context.Context,
+	protocolClient protocolClient,
+	streamType StreamType,
+) (Sender, Receiver) {
+	var cancel context.CancelFunc
+	if timeout := c.config.timeout(ctx); timeout > 0 {
+		ctx, cancel = withTimeout(ctx, c.config.Clock, timeout)
+	}
+	spec := c.config.newSpec(streamType)
 	if interceptor := c.config.Interceptor; interceptor != nil {
-		ctx = interceptor.WrapStreamContext(ctx)
+		ctx = interceptor.WrapStreamContext(withSpec(ctx, spec))
 	}
 	header := make(http.Header, 8) // arbitrary power of two, prevent immediate resizing
-	c.protocolClient.WriteRequestHeader(streamType, header)
-	sender, receiver := c.protocolClient.NewStream(ctx, c.config.newSpec(streamType), header)
+	protocolClient.WriteRequestHeader(streamType, header)
+	sender, receiver := protocolClient.NewStream(ctx, spec, header)
+	sender = newReservedHeaderSender(sender)
+	if validator := c.config.validator(); validator != nil {
+		sender = &validatingSender{Sender: sender, validator: validator}
+	}
 	if interceptor := c.config.Interceptor; interceptor != nil {
 		sender = interceptor.WrapStreamSender(ctx, sender)
 		receiver = interceptor.WrapStreamReceiver(ctx, receiver)
 	}
+	if slowLog := c.config.SlowRequestLog; slowLog != nil {
+		receiver = &slowLogReceiver{
+			Receiver: receiver,
+			finish:   slowLog.start(ctx, spec, c.config.Peer),
+		}
+	}
+	if cancel != nil {
+		receiver = &cancelReceiver{Receiver: receiver, cancel: cancel}
+	}
 	return sender, receiver
 }
 
+// cancelReceiver releases a stream's timeout once the stream is closed.
+type cancelReceiver struct {
+	Receiver
+
+	cancel context.CancelFunc
+}
+
+func (r *cancelReceiver) Close() error {
+	err := r.Receiver.Close()
+	r.cancel()
+	return err
+}
+
 type clientConfig struct {
 	Protocol               protocol
+	UnknownProtocol        string
 	Procedure              string
 	CompressMinBytes       int
 	Interceptor            Interceptor
@@ -179,7 +422,31 @@ type clientConfig struct {
 	CompressionNames       []string
 	Codec                  Codec
 	RequestCompressionName string
+	ResponseCompression    []string // nil accepts all
+	LazyUnmarshal          bool
+	IdempotencyKey         bool
+	IdempotencyLevel       IdempotencyLevel
+	SlowRequestLog         *slowRequestLog
+	Peer                   string // host from the client's URL
+	FallbackProtocol       protocol
+	Transport              Transport
 	BufferPool             *bufferPool
+	Clock                  Clock
+	Checksums              bool
+	MessageTransformer     *messageTransformer
+	DefaultTimeout         time.Duration
+	ServiceConfig          *ServiceConfig
+	Validator              Validator
+	Validation             bool
+	UserAgent              string
+	RoundTripMiddleware    RoundTripMiddleware
+	CallStats              bool
+	Types                  *protoregistry.Types
+	// Resolved from the ServiceConfig.
+	Timeout      time.Duration
+	Retry        Interceptor
+	SendMaxBytes int
+	ReadMaxBytes int
 }
 
 func newClientConfig(url string, options []ClientOption) (*clientConfig, *Error) {
@@ -187,8 +454,10 @@ func newClientConfig(url string, options []ClientOption) (*clientConfig, *Error)
 	config := clientConfig{
 		Protocol:         &protocolConnect{},
 		Procedure:        protoPath,
+		Peer:             peerFromURL(url),
 		CompressionPools: make(map[string]*compressionPool),
 		BufferPool:       newBufferPool(),
+		Clock:            systemClock{},
 	}
 	withProtoBinaryCodec().applyToClient(&config)
 	withGzip().applyToClient(&config)
@@ -198,10 +467,46 @@ func newClientConfig(url string, options []ClientOption) (*clientConfig, *Error)
 	if err := config.validate(); err != nil {
 		return nil, err
 	}
+	config.resolveServiceConfig()
+	config.resolveTypes()
+	if config.SlowRequestLog != nil {
+		config.SlowRequestLog.clock = config.Clock
+	}
 	return &config, nil
 }
 
+func (c *clientConfig) resolveServiceConfig() {
+	if c.ServiceConfig == nil {
+		return
+	}
+	method := c.ServiceConfig.methodConfig(c.Procedure)
+	if method == nil {
+		return
+	}
+	c.Timeout = method.Timeout
+	c.SendMaxBytes = method.MaxRequestBytes
+	c.ReadMaxBytes = method.MaxResponseBytes
+	if policy := c.ServiceConfig.retryPolicy(method); policy != nil {
+		policy.Clock = c.Clock
+		c.Retry = NewRetryInterceptor(*policy)
+	}
+}
+
+// resolveTypes configures the default JSON codec to resolve Any fields with
+// the types registered by WithTypeRegistry. Custom codecs are left alone.
+func (c *clientConfig) resolveTypes() {
+	if c.Types == nil {
+		return
+	}
+	if _, ok := c.Codec.(*protoJSONCodec); ok {
+		c.Codec = &protoJSONCodec{types: c.Types}
+	}
+}
+
 func (c *clientConfig) validate() *Error {
+	if c.Protocol == nil {
+		return errorf(CodeUnknown, "unknown protocol %q", c.UnknownProtocol)
+	}
 	if c.Codec == nil || c.Codec.Name() == "" {
 		return errorf(CodeUnknown, "no codec configured")
 	}
@@ -210,9 +515,67 @@ func (c *clientConfig) validate() *Error {
 			return errorf(CodeUnknown, "unknown compression %q", c.RequestCompressionName)
 		}
 	}
+	for _, name := range c.ResponseCompression {
+		if _, ok := c.CompressionPools[name]; !ok && name != compressionIdentity {
+			return errorf(CodeUnknown, "unknown compression %q", name)
+		}
+	}
 	return nil
 }
 
+// acceptCompressionNames returns the algorithms servers may use to compress
+// responses, in registration order. The client can still decompress
+// responses compressed with any registered algorithm.
+func (c *clientConfig) acceptCompressionNames() []string {
+	if c.ResponseCompression == nil {
+		return c.CompressionNames
+	}
+	accepted := stringSet(c.ResponseCompression)
+	names := make([]string, 0, len(c.CompressionNames))
+	for _, name := range c.CompressionNames {
+		if _, ok := accepted[name]; ok {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		// Servers assume that clients accept the request's compression unless
+		// told otherwise.
+		return []string{compressionIdentity}
+	}
+	return names
+}
+
+// usesFallback reports whether the client should retry calls that fail
+// because HTTP/2 is unavailable. Only the gRPC protocol requires HTTP/2.
+func (c *clientConfig) usesFallback() bool {
+	if c.FallbackProtocol == nil {
+		return false
+	}
+	grpc, ok := c.Protocol.(*protocolGRPC)
+	return ok && !grpc.web
+}
+
+// timeout returns the timeout for a call, or zero if the call doesn't need
+// one. The DefaultTimeout only applies when the context has no deadline.
+func (c *clientConfig) timeout(ctx context.Context) time.Duration {
+	if _, ok := ctx.Deadline(); ok || c.DefaultTimeout <= 0 {
+		return c.Timeout
+	}
+	if c.Timeout > 0 && c.Timeout < c.DefaultTimeout {
+		return c.Timeout
+	}
+	return c.DefaultTimeout
+}
+
+// codec returns the Codec for request and response messages.
+func (c *clientConfig) codec() Codec {
+	codec := c.Codec
+	if c.MessageTransformer != nil {
+		codec = &transformingCodec{Codec: codec, transformer: c.MessageTransformer}
+	}
+	return codec
+}
+
 func (c *clientConfig) protobuf() Codec {
 	if c.Codec.Name() == codecNameProto {
 		return c.Codec
@@ -220,10 +583,32 @@ func (c *clientConfig) protobuf() Codec {
 	return &protoBinaryCodec{}
 }
 
+// validator returns the Validator to use, or nil if validation is disabled.
+func (c *clientConfig) validator() Validator {
+	if !c.Validation {
+		return nil
+	}
+	return c.Validator
+}
+
+// lazyUnmarshal reports whether streams should defer unmarshaling. Stream
+// interceptors and validators must see the generated message types, so
+// messages are always unmarshaled eagerly when there are interceptors or
+// validation is enabled.
+func (c *clientConfig) lazyUnmarshal() bool {
+	return c.LazyUnmarshal && c.Interceptor == nil && c.validator() == nil
+}
+
 func (c *clientConfig) newSpec(t StreamType) Spec {
+	requestCompression := c.RequestCompressionName
+	if requestCompression == "" {
+		requestCompression = compressionIdentity
+	}
 	return Spec{
-		StreamType: t,
-		Procedure:  c.Procedure,
-		IsClient:   true,
+		StreamType:       t,
+		Procedure:        c.Procedure,
+		IsClient:         true,
+		IdempotencyLevel: c.IdempotencyLevel,
+		compression:      Compression{Request: requestCompression},
 	}
 }
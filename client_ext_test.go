@@ -15,15 +15,31 @@
 package connect_test
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/bufbuild/connect-go"
 	"github.com/bufbuild/connect-go/internal/assert"
 	pingv1 "github.com/bufbuild/connect-go/internal/gen/connect/ping/v1"
 	"github.com/bufbuild/connect-go/internal/gen/connect/ping/v1/pingv1connect"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 func TestNewClient_InitFailure(t *testing.T) {
@@ -68,3 +84,1901 @@ func TestNewClient_InitFailure(t *testing.T) {
 		validateExpectedError(t, err)
 	})
 }
+
+func TestWithProtocol(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}))
+	server := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		server.Close()
+	})
+	for _, protocol := range []string{connect.ProtocolConnect, connect.ProtocolGRPC, connect.ProtocolGRPCWeb} {
+		protocol := protocol
+		t.Run(protocol, func(t *testing.T) {
+			t.Parallel()
+			client := pingv1connect.NewPingServiceClient(
+				server.Client(),
+				server.URL,
+				connect.WithProtocol(protocol),
+			)
+			response, err := client.Ping(
+				context.Background(),
+				connect.NewRequest(&pingv1.PingRequest{Number: 42}),
+			)
+			assert.Nil(t, err)
+			assert.Equal(t, response.Msg.Number, 42)
+		})
+	}
+	t.Run("unknown", func(t *testing.T) {
+		t.Parallel()
+		client := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL,
+			connect.WithProtocol("carrier-pigeon"),
+		)
+		_, err := client.Ping(
+			context.Background(),
+			connect.NewRequest(&pingv1.PingRequest{Number: 42}),
+		)
+		assert.Equal(t, connect.CodeOf(err), connect.CodeUnknown)
+		var connectErr *connect.Error
+		assert.True(t, errors.As(err, &connectErr))
+		assert.Equal(t, connectErr.Message(), `unknown protocol "carrier-pigeon"`)
+	})
+}
+
+type inMemoryBroker struct {
+	handler http.Handler
+}
+
+func (b *inMemoryBroker) Request(ctx context.Context, subject string, payload []byte) ([]byte, error) {
+	return connect.ServeBrokerRequest(ctx, b.handler, payload)
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	t.Parallel()
+	const pingProcedure = "/" + pingv1connect.PingServiceName + "/Ping"
+	keys := make(chan string, 10)
+	mux := http.NewServeMux()
+	mux.Handle(pingProcedure, connect.NewUnaryHandler(
+		pingProcedure,
+		func(ctx context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+			key, ok := connect.IdempotencyKeyFromContext(ctx)
+			assert.True(t, ok)
+			keys <- key
+			return connect.NewResponse(&pingv1.PingResponse{}), nil
+		},
+	))
+	server := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		server.Close()
+	})
+	// Retry every call once, to verify that both attempts share a key.
+	retry := connect.UnaryInterceptorFunc(func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, request connect.AnyRequest) (connect.AnyResponse, error) {
+			if _, err := next(ctx, request); err != nil {
+				return nil, err
+			}
+			return next(ctx, request)
+		}
+	})
+	client := pingv1connect.NewPingServiceClient(
+		server.Client(),
+		server.URL,
+		connect.WithIdempotencyKey(),
+		connect.WithInterceptors(retry),
+	)
+	_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+	assert.Nil(t, err)
+	first, second := <-keys, <-keys
+	assert.Equal(t, len(first), 32)
+	assert.Equal(t, first, second)
+
+	_, err = client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+	assert.Nil(t, err)
+	third := <-keys
+	<-keys
+	assert.NotEqual(t, third, first)
+
+	// Sending the same Request again is a new call, so it gets a new key.
+	request := connect.NewRequest(&pingv1.PingRequest{})
+	for i := 0; i < 2; i++ {
+		_, err = client.Ping(context.Background(), request)
+		assert.Nil(t, err)
+		assert.Equal(t, request.Header().Get("Idempotency-Key"), "")
+	}
+	fourth, _, fifth, _ := <-keys, <-keys, <-keys, <-keys
+	assert.NotEqual(t, fourth, fifth)
+
+	request = connect.NewRequest(&pingv1.PingRequest{})
+	request.Header().Set("Idempotency-Key", "caller-supplied")
+	_, err = client.Ping(context.Background(), request)
+	assert.Nil(t, err)
+	assert.Equal(t, <-keys, "caller-supplied")
+}
+
+func TestUserAgent(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(&pluggablePingServer{
+		ping: func(_ context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+			return connect.NewResponse(&pingv1.PingResponse{Text: request.Header().Get("User-Agent")}), nil
+		},
+	}))
+	server := httptest.NewUnstartedServer(mux)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	for _, testCase := range []struct {
+		protocol string
+		prefix   string
+	}{
+		{connect.ProtocolConnect, "connect-go/" + connect.Version + " ("},
+		{connect.ProtocolGRPC, "grpc-go-connect/" + connect.Version + " ("},
+		{connect.ProtocolGRPCWeb, "grpc-go-connect/" + connect.Version + " ("},
+	} {
+		testCase := testCase
+		t.Run(testCase.protocol, func(t *testing.T) {
+			t.Parallel()
+			client := pingv1connect.NewPingServiceClient(
+				server.Client(),
+				server.URL,
+				connect.WithProtocol(testCase.protocol),
+				connect.WithUserAgent("billing-service/1.2.3"),
+			)
+			response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+			assert.Nil(t, err)
+			assert.True(t, strings.HasPrefix(response.Msg.Text, testCase.prefix))
+			assert.True(t, strings.HasSuffix(response.Msg.Text, ") billing-service/1.2.3"))
+		})
+	}
+}
+
+func TestRoundTripMiddleware(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(&pluggablePingServer{
+		ping: func(_ context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+			text := request.Header().Get("X-Injected") + " " + request.Header().Get("X-Body-Size")
+			return connect.NewResponse(&pingv1.PingResponse{Text: text}), nil
+		},
+	}))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	var order []string
+	record := func(name string) connect.RoundTripMiddleware {
+		return func(next connect.HTTPClient) connect.HTTPClient {
+			return connect.HTTPClientFunc(func(request *http.Request) (*http.Response, error) {
+				order = append(order, name)
+				return next.Do(request)
+			})
+		}
+	}
+	// Like a request signer, read the whole unary body before sending it.
+	measure := func(next connect.HTTPClient) connect.HTTPClient {
+		return connect.HTTPClientFunc(func(request *http.Request) (*http.Response, error) {
+			body, err := io.ReadAll(request.Body)
+			if err != nil {
+				return nil, err
+			}
+			request.Body = io.NopCloser(bytes.NewReader(body))
+			request.Header.Set("X-Body-Size", strconv.Itoa(len(body)))
+			return next.Do(request)
+		})
+	}
+	client := pingv1connect.NewPingServiceClient(
+		server.Client(),
+		server.URL,
+		connect.WithRoundTripMiddleware(record("first"), record("second")),
+		connect.WithRoundTripMiddleware(
+			connect.HeaderMiddleware(http.Header{"X-Injected": []string{"injected"}}),
+			measure,
+		),
+	)
+	response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Text: "hello"}))
+	assert.Nil(t, err)
+	assert.Equal(t, response.Msg.Text, "injected 7")
+	assert.Equal(t, order, []string{"first", "second"})
+}
+
+func TestPreflight(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}))
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	server := httptest.NewUnstartedServer(mux)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	ctx := context.Background()
+	pingURL := server.URL + "/" + pingv1connect.PingServiceName + "/Ping"
+
+	client := connect.NewClient[pingv1.PingRequest, pingv1.PingResponse](server.Client(), pingURL)
+	assert.Nil(t, client.Preflight(ctx))
+	assert.Equal(t, connect.CodeOf(connect.Preflight(ctx, server.Client(), server.URL+"/missing")), connect.CodeNotFound)
+	assert.Equal(t, connect.CodeOf(connect.Preflight(ctx, server.Client(), server.URL+"/broken")), connect.CodeUnavailable)
+	// The default client doesn't trust the test server's certificate.
+	err := connect.Preflight(ctx, http.DefaultClient, pingURL)
+	assert.Equal(t, connect.CodeOf(err), connect.CodeUnavailable)
+}
+
+func TestServiceConfig(t *testing.T) {
+	t.Parallel()
+	_, err := connect.ParseServiceConfig([]byte(`{"methodConfig": [{"timeout": "soon"}]}`))
+	assert.NotNil(t, err)
+	config, err := connect.ParseServiceConfig([]byte(`{
+		"methodConfig": [{
+			"name": [{"service": "connect.ping.v1.PingService"}],
+			"timeout": "30s"
+		}, {
+			"name": [{"service": "connect.ping.v1.PingService", "method": "Ping"}],
+			"timeout": "10s",
+			"maxRequestMessageBytes": 64,
+			"maxResponseMessageBytes": 64,
+			"retryPolicy": {
+				"maxAttempts": 3,
+				"initialBackoff": "0.001s",
+				"maxBackoff": "0.001s",
+				"backoffMultiplier": 2,
+				"retryableStatusCodes": ["UNAVAILABLE", 10]
+			}
+		}],
+		"retryThrottling": {"maxTokens": 10, "tokenRatio": 0.1}
+	}`))
+	assert.Nil(t, err)
+	assert.Equal(t, len(config.MethodConfigs), 2)
+	assert.NotNil(t, config.RetryThrottle)
+	policy := config.MethodConfigs[1].RetryPolicy
+	assert.NotNil(t, policy)
+	assert.Equal(t, policy.RetryableCodes, []connect.Code{connect.CodeUnavailable, connect.CodeAborted})
+
+	var attempts int
+	var mu sync.Mutex
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(&pluggablePingServer{
+		ping: func(ctx context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+			mu.Lock()
+			attempts++
+			attempt := attempts
+			mu.Unlock()
+			deadline, ok := ctx.Deadline()
+			if !ok || time.Until(deadline) > 10*time.Second {
+				return nil, connect.NewError(connect.CodeInternal, errors.New("missing method timeout"))
+			}
+			switch request.Msg.Text {
+			case "flaky":
+				if attempt == 1 {
+					return nil, connect.NewError(connect.CodeUnavailable, errors.New("try again"))
+				}
+			case "big":
+				return connect.NewResponse(&pingv1.PingResponse{Text: strings.Repeat("a", 128)}), nil
+			case "compressible":
+				// Compresses to well under the limit.
+				return connect.NewResponse(&pingv1.PingResponse{Text: strings.Repeat("a", 4096)}), nil
+			}
+			return connect.NewResponse(&pingv1.PingResponse{Number: int64(attempt)}), nil
+		},
+	}))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	client := pingv1connect.NewPingServiceClient(
+		server.Client(),
+		server.URL,
+		connect.WithServiceConfig(config),
+		connect.WithIdempotency(connect.IdempotencyNoSideEffects),
+	)
+	ping := func(text string) (*connect.Response[pingv1.PingResponse], error) {
+		mu.Lock()
+		attempts = 0
+		mu.Unlock()
+		return client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Text: text}))
+	}
+	response, err := ping("flaky")
+	assert.Nil(t, err)
+	assert.Equal(t, response.Msg.Number, 2)
+	_, err = ping(strings.Repeat("a", 128))
+	assert.Equal(t, connect.CodeOf(err), connect.CodeResourceExhausted)
+	_, err = ping("big")
+	assert.Equal(t, connect.CodeOf(err), connect.CodeResourceExhausted)
+	_, err = ping("compressible")
+	assert.Equal(t, connect.CodeOf(err), connect.CodeResourceExhausted)
+}
+
+func TestDefaultTimeout(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(&pluggablePingServer{
+		ping: func(ctx context.Context, _ *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+			var remaining time.Duration
+			if deadline, ok := ctx.Deadline(); ok {
+				remaining = time.Until(deadline)
+			}
+			return connect.NewResponse(&pingv1.PingResponse{Number: int64(remaining)}), nil
+		},
+	}))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	client := pingv1connect.NewPingServiceClient(
+		server.Client(),
+		server.URL,
+		connect.WithDefaultTimeout(time.Minute),
+	)
+	response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+	assert.Nil(t, err)
+	remaining := time.Duration(response.Msg.Number)
+	assert.True(t, remaining > 0 && remaining <= time.Minute, assert.Sprintf("remaining %v", remaining))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	response, err = client.Ping(ctx, connect.NewRequest(&pingv1.PingRequest{}))
+	assert.Nil(t, err)
+	remaining = time.Duration(response.Msg.Number)
+	assert.True(t, remaining > time.Minute, assert.Sprintf("remaining %v", remaining))
+}
+
+func TestValidation(t *testing.T) {
+	t.Parallel()
+	var calls int32
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{
+			ping: func(_ context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				atomic.AddInt32(&calls, 1)
+				return connect.NewResponse(&pingv1.PingResponse{Number: request.Msg.Number}), nil
+			},
+		},
+		connect.WithValidator(positiveValidator{}),
+		connect.WithValidation(),
+	))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	invalid := connect.NewRequest(&pingv1.PingRequest{Number: -1})
+
+	t.Run("client", func(t *testing.T) {
+		client := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL,
+			connect.WithValidator(positiveValidator{}),
+			connect.WithValidation(),
+		)
+		_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 1}))
+		assert.Nil(t, err)
+		before := atomic.LoadInt32(&calls)
+		_, err = client.Ping(context.Background(), invalid)
+		assert.Equal(t, connect.CodeOf(err), connect.CodeInvalidArgument)
+		assert.Equal(t, atomic.LoadInt32(&calls), before)
+		stream := client.CumSum(context.Background())
+		err = stream.Send(&pingv1.CumSumRequest{Number: -1})
+		assert.Equal(t, connect.CodeOf(err), connect.CodeInvalidArgument)
+		_ = stream.CloseSend()
+		_ = stream.CloseReceive()
+	})
+	t.Run("handler", func(t *testing.T) {
+		// Without WithValidation, the client's validator is unused.
+		client := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL,
+			connect.WithValidator(positiveValidator{}),
+		)
+		before := atomic.LoadInt32(&calls)
+		_, err := client.Ping(context.Background(), invalid)
+		assert.Equal(t, connect.CodeOf(err), connect.CodeInvalidArgument)
+		assert.Equal(t, atomic.LoadInt32(&calls), before)
+		var connectErr *connect.Error
+		assert.True(t, errors.As(err, &connectErr))
+		details := connectErr.Details()
+		assert.Equal(t, len(details), 1)
+		var violation wrapperspb.StringValue
+		assert.Nil(t, details[0].UnmarshalTo(&violation))
+		assert.Equal(t, violation.Value, "number must be positive")
+	})
+}
+
+// positiveValidator rejects messages with negative numbers, mimicking
+// protovalidate's errors.
+type positiveValidator struct{}
+
+func (positiveValidator) Validate(msg proto.Message) error {
+	var number int64
+	switch typed := msg.(type) {
+	case *pingv1.PingRequest:
+		number = typed.Number
+	case *pingv1.CumSumRequest:
+		number = typed.Number
+	case *pingv1.SumRequest:
+		number = typed.Number
+	}
+	if number < 0 {
+		return &violationsError{violation: "number must be positive"}
+	}
+	return nil
+}
+
+type violationsError struct {
+	violation string
+}
+
+func (e *violationsError) Error() string {
+	return "validation error: " + e.violation
+}
+
+func (e *violationsError) ToProto() *wrapperspb.StringValue {
+	return wrapperspb.String(e.violation)
+}
+
+func TestCloseReceiveUnblocksReceive(t *testing.T) {
+	t.Parallel()
+	procedure := "/" + pingv1connect.PingServiceName + "/CumSum"
+	handlerDone := make(chan error, 16)
+	mux := http.NewServeMux()
+	mux.Handle(procedure, connect.NewBidiStreamHandler(
+		procedure,
+		func(ctx context.Context, stream *connect.BidiStream[pingv1.CumSumRequest, pingv1.CumSumResponse]) error {
+			// Send headers, then go quiet until the client gives up.
+			if err := stream.Send(&pingv1.CumSumResponse{}); err != nil {
+				return err
+			}
+			<-ctx.Done()
+			handlerDone <- ctx.Err()
+			return ctx.Err()
+		},
+	))
+	server := httptest.NewUnstartedServer(mux)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	// receiveUntilError calls Receive on another goroutine and waits for it to
+	// return an error after unblock is called.
+	receiveUntilError := func(
+		t *testing.T,
+		stream *connect.BidiStreamForClient[pingv1.CumSumRequest, pingv1.CumSumResponse],
+		unblock func(),
+	) error {
+		t.Helper()
+		_, err := stream.Receive()
+		assert.Nil(t, err)
+		received := make(chan error, 1)
+		go func() {
+			_, err := stream.Receive()
+			received <- err
+		}()
+		unblock()
+		select {
+		case err := <-received:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("Receive still blocked")
+			return nil
+		}
+	}
+	for _, protocol := range []string{connect.ProtocolConnect, connect.ProtocolGRPC, connect.ProtocolGRPCWeb} {
+		protocol := protocol
+		client := connect.NewClient[pingv1.CumSumRequest, pingv1.CumSumResponse](
+			server.Client(),
+			server.URL+procedure,
+			connect.WithProtocol(protocol),
+		)
+		t.Run(protocol+"/close_receive", func(t *testing.T) {
+			stream := client.CallBidiStream(context.Background())
+			assert.Nil(t, stream.Send(&pingv1.CumSumRequest{}))
+			err := receiveUntilError(t, stream, func() {
+				assert.Nil(t, stream.CloseReceive())
+			})
+			assert.Equal(t, connect.CodeOf(err), connect.CodeCanceled)
+			assert.Equal(t, connect.StreamEndReasonOf(err), connect.StreamEndLocalClose)
+			_, err = stream.Receive()
+			assert.Equal(t, connect.CodeOf(err), connect.CodeCanceled)
+			_ = stream.CloseSend()
+			assert.NotNil(t, <-handlerDone)
+		})
+		t.Run(protocol+"/cancel", func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			stream := client.CallBidiStream(ctx)
+			assert.Nil(t, stream.Send(&pingv1.CumSumRequest{}))
+			err := receiveUntilError(t, stream, cancel)
+			assert.Equal(t, connect.CodeOf(err), connect.CodeCanceled)
+			assert.Equal(t, connect.StreamEndReasonOf(err), connect.StreamEndCanceled)
+			_ = stream.CloseSend()
+			_ = stream.CloseReceive()
+			assert.NotNil(t, <-handlerDone)
+		})
+	}
+}
+
+func TestConnPool(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}))
+	server := httptest.NewUnstartedServer(mux)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	serverTransport, ok := server.Client().Transport.(*http.Transport)
+	assert.True(t, ok)
+	clock := newFakeClock(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	pool := connect.NewConnPool(connect.ConnPoolConfig{
+		MaxIdleConnsPerHost: 1,
+		IdleConnTimeout:     time.Minute,
+		TLSClientConfig:     serverTransport.TLSClientConfig,
+		Clock:               clock,
+	})
+	t.Cleanup(pool.CloseIdleConnections)
+	assert.Equal(t, pool.Stats().OpenConns, 0)
+	target := strings.TrimPrefix(server.URL, "https://")
+	assert.Equal(t, pool.State(target), connect.ConnectivityIdle)
+	var (
+		transitionsMu sync.Mutex
+		transitions   []connect.ConnectivityState
+	)
+	unregister := pool.OnStateChange(func(changed string, state connect.ConnectivityState) {
+		assert.Equal(t, changed, target)
+		transitionsMu.Lock()
+		transitions = append(transitions, state)
+		transitionsMu.Unlock()
+	})
+	client := pingv1connect.NewPingServiceClient(pool, server.URL, connect.WithGRPC())
+	for i := 0; i < 3; i++ {
+		_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, pool.State(target), connect.ConnectivityReady)
+	transitionsMu.Lock()
+	assert.Equal(t, transitions, []connect.ConnectivityState{
+		connect.ConnectivityConnecting,
+		connect.ConnectivityReady,
+	})
+	transitionsMu.Unlock()
+	unregister()
+	stats := pool.Stats()
+	assert.Equal(t, stats.OpenConns, 1) // HTTP/2 reuses the connection
+	assert.Equal(t, stats.ActiveStreams, 0)
+
+	stream := client.CumSum(context.Background())
+	assert.Nil(t, stream.Send(&pingv1.CumSumRequest{Number: 1}))
+	_, err := stream.Receive()
+	assert.Nil(t, err)
+	stats = pool.Stats()
+	assert.Equal(t, stats.OpenConns, 1)
+	assert.Equal(t, stats.ActiveStreams, 1)
+	assert.Equal(t, len(stats.Conns), 1)
+	assert.Equal(t, stats.Conns[0].ActiveStreams, 1)
+	assert.Equal(t, stats.Conns[0].Opened, clock.Now())
+	assert.Nil(t, stream.CloseSend())
+	_, err = stream.Receive()
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Nil(t, stream.CloseReceive())
+	assert.Equal(t, pool.Stats().ActiveStreams, 0)
+
+	pool.CloseIdleConnections()
+	assert.Equal(t, pool.Stats().OpenConns, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.True(t, pool.WaitForStateChange(ctx, target, connect.ConnectivityReady))
+	assert.Equal(t, pool.State(target), connect.ConnectivityIdle)
+
+	// Nothing listens on a closed server's address.
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	closedClient := pingv1connect.NewPingServiceClient(pool, closed.URL)
+	_, err = closedClient.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+	assert.Equal(t, connect.CodeOf(err), connect.CodeUnavailable)
+	closedTarget := strings.TrimPrefix(closed.URL, "http://")
+	assert.Equal(t, pool.State(closedTarget), connect.ConnectivityTransientFailure)
+	assert.Equal(t, pool.State(closedTarget).String(), "transient_failure")
+}
+
+func TestHTTPStatus(t *testing.T) {
+	t.Parallel()
+	// Emulate a load balancer with no healthy backends.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+	for _, protocol := range []string{connect.ProtocolConnect, connect.ProtocolGRPC, connect.ProtocolGRPCWeb} {
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL, connect.WithProtocol(protocol))
+		_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Equal(t, connect.CodeOf(err), connect.CodeUnavailable, assert.Sprintf("%s: %v", protocol, err))
+		assert.Equal(t, connect.HTTPStatus(err), http.StatusServiceUnavailable, assert.Sprintf(protocol))
+
+		stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 1}))
+		assert.Nil(t, err)
+		assert.False(t, stream.Receive())
+		assert.Equal(t, connect.CodeOf(stream.Err()), connect.CodeUnavailable, assert.Sprintf("%s: %v", protocol, stream.Err()))
+		assert.Equal(t, connect.HTTPStatus(stream.Err()), http.StatusServiceUnavailable, assert.Sprintf(protocol))
+		assert.Nil(t, stream.Close())
+	}
+
+	// Errors returned by handlers don't have an HTTP status, even if the
+	// protocol encodes them in the status.
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}))
+	handlerServer := httptest.NewServer(mux)
+	t.Cleanup(handlerServer.Close)
+	failRequest := connect.NewRequest(&pingv1.FailRequest{Code: int32(connect.CodeResourceExhausted)})
+	grpcClient := pingv1connect.NewPingServiceClient(handlerServer.Client(), handlerServer.URL, connect.WithGRPCWeb())
+	_, err := grpcClient.Fail(context.Background(), failRequest)
+	assert.Equal(t, connect.CodeOf(err), connect.CodeResourceExhausted)
+	assert.Equal(t, connect.HTTPStatus(err), 0)
+	connectClient := pingv1connect.NewPingServiceClient(handlerServer.Client(), handlerServer.URL)
+	_, err = connectClient.Fail(context.Background(), failRequest)
+	assert.Equal(t, connect.CodeOf(err), connect.CodeResourceExhausted)
+	assert.Equal(t, connect.HTTPStatus(err), 0)
+	assert.Equal(t, connect.HTTPStatus(errors.New("plain error")), 0)
+}
+
+func TestCallStats(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}))
+	server := httptest.NewUnstartedServer(mux)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	for _, protocol := range []string{connect.ProtocolConnect, connect.ProtocolGRPC, connect.ProtocolGRPCWeb} {
+		protocol := protocol
+		t.Run(protocol, func(t *testing.T) {
+			t.Parallel()
+			httpClient := &http.Client{Transport: server.Client().Transport.(*http.Transport).Clone()}
+			client := pingv1connect.NewPingServiceClient(
+				httpClient,
+				server.URL,
+				connect.WithProtocol(protocol),
+				connect.WithCallStats(),
+			)
+			response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 42}))
+			assert.Nil(t, err)
+			stats := response.CallStats()
+			assert.NotNil(t, stats)
+			assert.True(t, stats.Total > 0)
+			assert.True(t, stats.TLS > 0)
+			assert.False(t, stats.ReusedConnection)
+			assert.True(t, stats.TimeToFirstByte > 0)
+
+			response, err = client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 42}))
+			assert.Nil(t, err)
+			assert.NotNil(t, response.CallStats())
+			assert.True(t, response.CallStats().ReusedConnection)
+			assert.Equal(t, response.CallStats().TLS, 0)
+
+			_, err = client.Fail(context.Background(), connect.NewRequest(&pingv1.FailRequest{Code: int32(connect.CodeResourceExhausted)}))
+			assert.Equal(t, connect.CodeOf(err), connect.CodeResourceExhausted)
+			var connectErr *connect.Error
+			assert.True(t, errors.As(err, &connectErr))
+			assert.NotNil(t, connectErr.CallStats())
+			assert.True(t, connectErr.CallStats().Total > 0)
+		})
+	}
+
+	t.Run("shared_error", func(t *testing.T) {
+		t.Parallel()
+		// Stats go in a new value, so errors returned to several calls don't
+		// share them.
+		sentinel := connect.NewError(connect.CodeUnavailable, errors.New("overloaded"))
+		clock := newFakeClock(time.Unix(0, 0))
+		client := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL,
+			connect.WithCallStats(),
+			connect.WithClock(clock),
+			connect.WithInterceptors(connect.UnaryInterceptorFunc(func(connect.UnaryFunc) connect.UnaryFunc {
+				return func(context.Context, connect.AnyRequest) (connect.AnyResponse, error) {
+					clock.Advance(time.Second)
+					return nil, fmt.Errorf("shed: %w", sentinel)
+				}
+			})),
+		)
+		for i := 1; i <= 2; i++ {
+			_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+			assert.ErrorIs(t, err, sentinel)
+			assert.Equal(t, err.Error(), "shed: unavailable: overloaded")
+			var connectErr *connect.Error
+			assert.True(t, errors.As(err, &connectErr))
+			assert.Equal(t, connectErr.Code(), connect.CodeUnavailable)
+			assert.NotNil(t, connectErr.CallStats())
+			assert.Equal(t, connectErr.CallStats().Total, time.Second)
+		}
+		assert.Nil(t, sentinel.CallStats())
+	})
+
+	// Stats are only recorded when requested.
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL)
+	response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+	assert.Nil(t, err)
+	assert.Nil(t, response.CallStats())
+}
+
+func TestTrafficSplitter(t *testing.T) {
+	t.Parallel()
+	newBackend := func(number int64) string {
+		handler := connect.NewUnaryHandler(
+			"/"+pingv1connect.PingServiceName+"/Ping",
+			func(context.Context, *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				return connect.NewResponse(&pingv1.PingResponse{Number: number}), nil
+			},
+		)
+		server := httptest.NewServer(handler)
+		t.Cleanup(server.Close)
+		return server.URL
+	}
+	splitter, err := connect.NewTrafficSplitter(connect.TrafficSplitterConfig{
+		Groups: []connect.TargetGroup{
+			{Name: "stable", Targets: []string{newBackend(1), newBackend(1)}, Weight: 100},
+			{Name: "canary", Targets: []string{newBackend(2)}, Weight: 0},
+		},
+		StickyHeader: "User-Id",
+	})
+	assert.Nil(t, err)
+	client := pingv1connect.NewPingServiceClient(splitter, "http://unused.invalid")
+	ping := func(user string) int64 {
+		request := connect.NewRequest(&pingv1.PingRequest{})
+		if user != "" {
+			request.Header().Set("User-Id", user)
+		}
+		response, err := client.Ping(context.Background(), request)
+		assert.Nil(t, err)
+		if err != nil {
+			return 0
+		}
+		return response.Msg.Number
+	}
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, ping(""), 1)
+	}
+
+	assert.Nil(t, splitter.SetWeights(map[string]int{"stable": 0, "canary": 100}))
+	assert.Equal(t, splitter.Weights(), map[string]int{"stable": 0, "canary": 100})
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, ping(""), 2)
+	}
+
+	// Sticky requests always go to the same group.
+	assert.Nil(t, splitter.SetWeights(map[string]int{"stable": 50, "canary": 50}))
+	seen := make(map[int64]bool)
+	for user := 0; user < 20; user++ {
+		first := ping(strconv.Itoa(user))
+		seen[first] = true
+		for i := 0; i < 5; i++ {
+			assert.Equal(t, ping(strconv.Itoa(user)), first, assert.Sprintf("user %d", user))
+		}
+	}
+	assert.Equal(t, len(seen), 2)
+
+	assert.NotNil(t, splitter.SetWeights(map[string]int{"stable": 0, "canary": 0}))
+	assert.NotNil(t, splitter.SetWeights(map[string]int{"stable": -1}))
+	assert.NotNil(t, splitter.SetWeights(map[string]int{"unknown": 1}))
+	assert.Equal(t, splitter.Weights(), map[string]int{"stable": 50, "canary": 50})
+
+	_, err = connect.NewTrafficSplitter(connect.TrafficSplitterConfig{})
+	assert.NotNil(t, err)
+	_, err = connect.NewTrafficSplitter(connect.TrafficSplitterConfig{
+		Groups: []connect.TargetGroup{{Name: "empty", Weight: 1}},
+	})
+	assert.NotNil(t, err)
+	_, err = connect.NewTrafficSplitter(connect.TrafficSplitterConfig{
+		Groups: []connect.TargetGroup{{Name: "relative", Targets: []string{"/no/host"}, Weight: 1}},
+	})
+	assert.NotNil(t, err)
+}
+
+func TestLeastLoadedBalancer(t *testing.T) {
+	t.Parallel()
+	newBackend := func(number int64, load float64) string {
+		report := &connect.LoadReport{
+			CPUUtilization: load,
+			RequestCost:    map[string]float64{"db_queries": 2},
+		}
+		mux := http.NewServeMux()
+		mux.Handle(pingv1connect.NewPingServiceHandler(&pluggablePingServer{
+			ping: func(ctx context.Context, _ *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				if err := connect.SetLoadReport(ctx, report); err != nil {
+					return nil, err
+				}
+				return connect.NewResponse(&pingv1.PingResponse{Number: number}), nil
+			},
+		}))
+		countUp := "/" + pingv1connect.PingServiceName + "/CountUp"
+		mux.Handle(countUp, connect.NewServerStreamHandler(
+			countUp,
+			func(ctx context.Context, _ *connect.Request[pingv1.CountUpRequest], stream *connect.ServerStream[pingv1.CountUpResponse]) error {
+				if err := connect.SetLoadReport(ctx, report); err != nil {
+					return err
+				}
+				return stream.Send(&pingv1.CountUpResponse{Number: number})
+			},
+		))
+		server := httptest.NewServer(mux)
+		t.Cleanup(server.Close)
+		return server.URL
+	}
+	busy, idle := newBackend(1, 0.9), newBackend(2, 0.1)
+
+	for _, protocol := range []string{connect.ProtocolConnect, connect.ProtocolGRPCWeb} {
+		protocol := protocol
+		t.Run(protocol, func(t *testing.T) {
+			t.Parallel()
+			balancer, err := connect.NewLeastLoadedBalancer(connect.LeastLoadedBalancerConfig{
+				Targets: []string{busy, idle},
+			})
+			assert.Nil(t, err)
+			client := pingv1connect.NewPingServiceClient(
+				balancer,
+				"http://unused.invalid",
+				connect.WithProtocol(protocol),
+				connect.WithInterceptors(balancer),
+			)
+			// Until both backends have reported, calls may go to either.
+			var response *connect.Response[pingv1.PingResponse]
+			for i := 0; i < 100; i++ {
+				response, err = client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+				assert.Nil(t, err)
+				if loads := balancer.Loads(); loads[busy] != 0 && loads[idle] != 0 {
+					break
+				}
+			}
+			assert.Equal(t, balancer.Loads(), map[string]float64{busy: 0.9, idle: 0.1})
+			report, ok := connect.LoadReportFromTrailer(response.Trailer())
+			assert.True(t, ok)
+			assert.Equal(t, report.RequestCost, map[string]float64{"db_queries": 2})
+			for i := 0; i < 10; i++ {
+				response, err = client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+				assert.Nil(t, err)
+				assert.Equal(t, response.Msg.Number, 2)
+			}
+
+			stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{}))
+			assert.Nil(t, err)
+			assert.True(t, stream.Receive())
+			assert.Equal(t, stream.Msg().Number, 2)
+			assert.False(t, stream.Receive())
+			assert.Nil(t, stream.Err())
+			report, ok = connect.LoadReportFromTrailer(stream.ResponseTrailer())
+			assert.True(t, ok)
+			assert.Equal(t, report.CPUUtilization, 0.1)
+			assert.Nil(t, stream.Close())
+		})
+	}
+
+	_, err := connect.NewLeastLoadedBalancer(connect.LeastLoadedBalancerConfig{})
+	assert.NotNil(t, err)
+	_, ok := connect.LoadReportFromTrailer(http.Header{connect.HeaderEndpointLoadMetrics: []string{"!!"}})
+	assert.False(t, ok)
+	assert.NotNil(t, connect.SetLoadReport(context.Background(), &connect.LoadReport{}))
+}
+
+func TestFailoverClient(t *testing.T) {
+	t.Parallel()
+	newBackend := func(number int64, down, probes *int32) string {
+		mux := http.NewServeMux()
+		mux.Handle(pingv1connect.NewPingServiceHandler(&pluggablePingServer{
+			ping: func(context.Context, *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				return connect.NewResponse(&pingv1.PingResponse{Number: number}), nil
+			},
+		}))
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead {
+				atomic.AddInt32(probes, 1)
+			}
+			if atomic.LoadInt32(down) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			mux.ServeHTTP(w, r)
+		}))
+		t.Cleanup(server.Close)
+		return server.URL
+	}
+	var primaryDown, secondaryDown, primaryProbes, secondaryProbes int32
+	primary := newBackend(1, &primaryDown, &primaryProbes)
+	secondary := newBackend(2, &secondaryDown, &secondaryProbes)
+	clock := newFakeClock(time.Now())
+	failover, err := connect.NewFailoverClient(connect.FailoverClientConfig{
+		Targets:          []string{primary, secondary},
+		FailureThreshold: 2,
+		ProbeInterval:    time.Second,
+		Clock:            clock,
+	})
+	assert.Nil(t, err)
+	defer failover.Close()
+	client := pingv1connect.NewPingServiceClient(failover, "http://unused.invalid")
+	ping := func() (int64, error) {
+		response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		if err != nil {
+			return 0, err
+		}
+		return response.Msg.Number, nil
+	}
+	// Probes are rescheduled asynchronously, so keep advancing the clock until
+	// one succeeds.
+	probeUntilActive := func(target string) {
+		t.Helper()
+		for i := 0; i < 100 && failover.Active() != target; i++ {
+			clock.Advance(time.Second)
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Equal(t, failover.Active(), target)
+	}
+
+	number, err := ping()
+	assert.Nil(t, err)
+	assert.Equal(t, number, 1)
+
+	// Once the primary has failed enough calls, traffic fails over.
+	atomic.StoreInt32(&primaryDown, 1)
+	for i := 0; i < 2; i++ {
+		_, err = ping()
+		assert.Equal(t, connect.CodeOf(err), connect.CodeUnavailable)
+	}
+	assert.Equal(t, failover.Active(), secondary)
+	assert.Equal(t, failover.Healthy(), map[string]bool{primary: false, secondary: true})
+	number, err = ping()
+	assert.Nil(t, err)
+	assert.Equal(t, number, 2)
+
+	// Failed probes leave the primary unhealthy.
+	clock.Advance(time.Second)
+	for i := 0; i < 100 && atomic.LoadInt32(&primaryProbes) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, atomic.LoadInt32(&primaryProbes), 1)
+	assert.Equal(t, failover.Active(), secondary)
+
+	// Once a probe succeeds, traffic fails back.
+	atomic.StoreInt32(&primaryDown, 0)
+	probeUntilActive(primary)
+	number, err = ping()
+	assert.Nil(t, err)
+	assert.Equal(t, number, 1)
+
+	// If every target is down, calls go to the primary.
+	atomic.StoreInt32(&primaryDown, 1)
+	atomic.StoreInt32(&secondaryDown, 1)
+	for i := 0; i < 4; i++ {
+		_, err = ping()
+		assert.NotNil(t, err)
+	}
+	assert.Equal(t, failover.Healthy(), map[string]bool{primary: false, secondary: false})
+	assert.Equal(t, failover.Active(), primary)
+	atomic.StoreInt32(&secondaryDown, 0)
+	probeUntilActive(secondary)
+
+	_, err = connect.NewFailoverClient(connect.FailoverClientConfig{})
+	assert.NotNil(t, err)
+	_, err = connect.NewFailoverClient(connect.FailoverClientConfig{Targets: []string{"localhost"}})
+	assert.NotNil(t, err)
+}
+
+func TestReconnectingBidiStream(t *testing.T) {
+	t.Parallel()
+	var connections int64
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(&flakyCumSumServer{connections: &connections}))
+	server := httptest.NewUnstartedServer(mux)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL)
+
+	stream := connect.NewReconnectingBidiStream(
+		context.Background(),
+		client.CumSum,
+		func(stream *connect.BidiStreamForClient[pingv1.CumSumRequest, pingv1.CumSumResponse]) error {
+			return stream.Send(&pingv1.CumSumRequest{Number: 1})
+		},
+		connect.RetryPolicy{InitialBackoff: time.Millisecond},
+	)
+	receive := func() int64 {
+		response, err := stream.Receive()
+		assert.Nil(t, err)
+		if err != nil {
+			return 0
+		}
+		return response.Sum
+	}
+	assert.Equal(t, receive(), 101)
+	assert.Nil(t, stream.Send(&pingv1.CumSumRequest{Number: 2}))
+	assert.Equal(t, receive(), 102)
+	// The first connection fails after its second message, so the stream
+	// reconnects and resubscribes.
+	assert.Equal(t, receive(), 201)
+	assert.Equal(t, stream.Reconnects(), 1)
+	assert.Nil(t, stream.Send(&pingv1.CumSumRequest{Number: 3}))
+	assert.Equal(t, receive(), 203)
+
+	// Non-retryable errors end the stream.
+	assert.Nil(t, stream.Send(&pingv1.CumSumRequest{Number: -1}))
+	_, err := stream.Receive()
+	assert.Equal(t, connect.CodeOf(err), connect.CodeInvalidArgument)
+	assert.Equal(t, stream.Reconnects(), 1)
+	assert.Nil(t, stream.Close())
+	_, err = stream.Receive()
+	assert.NotNil(t, err)
+}
+
+func TestReconnectingBidiStreamThrottle(t *testing.T) {
+	t.Parallel()
+	// Each connection sends three messages and then fails.
+	var connections int64
+	procedure := "/" + pingv1connect.PingServiceName + "/CumSum"
+	mux := http.NewServeMux()
+	mux.Handle(procedure, connect.NewBidiStreamHandler(
+		procedure,
+		func(_ context.Context, stream *connect.BidiStream[pingv1.CumSumRequest, pingv1.CumSumResponse]) error {
+			connection := atomic.AddInt64(&connections, 1)
+			for i := int64(1); i <= 3; i++ {
+				if err := stream.Send(&pingv1.CumSumResponse{Sum: 100*connection + i}); err != nil {
+					return err
+				}
+			}
+			return connect.NewError(connect.CodeUnavailable, errors.New("connection lost"))
+		},
+	))
+	server := httptest.NewUnstartedServer(mux)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL)
+
+	clock := newFakeClock(time.Now())
+	stream := connect.NewReconnectingBidiStream(
+		context.Background(),
+		client.CumSum,
+		func(stream *connect.BidiStreamForClient[pingv1.CumSumRequest, pingv1.CumSumResponse]) error {
+			// Bidi streams don't start until the client sends something.
+			return stream.Send(&pingv1.CumSumRequest{})
+		},
+		connect.RetryPolicy{
+			InitialBackoff: time.Hour,
+			Clock:          clock,
+			// Each failed stream costs a token and each stream that delivers
+			// messages earns back half of one, so the third failure leaves
+			// too few tokens to reconnect.
+			Throttle: connect.NewRetryThrottle(4, 0.5),
+		},
+	)
+	t.Cleanup(func() { _ = stream.Close() })
+	var sums []int64
+	var err error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			var response *pingv1.CumSumResponse
+			response, err = stream.Receive()
+			if err != nil {
+				return
+			}
+			sums = append(sums, response.Sum)
+		}
+	}()
+	// Backoff starts asynchronously, so keep advancing the clock until the
+	// throttle gives up.
+	for i := 0; i < 100; i++ {
+		select {
+		case <-done:
+		default:
+			clock.Advance(time.Hour)
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		break
+	}
+	select {
+	case <-done:
+	default:
+		t.Fatal("stream wasn't throttled")
+	}
+	assert.Equal(t, connect.CodeOf(err), connect.CodeUnavailable)
+	assert.Equal(t, sums, []int64{101, 102, 103, 201, 202, 203, 301, 302, 303})
+	assert.Equal(t, stream.Reconnects(), 2)
+}
+
+func TestStreamEndReason(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}))
+	server := httptest.NewUnstartedServer(mux)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	// The truncated server promises a ten-byte message but ends the stream
+	// after two bytes.
+	truncated := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/connect+proto")
+		_, _ = w.Write([]byte{0, 0, 0, 0, 10, 1, 2})
+	}))
+	t.Cleanup(truncated.Close)
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	for _, protocol := range []string{connect.ProtocolConnect, connect.ProtocolGRPC, connect.ProtocolGRPCWeb} {
+		protocol := protocol
+		t.Run(protocol, func(t *testing.T) {
+			t.Parallel()
+			client := pingv1connect.NewPingServiceClient(server.Client(), server.URL, connect.WithProtocol(protocol))
+			stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 2}))
+			assert.Nil(t, err)
+			for stream.Receive() {
+			}
+			assert.Equal(t, connect.StreamEndReasonOf(stream.Err()), connect.StreamEndPeerClosed)
+			assert.Nil(t, stream.Close())
+
+			_, err = client.Fail(
+				context.Background(),
+				connect.NewRequest(&pingv1.FailRequest{Code: int32(connect.CodeUnavailable)}),
+			)
+			assert.Equal(t, connect.StreamEndReasonOf(err), connect.StreamEndPeerError)
+			stream, err = client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{}))
+			assert.Nil(t, err)
+			assert.False(t, stream.Receive())
+			assert.Equal(t, connect.CodeOf(stream.Err()), connect.CodeInvalidArgument)
+			assert.Equal(t, connect.StreamEndReasonOf(stream.Err()), connect.StreamEndPeerError)
+			assert.Nil(t, stream.Close())
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+			defer cancel()
+			<-ctx.Done()
+			_, err = client.Ping(ctx, connect.NewRequest(&pingv1.PingRequest{}))
+			assert.Equal(t, connect.StreamEndReasonOf(err), connect.StreamEndDeadline)
+
+			// Both the closed server and the handler return CodeUnavailable,
+			// but only one of them reached the peer.
+			unreachable := pingv1connect.NewPingServiceClient(closed.Client(), closed.URL, connect.WithProtocol(protocol))
+			_, err = unreachable.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+			assert.Equal(t, connect.CodeOf(err), connect.CodeUnavailable)
+			assert.Equal(t, connect.StreamEndReasonOf(err), connect.StreamEndNetwork)
+		})
+	}
+	client := pingv1connect.NewPingServiceClient(truncated.Client(), truncated.URL)
+	stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 1}))
+	assert.Nil(t, err)
+	assert.False(t, stream.Receive())
+	assert.Equal(t, connect.StreamEndReasonOf(stream.Err()), connect.StreamEndProtocol)
+	assert.Nil(t, stream.Close())
+
+	assert.Equal(t, connect.StreamEndReasonOf(nil), connect.StreamEndPeerClosed)
+	assert.Equal(t, connect.StreamEndReasonOf(errors.New("oh no")), connect.StreamEndUnknown)
+	assert.Equal(t, connect.StreamEndPeerError.String(), "peer_error")
+}
+
+func TestBrokerHTTPClient(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{checkMetadata: true}))
+	httpClient := connect.NewBrokerHTTPClient(&inMemoryBroker{handler: mux})
+	for _, protocol := range []string{connect.ProtocolConnect, connect.ProtocolGRPC, connect.ProtocolGRPCWeb} {
+		protocol := protocol
+		t.Run(protocol, func(t *testing.T) {
+			t.Parallel()
+			client := pingv1connect.NewPingServiceClient(
+				httpClient,
+				"http://broker",
+				connect.WithProtocol(protocol),
+			)
+			request := connect.NewRequest(&pingv1.PingRequest{Number: 42})
+			request.Header().Set(clientHeader, headerValue)
+			response, err := client.Ping(context.Background(), request)
+			assert.Nil(t, err)
+			assert.Equal(t, response.Msg.Number, 42)
+			assert.Equal(t, response.Header().Get(handlerHeader), headerValue)
+			assert.Equal(t, response.Trailer().Get(handlerTrailer), trailerValue)
+
+			failRequest := connect.NewRequest(&pingv1.FailRequest{Code: int32(connect.CodeResourceExhausted)})
+			failRequest.Header().Set(clientHeader, headerValue)
+			_, err = client.Fail(context.Background(), failRequest)
+			assert.Equal(t, connect.CodeOf(err), connect.CodeResourceExhausted)
+
+			sum := client.Sum(context.Background())
+			sum.RequestHeader().Set(clientHeader, headerValue)
+			for i := int64(1); i <= 3; i++ {
+				assert.Nil(t, sum.Send(&pingv1.SumRequest{Number: i}))
+			}
+			sumResponse, err := sum.CloseAndReceive()
+			assert.Nil(t, err)
+			assert.Equal(t, sumResponse.Msg.Sum, 6)
+
+			countUpRequest := connect.NewRequest(&pingv1.CountUpRequest{Number: 3})
+			countUpRequest.Header().Set(clientHeader, headerValue)
+			countUp, err := client.CountUp(context.Background(), countUpRequest)
+			assert.Nil(t, err)
+			var got []int64
+			for countUp.Receive() {
+				got = append(got, countUp.Msg().Number)
+			}
+			assert.Nil(t, countUp.Err())
+			assert.Equal(t, got, []int64{1, 2, 3})
+			assert.Equal(t, countUp.ResponseTrailer().Get(handlerTrailer), trailerValue)
+			assert.Nil(t, countUp.Close())
+		})
+	}
+}
+
+func TestWithTransport(t *testing.T) {
+	t.Parallel()
+	procedure := func(method string) string {
+		return "/" + pingv1connect.PingServiceName + "/" + method
+	}
+	server := pingServer{checkMetadata: true}
+	transport := &inMemoryTransport{handlers: map[string]*connect.Handler{
+		procedure("Ping"):    connect.NewUnaryHandler(procedure("Ping"), server.Ping),
+		procedure("Fail"):    connect.NewUnaryHandler(procedure("Fail"), server.Fail),
+		procedure("Sum"):     connect.NewClientStreamHandler(procedure("Sum"), server.Sum),
+		procedure("CountUp"): connect.NewServerStreamHandler(procedure("CountUp"), server.CountUp),
+		procedure("CumSum"):  connect.NewBidiStreamHandler(procedure("CumSum"), server.CumSum),
+	}}
+	client := pingv1connect.NewPingServiceClient(nil, "memory://", connect.WithTransport(transport))
+
+	t.Run("unary", func(t *testing.T) {
+		t.Parallel()
+		request := connect.NewRequest(&pingv1.PingRequest{Number: 42})
+		request.Header().Set(clientHeader, headerValue)
+		response, err := client.Ping(context.Background(), request)
+		assert.Nil(t, err)
+		assert.Equal(t, response.Msg.Number, 42)
+		assert.Equal(t, response.Header().Get(handlerHeader), headerValue)
+		assert.Equal(t, response.Trailer().Get(handlerTrailer), trailerValue)
+	})
+	t.Run("unary_error", func(t *testing.T) {
+		t.Parallel()
+		request := connect.NewRequest(&pingv1.FailRequest{Code: int32(connect.CodeResourceExhausted)})
+		request.Header().Set(clientHeader, headerValue)
+		_, err := client.Fail(context.Background(), request)
+		assert.Equal(t, connect.CodeOf(err), connect.CodeResourceExhausted)
+	})
+	t.Run("client_stream", func(t *testing.T) {
+		t.Parallel()
+		stream := client.Sum(context.Background())
+		stream.RequestHeader().Set(clientHeader, headerValue)
+		for i := int64(1); i <= 3; i++ {
+			assert.Nil(t, stream.Send(&pingv1.SumRequest{Number: i}))
+		}
+		response, err := stream.CloseAndReceive()
+		assert.Nil(t, err)
+		assert.Equal(t, response.Msg.Sum, 6)
+	})
+	t.Run("server_stream", func(t *testing.T) {
+		t.Parallel()
+		request := connect.NewRequest(&pingv1.CountUpRequest{Number: 3})
+		request.Header().Set(clientHeader, headerValue)
+		stream, err := client.CountUp(context.Background(), request)
+		assert.Nil(t, err)
+		var got []int64
+		for stream.Receive() {
+			got = append(got, stream.Msg().Number)
+		}
+		assert.Nil(t, stream.Err())
+		assert.Equal(t, got, []int64{1, 2, 3})
+		assert.Equal(t, stream.ResponseTrailer().Get(handlerTrailer), trailerValue)
+		assert.Nil(t, stream.Close())
+	})
+	t.Run("bidi_stream", func(t *testing.T) {
+		t.Parallel()
+		stream := client.CumSum(context.Background())
+		stream.RequestHeader().Set(clientHeader, headerValue)
+		var got []int64
+		for _, n := range []int64{3, 5, 1} {
+			assert.Nil(t, stream.Send(&pingv1.CumSumRequest{Number: n}))
+			response, err := stream.Receive()
+			assert.Nil(t, err)
+			got = append(got, response.Sum)
+		}
+		assert.Nil(t, stream.CloseSend())
+		_, err := stream.Receive()
+		assert.ErrorIs(t, err, io.EOF)
+		assert.Nil(t, stream.CloseReceive())
+		assert.Equal(t, got, []int64{3, 8, 9})
+	})
+	t.Run("unary_round_trip", func(t *testing.T) {
+		t.Parallel()
+		roundTripper := &roundTripTransport{inMemoryTransport: transport}
+		client := pingv1connect.NewPingServiceClient(nil, "memory://", connect.WithTransport(roundTripper))
+		request := connect.NewRequest(&pingv1.PingRequest{Number: 42})
+		request.Header().Set(clientHeader, headerValue)
+		response, err := client.Ping(context.Background(), request)
+		assert.Nil(t, err)
+		assert.Equal(t, response.Msg.Number, 42)
+		assert.Equal(t, response.Header().Get(handlerHeader), headerValue)
+		assert.Equal(t, response.Trailer().Get(handlerTrailer), trailerValue)
+		assert.Equal(t, atomic.LoadInt32(&roundTripper.roundTrips), 1)
+	})
+	t.Run("handler_options", func(t *testing.T) {
+		t.Parallel()
+		// Handler options apply to streams served with ServeStream, just as
+		// they do over HTTP.
+		limited := &inMemoryTransport{handlers: map[string]*connect.Handler{
+			procedure("Ping"): connect.NewUnaryHandler(procedure("Ping"), server.Ping, connect.WithMaxHeaderBytes(64)),
+		}}
+		client := pingv1connect.NewPingServiceClient(nil, "memory://", connect.WithTransport(limited))
+		request := connect.NewRequest(&pingv1.PingRequest{Number: 42})
+		request.Header().Set(clientHeader, strings.Repeat("x", 64))
+		_, err := client.Ping(context.Background(), request)
+		assert.Equal(t, connect.CodeOf(err), connect.CodeResourceExhausted)
+	})
+}
+
+// roundTripTransport sends unary calls with RoundTrip, counting them.
+type roundTripTransport struct {
+	*inMemoryTransport
+
+	roundTrips int32 // atomic
+}
+
+func (t *roundTripTransport) RoundTrip(
+	ctx context.Context,
+	spec connect.Spec,
+	header http.Header,
+	request, response any,
+) (http.Header, http.Header, error) {
+	atomic.AddInt32(&t.roundTrips, 1)
+	sender, receiver := t.OpenStream(ctx, spec, header)
+	if err := sender.Send(request); err != nil {
+		return nil, nil, err
+	}
+	if err := sender.Close(nil); err != nil {
+		return nil, nil, err
+	}
+	if err := receiver.Receive(response); err != nil {
+		return nil, nil, err
+	}
+	// Drain the stream to read the trailers.
+	if err := receiver.Receive(response); err == nil {
+		return nil, nil, connect.NewError(connect.CodeUnknown, errors.New("unary stream has multiple messages"))
+	} else if !errors.Is(err, io.EOF) {
+		return nil, nil, err
+	}
+	trailer, _ := receiver.Trailer()
+	return receiver.Header(), trailer, receiver.Close()
+}
+
+// inMemoryTransport serves calls by passing serialized messages between
+// goroutines.
+type inMemoryTransport struct {
+	handlers map[string]*connect.Handler
+}
+
+func (t *inMemoryTransport) OpenStream(
+	ctx context.Context,
+	spec connect.Spec,
+	header http.Header,
+) (connect.Sender, connect.Receiver) {
+	requests, responses := newMemoryPipe(), newMemoryPipe()
+	responseHeader, responseTrailer := make(http.Header), make(http.Header)
+	handlerSpec := spec
+	handlerSpec.IsClient = false
+	clientSender := &memorySender{spec: spec, pipe: requests, header: header}
+	handler, ok := t.handlers[spec.Procedure]
+	if !ok {
+		responses.Close(connect.NewError(connect.CodeUnimplemented, errors.New("no such procedure")))
+	} else {
+		// Like HTTP, start the call when the request headers are sent: on the
+		// first call to Send or Close.
+		clientSender.start = func() {
+			go handler.ServeStream(
+				ctx,
+				&memorySender{spec: handlerSpec, pipe: responses, header: responseHeader, trailer: responseTrailer},
+				&memoryReceiver{spec: handlerSpec, pipe: requests, header: header},
+			)
+		}
+	}
+	return clientSender, &memoryReceiver{spec: spec, pipe: responses, header: responseHeader, trailer: responseTrailer}
+}
+
+type memoryPipe struct {
+	messages chan []byte
+	once     sync.Once
+	err      error // set before messages is closed
+}
+
+func newMemoryPipe() *memoryPipe {
+	return &memoryPipe{messages: make(chan []byte, 16)}
+}
+
+func (p *memoryPipe) Close(err error) {
+	p.once.Do(func() {
+		p.err = err
+		close(p.messages)
+	})
+}
+
+type memorySender struct {
+	spec    connect.Spec
+	pipe    *memoryPipe
+	header  http.Header
+	trailer http.Header
+	start   func()
+	started sync.Once
+}
+
+func (s *memorySender) Send(message any) error {
+	s.startCall()
+	data, err := proto.Marshal(message.(proto.Message)) //nolint:forcetypeassert
+	if err != nil {
+		return err
+	}
+	s.pipe.messages <- data
+	return nil
+}
+
+func (s *memorySender) Close(err error) error {
+	s.startCall()
+	s.pipe.Close(err)
+	return nil
+}
+
+func (s *memorySender) startCall() {
+	if s.start != nil {
+		s.started.Do(s.start)
+	}
+}
+
+func (s *memorySender) Spec() connect.Spec  { return s.spec }
+func (s *memorySender) Header() http.Header { return s.header }
+
+func (s *memorySender) Trailer() (http.Header, bool) {
+	return s.trailer, s.trailer != nil
+}
+
+type memoryReceiver struct {
+	spec    connect.Spec
+	pipe    *memoryPipe
+	header  http.Header
+	trailer http.Header
+}
+
+func (r *memoryReceiver) Receive(message any) error {
+	data, ok := <-r.pipe.messages
+	if !ok {
+		if r.pipe.err != nil {
+			return r.pipe.err
+		}
+		return io.EOF
+	}
+	return proto.Unmarshal(data, message.(proto.Message)) //nolint:forcetypeassert
+}
+
+func (r *memoryReceiver) Close() error        { return nil }
+func (r *memoryReceiver) Spec() connect.Spec  { return r.spec }
+func (r *memoryReceiver) Header() http.Header { return r.header }
+
+func (r *memoryReceiver) Trailer() (http.Header, bool) {
+	return r.trailer, r.trailer != nil
+}
+
+func TestServerStreamCloseError(t *testing.T) {
+	t.Parallel()
+	transport := &closeFailingTransport{}
+	client := pingv1connect.NewPingServiceClient(nil, "memory://", connect.WithTransport(transport))
+	_, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 1}))
+	assert.Equal(t, connect.CodeOf(err), connect.CodeUnavailable)
+	// The client releases the stream even though it was never returned.
+	assert.Equal(t, atomic.LoadInt32(&transport.receiverClosed), 1)
+}
+
+// closeFailingTransport opens streams whose Senders fail to close, and
+// records whether their Receivers are closed.
+type closeFailingTransport struct {
+	receiverClosed int32 // atomic
+}
+
+func (t *closeFailingTransport) OpenStream(
+	_ context.Context,
+	spec connect.Spec,
+	header http.Header,
+) (connect.Sender, connect.Receiver) {
+	sender := &closeFailingSender{memorySender: &memorySender{spec: spec, pipe: newMemoryPipe(), header: header}}
+	receiver := &closeRecordingReceiver{
+		memoryReceiver: &memoryReceiver{spec: spec, pipe: newMemoryPipe(), header: make(http.Header)},
+		closed:         &t.receiverClosed,
+	}
+	return sender, receiver
+}
+
+type closeFailingSender struct {
+	*memorySender
+}
+
+func (s *closeFailingSender) Close(error) error {
+	return connect.NewError(connect.CodeUnavailable, errors.New("connection reset"))
+}
+
+type closeRecordingReceiver struct {
+	*memoryReceiver
+
+	closed *int32 // atomic
+}
+
+func (r *closeRecordingReceiver) Close() error {
+	atomic.AddInt32(r.closed, 1)
+	return nil
+}
+
+func TestStreamChannels(t *testing.T) {
+	t.Parallel()
+	procedure := "/" + pingv1connect.PingServiceName + "/CountUp"
+	mux := http.NewServeMux()
+	mux.Handle(procedure, connect.NewServerStreamHandler(
+		procedure,
+		func(
+			ctx context.Context,
+			request *connect.Request[pingv1.CountUpRequest],
+			stream *connect.ServerStream[pingv1.CountUpResponse],
+		) error {
+			messages, wait := connect.SendChannel(stream)
+			go func() {
+				defer close(messages)
+				// A zero count means count forever.
+				for i := int64(1); request.Msg.Number == 0 || i <= request.Msg.Number; i++ {
+					select {
+					case messages <- &pingv1.CountUpResponse{Number: i}:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+			return wait()
+		},
+	))
+	server := httptest.NewUnstartedServer(mux)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	for _, protocol := range []string{connect.ProtocolConnect, connect.ProtocolGRPC, connect.ProtocolGRPCWeb} {
+		client := connect.NewClient[pingv1.CountUpRequest, pingv1.CountUpResponse](
+			server.Client(),
+			server.URL+procedure,
+			connect.WithProtocol(protocol),
+		)
+		t.Run(protocol, func(t *testing.T) {
+			t.Run("complete", func(t *testing.T) {
+				stream, err := client.CallServerStream(
+					context.Background(),
+					connect.NewRequest(&pingv1.CountUpRequest{Number: 5}),
+				)
+				assert.Nil(t, err)
+				messages, streamErr := connect.ReceiveChannel(context.Background(), stream)
+				var got []*pingv1.CountUpResponse
+				for msg := range messages {
+					got = append(got, msg)
+				}
+				assert.Nil(t, streamErr())
+				assert.Equal(t, len(got), 5)
+				// Each message is a separate value.
+				for i, msg := range got {
+					assert.Equal(t, msg.Number, int64(i+1))
+				}
+			})
+			t.Run("stop_early", func(t *testing.T) {
+				stream, err := client.CallServerStream(
+					context.Background(),
+					connect.NewRequest(&pingv1.CountUpRequest{}),
+				)
+				assert.Nil(t, err)
+				ctx, cancel := context.WithCancel(context.Background())
+				messages, streamErr := connect.ReceiveChannel(ctx, stream)
+				msg := <-messages
+				assert.Equal(t, msg.Number, int64(1))
+				cancel()
+				// Messages already in flight may still arrive.
+				for msg := range messages {
+					assert.True(t, msg.Number > 1)
+				}
+				assert.Equal(t, connect.CodeOf(streamErr()), connect.CodeCanceled)
+			})
+		})
+	}
+}
+
+func TestChunkedBodies(t *testing.T) {
+	t.Parallel()
+	const (
+		uploadProcedure   = "/connect.test.Files/Upload"
+		downloadProcedure = "/connect.test.Files/Download"
+		chunkSize         = 1000
+	)
+	payload := make([]byte, 1<<20)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	wrap := func(chunk []byte) *wrapperspb.BytesValue {
+		return wrapperspb.Bytes(chunk)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(uploadProcedure, connect.NewClientStreamHandler(
+		uploadProcedure,
+		func(
+			_ context.Context,
+			stream *connect.ClientStream[wrapperspb.BytesValue],
+		) (*connect.Response[wrapperspb.BoolValue], error) {
+			var got bytes.Buffer
+			reader := connect.NewChunkReader(connect.ReceiveFunc[wrapperspb.BytesValue](stream), (*wrapperspb.BytesValue).GetValue)
+			if _, err := io.Copy(&got, reader); err != nil {
+				return nil, err
+			}
+			return connect.NewResponse(wrapperspb.Bool(bytes.Equal(got.Bytes(), payload))), nil
+		},
+	))
+	mux.Handle(downloadProcedure, connect.NewServerStreamHandler(
+		downloadProcedure,
+		func(
+			_ context.Context,
+			_ *connect.Request[wrapperspb.BoolValue],
+			stream *connect.ServerStream[wrapperspb.BytesValue],
+		) error {
+			writer := connect.NewChunkWriter(stream.Send, chunkSize, wrap)
+			_, err := io.Copy(writer, bytes.NewReader(payload))
+			return err
+		},
+	))
+	server := httptest.NewUnstartedServer(mux)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	for _, protocol := range []string{connect.ProtocolConnect, connect.ProtocolGRPC, connect.ProtocolGRPCWeb} {
+		protocol := protocol
+		t.Run(protocol, func(t *testing.T) {
+			t.Parallel()
+			t.Run("upload", func(t *testing.T) {
+				client := connect.NewClient[wrapperspb.BytesValue, wrapperspb.BoolValue](
+					server.Client(),
+					server.URL+uploadProcedure,
+					connect.WithProtocol(protocol),
+				)
+				stream := client.CallClientStream(context.Background())
+				writer := connect.NewChunkWriter(stream.Send, chunkSize, wrap)
+				_, err := io.Copy(writer, bytes.NewReader(payload))
+				assert.Nil(t, err)
+				response, err := stream.CloseAndReceive()
+				assert.Nil(t, err)
+				assert.True(t, response.Msg.Value)
+			})
+			t.Run("download", func(t *testing.T) {
+				client := connect.NewClient[wrapperspb.BoolValue, wrapperspb.BytesValue](
+					server.Client(),
+					server.URL+downloadProcedure,
+					connect.WithProtocol(protocol),
+				)
+				stream, err := client.CallServerStream(context.Background(), connect.NewRequest(wrapperspb.Bool(true)))
+				assert.Nil(t, err)
+				var got bytes.Buffer
+				reader := connect.NewChunkReader(connect.ReceiveFunc[wrapperspb.BytesValue](stream), (*wrapperspb.BytesValue).GetValue)
+				_, err = io.Copy(&got, reader)
+				assert.Nil(t, err)
+				assert.Equal(t, got.Len(), len(payload))
+				assert.True(t, bytes.Equal(got.Bytes(), payload))
+				assert.Nil(t, stream.Close())
+			})
+		})
+	}
+	t.Run("receive_error", func(t *testing.T) {
+		t.Parallel()
+		wantErr := connect.NewError(connect.CodeDataLoss, errors.New("truncated"))
+		messages := []*wrapperspb.BytesValue{wrapperspb.Bytes([]byte("ab")), wrapperspb.Bytes(nil), wrapperspb.Bytes([]byte("c"))}
+		reader := connect.NewChunkReader(func() (*wrapperspb.BytesValue, error) {
+			if len(messages) == 0 {
+				return nil, wantErr
+			}
+			msg := messages[0]
+			messages = messages[1:]
+			return msg, nil
+		}, (*wrapperspb.BytesValue).GetValue)
+		got, err := io.ReadAll(reader)
+		assert.Equal(t, string(got), "abc")
+		assert.Equal(t, connect.CodeOf(err), connect.CodeDataLoss)
+	})
+}
+
+func TestTypeRegistry(t *testing.T) {
+	t.Parallel()
+	const (
+		lookupProcedure = "/connect.test.Registry/Lookup"
+		watchProcedure  = "/connect.test.Registry/Watch"
+	)
+	// connect.test.Custom isn't linked into the test binary, so only the
+	// registry can resolve it.
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:       proto.String("connect/test/registry.proto"),
+		Package:    proto.String("connect.test"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/any.proto", "google/protobuf/wrappers.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Custom"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("text"),
+				JsonName: proto.String("text"),
+				Number:   proto.Int32(1),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			}},
+		}},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Registry"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				{
+					Name:       proto.String("Lookup"),
+					InputType:  proto.String(".google.protobuf.StringValue"),
+					OutputType: proto.String(".google.protobuf.Any"),
+				},
+				{
+					Name:       proto.String("Describe"),
+					InputType:  proto.String(".connect.test.Custom"),
+					OutputType: proto.String(".connect.test.Custom"),
+				},
+			},
+		}},
+	}, protoregistry.GlobalFiles)
+	assert.Nil(t, err)
+	types := new(protoregistry.Types)
+	registry := connect.WithTypeRegistry(types, file.Services().Get(0))
+	customType, err := types.FindMessageByName("connect.test.Custom")
+	assert.Nil(t, err)
+	_, err = types.FindMessageByName("google.protobuf.StringValue")
+	assert.Nil(t, err)
+	newCustom := func(text string) *anypb.Any {
+		custom := customType.New()
+		custom.Set(custom.Descriptor().Fields().ByName("text"), protoreflect.ValueOfString(text))
+		packed, err := anypb.New(custom.Interface())
+		assert.Nil(t, err)
+		return packed
+	}
+	customText := func(packed *anypb.Any) string {
+		custom, err := anypb.UnmarshalNew(packed, proto.UnmarshalOptions{Resolver: types})
+		assert.Nil(t, err)
+		return custom.ProtoReflect().Get(customType.Descriptor().Fields().ByName("text")).String()
+	}
+	failure := func(text string) error {
+		err := connect.NewError(connect.CodeNotFound, errors.New("not found"))
+		err.AddDetail(newCustom(text))
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(lookupProcedure, connect.NewUnaryHandler(
+		lookupProcedure,
+		func(_ context.Context, request *connect.Request[wrapperspb.StringValue]) (*connect.Response[anypb.Any], error) {
+			switch request.Msg.Value {
+			case "":
+				return nil, failure("empty")
+			case "global":
+				// Int64Value isn't in the registry, so it resolves with the
+				// global one.
+				wrapped, err := anypb.New(wrapperspb.Int64(42))
+				if err != nil {
+					return nil, err
+				}
+				return connect.NewResponse(wrapped), nil
+			case "global_error":
+				err := connect.NewError(connect.CodeNotFound, errors.New("not found"))
+				err.AddDetail(newCustom("custom"))
+				wrapped, anyErr := anypb.New(wrapperspb.Int64(42))
+				if anyErr != nil {
+					return nil, anyErr
+				}
+				err.AddDetail(wrapped)
+				return nil, err
+			}
+			return connect.NewResponse(newCustom(request.Msg.Value)), nil
+		},
+		registry,
+	))
+	mux.Handle(watchProcedure, connect.NewServerStreamHandler(
+		watchProcedure,
+		func(
+			_ context.Context,
+			request *connect.Request[wrapperspb.StringValue],
+			stream *connect.ServerStream[anypb.Any],
+		) error {
+			if err := stream.Send(newCustom(request.Msg.Value)); err != nil {
+				return err
+			}
+			return failure("done")
+		},
+		registry,
+	))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	t.Run("unary", func(t *testing.T) {
+		t.Parallel()
+		client := connect.NewClient[wrapperspb.StringValue, anypb.Any](
+			server.Client(),
+			server.URL+lookupProcedure,
+			connect.WithProtoJSON(),
+			registry,
+		)
+		response, err := client.CallUnary(context.Background(), connect.NewRequest(wrapperspb.String("hello")))
+		assert.Nil(t, err)
+		assert.Equal(t, customText(response.Msg), "hello")
+		_, err = client.CallUnary(context.Background(), connect.NewRequest(wrapperspb.String("")))
+		assert.Equal(t, connect.CodeOf(err), connect.CodeNotFound)
+		var connectErr *connect.Error
+		assert.True(t, errors.As(err, &connectErr))
+		assert.Equal(t, len(connectErr.Details()), 1)
+		assert.Equal(t, customText(connectErr.Details()[0].(*anypb.Any)), "empty")
+	})
+	t.Run("global", func(t *testing.T) {
+		t.Parallel()
+		client := connect.NewClient[wrapperspb.StringValue, anypb.Any](
+			server.Client(),
+			server.URL+lookupProcedure,
+			connect.WithProtoJSON(),
+			registry,
+		)
+		response, err := client.CallUnary(context.Background(), connect.NewRequest(wrapperspb.String("global")))
+		assert.Nil(t, err)
+		var wrapped wrapperspb.Int64Value
+		assert.Nil(t, response.Msg.UnmarshalTo(&wrapped))
+		assert.Equal(t, wrapped.Value, 42)
+		_, err = client.CallUnary(context.Background(), connect.NewRequest(wrapperspb.String("global_error")))
+		assert.Equal(t, connect.CodeOf(err), connect.CodeNotFound)
+		var connectErr *connect.Error
+		assert.True(t, errors.As(err, &connectErr))
+		assert.Equal(t, len(connectErr.Details()), 2)
+		assert.Equal(t, customText(connectErr.Details()[0].(*anypb.Any)), "custom")
+		assert.Nil(t, connectErr.Details()[1].UnmarshalTo(&wrapped))
+		assert.Equal(t, wrapped.Value, 42)
+	})
+	t.Run("streaming", func(t *testing.T) {
+		t.Parallel()
+		client := connect.NewClient[wrapperspb.StringValue, anypb.Any](
+			server.Client(),
+			server.URL+watchProcedure,
+			connect.WithProtoJSON(),
+			registry,
+		)
+		stream, err := client.CallServerStream(context.Background(), connect.NewRequest(wrapperspb.String("hello")))
+		assert.Nil(t, err)
+		assert.True(t, stream.Receive())
+		assert.Equal(t, customText(stream.Msg()), "hello")
+		assert.False(t, stream.Receive())
+		assert.Equal(t, connect.CodeOf(stream.Err()), connect.CodeNotFound)
+		var connectErr *connect.Error
+		assert.True(t, errors.As(stream.Err(), &connectErr))
+		assert.Equal(t, len(connectErr.Details()), 1)
+		assert.Equal(t, customText(connectErr.Details()[0].(*anypb.Any)), "done")
+	})
+	t.Run("unregistered", func(t *testing.T) {
+		t.Parallel()
+		client := connect.NewClient[wrapperspb.StringValue, anypb.Any](
+			server.Client(),
+			server.URL+lookupProcedure,
+			connect.WithProtoJSON(),
+		)
+		_, err := client.CallUnary(context.Background(), connect.NewRequest(wrapperspb.String("hello")))
+		assert.NotNil(t, err)
+	})
+}
+
+// flakyCumSumServer echoes each number plus 100 times the number of the
+// connection. The first connection fails with CodeUnavailable after its
+// second message, and negative numbers fail with CodeInvalidArgument.
+type flakyCumSumServer struct {
+	pingv1connect.UnimplementedPingServiceHandler
+
+	connections *int64
+}
+
+func (s *flakyCumSumServer) CumSum(
+	_ context.Context,
+	stream *connect.BidiStream[pingv1.CumSumRequest, pingv1.CumSumResponse],
+) error {
+	connection := atomic.AddInt64(s.connections, 1)
+	for received := 1; ; received++ {
+		request, err := stream.Receive()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if request.Number < 0 {
+			return connect.NewError(connect.CodeInvalidArgument, errors.New("negative number"))
+		}
+		if err := stream.Send(&pingv1.CumSumResponse{Sum: request.Number + 100*connection}); err != nil {
+			return err
+		}
+		if connection == 1 && received == 2 {
+			return connect.NewError(connect.CodeUnavailable, errors.New("connection lost"))
+		}
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"errors"
 	"io"
 	"net/http"
+	"sync"
 )
 
 // ClientStreamForClient is the client's view of a client streaming RPC.
@@ -27,6 +28,10 @@ import (
 type ClientStreamForClient[Req, Res any] struct {
 	sender   Sender
 	receiver Receiver
+	// Result of the first call to CloseAndReceive.
+	closed      bool
+	response    *Response[Res]
+	responseErr error
 	// Error from client construction. If non-nil, return for all calls.
 	err error
 }
@@ -53,13 +58,52 @@ func (c *ClientStreamForClient[Req, Res]) Send(request *Req) error {
 	return c.sender.Send(request)
 }
 
+// SendBatch sends multiple messages to the server, coalescing their writes.
+// If any message fails to marshal, none of the batch is sent. Like Send, the
+// first call also sends the request headers.
+func (c *ClientStreamForClient[Req, Res]) SendBatch(requests []*Req) error {
+	if c.err != nil {
+		return c.err
+	}
+	return sendBatch(c.sender, anySlice(requests))
+}
+
+// SendUncompressed is like Send, but never compresses the message, even if
+// the client compresses requests. It's useful for payloads that are already
+// compressed, like JPEG chunks. If interceptors wrap the stream's Sender, the
+// message is sent with Send instead.
+func (c *ClientStreamForClient[Req, Res]) SendUncompressed(request *Req) error {
+	if c.err != nil {
+		return c.err
+	}
+	return sendUncompressed(c.sender, request)
+}
+
 // CloseAndReceive closes the send side of the stream and waits for the
-// response.
+// response. The response includes the server's headers and trailers. If the
+// server returned an error, including one that caused an earlier Send to
+// fail with io.EOF, CloseAndReceive returns it, and the error's metadata
+// includes the server's headers and trailers.
+//
+// CloseAndReceive always releases the stream's resources, so callers don't
+// need to do any other cleanup. Calling it more than once returns the result
+// of the first call.
 func (c *ClientStreamForClient[Req, Res]) CloseAndReceive() (*Response[Res], error) {
 	if c.err != nil {
 		return nil, c.err
 	}
-	if err := c.sender.Close(nil); err != nil {
+	if !c.closed {
+		c.closed = true
+		c.response, c.responseErr = c.closeAndReceive()
+	}
+	return c.response, c.responseErr
+}
+
+func (c *ClientStreamForClient[Req, Res]) closeAndReceive() (*Response[Res], error) {
+	// If the server has already responded, Close returns an error wrapping
+	// io.EOF and the server's response is still waiting to be read.
+	if err := c.sender.Close(nil); err != nil && !errors.Is(err, io.EOF) {
+		_ = c.receiver.Close()
 		return nil, err
 	}
 	response, err := receiveUnaryResponse[Res](c.receiver)
@@ -80,6 +124,12 @@ func (c *ClientStreamForClient[Req, Res]) CloseAndReceive() (*Response[Res], err
 type ServerStreamForClient[Res any] struct {
 	receiver Receiver
 	msg      Res
+	// Non-nil if the client was constructed with WithLazyUnmarshal.
+	lazy    *lazyMessage
+	decoded bool
+	// Restarts the call with the client's fallback protocol. Only set until the
+	// first call to Receive.
+	fallback func() (Receiver, error)
 	// Error from client construction. If non-nil, return for all calls.
 	constructErr error
 	// Error from Receive().
@@ -95,17 +145,81 @@ func (s *ServerStreamForClient[Res]) Receive() bool {
 	if s.constructErr != nil || s.receiveErr != nil {
 		return false
 	}
-	s.receiveErr = s.receiver.Receive(&s.msg)
+	s.receiveErr = s.receive()
+	if fallback := s.fallback; fallback != nil {
+		s.fallback = nil
+		if errors.Is(s.receiveErr, errHTTP2Unavailable) {
+			_ = s.receiver.Close()
+			receiver, err := fallback()
+			if err != nil {
+				s.receiveErr = err
+				return false
+			}
+			s.receiver = receiver
+			s.receiveErr = s.receive()
+		}
+	}
 	return s.receiveErr == nil
 }
 
+func (s *ServerStreamForClient[Res]) receive() error {
+	if s.lazy != nil {
+		s.decoded = false
+		return s.receiver.Receive(s.lazy)
+	}
+	return s.receiver.Receive(&s.msg)
+}
+
 // Msg returns the most recent message unmarshaled by a call to Receive. The
 // returned message points to data that will be overwritten by the next call to
 // Receive.
+//
+// If the client was constructed with WithLazyUnmarshal, the message is
+// unmarshaled on the first call to Msg. If unmarshaling fails, the next call
+// to Receive returns false and Err returns the unmarshaling error.
 func (s *ServerStreamForClient[Res]) Msg() *Res {
+	if s.lazy != nil && !s.decoded && s.constructErr == nil && s.receiveErr == nil {
+		s.decoded = true
+		if err := s.lazy.Unmarshal(&s.msg); err != nil {
+			s.receiveErr = err
+		}
+	}
 	return &s.msg
 }
 
+// ReceiveBatch receives up to limit messages. Like
+// BidiStreamForClient.ReceiveBatch, it blocks until limit messages have
+// arrived or the stream stops, and returns the messages received so far along
+// with the error that stopped the stream, if any. The error wraps io.EOF once
+// the server is done sending, and Err reports it like an error from Receive.
+//
+// Batched messages are always unmarshaled eagerly, even if the client was
+// constructed with WithLazyUnmarshal.
+func (s *ServerStreamForClient[Res]) ReceiveBatch(limit int) ([]*Res, error) {
+	if s.constructErr != nil {
+		return nil, s.constructErr
+	}
+	if s.receiveErr != nil {
+		return nil, s.receiveErr
+	}
+	batch, err := receiveBatch[Res](s.receiver, limit)
+	if fallback := s.fallback; fallback != nil {
+		s.fallback = nil
+		if len(batch) == 0 && errors.Is(err, errHTTP2Unavailable) {
+			_ = s.receiver.Close()
+			receiver, fallbackErr := fallback()
+			if fallbackErr != nil {
+				s.receiveErr = fallbackErr
+				return nil, fallbackErr
+			}
+			s.receiver = receiver
+			batch, err = receiveBatch[Res](s.receiver, limit)
+		}
+	}
+	s.receiveErr = err
+	return batch, err
+}
+
 // Err returns the first non-EOF error that was encountered by Receive.
 func (s *ServerStreamForClient[Res]) Err() error {
 	if s.constructErr != nil {
@@ -138,7 +252,8 @@ func (s *ServerStreamForClient[Res]) ResponseTrailer() http.Header {
 	return make(http.Header)
 }
 
-// Close the receive side of the stream.
+// Close the receive side of the stream. If the server hasn't finished
+// sending, Close cancels the stream, and the server's context is canceled.
 func (s *ServerStreamForClient[Res]) Close() error {
 	if s.constructErr != nil {
 		return s.constructErr
@@ -150,7 +265,16 @@ func (s *ServerStreamForClient[Res]) Close() error {
 //
 // It's returned from Client.CallBidiStream, but doesn't currently have an
 // exported constructor function.
+//
+// Bidirectional streams are usually driven by two goroutines: one may call
+// Send, SendBatch, and CloseSend while another calls Receive, ReceiveBatch,
+// and CloseReceive. CloseSend and CloseReceive are safe to call concurrently
+// with each other and with any other method, and calling them more than once
+// is harmless. Otherwise, calls on the same side of the stream must not be
+// made concurrently.
 type BidiStreamForClient[Req, Res any] struct {
+	// Serializes CloseSend with sends on other goroutines.
+	sendMu   sync.Mutex
 	sender   Sender
 	receiver Receiver
 	// Error from client construction. If non-nil, return for all calls.
@@ -176,14 +300,41 @@ func (b *BidiStreamForClient[Req, Res]) Send(msg *Req) error {
 	if b.err != nil {
 		return b.err
 	}
+	b.sendMu.Lock()
+	defer b.sendMu.Unlock()
 	return b.sender.Send(msg)
 }
 
+// SendBatch sends multiple messages to the server, coalescing their writes.
+// If any message fails to marshal, none of the batch is sent. Like Send, the
+// first call also sends the request headers.
+func (b *BidiStreamForClient[Req, Res]) SendBatch(msgs []*Req) error {
+	if b.err != nil {
+		return b.err
+	}
+	b.sendMu.Lock()
+	defer b.sendMu.Unlock()
+	return sendBatch(b.sender, anySlice(msgs))
+}
+
+// SendUncompressed is like Send, but never compresses the message. See
+// ClientStreamForClient.SendUncompressed.
+func (b *BidiStreamForClient[Req, Res]) SendUncompressed(msg *Req) error {
+	if b.err != nil {
+		return b.err
+	}
+	b.sendMu.Lock()
+	defer b.sendMu.Unlock()
+	return sendUncompressed(b.sender, msg)
+}
+
 // CloseSend closes the send side of the stream.
 func (b *BidiStreamForClient[Req, Res]) CloseSend() error {
 	if b.err != nil {
 		return b.err
 	}
+	b.sendMu.Lock()
+	defer b.sendMu.Unlock()
 	return b.sender.Close(nil)
 }
 
@@ -200,7 +351,23 @@ func (b *BidiStreamForClient[Req, Res]) Receive() (*Res, error) {
 	return &msg, nil
 }
 
-// CloseReceive closes the receive side of the stream.
+// ReceiveBatch receives up to limit messages, blocking until limit messages
+// have arrived or the stream stops. It returns the messages received so far
+// along with the error that stopped the stream, if any. When the server is
+// done sending messages, the error wraps io.EOF. Once a stream has received a
+// batch, its reads from the network are buffered, so a single read can return
+// several messages.
+func (b *BidiStreamForClient[Req, Res]) ReceiveBatch(limit int) ([]*Res, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return receiveBatch[Res](b.receiver, limit)
+}
+
+// CloseReceive closes the receive side of the stream. If the server hasn't
+// finished sending, CloseReceive cancels the stream: pending and future calls
+// to Receive return immediately with CodeCanceled, and the server's context
+// is canceled.
 func (b *BidiStreamForClient[Req, Res]) CloseReceive() error {
 	if b.err != nil {
 		return b.err
@@ -0,0 +1,142 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A Clock tells time and schedules callbacks. Clients and handlers use their
+// Clock to encode and enforce deadlines and timeouts. By default, they use the
+// system clock, but tests can supply a synthetic Clock with WithClock to
+// exercise timeout paths without sleeping.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// AfterFunc waits for the duration to elapse and then calls f in its own
+	// goroutine. The returned function cancels the call, and reports whether it
+	// stopped the call from happening (like the standard library's
+	// time.Timer.Stop).
+	AfterFunc(d time.Duration, f func()) (stop func() bool)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) func() bool {
+	return time.AfterFunc(d, f).Stop
+}
+
+// until returns the duration until the deadline, as measured by the clock.
+func until(clock Clock, deadline time.Time) time.Duration {
+	return deadline.Sub(clock.Now())
+}
+
+// sleep waits for the duration to elapse on the clock. It returns the
+// context's error if the context ends first.
+func sleep(ctx context.Context, clock Clock, d time.Duration) error {
+	done := make(chan struct{})
+	stop := clock.AfterFunc(d, func() { close(done) })
+	select {
+	case <-ctx.Done():
+		stop()
+		return ctx.Err()
+	case <-done:
+		return nil
+	}
+}
+
+// withTimeout is like the standard library's context.WithTimeout, but uses
+// the supplied Clock.
+func withTimeout(
+	ctx context.Context,
+	clock Clock,
+	timeout time.Duration,
+) (context.Context, context.CancelFunc) {
+	if _, ok := clock.(systemClock); ok {
+		return context.WithTimeout(ctx, timeout)
+	}
+	deadline := clock.Now().Add(timeout)
+	if current, ok := ctx.Deadline(); ok && current.Before(deadline) {
+		// The parent's deadline is sooner, so it's already enforced.
+		return context.WithCancel(ctx)
+	}
+	timeoutCtx := &clockContext{Context: ctx, deadline: deadline, done: make(chan struct{})}
+	cancel := func() { timeoutCtx.cancel(context.Canceled) }
+	if timeout <= 0 {
+		timeoutCtx.cancel(context.DeadlineExceeded)
+		return timeoutCtx, cancel
+	}
+	if parentDone := ctx.Done(); parentDone != nil {
+		go func() {
+			select {
+			case <-parentDone:
+				timeoutCtx.cancel(ctx.Err())
+			case <-timeoutCtx.done:
+			}
+		}()
+	}
+	stop := clock.AfterFunc(timeout, func() {
+		timeoutCtx.cancel(context.DeadlineExceeded)
+	})
+	return timeoutCtx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// clockContext is a context whose deadline is enforced by a Clock. It has its
+// own Done channel rather than wrapping a context from context.WithCancel:
+// the standard library would otherwise link children directly to the inner
+// context, and they'd report context.Canceled when the deadline expires.
+type clockContext struct {
+	context.Context
+
+	deadline time.Time
+	done     chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+func (c *clockContext) Deadline() (time.Time, bool) {
+	return c.deadline, true
+}
+
+func (c *clockContext) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *clockContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// cancel ends the context with err. Only the first call has any effect.
+func (c *clockContext) cancel(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.done)
+}
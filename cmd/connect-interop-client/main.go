@@ -0,0 +1,123 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// connect-interop-client runs the standard gRPC interoperability test cases
+// against a server implementing grpc.testing.TestService, like the interop
+// servers from grpc-go and grpc-java or connect-interop-server:
+//
+//	connect-interop-client -server_port 8080 -test_case large_unary
+//
+// Set -test_case to all to run every supported test case, and -protocol to
+// connect or grpcweb to test those protocols instead of gRPC. Without TLS,
+// the client uses HTTP/2 with prior knowledge (on Go 1.24 and later).
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/bufbuild/connect-go/internal/interop"
+)
+
+func main() {
+	host := flag.String("server_host", "localhost", "server host")
+	port := flag.Int("server_port", 10000, "server port")
+	testCase := flag.String("test_case", "large_unary", fmt.Sprintf(
+		"test case to run, or all: one of %s",
+		strings.Join(interop.TestCases(), ", "),
+	))
+	protocol := flag.String("protocol", connect.ProtocolGRPC, "protocol to use: grpc, grpcweb, or connect")
+	useTLS := flag.Bool("use_tls", false, "connect over TLS")
+	caFile := flag.String("ca_file", "", "PEM file of CAs to trust with -use_tls, instead of the system roots")
+	hostOverride := flag.String("server_host_override", "", "server name to verify with -use_tls, if not -server_host")
+	timeout := flag.Duration("timeout", time.Minute, "timeout for each test case")
+	flag.Parse()
+
+	var tlsConfig *tls.Config
+	if *useTLS {
+		var err error
+		if tlsConfig, err = newTLSConfig(*caFile, *hostOverride); err != nil {
+			fail(err)
+		}
+	}
+	scheme := "http://"
+	if *useTLS {
+		scheme = "https://"
+	}
+	var options []connect.ClientOption
+	switch *protocol {
+	case connect.ProtocolGRPC:
+		options = append(options, connect.WithGRPC())
+	case connect.ProtocolGRPCWeb:
+		options = append(options, connect.WithGRPCWeb())
+	case connect.ProtocolConnect:
+	default:
+		fail(fmt.Errorf("unknown protocol %q", *protocol))
+	}
+	clients := interop.NewClients(
+		&http.Client{Transport: interop.NewTransport(tlsConfig)},
+		scheme+net.JoinHostPort(*host, strconv.Itoa(*port)),
+		options...,
+	)
+	testCases := []string{*testCase}
+	if *testCase == "all" {
+		testCases = interop.TestCases()
+	}
+	failed := false
+	for _, name := range testCases {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		err := interop.Run(ctx, clients, name)
+		cancel()
+		if err != nil {
+			failed = true
+			fmt.Fprintf(os.Stderr, "FAIL: %s: %v\n", name, err)
+			continue
+		}
+		fmt.Fprintf(os.Stdout, "PASS: %s\n", name)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func newTLSConfig(caFile, serverName string) (*tls.Config, error) {
+	config := &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12}
+	if caFile == "" {
+		return config, nil
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	config.RootCAs = x509.NewCertPool()
+	if !config.RootCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return config, nil
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
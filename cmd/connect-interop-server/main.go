@@ -0,0 +1,64 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// connect-interop-server serves grpc.testing.TestService, the service used by
+// the standard gRPC interoperability tests, over the Connect, gRPC, and
+// gRPC-Web protocols. Point interop clients from grpc-go, grpc-java, or
+// grpc-web at it to verify compatibility:
+//
+//	connect-interop-server -port 8080
+//	go run google.golang.org/grpc/interop/client -server_port 8080 -test_case large_unary
+//
+// Without TLS, the server accepts both HTTP/1.1 and HTTP/2 with prior
+// knowledge (on Go 1.24 and later).
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/bufbuild/connect-go/internal/interop"
+)
+
+func main() {
+	port := flag.Int("port", 10000, "port to listen on")
+	useTLS := flag.Bool("use_tls", false, "serve over TLS")
+	certFile := flag.String("tls_cert_file", "", "TLS certificate, required with -use_tls")
+	keyFile := flag.String("tls_key_file", "", "TLS private key, required with -use_tls")
+	flag.Parse()
+	if *useTLS && (*certFile == "" || *keyFile == "") {
+		fmt.Fprintln(os.Stderr, "-use_tls requires -tls_cert_file and -tls_key_file")
+		os.Exit(2)
+	}
+	server := &http.Server{
+		Addr:    net.JoinHostPort("", strconv.Itoa(*port)),
+		Handler: interop.NewHandler(),
+	}
+	var err error
+	if *useTLS {
+		err = server.ListenAndServeTLS(*certFile, *keyFile)
+	} else {
+		interop.ConfigureServer(server)
+		err = server.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
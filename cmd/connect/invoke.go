@@ -0,0 +1,217 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/bufbuild/connect-go/internal/rawproto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// call invokes a method, reading JSON requests from input and printing JSON
+// responses.
+func (c *cli) call(ctx context.Context, target string, input io.Reader) error {
+	service, method, err := splitMethod(target)
+	if err != nil {
+		return err
+	}
+	reflection := newReflectionClient(ctx, c)
+	serviceDescriptor, err := reflection.findService(service)
+	reflection.Close()
+	if err != nil {
+		return err
+	}
+	methodDescriptor := serviceDescriptor.Methods().ByName(protoreflect.Name(method))
+	if methodDescriptor == nil {
+		return fmt.Errorf("service %s has no method %s", service, method)
+	}
+	requests, err := readRequests(input, methodDescriptor.Input())
+	if err != nil {
+		return err
+	}
+	if !methodDescriptor.IsStreamingClient() && len(requests) != 1 {
+		return fmt.Errorf("%s takes exactly one request, got %d", methodDescriptor.FullName(), len(requests))
+	}
+	client := connect.NewClient[rawproto.Message, rawproto.Message](
+		c.httpClient,
+		c.baseURL+"/"+service+"/"+method,
+		append(c.options, connect.WithCodec(rawproto.Codec{}))...,
+	)
+	output := methodDescriptor.Output()
+	switch {
+	case methodDescriptor.IsStreamingClient() && methodDescriptor.IsStreamingServer():
+		return c.callBidiStream(ctx, client, requests, output)
+	case methodDescriptor.IsStreamingClient():
+		stream := client.CallClientStream(ctx)
+		copyHeader(stream.RequestHeader(), c.header)
+		for _, request := range requests {
+			if err := stream.Send(request); err != nil && !errors.Is(err, io.EOF) {
+				return err
+			}
+		}
+		response, err := stream.CloseAndReceive()
+		if err != nil {
+			return err
+		}
+		c.printHeaders("headers", response.Header())
+		if err := c.printResponse(response.Msg, output); err != nil {
+			return err
+		}
+		c.printHeaders("trailers", response.Trailer())
+		return nil
+	case methodDescriptor.IsStreamingServer():
+		request := connect.NewRequest(requests[0])
+		copyHeader(request.Header(), c.header)
+		stream, err := client.CallServerStream(ctx, request)
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+		printedHeaders := false
+		for stream.Receive() {
+			if !printedHeaders {
+				c.printHeaders("headers", stream.ResponseHeader())
+				printedHeaders = true
+			}
+			if err := c.printResponse(stream.Msg(), output); err != nil {
+				return err
+			}
+		}
+		if err := stream.Err(); err != nil {
+			return err
+		}
+		c.printHeaders("trailers", stream.ResponseTrailer())
+		return nil
+	default:
+		request := connect.NewRequest(requests[0])
+		copyHeader(request.Header(), c.header)
+		response, err := client.CallUnary(ctx, request)
+		if err != nil {
+			return err
+		}
+		c.printHeaders("headers", response.Header())
+		if err := c.printResponse(response.Msg, output); err != nil {
+			return err
+		}
+		c.printHeaders("trailers", response.Trailer())
+		return nil
+	}
+}
+
+func (c *cli) callBidiStream(
+	ctx context.Context,
+	client *connect.Client[rawproto.Message, rawproto.Message],
+	requests []*rawproto.Message,
+	output protoreflect.MessageDescriptor,
+) error {
+	stream := client.CallBidiStream(ctx)
+	copyHeader(stream.RequestHeader(), c.header)
+	sendErr := make(chan error, 1)
+	go func() {
+		for _, request := range requests {
+			if err := stream.Send(request); err != nil {
+				sendErr <- err
+				return
+			}
+		}
+		sendErr <- stream.CloseSend()
+	}()
+	printedHeaders := false
+	for {
+		response, err := stream.Receive()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			_ = stream.CloseReceive()
+			return err
+		}
+		if !printedHeaders {
+			c.printHeaders("headers", stream.ResponseHeader())
+			printedHeaders = true
+		}
+		if err := c.printResponse(response, output); err != nil {
+			_ = stream.CloseReceive()
+			return err
+		}
+	}
+	if err := stream.CloseReceive(); err != nil {
+		return err
+	}
+	c.printHeaders("trailers", stream.ResponseTrailer())
+	if err := <-sendErr; err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// splitMethod accepts both package.Service/Method and package.Service.Method.
+func splitMethod(target string) (string, string, error) {
+	target = strings.TrimPrefix(target, "/")
+	separator := strings.LastIndex(target, "/")
+	if separator < 0 {
+		separator = strings.LastIndex(target, ".")
+	}
+	if separator <= 0 || separator == len(target)-1 {
+		return "", "", fmt.Errorf("%q isn't in package.Service/Method form", target)
+	}
+	return target[:separator], target[separator+1:], nil
+}
+
+// readRequests decodes a sequence of JSON objects into serialized messages.
+func readRequests(input io.Reader, descriptor protoreflect.MessageDescriptor) ([]*rawproto.Message, error) {
+	decoder := json.NewDecoder(input)
+	var requests []*rawproto.Message
+	for {
+		var object json.RawMessage
+		if err := decoder.Decode(&object); errors.Is(err, io.EOF) {
+			return requests, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("request data: %w", err)
+		}
+		message := dynamicpb.NewMessage(descriptor)
+		if err := protojson.Unmarshal(object, message); err != nil {
+			return nil, fmt.Errorf("request %d: %w", len(requests)+1, err)
+		}
+		data, err := proto.Marshal(message)
+		if err != nil {
+			return nil, err
+		}
+		raw := rawproto.Message(data)
+		requests = append(requests, &raw)
+	}
+}
+
+func (c *cli) printResponse(response *rawproto.Message, descriptor protoreflect.MessageDescriptor) error {
+	message := dynamicpb.NewMessage(descriptor)
+	if err := proto.Unmarshal(*response, message); err != nil {
+		return fmt.Errorf("response: %w", err)
+	}
+	data, err := protojson.MarshalOptions{Multiline: true}.Marshal(message)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.stdout, string(data))
+	return nil
+}
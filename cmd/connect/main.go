@@ -0,0 +1,175 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// connect is a command-line client for Connect, gRPC, and gRPC-Web servers,
+// in the spirit of grpcurl. It discovers services with the gRPC server
+// reflection API, so it doesn't need any Protobuf schemas.
+//
+// List services, or the methods of one service:
+//
+//	connect https://demo.example.com list
+//	connect https://demo.example.com list acme.ping.v1.PingService
+//
+// Call a method, supplying the request as JSON:
+//
+//	connect -d '{"number": 42}' https://demo.example.com acme.ping.v1.PingService/Ping
+//
+// For client and bidirectional streaming methods, -d may contain several
+// JSON objects, each of which becomes one request message. Use -d @ to read
+// requests from standard input. Responses are printed as JSON.
+//
+// The server must serve the grpc.reflection.v1alpha.ServerReflection service
+// over the protocol selected with -protocol, which defaults to gRPC. For
+// http:// URLs, the client uses HTTP/2 with prior knowledge (on Go 1.24 and
+// later).
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/bufbuild/connect-go"
+)
+
+const usage = `Usage:
+  connect [flags] URL list [SERVICE]
+  connect [flags] URL SERVICE/METHOD
+
+Flags:
+`
+
+// headerFlags collects repeated -H flags.
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(value string) error {
+	if !strings.Contains(value, ":") {
+		return fmt.Errorf("header %q isn't in Name: value form", value)
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+func main() {
+	flags := flag.NewFlagSet("connect", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	protocol := flags.String("protocol", connect.ProtocolGRPC, "protocol to use: grpc, grpcweb, or connect")
+	data := flags.String("d", "{}", "JSON request data, or @ to read it from standard input")
+	insecure := flags.Bool("insecure", false, "skip verification of the server's TLS certificate")
+	maxTime := flags.Duration("max-time", 0, "maximum duration of the whole operation (default unlimited)")
+	verbose := flags.Bool("v", false, "print response headers and trailers to standard error")
+	var headers headerFlags
+	flags.Var(&headers, "H", "request header in Name: value form (may be repeated)")
+	_ = flags.Parse(os.Args[1:])
+	args := flags.Args()
+	if len(args) < 2 || (args[1] != "list" && len(args) != 2) || len(args) > 3 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var options []connect.ClientOption
+	switch *protocol {
+	case connect.ProtocolGRPC:
+		options = append(options, connect.WithGRPC())
+	case connect.ProtocolGRPCWeb:
+		options = append(options, connect.WithGRPCWeb())
+	case connect.ProtocolConnect:
+	default:
+		fail(fmt.Errorf("unknown protocol %q", *protocol))
+	}
+	var tlsConfig *tls.Config
+	if *insecure {
+		tlsConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec // explicitly requested
+	}
+	ctx := context.Background()
+	if *maxTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *maxTime)
+		defer cancel()
+	}
+	cli := &cli{
+		httpClient: &http.Client{Transport: newTransport(tlsConfig)},
+		baseURL:    strings.TrimRight(args[0], "/"),
+		options:    options,
+		header:     make(http.Header),
+		stdout:     os.Stdout,
+		stderr:     os.Stderr,
+		verbose:    *verbose,
+	}
+	for _, header := range headers {
+		name, value, _ := strings.Cut(header, ":")
+		cli.header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	var err error
+	if args[1] == "list" {
+		service := ""
+		if len(args) == 3 {
+			service = args[2]
+		}
+		err = cli.list(ctx, service)
+	} else {
+		input := io.Reader(strings.NewReader(*data))
+		if *data == "@" {
+			input = os.Stdin
+		}
+		err = cli.call(ctx, args[1], input)
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+// cli holds the configuration shared by all subcommands.
+type cli struct {
+	httpClient connect.HTTPClient
+	baseURL    string
+	options    []connect.ClientOption
+	header     http.Header
+	stdout     io.Writer
+	stderr     io.Writer
+	verbose    bool
+}
+
+func fail(err error) {
+	var connectErr *connect.Error
+	if errors.As(err, &connectErr) {
+		fmt.Fprintf(os.Stderr, "ERROR:\n  Code: %s\n  Message: %s\n", connectErr.Code(), connectErr.Message())
+	} else {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+	}
+	os.Exit(1)
+}
+
+// printHeaders prints response metadata in verbose mode.
+func (c *cli) printHeaders(kind string, header http.Header) {
+	if !c.verbose || len(header) == 0 {
+		return
+	}
+	fmt.Fprintf(c.stderr, "Response %s:\n", kind)
+	_ = header.Write(c.stderr)
+	fmt.Fprintln(c.stderr)
+}
@@ -0,0 +1,229 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/bufbuild/connect-go/internal/assert"
+	pingv1 "github.com/bufbuild/connect-go/internal/gen/connect/ping/v1"
+	"github.com/bufbuild/connect-go/internal/gen/connect/ping/v1/pingv1connect"
+	reflectionv1alpha "github.com/bufbuild/connect-go/internal/gen/connectext/grpc/reflection/v1alpha"
+	"github.com/bufbuild/connect-go/internal/gen/connectext/grpc/reflection/v1alpha/reflectionv1alphaconnect"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+func TestCLI(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}))
+	mux.Handle(reflectionv1alphaconnect.NewServerReflectionHandler(reflectionServer{}))
+	server := httptest.NewUnstartedServer(mux)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	protocols := map[string][]connect.ClientOption{
+		connect.ProtocolConnect: nil,
+		connect.ProtocolGRPC:    {connect.WithGRPC()},
+		connect.ProtocolGRPCWeb: {connect.WithGRPCWeb()},
+	}
+	for protocol, options := range protocols {
+		protocol, options := protocol, options
+		t.Run(protocol, func(t *testing.T) {
+			t.Parallel()
+			run := func(command func(*cli) error) (string, error) {
+				stdout := &bytes.Buffer{}
+				err := command(&cli{
+					httpClient: server.Client(),
+					baseURL:    server.URL,
+					options:    options,
+					header:     http.Header{"Ping-Header": []string{"hello"}},
+					stdout:     stdout,
+					stderr:     io.Discard,
+				})
+				return stdout.String(), err
+			}
+			ctx := context.Background()
+			output, err := run(func(c *cli) error { return c.list(ctx, "") })
+			assert.Nil(t, err)
+			assert.Equal(t, output, pingv1connect.PingServiceName+"\n")
+
+			output, err = run(func(c *cli) error { return c.list(ctx, pingv1connect.PingServiceName) })
+			assert.Nil(t, err)
+			assert.True(t, strings.Contains(
+				output,
+				"connect.ping.v1.PingService.CumSum(stream connect.ping.v1.CumSumRequest) returns (stream connect.ping.v1.CumSumResponse)\n",
+			), assert.Sprintf("list output: %s", output))
+
+			output, err = run(func(c *cli) error {
+				return c.call(ctx, "connect.ping.v1.PingService/Ping", strings.NewReader(`{"number": 42}`))
+			})
+			assert.Nil(t, err)
+			assert.Equal(t, compact(output), `{"number":"42","text":"hello"}`)
+
+			output, err = run(func(c *cli) error {
+				return c.call(ctx, "connect.ping.v1.PingService.CountUp", strings.NewReader(`{"number": 2}`))
+			})
+			assert.Nil(t, err)
+			assert.Equal(t, compact(output), `{"number":"1"}{"number":"2"}`)
+
+			output, err = run(func(c *cli) error {
+				return c.call(ctx, "connect.ping.v1.PingService/Sum", strings.NewReader(`{"number": 1} {"number": 2}`))
+			})
+			assert.Nil(t, err)
+			assert.Equal(t, compact(output), `{"sum":"3"}`)
+
+			output, err = run(func(c *cli) error {
+				return c.call(ctx, "connect.ping.v1.PingService/CumSum", strings.NewReader(`{"number": 1} {"number": 2}`))
+			})
+			assert.Nil(t, err)
+			assert.Equal(t, compact(output), `{"sum":"1"}{"sum":"3"}`)
+
+			_, err = run(func(c *cli) error {
+				return c.call(ctx, "connect.ping.v1.PingService/Fail", strings.NewReader(`{"code": 9}`))
+			})
+			assert.Equal(t, connect.CodeOf(err), connect.CodeFailedPrecondition)
+
+			_, err = run(func(c *cli) error {
+				return c.call(ctx, "connect.ping.v1.PingService/Nope", strings.NewReader(`{}`))
+			})
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func compact(output string) string {
+	return strings.Join(strings.Fields(output), "")
+}
+
+type pingServer struct {
+	pingv1connect.UnimplementedPingServiceHandler
+}
+
+func (pingServer) Ping(_ context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+	return connect.NewResponse(&pingv1.PingResponse{
+		Number: request.Msg.Number,
+		Text:   request.Header().Get("Ping-Header"),
+	}), nil
+}
+
+func (pingServer) Fail(_ context.Context, request *connect.Request[pingv1.FailRequest]) (*connect.Response[pingv1.FailResponse], error) {
+	return nil, connect.NewError(connect.Code(request.Msg.Code), errors.New("failed"))
+}
+
+func (pingServer) Sum(_ context.Context, stream *connect.ClientStream[pingv1.SumRequest]) (*connect.Response[pingv1.SumResponse], error) {
+	var sum int64
+	for stream.Receive() {
+		sum += stream.Msg().Number
+	}
+	if err := stream.Err(); err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&pingv1.SumResponse{Sum: sum}), nil
+}
+
+func (pingServer) CountUp(
+	_ context.Context,
+	request *connect.Request[pingv1.CountUpRequest],
+	stream *connect.ServerStream[pingv1.CountUpResponse],
+) error {
+	for i := int64(1); i <= request.Msg.Number; i++ {
+		if err := stream.Send(&pingv1.CountUpResponse{Number: i}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (pingServer) CumSum(_ context.Context, stream *connect.BidiStream[pingv1.CumSumRequest, pingv1.CumSumResponse]) error {
+	var sum int64
+	for {
+		request, err := stream.Receive()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		sum += request.Number
+		if err := stream.Send(&pingv1.CumSumResponse{Sum: sum}); err != nil {
+			return err
+		}
+	}
+}
+
+// reflectionServer serves descriptors for the linked-in ping service.
+type reflectionServer struct {
+	reflectionv1alphaconnect.UnimplementedServerReflectionHandler
+}
+
+func (reflectionServer) ServerReflectionInfo(
+	_ context.Context,
+	stream *connect.BidiStream[reflectionv1alpha.ServerReflectionRequest, reflectionv1alpha.ServerReflectionResponse],
+) error {
+	for {
+		request, err := stream.Receive()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		response := &reflectionv1alpha.ServerReflectionResponse{OriginalRequest: request}
+		var file protoreflect.FileDescriptor
+		switch typed := request.MessageRequest.(type) {
+		case *reflectionv1alpha.ServerReflectionRequest_ListServices:
+			response.MessageResponse = &reflectionv1alpha.ServerReflectionResponse_ListServicesResponse{
+				ListServicesResponse: &reflectionv1alpha.ListServiceResponse{
+					Service: []*reflectionv1alpha.ServiceResponse{{Name: pingv1connect.PingServiceName}},
+				},
+			}
+		case *reflectionv1alpha.ServerReflectionRequest_FileContainingSymbol:
+			descriptor, findErr := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(typed.FileContainingSymbol))
+			if findErr == nil {
+				file = descriptor.ParentFile()
+			}
+		case *reflectionv1alpha.ServerReflectionRequest_FileByFilename:
+			file, _ = protoregistry.GlobalFiles.FindFileByPath(typed.FileByFilename)
+		}
+		if response.MessageResponse == nil {
+			if file == nil {
+				response.MessageResponse = &reflectionv1alpha.ServerReflectionResponse_ErrorResponse{
+					ErrorResponse: &reflectionv1alpha.ErrorResponse{ErrorCode: int32(connect.CodeNotFound), ErrorMessage: "not found"},
+				}
+			} else {
+				data, err := proto.Marshal(protodesc.ToFileDescriptorProto(file))
+				if err != nil {
+					return err
+				}
+				response.MessageResponse = &reflectionv1alpha.ServerReflectionResponse_FileDescriptorResponse{
+					FileDescriptorResponse: &reflectionv1alpha.FileDescriptorResponse{FileDescriptorProto: [][]byte{data}},
+				}
+			}
+		}
+		if err := stream.Send(response); err != nil {
+			return err
+		}
+	}
+}
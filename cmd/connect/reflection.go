@@ -0,0 +1,244 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/bufbuild/connect-go"
+	reflectionv1alpha "github.com/bufbuild/connect-go/internal/gen/connectext/grpc/reflection/v1alpha"
+	"github.com/bufbuild/connect-go/internal/gen/connectext/grpc/reflection/v1alpha/reflectionv1alphaconnect"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	// Servers often omit the well-known types from reflection responses, so
+	// link them in.
+	_ "google.golang.org/protobuf/types/known/anypb"
+	_ "google.golang.org/protobuf/types/known/durationpb"
+	_ "google.golang.org/protobuf/types/known/emptypb"
+	_ "google.golang.org/protobuf/types/known/fieldmaskpb"
+	_ "google.golang.org/protobuf/types/known/structpb"
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+	_ "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// reflectionClient resolves descriptors using a single server reflection
+// stream.
+type reflectionClient struct {
+	stream *connect.BidiStreamForClient[
+		reflectionv1alpha.ServerReflectionRequest,
+		reflectionv1alpha.ServerReflectionResponse,
+	]
+	files map[string]*descriptorpb.FileDescriptorProto
+}
+
+func newReflectionClient(ctx context.Context, c *cli) *reflectionClient {
+	client := reflectionv1alphaconnect.NewServerReflectionClient(c.httpClient, c.baseURL, c.options...)
+	stream := client.ServerReflectionInfo(ctx)
+	copyHeader(stream.RequestHeader(), c.header)
+	return &reflectionClient{
+		stream: stream,
+		files:  make(map[string]*descriptorpb.FileDescriptorProto),
+	}
+}
+
+func (r *reflectionClient) Close() {
+	_ = r.stream.CloseSend()
+	_ = r.stream.CloseReceive()
+}
+
+func (r *reflectionClient) send(
+	request *reflectionv1alpha.ServerReflectionRequest,
+) (*reflectionv1alpha.ServerReflectionResponse, error) {
+	if err := r.stream.Send(request); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	response, err := r.stream.Receive()
+	if err != nil {
+		return nil, fmt.Errorf("server reflection: %w", err)
+	}
+	if failure := response.GetErrorResponse(); failure != nil {
+		return nil, connect.NewError(connect.Code(failure.ErrorCode), errors.New(failure.ErrorMessage))
+	}
+	return response, nil
+}
+
+// listServices returns the names of the services the server exposes.
+func (r *reflectionClient) listServices() ([]string, error) {
+	response, err := r.send(&reflectionv1alpha.ServerReflectionRequest{
+		MessageRequest: &reflectionv1alpha.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	services := response.GetListServicesResponse().GetService()
+	names := make([]string, 0, len(services))
+	for _, service := range services {
+		names = append(names, service.Name)
+	}
+	return names, nil
+}
+
+// findService fetches the file declaring the service, along with its
+// transitive dependencies.
+func (r *reflectionClient) findService(name string) (protoreflect.ServiceDescriptor, error) {
+	response, err := r.send(&reflectionv1alpha.ServerReflectionRequest{
+		MessageRequest: &reflectionv1alpha.ServerReflectionRequest_FileContainingSymbol{
+			FileContainingSymbol: name,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := r.addFiles(response); err != nil {
+		return nil, err
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, file := range r.files {
+		if err := r.addDependencies(set, file, make(map[string]struct{})); err != nil {
+			return nil, err
+		}
+	}
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptors from server: %w", err)
+	}
+	descriptor, err := files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("service %s: %w", name, err)
+	}
+	service, ok := descriptor.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is a %T, not a service", name, descriptor)
+	}
+	return service, nil
+}
+
+func (r *reflectionClient) addFiles(response *reflectionv1alpha.ServerReflectionResponse) error {
+	for _, data := range response.GetFileDescriptorResponse().GetFileDescriptorProto() {
+		var file descriptorpb.FileDescriptorProto
+		if err := proto.Unmarshal(data, &file); err != nil {
+			return fmt.Errorf("invalid descriptor from server: %w", err)
+		}
+		r.files[file.GetName()] = &file
+	}
+	return nil
+}
+
+// addDependencies adds file and its dependencies to set, fetching any the
+// server hasn't sent yet.
+func (r *reflectionClient) addDependencies(
+	set *descriptorpb.FileDescriptorSet,
+	file *descriptorpb.FileDescriptorProto,
+	seen map[string]struct{},
+) error {
+	if _, ok := seen[file.GetName()]; ok {
+		return nil
+	}
+	seen[file.GetName()] = struct{}{}
+	for _, dependency := range file.Dependency {
+		dependencyFile, err := r.findFile(dependency)
+		if err != nil {
+			return err
+		}
+		if err := r.addDependencies(set, dependencyFile, seen); err != nil {
+			return err
+		}
+	}
+	for _, existing := range set.File {
+		if existing.GetName() == file.GetName() {
+			return nil
+		}
+	}
+	set.File = append(set.File, file)
+	return nil
+}
+
+func (r *reflectionClient) findFile(name string) (*descriptorpb.FileDescriptorProto, error) {
+	if file, ok := r.files[name]; ok {
+		return file, nil
+	}
+	response, err := r.send(&reflectionv1alpha.ServerReflectionRequest{
+		MessageRequest: &reflectionv1alpha.ServerReflectionRequest_FileByFilename{
+			FileByFilename: name,
+		},
+	})
+	if err == nil {
+		err = r.addFiles(response)
+	}
+	if file, ok := r.files[name]; ok {
+		return file, nil
+	}
+	if linked, linkedErr := protoregistry.GlobalFiles.FindFileByPath(name); linkedErr == nil {
+		file := protodesc.ToFileDescriptorProto(linked)
+		r.files[name] = file
+		return file, nil
+	}
+	if err == nil {
+		err = errors.New("not found")
+	}
+	return nil, fmt.Errorf("file %s: %w", name, err)
+}
+
+// list prints the services exposed by the server, or the methods of a
+// service.
+func (c *cli) list(ctx context.Context, service string) error {
+	reflection := newReflectionClient(ctx, c)
+	defer reflection.Close()
+	if service == "" {
+		names, err := reflection.listServices()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			fmt.Fprintln(c.stdout, name)
+		}
+		return nil
+	}
+	descriptor, err := reflection.findService(service)
+	if err != nil {
+		return err
+	}
+	methods := descriptor.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		fmt.Fprintln(c.stdout, methodSignature(methods.Get(i)))
+	}
+	return nil
+}
+
+func methodSignature(method protoreflect.MethodDescriptor) string {
+	input, output := string(method.Input().FullName()), string(method.Output().FullName())
+	if method.IsStreamingClient() {
+		input = "stream " + input
+	}
+	if method.IsStreamingServer() {
+		output = "stream " + output
+	}
+	return fmt.Sprintf("%s(%s) returns (%s)", method.FullName(), input, output)
+}
+
+// copyHeader adds the user's headers to a request.
+func copyHeader(into, from http.Header) {
+	for key, values := range from {
+		into[key] = append(into[key], values...)
+	}
+}
@@ -36,19 +36,85 @@
 //
 //	 gen/path/to/file.pb.go
 //	 gen/path/to/connectfoov1/file.connect.go
+//
+// Each generated package also gets a RegisterAll function, which mounts
+// implementations of all the package's services on a mux in one call. Since
+// the plugin only sees the files it's asked to generate, generate all of a
+// Protobuf package's files in a single invocation.
+//
+// Methods may set a default timeout for generated clients with the
+// connect.options.v1.default_timeout option, defined in
+// proto/connect/options/v1/options.proto:
+//
+//	rpc Foo(FooRequest) returns (FooResponse) {
+//	  option (connect.options.v1.default_timeout) = "5s";
+//	}
+//
+// Services may declare the messages they attach to errors as details with the
+// connect.options.v1.error_details option:
+//
+//	service StoreService {
+//	  option (connect.options.v1.error_details) = {
+//	    message: "OutOfStock"
+//	    code: "failed_precondition"
+//	  };
+//	}
+//
+// For each detail, the generated package gets a constructor, like
+// NewOutOfStockError, that returns a *connect.Error with the declared code and
+// the detail attached, and an extractor, like AsOutOfStock, that finds the
+// detail in an error returned by a client.
+//
+// The file_suffix parameter changes the suffix of generated files from
+// ".connect.go", and the build_tags parameter adds a //go:build constraint to
+// them. For example, to generate handlers only for server builds:
+//
+//	protoc --connect-go_out=gen --connect-go_opt=file_suffix=_server.connect.go,build_tags=server
+//
+// Methods annotated with the google.longrunning.operation_info option get a
+// note on their generated client method naming the operation's response and
+// metadata types. Serve and wait for such operations with the
+// github.com/bufbuild/connect-go/longrunning package.
+//
+// With the validate=true parameter (--connect-go_opt=validate=true with
+// protoc), generated clients and handlers enable connect.WithValidation for
+// methods whose request messages carry buf.validate constraints. Pass a
+// protovalidate validator to the generated constructors with
+// connect.WithValidator to check requests before they're sent and before
+// they reach the service implementation.
+//
+// With the graphql=true parameter, each service also gets a constructor, like
+// NewPingServiceGraphQLFields, that exposes the service's client as GraphQL
+// fields for the github.com/bufbuild/connect-go/graphql package.
+//
+// During migrations from grpc-go, the grpc_gateway=true parameter generates a
+// constructor for each service, like NewPingServiceGRPCClient, that adapts
+// the Connect client to the client interface generated by protoc-gen-go-grpc.
+// Existing grpc-gateway REST gateways can then register the adapter with
+// their generated RegisterPingServiceHandlerClient functions instead of a
+// grpc.ClientConn. The generated code imports google.golang.org/grpc, and it
+// assumes that protoc-gen-go-grpc's output is in the same package as the
+// Protobuf types.
 package main
 
 import (
 	"bytes"
+	"flag"
 	"fmt"
+	"go/build/constraint"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 	"unicode/utf8"
 
 	"github.com/bufbuild/connect-go"
+	optionsv1 "github.com/bufbuild/connect-go/proto/connect/options/v1"
 	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/types/descriptorpb"
 	"google.golang.org/protobuf/types/pluginpb"
 )
@@ -58,7 +124,17 @@ const (
 	errorsPackage  = protogen.GoImportPath("errors")
 	httpPackage    = protogen.GoImportPath("net/http")
 	stringsPackage = protogen.GoImportPath("strings")
+	timePackage    = protogen.GoImportPath("time")
 	connectPackage = protogen.GoImportPath("github.com/bufbuild/connect-go")
+	anypbPackage   = protogen.GoImportPath("google.golang.org/protobuf/types/known/anypb")
+	graphqlPackage = protogen.GoImportPath("github.com/bufbuild/connect-go/graphql")
+	ioPackage      = protogen.GoImportPath("io")
+	protoPackage   = protogen.GoImportPath("google.golang.org/protobuf/proto")
+
+	grpcPackage         = protogen.GoImportPath("google.golang.org/grpc")
+	grpcCodesPackage    = protogen.GoImportPath("google.golang.org/grpc/codes")
+	grpcMetadataPackage = protogen.GoImportPath("google.golang.org/grpc/metadata")
+	grpcStatusPackage   = protogen.GoImportPath("google.golang.org/grpc/status")
 
 	generatedFilenameExtension = ".connect.go"
 	generatedPackageSuffix     = "connect"
@@ -66,6 +142,13 @@ const (
 	usage = "See https://connect.build/docs/go/getting-started to learn how to use this plugin.\n\nFlags:\n  -h, --help\tPrint this help and exit.\n      --version\tPrint the version and exit."
 
 	commentWidth = 97 // leave room for "// "
+
+	// validateExtensionNumber is the field number of the buf.validate.message,
+	// buf.validate.oneof, and buf.validate.field options.
+	validateExtensionNumber = 1159
+	// operationInfoExtensionNumber is the field number of the
+	// google.longrunning.operation_info method option.
+	operationInfoExtensionNumber = 1049
 )
 
 func main() {
@@ -81,23 +164,78 @@ func main() {
 		fmt.Fprintln(os.Stderr, usage)
 		os.Exit(1)
 	}
-	protogen.Options{}.Run(
+	var flags flag.FlagSet
+	var opts options
+	flags.BoolVar(&opts.validate, "validate", false, "enable validation for methods with buf.validate constraints")
+	flags.StringVar(&opts.fileSuffix, "file_suffix", generatedFilenameExtension, "suffix of generated file names")
+	flags.StringVar(&opts.buildTags, "build_tags", "", "build constraint for generated files")
+	flags.BoolVar(&opts.graphql, "graphql", false, "generate GraphQL fields backed by clients")
+	flags.BoolVar(&opts.grpcGateway, "grpc_gateway", false, "generate grpc-gateway client adapters")
+	protogen.Options{ParamFunc: flags.Set}.Run(
 		func(plugin *protogen.Plugin) error {
-			plugin.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
-			for _, file := range plugin.Files {
-				if file.Generate {
-					generate(plugin, file)
-				}
-			}
-			return nil
+			return run(plugin, opts)
 		},
 	)
 }
 
-func generate(plugin *protogen.Plugin, file *protogen.File) {
+// run generates code for all the files the plugin was asked to generate.
+func run(plugin *protogen.Plugin, opts options) error {
+	plugin.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+	if err := opts.check(); err != nil {
+		return err
+	}
+	packages := newGeneratedPackages()
+	for _, file := range plugin.Files {
+		if file.Generate {
+			generate(plugin, file, opts, packages)
+		}
+	}
+	for _, importPath := range packages.order {
+		generateRegistrar(plugin, importPath, packages.byPath[importPath], opts)
+	}
+	return nil
+}
+
+// options are the plugin's parameters.
+type options struct {
+	validate    bool
+	graphql     bool
+	grpcGateway bool
+	fileSuffix  string
+	buildTags   string
+}
+
+func (o options) check() error {
+	if !strings.HasSuffix(o.fileSuffix, ".go") {
+		return fmt.Errorf("file_suffix must end in .go, got %q", o.fileSuffix)
+	}
+	if o.buildTags != "" {
+		if _, err := constraint.Parse("//go:build " + o.buildTags); err != nil {
+			return fmt.Errorf("invalid build_tags %q: %w", o.buildTags, err)
+		}
+	}
+	return nil
+}
+
+func generate(plugin *protogen.Plugin, file *protogen.File, opts options, packages *generatedPackages) {
 	if len(file.Services) == 0 {
 		return
 	}
+	var details []errorDetail
+	for _, service := range file.Services {
+		for _, method := range service.Methods {
+			if _, err := defaultTimeout(method); err != nil {
+				plugin.Error(err)
+				return
+			}
+		}
+		serviceDetails, err := errorDetails(plugin, service)
+		if err != nil {
+			plugin.Error(err)
+			return
+		}
+		details = append(details, serviceDetails...)
+	}
 	file.GoPackageName += generatedPackageSuffix
 
 	dir := filepath.Dir(file.GeneratedFilenamePrefix)
@@ -107,21 +245,127 @@ func generate(plugin *protogen.Plugin, file *protogen.File) {
 		string(file.GoPackageName),
 		base,
 	)
+	importPath := protogen.GoImportPath(path.Join(
+		string(file.GoImportPath),
+		string(file.GoPackageName),
+	))
 	generatedFile := plugin.NewGeneratedFile(
-		file.GeneratedFilenamePrefix+generatedFilenameExtension,
-		protogen.GoImportPath(path.Join(
-			string(file.GoImportPath),
-			string(file.GoPackageName),
-		)),
+		file.GeneratedFilenamePrefix+opts.fileSuffix,
+		importPath,
 	)
-	generatePreamble(generatedFile, file)
+	pkg := packages.add(importPath, file)
+	generatePreamble(generatedFile, file, opts.buildTags)
 	generateServiceNameConstants(generatedFile, file.Services)
 	for _, service := range file.Services {
-		generateService(generatedFile, file, service)
+		generateService(generatedFile, file, service, opts)
+	}
+	for _, detail := range details {
+		// Services in several files of a package may declare the same error
+		// detail, but we should only generate its helpers once per package.
+		if pkg.errorDetails[detail.message.Desc.FullName()] {
+			continue
+		}
+		pkg.errorDetails[detail.message.Desc.FullName()] = true
+		generateErrorDetail(generatedFile, detail)
+	}
+}
+
+// generatedPackages tracks the Go packages generated so far, so that we can
+// generate package-level code once all the files in a package are done.
+type generatedPackages struct {
+	order  []protogen.GoImportPath
+	byPath map[protogen.GoImportPath]*generatedPackage
+}
+
+type generatedPackage struct {
+	name         protogen.GoPackageName
+	dir          string
+	files        []*protogen.File
+	errorDetails map[protoreflect.FullName]bool
+}
+
+func newGeneratedPackages() *generatedPackages {
+	return &generatedPackages{byPath: make(map[protogen.GoImportPath]*generatedPackage)}
+}
+
+// add records a generated file, returning its package.
+func (p *generatedPackages) add(importPath protogen.GoImportPath, file *protogen.File) *generatedPackage {
+	pkg, ok := p.byPath[importPath]
+	if !ok {
+		pkg = &generatedPackage{
+			name:         file.GoPackageName,
+			dir:          filepath.Dir(file.GeneratedFilenamePrefix),
+			errorDetails: make(map[protoreflect.FullName]bool),
+		}
+		p.byPath[importPath] = pkg
+		p.order = append(p.order, importPath)
 	}
+	pkg.files = append(pkg.files, file)
+	return pkg
 }
 
-func generatePreamble(g *protogen.GeneratedFile, file *protogen.File) {
+// generateRegistrar generates a RegisterAll function that mounts handlers for
+// all the services in a package. Since the plugin only sees the files it's
+// asked to generate, all of a package's files must be generated together.
+func generateRegistrar(plugin *protogen.Plugin, importPath protogen.GoImportPath, pkg *generatedPackage, opts options) {
+	g := plugin.NewGeneratedFile(
+		filepath.Join(pkg.dir, string(pkg.name)+opts.fileSuffix),
+		importPath,
+	)
+	paths := make([]string, 0, len(pkg.files))
+	for _, file := range pkg.files {
+		paths = append(paths, file.Desc.Path())
+	}
+	generateBuildConstraint(g, opts.buildTags)
+	g.P("// Code generated by ", filepath.Base(os.Args[0]), ". DO NOT EDIT.")
+	g.P("//")
+	g.P("// Source: ", strings.Join(paths, ", "))
+	g.P()
+	g.P("package ", pkg.name)
+	g.P()
+	protoPackage := pkg.files[0].Desc.Package()
+	wrapComments(g, "Handlers holds implementations of the services in the ", protoPackage,
+		" package. Use RegisterAll to mount them.")
+	g.P("type Handlers struct {")
+	for _, file := range pkg.files {
+		for _, service := range file.Services {
+			names := newNames(service)
+			g.P(service.GoName, " ", names.Server)
+		}
+	}
+	g.P("}")
+	g.P()
+	wrapComments(g, "RegisterAll mounts handlers for the services in the ", protoPackage,
+		" package on the mux, which is usually an *http.ServeMux or a *connect.ServeMux. ",
+		"It skips services without an implementation. The options apply to all the handlers.")
+	g.P("func RegisterAll(mux interface{ Handle(string, ", httpPackage.Ident("Handler"), ") }, ",
+		"handlers Handlers, opts ...", connectPackage.Ident("HandlerOption"), ") {")
+	for _, file := range pkg.files {
+		for _, service := range file.Services {
+			names := newNames(service)
+			g.P("if handlers.", service.GoName, " != nil {")
+			g.P("mux.Handle(", names.ServerConstructor, "(handlers.", service.GoName, ", opts...))")
+			g.P("}")
+		}
+	}
+	g.P("}")
+	if opts.grpcGateway {
+		g.P()
+		generateGRPCGatewayHelpers(g)
+	}
+}
+
+// generateBuildConstraint adds a //go:build line, if the user asked for one.
+func generateBuildConstraint(g *protogen.GeneratedFile, buildTags string) {
+	if buildTags == "" {
+		return
+	}
+	g.P("//go:build ", buildTags)
+	g.P()
+}
+
+func generatePreamble(g *protogen.GeneratedFile, file *protogen.File, buildTags string) {
+	generateBuildConstraint(g, buildTags)
 	g.P("// Code generated by ", filepath.Base(os.Args[0]), ". DO NOT EDIT.")
 	g.P("//")
 	if file.Proto.GetOptions().GetDeprecated() {
@@ -153,13 +397,19 @@ func generateServiceNameConstants(g *protogen.GeneratedFile, services []*protoge
 	g.P()
 }
 
-func generateService(g *protogen.GeneratedFile, file *protogen.File, service *protogen.Service) {
+func generateService(g *protogen.GeneratedFile, file *protogen.File, service *protogen.Service, opts options) {
 	names := newNames(service)
 	generateClientInterface(g, service, names)
-	generateClientImplementation(g, service, names)
+	generateClientImplementation(g, service, names, opts.validate)
 	generateServerInterface(g, service, names)
-	generateServerConstructor(g, service, names)
+	generateServerConstructor(g, service, names, opts.validate)
 	generateUnimplementedServerImplementation(g, service, names)
+	if opts.graphql {
+		generateGraphQLFields(g, service, names)
+	}
+	if opts.grpcGateway {
+		generateGRPCGatewayClient(g, file, service, names)
+	}
 }
 
 func generateClientInterface(g *protogen.GeneratedFile, service *protogen.Service, names names) {
@@ -183,7 +433,7 @@ func generateClientInterface(g *protogen.GeneratedFile, service *protogen.Servic
 	g.P()
 }
 
-func generateClientImplementation(g *protogen.GeneratedFile, service *protogen.Service, names names) {
+func generateClientImplementation(g *protogen.GeneratedFile, service *protogen.Service, names names, validate bool) {
 	clientOption := connectPackage.Ident("ClientOption")
 
 	// Client constructor.
@@ -211,7 +461,27 @@ func generateClientImplementation(g *protogen.GeneratedFile, service *protogen.S
 		)
 		g.P("httpClient,")
 		g.P(`baseURL + "`, procedureName(method), `",`)
-		g.P("opts...,")
+		// Validated in generate.
+		timeout, _ := defaultTimeout(method)
+		validated := validate && hasValidateConstraints(method.Input)
+		idempotency := idempotencyLevel(method)
+		if timeout > 0 || validated || idempotency != "" {
+			// Callers' options take precedence over the defaults.
+			g.P(connectPackage.Ident("WithClientOptions"), "(")
+			if timeout > 0 {
+				g.P(connectPackage.Ident("WithDefaultTimeout"), "(", durationLiteral(g, timeout), "),")
+			}
+			if validated {
+				g.P(connectPackage.Ident("WithValidation"), "(),")
+			}
+			if idempotency != "" {
+				g.P(connectPackage.Ident("WithIdempotency"), "(", connectPackage.Ident(idempotency), "),")
+			}
+			g.P(connectPackage.Ident("WithClientOptions"), "(opts...),")
+			g.P("),")
+		} else {
+			g.P("opts...,")
+		}
 		g.P("),")
 	}
 	g.P("}")
@@ -237,6 +507,15 @@ func generateClientMethod(g *protogen.GeneratedFile, service *protogen.Service,
 	isStreamingClient := method.Desc.IsStreamingClient()
 	isStreamingServer := method.Desc.IsStreamingServer()
 	wrapComments(g, method.GoName, " calls ", method.Desc.FullName(), ".")
+	if response, metadata, ok := operationInfo(method.Desc.Options()); ok {
+		if metadata != "" {
+			response += " and whose metadata is a " + metadata
+		}
+		g.P("//")
+		wrapComments(g, "It starts a long-running operation whose response is a ", response,
+			". Wait for the operation with a longrunning.Client, then unpack its response ",
+			"with longrunning.Result.")
+	}
 	if isDeprecatedMethod(method) {
 		g.P("//")
 		deprecated(g)
@@ -308,7 +587,7 @@ func generateServerInterface(g *protogen.GeneratedFile, service *protogen.Servic
 	g.P()
 }
 
-func generateServerConstructor(g *protogen.GeneratedFile, service *protogen.Service, names names) {
+func generateServerConstructor(g *protogen.GeneratedFile, service *protogen.Service, names names, validate bool) {
 	wrapComments(g, names.ServerConstructor, " builds an HTTP handler from the service implementation.",
 		" It returns the path on which to mount the handler and the handler itself.")
 	g.P("//")
@@ -337,7 +616,21 @@ func generateServerConstructor(g *protogen.GeneratedFile, service *protogen.Serv
 		}
 		g.P(`"`, procedureName(method), `",`)
 		g.P("svc.", method.GoName, ",")
-		g.P("opts...,")
+		validated := validate && hasValidateConstraints(method.Input)
+		idempotency := idempotencyLevel(method)
+		if validated || idempotency != "" {
+			g.P(connectPackage.Ident("WithHandlerOptions"), "(")
+			if validated {
+				g.P(connectPackage.Ident("WithValidation"), "(),")
+			}
+			if idempotency != "" {
+				g.P(connectPackage.Ident("WithIdempotency"), "(", connectPackage.Ident(idempotency), "),")
+			}
+			g.P(connectPackage.Ident("WithHandlerOptions"), "(opts...),")
+			g.P("),")
+		} else {
+			g.P("opts...,")
+		}
 		g.P("))")
 	}
 	g.P(`return "/`, reflectionName(service), `/", mux`)
@@ -366,6 +659,379 @@ func generateUnimplementedServerImplementation(g *protogen.GeneratedFile, servic
 	g.P()
 }
 
+func generateGraphQLFields(g *protogen.GeneratedFile, service *protogen.Service, names names) {
+	field := graphqlPackage.Ident("Field")
+	wrapComments(g, names.GraphQLFields, " returns GraphQL root fields for the ", service.Desc.FullName(),
+		" service, backed by client. Pass them to graphql.NewSchema to serve them. Idempotent unary methods are",
+		" queries, other unary methods are mutations, and server streaming methods are subscriptions; client",
+		" and bidirectional streaming methods are omitted.")
+	if isDeprecatedService(service) {
+		g.P("//")
+		deprecated(g)
+	}
+	g.P("func ", names.GraphQLFields, "(client ", names.Client, ") []", field, " {")
+	g.P("return []", field, "{")
+	for _, method := range service.Methods {
+		var constructor string
+		switch {
+		case method.Desc.IsStreamingClient():
+			continue
+		case method.Desc.IsStreamingServer():
+			constructor = "NewSubscription"
+		case isIdempotentMethod(method):
+			constructor = "NewQuery"
+		default:
+			constructor = "NewMutation"
+		}
+		g.P(graphqlPackage.Ident(constructor), "(", fmt.Sprintf("%q", unexport(method.GoName)), ", client.", method.GoName, "),")
+	}
+	g.P("}")
+	g.P("}")
+	g.P()
+}
+
+func generateGRPCGatewayClient(g *protogen.GeneratedFile, file *protogen.File, service *protogen.Service, names names) {
+	grpcClient := file.GoImportPath.Ident(service.GoName + "Client")
+	callOption := grpcPackage.Ident("CallOption")
+	wrapComments(g, names.GRPCClientConstructor, " adapts a Connect client for the ", service.Desc.FullName(),
+		" service to the ", grpcClient.GoName, " interface generated by protoc-gen-go-grpc. Pass the result to",
+		" grpc-gateway's Register", service.GoName, "HandlerClient to back an existing REST gateway with",
+		" Connect rather than a grpc.ClientConn. Metadata that the gateway forwards from HTTP requests",
+		" becomes request headers, response headers and trailers are returned through the grpc.Header and",
+		" grpc.Trailer call options, and errors become gRPC status errors with the same code, message, and",
+		" details.")
+	if isDeprecatedService(service) {
+		g.P("//")
+		deprecated(g)
+	}
+	g.P("func ", names.GRPCClientConstructor, "(client ", names.Client, ") ", grpcClient, " {")
+	g.P("return &", names.GRPCClientImpl, "{client: client}")
+	g.P("}")
+	g.P()
+	g.P("type ", names.GRPCClientImpl, " struct {")
+	g.P("client ", names.Client)
+	g.P("}")
+	g.P()
+	for _, method := range service.Methods {
+		input, output := g.QualifiedGoIdent(method.Input.GoIdent), g.QualifiedGoIdent(method.Output.GoIdent)
+		streamInterface := file.GoImportPath.Ident(service.GoName + "_" + method.GoName + "Client")
+		streamImpl := unexport(service.GoName) + method.GoName + "GRPCClient"
+		switch {
+		case !method.Desc.IsStreamingClient() && !method.Desc.IsStreamingServer():
+			g.P("func (c *", names.GRPCClientImpl, ") ", method.GoName, "(ctx ", contextPackage.Ident("Context"),
+				", in *", input, ", opts ...", callOption, ") (*", output, ", error) {")
+			g.P("request := ", connectPackage.Ident("NewRequest"), "(in)")
+			g.P("grpcGatewayOutgoingHeader(ctx, request.Header())")
+			g.P("response, err := c.client.", method.GoName, "(ctx, request)")
+			g.P("if err != nil {")
+			g.P("return nil, grpcGatewayError(opts, err)")
+			g.P("}")
+			g.P("grpcGatewaySetMetadata(opts, response.Header(), response.Trailer())")
+			g.P("return response.Msg, nil")
+			g.P("}")
+			g.P()
+			continue
+		case method.Desc.IsStreamingClient() && method.Desc.IsStreamingServer():
+			g.P("func (c *", names.GRPCClientImpl, ") ", method.GoName, "(ctx ", contextPackage.Ident("Context"),
+				", opts ...", callOption, ") (", streamInterface, ", error) {")
+			g.P("stream := c.client.", method.GoName, "(ctx)")
+			g.P("grpcGatewayOutgoingHeader(ctx, stream.RequestHeader())")
+			g.P("return &", streamImpl, "{ctx: ctx, stream: stream}, nil")
+			g.P("}")
+			g.P()
+			g.P("type ", streamImpl, " struct {")
+			g.P("ctx ", contextPackage.Ident("Context"))
+			g.P("stream *", connectPackage.Ident("BidiStreamForClient"), "[", input, ", ", output, "]")
+			g.P("}")
+			g.P()
+			g.P("func (s *", streamImpl, ") Send(msg *", input, ") error {")
+			g.P("return grpcGatewayError(nil, s.stream.Send(msg))")
+			g.P("}")
+			g.P()
+			g.P("func (s *", streamImpl, ") Recv() (*", output, ", error) {")
+			g.P("msg, err := s.stream.Receive()")
+			g.P("if err != nil {")
+			g.P("return nil, grpcGatewayError(nil, err)")
+			g.P("}")
+			g.P("return msg, nil")
+			g.P("}")
+			g.P()
+			g.P("func (s *", streamImpl, ") CloseSend() error {")
+			g.P("return grpcGatewayError(nil, s.stream.CloseSend())")
+			g.P("}")
+			g.P()
+			g.P("func (s *", streamImpl, ") Header() (", grpcMetadataPackage.Ident("MD"), ", error) {")
+			g.P("return grpcGatewayMetadata(s.stream.ResponseHeader()), nil")
+			g.P("}")
+			g.P()
+			g.P("func (s *", streamImpl, ") Trailer() ", grpcMetadataPackage.Ident("MD"), " {")
+			g.P("return grpcGatewayMetadata(s.stream.ResponseTrailer())")
+			g.P("}")
+			g.P()
+		case method.Desc.IsStreamingClient():
+			g.P("func (c *", names.GRPCClientImpl, ") ", method.GoName, "(ctx ", contextPackage.Ident("Context"),
+				", opts ...", callOption, ") (", streamInterface, ", error) {")
+			g.P("stream := c.client.", method.GoName, "(ctx)")
+			g.P("grpcGatewayOutgoingHeader(ctx, stream.RequestHeader())")
+			g.P("return &", streamImpl, "{ctx: ctx, stream: stream}, nil")
+			g.P("}")
+			g.P()
+			g.P("type ", streamImpl, " struct {")
+			g.P("ctx ", contextPackage.Ident("Context"))
+			g.P("stream *", connectPackage.Ident("ClientStreamForClient"), "[", input, ", ", output, "]")
+			g.P("header ", httpPackage.Ident("Header"))
+			g.P("trailer ", httpPackage.Ident("Header"))
+			g.P("}")
+			g.P()
+			g.P("func (s *", streamImpl, ") Send(msg *", input, ") error {")
+			g.P("return grpcGatewayError(nil, s.stream.Send(msg))")
+			g.P("}")
+			g.P()
+			g.P("func (s *", streamImpl, ") CloseAndRecv() (*", output, ", error) {")
+			g.P("response, err := s.stream.CloseAndReceive()")
+			g.P("if err != nil {")
+			g.P("return nil, grpcGatewayError(nil, err)")
+			g.P("}")
+			g.P("s.header, s.trailer = response.Header(), response.Trailer()")
+			g.P("return response.Msg, nil")
+			g.P("}")
+			g.P()
+			g.P("func (s *", streamImpl, ") CloseSend() error {")
+			g.P("return nil")
+			g.P("}")
+			g.P()
+			g.P("// Header returns the response headers, which are only available after CloseAndRecv.")
+			g.P("func (s *", streamImpl, ") Header() (", grpcMetadataPackage.Ident("MD"), ", error) {")
+			g.P("return grpcGatewayMetadata(s.header), nil")
+			g.P("}")
+			g.P()
+			g.P("func (s *", streamImpl, ") Trailer() ", grpcMetadataPackage.Ident("MD"), " {")
+			g.P("return grpcGatewayMetadata(s.trailer)")
+			g.P("}")
+			g.P()
+		default:
+			g.P("func (c *", names.GRPCClientImpl, ") ", method.GoName, "(ctx ", contextPackage.Ident("Context"),
+				", in *", input, ", opts ...", callOption, ") (", streamInterface, ", error) {")
+			g.P("request := ", connectPackage.Ident("NewRequest"), "(in)")
+			g.P("grpcGatewayOutgoingHeader(ctx, request.Header())")
+			g.P("stream, err := c.client.", method.GoName, "(ctx, request)")
+			g.P("if err != nil {")
+			g.P("return nil, grpcGatewayError(opts, err)")
+			g.P("}")
+			g.P("return &", streamImpl, "{ctx: ctx, stream: stream}, nil")
+			g.P("}")
+			g.P()
+			g.P("type ", streamImpl, " struct {")
+			g.P("ctx ", contextPackage.Ident("Context"))
+			g.P("stream *", connectPackage.Ident("ServerStreamForClient"), "[", output, "]")
+			g.P("}")
+			g.P()
+			g.P("func (s *", streamImpl, ") Recv() (*", output, ", error) {")
+			g.P("if s.stream.Receive() {")
+			g.P("// Unlike Connect, gRPC returns a new message from each call.")
+			g.P("msg, _ := ", protoPackage.Ident("Clone"), "(s.stream.Msg()).(*", output, ")")
+			g.P("return msg, nil")
+			g.P("}")
+			g.P("if err := s.stream.Err(); err != nil {")
+			g.P("return nil, grpcGatewayError(nil, err)")
+			g.P("}")
+			g.P("return nil, ", ioPackage.Ident("EOF"))
+			g.P("}")
+			g.P()
+			g.P("func (s *", streamImpl, ") CloseSend() error {")
+			g.P("return nil")
+			g.P("}")
+			g.P()
+			g.P("func (s *", streamImpl, ") Header() (", grpcMetadataPackage.Ident("MD"), ", error) {")
+			g.P("return grpcGatewayMetadata(s.stream.ResponseHeader()), nil")
+			g.P("}")
+			g.P()
+			g.P("func (s *", streamImpl, ") Trailer() ", grpcMetadataPackage.Ident("MD"), " {")
+			g.P("return grpcGatewayMetadata(s.stream.ResponseTrailer())")
+			g.P("}")
+			g.P()
+		}
+		// The rest of grpc.ClientStream is the same for all streaming methods.
+		g.P("func (s *", streamImpl, ") Context() ", contextPackage.Ident("Context"), " {")
+		g.P("return s.ctx")
+		g.P("}")
+		g.P()
+		g.P("func (s *", streamImpl, ") SendMsg(m any) error {")
+		if method.Desc.IsStreamingClient() {
+			g.P("msg, ok := m.(*", input, ")")
+			g.P("if !ok {")
+			g.P("return ", grpcStatusPackage.Ident("Errorf"), "(", grpcCodesPackage.Ident("Internal"), `, "expected *`,
+				method.Input.GoIdent.GoName, `, got %T", m)`)
+			g.P("}")
+			g.P("return s.Send(msg)")
+		} else {
+			g.P("return ", grpcStatusPackage.Ident("Error"), "(", grpcCodesPackage.Ident("Internal"), `, "`,
+				method.Desc.FullName(), ` doesn't stream requests")`)
+		}
+		g.P("}")
+		g.P()
+		g.P("func (s *", streamImpl, ") RecvMsg(m any) error {")
+		if method.Desc.IsStreamingServer() {
+			g.P("dst, ok := m.(", protoPackage.Ident("Message"), ")")
+			g.P("if !ok {")
+			g.P("return ", grpcStatusPackage.Ident("Errorf"), "(", grpcCodesPackage.Ident("Internal"),
+				`, "expected a Protobuf message, got %T", m)`)
+			g.P("}")
+			g.P("msg, err := s.Recv()")
+			g.P("if err != nil {")
+			g.P("return err")
+			g.P("}")
+			g.P(protoPackage.Ident("Reset"), "(dst)")
+			g.P(protoPackage.Ident("Merge"), "(dst, msg)")
+			g.P("return nil")
+		} else {
+			g.P("dst, ok := m.(", protoPackage.Ident("Message"), ")")
+			g.P("if !ok {")
+			g.P("return ", grpcStatusPackage.Ident("Errorf"), "(", grpcCodesPackage.Ident("Internal"),
+				`, "expected a Protobuf message, got %T", m)`)
+			g.P("}")
+			g.P("msg, err := s.CloseAndRecv()")
+			g.P("if err != nil {")
+			g.P("return err")
+			g.P("}")
+			g.P(protoPackage.Ident("Reset"), "(dst)")
+			g.P(protoPackage.Ident("Merge"), "(dst, msg)")
+			g.P("return nil")
+		}
+		g.P("}")
+		g.P()
+	}
+}
+
+// generateGRPCGatewayHelpers generates the package-level helpers shared by
+// the grpc-gateway adapters of all the package's services.
+func generateGRPCGatewayHelpers(g *protogen.GeneratedFile) {
+	metadataMD := grpcMetadataPackage.Ident("MD")
+	callOption := grpcPackage.Ident("CallOption")
+	g.P("// grpcGatewayOutgoingHeader copies the metadata grpc-gateway forwards from HTTP requests to a")
+	g.P("// Connect request's headers.")
+	g.P("func grpcGatewayOutgoingHeader(ctx ", contextPackage.Ident("Context"), ", header ", httpPackage.Ident("Header"), ") {")
+	g.P("md, _ := ", grpcMetadataPackage.Ident("FromOutgoingContext"), "(ctx)")
+	g.P("for key, values := range md {")
+	g.P("for _, value := range values {")
+	g.P("if ", stringsPackage.Ident("HasSuffix"), `(key, "-bin") {`)
+	g.P("value = ", connectPackage.Ident("EncodeBinaryHeader"), "([]byte(value))")
+	g.P("}")
+	g.P("header.Add(key, value)")
+	g.P("}")
+	g.P("}")
+	g.P("}")
+	g.P()
+	g.P("// grpcGatewayMetadata converts Connect headers or trailers to gRPC metadata.")
+	g.P("func grpcGatewayMetadata(header ", httpPackage.Ident("Header"), ") ", metadataMD, " {")
+	g.P("md := make(", metadataMD, ", len(header))")
+	g.P("for key, values := range header {")
+	g.P("key = ", stringsPackage.Ident("ToLower"), "(key)")
+	g.P("for _, value := range values {")
+	g.P("if ", stringsPackage.Ident("HasSuffix"), `(key, "-bin") {`)
+	g.P("if decoded, err := ", connectPackage.Ident("DecodeBinaryHeader"), "(value); err == nil {")
+	g.P("value = string(decoded)")
+	g.P("}")
+	g.P("}")
+	g.P("md[key] = append(md[key], value)")
+	g.P("}")
+	g.P("}")
+	g.P("return md")
+	g.P("}")
+	g.P()
+	g.P("// grpcGatewaySetMetadata fills in the grpc.Header and grpc.Trailer call options, which")
+	g.P("// grpc-gateway uses to forward response metadata.")
+	g.P("func grpcGatewaySetMetadata(opts []", callOption, ", header, trailer ", httpPackage.Ident("Header"), ") {")
+	g.P("for _, opt := range opts {")
+	g.P("switch opt := opt.(type) {")
+	g.P("case ", grpcPackage.Ident("HeaderCallOption"), ":")
+	g.P("*opt.HeaderAddr = grpcGatewayMetadata(header)")
+	g.P("case ", grpcPackage.Ident("TrailerCallOption"), ":")
+	g.P("*opt.TrailerAddr = grpcGatewayMetadata(trailer)")
+	g.P("}")
+	g.P("}")
+	g.P("}")
+	g.P()
+	g.P("// grpcGatewayError converts an error from a Connect client to a gRPC status error, which")
+	g.P("// grpc-gateway maps to an HTTP status. The status keeps the error's code, message, and")
+	g.P("// details, and the error's metadata is returned as trailers.")
+	g.P("func grpcGatewayError(opts []", callOption, ", err error) error {")
+	g.P("if err == nil {")
+	g.P("return nil")
+	g.P("}")
+	g.P("var connectErr *", connectPackage.Ident("Error"))
+	g.P("if !", errorsPackage.Ident("As"), "(err, &connectErr) {")
+	g.P("if ", errorsPackage.Ident("Is"), "(err, ", ioPackage.Ident("EOF"), ") {")
+	g.P("return ", ioPackage.Ident("EOF"))
+	g.P("}")
+	g.P("return ", grpcStatusPackage.Ident("Error"), "(", grpcCodesPackage.Ident("Unknown"), ", err.Error())")
+	g.P("}")
+	g.P("if connectErr.Code() == ", connectPackage.Ident("CodeUnknown"), " && len(connectErr.Details()) == 0 && ",
+		errorsPackage.Ident("Is"), "(connectErr, ", ioPackage.Ident("EOF"), ") {")
+	g.P("// Connect clients report the end of a stream as an unknown error wrapping io.EOF, and")
+	g.P("// gRPC streams signal it with a bare io.EOF.")
+	g.P("return ", ioPackage.Ident("EOF"))
+	g.P("}")
+	g.P("grpcGatewaySetMetadata(opts, nil, connectErr.Meta())")
+	g.P("details := make([]*", anypbPackage.Ident("Any"), ", 0, len(connectErr.Details()))")
+	g.P("for _, detail := range connectErr.Details() {")
+	g.P("anyDetail, ok := detail.(*", anypbPackage.Ident("Any"), ")")
+	g.P("if !ok {")
+	g.P("var err error")
+	g.P("if anyDetail, err = ", anypbPackage.Ident("New"), "(detail); err != nil {")
+	g.P("return ", grpcStatusPackage.Ident("Errorf"), "(", grpcCodesPackage.Ident("Internal"),
+		`, "marshal error detail: %v", err)`)
+	g.P("}")
+	g.P("}")
+	g.P("details = append(details, anyDetail)")
+	g.P("}")
+	g.P("grpcStatus := ", grpcStatusPackage.Ident("New"), "(", grpcCodesPackage.Ident("Code"),
+		"(connectErr.Code()), connectErr.Message()).Proto()")
+	g.P("grpcStatus.Details = details")
+	g.P("return ", grpcStatusPackage.Ident("FromProto"), "(grpcStatus).Err()")
+	g.P("}")
+}
+
+func generateErrorDetail(g *protogen.GeneratedFile, detail errorDetail) {
+	base := detail.message.GoIdent.GoName
+	constructor := "New" + base
+	if !strings.HasSuffix(base, "Error") {
+		constructor += "Error"
+	}
+	extractor := "As" + base
+	code := connectPackage.Ident(codeIdentName(detail.code))
+
+	wrapComments(g, constructor, " returns a *connect.Error with ", code.GoName, " and the ",
+		detail.message.Desc.FullName(), " detail attached.")
+	g.P("func ", constructor, "(detail *", detail.message.GoIdent, ") *", connectPackage.Ident("Error"), " {")
+	g.P("anyDetail, err := ", anypbPackage.Ident("New"), "(detail)")
+	g.P("if err != nil {")
+	g.P("return ", connectPackage.Ident("NewError"), "(", connectPackage.Ident("CodeInternal"), ", err)")
+	g.P("}")
+	g.P("connectErr := ", connectPackage.Ident("NewError"), "(", code, ", nil)")
+	g.P("connectErr.AddDetail(anyDetail)")
+	g.P("return connectErr")
+	g.P("}")
+	g.P()
+
+	wrapComments(g, extractor, " returns the ", detail.message.Desc.FullName(),
+		" detail attached to err, if any.")
+	g.P("func ", extractor, "(err error) (*", detail.message.GoIdent, ", bool) {")
+	g.P("var connectErr *", connectPackage.Ident("Error"))
+	g.P("if !", errorsPackage.Ident("As"), "(err, &connectErr) {")
+	g.P("return nil, false")
+	g.P("}")
+	g.P("for _, d := range connectErr.Details() {")
+	g.P("detail := &", detail.message.GoIdent, "{}")
+	g.P("if d.UnmarshalTo(detail) == nil {")
+	g.P("return detail, true")
+	g.P("}")
+	g.P("}")
+	g.P("return nil, false")
+	g.P("}")
+	g.P()
+}
+
 func serverSignature(g *protogen.GeneratedFile, method *protogen.Method) string {
 	return method.GoName + serverSignatureParams(g, method, false /* named */)
 }
@@ -426,11 +1092,276 @@ func isDeprecatedService(service *protogen.Service) bool {
 	return ok && serviceOptions.GetDeprecated()
 }
 
+// isIdempotentMethod reports whether the method's idempotency_level is
+// NO_SIDE_EFFECTS or IDEMPOTENT.
+func isIdempotentMethod(method *protogen.Method) bool {
+	methodOptions, ok := method.Desc.Options().(*descriptorpb.MethodOptions)
+	if !ok {
+		return false
+	}
+	return methodOptions.GetIdempotencyLevel() != descriptorpb.MethodOptions_IDEMPOTENCY_UNKNOWN
+}
+
+// idempotencyLevel returns the name of the connect.IdempotencyLevel constant
+// matching the method's idempotency_level, or an empty string if the level is
+// unknown.
+func idempotencyLevel(method *protogen.Method) string {
+	methodOptions, ok := method.Desc.Options().(*descriptorpb.MethodOptions)
+	if !ok {
+		return ""
+	}
+	switch methodOptions.GetIdempotencyLevel() {
+	case descriptorpb.MethodOptions_NO_SIDE_EFFECTS:
+		return "IdempotencyNoSideEffects"
+	case descriptorpb.MethodOptions_IDEMPOTENT:
+		return "IdempotencyIdempotent"
+	default:
+		return ""
+	}
+}
+
 func isDeprecatedMethod(method *protogen.Method) bool {
 	methodOptions, ok := method.Desc.Options().(*descriptorpb.MethodOptions)
 	return ok && methodOptions.GetDeprecated()
 }
 
+// defaultTimeout returns the method's connect.options.v1.default_timeout, or
+// zero if it's not set.
+func defaultTimeout(method *protogen.Method) (time.Duration, error) {
+	methodOptions, ok := method.Desc.Options().(*descriptorpb.MethodOptions)
+	if !ok || !proto.HasExtension(methodOptions, optionsv1.E_DefaultTimeout) {
+		return 0, nil
+	}
+	value, _ := proto.GetExtension(methodOptions, optionsv1.E_DefaultTimeout).(string)
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid default_timeout: %w", method.Desc.FullName(), err)
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("%s: default_timeout must be positive, got %q", method.Desc.FullName(), value)
+	}
+	return timeout, nil
+}
+
+// errorDetail is an error detail declared with the
+// connect.options.v1.error_details option.
+type errorDetail struct {
+	message *protogen.Message
+	code    connect.Code
+}
+
+// errorDetails returns the error details declared by the service.
+func errorDetails(plugin *protogen.Plugin, service *protogen.Service) ([]errorDetail, error) {
+	serviceOptions, ok := service.Desc.Options().(*descriptorpb.ServiceOptions)
+	if !ok || !proto.HasExtension(serviceOptions, optionsv1.E_ErrorDetails) {
+		return nil, nil
+	}
+	declarations, _ := proto.GetExtension(serviceOptions, optionsv1.E_ErrorDetails).([]*optionsv1.ErrorDetail)
+	details := make([]errorDetail, 0, len(declarations))
+	for _, declaration := range declarations {
+		name := strings.TrimPrefix(declaration.GetMessage(), ".")
+		message := findMessage(plugin, protoreflect.FullName(name))
+		if message == nil {
+			message = findMessage(plugin, service.Desc.ParentFile().Package().Append(protoreflect.Name(name)))
+		}
+		if message == nil {
+			return nil, fmt.Errorf("%s: error detail message %q not found", service.Desc.FullName(), name)
+		}
+		var code connect.Code
+		if err := code.UnmarshalText([]byte(declaration.GetCode())); err != nil {
+			return nil, fmt.Errorf("%s: invalid code for error detail %q: %w", service.Desc.FullName(), name, err)
+		}
+		details = append(details, errorDetail{message: message, code: code})
+	}
+	return details, nil
+}
+
+// findMessage looks up a message by its fully-qualified name in all the files
+// the plugin knows about.
+func findMessage(plugin *protogen.Plugin, name protoreflect.FullName) *protogen.Message {
+	for _, file := range plugin.Files {
+		if message := findNestedMessage(file.Messages, name); message != nil {
+			return message
+		}
+	}
+	return nil
+}
+
+func findNestedMessage(messages []*protogen.Message, name protoreflect.FullName) *protogen.Message {
+	for _, message := range messages {
+		if message.Desc.FullName() == name {
+			return message
+		}
+		if nested := findNestedMessage(message.Messages, name); nested != nil {
+			return nested
+		}
+	}
+	return nil
+}
+
+// codeIdentName returns the name of the connect package's constant for the
+// code, like CodeFailedPrecondition.
+func codeIdentName(code connect.Code) string {
+	name := "Code"
+	for _, word := range strings.Split(code.String(), "_") {
+		name += strings.ToUpper(word[:1]) + word[1:]
+	}
+	return name
+}
+
+// hasValidateConstraints reports whether the message, or any message it
+// contains, carries buf.validate constraints. Since the plugin doesn't link
+// in the buf.validate descriptors, the options are usually unknown fields.
+func hasValidateConstraints(message *protogen.Message) bool {
+	return hasValidateConstraintsVisited(message, make(map[protoreflect.FullName]bool))
+}
+
+func hasValidateConstraintsVisited(message *protogen.Message, visited map[protoreflect.FullName]bool) bool {
+	if visited[message.Desc.FullName()] {
+		return false
+	}
+	visited[message.Desc.FullName()] = true
+	if hasValidateOption(message.Desc.Options()) {
+		return true
+	}
+	for _, oneof := range message.Oneofs {
+		if hasValidateOption(oneof.Desc.Options()) {
+			return true
+		}
+	}
+	for _, field := range message.Fields {
+		if hasValidateOption(field.Desc.Options()) {
+			return true
+		}
+		if field.Message != nil && hasValidateConstraintsVisited(field.Message, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasValidateOption(options proto.Message) bool {
+	if options == nil {
+		return false
+	}
+	reflectOptions := options.ProtoReflect()
+	if !reflectOptions.IsValid() {
+		return false
+	}
+	found := false
+	reflectOptions.Range(func(field protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		found = field.IsExtension() && field.Number() == validateExtensionNumber
+		return !found
+	})
+	if found {
+		return true
+	}
+	unknown := reflectOptions.GetUnknown()
+	for len(unknown) > 0 {
+		number, wireType, n := protowire.ConsumeTag(unknown)
+		if n < 0 {
+			return false
+		}
+		if number == validateExtensionNumber {
+			return true
+		}
+		unknown = unknown[n:]
+		n = protowire.ConsumeFieldValue(number, wireType, unknown)
+		if n < 0 {
+			return false
+		}
+		unknown = unknown[n:]
+	}
+	return false
+}
+
+// operationInfo reports the response and metadata types named by a method's
+// google.longrunning.operation_info option. The plugin doesn't link the
+// option's Go package, so it's usually found among the unknown fields.
+func operationInfo(options proto.Message) (response, metadata string, ok bool) {
+	if options == nil {
+		return "", "", false
+	}
+	reflectOptions := options.ProtoReflect()
+	if !reflectOptions.IsValid() {
+		return "", "", false
+	}
+	var raw []byte
+	reflectOptions.Range(func(field protoreflect.FieldDescriptor, value protoreflect.Value) bool {
+		if !field.IsExtension() || field.Number() != operationInfoExtensionNumber ||
+			field.Kind() != protoreflect.MessageKind {
+			return true
+		}
+		raw, _ = proto.Marshal(value.Message().Interface())
+		ok = true
+		return false
+	})
+	unknown := reflectOptions.GetUnknown()
+	for !ok && len(unknown) > 0 {
+		number, wireType, n := protowire.ConsumeTag(unknown)
+		if n < 0 {
+			return "", "", false
+		}
+		unknown = unknown[n:]
+		if number == operationInfoExtensionNumber && wireType == protowire.BytesType {
+			raw, n = protowire.ConsumeBytes(unknown)
+			ok = n >= 0
+			break
+		}
+		n = protowire.ConsumeFieldValue(number, wireType, unknown)
+		if n < 0 {
+			return "", "", false
+		}
+		unknown = unknown[n:]
+	}
+	if !ok {
+		return "", "", false
+	}
+	for len(raw) > 0 {
+		number, wireType, n := protowire.ConsumeTag(raw)
+		if n < 0 {
+			return "", "", false
+		}
+		raw = raw[n:]
+		if wireType != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(number, wireType, raw)
+		} else {
+			var value []byte
+			value, n = protowire.ConsumeBytes(raw)
+			switch number {
+			case 1:
+				response = string(value)
+			case 2:
+				metadata = string(value)
+			}
+		}
+		if n < 0 {
+			return "", "", false
+		}
+		raw = raw[n:]
+	}
+	return response, metadata, response != ""
+}
+
+// durationLiteral formats a duration as readable Go source, like 5*time.Second.
+func durationLiteral(g *protogen.GeneratedFile, duration time.Duration) string {
+	for _, unit := range []struct {
+		size time.Duration
+		name string
+	}{
+		{time.Hour, "Hour"},
+		{time.Minute, "Minute"},
+		{time.Second, "Second"},
+		{time.Millisecond, "Millisecond"},
+		{time.Microsecond, "Microsecond"},
+	} {
+		if duration%unit.size == 0 {
+			return fmt.Sprintf("%d*%s", duration/unit.size, g.QualifiedGoIdent(timePackage.Ident(unit.name)))
+		}
+	}
+	return fmt.Sprintf("%d*%s", duration, g.QualifiedGoIdent(timePackage.Ident("Nanosecond")))
+}
+
 // Raggedy comments in the generated code are driving me insane. This
 // word-wrapping function is ruinously inefficient, but it gets the job done.
 func wrapComments(g *protogen.GeneratedFile, elems ...any) {
@@ -486,25 +1417,31 @@ func unexport(s string) string {
 }
 
 type names struct {
-	Base                string
-	Client              string
-	ClientConstructor   string
-	ClientImpl          string
-	ClientExposeMethod  string
-	Server              string
-	ServerConstructor   string
-	UnimplementedServer string
+	Base                  string
+	Client                string
+	ClientConstructor     string
+	ClientImpl            string
+	ClientExposeMethod    string
+	Server                string
+	ServerConstructor     string
+	UnimplementedServer   string
+	GraphQLFields         string
+	GRPCClientConstructor string
+	GRPCClientImpl        string
 }
 
 func newNames(service *protogen.Service) names {
 	base := service.GoName
 	return names{
-		Base:                base,
-		Client:              fmt.Sprintf("%sClient", base),
-		ClientConstructor:   fmt.Sprintf("New%sClient", base),
-		ClientImpl:          fmt.Sprintf("%sClient", unexport(base)),
-		Server:              fmt.Sprintf("%sHandler", base),
-		ServerConstructor:   fmt.Sprintf("New%sHandler", base),
-		UnimplementedServer: fmt.Sprintf("Unimplemented%sHandler", base),
+		Base:                  base,
+		Client:                fmt.Sprintf("%sClient", base),
+		ClientConstructor:     fmt.Sprintf("New%sClient", base),
+		ClientImpl:            fmt.Sprintf("%sClient", unexport(base)),
+		Server:                fmt.Sprintf("%sHandler", base),
+		ServerConstructor:     fmt.Sprintf("New%sHandler", base),
+		UnimplementedServer:   fmt.Sprintf("Unimplemented%sHandler", base),
+		GraphQLFields:         fmt.Sprintf("New%sGraphQLFields", base),
+		GRPCClientConstructor: fmt.Sprintf("New%sGRPCClient", base),
+		GRPCClientImpl:        fmt.Sprintf("%sGRPCClient", unexport(base)),
 	}
 }
@@ -0,0 +1,203 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bufbuild/connect-go/internal/assert"
+	optionsv1 "github.com/bufbuild/connect-go/proto/connect/options/v1"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+var update = flag.Bool("update", false, "update golden files") //nolint:gochecknoglobals
+
+func TestErrorDetails(t *testing.T) {
+	t.Parallel()
+	serviceOptions := &descriptorpb.ServiceOptions{}
+	proto.SetExtension(serviceOptions, optionsv1.E_ErrorDetails, []*optionsv1.ErrorDetail{
+		{Message: "OutOfStock", Code: "failed_precondition"},
+		{Message: ".connect.store.v1.Backordered", Code: "unavailable"},
+	})
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("connect/store/v1/store.proto"),
+		Package:    proto.String("connect.store.v1"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"connect/options/v1/options.proto"},
+		Options: &descriptorpb.FileOptions{
+			GoPackage: proto.String("example.com/gen/connect/store/v1;storev1"),
+		},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("BuyRequest"), Field: []*descriptorpb.FieldDescriptorProto{stringField("sku", 1)}},
+			{Name: proto.String("BuyResponse")},
+			{Name: proto.String("OutOfStock"), Field: []*descriptorpb.FieldDescriptorProto{stringField("sku", 1)}},
+			{Name: proto.String("Backordered"), Field: []*descriptorpb.FieldDescriptorProto{stringField("sku", 1)}},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("StoreService"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:       proto.String("Buy"),
+				InputType:  proto.String(".connect.store.v1.BuyRequest"),
+				OutputType: proto.String(".connect.store.v1.BuyResponse"),
+			}},
+			Options: serviceOptions,
+		}},
+	}
+	testGolden(t, "error_details", options{fileSuffix: generatedFilenameExtension}, file)
+}
+
+func TestErrorDetailsNotFound(t *testing.T) {
+	t.Parallel()
+	serviceOptions := &descriptorpb.ServiceOptions{}
+	proto.SetExtension(serviceOptions, optionsv1.E_ErrorDetails, []*optionsv1.ErrorDetail{
+		{Message: "Missing", Code: "not_found"},
+	})
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("connect/store/v1/store.proto"),
+		Package:    proto.String("connect.store.v1"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"connect/options/v1/options.proto"},
+		Options: &descriptorpb.FileOptions{
+			GoPackage: proto.String("example.com/gen/connect/store/v1;storev1"),
+		},
+		MessageType: []*descriptorpb.DescriptorProto{{Name: proto.String("Empty")}},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("StoreService"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:       proto.String("Buy"),
+				InputType:  proto.String(".connect.store.v1.Empty"),
+				OutputType: proto.String(".connect.store.v1.Empty"),
+			}},
+			Options: serviceOptions,
+		}},
+	}
+	response := runPlugin(t, options{fileSuffix: generatedFilenameExtension}, file)
+	assert.Equal(t, response.GetError(), `connect.store.v1.StoreService: error detail message "Missing" not found`)
+}
+
+func TestGRPCGateway(t *testing.T) {
+	t.Parallel()
+	// This is testdata/grpcgateway/proto/connect/gateway/v1/gateway.proto. The
+	// generated code is checked into testdata/grpcgateway/gen, which is a
+	// separate module so that it can depend on gRPC. Its tests compile the
+	// adapters against protoc-gen-go-grpc's output and run them.
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("connect/gateway/v1/gateway.proto"),
+		Package: proto.String("connect.gateway.v1"),
+		Syntax:  proto.String("proto3"),
+		Options: &descriptorpb.FileOptions{
+			GoPackage: proto.String("github.com/bufbuild/connect-go/cmd/protoc-gen-connect-go/testdata/grpcgateway/" +
+				"gen/connect/gateway/v1;gatewayv1"),
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("GatewayService"),
+		}},
+	}
+	for _, method := range []struct {
+		name           string
+		client, server bool
+	}{
+		{name: "Echo"},
+		{name: "Collect", client: true},
+		{name: "Expand", server: true},
+		{name: "Chat", client: true, server: true},
+	} {
+		for _, suffix := range []string{"Request", "Response"} {
+			file.MessageType = append(file.MessageType, &descriptorpb.DescriptorProto{
+				Name:  proto.String(method.name + suffix),
+				Field: []*descriptorpb.FieldDescriptorProto{stringField("text", 1)},
+			})
+		}
+		file.Service[0].Method = append(file.Service[0].Method, &descriptorpb.MethodDescriptorProto{
+			Name:            proto.String(method.name),
+			InputType:       proto.String(".connect.gateway.v1." + method.name + "Request"),
+			OutputType:      proto.String(".connect.gateway.v1." + method.name + "Response"),
+			ClientStreaming: proto.Bool(method.client),
+			ServerStreaming: proto.Bool(method.server),
+		})
+	}
+	response := runPlugin(t, options{fileSuffix: generatedFilenameExtension, grpcGateway: true}, file)
+	compareGenerated(t, response, func(name string) string {
+		return filepath.Join("testdata", "grpcgateway", "gen", name)
+	})
+}
+
+// testGolden runs the plugin on the file and compares its output to the
+// golden files in testdata/name. Run the tests with -update to rewrite them.
+func testGolden(tb testing.TB, name string, opts options, file *descriptorpb.FileDescriptorProto) {
+	tb.Helper()
+	compareGenerated(tb, runPlugin(tb, opts, file), func(generated string) string {
+		return filepath.Join("testdata", name, generated+".golden")
+	})
+}
+
+// compareGenerated compares each generated file to the file at path(name),
+// rewriting them when the tests are run with -update.
+func compareGenerated(tb testing.TB, response *pluginpb.CodeGeneratorResponse, path func(string) string) {
+	tb.Helper()
+	assert.Equal(tb, response.GetError(), "")
+	for _, generated := range response.File {
+		path := path(generated.GetName())
+		if *update {
+			assert.Nil(tb, os.MkdirAll(filepath.Dir(path), 0o755))
+			assert.Nil(tb, os.WriteFile(path, []byte(generated.GetContent()), 0o600))
+			continue
+		}
+		want, err := os.ReadFile(path)
+		assert.Nil(tb, err)
+		assert.Equal(
+			tb, generated.GetContent(), string(want),
+			assert.Sprintf("%s differs from %s", generated.GetName(), path),
+		)
+	}
+}
+
+// runPlugin runs the plugin on the file, as though protoc had parsed it along
+// with its dependencies.
+func runPlugin(tb testing.TB, opts options, file *descriptorpb.FileDescriptorProto) *pluginpb.CodeGeneratorResponse {
+	tb.Helper()
+	request := &pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{file.GetName()},
+		Parameter:      proto.String("paths=source_relative"),
+		ProtoFile: []*descriptorpb.FileDescriptorProto{
+			protodesc.ToFileDescriptorProto(descriptorpb.File_google_protobuf_descriptor_proto),
+			protodesc.ToFileDescriptorProto(optionsv1.File_connect_options_v1_options_proto),
+			file,
+		},
+	}
+	plugin, err := protogen.Options{}.New(request)
+	assert.Nil(tb, err)
+	if err := run(plugin, opts); err != nil {
+		plugin.Error(err)
+	}
+	return plugin.Response()
+}
+
+func stringField(name string, number int32) *descriptorpb.FieldDescriptorProto {
+	return &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		JsonName: proto.String(name),
+		Number:   proto.Int32(number),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+	}
+}
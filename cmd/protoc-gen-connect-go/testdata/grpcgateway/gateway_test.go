@@ -0,0 +1,218 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package grpcgateway holds code generated with the grpc_gateway=true
+// parameter, so that it's compiled against protoc-gen-go-grpc's output. The
+// generated code is checked by TestGRPCGateway in the plugin's tests; after
+// changing the plugin, run them with -update.
+package grpcgateway
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	connect "github.com/bufbuild/connect-go"
+	gatewayv1 "github.com/bufbuild/connect-go/cmd/protoc-gen-connect-go/testdata/grpcgateway/gen/connect/gateway/v1"
+	"github.com/bufbuild/connect-go/cmd/protoc-gen-connect-go/testdata/grpcgateway/gen/connect/gateway/v1/gatewayv1connect"
+	"github.com/bufbuild/connect-go/internal/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func TestGRPCGatewayClient(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(gatewayv1connect.NewGatewayServiceHandler(gatewayServer{}))
+	server := httptest.NewUnstartedServer(mux)
+	server.EnableHTTP2 = true // for bidirectional streaming
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	client := gatewayv1connect.NewGatewayServiceGRPCClient(
+		gatewayv1connect.NewGatewayServiceClient(server.Client(), server.URL),
+	)
+	var _ gatewayv1.GatewayServiceClient = client // compile-time check
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-echo", "hello")
+
+	t.Run("unary", func(t *testing.T) {
+		t.Parallel()
+		var header metadata.MD
+		response, err := client.Echo(ctx, &gatewayv1.EchoRequest{Text: "ping"}, grpc.Header(&header))
+		assert.Nil(t, err)
+		assert.Equal(t, response.Text, "ping")
+		assert.Equal(t, header.Get("x-echo"), []string{"hello"})
+	})
+	t.Run("unary_error", func(t *testing.T) {
+		t.Parallel()
+		var trailer metadata.MD
+		_, err := client.Echo(ctx, &gatewayv1.EchoRequest{Text: "fail"}, grpc.Trailer(&trailer))
+		assertStatus(t, err, codes.FailedPrecondition, "fail")
+		assert.Equal(t, trailer.Get("x-echo"), []string{"hello"})
+	})
+	t.Run("client_stream", func(t *testing.T) {
+		t.Parallel()
+		stream, err := client.Collect(ctx)
+		assert.Nil(t, err)
+		assert.Nil(t, stream.Send(&gatewayv1.CollectRequest{Text: "a"}))
+		assert.Nil(t, stream.Send(&gatewayv1.CollectRequest{Text: "b"}))
+		response, err := stream.CloseAndRecv()
+		assert.Nil(t, err)
+		assert.Equal(t, response.Text, "ab")
+	})
+	t.Run("server_stream", func(t *testing.T) {
+		t.Parallel()
+		stream, err := client.Expand(ctx, &gatewayv1.ExpandRequest{Text: "ab"})
+		assert.Nil(t, err)
+		var texts []string
+		for {
+			response, err := stream.Recv()
+			if err != nil {
+				assertEOF(t, err)
+				break
+			}
+			texts = append(texts, response.Text)
+		}
+		assert.Equal(t, texts, []string{"a", "b"})
+	})
+	t.Run("server_stream_error", func(t *testing.T) {
+		t.Parallel()
+		// The handler's error wraps io.EOF, but it's still an error rather than
+		// the end of the stream.
+		stream, err := client.Expand(ctx, &gatewayv1.ExpandRequest{Text: "a!"})
+		assert.Nil(t, err)
+		response, err := stream.Recv()
+		assert.Nil(t, err)
+		assert.Equal(t, response.Text, "a")
+		_, err = stream.Recv()
+		assertStatus(t, err, codes.Unavailable, io.EOF.Error())
+	})
+	t.Run("bidi_stream", func(t *testing.T) {
+		t.Parallel()
+		stream, err := client.Chat(ctx)
+		assert.Nil(t, err)
+		assert.Nil(t, stream.Send(&gatewayv1.ChatRequest{Text: "ping"}))
+		response, err := stream.Recv()
+		assert.Nil(t, err)
+		assert.Equal(t, response.Text, "ping")
+		assert.Nil(t, stream.CloseSend())
+		_, err = stream.Recv()
+		assertEOF(t, err)
+	})
+}
+
+// assertEOF checks that err is a bare io.EOF, which is how gRPC streams signal
+// that they've ended.
+func assertEOF(tb testing.TB, err error) {
+	tb.Helper()
+	assert.True(tb, err == io.EOF, assert.Sprintf("got %v, expected io.EOF", err)) //nolint:errorlint
+}
+
+// assertStatus checks that err is a gRPC status error with the code, message,
+// and an *EchoResponse detail holding the message.
+func assertStatus(tb testing.TB, err error, code codes.Code, message string) {
+	tb.Helper()
+	grpcStatus, ok := status.FromError(err)
+	assert.True(tb, ok, assert.Sprintf("got %T, expected a gRPC status error", err))
+	assert.Equal(tb, grpcStatus.Code(), code)
+	assert.Equal(tb, grpcStatus.Message(), message)
+	details := grpcStatus.Details()
+	assert.Equal(tb, len(details), 1)
+	if len(details) == 1 {
+		detail, ok := details[0].(*gatewayv1.EchoResponse)
+		assert.True(tb, ok, assert.Sprintf("got detail %T, expected *EchoResponse", details[0]))
+		assert.Equal(tb, detail.GetText(), message)
+	}
+}
+
+type gatewayServer struct {
+	gatewayv1connect.UnimplementedGatewayServiceHandler
+}
+
+func (gatewayServer) Echo(
+	ctx context.Context,
+	request *connect.Request[gatewayv1.EchoRequest],
+) (*connect.Response[gatewayv1.EchoResponse], error) {
+	if request.Msg.Text == "fail" {
+		err := newDetailedError(connect.CodeFailedPrecondition, errors.New("fail"))
+		err.Meta().Set("X-Echo", request.Header().Get("X-Echo"))
+		return nil, err
+	}
+	response := connect.NewResponse(&gatewayv1.EchoResponse{Text: request.Msg.Text})
+	response.Header().Set("X-Echo", request.Header().Get("X-Echo"))
+	return response, nil
+}
+
+func (gatewayServer) Collect(
+	ctx context.Context,
+	stream *connect.ClientStream[gatewayv1.CollectRequest],
+) (*connect.Response[gatewayv1.CollectResponse], error) {
+	var text strings.Builder
+	for stream.Receive() {
+		text.WriteString(stream.Msg().Text)
+	}
+	if err := stream.Err(); err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&gatewayv1.CollectResponse{Text: text.String()}), nil
+}
+
+func (gatewayServer) Expand(
+	ctx context.Context,
+	request *connect.Request[gatewayv1.ExpandRequest],
+	stream *connect.ServerStream[gatewayv1.ExpandResponse],
+) error {
+	for _, r := range request.Msg.Text {
+		if r == '!' {
+			return newDetailedError(connect.CodeUnavailable, io.EOF)
+		}
+		if err := stream.Send(&gatewayv1.ExpandResponse{Text: string(r)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (gatewayServer) Chat(
+	ctx context.Context,
+	stream *connect.BidiStream[gatewayv1.ChatRequest, gatewayv1.ChatResponse],
+) error {
+	for {
+		request, err := stream.Receive()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := stream.Send(&gatewayv1.ChatResponse{Text: request.Text}); err != nil {
+			return err
+		}
+	}
+}
+
+func newDetailedError(code connect.Code, underlying error) *connect.Error {
+	err := connect.NewError(code, underlying)
+	detail, anyErr := anypb.New(&gatewayv1.EchoResponse{Text: underlying.Error()})
+	if anyErr != nil {
+		return connect.NewError(connect.CodeInternal, anyErr)
+	}
+	err.AddDetail(detail)
+	return err
+}
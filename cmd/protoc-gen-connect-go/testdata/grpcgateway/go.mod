@@ -0,0 +1,20 @@
+module github.com/bufbuild/connect-go/cmd/protoc-gen-connect-go/testdata/grpcgateway
+
+go 1.18
+
+require (
+	github.com/bufbuild/connect-go v0.1.0
+	google.golang.org/grpc v1.56.3
+	google.golang.org/protobuf v1.30.0
+)
+
+require (
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/google/go-cmp v0.5.9 // indirect
+	golang.org/x/net v0.11.0 // indirect
+	golang.org/x/sys v0.10.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1 // indirect
+)
+
+replace github.com/bufbuild/connect-go => ../../../..
@@ -19,6 +19,7 @@ import (
 
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
 const (
@@ -70,7 +71,11 @@ func (c *protoBinaryCodec) Unmarshal(data []byte, message any) error {
 	return proto.Unmarshal(data, protoMessage)
 }
 
-type protoJSONCodec struct{}
+type protoJSONCodec struct {
+	// Resolves the types of google.protobuf.Any fields, falling back to the
+	// global registry and then the standard error details. May be nil.
+	types *protoregistry.Types
+}
 
 var _ Codec = (*protoJSONCodec)(nil)
 
@@ -81,7 +86,7 @@ func (c *protoJSONCodec) Marshal(message any) ([]byte, error) {
 	if !ok {
 		return nil, errNotProto(message)
 	}
-	var options protojson.MarshalOptions
+	options := protojson.MarshalOptions{Resolver: typeResolver{types: c.types}}
 	return options.Marshal(protoMessage)
 }
 
@@ -90,7 +95,7 @@ func (c *protoJSONCodec) Unmarshal(binary []byte, message any) error {
 	if !ok {
 		return errNotProto(message)
 	}
-	var options protojson.UnmarshalOptions
+	options := protojson.UnmarshalOptions{Resolver: typeResolver{types: c.types}}
 	return options.Unmarshal(binary, protoMessage)
 }
 
@@ -118,6 +123,7 @@ func newReadOnlyCodecs(nameToCodec map[string]Codec) readOnlyCodecs {
 
 type codecMap struct {
 	nameToCodec map[string]Codec
+	protobuf    Codec // overrides the "proto" codec for protocol messages
 }
 
 func (m *codecMap) Get(name string) Codec {
@@ -125,6 +131,9 @@ func (m *codecMap) Get(name string) Codec {
 }
 
 func (m *codecMap) Protobuf() Codec {
+	if m.protobuf != nil {
+		return m.protobuf
+	}
 	if pb, ok := m.nameToCodec[codecNameProto]; ok {
 		return pb
 	}
@@ -16,6 +16,8 @@ package connect
 
 import (
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
 	"errors"
 	"io"
 	"strings"
@@ -74,15 +76,26 @@ func newCompressionPool(
 	}
 }
 
-func (c *compressionPool) Decompress(dst *bytes.Buffer, src *bytes.Buffer) *Error {
+// Decompress decompresses src into dst. If readMaxBytes is positive, it fails
+// with CodeResourceExhausted once the output grows past readMaxBytes.
+func (c *compressionPool) Decompress(dst *bytes.Buffer, src *bytes.Buffer, readMaxBytes int64) *Error {
 	decompressor, err := c.getDecompressor(src)
 	if err != nil {
 		return errorf(CodeInvalidArgument, "get decompressor: %w", err)
 	}
-	if _, err := dst.ReadFrom(decompressor); err != nil {
+	var reader io.Reader = decompressor
+	if readMaxBytes > 0 {
+		reader = io.LimitReader(reader, readMaxBytes+1)
+	}
+	bytesRead, err := dst.ReadFrom(reader)
+	if err != nil {
 		_ = c.putDecompressor(decompressor)
 		return errorf(CodeInvalidArgument, "decompress: %w", err)
 	}
+	if readMaxBytes > 0 && bytesRead > readMaxBytes {
+		_ = c.putDecompressor(decompressor)
+		return errorf(CodeResourceExhausted, "decompressed message size exceeds readMaxBytes %d", readMaxBytes)
+	}
 	if err := c.putDecompressor(decompressor); err != nil {
 		return errorf(CodeUnknown, "recycle decompressor: %w", err)
 	}
@@ -122,9 +135,14 @@ func (c *compressionPool) putDecompressor(decompressor Decompressor) error {
 	// know the compression format, we can't provide a valid header. Since we
 	// also reset the decompressor when it's pulled out of the pool, we can
 	// ignore errors here.
+	c.recycleDecompressor(decompressor)
+	return nil
+}
+
+// recycleDecompressor returns a closed decompressor to the pool.
+func (c *compressionPool) recycleDecompressor(decompressor Decompressor) {
 	_ = decompressor.Reset(strings.NewReader(""))
 	c.decompressors.Put(decompressor)
-	return nil
 }
 
 func (c *compressionPool) getCompressor(writer io.Writer) (Compressor, error) {
@@ -140,9 +158,81 @@ func (c *compressionPool) putCompressor(compressor Compressor) error {
 	if err := compressor.Close(); err != nil {
 		return err
 	}
+	c.recycleCompressor(compressor)
+	return nil
+}
+
+// recycleCompressor returns a closed compressor to the pool.
+func (c *compressionPool) recycleCompressor(compressor Compressor) {
 	compressor.Reset(io.Discard) // don't keep references
 	c.compressors.Put(compressor)
-	return nil
+}
+
+// gzipPools holds one compressionPool per gzip level. Every client and handler
+// shares them, so readers and writers are reused across the whole process
+// rather than per client or handler.
+var gzipPools = struct {
+	sync.Mutex
+
+	byLevel map[int]*compressionPool
+}{byLevel: make(map[int]*compressionPool)}
+
+// gzipCompressionPool returns the shared pool for a gzip level. Invalid levels
+// use gzip.DefaultCompression.
+func gzipCompressionPool(level int) *compressionPool {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		level = gzip.DefaultCompression
+	}
+	gzipPools.Lock()
+	defer gzipPools.Unlock()
+	if pool, ok := gzipPools.byLevel[level]; ok {
+		return pool
+	}
+	pool := newCompressionPool(
+		func() Decompressor { return &gzip.Reader{} },
+		func() Compressor {
+			// NewWriterLevel only errors for invalid levels, which we've ruled out.
+			writer, _ := gzip.NewWriterLevel(io.Discard, level)
+			return writer
+		},
+	)
+	gzipPools.byLevel[level] = pool
+	return pool
+}
+
+// newDeflateDictionaryPool constructs a pool of DEFLATE compressors and
+// decompressors that share a preset dictionary.
+func newDeflateDictionaryPool(dictionary []byte) *compressionPool {
+	dictionary = append([]byte(nil), dictionary...) // callers may reuse the slice
+	return newCompressionPool(
+		func() Decompressor {
+			return &deflateDictionaryReader{
+				ReadCloser: flate.NewReaderDict(strings.NewReader(""), dictionary),
+				dictionary: dictionary,
+			}
+		},
+		func() Compressor {
+			// NewWriterDict only errors for invalid compression levels.
+			writer, _ := flate.NewWriterDict(io.Discard, flate.DefaultCompression, dictionary)
+			return writer
+		},
+	)
+}
+
+// deflateDictionaryReader adapts the standard library's flate reader, which
+// needs the dictionary on every reset, to the Decompressor interface.
+type deflateDictionaryReader struct {
+	io.ReadCloser
+
+	dictionary []byte
+}
+
+func (r *deflateDictionaryReader) Reset(reader io.Reader) error {
+	resetter, ok := r.ReadCloser.(flate.Resetter)
+	if !ok {
+		return errors.New("flate reader doesn't implement flate.Resetter")
+	}
+	return resetter.Reset(reader, r.dictionary)
 }
 
 // readOnlyCompressionPools is a read-only interface to a map of named
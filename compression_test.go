@@ -0,0 +1,47 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+
+	"github.com/bufbuild/connect-go/internal/assert"
+)
+
+func TestGzipCompressionPools(t *testing.T) {
+	t.Parallel()
+	assert.True(t, gzipCompressionPool(gzip.BestSpeed) == gzipCompressionPool(gzip.BestSpeed))
+	assert.True(t, gzipCompressionPool(gzip.BestSpeed) != gzipCompressionPool(gzip.BestCompression))
+	assert.True(t, gzipCompressionPool(42) == gzipCompressionPool(gzip.DefaultCompression))
+
+	config, err := newClientConfig("http://localhost/foo.v1.FooService/Bar", []ClientOption{
+		WithGzipLevel(gzip.BestCompression),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, config.CompressionNames, []string{compressionGzip})
+	pool := config.CompressionPools[compressionGzip]
+	assert.True(t, pool == gzipCompressionPool(gzip.BestCompression))
+
+	src := strings.Repeat("gzip", 1024)
+	compressed := &bytes.Buffer{}
+	assert.Nil(t, pool.Compress(compressed, bytes.NewBufferString(src)))
+	assert.True(t, compressed.Len() < len(src))
+	decompressed := &bytes.Buffer{}
+	assert.Nil(t, pool.Decompress(decompressed, compressed, 0 /* readMaxBytes */))
+	assert.Equal(t, decompressed.String(), src)
+}
@@ -0,0 +1,222 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conformance
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// CheckClient verifies that clients returned by newClient speak protocol
+// (connect.ProtocolConnect, connect.ProtocolGRPC, or
+// connect.ProtocolGRPCWeb) correctly. Clients call the conformance service
+// over HTTP/2, both on a well-behaved server and on servers that send
+// malformed responses. The checks cover framing, error codes, timeouts,
+// trailers, and compression negotiation.
+func CheckClient(
+	t *testing.T,
+	protocol string,
+	newClient func(httpClient *http.Client, baseURL string) Client,
+) {
+	t.Helper()
+	checker := &clientChecker{
+		protocol:  protocol,
+		newClient: newClient,
+	}
+	t.Run("framing", checker.checkFraming)
+	t.Run("trailers", checker.checkTrailers)
+	t.Run("codes", checker.checkCodes)
+	t.Run("timeout", checker.checkTimeout)
+	t.Run("compression", checker.checkCompression)
+	t.Run("streaming", checker.checkStreaming)
+}
+
+type clientChecker struct {
+	protocol  string
+	newClient func(*http.Client, string) Client
+}
+
+// start returns a client for a server running handler.
+func (c *clientChecker) start(t *testing.T, handler http.Handler) Client {
+	t.Helper()
+	server := httptest.NewUnstartedServer(handler)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return c.newClient(server.Client(), server.URL)
+}
+
+// startRaw returns a client for a server that responds to every call with
+// the supplied messages, already encoded and enveloped, followed by a
+// successful end of stream. Unary Connect responses aren't enveloped, so
+// their body is the concatenation of the messages.
+func (c *clientChecker) startRaw(t *testing.T, streaming bool, header http.Header, messages ...[]byte) Client {
+	t.Helper()
+	w := wire{protocol: c.protocol, streaming: streaming}
+	return c.start(t, http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		_, _ = io.Copy(io.Discard, request.Body)
+		for key, values := range header {
+			responseWriter.Header()[key] = values
+		}
+		responseWriter.Header().Set("Content-Type", w.contentType())
+		if w.protocol == connect.ProtocolGRPC {
+			responseWriter.Header().Set("Trailer", "Grpc-Status")
+		}
+		responseWriter.WriteHeader(http.StatusOK)
+		for _, msg := range messages {
+			_, _ = responseWriter.Write(msg)
+		}
+		switch {
+		case w.protocol == connect.ProtocolGRPC:
+			responseWriter.Header().Set("Grpc-Status", "0")
+		case w.protocol == connect.ProtocolGRPCWeb:
+			_, _ = responseWriter.Write(envelope(flagGRPCWebTrailer, []byte("grpc-status: 0\r\n")))
+		case w.streaming:
+			_, _ = responseWriter.Write(envelope(flagConnectEndStream, []byte("{}")))
+		}
+	}))
+}
+
+func (c *clientChecker) checkFraming(t *testing.T) {
+	client := c.start(t, NewHandler())
+	for _, text := range []string{"", "framing", strings.Repeat("x", 1<<15)} {
+		got, _, err := client.Echo(context.Background(), text)
+		if err != nil {
+			t.Fatalf("Echo: %v", err)
+		}
+		if got != text {
+			t.Errorf("Echo returned %d bytes, want %d", len(got), len(text))
+		}
+	}
+
+	// Responses with an envelope shorter than its prefix claims must fail.
+	// Unary Connect responses aren't enveloped, so use a streaming call.
+	data, err := proto.Marshal(wrapperspb.Int32(1))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	client = c.startRaw(t, true /* streaming */, nil, envelope(0, data)[:len(data)+4])
+	if _, err := client.Count(context.Background(), 1); err == nil {
+		t.Error("Count succeeded with a truncated response envelope")
+	}
+}
+
+func (c *clientChecker) checkTrailers(t *testing.T) {
+	client := c.start(t, NewHandler())
+	_, trailer, err := client.Echo(context.Background(), "trailer")
+	if err != nil {
+		t.Fatalf("Echo: %v", err)
+	}
+	if got := trailer.Get(TrailerName); got != "trailer" {
+		t.Errorf("trailer %s is %q, want %q", TrailerName, got, "trailer")
+	}
+}
+
+func (c *clientChecker) checkCodes(t *testing.T) {
+	client := c.start(t, NewHandler())
+	for code := connect.CodeCanceled; code <= connect.CodeUnauthenticated; code++ {
+		err := client.Fail(context.Background(), code)
+		if got := connect.CodeOf(err); got != code {
+			t.Errorf("Fail(%v) returned %v: %v", code, got, err)
+		}
+		var connectErr *connect.Error
+		if errors.As(err, &connectErr) && connectErr.Message() != failMessage {
+			t.Errorf("Fail(%v) returned message %q, want %q", code, connectErr.Message(), failMessage)
+		}
+	}
+}
+
+func (c *clientChecker) checkTimeout(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		timeout string
+	)
+	w := wire{protocol: c.protocol}
+	handler := NewHandler()
+	client := c.start(t, http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		mu.Lock()
+		timeout = request.Header.Get(w.timeoutHeader())
+		mu.Unlock()
+		handler.ServeHTTP(responseWriter, request)
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := client.Sleep(ctx, time.Minute)
+	if got := connect.CodeOf(err); got != connect.CodeDeadlineExceeded {
+		t.Errorf("Sleep past deadline returned %v: %v", got, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if timeout == "" {
+		t.Errorf("request with a deadline is missing %s", w.timeoutHeader())
+	}
+}
+
+func (c *clientChecker) checkCompression(t *testing.T) {
+	w := wire{protocol: c.protocol}
+	t.Run("gzip_response", func(t *testing.T) {
+		data, err := proto.Marshal(wrapperspb.String("gzip"))
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		if data, err = gzipBytes(data); err != nil {
+			t.Fatalf("compress: %v", err)
+		}
+		if w.enveloped() {
+			data = envelope(flagCompressed, data)
+		}
+		header := http.Header{w.compressionHeader(): []string{"gzip"}}
+		got, _, err := c.startRaw(t, false /* streaming */, header, data).Echo(context.Background(), "gzip")
+		if err != nil {
+			t.Fatalf("Echo: %v", err)
+		}
+		if got != "gzip" {
+			t.Errorf("Echo returned %q, want %q", got, "gzip")
+		}
+	})
+	t.Run("unknown_response", func(t *testing.T) {
+		data := []byte("not compressed")
+		if w.enveloped() {
+			data = envelope(flagCompressed, data)
+		}
+		header := http.Header{w.compressionHeader(): []string{unknownCompression}}
+		_, _, err := c.startRaw(t, false /* streaming */, header, data).Echo(context.Background(), "unknown")
+		if got := connect.CodeOf(err); got != connect.CodeInternal {
+			t.Errorf("Echo with unsupported response compression returned %v: %v", got, err)
+		}
+	})
+}
+
+func (c *clientChecker) checkStreaming(t *testing.T) {
+	client := c.start(t, NewHandler())
+	values, err := client.Count(context.Background(), 3)
+	if err != nil {
+		t.Fatalf("Count: %v", err)
+	}
+	if len(values) != 3 || values[0] != 1 || values[1] != 2 || values[2] != 3 {
+		t.Errorf("Count returned %v, want [1 2 3]", values)
+	}
+}
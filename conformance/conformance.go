@@ -0,0 +1,185 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package conformance verifies that handlers and clients follow the Connect,
+// gRPC, and gRPC-Web protocols. It's intended for forks of connect and for
+// custom transports: CheckHandler sends hand-built HTTP requests to a handler
+// and inspects the raw responses, and CheckClient points a client at both
+// well-behaved and misbehaving servers.
+//
+// Both checks exercise a small service, connect.conformance.v1.ConformanceService,
+// built from the Protobuf well-known types:
+//
+//   - Echo is unary. It responds with the google.protobuf.StringValue it
+//     received and sets the Conformance-Trailer response trailer to the same
+//     value.
+//   - Fail is unary. It returns an error whose code is the value of the
+//     google.protobuf.Int32Value it received.
+//   - Sleep is unary. It waits for the google.protobuf.Duration it received
+//     and responds with google.protobuf.Empty, or returns an error as soon as
+//     the call's context is done.
+//   - Count is server streaming. Given a google.protobuf.Int32Value n, it
+//     responds with the values 1 through n.
+//
+// NewHandler and NewClient are implementations of the service built with this
+// module.
+package conformance
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const (
+	// ServiceName is the fully-qualified name of the conformance service.
+	ServiceName = "connect.conformance.v1.ConformanceService"
+
+	// EchoProcedure is the procedure name of the unary Echo method.
+	EchoProcedure = "/" + ServiceName + "/Echo"
+	// FailProcedure is the procedure name of the unary Fail method.
+	FailProcedure = "/" + ServiceName + "/Fail"
+	// SleepProcedure is the procedure name of the unary Sleep method.
+	SleepProcedure = "/" + ServiceName + "/Sleep"
+	// CountProcedure is the procedure name of the server streaming Count
+	// method.
+	CountProcedure = "/" + ServiceName + "/Count"
+
+	// TrailerName is the response trailer set by Echo.
+	TrailerName = "Conformance-Trailer"
+)
+
+// failMessage is the message of errors returned by Fail.
+const failMessage = "conformance failure"
+
+// A Client calls the conformance service. CheckClient verifies its behavior.
+// Errors must be (or wrap) a *connect.Error.
+type Client interface {
+	// Echo returns the response message and trailers.
+	Echo(ctx context.Context, text string) (string, http.Header, error)
+	Fail(ctx context.Context, code connect.Code) error
+	Sleep(ctx context.Context, duration time.Duration) error
+	// Count returns all the messages in the response stream.
+	Count(ctx context.Context, n int32) ([]int32, error)
+}
+
+// NewHandler returns an http.Handler that serves the conformance service with
+// the supplied options.
+func NewHandler(options ...connect.HandlerOption) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(EchoProcedure, connect.NewUnaryHandler(
+		EchoProcedure,
+		func(_ context.Context, request *connect.Request[wrapperspb.StringValue]) (*connect.Response[wrapperspb.StringValue], error) {
+			response := connect.NewResponse(wrapperspb.String(request.Msg.Value))
+			response.Trailer().Set(TrailerName, request.Msg.Value)
+			return response, nil
+		},
+		options...,
+	))
+	mux.Handle(FailProcedure, connect.NewUnaryHandler(
+		FailProcedure,
+		func(_ context.Context, request *connect.Request[wrapperspb.Int32Value]) (*connect.Response[emptypb.Empty], error) {
+			return nil, connect.NewError(connect.Code(request.Msg.Value), errors.New(failMessage))
+		},
+		options...,
+	))
+	mux.Handle(SleepProcedure, connect.NewUnaryHandler(
+		SleepProcedure,
+		func(ctx context.Context, request *connect.Request[durationpb.Duration]) (*connect.Response[emptypb.Empty], error) {
+			timer := time.NewTimer(request.Msg.AsDuration())
+			defer timer.Stop()
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-timer.C:
+				return connect.NewResponse(&emptypb.Empty{}), nil
+			}
+		},
+		options...,
+	))
+	mux.Handle(CountProcedure, connect.NewServerStreamHandler(
+		CountProcedure,
+		func(_ context.Context, request *connect.Request[wrapperspb.Int32Value], stream *connect.ServerStream[wrapperspb.Int32Value]) error {
+			for i := int32(1); i <= request.Msg.Value; i++ {
+				if err := stream.Send(wrapperspb.Int32(i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		options...,
+	))
+	return mux
+}
+
+// NewClient returns a Client for the conformance service at baseURL. Like
+// generated clients, it uses the Connect protocol unless options select
+// another protocol.
+func NewClient(httpClient connect.HTTPClient, baseURL string, options ...connect.ClientOption) Client {
+	baseURL = strings.TrimRight(baseURL, "/")
+	return &client{
+		echo:  connect.NewClient[wrapperspb.StringValue, wrapperspb.StringValue](httpClient, baseURL+EchoProcedure, options...),
+		fail:  connect.NewClient[wrapperspb.Int32Value, emptypb.Empty](httpClient, baseURL+FailProcedure, options...),
+		sleep: connect.NewClient[durationpb.Duration, emptypb.Empty](httpClient, baseURL+SleepProcedure, options...),
+		count: connect.NewClient[wrapperspb.Int32Value, wrapperspb.Int32Value](httpClient, baseURL+CountProcedure, options...),
+	}
+}
+
+type client struct {
+	echo  *connect.Client[wrapperspb.StringValue, wrapperspb.StringValue]
+	fail  *connect.Client[wrapperspb.Int32Value, emptypb.Empty]
+	sleep *connect.Client[durationpb.Duration, emptypb.Empty]
+	count *connect.Client[wrapperspb.Int32Value, wrapperspb.Int32Value]
+}
+
+func (c *client) Echo(ctx context.Context, text string) (string, http.Header, error) {
+	response, err := c.echo.CallUnary(ctx, connect.NewRequest(wrapperspb.String(text)))
+	if err != nil {
+		return "", nil, err
+	}
+	return response.Msg.Value, response.Trailer(), nil
+}
+
+func (c *client) Fail(ctx context.Context, code connect.Code) error {
+	_, err := c.fail.CallUnary(ctx, connect.NewRequest(wrapperspb.Int32(int32(code))))
+	return err
+}
+
+func (c *client) Sleep(ctx context.Context, duration time.Duration) error {
+	_, err := c.sleep.CallUnary(ctx, connect.NewRequest(durationpb.New(duration)))
+	return err
+}
+
+func (c *client) Count(ctx context.Context, n int32) ([]int32, error) {
+	stream, err := c.count.CallServerStream(ctx, connect.NewRequest(wrapperspb.Int32(n)))
+	if err != nil {
+		return nil, err
+	}
+	var values []int32
+	for stream.Receive() {
+		values = append(values, stream.Msg().Value)
+	}
+	if err := stream.Err(); err != nil {
+		_ = stream.Close()
+		return values, err
+	}
+	return values, stream.Close()
+}
@@ -0,0 +1,46 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conformance_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/bufbuild/connect-go/conformance"
+)
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+	conformance.CheckHandler(t, conformance.NewHandler())
+}
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+	options := map[string][]connect.ClientOption{
+		connect.ProtocolConnect: nil,
+		connect.ProtocolGRPC:    {connect.WithGRPC()},
+		connect.ProtocolGRPCWeb: {connect.WithGRPCWeb()},
+	}
+	for protocol, opts := range options {
+		protocol, opts := protocol, opts
+		t.Run(protocol, func(t *testing.T) {
+			t.Parallel()
+			conformance.CheckClient(t, protocol, func(httpClient *http.Client, baseURL string) conformance.Client {
+				return conformance.NewClient(httpClient, baseURL, opts...)
+			})
+		})
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// connectHTTPStatus is the HTTP status the Connect protocol uses for each
+// error code.
+var connectHTTPStatus = map[connect.Code]int{ //nolint:gochecknoglobals
+	connect.CodeCanceled:           408,
+	connect.CodeUnknown:            500,
+	connect.CodeInvalidArgument:    400,
+	connect.CodeDeadlineExceeded:   408,
+	connect.CodeNotFound:           404,
+	connect.CodeAlreadyExists:      409,
+	connect.CodePermissionDenied:   403,
+	connect.CodeResourceExhausted:  429,
+	connect.CodeFailedPrecondition: 412,
+	connect.CodeAborted:            409,
+	connect.CodeOutOfRange:         400,
+	connect.CodeUnimplemented:      404,
+	connect.CodeInternal:           500,
+	connect.CodeUnavailable:        503,
+	connect.CodeDataLoss:           500,
+	connect.CodeUnauthenticated:    401,
+}
+
+// CheckHandler verifies that handler implements the conformance service and
+// speaks the Connect, gRPC, and gRPC-Web protocols correctly. It sends
+// hand-built requests over HTTP/2 and checks the framing, error codes,
+// timeouts, trailers, and compression negotiation of the raw responses.
+func CheckHandler(t *testing.T, handler http.Handler) {
+	t.Helper()
+	server := httptest.NewUnstartedServer(handler)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	for _, protocol := range []string{connect.ProtocolConnect, connect.ProtocolGRPC, connect.ProtocolGRPCWeb} {
+		protocol := protocol
+		t.Run(protocol, func(t *testing.T) {
+			checker := &handlerChecker{server: server, protocol: protocol}
+			t.Run("framing", checker.checkFraming)
+			t.Run("trailers", checker.checkTrailers)
+			t.Run("codes", checker.checkCodes)
+			t.Run("timeout", checker.checkTimeout)
+			t.Run("compression", checker.checkCompression)
+			t.Run("streaming", checker.checkStreaming)
+		})
+	}
+}
+
+type handlerChecker struct {
+	server   *httptest.Server
+	protocol string
+}
+
+func (c *handlerChecker) unary() wire {
+	return wire{protocol: c.protocol}
+}
+
+func (c *handlerChecker) stream() wire {
+	return wire{protocol: c.protocol, streaming: true}
+}
+
+// call sends a request and decodes the response, failing the test if the
+// response is malformed.
+func (c *handlerChecker) call(
+	t *testing.T,
+	ctx context.Context, //nolint:revive
+	w wire,
+	procedure string,
+	body []byte,
+	header http.Header,
+) *result {
+	t.Helper()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.server.URL+procedure, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("build request: %v", err)
+	}
+	request.Header = header
+	response, err := c.server.Client().Do(request)
+	if err != nil {
+		t.Fatalf("%s: %v", procedure, err)
+	}
+	defer response.Body.Close()
+	res, err := w.decode(response)
+	if err != nil {
+		t.Fatalf("%s: malformed response: %v", procedure, err)
+	}
+	return res
+}
+
+// callMessage is like call, but builds the request from msg.
+func (c *handlerChecker) callMessage(t *testing.T, w wire, procedure string, msg proto.Message, compression string) *result {
+	t.Helper()
+	body, header, err := w.request(msg, compression)
+	if err != nil {
+		t.Fatalf("build request: %v", err)
+	}
+	return c.call(t, context.Background(), w, procedure, body, header)
+}
+
+func (c *handlerChecker) checkFraming(t *testing.T) {
+	res := c.callMessage(t, c.unary(), EchoProcedure, wrapperspb.String("framing"), "")
+	expectCode(t, res, 0)
+	if got, want := res.header.Get("Content-Type"), c.unary().contentType(); got != want {
+		t.Errorf("Content-Type %q, want %q", got, want)
+	}
+	if len(res.messages) != 1 {
+		t.Fatalf("got %d response messages, want 1", len(res.messages))
+	}
+	expectString(t, res.messages[0], "framing")
+
+	// A request whose envelope is shorter than its prefix claims must fail.
+	// Unary Connect requests aren't enveloped, so use a streaming call.
+	w := c.unary()
+	procedure := EchoProcedure
+	if !w.enveloped() {
+		w = c.stream()
+		procedure = CountProcedure
+	}
+	_, header, err := w.request(wrapperspb.String(""), "")
+	if err != nil {
+		t.Fatalf("build request: %v", err)
+	}
+	truncated := envelope(0, []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10})[:8]
+	res = c.call(t, context.Background(), w, procedure, truncated, header)
+	if res.code == 0 {
+		t.Error("truncated request envelope succeeded")
+	}
+}
+
+func (c *handlerChecker) checkTrailers(t *testing.T) {
+	res := c.callMessage(t, c.unary(), EchoProcedure, wrapperspb.String("trailer"), "")
+	expectCode(t, res, 0)
+	if got := res.trailer.Get(TrailerName); got != "trailer" {
+		t.Errorf("trailer %s is %q, want %q", TrailerName, got, "trailer")
+	}
+	if c.protocol != connect.ProtocolConnect && res.trailer.Get("Grpc-Status") != "0" {
+		t.Errorf("successful response has trailers %v, want grpc-status 0", res.trailer)
+	}
+}
+
+func (c *handlerChecker) checkCodes(t *testing.T) {
+	for code := connect.CodeCanceled; code <= connect.CodeUnauthenticated; code++ {
+		res := c.callMessage(t, c.unary(), FailProcedure, wrapperspb.Int32(int32(code)), "")
+		expectCode(t, res, code)
+		if res.message != failMessage {
+			t.Errorf("%v: message %q, want %q", code, res.message, failMessage)
+		}
+		want := http.StatusOK
+		if c.protocol == connect.ProtocolConnect {
+			want = connectHTTPStatus[code]
+		}
+		if res.status != want {
+			t.Errorf("%v: HTTP status %d, want %d", code, res.status, want)
+		}
+	}
+}
+
+func (c *handlerChecker) checkTimeout(t *testing.T) {
+	w := c.unary()
+	body, header, err := w.request(durationpb.New(time.Minute), "")
+	if err != nil {
+		t.Fatalf("build request: %v", err)
+	}
+	header.Set(w.timeoutHeader(), w.encodeTimeout(100))
+	// Bound the test even if the handler ignores the timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
+	res := c.call(t, ctx, w, SleepProcedure, body, header)
+	expectCode(t, res, connect.CodeDeadlineExceeded)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("call with 100ms timeout took %v", elapsed)
+	}
+}
+
+func (c *handlerChecker) checkCompression(t *testing.T) {
+	w := c.unary()
+	t.Run("gzip_request", func(t *testing.T) {
+		res := c.callMessage(t, w, EchoProcedure, wrapperspb.String("gzip"), "gzip")
+		expectCode(t, res, 0)
+		if len(res.messages) == 1 {
+			expectString(t, res.messages[0], "gzip")
+		}
+	})
+	t.Run("unknown_request", func(t *testing.T) {
+		res := c.callMessage(t, w, EchoProcedure, wrapperspb.String("unknown"), unknownCompression)
+		expectCode(t, res, connect.CodeUnimplemented)
+		if res.header.Get(w.acceptCompressionHeader()) == "" {
+			t.Errorf("unsupported compression rejected without %s", w.acceptCompressionHeader())
+		}
+	})
+	t.Run("uncompressed_response", func(t *testing.T) {
+		// Without an accept-encoding header, responses must not be compressed.
+		res := c.callMessage(t, w, EchoProcedure, wrapperspb.String("identity"), "")
+		expectCode(t, res, 0)
+		if got := res.header.Get(w.compressionHeader()); got != "" && got != "identity" {
+			t.Errorf("response compressed with %q, but the client didn't accept it", got)
+		}
+	})
+}
+
+func (c *handlerChecker) checkStreaming(t *testing.T) {
+	res := c.callMessage(t, c.stream(), CountProcedure, wrapperspb.Int32(3), "")
+	expectCode(t, res, 0)
+	if len(res.messages) != 3 {
+		t.Fatalf("got %d response messages, want 3", len(res.messages))
+	}
+	for i, data := range res.messages {
+		var msg wrapperspb.Int32Value
+		if err := proto.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("unmarshal response %d: %v", i, err)
+		}
+		if msg.Value != int32(i+1) {
+			t.Errorf("response %d is %d, want %d", i, msg.Value, i+1)
+		}
+	}
+}
+
+func expectCode(t *testing.T, res *result, want connect.Code) {
+	t.Helper()
+	if res.code != want {
+		t.Errorf("got code %d (%v), want %d (%v): %q", res.code, res.code, want, want, res.message)
+	}
+}
+
+func expectString(t *testing.T, data []byte, want string) {
+	t.Helper()
+	var msg wrapperspb.StringValue
+	if err := proto.Unmarshal(data, &msg); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+	if msg.Value != want {
+		t.Errorf("response %q, want %q", msg.Value, want)
+	}
+}
@@ -0,0 +1,315 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conformance
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/bufbuild/connect-go"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	flagCompressed       = 0b00000001
+	flagConnectEndStream = 0b00000010
+	flagGRPCWebTrailer   = 0b10000000
+
+	// unknownCompression is a compression algorithm no implementation
+	// supports.
+	unknownCompression = "conformance-unknown"
+)
+
+// wire describes how one protocol encodes a call.
+type wire struct {
+	protocol  string
+	streaming bool
+}
+
+func (w wire) enveloped() bool {
+	return w.protocol != connect.ProtocolConnect || w.streaming
+}
+
+func (w wire) contentType() string {
+	switch w.protocol {
+	case connect.ProtocolGRPC:
+		return "application/grpc+proto"
+	case connect.ProtocolGRPCWeb:
+		return "application/grpc-web+proto"
+	}
+	if w.streaming {
+		return "application/connect+proto"
+	}
+	return "application/proto"
+}
+
+func (w wire) compressionHeader() string {
+	if w.protocol != connect.ProtocolConnect {
+		return "Grpc-Encoding"
+	}
+	if w.streaming {
+		return "Connect-Content-Encoding"
+	}
+	return "Content-Encoding"
+}
+
+func (w wire) acceptCompressionHeader() string {
+	if w.protocol != connect.ProtocolConnect {
+		return "Grpc-Accept-Encoding"
+	}
+	if w.streaming {
+		return "Connect-Accept-Encoding"
+	}
+	return "Accept-Encoding"
+}
+
+func (w wire) timeoutHeader() string {
+	if w.protocol == connect.ProtocolConnect {
+		return "Connect-Timeout-Ms"
+	}
+	return "Grpc-Timeout"
+}
+
+func (w wire) encodeTimeout(milliseconds int) string {
+	if w.protocol == connect.ProtocolConnect {
+		return strconv.Itoa(milliseconds)
+	}
+	return strconv.Itoa(milliseconds) + "m"
+}
+
+// request builds the body and headers of a request carrying msg. If
+// compression is "gzip", the message is compressed.
+func (w wire) request(msg proto.Message, compression string) ([]byte, http.Header, error) {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	header := make(http.Header)
+	header.Set("Content-Type", w.contentType())
+	// Without an explicit Accept-Encoding, net/http asks for gzip and
+	// transparently decompresses responses, hiding what the handler did.
+	header.Set("Accept-Encoding", "identity")
+	if w.protocol == connect.ProtocolGRPC {
+		header.Set("Te", "trailers")
+	}
+	if w.protocol == connect.ProtocolConnect {
+		header.Set("Connect-Protocol-Version", "1")
+	}
+	var flags byte
+	if compression != "" {
+		header.Set(w.compressionHeader(), compression)
+		if compression == "gzip" {
+			if data, err = gzipBytes(data); err != nil {
+				return nil, nil, err
+			}
+			flags = flagCompressed
+		}
+	}
+	if !w.enveloped() {
+		return data, header, nil
+	}
+	return envelope(flags, data), header, nil
+}
+
+// result is a decoded response.
+type result struct {
+	status   int
+	header   http.Header
+	trailer  http.Header // protocol trailers, however they're encoded
+	messages [][]byte
+	code     connect.Code
+	message  string
+}
+
+func (w wire) decode(response *http.Response) (*result, error) {
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	res := &result{
+		status:  response.StatusCode,
+		header:  response.Header,
+		trailer: make(http.Header),
+	}
+	compression := response.Header.Get(w.compressionHeader())
+	if !w.enveloped() {
+		return res, w.decodeConnectUnary(res, body, compression)
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP status %d, want 200", response.StatusCode)
+	}
+	frames, err := readFrames(body)
+	if err != nil {
+		return nil, err
+	}
+	for _, frame := range frames {
+		data := frame.data
+		if frame.flags&flagCompressed != 0 {
+			if data, err = decompress(compression, data); err != nil {
+				return nil, err
+			}
+		}
+		switch {
+		case w.protocol == connect.ProtocolGRPCWeb && frame.flags&flagGRPCWebTrailer != 0:
+			trailer, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(append(data, '\r', '\n')))).ReadMIMEHeader()
+			if err != nil && !errors.Is(err, io.EOF) {
+				return nil, fmt.Errorf("invalid trailers frame: %w", err)
+			}
+			res.trailer = http.Header(trailer)
+		case w.protocol == connect.ProtocolConnect && frame.flags&flagConnectEndStream != 0:
+			if err := decodeEndStream(res, data); err != nil {
+				return nil, err
+			}
+			return res, nil
+		default:
+			res.messages = append(res.messages, data)
+		}
+	}
+	switch w.protocol {
+	case connect.ProtocolConnect:
+		return nil, errors.New("missing end-of-stream message")
+	case connect.ProtocolGRPC:
+		for key, values := range response.Trailer {
+			res.trailer[key] = values
+		}
+	}
+	// Trailers-only responses put the status in the headers.
+	status := res.trailer.Get("Grpc-Status")
+	if status == "" {
+		status = response.Header.Get("Grpc-Status")
+		res.message = response.Header.Get("Grpc-Message")
+	} else {
+		res.message = res.trailer.Get("Grpc-Message")
+	}
+	if status == "" {
+		return nil, errors.New("missing grpc-status")
+	}
+	code, err := strconv.ParseUint(status, 10 /* base */, 32 /* bitsize */)
+	if err != nil {
+		return nil, fmt.Errorf("invalid grpc-status %q", status)
+	}
+	res.code = connect.Code(code)
+	return res, nil
+}
+
+func (w wire) decodeConnectUnary(res *result, body []byte, compression string) error {
+	for key, values := range res.header {
+		if strings.HasPrefix(key, "Trailer-") {
+			res.trailer[strings.TrimPrefix(key, "Trailer-")] = values
+		}
+	}
+	if compression != "" && compression != "identity" {
+		var err error
+		if body, err = decompress(compression, body); err != nil {
+			return err
+		}
+	}
+	if res.status == http.StatusOK {
+		res.messages = [][]byte{body}
+		return nil
+	}
+	var wireErr struct {
+		Code    connect.Code `json:"code"`
+		Message string       `json:"message"`
+	}
+	if err := json.Unmarshal(body, &wireErr); err != nil {
+		return fmt.Errorf("invalid error body %q: %w", body, err)
+	}
+	res.code = wireErr.Code
+	res.message = wireErr.Message
+	return nil
+}
+
+func decodeEndStream(res *result, data []byte) error {
+	var end struct {
+		Error *struct {
+			Code    connect.Code `json:"code"`
+			Message string       `json:"message"`
+		} `json:"error"`
+		Metadata http.Header `json:"metadata"`
+	}
+	if err := json.Unmarshal(data, &end); err != nil {
+		return fmt.Errorf("invalid end-of-stream message %q: %w", data, err)
+	}
+	if end.Error != nil {
+		res.code = end.Error.Code
+		res.message = end.Error.Message
+	}
+	for key, values := range end.Metadata {
+		res.trailer[http.CanonicalHeaderKey(key)] = values
+	}
+	return nil
+}
+
+type frame struct {
+	flags byte
+	data  []byte
+}
+
+func envelope(flags byte, data []byte) []byte {
+	out := make([]byte, 5, 5+len(data))
+	out[0] = flags
+	binary.BigEndian.PutUint32(out[1:], uint32(len(data)))
+	return append(out, data...)
+}
+
+func readFrames(body []byte) ([]frame, error) {
+	var frames []frame
+	for len(body) > 0 {
+		if len(body) < 5 {
+			return nil, fmt.Errorf("truncated envelope prefix: %d bytes", len(body))
+		}
+		size := binary.BigEndian.Uint32(body[1:5])
+		if uint64(len(body)-5) < uint64(size) {
+			return nil, fmt.Errorf("truncated envelope: want %d bytes, got %d", size, len(body)-5)
+		}
+		frames = append(frames, frame{flags: body[0], data: body[5 : 5+size]})
+		body = body[5+size:]
+	}
+	return frames, nil
+}
+
+func gzipBytes(data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	writer := gzip.NewWriter(&out)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func decompress(compression string, data []byte) ([]byte, error) {
+	if compression != "gzip" {
+		return nil, fmt.Errorf("compressed message with unsupported compression %q", compression)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
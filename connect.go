@@ -126,7 +126,9 @@ func (r *Request[_]) Spec() Spec {
 	return r.spec
 }
 
-// Header returns the HTTP headers for this request.
+// Header returns the HTTP headers for this request. Calls fail with
+// CodeInternal if the caller or an interceptor sets headers reserved for the
+// protocol, like Content-Type and Grpc-Timeout.
 func (r *Request[_]) Header() http.Header {
 	if r.header == nil {
 		r.header = make(http.Header)
@@ -157,8 +159,9 @@ type AnyRequest interface {
 type Response[T any] struct {
 	Msg *T
 
-	header  http.Header
-	trailer http.Header
+	header    http.Header
+	trailer   http.Header
+	callStats *CallStats
 }
 
 // NewResponse wraps a generated response message.
@@ -177,7 +180,9 @@ func (r *Response[_]) Any() any {
 	return r.Msg
 }
 
-// Header returns the HTTP headers for this response.
+// Header returns the HTTP headers for this response. Handlers that set headers
+// reserved for the protocol, like Content-Type and Grpc-Status, fail with
+// CodeInternal.
 func (r *Response[_]) Header() http.Header {
 	if r.header == nil {
 		r.header = make(http.Header)
@@ -195,6 +200,16 @@ func (r *Response[_]) Trailer() http.Header {
 	return r.trailer
 }
 
+// CallStats returns the latency breakdown of the call that produced this
+// response. It's nil unless the client was constructed with WithCallStats.
+func (r *Response[_]) CallStats() *CallStats {
+	return r.callStats
+}
+
+func (r *Response[_]) setCallStats(stats *CallStats) {
+	r.callStats = stats
+}
+
 // internalOnly implements AnyResponse.
 func (r *Response[_]) internalOnly() {}
 
@@ -223,30 +238,76 @@ type Spec struct {
 	StreamType StreamType
 	Procedure  string // for example, "/acme.foo.v1.FooService/Bar"
 	IsClient   bool   // otherwise we're in a handler
+	// IdempotencyLevel is the procedure's idempotency level, as configured
+	// with WithIdempotency.
+	IdempotencyLevel IdempotencyLevel
+
+	compression Compression
+}
+
+// Compression returns the compression negotiated for the call. See
+// Compression for details.
+func (s Spec) Compression() Compression {
+	return s.compression
+}
+
+// Compression describes the compression algorithms used by a call, named as
+// in WithCompression. Uncompressed messages use "identity", and names are
+// empty if negotiation failed.
+//
+// Handlers know both algorithms once the call's headers are parsed. Clients
+// choose the request compression up front, but only learn the response
+// compression from the server's headers, so Response is always empty in
+// client specs. Small messages may be sent uncompressed even when an algorithm
+// has been negotiated; see WithCompressMinBytes.
+type Compression struct {
+	Request  string
+	Response string
 }
 
 // receiveUnaryResponse unmarshals a message from a Receiver, then envelopes
 // the message and attaches the Receiver's headers and trailers. It attempts to
 // consume the Receiver and isn't appropriate when receiving multiple messages.
 func receiveUnaryResponse[T any](receiver Receiver) (*Response[T], error) {
-	var msg T
-	if err := receiver.Receive(&msg); err != nil {
+	// For small messages, allocating the wrapper and message separately is a
+	// noticeable fraction of the cost of a call, so we allocate them together.
+	both := &struct {
+		response Response[T]
+		msg      T
+	}{}
+	if err := receiver.Receive(&both.msg); err != nil {
 		return nil, err
 	}
 	// In a well-formed stream, the response message may be followed by a block
 	// of in-stream trailers or HTTP trailers. To ensure that we receive the
-	// trailers, try to read another message from the stream.
-	if err := receiver.Receive(new(T)); err == nil {
+	// trailers, try to read another message from the stream. If there is one,
+	// we return an error and discard msg, so there's no need to allocate
+	// another message to receive into.
+	if err := receiver.Receive(&both.msg); err == nil {
 		return nil, NewError(CodeUnknown, errors.New("unary stream has multiple messages"))
 	} else if err != nil && !errors.Is(err, io.EOF) {
 		return nil, NewError(CodeUnknown, err)
 	}
-	response := &Response[T]{
-		Msg:    &msg,
-		header: receiver.Header(),
-	}
+	response := &both.response
+	response.Msg = &both.msg
+	response.header = receiver.Header()
 	if trailer, ok := receiver.Trailer(); ok {
 		response.trailer = trailer
 	}
 	return response, nil
 }
+
+// newReceivedRequest allocates a Request and its message together, which
+// halves the wrapper allocations on the unary handler path.
+func newReceivedRequest[T any](spec Spec, header http.Header) *Request[T] {
+	both := &struct {
+		request Request[T]
+		msg     T
+	}{}
+	both.request = Request[T]{
+		Msg:    &both.msg,
+		spec:   spec,
+		header: header,
+	}
+	return &both.request
+}
@@ -23,8 +23,11 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -32,7 +35,9 @@ import (
 	"github.com/bufbuild/connect-go/internal/assert"
 	pingv1 "github.com/bufbuild/connect-go/internal/gen/connect/ping/v1"
 	"github.com/bufbuild/connect-go/internal/gen/connect/ping/v1/pingv1connect"
+	statusv1 "github.com/bufbuild/connect-go/internal/gen/connectext/grpc/status/v1"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
 )
 
 const errorMessage = "oh no"
@@ -127,6 +132,19 @@ func TestServer(t *testing.T) {
 			}
 			_, err := stream.CloseAndReceive()
 			assert.Equal(t, connect.CodeOf(err), connect.CodeInvalidArgument)
+			_, again := stream.CloseAndReceive()
+			assert.True(t, again == err)
+		})
+		t.Run("sum_close_and_receive_twice", func(t *testing.T) {
+			stream := client.Sum(context.Background())
+			stream.RequestHeader().Set(clientHeader, headerValue)
+			assert.Nil(t, stream.Send(&pingv1.SumRequest{Number: 2}))
+			response, err := stream.CloseAndReceive()
+			assert.Nil(t, err)
+			assert.Equal(t, response.Msg.Sum, 2)
+			again, err := stream.CloseAndReceive()
+			assert.Nil(t, err)
+			assert.True(t, again == response)
 		})
 		t.Run("sum_close_and_receive_without_send", func(t *testing.T) {
 			stream := client.Sum(context.Background())
@@ -373,6 +391,94 @@ func TestServer(t *testing.T) {
 		defer server.Close()
 		testMatrix(t, server, true /* bidi */)
 	})
+	t.Run("transcoder", func(t *testing.T) {
+		t.Parallel()
+		upstream := httptest.NewUnstartedServer(mux)
+		upstream.EnableHTTP2 = true
+		upstream.StartTLS()
+		defer upstream.Close()
+		transcoder := http.NewServeMux()
+		handleTranscoded(transcoder, upstream, connect.WithGRPC())
+		server := httptest.NewUnstartedServer(transcoder)
+		server.EnableHTTP2 = true
+		server.StartTLS()
+		defer server.Close()
+		testMatrix(t, server, true /* bidi */)
+	})
+}
+
+// handleTranscoded registers transcoders for the PingService that forward to
+// the upstream server.
+func handleTranscoded(mux *http.ServeMux, upstream *httptest.Server, opts ...connect.ClientOption) {
+	procedure := func(method string) string {
+		return "/" + pingv1connect.PingServiceName + "/" + method
+	}
+	mux.Handle(procedure("Ping"), connect.NewUnaryTranscoder(
+		procedure("Ping"),
+		connect.NewClient[pingv1.PingRequest, pingv1.PingResponse](
+			upstream.Client(), upstream.URL+procedure("Ping"), opts...,
+		),
+	))
+	mux.Handle(procedure("Fail"), connect.NewUnaryTranscoder(
+		procedure("Fail"),
+		connect.NewClient[pingv1.FailRequest, pingv1.FailResponse](
+			upstream.Client(), upstream.URL+procedure("Fail"), opts...,
+		),
+	))
+	mux.Handle(procedure("Sum"), connect.NewClientStreamTranscoder(
+		procedure("Sum"),
+		connect.NewClient[pingv1.SumRequest, pingv1.SumResponse](
+			upstream.Client(), upstream.URL+procedure("Sum"), opts...,
+		),
+	))
+	mux.Handle(procedure("CountUp"), connect.NewServerStreamTranscoder(
+		procedure("CountUp"),
+		connect.NewClient[pingv1.CountUpRequest, pingv1.CountUpResponse](
+			upstream.Client(), upstream.URL+procedure("CountUp"), opts...,
+		),
+	))
+	mux.Handle(procedure("CumSum"), connect.NewBidiStreamTranscoder(
+		procedure("CumSum"),
+		connect.NewClient[pingv1.CumSumRequest, pingv1.CumSumResponse](
+			upstream.Client(), upstream.URL+procedure("CumSum"), opts...,
+		),
+	))
+}
+
+func TestBidiStreamTranscoderUpstreamError(t *testing.T) {
+	t.Parallel()
+	upstreamMux := http.NewServeMux()
+	upstreamMux.Handle(pingv1connect.NewPingServiceHandler(failingCumSumServer{}))
+	upstream := httptest.NewUnstartedServer(upstreamMux)
+	upstream.EnableHTTP2 = true
+	upstream.StartTLS()
+	t.Cleanup(upstream.Close)
+	transcoder := http.NewServeMux()
+	handleTranscoded(transcoder, upstream, connect.WithGRPC())
+	server := httptest.NewUnstartedServer(transcoder)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL, connect.WithGRPC())
+	stream := client.CumSum(context.Background())
+	assert.Nil(t, stream.Send(&pingv1.CumSumRequest{Number: 1}))
+	// The client never closes its side of the stream, but the transcoder
+	// still returns the upstream's error.
+	_, err := stream.Receive()
+	assert.Equal(t, connect.CodeOf(err), connect.CodeResourceExhausted)
+	assert.Nil(t, stream.CloseSend())
+	assert.Nil(t, stream.CloseReceive())
+}
+
+type failingCumSumServer struct {
+	pingv1connect.UnimplementedPingServiceHandler
+}
+
+func (failingCumSumServer) CumSum(
+	ctx context.Context,
+	stream *connect.BidiStream[pingv1.CumSumRequest, pingv1.CumSumResponse],
+) error {
+	return connect.NewError(connect.CodeResourceExhausted, errors.New("oh no"))
 }
 
 func TestHeaderBasic(t *testing.T) {
@@ -465,6 +571,98 @@ func TestGRPCMarshalStatusError(t *testing.T) {
 	assertInternalError(t, connect.WithGRPCWeb())
 }
 
+func TestGRPCStatusDetails(t *testing.T) {
+	t.Parallel()
+	newError := func() error {
+		err := connect.NewError(connect.CodeFailedPrecondition, errors.New(errorMessage))
+		detail, anyErr := anypb.New(&pingv1.PingRequest{Text: "detail"})
+		assert.Nil(t, anyErr)
+		err.AddDetail(detail)
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(&pluggablePingServer{
+		ping: func(context.Context, *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+			return nil, newError()
+		},
+	}))
+	server := httptest.NewUnstartedServer(mux)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(func() {
+		server.Close()
+	})
+	// Other gRPC implementations only look at grpc-status-details-bin, so
+	// check the raw metadata rather than the error's details.
+	for _, opt := range []connect.ClientOption{connect.WithGRPC(), connect.WithGRPCWeb()} {
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL, opt)
+		_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		var connectErr *connect.Error
+		assert.True(t, errors.As(err, &connectErr))
+		encoded := connectErr.Meta().Get("Grpc-Status-Details-Bin")
+		assert.NotZero(t, encoded)
+		raw, decodeErr := connect.DecodeBinaryHeader(encoded)
+		assert.Nil(t, decodeErr)
+		var status statusv1.Status
+		assert.Nil(t, proto.Unmarshal(raw, &status))
+		assert.Equal(t, status.Code, int32(connect.CodeFailedPrecondition))
+		assert.Equal(t, status.Message, errorMessage)
+		assert.Equal(t, len(status.Details), 1)
+		assert.Equal(t, status.Details[0].TypeUrl, "type.googleapis.com/connect.ping.v1.PingRequest")
+		var detail pingv1.PingRequest
+		assert.Nil(t, status.Details[0].UnmarshalTo(&detail))
+		assert.Equal(t, detail.Text, "detail")
+	}
+}
+
+func TestGRPCUpstreamStatusDetails(t *testing.T) {
+	t.Parallel()
+	detail, err := anypb.New(&pingv1.PingRequest{Text: "detail"})
+	assert.Nil(t, err)
+	status, err := proto.Marshal(&statusv1.Status{
+		Code:    int32(connect.CodeInvalidArgument),
+		Message: errorMessage,
+		Details: []*anypb.Any{detail},
+	})
+	assert.Nil(t, err)
+	// Emulate grpc-go, which sends errors without a body. Depending on whether
+	// it's already sent headers, it uses either a trailers-only response or
+	// HTTP trailers.
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix := ""
+		if r.Header.Get("Trailers-Only") == "" {
+			prefix = http.TrailerPrefix
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set(prefix+"Grpc-Status", strconv.Itoa(int(connect.CodeInvalidArgument)))
+		w.Header().Set(prefix+"Grpc-Message", errorMessage)
+		w.Header().Set(prefix+"Grpc-Status-Details-Bin", connect.EncodeBinaryHeader(status))
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(func() {
+		server.Close()
+	})
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL, connect.WithGRPC())
+	for _, trailersOnly := range []bool{true, false} {
+		request := connect.NewRequest(&pingv1.PingRequest{})
+		if trailersOnly {
+			request.Header().Set("Trailers-Only", "1")
+		}
+		_, err := client.Ping(context.Background(), request)
+		var connectErr *connect.Error
+		assert.True(t, errors.As(err, &connectErr), assert.Sprintf("trailers-only: %v", trailersOnly))
+		assert.Equal(t, connectErr.Code(), connect.CodeInvalidArgument)
+		assert.Equal(t, connectErr.Message(), errorMessage)
+		details := connectErr.Details()
+		assert.Equal(t, len(details), 1)
+		var msg pingv1.PingRequest
+		assert.Nil(t, details[0].UnmarshalTo(&msg))
+		assert.Equal(t, msg.Text, "detail")
+	}
+}
+
 func TestUnavailableIfHostInvalid(t *testing.T) {
 	t.Parallel()
 	client := pingv1connect.NewPingServiceClient(
@@ -542,6 +740,120 @@ func TestCompressMinBytes(t *testing.T) {
 	})
 }
 
+func TestCompressionSelector(t *testing.T) {
+	t.Parallel()
+	const pingProcedure = "/" + pingv1connect.PingServiceName + "/Ping"
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		pingServer{},
+		connect.WithCompressionSelector(func(procedure string, acceptEncoding []string) string {
+			if procedure == pingProcedure {
+				return "identity"
+			}
+			return "gzip"
+		}),
+	))
+	server := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		server.Close()
+	})
+	t.Run("connect", func(t *testing.T) {
+		t.Parallel()
+		request, err := http.NewRequest(
+			http.MethodPost,
+			server.URL+pingProcedure,
+			strings.NewReader(`{"text": "`+strings.Repeat("ping", 64)+`"}`),
+		)
+		assert.Nil(t, err)
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("Accept-Encoding", "gzip")
+		response, err := server.Client().Do(request)
+		assert.Nil(t, err)
+		defer response.Body.Close()
+		assert.Equal(t, response.StatusCode, http.StatusOK)
+		assert.Equal(t, response.Header.Get("Content-Encoding"), "")
+	})
+	t.Run("grpc", func(t *testing.T) {
+		t.Parallel()
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL, connect.WithGRPC())
+		response, err := client.Ping(
+			context.Background(),
+			connect.NewRequest(&pingv1.PingRequest{Text: strings.Repeat("ping", 64)}),
+		)
+		assert.Nil(t, err)
+		assert.Equal(t, response.Header().Get("Grpc-Encoding"), "")
+		stream, err := client.CountUp(
+			context.Background(),
+			connect.NewRequest(&pingv1.CountUpRequest{Number: 1}),
+		)
+		assert.Nil(t, err)
+		assert.True(t, stream.Receive())
+		assert.Equal(t, stream.ResponseHeader().Get("Grpc-Encoding"), "gzip")
+		assert.Nil(t, stream.Close())
+	})
+}
+
+func TestResponseCompression(t *testing.T) {
+	t.Parallel()
+	// newServer records the Accept-Encoding of each request and the
+	// Content-Encoding of each response.
+	newServer := func(t *testing.T, options ...connect.HandlerOption) (*httptest.Server, *[2]string) {
+		t.Helper()
+		var encodings [2]string
+		mux := http.NewServeMux()
+		mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}, options...))
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mux.ServeHTTP(w, r)
+			encodings = [2]string{r.Header.Get("Accept-Encoding"), w.Header().Get("Content-Encoding")}
+		}))
+		t.Cleanup(server.Close)
+		return server, &encodings
+	}
+	ping := func(t *testing.T, client pingv1connect.PingServiceClient) {
+		t.Helper()
+		response, err := client.Ping(
+			context.Background(),
+			connect.NewRequest(&pingv1.PingRequest{Text: strings.Repeat("ping", 64)}),
+		)
+		assert.Nil(t, err)
+		assert.Equal(t, response.Msg.Text, strings.Repeat("ping", 64))
+	}
+	t.Run("symmetric", func(t *testing.T) {
+		t.Parallel()
+		server, encodings := newServer(t)
+		ping(t, pingv1connect.NewPingServiceClient(server.Client(), server.URL, connect.WithSendGzip()))
+		assert.Equal(t, encodings[1], "gzip")
+	})
+	t.Run("handler", func(t *testing.T) {
+		t.Parallel()
+		server, encodings := newServer(t, connect.WithResponseCompression("identity"))
+		ping(t, pingv1connect.NewPingServiceClient(server.Client(), server.URL, connect.WithSendGzip()))
+		assert.Equal(t, encodings[1], "")
+	})
+	t.Run("client", func(t *testing.T) {
+		t.Parallel()
+		server, encodings := newServer(t)
+		ping(t, pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL,
+			connect.WithSendGzip(),
+			connect.WithResponseCompression("identity"),
+		))
+		assert.Equal(t, encodings[0], "identity")
+		assert.Equal(t, encodings[1], "")
+	})
+	t.Run("client_unknown", func(t *testing.T) {
+		t.Parallel()
+		client := pingv1connect.NewPingServiceClient(
+			http.DefaultClient,
+			"http://localhost",
+			connect.WithResponseCompression("zstd"),
+		)
+		_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Equal(t, connect.CodeOf(err), connect.CodeUnknown)
+	})
+}
+
 func TestCustomCompression(t *testing.T) {
 	t.Parallel()
 	mux := http.NewServeMux()
@@ -575,6 +887,689 @@ func TestCustomCompression(t *testing.T) {
 	assert.Equal(t, response.Msg, &pingv1.PingResponse{Text: request.Text})
 }
 
+func TestDeflateDictionary(t *testing.T) {
+	t.Parallel()
+	const (
+		compressionName = "deflate-ping"
+		pingProcedure   = "/" + pingv1connect.PingServiceName + "/Ping"
+	)
+	dictionary := []byte(strings.Repeat("connect ping dictionary ", 8))
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		pingServer{},
+		connect.WithProcedureOptions(
+			pingProcedure,
+			connect.WithDeflateDictionary(compressionName, dictionary),
+		),
+	))
+	server := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		server.Close()
+	})
+	for _, protocol := range []string{connect.ProtocolConnect, connect.ProtocolGRPCWeb} {
+		client := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL,
+			connect.WithProtocol(protocol),
+			connect.WithDeflateDictionary(compressionName, dictionary),
+			connect.WithSendCompression(compressionName),
+		)
+		request := &pingv1.PingRequest{Text: "connect ping dictionary"}
+		response, err := client.Ping(context.Background(), connect.NewRequest(request))
+		assert.Nil(t, err, assert.Sprintf("%s: ping", protocol))
+		assert.Equal(t, response.Msg, &pingv1.PingResponse{Text: request.Text})
+
+		// Other procedures don't support the dictionary.
+		_, err = client.Fail(context.Background(), connect.NewRequest(&pingv1.FailRequest{}))
+		assert.Equal(t, connect.CodeOf(err), connect.CodeUnimplemented, assert.Sprintf("%s: fail", protocol))
+	}
+}
+
+func TestLazyUnmarshal(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		pingServer{},
+		connect.WithLazyUnmarshal(),
+	))
+	server := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		server.Close()
+	})
+	client := pingv1connect.NewPingServiceClient(
+		server.Client(),
+		server.URL,
+		connect.WithLazyUnmarshal(),
+	)
+	t.Run("client_stream", func(t *testing.T) {
+		t.Parallel()
+		stream := client.Sum(context.Background())
+		for i := int64(0); i < 4; i++ {
+			assert.Nil(t, stream.Send(&pingv1.SumRequest{Number: i}))
+		}
+		response, err := stream.CloseAndReceive()
+		assert.Nil(t, err)
+		assert.Equal(t, response.Msg.Sum, 6)
+	})
+	t.Run("server_stream", func(t *testing.T) {
+		t.Parallel()
+		stream, err := client.CountUp(
+			context.Background(),
+			connect.NewRequest(&pingv1.CountUpRequest{Number: 5}),
+		)
+		assert.Nil(t, err)
+		var got []int64
+		for stream.Receive() {
+			// Skip decoding odd messages entirely.
+			if len(got)%2 == 1 {
+				got = append(got, 0)
+				continue
+			}
+			got = append(got, stream.Msg().Number)
+		}
+		assert.Nil(t, stream.Err())
+		assert.Nil(t, stream.Close())
+		assert.Equal(t, got, []int64{1, 0, 3, 0, 5})
+	})
+	t.Run("interceptors", func(t *testing.T) {
+		t.Parallel()
+		// Interceptors must never see the lazy placeholder.
+		checker := &typedReceiveInterceptor{
+			UnaryInterceptorFunc: func(next connect.UnaryFunc) connect.UnaryFunc { return next },
+		}
+		client := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL,
+			connect.WithLazyUnmarshal(),
+			connect.WithInterceptors(checker),
+		)
+		stream, err := client.CountUp(
+			context.Background(),
+			connect.NewRequest(&pingv1.CountUpRequest{Number: 2}),
+		)
+		assert.Nil(t, err)
+		for stream.Receive() {
+			assert.NotZero(t, stream.Msg().Number)
+		}
+		assert.Nil(t, stream.Err())
+		assert.Nil(t, stream.Close())
+		assert.Equal(t, atomic.LoadInt32(&checker.received), 2)
+		assert.Equal(t, atomic.LoadInt32(&checker.untyped), 0)
+	})
+	t.Run("validation", func(t *testing.T) {
+		t.Parallel()
+		// Validators must see every message, so lazy unmarshaling is off.
+		mux := http.NewServeMux()
+		mux.Handle(pingv1connect.NewPingServiceHandler(
+			pingServer{},
+			connect.WithLazyUnmarshal(),
+			connect.WithValidator(positiveValidator{}),
+			connect.WithValidation(),
+		))
+		server := httptest.NewServer(mux)
+		t.Cleanup(server.Close)
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL)
+		stream := client.Sum(context.Background())
+		assert.Nil(t, stream.Send(&pingv1.SumRequest{Number: -1}))
+		_, err := stream.CloseAndReceive()
+		assert.Equal(t, connect.CodeOf(err), connect.CodeInvalidArgument)
+	})
+}
+
+// typedReceiveInterceptor counts the messages its stream receivers see, and
+// how many of them aren't generated CountUpResponses.
+type typedReceiveInterceptor struct {
+	connect.UnaryInterceptorFunc
+
+	received int32
+	untyped  int32
+}
+
+func (i *typedReceiveInterceptor) WrapStreamReceiver(_ context.Context, receiver connect.Receiver) connect.Receiver {
+	return &typedReceiver{Receiver: receiver, interceptor: i}
+}
+
+type typedReceiver struct {
+	connect.Receiver
+
+	interceptor *typedReceiveInterceptor
+}
+
+func (r *typedReceiver) Receive(msg any) error {
+	if err := r.Receiver.Receive(msg); err != nil {
+		return err
+	}
+	atomic.AddInt32(&r.interceptor.received, 1)
+	if _, ok := msg.(*pingv1.CountUpResponse); !ok {
+		atomic.AddInt32(&r.interceptor.untyped, 1)
+	}
+	return nil
+}
+
+func TestStreamBatches(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(batchPingServer{t: t}))
+	server := httptest.NewUnstartedServer(mux)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(func() {
+		server.Close()
+	})
+	for _, protocol := range []string{connect.ProtocolConnect, connect.ProtocolGRPC, connect.ProtocolGRPCWeb} {
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL, connect.WithProtocol(protocol))
+		t.Run(protocol+"/client_stream", func(t *testing.T) {
+			stream := client.Sum(context.Background())
+			assert.Nil(t, stream.SendBatch([]*pingv1.SumRequest{
+				{Number: 1},
+				{Number: 2},
+				{Number: 3},
+			}))
+			response, err := stream.CloseAndReceive()
+			assert.Nil(t, err)
+			assert.Equal(t, response.Msg.Sum, 6)
+		})
+		t.Run(protocol+"/server_stream", func(t *testing.T) {
+			stream, err := client.CountUp(
+				context.Background(),
+				connect.NewRequest(&pingv1.CountUpRequest{Number: 3}),
+			)
+			assert.Nil(t, err)
+			responses, err := stream.ReceiveBatch(2)
+			assert.Nil(t, err)
+			assert.Equal(t, len(responses), 2)
+			assert.Equal(t, responses[1].Number, 2)
+			responses, err = stream.ReceiveBatch(2)
+			assert.ErrorIs(t, err, io.EOF)
+			assert.Equal(t, len(responses), 1)
+			assert.Equal(t, responses[0].Number, 3)
+			assert.False(t, stream.Receive())
+			assert.Nil(t, stream.Err())
+			assert.Nil(t, stream.Close())
+		})
+		t.Run(protocol+"/bidi_stream", func(t *testing.T) {
+			stream := client.CumSum(context.Background())
+			assert.Nil(t, stream.SendBatch([]*pingv1.CumSumRequest{
+				{Number: 1},
+				{Number: 2},
+				{Number: 3},
+			}))
+			assert.Nil(t, stream.CloseSend())
+			responses, err := stream.ReceiveBatch(2)
+			assert.Nil(t, err)
+			assert.Equal(t, len(responses), 2)
+			assert.Equal(t, responses[1].Sum, 3)
+			responses, err = stream.ReceiveBatch(2)
+			assert.ErrorIs(t, err, io.EOF)
+			assert.Equal(t, len(responses), 1)
+			assert.Equal(t, responses[0].Sum, 6)
+			assert.Nil(t, stream.CloseReceive())
+		})
+	}
+}
+
+// batchPingServer receives and sends messages in batches.
+type batchPingServer struct {
+	pingServer
+
+	t *testing.T
+}
+
+func (p batchPingServer) Sum(
+	ctx context.Context,
+	stream *connect.ClientStream[pingv1.SumRequest],
+) (*connect.Response[pingv1.SumResponse], error) {
+	var sum int64
+	for {
+		batch, err := stream.ReceiveBatch(2)
+		for _, msg := range batch {
+			sum += msg.Number
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	// The stream remembers the end of the batches.
+	assert.False(p.t, stream.Receive())
+	assert.Nil(p.t, stream.Err())
+	return connect.NewResponse(&pingv1.SumResponse{Sum: sum}), nil
+}
+
+func (p batchPingServer) CountUp(
+	ctx context.Context,
+	request *connect.Request[pingv1.CountUpRequest],
+	stream *connect.ServerStream[pingv1.CountUpResponse],
+) error {
+	batch := make([]*pingv1.CountUpResponse, 0, request.Msg.Number)
+	for i := int64(1); i <= request.Msg.Number; i++ {
+		batch = append(batch, &pingv1.CountUpResponse{Number: i})
+	}
+	return stream.SendBatch(batch)
+}
+
+func TestSendUncompressed(t *testing.T) {
+	t.Parallel()
+	const countUp = "/" + pingv1connect.PingServiceName + "/CountUp"
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}))
+	mux.Handle(countUp, connect.NewServerStreamHandler(
+		countUp,
+		func(
+			ctx context.Context,
+			request *connect.Request[pingv1.CountUpRequest],
+			stream *connect.ServerStream[pingv1.CountUpResponse],
+		) error {
+			// Alternate between compressed and uncompressed messages.
+			for i := int64(1); i <= request.Msg.Number; i++ {
+				send := stream.Send
+				if i%2 == 0 {
+					send = stream.SendUncompressed
+				}
+				if err := send(&pingv1.CountUpResponse{Number: i}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	))
+	server := httptest.NewUnstartedServer(mux)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	t.Run("handler", func(t *testing.T) {
+		t.Parallel()
+		message, err := proto.Marshal(&pingv1.CountUpRequest{Number: 3})
+		assert.Nil(t, err)
+		body := append([]byte{0, 0, 0, 0, byte(len(message))}, message...)
+		request, err := http.NewRequest(http.MethodPost, server.URL+countUp, bytes.NewReader(body))
+		assert.Nil(t, err)
+		request.Header.Set("Content-Type", "application/connect+proto")
+		request.Header.Set("Connect-Accept-Encoding", "gzip")
+		response, err := server.Client().Do(request)
+		assert.Nil(t, err)
+		defer response.Body.Close()
+		assert.Equal(t, response.Header.Get("Connect-Content-Encoding"), "gzip")
+		var compressed []bool
+		for {
+			var prefix [5]byte
+			if _, err := io.ReadFull(response.Body, prefix[:]); err != nil {
+				break
+			}
+			size := int64(prefix[1])<<24 | int64(prefix[2])<<16 | int64(prefix[3])<<8 | int64(prefix[4])
+			_, err := io.CopyN(io.Discard, response.Body, size)
+			assert.Nil(t, err)
+			if prefix[0]&0b10 == 0 { // skip the end of stream message
+				compressed = append(compressed, prefix[0]&0b01 != 0)
+			}
+		}
+		assert.Equal(t, compressed, []bool{true, false, true})
+	})
+	for _, protocol := range []string{connect.ProtocolConnect, connect.ProtocolGRPC, connect.ProtocolGRPCWeb} {
+		protocol := protocol
+		t.Run(protocol, func(t *testing.T) {
+			t.Parallel()
+			client := pingv1connect.NewPingServiceClient(
+				server.Client(),
+				server.URL,
+				connect.WithProtocol(protocol),
+				connect.WithSendGzip(),
+			)
+			clientStream := client.Sum(context.Background())
+			assert.Nil(t, clientStream.Send(&pingv1.SumRequest{Number: 1}))
+			assert.Nil(t, clientStream.SendUncompressed(&pingv1.SumRequest{Number: 2}))
+			response, err := clientStream.CloseAndReceive()
+			assert.Nil(t, err)
+			assert.Equal(t, response.Msg.Sum, 3)
+
+			bidiStream := client.CumSum(context.Background())
+			assert.Nil(t, bidiStream.SendUncompressed(&pingv1.CumSumRequest{Number: 2}))
+			sum, err := bidiStream.Receive()
+			assert.Nil(t, err)
+			assert.Equal(t, sum.Sum, 2)
+			assert.Nil(t, bidiStream.CloseSend())
+			assert.Nil(t, bidiStream.CloseReceive())
+
+			stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 3}))
+			assert.Nil(t, err)
+			var got []int64
+			for stream.Receive() {
+				got = append(got, stream.Msg().Number)
+			}
+			assert.Nil(t, stream.Err())
+			assert.Nil(t, stream.Close())
+			assert.Equal(t, got, []int64{1, 2, 3})
+		})
+	}
+}
+
+func TestBidiConcurrency(t *testing.T) {
+	t.Parallel()
+	const messages = 100
+	procedure := "/" + pingv1connect.PingServiceName + "/CumSum"
+	options := connect.WithSlowRequestLog(time.Hour, func(context.Context, string) {})
+	mux := http.NewServeMux()
+	mux.Handle(procedure, connect.NewBidiStreamHandler(
+		procedure,
+		func(ctx context.Context, stream *connect.BidiStream[pingv1.CumSumRequest, pingv1.CumSumResponse]) error {
+			// Receive on one goroutine and send on another.
+			numbers := make(chan int64)
+			received := make(chan error, 1)
+			go func() {
+				defer close(numbers)
+				for {
+					msg, err := stream.Receive()
+					if err != nil {
+						received <- err
+						return
+					}
+					numbers <- msg.Number
+				}
+			}()
+			var sum int64
+			for number := range numbers {
+				sum += number
+				if err := stream.Send(&pingv1.CumSumResponse{Sum: sum}); err != nil {
+					return err
+				}
+			}
+			if err := <-received; !errors.Is(err, io.EOF) {
+				return err
+			}
+			return nil
+		},
+		options,
+		connect.WithOnFinish(func(context.Context, connect.Spec, error, connect.Stats) {}),
+	))
+	server := httptest.NewUnstartedServer(mux)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	for _, protocol := range []string{connect.ProtocolConnect, connect.ProtocolGRPC, connect.ProtocolGRPCWeb} {
+		protocol := protocol
+		t.Run(protocol, func(t *testing.T) {
+			t.Parallel()
+			client := connect.NewClient[pingv1.CumSumRequest, pingv1.CumSumResponse](
+				server.Client(),
+				server.URL+procedure,
+				connect.WithProtocol(protocol),
+				options,
+			)
+			stream := client.CallBidiStream(context.Background())
+			sent := make(chan error, 1)
+			go func() {
+				for i := 0; i < messages; i++ {
+					if err := stream.Send(&pingv1.CumSumRequest{Number: 1}); err != nil {
+						sent <- err
+						return
+					}
+				}
+				sent <- stream.CloseSend()
+			}()
+			var last int64
+			for {
+				msg, err := stream.Receive()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				assert.Nil(t, err)
+				if err != nil {
+					break
+				}
+				last = msg.Sum
+			}
+			assert.Nil(t, <-sent)
+			assert.Equal(t, last, messages)
+			// Close methods may race with each other.
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(2)
+				go func() {
+					defer wg.Done()
+					_ = stream.CloseSend()
+				}()
+				go func() {
+					defer wg.Done()
+					_ = stream.CloseReceive()
+				}()
+			}
+			wg.Wait()
+		})
+	}
+	t.Run("close_while_active", func(t *testing.T) {
+		client := connect.NewClient[pingv1.CumSumRequest, pingv1.CumSumResponse](
+			server.Client(),
+			server.URL+procedure,
+			options,
+		)
+		stream := client.CallBidiStream(context.Background())
+		var wg sync.WaitGroup
+		wg.Add(4)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < messages; i++ {
+				if stream.Send(&pingv1.CumSumRequest{Number: 1}) != nil {
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for {
+				if _, err := stream.Receive(); err != nil {
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			_ = stream.CloseSend()
+		}()
+		go func() {
+			defer wg.Done()
+			_ = stream.CloseReceive()
+		}()
+		wg.Wait()
+	})
+}
+
+func TestGRPCFallback(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}))
+	// Mimic a proxy that only speaks HTTP/1.1 in front of a gRPC server.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType := r.Header.Get("Content-Type")
+		if strings.HasPrefix(contentType, "application/grpc") &&
+			!strings.HasPrefix(contentType, "application/grpc-web") {
+			w.WriteHeader(http.StatusHTTPVersionNotSupported)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(func() {
+		server.Close()
+	})
+	testFallback := func(t *testing.T, opts ...connect.ClientOption) {
+		t.Helper()
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL, opts...)
+		stream, err := client.CountUp(
+			context.Background(),
+			connect.NewRequest(&pingv1.CountUpRequest{Number: 2}),
+		)
+		assert.Nil(t, err)
+		var got []int64
+		for stream.Receive() {
+			got = append(got, stream.Msg().Number)
+		}
+		assert.Nil(t, stream.Err())
+		assert.Nil(t, stream.Close())
+		assert.Equal(t, got, []int64{1, 2})
+		for i := 0; i < 2; i++ {
+			response, err := client.Ping(
+				context.Background(),
+				connect.NewRequest(&pingv1.PingRequest{Number: 42}),
+			)
+			assert.Nil(t, err)
+			assert.Equal(t, response.Msg.Number, 42)
+		}
+	}
+	t.Run("grpcweb", func(t *testing.T) {
+		t.Parallel()
+		testFallback(t, connect.WithGRPC(), connect.WithGRPCFallback())
+	})
+	t.Run("connect", func(t *testing.T) {
+		t.Parallel()
+		testFallback(t, connect.WithGRPC(), connect.WithConnectFallback())
+	})
+	t.Run("unary_first", func(t *testing.T) {
+		t.Parallel()
+		client := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL,
+			connect.WithGRPC(),
+			connect.WithGRPCFallback(),
+		)
+		response, err := client.Ping(
+			context.Background(),
+			connect.NewRequest(&pingv1.PingRequest{Number: 42}),
+		)
+		assert.Nil(t, err)
+		assert.Equal(t, response.Msg.Number, 42)
+	})
+	t.Run("disabled", func(t *testing.T) {
+		t.Parallel()
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL, connect.WithGRPC())
+		_, err := client.Ping(
+			context.Background(),
+			connect.NewRequest(&pingv1.PingRequest{Number: 42}),
+		)
+		assert.NotNil(t, err)
+		assert.True(t, strings.Contains(err.Error(), "HTTP status 505"))
+	})
+	t.Run("unrelated_errors", func(t *testing.T) {
+		t.Parallel()
+		var requests int32
+		// Mimic an HTTP/1.1 proxy that rejects unauthenticated requests.
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		t.Cleanup(proxy.Close)
+		client := pingv1connect.NewPingServiceClient(
+			proxy.Client(),
+			proxy.URL,
+			connect.WithGRPC(),
+			connect.WithGRPCFallback(),
+		)
+		_, err := client.Ping(
+			context.Background(),
+			connect.NewRequest(&pingv1.PingRequest{Number: 42}),
+		)
+		assert.Equal(t, connect.CodeOf(err), connect.CodeUnauthenticated)
+		assert.Equal(t, atomic.LoadInt32(&requests), 1)
+	})
+}
+
+func TestChecksums(t *testing.T) {
+	t.Parallel()
+	var corrupt int32 // atomic; if set, flip a bit in the next request body
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}, connect.WithChecksums()))
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.CompareAndSwapInt32(&corrupt, 1, 0) {
+			body, err := io.ReadAll(r.Body)
+			assert.Nil(t, err)
+			body[len(body)-1] ^= 1
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(func() {
+		server.Close()
+	})
+	for _, protocol := range []string{connect.ProtocolConnect, connect.ProtocolGRPC, connect.ProtocolGRPCWeb} {
+		client := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL,
+			connect.WithProtocol(protocol),
+			connect.WithChecksums(),
+		)
+		response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 42}))
+		assert.Nil(t, err, assert.Sprintf("%s: ping", protocol))
+		assert.Equal(t, response.Msg.Number, 42)
+		if protocol == connect.ProtocolConnect {
+			assert.NotZero(t, response.Header().Get("Message-Crc32c"))
+		}
+		stream := client.CumSum(context.Background())
+		for i := 1; i <= 3; i++ {
+			assert.Nil(t, stream.Send(&pingv1.CumSumRequest{Number: int64(i)}))
+			msg, err := stream.Receive()
+			assert.Nil(t, err, assert.Sprintf("%s: cumsum", protocol))
+			assert.Equal(t, msg.Sum, int64(i*(i+1)/2))
+		}
+		assert.Nil(t, stream.CloseSend())
+		assert.Nil(t, stream.CloseReceive())
+
+		atomic.StoreInt32(&corrupt, 1)
+		_, err = client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 42}))
+		assert.Equal(t, connect.CodeOf(err), connect.CodeDataLoss, assert.Sprintf("%s: corrupt ping", protocol))
+	}
+}
+
+func TestMessageTransformer(t *testing.T) {
+	t.Parallel()
+	xor := func(data []byte) ([]byte, error) {
+		transformed := make([]byte, len(data))
+		for i, b := range data {
+			transformed[i] = b ^ 0x5a
+		}
+		return transformed, nil
+	}
+	transformer := connect.WithMessageTransformer(xor, xor)
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}, transformer))
+	server := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		server.Close()
+	})
+	for _, protocol := range []string{connect.ProtocolConnect, connect.ProtocolGRPC, connect.ProtocolGRPCWeb} {
+		client := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL,
+			connect.WithProtocol(protocol),
+			transformer,
+		)
+		response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 42}))
+		assert.Nil(t, err, assert.Sprintf("%s: ping", protocol))
+		assert.Equal(t, response.Msg.Number, 42)
+		_, err = client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Nil(t, err, assert.Sprintf("%s: empty ping", protocol))
+		stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 2}))
+		assert.Nil(t, err)
+		for i := int64(1); i <= 2; i++ {
+			assert.True(t, stream.Receive(), assert.Sprintf("%s: count up", protocol))
+			assert.Equal(t, stream.Msg().Number, i)
+		}
+		assert.False(t, stream.Receive())
+		assert.Nil(t, stream.Close())
+
+		// Clients without the transformer send plain messages, which the handler
+		// can't decode.
+		plainClient := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL,
+			connect.WithProtocol(protocol),
+		)
+		_, err = plainClient.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 42}))
+		assert.Equal(t, connect.CodeOf(err), connect.CodeInvalidArgument, assert.Sprintf("%s: plain ping", protocol))
+	}
+}
+
 func TestInvalidHeaderTimeout(t *testing.T) {
 	t.Parallel()
 	mux := http.NewServeMux()
@@ -804,3 +1799,36 @@ func (d *deflateReader) Reset(reader io.Reader) error {
 }
 
 var _ connect.Decompressor = (*deflateReader)(nil)
+
+func TestUnixSocket(t *testing.T) {
+	t.Parallel()
+	client := startUnixServer(t)
+	request := connect.NewRequest(&pingv1.PingRequest{Number: 42})
+	request.Header().Set(clientHeader, headerValue)
+	response, err := client.Ping(context.Background(), request)
+	assert.Nil(t, err)
+	assert.Equal(t, response.Msg.Number, 42)
+	assert.Equal(t, response.Trailer().Get(handlerTrailer), trailerValue)
+}
+
+func startUnixServer(t *testing.T) pingv1connect.PingServiceClient {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ping.sock")
+	listener, err := connect.ListenUnix(path)
+	assert.Nil(t, err)
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{checkMetadata: true}))
+	server := connect.NewUnixServer(mux)
+	assert.True(t, server.ReadHeaderTimeout > 0)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+	return pingv1connect.NewPingServiceClient(
+		connect.DialUnix(path),
+		"http://localhost",
+		connect.WithGRPC(),
+	)
+}
@@ -0,0 +1,96 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connecttest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/bufbuild/connect-go"
+	"google.golang.org/protobuf/proto"
+)
+
+// AssertCode reports a test error unless err has the given code. A nil err
+// never matches. Like the other assertions in this package, AssertCode uses
+// tb.Errorf, so it's safe to call from handlers and other goroutines; check
+// its result to stop early.
+func AssertCode(tb testing.TB, err error, want connect.Code) bool {
+	tb.Helper()
+	if err != nil && connect.CodeOf(err) == want {
+		return true
+	}
+	report(tb, "AssertCode", err, want)
+	return false
+}
+
+// AssertErrorDetail reports a test error unless err is a *connect.Error with a
+// detail of the same type as want and equal to it.
+func AssertErrorDetail(tb testing.TB, err error, want proto.Message) bool {
+	tb.Helper()
+	var connectErr *connect.Error
+	if !errors.As(err, &connectErr) {
+		report(tb, "AssertErrorDetail", err, want)
+		return false
+	}
+	name := want.ProtoReflect().Descriptor().FullName()
+	var got []proto.Message
+	for _, detail := range connectErr.Details() {
+		if detail.MessageName() != name {
+			continue
+		}
+		msg := want.ProtoReflect().New().Interface()
+		if err := detail.UnmarshalTo(msg); err != nil {
+			continue
+		}
+		if proto.Equal(msg, want) {
+			return true
+		}
+		got = append(got, msg)
+	}
+	report(tb, "AssertErrorDetail", got, want)
+	return false
+}
+
+// AssertHeader reports a test error unless the header's values for key are
+// exactly the wanted values, in order. With no wanted values, it asserts that
+// the key is absent.
+func AssertHeader(tb testing.TB, header http.Header, key string, want ...string) bool {
+	tb.Helper()
+	got := header.Values(key)
+	if len(got) == len(want) {
+		equal := true
+		for i := range got {
+			equal = equal && got[i] == want[i]
+		}
+		if equal {
+			return true
+		}
+	}
+	report(tb, "AssertHeader("+key+")", got, want)
+	return false
+}
+
+func report(tb testing.TB, assertion string, got, want any) {
+	tb.Helper()
+	buffer := &bytes.Buffer{}
+	buffer.WriteString("\n")
+	fmt.Fprintf(buffer, "assertion:\t%s\n", assertion)
+	fmt.Fprintf(buffer, "got:\t%+v\n", got)
+	fmt.Fprintf(buffer, "want:\t%+v\n", want)
+	tb.Error(buffer.String())
+}
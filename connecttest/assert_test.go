@@ -0,0 +1,80 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connecttest_test
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/bufbuild/connect-go/connecttest"
+	"github.com/bufbuild/connect-go/internal/assert"
+	pingv1 "github.com/bufbuild/connect-go/internal/gen/connect/ping/v1"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func TestAssertions(t *testing.T) {
+	t.Parallel()
+	connectErr := connect.NewError(connect.CodeNotFound, errors.New("not found"))
+	detail, err := anypb.New(&pingv1.PingRequest{Text: "detail"})
+	assert.Nil(t, err)
+	connectErr.AddDetail(detail)
+	header := http.Header{"Acme-Shard": []string{"1", "2"}}
+
+	passing := &fakeTB{TB: t}
+	assert.True(t, connecttest.AssertCode(passing, connectErr, connect.CodeNotFound))
+	assert.True(t, connecttest.AssertErrorDetail(passing, connectErr, &pingv1.PingRequest{Text: "detail"}))
+	assert.True(t, connecttest.AssertHeader(passing, header, "Acme-Shard", "1", "2"))
+	assert.True(t, connecttest.AssertHeader(passing, header, "Acme-Missing"))
+	assert.Zero(t, len(passing.errors()))
+
+	failing := &fakeTB{TB: t}
+	assert.False(t, connecttest.AssertCode(failing, nil, connect.CodeUnknown))
+	assert.False(t, connecttest.AssertCode(failing, connectErr, connect.CodeInternal))
+	assert.False(t, connecttest.AssertErrorDetail(failing, connectErr, &pingv1.PingRequest{Text: "other"}))
+	assert.False(t, connecttest.AssertErrorDetail(failing, connectErr, &pingv1.PingResponse{}))
+	assert.False(t, connecttest.AssertErrorDetail(failing, errors.New("plain"), &pingv1.PingRequest{}))
+	assert.False(t, connecttest.AssertHeader(failing, header, "Acme-Shard", "2", "1"))
+	assert.Equal(t, len(failing.errors()), 6)
+}
+
+// fakeTB records errors instead of failing the test, so tests can exercise
+// failing assertions.
+type fakeTB struct {
+	testing.TB
+
+	mu   sync.Mutex
+	errs []string
+}
+
+func (tb *fakeTB) Helper() {}
+
+func (tb *fakeTB) Error(args ...any) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	for _, arg := range args {
+		if msg, ok := arg.(string); ok {
+			tb.errs = append(tb.errs, msg)
+		}
+	}
+}
+
+func (tb *fakeTB) errors() []string {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	return append([]string(nil), tb.errs...)
+}
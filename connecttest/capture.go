@@ -0,0 +1,291 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connecttest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/bufbuild/connect-go"
+)
+
+// A CapturedCall is the metadata of one call seen by a Capture. Headers and
+// trailers are copies taken as the call finishes, so tests can inspect them
+// after the call returns.
+type CapturedCall struct {
+	Spec            connect.Spec
+	RequestHeader   http.Header
+	ResponseHeader  http.Header
+	ResponseTrailer http.Header
+	// Err is the error that ended the call, if any. For streams, it's the first
+	// error other than io.EOF.
+	Err error
+}
+
+// A Capture is an interceptor that records the headers, trailers, and errors of
+// every call, for verification in tests. It works with clients and handlers,
+// and with unary and streaming calls:
+//
+//	capture := connecttest.NewCapture()
+//	client := pingv1connect.NewPingServiceClient(
+//		httpClient,
+//		baseURL,
+//		connect.WithInterceptors(capture),
+//	)
+//	// make calls...
+//	connecttest.AssertHeader(t, capture.Last().ResponseHeader, "Acme-Shard", "1")
+//
+// Handlers record calls as they finish. Clients record unary calls as they
+// return, and streams when they reach the end of the response or close the
+// receive side. Streams closed before the end of the response don't capture
+// response headers or trailers.
+type Capture struct {
+	mu    sync.Mutex
+	calls []*CapturedCall
+}
+
+var _ connect.Interceptor = (*Capture)(nil)
+
+// NewCapture constructs an empty Capture.
+func NewCapture() *Capture {
+	return &Capture{}
+}
+
+// Calls returns the calls recorded so far, in the order they finished.
+func (c *Capture) Calls() []*CapturedCall {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*CapturedCall(nil), c.calls...)
+}
+
+// Last returns the most recently finished call, or nil if there are none.
+func (c *Capture) Last() *CapturedCall {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.calls) == 0 {
+		return nil
+	}
+	return c.calls[len(c.calls)-1]
+}
+
+// Reset discards the recorded calls.
+func (c *Capture) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = nil
+}
+
+// WrapUnary implements connect.Interceptor.
+func (c *Capture) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, request connect.AnyRequest) (connect.AnyResponse, error) {
+		response, err := next(ctx, request)
+		call := &CapturedCall{
+			Spec:          request.Spec(),
+			RequestHeader: request.Header().Clone(),
+			Err:           err,
+		}
+		if response != nil {
+			call.ResponseHeader = response.Header().Clone()
+			call.ResponseTrailer = response.Trailer().Clone()
+		} else if connectErr := new(connect.Error); errors.As(err, &connectErr) {
+			// Errors carry the response's headers and trailers together.
+			call.ResponseHeader = connectErr.Meta().Clone()
+		}
+		c.record(call)
+		return response, err
+	}
+}
+
+// WrapStreamContext implements connect.Interceptor. It attaches a call to the
+// context, so the stream's Sender and Receiver can share it.
+func (c *Capture) WrapStreamContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, captureKey{c}, &capturedStream{capture: c})
+}
+
+// WrapStreamSender implements connect.Interceptor.
+func (c *Capture) WrapStreamSender(ctx context.Context, sender connect.Sender) connect.Sender {
+	stream, ok := ctx.Value(captureKey{c}).(*capturedStream)
+	if !ok {
+		return sender
+	}
+	stream.setSpec(sender.Spec())
+	return &captureSender{Sender: sender, stream: stream}
+}
+
+// WrapStreamReceiver implements connect.Interceptor.
+func (c *Capture) WrapStreamReceiver(ctx context.Context, receiver connect.Receiver) connect.Receiver {
+	stream, ok := ctx.Value(captureKey{c}).(*capturedStream)
+	if !ok {
+		return receiver
+	}
+	stream.setSpec(receiver.Spec())
+	return &captureReceiver{Receiver: receiver, stream: stream}
+}
+
+func (c *Capture) record(call *CapturedCall) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = append(c.calls, call)
+}
+
+// captureKey is keyed by Capture, so multiple Captures can wrap the same
+// stream.
+type captureKey struct {
+	capture *Capture
+}
+
+// capturedStream assembles a CapturedCall from a stream's Sender and
+// Receiver, which may be used on different goroutines.
+type capturedStream struct {
+	capture *Capture
+
+	mu       sync.Mutex
+	call     CapturedCall
+	recorded bool
+}
+
+func (s *capturedStream) setSpec(spec connect.Spec) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.call.Spec = spec
+}
+
+func (s *capturedStream) update(update func(*CapturedCall)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	update(&s.call)
+}
+
+func (s *capturedStream) setErr(err error) {
+	if err == nil || errors.Is(err, io.EOF) {
+		return
+	}
+	s.update(func(call *CapturedCall) {
+		if call.Err == nil {
+			call.Err = err
+		}
+	})
+}
+
+// finish records the call the first time it's called.
+func (s *capturedStream) finish() {
+	s.mu.Lock()
+	if s.recorded {
+		s.mu.Unlock()
+		return
+	}
+	s.recorded = true
+	call := s.call
+	s.mu.Unlock()
+	s.capture.record(&call)
+}
+
+type captureSender struct {
+	connect.Sender
+
+	stream *capturedStream
+}
+
+func (s *captureSender) Send(msg any) error {
+	if s.Spec().IsClient {
+		// Request headers are sent with the first message.
+		s.captureRequestHeader()
+	}
+	err := s.Sender.Send(msg)
+	s.stream.setErr(err)
+	return err
+}
+
+func (s *captureSender) Close(err error) error {
+	if s.Spec().IsClient {
+		s.captureRequestHeader()
+		closeErr := s.Sender.Close(err)
+		s.stream.setErr(closeErr)
+		return closeErr
+	}
+	// Handlers close the sender last, with the error that ended the call.
+	s.stream.setErr(err)
+	s.stream.update(func(call *CapturedCall) {
+		call.ResponseHeader = s.Header().Clone()
+		if trailer, ok := s.Trailer(); ok {
+			call.ResponseTrailer = trailer.Clone()
+		}
+	})
+	s.stream.finish()
+	return s.Sender.Close(err)
+}
+
+func (s *captureSender) captureRequestHeader() {
+	s.stream.update(func(call *CapturedCall) {
+		if call.RequestHeader == nil {
+			call.RequestHeader = s.Header().Clone()
+		}
+	})
+}
+
+type captureReceiver struct {
+	connect.Receiver
+
+	stream *capturedStream
+}
+
+func (r *captureReceiver) Receive(msg any) error {
+	if !r.Spec().IsClient {
+		r.captureRequestHeader()
+	}
+	err := r.Receiver.Receive(msg)
+	if err != nil && r.Spec().IsClient {
+		// Clients see trailers once the response ends.
+		r.stream.setErr(err)
+		r.captureResponse()
+		r.stream.finish()
+	}
+	return err
+}
+
+func (r *captureReceiver) Close() error {
+	if !r.Spec().IsClient {
+		r.captureRequestHeader()
+		return r.Receiver.Close()
+	}
+	// Don't capture the response here: if the request was never sent, reading
+	// the response headers would block forever.
+	err := r.Receiver.Close()
+	r.stream.setErr(err)
+	r.stream.finish()
+	return err
+}
+
+func (r *captureReceiver) captureRequestHeader() {
+	r.stream.update(func(call *CapturedCall) {
+		if call.RequestHeader == nil {
+			call.RequestHeader = r.Header().Clone()
+		}
+	})
+}
+
+func (r *captureReceiver) captureResponse() {
+	r.stream.update(func(call *CapturedCall) {
+		if call.ResponseHeader == nil {
+			call.ResponseHeader = r.Header().Clone()
+		}
+		if trailer, ok := r.Trailer(); ok {
+			call.ResponseTrailer = trailer.Clone()
+		}
+	})
+}
@@ -0,0 +1,363 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package connecttest contains utilities for testing connect clients and
+// handlers.
+package connecttest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/bufbuild/connect-go/internal/rawproto"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const (
+	longStringBytes = 64 * 1024
+	manyElements    = 1024
+	shallowDepth    = 3  // nesting for cases that fill every field
+	deepDepth       = 64 // nesting for the deep-nesting case
+)
+
+// A Failure is a fuzzed call that crashed the handler or failed with a code
+// handlers shouldn't use to reject a request.
+type Failure struct {
+	Procedure string
+	// Case names the kind of request, like "long-strings" or "max-numbers".
+	Case    string
+	Request proto.Message
+	Err     error
+}
+
+func (f *Failure) String() string {
+	return fmt.Sprintf("%s (%s): %v", f.Procedure, f.Case, f.Err)
+}
+
+// Fuzz calls every method of the service with structurally valid but
+// adversarial requests generated from the method's input descriptor: empty
+// messages, maximum-length strings and bytes, extreme and special numbers,
+// undeclared enum values, large repeated fields and maps, and deeply nested
+// messages. The service must be served at baseURL.
+//
+// Handlers may succeed or reject each request with a code that describes a
+// bad request, like CodeInvalidArgument or CodeResourceExhausted. Fuzz returns
+// a Failure for every other outcome, including codes outside the range
+// defined by the Connect and gRPC specifications. Handlers that panic usually
+// surface as CodeUnavailable or CodeUnknown. The returned error is non-nil
+// only if Fuzz couldn't generate or send requests, or if ctx is done.
+//
+// Requests are always sent as binary Protobuf, so any codec options are
+// overridden. Bidirectional streaming methods require HTTP/2.
+func Fuzz(
+	ctx context.Context,
+	httpClient connect.HTTPClient,
+	baseURL string,
+	service protoreflect.ServiceDescriptor,
+	options ...connect.ClientOption,
+) ([]*Failure, error) {
+	// Copy the options, so that appending doesn't write to the caller's array.
+	clientOptions := make([]connect.ClientOption, 0, len(options)+1)
+	clientOptions = append(clientOptions, options...)
+	clientOptions = append(clientOptions, connect.WithCodec(rawproto.Codec{}))
+	var failures []*Failure
+	methods := service.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		method := methods.Get(i)
+		procedure := fmt.Sprintf("/%s/%s", service.FullName(), method.Name())
+		client := connect.NewClient[rawproto.Message, rawproto.Message](
+			httpClient,
+			strings.TrimSuffix(baseURL, "/")+procedure,
+			clientOptions...,
+		)
+		for _, fuzzCase := range fuzzCases {
+			request := dynamicpb.NewMessage(method.Input())
+			fuzzCase.fill(request, 0)
+			data, err := proto.Marshal(request)
+			if err != nil {
+				return failures, fmt.Errorf("marshal %s request for %s: %w", fuzzCase.name, procedure, err)
+			}
+			err = call(ctx, client, method, rawproto.Message(data))
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return failures, ctxErr
+			}
+			if err != nil && !isRejection(connect.CodeOf(err)) {
+				failures = append(failures, &Failure{
+					Procedure: procedure,
+					Case:      fuzzCase.name,
+					Request:   request,
+					Err:       err,
+				})
+			}
+		}
+	}
+	return failures, nil
+}
+
+func call(
+	ctx context.Context,
+	client *connect.Client[rawproto.Message, rawproto.Message],
+	method protoreflect.MethodDescriptor,
+	request rawproto.Message,
+) error {
+	switch {
+	case method.IsStreamingClient() && method.IsStreamingServer():
+		stream := client.CallBidiStream(ctx)
+		if err := stream.Send(&request); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if err := stream.CloseSend(); err != nil {
+			return err
+		}
+		for {
+			if _, err := stream.Receive(); errors.Is(err, io.EOF) {
+				return stream.CloseReceive()
+			} else if err != nil {
+				_ = stream.CloseReceive()
+				return err
+			}
+		}
+	case method.IsStreamingClient():
+		stream := client.CallClientStream(ctx)
+		if err := stream.Send(&request); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		_, err := stream.CloseAndReceive()
+		return err
+	case method.IsStreamingServer():
+		stream, err := client.CallServerStream(ctx, connect.NewRequest(&request))
+		if err != nil {
+			return err
+		}
+		for stream.Receive() {
+		}
+		if err := stream.Err(); err != nil {
+			_ = stream.Close()
+			return err
+		}
+		return stream.Close()
+	default:
+		_, err := client.CallUnary(ctx, connect.NewRequest(&request))
+		return err
+	}
+}
+
+// isRejection reports whether handlers may use the code to reject a request
+// they received intact.
+func isRejection(code connect.Code) bool {
+	switch code {
+	case connect.CodeInvalidArgument,
+		connect.CodeNotFound,
+		connect.CodeAlreadyExists,
+		connect.CodePermissionDenied,
+		connect.CodeResourceExhausted,
+		connect.CodeFailedPrecondition,
+		connect.CodeAborted,
+		connect.CodeOutOfRange,
+		connect.CodeUnimplemented,
+		connect.CodeUnauthenticated:
+		return true
+	default:
+		return false
+	}
+}
+
+// A fuzzCase populates messages with a kind of adversarial data.
+type fuzzCase struct {
+	name string
+	// value returns the value for a scalar field, or an invalid Value to leave
+	// the field unset.
+	value    func(protoreflect.FieldDescriptor) protoreflect.Value
+	elements int // for repeated fields and maps
+	depth    int // maximum nesting of message fields
+	// If set, only the first message field at each level is populated, so deep
+	// nesting doesn't grow exponentially with the number of fields.
+	narrow bool
+}
+
+//nolint:gochecknoglobals
+var fuzzCases = []*fuzzCase{
+	{name: "empty"},
+	{name: "long-strings", value: longValue, elements: 1, depth: shallowDepth},
+	{name: "max-numbers", value: maxValue, elements: 1, depth: shallowDepth},
+	{name: "min-numbers", value: minValue, elements: 1, depth: shallowDepth},
+	{name: "special-floats", value: specialValue, elements: 1, depth: shallowDepth},
+	{name: "many-elements", value: maxValue, elements: manyElements, depth: 1},
+	{name: "deep-nesting", value: zeroValue, elements: 1, depth: deepDepth, narrow: true},
+}
+
+func (c *fuzzCase) fill(message protoreflect.Message, depth int) {
+	if c.value == nil || depth >= c.depth {
+		return
+	}
+	fields := message.Descriptor().Fields()
+	nested := false
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		isMessage := field.Message() != nil && !field.IsMap()
+		if isMessage && c.narrow && nested {
+			continue
+		}
+		switch {
+		case field.IsMap():
+			if c.narrow {
+				continue
+			}
+			entries := message.Mutable(field).Map()
+			for j := 0; j < c.elements; j++ {
+				key := elementKey(field.MapKey(), j)
+				entries.Set(key.MapKey(), c.element(entries.NewValue(), field.MapValue(), depth))
+			}
+		case field.IsList():
+			list := message.Mutable(field).List()
+			for j := 0; j < c.elements; j++ {
+				list.Append(c.element(list.NewElement(), field, depth))
+			}
+		case isMessage:
+			c.fill(message.Mutable(field).Message(), depth+1)
+		default:
+			if value := c.value(field); value.IsValid() {
+				message.Set(field, value)
+			}
+		}
+		nested = nested || isMessage
+	}
+}
+
+// element populates a list element or map value. Scalars are replaced by the
+// case's value and messages are filled in place.
+func (c *fuzzCase) element(
+	value protoreflect.Value,
+	field protoreflect.FieldDescriptor,
+	depth int,
+) protoreflect.Value {
+	if field.Message() != nil {
+		c.fill(value.Message(), depth+1)
+		return value
+	}
+	if scalar := c.value(field); scalar.IsValid() {
+		return scalar
+	}
+	return field.Default()
+}
+
+// elementKey returns the i-th map key. Keys are distinct, so maps actually
+// grow, except for bool keys, which only have two values.
+func elementKey(field protoreflect.FieldDescriptor, i int) protoreflect.Value {
+	switch field.Kind() {
+	case protoreflect.BoolKind:
+		return protoreflect.ValueOfBool(i%2 == 0)
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(fmt.Sprintf("key-%d", i))
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return protoreflect.ValueOfInt32(math.MaxInt32 - int32(i))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return protoreflect.ValueOfInt64(math.MaxInt64 - int64(i))
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return protoreflect.ValueOfUint32(math.MaxUint32 - uint32(i))
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return protoreflect.ValueOfUint64(math.MaxUint64 - uint64(i))
+	default:
+		// Map keys are always integers, strings, or bools.
+		panic(fmt.Sprintf("invalid map key kind %v", field.Kind()))
+	}
+}
+
+func zeroValue(protoreflect.FieldDescriptor) protoreflect.Value {
+	return protoreflect.Value{}
+}
+
+func longValue(field protoreflect.FieldDescriptor) protoreflect.Value {
+	switch field.Kind() {
+	case protoreflect.StringKind:
+		// Multi-byte runes exercise handlers that confuse bytes and characters.
+		return protoreflect.ValueOfString(strings.Repeat("é", longStringBytes/2))
+	case protoreflect.BytesKind:
+		return protoreflect.ValueOfBytes([]byte(strings.Repeat("\xff", longStringBytes)))
+	default:
+		return maxValue(field)
+	}
+}
+
+func maxValue(field protoreflect.FieldDescriptor) protoreflect.Value {
+	switch field.Kind() {
+	case protoreflect.BoolKind:
+		return protoreflect.ValueOfBool(true)
+	case protoreflect.EnumKind:
+		// An undeclared value: open enums must preserve it, and closed enums
+		// must treat it as unknown.
+		return protoreflect.ValueOfEnum(math.MaxInt32)
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return protoreflect.ValueOfInt32(math.MaxInt32)
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return protoreflect.ValueOfInt64(math.MaxInt64)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return protoreflect.ValueOfUint32(math.MaxUint32)
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return protoreflect.ValueOfUint64(math.MaxUint64)
+	case protoreflect.FloatKind:
+		return protoreflect.ValueOfFloat32(math.MaxFloat32)
+	case protoreflect.DoubleKind:
+		return protoreflect.ValueOfFloat64(math.MaxFloat64)
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString("x")
+	case protoreflect.BytesKind:
+		return protoreflect.ValueOfBytes([]byte{0xff})
+	default:
+		return protoreflect.Value{}
+	}
+}
+
+func minValue(field protoreflect.FieldDescriptor) protoreflect.Value {
+	switch field.Kind() {
+	case protoreflect.EnumKind:
+		return protoreflect.ValueOfEnum(math.MinInt32)
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return protoreflect.ValueOfInt32(math.MinInt32)
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return protoreflect.ValueOfInt64(math.MinInt64)
+	case protoreflect.FloatKind:
+		return protoreflect.ValueOfFloat32(-math.MaxFloat32)
+	case protoreflect.DoubleKind:
+		return protoreflect.ValueOfFloat64(-math.MaxFloat64)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind,
+		protoreflect.BoolKind:
+		// The minimum is the zero value, which isn't sent in proto3.
+		return protoreflect.Value{}
+	default:
+		return maxValue(field)
+	}
+}
+
+func specialValue(field protoreflect.FieldDescriptor) protoreflect.Value {
+	switch field.Kind() {
+	case protoreflect.FloatKind:
+		return protoreflect.ValueOfFloat32(float32(math.NaN()))
+	case protoreflect.DoubleKind:
+		return protoreflect.ValueOfFloat64(math.Inf(-1))
+	case protoreflect.StringKind:
+		// Valid UTF-8, but hostile to naive string handling.
+		return protoreflect.ValueOfString("\x00\u202e\ufffd%s%n../\r\n")
+	default:
+		return protoreflect.Value{}
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connecttest_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/bufbuild/connect-go/connecttest"
+	"github.com/bufbuild/connect-go/internal/assert"
+	pingv1 "github.com/bufbuild/connect-go/internal/gen/connect/ping/v1"
+	"github.com/bufbuild/connect-go/internal/gen/connect/ping/v1/pingv1connect"
+)
+
+func TestFuzz(t *testing.T) {
+	t.Parallel()
+	service := pingv1.File_connect_ping_v1_ping_proto.Services().ByName("PingService")
+	start := func(t *testing.T, handler pingv1connect.PingServiceHandler) *httptest.Server {
+		t.Helper()
+		mux := http.NewServeMux()
+		mux.Handle(pingv1connect.NewPingServiceHandler(handler))
+		server := httptest.NewUnstartedServer(mux)
+		server.EnableHTTP2 = true
+		server.Config.ErrorLog = log.New(io.Discard, "", 0) // silence panics
+		server.StartTLS()
+		t.Cleanup(server.Close)
+		return server
+	}
+	t.Run("robust", func(t *testing.T) {
+		t.Parallel()
+		server := start(t, robustPingServer{})
+		failures, err := connecttest.Fuzz(context.Background(), server.Client(), server.URL, service)
+		assert.Nil(t, err)
+		assert.Zero(t, len(failures), assert.Sprintf("failures: %v", failures))
+	})
+	t.Run("fragile", func(t *testing.T) {
+		t.Parallel()
+		server := start(t, fragilePingServer{})
+		// Leave room in the array to check that Fuzz doesn't write to it.
+		options := make([]connect.ClientOption, 1, 2)
+		options[0] = connect.WithGRPC()
+		failures, err := connecttest.Fuzz(
+			context.Background(),
+			server.Client(),
+			server.URL,
+			service,
+			options...,
+		)
+		assert.Nil(t, err)
+		assert.Nil(t, options[:2][1])
+		got := make(map[string][]string)
+		for _, failure := range failures {
+			got[failure.Procedure] = append(got[failure.Procedure], failure.Case)
+			assert.NotNil(t, failure.Request)
+		}
+		assert.Equal(t, got, map[string][]string{
+			"/" + pingv1connect.PingServiceName + "/Ping": {"long-strings"},
+			"/" + pingv1connect.PingServiceName + "/Fail": {"long-strings", "max-numbers", "min-numbers", "many-elements"},
+		})
+	})
+}
+
+type robustPingServer struct {
+	pingv1connect.UnimplementedPingServiceHandler
+}
+
+func (robustPingServer) Ping(
+	_ context.Context,
+	request *connect.Request[pingv1.PingRequest],
+) (*connect.Response[pingv1.PingResponse], error) {
+	if len(request.Msg.Text) > 1024 {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("text too long"))
+	}
+	return connect.NewResponse(&pingv1.PingResponse{
+		Number: request.Msg.Number,
+		Text:   request.Msg.Text,
+	}), nil
+}
+
+func (robustPingServer) Fail(
+	_ context.Context,
+	request *connect.Request[pingv1.FailRequest],
+) (*connect.Response[pingv1.FailResponse], error) {
+	return nil, connect.NewError(connect.CodeFailedPrecondition, errors.New("failed"))
+}
+
+func (robustPingServer) Sum(
+	_ context.Context,
+	stream *connect.ClientStream[pingv1.SumRequest],
+) (*connect.Response[pingv1.SumResponse], error) {
+	var sum int64
+	for stream.Receive() {
+		sum += stream.Msg().Number
+	}
+	if err := stream.Err(); err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&pingv1.SumResponse{Sum: sum}), nil
+}
+
+func (robustPingServer) CountUp(
+	_ context.Context,
+	request *connect.Request[pingv1.CountUpRequest],
+	stream *connect.ServerStream[pingv1.CountUpResponse],
+) error {
+	if request.Msg.Number < 0 || request.Msg.Number > 10 {
+		return connect.NewError(connect.CodeOutOfRange, errors.New("number out of range"))
+	}
+	for i := int64(1); i <= request.Msg.Number; i++ {
+		if err := stream.Send(&pingv1.CountUpResponse{Number: i}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (robustPingServer) CumSum(
+	_ context.Context,
+	stream *connect.BidiStream[pingv1.CumSumRequest, pingv1.CumSumResponse],
+) error {
+	var sum int64
+	for {
+		request, err := stream.Receive()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		sum += request.Number
+		if err := stream.Send(&pingv1.CumSumResponse{Sum: sum}); err != nil {
+			return err
+		}
+	}
+}
+
+// fragilePingServer panics on long text and returns any error code clients
+// ask for, even ones the specification doesn't define.
+type fragilePingServer struct {
+	robustPingServer
+}
+
+func (fragilePingServer) Ping(
+	_ context.Context,
+	request *connect.Request[pingv1.PingRequest],
+) (*connect.Response[pingv1.PingResponse], error) {
+	if len(request.Msg.Text) > 1024 {
+		panic("text too long")
+	}
+	return connect.NewResponse(&pingv1.PingResponse{Text: request.Msg.Text}), nil
+}
+
+func (fragilePingServer) Fail(
+	_ context.Context,
+	request *connect.Request[pingv1.FailRequest],
+) (*connect.Response[pingv1.FailResponse], error) {
+	if request.Msg.Code == 0 {
+		return connect.NewResponse(&pingv1.FailResponse{}), nil
+	}
+	return nil, connect.NewError(connect.Code(request.Msg.Code), errors.New("failed"))
+}
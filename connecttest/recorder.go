@@ -0,0 +1,401 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connecttest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/bufbuild/connect-go"
+)
+
+const (
+	envelopePrefixBytes = 5
+	redacted            = "REDACTED"
+)
+
+// A Cassette is the file format used by Recorder and Replayer: a list of
+// HTTP exchanges, in the order they started.
+type Cassette struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// An Interaction is a recorded HTTP request and its response.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// A RecordedRequest is the client's half of an Interaction. Streaming bodies
+// (gRPC, gRPC-Web, and streaming Connect) are split into Frames, one per
+// envelope; other bodies are stored whole in Body.
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"` // path and query, without the host
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+	Frames []Frame     `json:"frames,omitempty"`
+}
+
+// A RecordedResponse is the server's half of an Interaction. As with
+// requests, streaming bodies are split into Frames.
+type RecordedResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Proto      string      `json:"proto"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       []byte      `json:"body,omitempty"`
+	Frames     []Frame     `json:"frames,omitempty"`
+	Trailer    http.Header `json:"trailer,omitempty"`
+}
+
+// A Frame is a single enveloped message. Data is the message as sent, so it's
+// compressed if the compressed flag is set. Redaction hooks may replace Data;
+// the envelope's length prefix is recomputed on replay.
+type Frame struct {
+	Flags uint8  `json:"flags,omitempty"`
+	Data  []byte `json:"data"`
+}
+
+// A RecorderOption configures a Recorder.
+type RecorderOption interface {
+	applyToRecorder(*Recorder)
+}
+
+// WithRedaction adds a hook that edits each Interaction before it's saved,
+// typically to remove secrets. Hooks run in the order they're added.
+func WithRedaction(redact func(*Interaction)) RecorderOption {
+	return &redactionOption{redact: redact}
+}
+
+// RedactHeaders returns a redaction hook that replaces the values of the
+// named request headers, response headers, and trailers with "REDACTED".
+func RedactHeaders(names ...string) func(*Interaction) {
+	return func(interaction *Interaction) {
+		for _, header := range []http.Header{
+			interaction.Request.Header,
+			interaction.Response.Header,
+			interaction.Response.Trailer,
+		} {
+			for _, name := range names {
+				if values := header.Values(name); len(values) > 0 {
+					header.Set(name, redacted)
+				}
+			}
+		}
+	}
+}
+
+// Recorder is a connect.HTTPClient that sends requests with another client and
+// records each exchange, including every envelope of streaming calls. Save
+// the recording with Save, then replay it in hermetic tests with a Replayer.
+type Recorder struct {
+	httpClient connect.HTTPClient
+	redact     []func(*Interaction)
+
+	mu         sync.Mutex
+	recordings []*recording
+}
+
+var _ connect.HTTPClient = (*Recorder)(nil)
+
+// NewRecorder constructs a Recorder that sends requests with httpClient.
+func NewRecorder(httpClient connect.HTTPClient, options ...RecorderOption) *Recorder {
+	recorder := &Recorder{httpClient: httpClient}
+	for _, opt := range options {
+		opt.applyToRecorder(recorder)
+	}
+	return recorder
+}
+
+// Do sends the request and records it, along with the response.
+func (r *Recorder) Do(request *http.Request) (*http.Response, error) {
+	rec := &recording{
+		method: request.Method,
+		url:    request.URL.RequestURI(),
+		header: request.Header.Clone(),
+	}
+	if request.Body != nil && request.Body != http.NoBody {
+		request.Body = &recordingBody{ReadCloser: request.Body, data: &rec.requestBody}
+	}
+	// Reserve a place in the cassette now, so interactions are saved in the
+	// order they started.
+	r.mu.Lock()
+	r.recordings = append(r.recordings, rec)
+	r.mu.Unlock()
+	response, err := r.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	rec.response = response
+	rec.responseHeader = response.Header.Clone()
+	r.mu.Unlock()
+	response.Body = &recordingBody{ReadCloser: response.Body, data: &rec.responseBody}
+	return response, nil
+}
+
+// Save writes the interactions recorded so far to a JSON file at path,
+// applying any redaction hooks. Call Save only after the recorded calls have
+// finished, so that every message and trailer has been recorded.
+func (r *Recorder) Save(path string) error {
+	r.mu.Lock()
+	cassette := &Cassette{Interactions: make([]*Interaction, 0, len(r.recordings))}
+	for _, rec := range r.recordings {
+		// Requests that failed without a response can't be replayed.
+		if rec.response != nil {
+			cassette.Interactions = append(cassette.Interactions, rec.interaction())
+		}
+	}
+	r.mu.Unlock()
+	for _, interaction := range cassette.Interactions {
+		for _, redact := range r.redact {
+			redact(interaction)
+		}
+	}
+	data, err := json.MarshalIndent(cassette, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal cassette: %w", err)
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+// Replayer is a connect.HTTPClient that answers requests with the responses
+// saved by a Recorder, without any network access. Each request is matched to
+// the first unused Interaction with the same method, path, and query, so
+// repeated calls replay in the order they were recorded. Since streaming
+// clients send requests in the background, tests should finish each stream
+// before starting another call to the same procedure.
+type Replayer struct {
+	mu           sync.Mutex
+	interactions []*Interaction
+	used         []bool
+}
+
+var _ connect.HTTPClient = (*Replayer)(nil)
+
+// NewReplayer loads a cassette saved by Recorder.Save.
+func NewReplayer(path string) (*Replayer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cassette Cassette
+	if err := json.Unmarshal(data, &cassette); err != nil {
+		return nil, fmt.Errorf("unmarshal cassette %s: %w", path, err)
+	}
+	return &Replayer{
+		interactions: cassette.Interactions,
+		used:         make([]bool, len(cassette.Interactions)),
+	}, nil
+}
+
+// Do replays the recorded response to the request. The request body is read
+// and discarded in the background, so streaming clients never block on sends.
+// If no unused Interaction matches the request, Do returns an error.
+func (r *Replayer) Do(request *http.Request) (*http.Response, error) {
+	interaction := r.claim(request.Method, request.URL.RequestURI())
+	if request.Body != nil {
+		go func() {
+			_, _ = io.Copy(io.Discard, request.Body)
+			_ = request.Body.Close()
+		}()
+	}
+	if interaction == nil {
+		return nil, fmt.Errorf("no recorded interaction for %s %s", request.Method, request.URL.RequestURI())
+	}
+	recorded := interaction.Response
+	major, minor, ok := http.ParseHTTPVersion(recorded.Proto)
+	if !ok {
+		major, minor = 1, 1
+	}
+	body := joinBody(recorded.Body, recorded.Frames)
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+		StatusCode:    recorded.StatusCode,
+		Proto:         recorded.Proto,
+		ProtoMajor:    major,
+		ProtoMinor:    minor,
+		Header:        cloneHeader(recorded.Header),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Trailer:       cloneHeader(recorded.Trailer),
+		Request:       request,
+	}, nil
+}
+
+// Unused returns the number of recorded interactions that haven't been
+// replayed. Tests can check it to make sure the code under test made every
+// recorded call.
+func (r *Replayer) Unused() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var unused int
+	for _, used := range r.used {
+		if !used {
+			unused++
+		}
+	}
+	return unused
+}
+
+func (r *Replayer) claim(method, url string) *Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, interaction := range r.interactions {
+		if r.used[i] || interaction.Request.Method != method || interaction.Request.URL != url {
+			continue
+		}
+		r.used[i] = true
+		return interaction
+	}
+	return nil
+}
+
+type redactionOption struct {
+	redact func(*Interaction)
+}
+
+func (o *redactionOption) applyToRecorder(recorder *Recorder) {
+	if o.redact != nil {
+		recorder.redact = append(recorder.redact, o.redact)
+	}
+}
+
+// recording is an in-progress Interaction. Bodies are recorded as they're
+// read, so streams can't be converted to Interactions until they finish.
+type recording struct {
+	method         string
+	url            string
+	header         http.Header
+	requestBody    lockedBuffer
+	response       *http.Response
+	responseHeader http.Header
+	responseBody   lockedBuffer
+}
+
+func (r *recording) interaction() *Interaction {
+	interaction := &Interaction{
+		Request: RecordedRequest{
+			Method: r.method,
+			URL:    r.url,
+			Header: r.header,
+		},
+		Response: RecordedResponse{
+			StatusCode: r.response.StatusCode,
+			Proto:      r.response.Proto,
+			Header:     r.responseHeader,
+			Trailer:    cloneHeader(r.response.Trailer),
+		},
+	}
+	interaction.Request.Body, interaction.Request.Frames = splitBody(
+		r.header.Get("Content-Type"),
+		r.requestBody.Bytes(),
+	)
+	interaction.Response.Body, interaction.Response.Frames = splitBody(
+		r.responseHeader.Get("Content-Type"),
+		r.responseBody.Bytes(),
+	)
+	return interaction
+}
+
+// recordingBody copies everything read from the underlying body.
+type recordingBody struct {
+	io.ReadCloser
+
+	data *lockedBuffer
+}
+
+func (b *recordingBody) Read(data []byte) (int, error) {
+	n, err := b.ReadCloser.Read(data)
+	b.data.Write(data[:n])
+	return n, err
+}
+
+// lockedBuffer is a bytes.Buffer that's safe to write while it's saved:
+// transports read request bodies on their own goroutines.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Write(data)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+// splitBody splits streaming bodies into envelopes. Unary bodies, and
+// streaming bodies cut off mid-envelope, are returned whole.
+func splitBody(contentType string, data []byte) ([]byte, []Frame) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if !strings.HasPrefix(contentType, "application/grpc") &&
+		!strings.HasPrefix(contentType, "application/connect+") {
+		return data, nil
+	}
+	var frames []Frame
+	for rest := data; len(rest) > 0; {
+		if len(rest) < envelopePrefixBytes {
+			return data, nil
+		}
+		size := binary.BigEndian.Uint32(rest[1:envelopePrefixBytes])
+		if uint64(len(rest)-envelopePrefixBytes) < uint64(size) {
+			return data, nil
+		}
+		end := envelopePrefixBytes + int(size)
+		frames = append(frames, Frame{
+			Flags: rest[0],
+			Data:  rest[envelopePrefixBytes:end],
+		})
+		rest = rest[end:]
+	}
+	return nil, frames
+}
+
+func joinBody(body []byte, frames []Frame) []byte {
+	if len(frames) == 0 {
+		return body
+	}
+	var joined bytes.Buffer
+	for _, frame := range frames {
+		var prefix [envelopePrefixBytes]byte
+		prefix[0] = frame.Flags
+		binary.BigEndian.PutUint32(prefix[1:], uint32(len(frame.Data)))
+		joined.Write(prefix[:])
+		joined.Write(frame.Data)
+	}
+	return joined.Bytes()
+}
+
+func cloneHeader(header http.Header) http.Header {
+	if header == nil {
+		return make(http.Header)
+	}
+	return header.Clone()
+}
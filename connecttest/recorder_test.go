@@ -0,0 +1,106 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connecttest_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/bufbuild/connect-go/connecttest"
+	"github.com/bufbuild/connect-go/internal/assert"
+	pingv1 "github.com/bufbuild/connect-go/internal/gen/connect/ping/v1"
+	"github.com/bufbuild/connect-go/internal/gen/connect/ping/v1/pingv1connect"
+)
+
+func TestRecordReplay(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(robustPingServer{}))
+	server := httptest.NewUnstartedServer(mux)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	// exercise makes unary, server streaming, and bidi calls, returning a
+	// transcript of the responses.
+	exercise := func(t *testing.T, httpClient connect.HTTPClient, baseURL string) string {
+		t.Helper()
+		var transcript strings.Builder
+		client := pingv1connect.NewPingServiceClient(httpClient, baseURL, connect.WithGRPC())
+		ctx := context.Background()
+		request := connect.NewRequest(&pingv1.PingRequest{Number: 42, Text: "hello"})
+		request.Header().Set("Authorization", "Bearer secret")
+		response, err := client.Ping(ctx, request)
+		assert.Nil(t, err)
+		transcript.WriteString(response.Msg.Text)
+		stream, err := client.CountUp(ctx, connect.NewRequest(&pingv1.CountUpRequest{Number: 100}))
+		assert.Nil(t, err)
+		assert.False(t, stream.Receive())
+		transcript.WriteString(" " + connect.CodeOf(stream.Err()).String())
+		assert.Nil(t, stream.Close())
+		stream, err = client.CountUp(ctx, connect.NewRequest(&pingv1.CountUpRequest{Number: 3}))
+		assert.Nil(t, err)
+		for stream.Receive() {
+			transcript.WriteString(" " + stream.Msg().String())
+		}
+		assert.Nil(t, stream.Err())
+		assert.Nil(t, stream.Close())
+		bidi := client.CumSum(ctx)
+		for i := int64(1); i <= 3; i++ {
+			assert.Nil(t, bidi.Send(&pingv1.CumSumRequest{Number: i}))
+		}
+		assert.Nil(t, bidi.CloseSend())
+		for {
+			msg, err := bidi.Receive()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			assert.Nil(t, err)
+			transcript.WriteString(" " + msg.String())
+		}
+		assert.Nil(t, bidi.CloseReceive())
+		return transcript.String()
+	}
+
+	path := filepath.Join(t.TempDir(), "ping.json")
+	recorder := connecttest.NewRecorder(
+		server.Client(),
+		connecttest.WithRedaction(connecttest.RedactHeaders("Authorization")),
+	)
+	recorded := exercise(t, recorder, server.URL)
+	assert.Nil(t, recorder.Save(path))
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.False(t, strings.Contains(string(data), "secret"))
+	assert.True(t, strings.Contains(string(data), "REDACTED"))
+
+	replayer, err := connecttest.NewReplayer(path)
+	assert.Nil(t, err)
+	replayed := exercise(t, replayer, "https://example.invalid")
+	assert.Equal(t, replayed, recorded)
+	assert.Zero(t, replayer.Unused())
+
+	client := pingv1connect.NewPingServiceClient(replayer, "https://example.invalid")
+	_, err = client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+	assert.NotNil(t, err)
+}
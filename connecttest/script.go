@@ -0,0 +1,267 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connecttest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/bufbuild/connect-go"
+	"google.golang.org/protobuf/proto"
+)
+
+// A Script is a fake streaming handler that plays out a fixed conversation,
+// message by message. Build a Script by chaining steps, then pass one of its
+// Handle methods to the matching handler constructor:
+//
+//	script := connecttest.NewScript[pingv1.CumSumRequest, pingv1.CumSumResponse](t).
+//		Expect(&pingv1.CumSumRequest{Number: 1}).
+//		SetHeader("Acme-Shard", "1").
+//		Send(&pingv1.CumSumResponse{Sum: 1}).
+//		ExpectEnd().
+//		SetTrailer("Acme-Total", "1")
+//	mux.Handle(connect.NewBidiStreamHandler(procedure, script.HandleBidiStream))
+//
+// If the client sends something other than what a step expects, the Script
+// reports a test error and fails the call with CodeInvalidArgument. A Script
+// may serve any number of calls, one step list per call.
+type Script[Req, Res any] struct {
+	tb    testing.TB
+	steps []scriptStep[Req, Res]
+}
+
+// NewScript constructs an empty Script that reports unexpected messages to tb.
+func NewScript[Req, Res any](tb testing.TB) *Script[Req, Res] {
+	return &Script[Req, Res]{tb: tb}
+}
+
+// Expect adds a step that receives the next message and checks that it equals
+// want. Protobuf messages are compared with proto.Equal.
+func (s *Script[Req, Res]) Expect(want *Req) *Script[Req, Res] {
+	return s.add(func(stream scriptStream[Req, Res]) error {
+		got, err := stream.receive()
+		if err != nil {
+			return s.unexpected("Expect", err, want)
+		}
+		if !messagesEqual(got, want) {
+			return s.unexpected("Expect", got, want)
+		}
+		return nil
+	})
+}
+
+// ExpectEnd adds a step that checks that the client has finished sending.
+func (s *Script[Req, Res]) ExpectEnd() *Script[Req, Res] {
+	return s.add(func(stream scriptStream[Req, Res]) error {
+		got, err := stream.receive()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return s.unexpected("ExpectEnd", err, io.EOF)
+		}
+		return s.unexpected("ExpectEnd", got, io.EOF)
+	})
+}
+
+// Send adds a step that sends a message. For client streams, which have a
+// single response, the message sent is the response and the script may send
+// only once.
+func (s *Script[Req, Res]) Send(msg *Res) *Script[Req, Res] {
+	return s.add(func(stream scriptStream[Req, Res]) error {
+		return stream.send(msg)
+	})
+}
+
+// SetHeader adds a step that adds a response header. Headers are sent with
+// the first message, so later SetHeader steps have no effect on server and
+// bidirectional streams.
+func (s *Script[Req, Res]) SetHeader(key, value string) *Script[Req, Res] {
+	return s.add(func(stream scriptStream[Req, Res]) error {
+		stream.responseHeader().Add(key, value)
+		return nil
+	})
+}
+
+// SetTrailer adds a step that adds a response trailer.
+func (s *Script[Req, Res]) SetTrailer(key, value string) *Script[Req, Res] {
+	return s.add(func(stream scriptStream[Req, Res]) error {
+		stream.responseTrailer().Add(key, value)
+		return nil
+	})
+}
+
+// Fail adds a step that ends the call with err. Steps after Fail never run.
+func (s *Script[Req, Res]) Fail(err error) *Script[Req, Res] {
+	return s.add(func(scriptStream[Req, Res]) error {
+		return err
+	})
+}
+
+// HandleBidiStream plays the script on a bidirectional stream. It has the
+// signature expected by connect.NewBidiStreamHandler.
+func (s *Script[Req, Res]) HandleBidiStream(_ context.Context, stream *connect.BidiStream[Req, Res]) error {
+	return s.run(&bidiScriptStream[Req, Res]{stream: stream})
+}
+
+// HandleServerStream plays the script on a server stream. The request is the
+// only message the client sends, so the first Expect step receives it. It has
+// the signature expected by connect.NewServerStreamHandler.
+func (s *Script[Req, Res]) HandleServerStream(
+	_ context.Context,
+	request *connect.Request[Req],
+	stream *connect.ServerStream[Res],
+) error {
+	return s.run(&serverScriptStream[Req, Res]{request: request.Msg, stream: stream})
+}
+
+// HandleClientStream plays the script on a client stream, responding with the
+// message from the script's Send step. It has the signature expected by
+// connect.NewClientStreamHandler.
+func (s *Script[Req, Res]) HandleClientStream(
+	_ context.Context,
+	stream *connect.ClientStream[Req],
+) (*connect.Response[Res], error) {
+	scripted := &clientScriptStream[Req, Res]{
+		stream:  stream,
+		header:  make(http.Header),
+		trailer: make(http.Header),
+	}
+	if err := s.run(scripted); err != nil {
+		return nil, err
+	}
+	if scripted.response == nil {
+		s.tb.Error("script for client stream never sent a response")
+		return nil, connect.NewError(connect.CodeInternal, errors.New("script has no response"))
+	}
+	response := connect.NewResponse(scripted.response)
+	mergeHeaders(response.Header(), scripted.header)
+	mergeHeaders(response.Trailer(), scripted.trailer)
+	return response, nil
+}
+
+func (s *Script[Req, Res]) add(step scriptStep[Req, Res]) *Script[Req, Res] {
+	s.steps = append(s.steps, step)
+	return s
+}
+
+func (s *Script[Req, Res]) run(stream scriptStream[Req, Res]) error {
+	for _, step := range s.steps {
+		if err := step(stream); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Script[Req, Res]) unexpected(step string, got, want any) error {
+	s.tb.Helper()
+	report(s.tb, "Script."+step, got, want)
+	return connect.NewError(
+		connect.CodeInvalidArgument,
+		fmt.Errorf("script step %s: got %v, want %v", step, got, want),
+	)
+}
+
+type scriptStep[Req, Res any] func(scriptStream[Req, Res]) error
+
+// scriptStream adapts the handler-side streams to a common interface.
+type scriptStream[Req, Res any] interface {
+	receive() (*Req, error)
+	send(*Res) error
+	responseHeader() http.Header
+	responseTrailer() http.Header
+}
+
+type bidiScriptStream[Req, Res any] struct {
+	stream *connect.BidiStream[Req, Res]
+}
+
+func (s *bidiScriptStream[Req, Res]) receive() (*Req, error) { return s.stream.Receive() }
+func (s *bidiScriptStream[Req, Res]) send(msg *Res) error    { return s.stream.Send(msg) }
+func (s *bidiScriptStream[Req, Res]) responseHeader() http.Header {
+	return s.stream.ResponseHeader()
+}
+func (s *bidiScriptStream[Req, Res]) responseTrailer() http.Header {
+	return s.stream.ResponseTrailer()
+}
+
+type serverScriptStream[Req, Res any] struct {
+	request *Req // nil once received
+	stream  *connect.ServerStream[Res]
+}
+
+func (s *serverScriptStream[Req, Res]) receive() (*Req, error) {
+	request := s.request
+	if request == nil {
+		return nil, io.EOF
+	}
+	s.request = nil
+	return request, nil
+}
+func (s *serverScriptStream[Req, Res]) send(msg *Res) error { return s.stream.Send(msg) }
+func (s *serverScriptStream[Req, Res]) responseHeader() http.Header {
+	return s.stream.ResponseHeader()
+}
+func (s *serverScriptStream[Req, Res]) responseTrailer() http.Header {
+	return s.stream.ResponseTrailer()
+}
+
+type clientScriptStream[Req, Res any] struct {
+	stream   *connect.ClientStream[Req]
+	response *Res
+	header   http.Header
+	trailer  http.Header
+}
+
+func (s *clientScriptStream[Req, Res]) receive() (*Req, error) {
+	if s.stream.Receive() {
+		return s.stream.Msg(), nil
+	}
+	if err := s.stream.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (s *clientScriptStream[Req, Res]) send(msg *Res) error {
+	if s.response != nil {
+		return connect.NewError(connect.CodeInternal, errors.New("script for client stream sent twice"))
+	}
+	s.response = msg
+	return nil
+}
+func (s *clientScriptStream[Req, Res]) responseHeader() http.Header  { return s.header }
+func (s *clientScriptStream[Req, Res]) responseTrailer() http.Header { return s.trailer }
+
+func messagesEqual(got, want any) bool {
+	gotProto, gotOK := got.(proto.Message)
+	wantProto, wantOK := want.(proto.Message)
+	if gotOK && wantOK {
+		return proto.Equal(gotProto, wantProto)
+	}
+	return reflect.DeepEqual(got, want)
+}
+
+func mergeHeaders(into, from http.Header) {
+	for key, values := range from {
+		into[key] = append(into[key], values...)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connecttest_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/bufbuild/connect-go/connecttest"
+	"github.com/bufbuild/connect-go/internal/assert"
+	pingv1 "github.com/bufbuild/connect-go/internal/gen/connect/ping/v1"
+	"github.com/bufbuild/connect-go/internal/gen/connect/ping/v1/pingv1connect"
+)
+
+func TestScript(t *testing.T) {
+	t.Parallel()
+	const (
+		sumProcedure     = "/" + pingv1connect.PingServiceName + "/Sum"
+		countUpProcedure = "/" + pingv1connect.PingServiceName + "/CountUp"
+		cumSumProcedure  = "/" + pingv1connect.PingServiceName + "/CumSum"
+	)
+	handlerTB := &fakeTB{TB: t}
+	handlerCapture := connecttest.NewCapture()
+	sum := connecttest.NewScript[pingv1.SumRequest, pingv1.SumResponse](handlerTB).
+		Expect(&pingv1.SumRequest{Number: 1}).
+		Expect(&pingv1.SumRequest{Number: 2}).
+		ExpectEnd().
+		SetHeader("Acme-Shard", "1").
+		Send(&pingv1.SumResponse{Sum: 3})
+	countUp := connecttest.NewScript[pingv1.CountUpRequest, pingv1.CountUpResponse](handlerTB).
+		Expect(&pingv1.CountUpRequest{Number: 2}).
+		Send(&pingv1.CountUpResponse{Number: 1}).
+		Send(&pingv1.CountUpResponse{Number: 2}).
+		SetTrailer("Acme-Count", "2").
+		Fail(connect.NewError(connect.CodeAborted, errors.New("done counting"))).
+		Send(&pingv1.CountUpResponse{Number: 3})
+	cumSum := connecttest.NewScript[pingv1.CumSumRequest, pingv1.CumSumResponse](handlerTB).
+		SetHeader("Acme-Shard", "2").
+		Expect(&pingv1.CumSumRequest{Number: 1}).
+		Send(&pingv1.CumSumResponse{Sum: 1}).
+		Expect(&pingv1.CumSumRequest{Number: 2}).
+		Send(&pingv1.CumSumResponse{Sum: 3}).
+		ExpectEnd().
+		SetTrailer("Acme-Total", "3")
+	interceptors := connect.WithInterceptors(handlerCapture)
+	mux := http.NewServeMux()
+	mux.Handle(sumProcedure, connect.NewClientStreamHandler(sumProcedure, sum.HandleClientStream, interceptors))
+	mux.Handle(countUpProcedure, connect.NewServerStreamHandler(countUpProcedure, countUp.HandleServerStream, interceptors))
+	mux.Handle(cumSumProcedure, connect.NewBidiStreamHandler(cumSumProcedure, cumSum.HandleBidiStream, interceptors))
+	server := httptest.NewUnstartedServer(mux)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	clientCapture := connecttest.NewCapture()
+	client := pingv1connect.NewPingServiceClient(
+		server.Client(),
+		server.URL,
+		connect.WithInterceptors(clientCapture),
+	)
+	ctx := context.Background()
+
+	t.Run("client_stream", func(t *testing.T) {
+		stream := client.Sum(ctx)
+		stream.RequestHeader().Set("Acme-Request", "sum")
+		assert.Nil(t, stream.Send(&pingv1.SumRequest{Number: 1}))
+		assert.Nil(t, stream.Send(&pingv1.SumRequest{Number: 2}))
+		response, err := stream.CloseAndReceive()
+		assert.Nil(t, err)
+		assert.Equal(t, response.Msg.Sum, int64(3))
+		connecttest.AssertHeader(t, response.Header(), "Acme-Shard", "1")
+		call := clientCapture.Last()
+		assert.Equal(t, call.Spec.Procedure, sumProcedure)
+		connecttest.AssertHeader(t, call.RequestHeader, "Acme-Request", "sum")
+		connecttest.AssertHeader(t, call.ResponseHeader, "Acme-Shard", "1")
+		assert.Nil(t, call.Err)
+	})
+	t.Run("server_stream", func(t *testing.T) {
+		stream, err := client.CountUp(ctx, connect.NewRequest(&pingv1.CountUpRequest{Number: 2}))
+		assert.Nil(t, err)
+		var got []int64
+		for stream.Receive() {
+			got = append(got, stream.Msg().Number)
+		}
+		assert.Equal(t, got, []int64{1, 2})
+		connecttest.AssertCode(t, stream.Err(), connect.CodeAborted)
+		assert.Nil(t, stream.Close())
+		call := clientCapture.Last()
+		assert.Equal(t, call.Spec.Procedure, countUpProcedure)
+		connecttest.AssertCode(t, call.Err, connect.CodeAborted)
+		connecttest.AssertHeader(t, call.ResponseTrailer, "Acme-Count", "2")
+	})
+	t.Run("bidi_stream", func(t *testing.T) {
+		stream := client.CumSum(ctx)
+		var sums []int64
+		for i := int64(1); i <= 2; i++ {
+			assert.Nil(t, stream.Send(&pingv1.CumSumRequest{Number: i}))
+			msg, err := stream.Receive()
+			assert.Nil(t, err)
+			sums = append(sums, msg.Sum)
+		}
+		assert.Nil(t, stream.CloseSend())
+		_, err := stream.Receive()
+		assert.ErrorIs(t, err, io.EOF)
+		assert.Nil(t, stream.CloseReceive())
+		assert.Equal(t, sums, []int64{1, 3})
+		call := clientCapture.Last()
+		assert.Equal(t, call.Spec.Procedure, cumSumProcedure)
+		connecttest.AssertHeader(t, call.ResponseHeader, "Acme-Shard", "2")
+		connecttest.AssertHeader(t, call.ResponseTrailer, "Acme-Total", "3")
+		assert.Nil(t, call.Err)
+	})
+	t.Run("unexpected", func(t *testing.T) {
+		stream := client.Sum(ctx)
+		assert.Nil(t, stream.Send(&pingv1.SumRequest{Number: 2}))
+		_, err := stream.CloseAndReceive()
+		connecttest.AssertCode(t, err, connect.CodeInvalidArgument)
+		assert.Equal(t, len(handlerTB.errors()), 1)
+	})
+	t.Run("handler_capture", func(t *testing.T) {
+		calls := handlerCapture.Calls()
+		assert.Equal(t, len(calls), 4)
+		byProcedure := make(map[string]*connecttest.CapturedCall)
+		for _, call := range calls {
+			assert.False(t, call.Spec.IsClient)
+			if call.Err == nil || call.Spec.Procedure != sumProcedure {
+				byProcedure[call.Spec.Procedure] = call
+			}
+		}
+		connecttest.AssertHeader(t, byProcedure[sumProcedure].RequestHeader, "Acme-Request", "sum")
+		connecttest.AssertHeader(t, byProcedure[sumProcedure].ResponseHeader, "Acme-Shard", "1")
+		connecttest.AssertCode(t, byProcedure[countUpProcedure].Err, connect.CodeAborted)
+		connecttest.AssertHeader(t, byProcedure[countUpProcedure].ResponseTrailer, "Acme-Count", "2")
+		connecttest.AssertHeader(t, byProcedure[cumSumProcedure].ResponseTrailer, "Acme-Total", "3")
+	})
+}
@@ -0,0 +1,307 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// debugRecentCalls is the number of completed calls shown on the debug page.
+const debugRecentCalls = 64
+
+// A DebugPage collects lightweight statistics about the RPCs served by
+// handlers and renders them as an HTML page, for quick triage in production.
+// It shows the registered procedures, their request counts and error codes,
+// the most recently completed calls, and any streams still in progress.
+//
+// A DebugPage is both a HandlerOption and an http.Handler. Pass it to handler
+// constructors to collect statistics, and mount it on a mux to serve the
+// page:
+//
+//	debug := connect.NewDebugPage()
+//	mux := http.NewServeMux()
+//	mux.Handle(pingv1connect.NewPingServiceHandler(&pingServer{}, debug))
+//	mux.Handle("/debug/connect", debug)
+//
+// The page exposes procedure names and error rates, so it shouldn't be
+// reachable by untrusted clients.
+type DebugPage struct {
+	mu         sync.Mutex
+	procedures map[string]*debugProcedure
+	recent     []debugCall // ring buffer
+	next       int
+	active     map[*debugStream]struct{}
+}
+
+// NewDebugPage constructs an empty DebugPage.
+func NewDebugPage() *DebugPage {
+	return &DebugPage{
+		procedures: make(map[string]*debugProcedure),
+		recent:     make([]debugCall, 0, debugRecentCalls),
+		active:     make(map[*debugStream]struct{}),
+	}
+}
+
+func (p *DebugPage) applyToHandler(config *handlerConfig) {
+	p.mu.Lock()
+	p.procedure(config.Procedure)
+	p.mu.Unlock()
+	option := &interceptorsOption{Interceptors: []Interceptor{&debugInterceptor{page: p, config: config}}}
+	option.applyToHandler(config)
+}
+
+// ServeHTTP implements http.Handler.
+func (p *DebugPage) ServeHTTP(responseWriter http.ResponseWriter, request *http.Request) {
+	data := p.snapshot()
+	responseWriter.Header().Set(headerContentType, "text/html; charset=utf-8")
+	_ = debugTemplate.Execute(responseWriter, data)
+}
+
+// procedure must be called with the lock held.
+func (p *DebugPage) procedure(name string) *debugProcedure {
+	proc, ok := p.procedures[name]
+	if !ok {
+		proc = &debugProcedure{codes: make(map[Code]int64)}
+		p.procedures[name] = proc
+	}
+	return proc
+}
+
+func (p *DebugPage) finish(spec Spec, start, end time.Time, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	proc := p.procedure(spec.Procedure)
+	proc.streamType = spec.StreamType
+	proc.calls++
+	code := "ok"
+	if err != nil {
+		proc.codes[CodeOf(err)]++
+		code = CodeOf(err).String()
+	}
+	call := debugCall{
+		Procedure: spec.Procedure,
+		Start:     start,
+		Duration:  end.Sub(start),
+		Code:      code,
+	}
+	if len(p.recent) < debugRecentCalls {
+		p.recent = append(p.recent, call)
+	} else {
+		p.recent[p.next] = call
+	}
+	p.next = (p.next + 1) % debugRecentCalls
+}
+
+func (p *DebugPage) snapshot() *debugData {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	data := &debugData{}
+	for name, proc := range p.procedures {
+		summary := debugProcedureSummary{
+			Name:       name,
+			StreamType: streamTypeName(proc.streamType),
+			Calls:      proc.calls,
+		}
+		var failed int64
+		for code, count := range proc.codes {
+			failed += count
+			summary.Errors = append(summary.Errors, debugCodeCount{Code: code.String(), Count: count})
+		}
+		sort.Slice(summary.Errors, func(i, j int) bool {
+			return summary.Errors[i].Code < summary.Errors[j].Code
+		})
+		if proc.calls > 0 {
+			summary.ErrorPercent = 100 * float64(failed) / float64(proc.calls)
+		}
+		data.Procedures = append(data.Procedures, summary)
+	}
+	sort.Slice(data.Procedures, func(i, j int) bool {
+		return data.Procedures[i].Name < data.Procedures[j].Name
+	})
+	for stream := range p.active {
+		data.Active = append(data.Active, debugActiveStream{
+			Procedure:  stream.spec.Procedure,
+			StreamType: streamTypeName(stream.spec.StreamType),
+			Age:        stream.clock.Now().Sub(stream.start),
+		})
+	}
+	sort.Slice(data.Active, func(i, j int) bool {
+		return data.Active[i].Age > data.Active[j].Age
+	})
+	// Show the most recent calls first.
+	for i := 0; i < len(p.recent); i++ {
+		index := (p.next - 1 - i + 2*debugRecentCalls) % debugRecentCalls
+		if index >= len(p.recent) {
+			continue
+		}
+		data.Recent = append(data.Recent, p.recent[index])
+	}
+	return data
+}
+
+type debugProcedure struct {
+	streamType StreamType
+	calls      int64
+	codes      map[Code]int64
+}
+
+type debugStream struct {
+	spec  Spec
+	clock Clock
+	start time.Time
+	once  sync.Once
+}
+
+type debugCall struct {
+	Procedure string
+	Start     time.Time
+	Duration  time.Duration
+	Code      string
+}
+
+type debugData struct {
+	Procedures []debugProcedureSummary
+	Active     []debugActiveStream
+	Recent     []debugCall
+}
+
+type debugProcedureSummary struct {
+	Name         string
+	StreamType   string
+	Calls        int64
+	ErrorPercent float64
+	Errors       []debugCodeCount
+}
+
+type debugCodeCount struct {
+	Code  string
+	Count int64
+}
+
+type debugActiveStream struct {
+	Procedure  string
+	StreamType string
+	Age        time.Duration
+}
+
+// debugInterceptor feeds a DebugPage. Unary calls are recorded by WrapUnary;
+// streams are tracked from the creation of their Sender until it's closed.
+type debugInterceptor struct {
+	page *DebugPage
+	// Options may come in any order, so the handler's Clock is read from its
+	// config at call time.
+	config *handlerConfig
+}
+
+func (i *debugInterceptor) WrapUnary(next UnaryFunc) UnaryFunc {
+	return func(ctx context.Context, request AnyRequest) (AnyResponse, error) {
+		clock := i.config.Clock
+		start := clock.Now()
+		response, err := next(ctx, request)
+		i.page.finish(request.Spec(), start, clock.Now(), err)
+		return response, err
+	}
+}
+
+func (i *debugInterceptor) WrapStreamContext(ctx context.Context) context.Context {
+	return ctx
+}
+
+func (i *debugInterceptor) WrapStreamSender(_ context.Context, sender Sender) Sender {
+	if sender.Spec().IsClient {
+		return sender
+	}
+	clock := i.config.Clock
+	stream := &debugStream{spec: sender.Spec(), clock: clock, start: clock.Now()}
+	i.page.mu.Lock()
+	i.page.active[stream] = struct{}{}
+	i.page.mu.Unlock()
+	return &debugSender{Sender: sender, page: i.page, stream: stream}
+}
+
+func (i *debugInterceptor) WrapStreamReceiver(_ context.Context, receiver Receiver) Receiver {
+	return receiver
+}
+
+type debugSender struct {
+	Sender
+
+	page   *DebugPage
+	stream *debugStream
+}
+
+func (s *debugSender) Close(err error) error {
+	s.stream.once.Do(func() {
+		s.page.mu.Lock()
+		delete(s.page.active, s.stream)
+		s.page.mu.Unlock()
+		s.page.finish(s.stream.spec, s.stream.start, s.stream.clock.Now(), err)
+	})
+	return s.Sender.Close(err)
+}
+
+func streamTypeName(streamType StreamType) string {
+	switch streamType {
+	case StreamTypeUnary:
+		return "unary"
+	case StreamTypeClient:
+		return "client streaming"
+	case StreamTypeServer:
+		return "server streaming"
+	case StreamTypeBidi:
+		return "bidi streaming"
+	default:
+		return "unknown"
+	}
+}
+
+var debugTemplate = template.Must(template.New("debug").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>connect</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.25em 0.75em; text-align: left; }
+</style>
+</head>
+<body>
+<h1>Procedures</h1>
+<table>
+<tr><th>Procedure</th><th>Type</th><th>Calls</th><th>Error rate</th><th>Errors by code</th></tr>
+{{range .Procedures}}<tr>
+<td>{{.Name}}</td><td>{{if .Calls}}{{.StreamType}}{{end}}</td><td>{{.Calls}}</td>
+<td>{{printf "%.2f%%" .ErrorPercent}}</td>
+<td>{{range .Errors}}{{.Code}}: {{.Count}}<br>{{end}}</td>
+</tr>{{end}}
+</table>
+<h1>Active streams</h1>
+<table>
+<tr><th>Procedure</th><th>Type</th><th>Age</th></tr>
+{{range .Active}}<tr><td>{{.Procedure}}</td><td>{{.StreamType}}</td><td>{{.Age}}</td></tr>{{end}}
+</table>
+<h1>Recent calls</h1>
+<table>
+<tr><th>Procedure</th><th>Started</th><th>Duration</th><th>Code</th></tr>
+{{range .Recent}}<tr><td>{{.Procedure}}</td><td>{{.Start.Format "2006-01-02T15:04:05.000Z07:00"}}</td><td>{{.Duration}}</td><td>{{.Code}}</td></tr>{{end}}
+</table>
+</body>
+</html>
+`))
@@ -0,0 +1,229 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"io"
+	"mime"
+	"net/http"
+
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// WithDownloadFilename sets the filename browsers suggest when saving a
+// download served by NewDownloadHandler. Without it, the Content-Disposition
+// header has no filename. Other handlers ignore it.
+func WithDownloadFilename(filename string) HandlerOption {
+	return &downloadFilenameOption{filename: filename}
+}
+
+// WithDownloadContentType sets the Content-Type of a download served by
+// NewDownloadHandler. The default is application/octet-stream. Other handlers
+// ignore it.
+func WithDownloadContentType(contentType string) HandlerOption {
+	return &downloadContentTypeOption{contentType: contentType}
+}
+
+// NewDownloadHandler exposes a server streaming procedure whose responses
+// carry chunks of a file, like an export, as a plain HTTP download that
+// browsers can consume directly. Parse builds the request message from the
+// HTTP request (typically from its query parameters); if parse is nil, the
+// implementation receives an empty message. The implementation is the same
+// function passed to NewServerStreamHandler, and unwrap returns the chunk
+// carried by each response message. The chunks are concatenated into the
+// response body and flushed as they're sent.
+//
+// The handler options are the same ones passed to NewServerStreamHandler, so
+// interceptors (for authentication, say), validation, and the other handler
+// options apply to downloads too. Options that only make sense for the RPC
+// protocols, like codecs and compression, have no effect.
+//
+// The response is sent with "Content-Disposition: attachment", so browsers
+// save it rather than displaying it. Implementations may override the
+// Content-Type and Content-Disposition (to set a per-request filename, for
+// example) by modifying the stream's response headers before the first call
+// to Send.
+//
+// Errors returned before the first chunk is sent are written like unary
+// Connect errors, with an HTTP status derived from the error code and a JSON
+// body; the download's Content-Type and Content-Disposition are removed, so
+// browsers don't save the error as the file. Once the download has started,
+// the status can't change, so the handler aborts the response instead and
+// the client sees a failed download rather than a truncated file.
+func NewDownloadHandler[Req, Res any](
+	procedure string,
+	parse func(*http.Request) (*Req, error),
+	implementation func(context.Context, *Request[Req], *ServerStream[Res]) error,
+	unwrap func(*Res) []byte,
+	options ...HandlerOption,
+) http.Handler {
+	config := newHandlerConfig(procedure, options)
+	contentType := config.DownloadContentType
+	if contentType == "" {
+		contentType = httpBodyDefaultType
+	}
+	disposition := "attachment"
+	if config.DownloadFilename != "" {
+		disposition = mime.FormatMediaType(disposition, map[string]string{"filename": config.DownloadFilename})
+	}
+	handler := newServerStreamHandler(config, implementation)
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet && request.Method != http.MethodPost {
+			responseWriter.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+			responseWriter.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		header := responseWriter.Header()
+		header.Set(headerContentType, contentType)
+		header.Set("Content-Disposition", disposition)
+		sender := &downloadSender{
+			spec:   handler.spec,
+			writer: responseWriter,
+			types:  config.Types,
+			unwrap: func(message any) ([]byte, error) {
+				typed, ok := message.(*Res)
+				if !ok {
+					return nil, errorf(CodeInternal, "expected response of type %T, got %T", (*Res)(nil), message)
+				}
+				return unwrap(typed), nil
+			},
+		}
+		receiver := &downloadReceiver[Req]{
+			spec:    handler.spec,
+			request: request,
+			parse:   parse,
+		}
+		handler.serveStream(request.Context(), sender, receiver)
+		if sender.aborted {
+			// Abort the response so that the client doesn't mistake a partial
+			// download for a complete one.
+			panic(http.ErrAbortHandler)
+		}
+	})
+}
+
+type downloadFilenameOption struct {
+	filename string
+}
+
+func (o *downloadFilenameOption) applyToHandler(config *handlerConfig) {
+	config.DownloadFilename = o.filename
+}
+
+type downloadContentTypeOption struct {
+	contentType string
+}
+
+func (o *downloadContentTypeOption) applyToHandler(config *handlerConfig) {
+	config.DownloadContentType = o.contentType
+}
+
+// downloadReceiver delivers the single request message built by parse.
+type downloadReceiver[Req any] struct {
+	spec     Spec
+	request  *http.Request
+	parse    func(*http.Request) (*Req, error)
+	received bool
+}
+
+func (r *downloadReceiver[Req]) Spec() Spec {
+	return r.spec
+}
+
+func (r *downloadReceiver[Req]) Header() http.Header {
+	return r.request.Header
+}
+
+func (r *downloadReceiver[Req]) Trailer() (http.Header, bool) {
+	return nil, false
+}
+
+func (r *downloadReceiver[Req]) Close() error {
+	return nil
+}
+
+func (r *downloadReceiver[Req]) Receive(message any) error {
+	if r.received {
+		return NewError(CodeUnknown, io.EOF)
+	}
+	r.received = true
+	typed, ok := message.(*Req)
+	if !ok {
+		return errorf(CodeInternal, "expected message of type %T, got %T", (*Req)(nil), message)
+	}
+	if r.parse == nil {
+		return nil
+	}
+	parsed, err := r.parse(r.request)
+	if err != nil {
+		return err
+	}
+	copyMessage(typed, parsed)
+	return nil
+}
+
+// downloadSender writes each message's chunk directly to the response body.
+type downloadSender struct {
+	spec      Spec
+	writer    http.ResponseWriter
+	types     *protoregistry.Types
+	unwrap    func(any) ([]byte, error)
+	wroteBody bool
+	aborted   bool // failed after writing part of the body
+}
+
+func (s *downloadSender) Spec() Spec {
+	return s.spec
+}
+
+func (s *downloadSender) Header() http.Header {
+	return s.writer.Header()
+}
+
+// Trailer returns false, since plain HTTP downloads don't have trailers.
+func (s *downloadSender) Trailer() (http.Header, bool) {
+	return nil, false
+}
+
+func (s *downloadSender) Send(message any) error {
+	chunk, err := s.unwrap(message)
+	if err != nil {
+		return err
+	}
+	s.wroteBody = true
+	if _, err := s.writer.Write(chunk); err != nil {
+		return errorf(CodeUnknown, "write download: %w", err)
+	}
+	flushResponseWriter(s.writer)
+	return nil
+}
+
+// Close writes the error, if any. Before the body has started, it replaces
+// the download's headers with a unary Connect error; afterwards, it marks the
+// response for aborting.
+func (s *downloadSender) Close(err error) error {
+	if err == nil {
+		return nil
+	}
+	if s.wroteBody {
+		s.aborted = true
+		return nil
+	}
+	header := s.writer.Header()
+	header.Del(headerContentType)
+	header.Del("Content-Disposition")
+	return writeConnectUnaryError(s.writer, err, s.types)
+}
@@ -21,6 +21,7 @@ import (
 	"io"
 	"net/http"
 	"sync"
+	"sync/atomic"
 )
 
 // duplexHTTPCall is a full-duplex stream between the client and server. The
@@ -30,6 +31,7 @@ import (
 // Be warned: we need to use some lesser-known APIs to do this with net/http.
 type duplexHTTPCall struct {
 	ctx              context.Context
+	cancel           context.CancelFunc // aborts the HTTP request
 	httpClient       HTTPClient
 	streamType       StreamType
 	validateResponse func(*http.Response) *Error
@@ -44,6 +46,7 @@ type duplexHTTPCall struct {
 	responseReady   chan struct{}
 	request         *http.Request
 	response        *http.Response
+	responseEOF     int32 // atomic, 1 once the response body is exhausted
 
 	errMu sync.Mutex
 	err   error
@@ -57,8 +60,11 @@ func newDuplexHTTPCall(
 	header http.Header,
 ) *duplexHTTPCall {
 	pipeReader, pipeWriter := io.Pipe()
+	// CloseRead may need to abort the request while net/http is still reading
+	// the response, so the request gets its own cancelable context.
+	requestCtx, cancel := context.WithCancel(ctx)
 	request, err := http.NewRequestWithContext(
-		ctx,
+		requestCtx,
 		http.MethodPost,
 		url,
 		pipeReader,
@@ -66,6 +72,7 @@ func newDuplexHTTPCall(
 	request.Header = header
 	client := &duplexHTTPCall{
 		ctx:               ctx,
+		cancel:            cancel,
 		httpClient:        httpClient,
 		streamType:        spec.StreamType,
 		requestBodyReader: pipeReader,
@@ -153,10 +160,35 @@ func (d *duplexHTTPCall) Read(data []byte) (int, error) {
 	if d.response == nil {
 		return 0, fmt.Errorf("nil response from %v", d.request.URL)
 	}
-	return d.response.Body.Read(data)
+	bytesRead, err := d.response.Body.Read(data)
+	if errors.Is(err, io.EOF) {
+		atomic.StoreInt32(&d.responseEOF, 1)
+	} else if err != nil {
+		// If CloseRead aborted the request, report that rather than the
+		// transport's error.
+		if closedErr := d.getError(); closedErr != nil {
+			return bytesRead, closedErr
+		}
+		return bytesRead, &transportError{err: err}
+	}
+	return bytesRead, err
 }
 
+// CloseRead closes the response body. If the stream is still active, it
+// aborts the request: reads blocked in other goroutines return immediately
+// with CodeCanceled, and the server sees the stream canceled. Otherwise, it
+// drains the response body so the connection can be reused.
 func (d *duplexHTTPCall) CloseRead() error {
+	defer d.cancel()
+	if d.getError() == nil && atomic.LoadInt32(&d.responseEOF) == 0 {
+		d.SetError(errorf(CodeCanceled, "receive side of stream closed").withEndReason(StreamEndLocalClose))
+		d.cancel()
+		d.BlockUntilResponseReady()
+		if d.response != nil {
+			_ = d.response.Body.Close()
+		}
+		return nil
+	}
 	d.BlockUntilResponseReady()
 	if d.response == nil {
 		return nil
@@ -246,7 +278,7 @@ func (d *duplexHTTPCall) makeRequest() {
 		err = wrapIfLikelyH2CNotConfiguredError(d.request, err)
 		err = wrapIfLikelyWithGRPCNotUsedError(err)
 		if _, ok := asError(err); !ok {
-			err = NewError(CodeUnavailable, err)
+			err = NewError(CodeUnavailable, err).withEndReason(StreamEndNetwork)
 		}
 		d.SetError(err)
 		return
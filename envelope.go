@@ -15,10 +15,13 @@
 package connect
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"errors"
 	"io"
+
+	"google.golang.org/protobuf/proto"
 )
 
 // flagEnvelopeCompressed indicates that the data is compressed. It has the
@@ -54,21 +57,69 @@ type envelopeWriter struct {
 	compressMinBytes int
 	compressionPool  *compressionPool
 	bufferPool       *bufferPool
+	checksum         bool // append a CRC32C to each envelope
+	sendMaxBytes     int  // zero means no limit
+	// Streams compress many messages, so we hold on to a single compressor
+	// until the stream is closed rather than going back to the pool each time.
+	compressor Compressor
 }
 
 func (w *envelopeWriter) Marshal(message any) *Error {
+	return w.marshal(message, true /* compress */)
+}
+
+// MarshalUncompressed is like Marshal, but never compresses the message. It's
+// useful for messages that are already compressed, like images.
+func (w *envelopeWriter) MarshalUncompressed(message any) *Error {
+	return w.marshal(message, false /* compress */)
+}
+
+func (w *envelopeWriter) marshal(message any, compress bool) *Error {
 	raw, err := w.codec.Marshal(message)
 	if err != nil {
 		return errorf(CodeInternal, "marshal message: %w", err)
 	}
+	if w.sendMaxBytes > 0 && len(raw) > w.sendMaxBytes {
+		return errorf(CodeResourceExhausted, "message size %d exceeds sendMaxBytes %d", len(raw), w.sendMaxBytes)
+	}
+	w.bufferPool.budget.add(int64(len(raw)))
+	defer w.bufferPool.budget.release(int64(len(raw)))
 	// We can't avoid allocating the byte slice, so we may as well reuse it once
 	// we're done with it.
 	buffer := bytes.NewBuffer(raw)
 	defer w.bufferPool.Put(buffer)
 	envelope := &envelope{Data: buffer}
+	if !compress {
+		return w.write(envelope)
+	}
 	return w.Write(envelope)
 }
 
+// MarshalBatch marshals and envelopes all the messages into a single buffer,
+// then writes the buffer to the underlying writer with one call to Write. If
+// any message fails to marshal, nothing is written.
+func (w *envelopeWriter) MarshalBatch(messages []any) *Error {
+	batch := w.bufferPool.Get()
+	defer w.bufferPool.Put(batch)
+	buffered := *w
+	buffered.writer = batch
+	defer func() {
+		w.compressor = buffered.compressor
+	}()
+	for _, message := range messages {
+		if err := buffered.Marshal(message); err != nil {
+			return err
+		}
+	}
+	if _, err := w.writer.Write(batch.Bytes()); err != nil {
+		if connectErr, ok := asError(err); ok {
+			return connectErr
+		}
+		return errorf(CodeUnknown, "write envelopes: %w", err)
+	}
+	return nil
+}
+
 // Write writes the enveloped message, compressing as necessary. It doesn't
 // retain any references to the supplied envelope or its underlying data.
 func (w *envelopeWriter) Write(env *envelope) *Error {
@@ -79,7 +130,7 @@ func (w *envelopeWriter) Write(env *envelope) *Error {
 	}
 	data := w.bufferPool.Get()
 	defer w.bufferPool.Put(data)
-	if err := w.compressionPool.Compress(data, env.Data); err != nil {
+	if err := w.compress(data, env.Data); err != nil {
 		return err
 	}
 	return w.write(&envelope{
@@ -88,10 +139,50 @@ func (w *envelopeWriter) Write(env *envelope) *Error {
 	})
 }
 
+func (w *envelopeWriter) compress(dst *bytes.Buffer, src *bytes.Buffer) *Error {
+	if w.compressor == nil {
+		compressor, err := w.compressionPool.getCompressor(dst)
+		if err != nil {
+			return errorf(CodeUnknown, "get compressor: %w", err)
+		}
+		w.compressor = compressor
+	} else {
+		w.compressor.Reset(dst)
+	}
+	if _, err := io.Copy(w.compressor, src); err != nil {
+		w.release()
+		return errorf(CodeInternal, "compress: %w", err)
+	}
+	// Closing flushes the compressed data, but leaves the compressor ready to
+	// Reset for the next message.
+	if err := w.compressor.Close(); err != nil {
+		w.release()
+		return errorf(CodeInternal, "compress: %w", err)
+	}
+	return nil
+}
+
+// release returns the writer's compressor, if any, to the pool. Protocols
+// call it when the stream's send side is closed.
+func (w *envelopeWriter) release() {
+	if w.compressor == nil {
+		return
+	}
+	w.compressionPool.recycleCompressor(w.compressor)
+	w.compressor = nil
+}
+
 func (w *envelopeWriter) write(env *envelope) *Error {
 	prefix := [5]byte{}
 	prefix[0] = env.Flags
-	binary.BigEndian.PutUint32(prefix[1:5], uint32(env.Data.Len()))
+	size := env.Data.Len()
+	var checksum [checksumSize]byte
+	if w.checksum {
+		prefix[0] |= flagEnvelopeChecksum
+		size += checksumSize
+		binary.BigEndian.PutUint32(checksum[:], checksumData(env.Data.Bytes()))
+	}
+	binary.BigEndian.PutUint32(prefix[1:5], uint32(size))
 	if _, err := w.writer.Write(prefix[:]); err != nil {
 		if connectErr, ok := asError(err); ok {
 			return connectErr
@@ -101,29 +192,75 @@ func (w *envelopeWriter) write(env *envelope) *Error {
 	if _, err := io.Copy(w.writer, env.Data); err != nil {
 		return errorf(CodeUnknown, "write message: %w", err)
 	}
+	if w.checksum {
+		if _, err := w.writer.Write(checksum[:]); err != nil {
+			return errorf(CodeUnknown, "write checksum: %w", err)
+		}
+	}
 	return nil
 }
 
+// readAheadSize is the size of the buffer envelopeReaders use to coalesce
+// reads once a stream receives messages in batches.
+const readAheadSize = 32 * 1024
+
+// maxEnvelopePreallocation limits how much buffer space envelopeReaders
+// allocate based on the size prefix alone.
+const maxEnvelopePreallocation = 4 * 1024 * 1024 // 4 MiB
+
 type envelopeReader struct {
 	reader          io.Reader
 	codec           Codec
 	last            envelope
 	compressionPool *compressionPool
 	bufferPool      *bufferPool
+	readMaxBytes    int // zero means no limit
+	// Like envelopeWriter, hold a single decompressor for the whole stream. We
+	// return it to the pool once the stream ends.
+	decompressor Decompressor
+	// reserved is the memory budget held for the message being read.
+	reserved int64
+	// buffered is true once readAhead has wrapped reader.
+	buffered bool
+}
+
+// readAhead buffers reads from the underlying reader, so that one read from
+// the network can fetch several small envelopes. Once enabled, it stays on
+// for the rest of the stream: the buffer may already hold later envelopes.
+func (r *envelopeReader) readAhead() {
+	if r.buffered {
+		return
+	}
+	r.reader = bufio.NewReaderSize(r.reader, readAheadSize)
+	r.buffered = true
 }
 
 func (r *envelopeReader) Unmarshal(message any) *Error {
+	err := r.unmarshal(message)
+	if err != nil {
+		// Whether it's an EOF, the end-of-stream message, or a real error, we
+		// won't be decompressing any more messages.
+		r.release()
+	}
+	return err
+}
+
+func (r *envelopeReader) unmarshal(message any) *Error {
 	buffer := r.bufferPool.Get()
 	defer r.bufferPool.Put(buffer)
 
 	env := &envelope{Data: buffer}
 	err := r.Read(env)
+	defer r.releaseReserved()
 	switch {
 	case err == nil &&
 		(env.Flags == 0 || env.Flags == flagEnvelopeCompressed) &&
 		env.Data.Len() == 0:
 		// This is a standard message (because none of the top 7 bits are set) and
 		// there's no data, so the zero value of the message is correct.
+		if lazy, ok := message.(*lazyMessage); ok {
+			lazy.capture(r.codec, nil)
+		}
 		return nil
 	case err != nil && errors.Is(err, io.EOF):
 		// The stream has ended. Propagate the EOF to the caller.
@@ -143,7 +280,7 @@ func (r *envelopeReader) Unmarshal(message any) *Error {
 		}
 		decompressed := r.bufferPool.Get()
 		defer r.bufferPool.Put(decompressed)
-		if err := r.compressionPool.Decompress(decompressed, data); err != nil {
+		if err := r.decompress(decompressed, data); err != nil {
 			return err
 		}
 		data = decompressed
@@ -166,76 +303,181 @@ func (r *envelopeReader) Unmarshal(message any) *Error {
 		return errSpecialEnvelope
 	}
 
+	if lazy, ok := message.(*lazyMessage); ok {
+		// The caller wants to defer unmarshaling. Since data goes back to the
+		// pool when we return, the lazy message keeps its own copy.
+		lazy.capture(r.codec, data.Bytes())
+		return nil
+	}
 	if err := r.codec.Unmarshal(data.Bytes(), message); err != nil {
 		return errorf(CodeInvalidArgument, "unmarshal into %T: %w", message, err)
 	}
 	return nil
 }
 
+func (r *envelopeReader) decompress(dst *bytes.Buffer, src *bytes.Buffer) *Error {
+	if r.decompressor == nil {
+		decompressor, err := r.compressionPool.getDecompressor(src)
+		if err != nil {
+			if decompressor != nil {
+				r.compressionPool.recycleDecompressor(decompressor)
+			}
+			return errorf(CodeInvalidArgument, "get decompressor: %w", err)
+		}
+		r.decompressor = decompressor
+	} else if err := r.decompressor.Reset(src); err != nil {
+		return errorf(CodeInvalidArgument, "get decompressor: %w", err)
+	}
+	var reader io.Reader = r.decompressor
+	if r.readMaxBytes > 0 {
+		reader = io.LimitReader(reader, int64(r.readMaxBytes)+1)
+	}
+	bytesRead, err := dst.ReadFrom(reader)
+	if err != nil {
+		return errorf(CodeInvalidArgument, "decompress: %w", err)
+	}
+	if r.readMaxBytes > 0 && bytesRead > int64(r.readMaxBytes) {
+		return errorf(CodeResourceExhausted, "decompressed message size exceeds readMaxBytes %d", r.readMaxBytes)
+	}
+	if err := r.decompressor.Close(); err != nil {
+		return errorf(CodeUnknown, "recycle decompressor: %w", err)
+	}
+	return nil
+}
+
+// releaseReserved returns the memory budget reserved by Read.
+func (r *envelopeReader) releaseReserved() {
+	r.bufferPool.budget.release(r.reserved)
+	r.reserved = 0
+}
+
+func (r *envelopeReader) release() {
+	if r.decompressor == nil {
+		return
+	}
+	r.compressionPool.recycleDecompressor(r.decompressor)
+	r.decompressor = nil
+}
+
 func (r *envelopeReader) Read(env *envelope) *Error {
 	prefixes := [5]byte{}
-	prefixBytesRead, err := r.reader.Read(prefixes[:])
-
+	// The prefix may arrive in several chunks, so we use io.ReadFull rather than
+	// a single Read.
+	prefixBytesRead, err := io.ReadFull(r.reader, prefixes[:])
 	switch {
-	case (err == nil || errors.Is(err, io.EOF)) &&
-		prefixBytesRead == 5 &&
-		isSizeZeroPrefix(prefixes):
-		// Successfully read prefix and expect no additional data.
-		env.Flags = prefixes[0]
-		return nil
 	case err != nil && errors.Is(err, io.EOF) && prefixBytesRead == 0:
 		// The stream ended cleanly. That's expected, but we need to propagate them
 		// to the user so that they know that the stream has ended. We shouldn't
 		// add any alarming text about protocol errors, though.
 		return NewError(CodeUnknown, err)
-	case err != nil || prefixBytesRead < 5:
+	case err != nil:
 		// Something else has gone wrong - the stream didn't end cleanly.
 		if connectErr, ok := asError(err); ok {
 			return connectErr
 		}
-		return errorf(
+		incompleteErr := errorf(
 			CodeInvalidArgument,
 			"protocol error: incomplete envelope: %w", err,
 		)
+		if !isTransportError(err) {
+			// The peer ended the stream partway through the prefix.
+			incompleteErr.endReason = StreamEndProtocol
+		}
+		return incompleteErr
 	}
-	size := int(binary.BigEndian.Uint32(prefixes[1:5]))
-	if size < 0 {
-		return errorf(CodeInvalidArgument, "message size %d overflowed uint32", size)
-	}
-	if size > 0 {
-		env.Data.Grow(size)
-		// At layer 7, we don't know exactly what's happening down in L4. Large
-		// length-prefixed messages may arrive in chunks, so we may need to read
-		// the request body past EOF. We also need to take care that we don't retry
-		// forever if the message is malformed.
-		remaining := int64(size)
-		for remaining > 0 {
-			bytesRead, err := io.CopyN(env.Data, r.reader, remaining)
-			if err != nil && !errors.Is(err, io.EOF) {
-				return errorf(CodeUnknown, "read enveloped message: %w", err)
-			}
-			if errors.Is(err, io.EOF) && bytesRead == 0 {
-				// We've gotten zero-length chunk of data. Message is likely malformed,
-				// don't wait for additional chunks.
-				return errorf(
-					CodeInvalidArgument,
-					"protocol error: promised %d bytes in enveloped message, got %d bytes",
-					size,
-					int64(size)-remaining,
-				)
+	// Decoding into an int64 can't overflow, even on 32-bit platforms.
+	size := int64(binary.BigEndian.Uint32(prefixes[1:5]))
+	if r.readMaxBytes > 0 && size > int64(r.readMaxBytes) {
+		// Don't read the message at all: the limit is meant to bound memory.
+		return errorf(CodeResourceExhausted, "message size %d exceeds readMaxBytes %d", size, r.readMaxBytes)
+	}
+	if err := r.bufferPool.budget.reserve(size); err != nil {
+		return err
+	}
+	r.reserved = size
+	if err := r.readData(env.Data, size); err != nil {
+		return err
+	}
+	env.Flags = prefixes[0]
+	if env.IsSet(flagEnvelopeChecksum) {
+		// Checksums are optional, so we always verify and strip them. Callers
+		// never see the flag.
+		return verifyEnvelopeChecksum(env)
+	}
+	return nil
+}
+
+// readData reads exactly size bytes of envelope data into dst.
+func (r *envelopeReader) readData(dst *bytes.Buffer, size int64) *Error {
+	if size == 0 {
+		return nil
+	}
+	// Pooled buffers usually have enough capacity already. If not, we don't
+	// trust the prefix enough to allocate a huge buffer before any data
+	// arrives: very large messages grow the buffer as they're read instead.
+	if size <= maxEnvelopePreallocation {
+		dst.Grow(int(size))
+	} else {
+		dst.Grow(maxEnvelopePreallocation)
+	}
+	// At layer 7, we don't know exactly what's happening down in L4. Large
+	// length-prefixed messages may arrive in chunks, so we may need to read
+	// the request body past EOF. We also need to take care that we don't retry
+	// forever if the message is malformed.
+	remaining := size
+	for remaining > 0 {
+		bytesRead, err := io.CopyN(dst, r.reader, remaining)
+		if err != nil && !errors.Is(err, io.EOF) {
+			if connectErr, ok := asError(err); ok {
+				return connectErr
 			}
-			remaining -= bytesRead
+			return errorf(CodeUnknown, "read enveloped message: %w", err)
+		}
+		if errors.Is(err, io.EOF) && bytesRead == 0 {
+			// We've gotten zero-length chunk of data. Message is likely malformed,
+			// don't wait for additional chunks.
+			return errorf(
+				CodeInvalidArgument,
+				"protocol error: promised %d bytes in enveloped message, got %d bytes",
+				size,
+				size-remaining,
+			).withEndReason(StreamEndProtocol)
 		}
+		remaining -= bytesRead
 	}
-	env.Flags = prefixes[0]
 	return nil
 }
 
-func isSizeZeroPrefix(prefix [5]byte) bool {
-	for i := 1; i < 5; i++ {
-		if prefix[i] != 0 {
-			return false
+// lazyMessage holds the raw bytes of a received message so that unmarshaling
+// can be deferred until the message is actually needed. Streams created with
+// WithLazyUnmarshal pass a *lazyMessage to Receive instead of the generated
+// message struct.
+type lazyMessage struct {
+	codec Codec
+	data  []byte
+}
+
+func (m *lazyMessage) capture(codec Codec, data []byte) {
+	m.codec = codec
+	// Reuse the existing slice, since streams reuse their lazyMessage for every
+	// call to Receive.
+	m.data = append(m.data[:0], data...)
+}
+
+// Unmarshal decodes the captured bytes into the supplied message.
+func (m *lazyMessage) Unmarshal(message any) *Error {
+	if m.codec == nil {
+		return errorf(CodeInternal, "unmarshal into %T: no message received", message)
+	}
+	if len(m.data) == 0 {
+		// Empty messages are never passed to codecs, so the zero value is correct.
+		if protoMessage, ok := message.(proto.Message); ok {
+			proto.Reset(protoMessage)
 		}
+		return nil
 	}
-	return true
+	if err := m.codec.Unmarshal(m.data, message); err != nil {
+		return errorf(CodeInvalidArgument, "unmarshal into %T: %w", message, err)
+	}
+	return nil
 }
@@ -0,0 +1,163 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/bufbuild/connect-go/internal/assert"
+	pingv1 "github.com/bufbuild/connect-go/internal/gen/connect/ping/v1"
+)
+
+func TestEnvelopeCompressorReuse(t *testing.T) {
+	t.Parallel()
+	var compressors, decompressors int
+	pool := newCompressionPool(
+		func() Decompressor {
+			decompressors++
+			return &gzip.Reader{}
+		},
+		func() Compressor {
+			compressors++
+			return gzip.NewWriter(io.Discard)
+		},
+	)
+	wire := &bytes.Buffer{}
+	writer := &envelopeWriter{
+		writer:          wire,
+		codec:           &protoBinaryCodec{},
+		compressionPool: pool,
+		bufferPool:      newBufferPool(),
+	}
+	text := strings.Repeat("ping", 32)
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, writer.Marshal(&pingv1.PingRequest{Number: int64(i), Text: text}))
+	}
+	assert.Nil(t, writer.MarshalBatch([]any{&pingv1.PingRequest{Number: 3, Text: text}}))
+	assert.NotNil(t, writer.compressor)
+	writer.release()
+	assert.Nil(t, writer.compressor)
+
+	reader := &envelopeReader{
+		reader:          wire,
+		codec:           &protoBinaryCodec{},
+		compressionPool: pool,
+		bufferPool:      newBufferPool(),
+	}
+	for i := 0; i < 4; i++ {
+		var msg pingv1.PingRequest
+		assert.Nil(t, reader.Unmarshal(&msg))
+		assert.Equal(t, msg.Number, int64(i))
+		assert.Equal(t, msg.Text, text)
+		assert.NotNil(t, reader.decompressor)
+	}
+	err := reader.Unmarshal(&pingv1.PingRequest{})
+	assert.True(t, errors.Is(err, io.EOF))
+	assert.Nil(t, reader.decompressor) // released at the end of the stream
+
+	// The whole stream used just one compressor and one decompressor. (The pool
+	// may drop idle items, so we can't make stronger assertions.)
+	assert.Equal(t, compressors, 1)
+	assert.Equal(t, decompressors, 1)
+}
+
+func TestEnvelopeReaderPartialReads(t *testing.T) {
+	t.Parallel()
+	wire := &bytes.Buffer{}
+	writer := &envelopeWriter{
+		writer:     wire,
+		codec:      &protoBinaryCodec{},
+		bufferPool: newBufferPool(),
+	}
+	messages := []*pingv1.PingRequest{
+		{},
+		{Number: 1},
+		{Number: 2, Text: strings.Repeat("ping", 1024)},
+	}
+	for _, msg := range messages {
+		assert.Nil(t, writer.Marshal(msg))
+	}
+	wireBytes := wire.Bytes()
+	for name, reader := range map[string]io.Reader{
+		"one_byte": iotest.OneByteReader(bytes.NewReader(wireBytes)),
+		"half":     iotest.HalfReader(bytes.NewReader(wireBytes)),
+		"data_err": iotest.DataErrReader(bytes.NewReader(wireBytes)),
+	} {
+		envReader := &envelopeReader{
+			reader:     reader,
+			codec:      &protoBinaryCodec{},
+			bufferPool: newBufferPool(),
+		}
+		for _, want := range messages {
+			var got pingv1.PingRequest
+			assert.Nil(t, envReader.Unmarshal(&got), assert.Sprintf(name))
+			assert.Equal(t, got.Number, want.Number)
+			assert.Equal(t, got.Text, want.Text)
+		}
+		err := envReader.Unmarshal(&pingv1.PingRequest{})
+		assert.True(t, errors.Is(err, io.EOF), assert.Sprintf("%s: %v", name, err))
+	}
+}
+
+func TestEnvelopeReaderBounds(t *testing.T) {
+	t.Parallel()
+	read := func(data []byte) (*envelope, *Error) {
+		reader := &envelopeReader{reader: bytes.NewReader(data), bufferPool: newBufferPool()}
+		env := &envelope{Data: &bytes.Buffer{}}
+		return env, reader.Read(env)
+	}
+	_, err := read([]byte{0, 0, 0})
+	assert.Equal(t, CodeOf(err), CodeInvalidArgument)
+	// A huge size prefix with no data behind it shouldn't allocate the whole
+	// promised size.
+	env, err := read([]byte{0, 0xff, 0xff, 0xff, 0xff, 1, 2, 3})
+	assert.Equal(t, CodeOf(err), CodeInvalidArgument)
+	assert.True(t, env.Data.Cap() <= 2*maxEnvelopePreallocation)
+}
+
+func FuzzEnvelopeReader(f *testing.F) {
+	wire := &bytes.Buffer{}
+	writer := &envelopeWriter{writer: wire, codec: &protoBinaryCodec{}, bufferPool: newBufferPool(), checksum: true}
+	_ = writer.Marshal(&pingv1.PingRequest{Number: 42, Text: "fuzz"})
+	f.Add(wire.Bytes())
+	f.Add([]byte{0, 0, 0, 0, 0})
+	f.Add([]byte{2, 0, 0, 0, 1, 0})
+	f.Add([]byte{0, 0xff, 0xff, 0xff, 0xff})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader := &envelopeReader{reader: iotest.HalfReader(bytes.NewReader(data)), bufferPool: newBufferPool()}
+		consumed := 0
+		for {
+			env := &envelope{Data: &bytes.Buffer{}}
+			if err := reader.Read(env); err != nil {
+				return
+			}
+			size := int(binary.BigEndian.Uint32(data[consumed+1 : consumed+5]))
+			if env.IsSet(flagEnvelopeChecksum) {
+				t.Fatalf("checksum flag wasn't stripped")
+			}
+			if env.Data.Len() != size && env.Data.Len() != size-checksumSize {
+				t.Fatalf("read %d bytes, prefix promised %d", env.Data.Len(), size)
+			}
+			consumed += 5 + size
+		}
+	})
+}
@@ -18,6 +18,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strings"
 
@@ -36,7 +37,9 @@ const commonErrorsURL = "https://connect.build/docs/go/common-errors"
 // Go by the google.golang.org/protobuf/types/known/anypb package. The
 // google.golang.org/genproto/googleapis/rpc/errdetails package contains a
 // variety of Protobuf messages commonly wrapped in anypb.Any and used as error
-// details.
+// details. The most common of them can be built and read without genproto
+// using BadRequest, PreconditionFailure, QuotaFailure, ErrorInfo, and
+// LocalizedMessage.
 type ErrorDetail interface {
 	proto.Message
 
@@ -65,6 +68,13 @@ type Error struct {
 	err     error
 	details []ErrorDetail
 	meta    http.Header
+	// httpStatus is set on clients when the call failed at the HTTP layer,
+	// before any RPC-level error was available.
+	httpStatus int
+	callStats  *CallStats
+	// endReason is set where StreamEndReasonOf can't infer why the stream
+	// ended from the code or cause.
+	endReason StreamEndReason
 }
 
 // NewError annotates any Go error with a status code.
@@ -100,12 +110,20 @@ func (e *Error) Code() Code {
 	return e.code
 }
 
-// Details returns the error's details.
+// Details returns the error's details. Clients decode details sent by gRPC
+// servers (including grpc-go and grpc-java) from the grpc-status-details-bin
+// trailer. Use each detail's UnmarshalTo method to access the typed message,
+// like an errdetails.BadRequest or errdetails.ErrorInfo.
 func (e *Error) Details() []ErrorDetail {
 	return e.details
 }
 
 // AddDetail appends a message to the error's details.
+//
+// Handlers using the gRPC and gRPC-Web protocols send details in the
+// grpc-status-details-bin trailer as a serialized google.rpc.Status, so clients
+// built with other gRPC implementations (and proxies like Envoy) see the full
+// structured error.
 func (e *Error) AddDetail(d ErrorDetail) {
 	e.details = append(e.details, d)
 }
@@ -128,6 +146,32 @@ func (e *Error) Meta() http.Header {
 	return e.meta
 }
 
+// CallStats returns the latency breakdown of the failed client call. It's nil
+// unless the client was constructed with WithCallStats.
+func (e *Error) CallStats() *CallStats {
+	return e.callStats
+}
+
+// HTTPStatus returns the HTTP status code of a failed client call if the error
+// is or wraps a *connect.Error that the client built from a non-200 HTTP
+// response, and zero otherwise. Errors returned by handlers don't have an HTTP
+// status, even if the protocol sends them with one. It's useful for
+// distinguishing, for example, a 503 from a load balancer from a
+// CodeUnavailable error returned by a handler.
+func HTTPStatus(err error) int {
+	if connectErr, ok := asError(err); ok {
+		return connectErr.httpStatus
+	}
+	return 0
+}
+
+// newHTTPStatusError constructs an error for a non-200 HTTP response.
+func newHTTPStatusError(code Code, response *http.Response, underlying error) *Error {
+	err := NewError(code, underlying)
+	err.httpStatus = response.StatusCode
+	return err
+}
+
 func (e *Error) detailsAsAny() ([]*anypb.Any, error) {
 	anys := make([]*anypb.Any, 0, len(e.details))
 	for _, detail := range e.details {
@@ -198,6 +242,32 @@ func wrapIfContextError(err error) error {
 	return err
 }
 
+// wrapIfContextDone applies CodeCanceled or CodeDeadlineExceeded to errors
+// that occur after the context has ended. When a client disconnects, pending
+// reads and writes fail with transport-specific errors (for example, an
+// unexpected EOF or an HTTP/2 stream reset) that don't wrap the context's
+// error, so the context is the only reliable signal. Errors wrapping io.EOF
+// are left unchanged so that streams still end cleanly.
+func wrapIfContextDone(ctx context.Context, err error) error {
+	if err == nil || errors.Is(err, io.EOF) {
+		return err
+	}
+	ctxErr := ctx.Err()
+	if ctxErr == nil {
+		return err
+	}
+	if connectErr, ok := asError(err); ok {
+		if code := connectErr.Code(); code == CodeCanceled || code == CodeDeadlineExceeded {
+			return err
+		}
+	}
+	code := CodeCanceled
+	if errors.Is(ctxErr, context.DeadlineExceeded) {
+		code = CodeDeadlineExceeded
+	}
+	return NewError(code, err)
+}
+
 // wrapIfLikelyWithGRPCNotUsedError adds a wrapping error that has a message
 // telling the caller that they likely need to use h2c but are using a raw http.Client{}.
 //
@@ -220,7 +290,9 @@ func wrapIfLikelyH2CNotConfiguredError(request *http.Request, err error) error {
 	if errString := err.Error(); strings.HasPrefix(errString, `Post "`) &&
 		(strings.Contains(errString, `net/http: HTTP/1.x transport connection broken: malformed HTTP response`) ||
 			strings.HasSuffix(errString, `write: broken pipe`)) {
-		return fmt.Errorf("possible h2c configuration issue when talking to gRPC server, see %s: %w", commonErrorsURL, err)
+		return &http2UnavailableError{
+			fmt.Errorf("possible h2c configuration issue when talking to gRPC server, see %s: %w", commonErrorsURL, err),
+		}
 	}
 	return err
 }
@@ -247,3 +319,22 @@ func wrapIfLikelyWithGRPCNotUsedError(err error) error {
 	}
 	return err
 }
+
+// errHTTP2Unavailable matches (via errors.Is) errors caused by a gRPC call
+// reaching a server or proxy that can't speak HTTP/2. Clients configured with
+// a fallback protocol retry these calls.
+var errHTTP2Unavailable = errors.New("HTTP/2 unavailable")
+
+// http2UnavailableError marks an error as caused by the lack of HTTP/2
+// without changing its message.
+type http2UnavailableError struct {
+	error
+}
+
+func (e *http2UnavailableError) Unwrap() error {
+	return e.error
+}
+
+func (e *http2UnavailableError) Is(target error) bool {
+	return target == errHTTP2Unavailable
+}
@@ -0,0 +1,539 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+const anyTypeURLPrefix = "type.googleapis.com/"
+
+// The standard error details below are wire-compatible with the messages in
+// https://github.com/googleapis/googleapis/blob/master/google/rpc/error_details.proto,
+// so clients and servers using genproto's errdetails package (or any other
+// gRPC implementation) can read them. They're encoded by hand so that this
+// module doesn't depend on genproto or register the google.rpc messages
+// itself, which would conflict with programs that do. The Connect protocol
+// sends details as JSON, so it resolves them with standardDetailTypes when
+// they're not in the global registry.
+
+// standardDetails caches the dynamic types for the standard error details,
+// which are built on first use.
+var standardDetails struct { //nolint:gochecknoglobals
+	once  sync.Once
+	types *protoregistry.Types
+	err   error
+}
+
+// standardDetailTypes returns the dynamic types for the standard error
+// details.
+func standardDetailTypes() (*protoregistry.Types, error) {
+	standardDetails.once.Do(func() {
+		standardDetails.types, standardDetails.err = newStandardDetailTypes()
+	})
+	return standardDetails.types, standardDetails.err
+}
+
+// BadRequest describes violations in a client request, usually sent with
+// CodeInvalidArgument. Build one with NewBadRequest and AddFieldViolation:
+//
+//	err := connect.NewError(connect.CodeInvalidArgument, errors.New("invalid book"))
+//	err.AddDetail(connect.NewBadRequest().
+//	  AddFieldViolation("title", "must not be empty").
+//	  AddFieldViolation("isbn", "must be 13 digits").
+//	  Detail())
+type BadRequest struct {
+	FieldViolations []FieldViolation
+}
+
+// FieldViolation describes a single bad request field.
+type FieldViolation struct {
+	// Field is a path to the offending field, like "book.authors[2].name".
+	Field string
+	// Description explains why the field is invalid.
+	Description string
+}
+
+// NewBadRequest constructs a BadRequest with the supplied violations.
+func NewBadRequest(violations ...FieldViolation) *BadRequest {
+	return &BadRequest{FieldViolations: violations}
+}
+
+// AddFieldViolation appends a violation and returns the BadRequest, so calls
+// can be chained.
+func (b *BadRequest) AddFieldViolation(field, description string) *BadRequest {
+	b.FieldViolations = append(b.FieldViolations, FieldViolation{Field: field, Description: description})
+	return b
+}
+
+// Detail encodes the BadRequest as a google.rpc.BadRequest error detail.
+func (b *BadRequest) Detail() ErrorDetail {
+	var data []byte
+	for _, violation := range b.FieldViolations {
+		var inner []byte
+		inner = appendStringField(inner, 1, violation.Field)
+		inner = appendStringField(inner, 2, violation.Description)
+		data = appendMessageField(data, 1, inner)
+	}
+	return newStandardDetail("google.rpc.BadRequest", data)
+}
+
+// PreconditionFailure describes why the system isn't in a state required for
+// the operation, usually sent with CodeFailedPrecondition.
+type PreconditionFailure struct {
+	Violations []PreconditionViolation
+}
+
+// PreconditionViolation describes a single precondition failure.
+type PreconditionViolation struct {
+	// Type is a service-specific category, like "TOS" for a terms of service
+	// violation.
+	Type string
+	// Subject identifies what failed the precondition, relative to Type.
+	Subject string
+	// Description explains how the precondition failed.
+	Description string
+}
+
+// NewPreconditionFailure constructs a PreconditionFailure with the supplied
+// violations.
+func NewPreconditionFailure(violations ...PreconditionViolation) *PreconditionFailure {
+	return &PreconditionFailure{Violations: violations}
+}
+
+// AddViolation appends a violation and returns the PreconditionFailure, so
+// calls can be chained.
+func (p *PreconditionFailure) AddViolation(typ, subject, description string) *PreconditionFailure {
+	p.Violations = append(p.Violations, PreconditionViolation{
+		Type:        typ,
+		Subject:     subject,
+		Description: description,
+	})
+	return p
+}
+
+// Detail encodes the PreconditionFailure as a google.rpc.PreconditionFailure
+// error detail.
+func (p *PreconditionFailure) Detail() ErrorDetail {
+	var data []byte
+	for _, violation := range p.Violations {
+		var inner []byte
+		inner = appendStringField(inner, 1, violation.Type)
+		inner = appendStringField(inner, 2, violation.Subject)
+		inner = appendStringField(inner, 3, violation.Description)
+		data = appendMessageField(data, 1, inner)
+	}
+	return newStandardDetail("google.rpc.PreconditionFailure", data)
+}
+
+// QuotaFailure describes how a quota check failed, usually sent with
+// CodeResourceExhausted.
+type QuotaFailure struct {
+	Violations []QuotaViolation
+}
+
+// QuotaViolation describes a single quota violation.
+type QuotaViolation struct {
+	// Subject is the subject of the quota check, like "project:acme" or
+	// "clientip:203.0.113.7".
+	Subject string
+	// Description explains how the quota was exceeded.
+	Description string
+}
+
+// NewQuotaFailure constructs a QuotaFailure with the supplied violations.
+func NewQuotaFailure(violations ...QuotaViolation) *QuotaFailure {
+	return &QuotaFailure{Violations: violations}
+}
+
+// AddViolation appends a violation and returns the QuotaFailure, so calls can
+// be chained.
+func (q *QuotaFailure) AddViolation(subject, description string) *QuotaFailure {
+	q.Violations = append(q.Violations, QuotaViolation{Subject: subject, Description: description})
+	return q
+}
+
+// Detail encodes the QuotaFailure as a google.rpc.QuotaFailure error detail.
+func (q *QuotaFailure) Detail() ErrorDetail {
+	var data []byte
+	for _, violation := range q.Violations {
+		var inner []byte
+		inner = appendStringField(inner, 1, violation.Subject)
+		inner = appendStringField(inner, 2, violation.Description)
+		data = appendMessageField(data, 1, inner)
+	}
+	return newStandardDetail("google.rpc.QuotaFailure", data)
+}
+
+// ErrorInfo describes the cause of an error in a machine-readable way.
+type ErrorInfo struct {
+	// Reason is a short UPPER_SNAKE_CASE identifier for the cause of the
+	// error, unique within Domain.
+	Reason string
+	// Domain is the logical grouping Reason belongs to, usually the service's
+	// name, like "pubsub.googleapis.com".
+	Domain string
+	// Metadata holds additional structured details.
+	Metadata map[string]string
+}
+
+// Detail encodes the ErrorInfo as a google.rpc.ErrorInfo error detail.
+func (e *ErrorInfo) Detail() ErrorDetail {
+	var data []byte
+	data = appendStringField(data, 1, e.Reason)
+	data = appendStringField(data, 2, e.Domain)
+	// Sort map entries so the encoding is deterministic.
+	keys := make([]string, 0, len(e.Metadata))
+	for key := range e.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var entry []byte
+		entry = appendStringField(entry, 1, key)
+		entry = appendStringField(entry, 2, e.Metadata[key])
+		data = appendMessageField(data, 3, entry)
+	}
+	return newStandardDetail("google.rpc.ErrorInfo", data)
+}
+
+// LocalizedMessage is an error message that's safe to show to end users.
+type LocalizedMessage struct {
+	// Locale is a BCP 47 language tag, like "en-US" or "fr-CH".
+	Locale string
+	// Message is the localized error message.
+	Message string
+}
+
+// Detail encodes the LocalizedMessage as a google.rpc.LocalizedMessage error
+// detail.
+func (l *LocalizedMessage) Detail() ErrorDetail {
+	var data []byte
+	data = appendStringField(data, 1, l.Locale)
+	data = appendStringField(data, 2, l.Message)
+	return newStandardDetail("google.rpc.LocalizedMessage", data)
+}
+
+// BadRequestDetail returns the first google.rpc.BadRequest detail attached to
+// err, which may be wrapped.
+func BadRequestDetail(err error) (*BadRequest, bool) {
+	data, ok := findStandardDetail(err, "google.rpc.BadRequest")
+	if !ok {
+		return nil, false
+	}
+	badRequest := &BadRequest{}
+	ok = rangeBytesFields(data, func(number protowire.Number, value []byte) bool {
+		if number != 1 {
+			return true
+		}
+		var violation FieldViolation
+		ok := rangeBytesFields(value, func(number protowire.Number, value []byte) bool {
+			switch number {
+			case 1:
+				violation.Field = string(value)
+			case 2:
+				violation.Description = string(value)
+			}
+			return true
+		})
+		badRequest.FieldViolations = append(badRequest.FieldViolations, violation)
+		return ok
+	})
+	if !ok {
+		return nil, false
+	}
+	return badRequest, true
+}
+
+// PreconditionFailureDetail returns the first google.rpc.PreconditionFailure
+// detail attached to err, which may be wrapped.
+func PreconditionFailureDetail(err error) (*PreconditionFailure, bool) {
+	data, ok := findStandardDetail(err, "google.rpc.PreconditionFailure")
+	if !ok {
+		return nil, false
+	}
+	failure := &PreconditionFailure{}
+	ok = rangeBytesFields(data, func(number protowire.Number, value []byte) bool {
+		if number != 1 {
+			return true
+		}
+		var violation PreconditionViolation
+		ok := rangeBytesFields(value, func(number protowire.Number, value []byte) bool {
+			switch number {
+			case 1:
+				violation.Type = string(value)
+			case 2:
+				violation.Subject = string(value)
+			case 3:
+				violation.Description = string(value)
+			}
+			return true
+		})
+		failure.Violations = append(failure.Violations, violation)
+		return ok
+	})
+	if !ok {
+		return nil, false
+	}
+	return failure, true
+}
+
+// QuotaFailureDetail returns the first google.rpc.QuotaFailure detail
+// attached to err, which may be wrapped.
+func QuotaFailureDetail(err error) (*QuotaFailure, bool) {
+	data, ok := findStandardDetail(err, "google.rpc.QuotaFailure")
+	if !ok {
+		return nil, false
+	}
+	failure := &QuotaFailure{}
+	ok = rangeBytesFields(data, func(number protowire.Number, value []byte) bool {
+		if number != 1 {
+			return true
+		}
+		var violation QuotaViolation
+		ok := rangeBytesFields(value, func(number protowire.Number, value []byte) bool {
+			switch number {
+			case 1:
+				violation.Subject = string(value)
+			case 2:
+				violation.Description = string(value)
+			}
+			return true
+		})
+		failure.Violations = append(failure.Violations, violation)
+		return ok
+	})
+	if !ok {
+		return nil, false
+	}
+	return failure, true
+}
+
+// ErrorInfoDetail returns the first google.rpc.ErrorInfo detail attached to
+// err, which may be wrapped.
+func ErrorInfoDetail(err error) (*ErrorInfo, bool) {
+	data, ok := findStandardDetail(err, "google.rpc.ErrorInfo")
+	if !ok {
+		return nil, false
+	}
+	info := &ErrorInfo{}
+	ok = rangeBytesFields(data, func(number protowire.Number, value []byte) bool {
+		switch number {
+		case 1:
+			info.Reason = string(value)
+		case 2:
+			info.Domain = string(value)
+		case 3:
+			var key, entry string
+			ok := rangeBytesFields(value, func(number protowire.Number, value []byte) bool {
+				switch number {
+				case 1:
+					key = string(value)
+				case 2:
+					entry = string(value)
+				}
+				return true
+			})
+			if info.Metadata == nil {
+				info.Metadata = make(map[string]string)
+			}
+			info.Metadata[key] = entry
+			return ok
+		}
+		return true
+	})
+	if !ok {
+		return nil, false
+	}
+	return info, true
+}
+
+// LocalizedMessageDetail returns the first google.rpc.LocalizedMessage detail
+// attached to err, which may be wrapped.
+func LocalizedMessageDetail(err error) (*LocalizedMessage, bool) {
+	data, ok := findStandardDetail(err, "google.rpc.LocalizedMessage")
+	if !ok {
+		return nil, false
+	}
+	message := &LocalizedMessage{}
+	ok = rangeBytesFields(data, func(number protowire.Number, value []byte) bool {
+		switch number {
+		case 1:
+			message.Locale = string(value)
+		case 2:
+			message.Message = string(value)
+		}
+		return true
+	})
+	if !ok {
+		return nil, false
+	}
+	return message, true
+}
+
+// newStandardDetailTypes builds the types of the standard error details from
+// a copy of the messages' definitions in google/rpc/error_details.proto.
+func newStandardDetailTypes() (*protoregistry.Types, error) {
+	field := func(name string, number int32, typeName string) *descriptorpb.FieldDescriptorProto {
+		field := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:   descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+		}
+		if typeName != "" {
+			field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			field.TypeName = proto.String(typeName)
+		}
+		return field
+	}
+	message := func(name string, fields ...*descriptorpb.FieldDescriptorProto) *descriptorpb.DescriptorProto {
+		return &descriptorpb.DescriptorProto{Name: proto.String(name), Field: fields}
+	}
+	badRequest := message("BadRequest", field("field_violations", 1, ".google.rpc.BadRequest.FieldViolation"))
+	badRequest.NestedType = append(badRequest.NestedType, message(
+		"FieldViolation",
+		field("field", 1, ""),
+		field("description", 2, ""),
+	))
+	preconditionFailure := message(
+		"PreconditionFailure",
+		field("violations", 1, ".google.rpc.PreconditionFailure.Violation"),
+	)
+	preconditionFailure.NestedType = append(preconditionFailure.NestedType, message(
+		"Violation",
+		field("type", 1, ""),
+		field("subject", 2, ""),
+		field("description", 3, ""),
+	))
+	quotaFailure := message("QuotaFailure", field("violations", 1, ".google.rpc.QuotaFailure.Violation"))
+	quotaFailure.NestedType = append(quotaFailure.NestedType, message(
+		"Violation",
+		field("subject", 1, ""),
+		field("description", 2, ""),
+	))
+	errorInfo := message(
+		"ErrorInfo",
+		field("reason", 1, ""),
+		field("domain", 2, ""),
+		field("metadata", 3, ".google.rpc.ErrorInfo.MetadataEntry"),
+	)
+	metadataEntry := message("MetadataEntry", field("key", 1, ""), field("value", 2, ""))
+	metadataEntry.Options = &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)}
+	errorInfo.NestedType = append(errorInfo.NestedType, metadataEntry)
+	localizedMessage := message("LocalizedMessage", field("locale", 1, ""), field("message", 2, ""))
+
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("google/rpc/error_details.proto"),
+		Package: proto.String("google.rpc"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			badRequest,
+			preconditionFailure,
+			quotaFailure,
+			errorInfo,
+			localizedMessage,
+		},
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid error detail descriptors: %w", err)
+	}
+	types := new(protoregistry.Types)
+	messages := file.Messages()
+	for i := 0; i < messages.Len(); i++ {
+		if err := types.RegisterMessage(dynamicpb.NewMessageType(messages.Get(i))); err != nil {
+			return nil, fmt.Errorf("register error detail: %w", err)
+		}
+	}
+	return types, nil
+}
+
+func newStandardDetail(name protoreflect.FullName, data []byte) ErrorDetail {
+	return &anypb.Any{TypeUrl: anyTypeURLPrefix + string(name), Value: data}
+}
+
+// findStandardDetail returns the serialized value of the first detail with
+// the supplied message name.
+func findStandardDetail(err error, name protoreflect.FullName) ([]byte, bool) {
+	var connectErr *Error
+	if !errors.As(err, &connectErr) {
+		return nil, false
+	}
+	for _, detail := range connectErr.Details() {
+		if detail.MessageName() != name {
+			continue
+		}
+		if valuer, ok := detail.(interface{ GetValue() []byte }); ok {
+			return valuer.GetValue(), true
+		}
+	}
+	return nil, false
+}
+
+func appendStringField(data []byte, number protowire.Number, value string) []byte {
+	if value == "" {
+		return data
+	}
+	data = protowire.AppendTag(data, number, protowire.BytesType)
+	return protowire.AppendString(data, value)
+}
+
+func appendMessageField(data []byte, number protowire.Number, value []byte) []byte {
+	data = protowire.AppendTag(data, number, protowire.BytesType)
+	return protowire.AppendBytes(data, value)
+}
+
+// rangeBytesFields calls visit for each length-delimited field in a
+// serialized message, skipping fields of other types. It returns false if the
+// message is malformed or visit returns false.
+func rangeBytesFields(data []byte, visit func(protowire.Number, []byte) bool) bool {
+	for len(data) > 0 {
+		number, wireType, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return false
+		}
+		data = data[n:]
+		if wireType != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(number, wireType, data)
+			if n < 0 {
+				return false
+			}
+			data = data[n:]
+			continue
+		}
+		value, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return false
+		}
+		data = data[n:]
+		if !visit(number, value) {
+			return false
+		}
+	}
+	return true
+}
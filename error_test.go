@@ -15,13 +15,20 @@
 package connect
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/bufbuild/connect-go/internal/assert"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/types/known/anypb"
 	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/emptypb"
@@ -87,6 +94,247 @@ func TestErrorDetails(t *testing.T) {
 	assert.Equal(t, connectErr.Details(), []ErrorDetail{detail})
 }
 
+func TestStandardErrorDetails(t *testing.T) {
+	t.Parallel()
+	connectErr := NewError(CodeInvalidArgument, errors.New("invalid book"))
+	connectErr.AddDetail(NewBadRequest().
+		AddFieldViolation("title", "must not be empty").
+		AddFieldViolation("isbn", "").
+		Detail())
+	connectErr.AddDetail(NewPreconditionFailure().AddViolation("TOS", "user:1", "terms not accepted").Detail())
+	connectErr.AddDetail(NewQuotaFailure().AddViolation("project:acme", "out of reads").Detail())
+	connectErr.AddDetail((&ErrorInfo{
+		Reason:   "BOOK_INVALID",
+		Domain:   "library.acme.com",
+		Metadata: map[string]string{"shelf": "3", "empty": ""},
+	}).Detail())
+	connectErr.AddDetail((&LocalizedMessage{Locale: "en", Message: "hi"}).Detail())
+	err := fmt.Errorf("wrapped: %w", connectErr)
+
+	assert.Equal(t, connectErr.Details()[0].MessageName(), "google.rpc.BadRequest")
+	badRequest, ok := BadRequestDetail(err)
+	assert.True(t, ok)
+	assert.Equal(t, badRequest, NewBadRequest(
+		FieldViolation{Field: "title", Description: "must not be empty"},
+		FieldViolation{Field: "isbn"},
+	))
+	precondition, ok := PreconditionFailureDetail(err)
+	assert.True(t, ok)
+	assert.Equal(t, precondition.Violations, []PreconditionViolation{
+		{Type: "TOS", Subject: "user:1", Description: "terms not accepted"},
+	})
+	quota, ok := QuotaFailureDetail(err)
+	assert.True(t, ok)
+	assert.Equal(t, quota.Violations, []QuotaViolation{{Subject: "project:acme", Description: "out of reads"}})
+	info, ok := ErrorInfoDetail(err)
+	assert.True(t, ok)
+	assert.Equal(t, info, &ErrorInfo{
+		Reason:   "BOOK_INVALID",
+		Domain:   "library.acme.com",
+		Metadata: map[string]string{"shelf": "3", "empty": ""},
+	})
+	message, ok := LocalizedMessageDetail(err)
+	assert.True(t, ok)
+	assert.Equal(t, message, &LocalizedMessage{Locale: "en", Message: "hi"})
+	// The encoding matches protoc's for google.rpc.LocalizedMessage.
+	encoded, ok := connectErr.Details()[4].(*anypb.Any)
+	assert.True(t, ok)
+	assert.Equal(t, encoded.GetValue(), []byte{0x0a, 0x02, 'e', 'n', 0x12, 0x02, 'h', 'i'})
+
+	_, ok = BadRequestDetail(NewError(CodeInvalidArgument, nil))
+	assert.False(t, ok)
+	_, ok = BadRequestDetail(errors.New("plain"))
+	assert.False(t, ok)
+	malformed := NewError(CodeInvalidArgument, nil)
+	malformed.AddDetail(&anypb.Any{TypeUrl: "type.googleapis.com/google.rpc.ErrorInfo", Value: []byte{0x0a, 0x05}})
+	_, ok = ErrorInfoDetail(malformed)
+	assert.False(t, ok)
+}
+
+func TestStandardErrorDetailsCanonical(t *testing.T) {
+	t.Parallel()
+	// The expected encodings were produced by marshaling genproto's errdetails
+	// messages with deterministic Protobuf and protojson.
+	tests := []struct {
+		name   string
+		detail ErrorDetail
+		parse  func(error) (any, bool)
+		want   any
+		binary string
+		json   string
+	}{
+		{
+			name: "google.rpc.BadRequest",
+			detail: NewBadRequest().
+				AddFieldViolation("title", "empty").
+				AddFieldViolation("isbn", "").
+				Detail(),
+			parse: func(err error) (any, bool) { return BadRequestDetail(err) },
+			want: NewBadRequest(
+				FieldViolation{Field: "title", Description: "empty"},
+				FieldViolation{Field: "isbn"},
+			),
+			binary: "\n\x0e\n\x05title\x12\x05empty\n\x06\n\x04isbn",
+			json:   `{"fieldViolations":[{"field":"title","description":"empty"},{"field":"isbn"}]}`,
+		},
+		{
+			name:   "google.rpc.PreconditionFailure",
+			detail: NewPreconditionFailure().AddViolation("TOS", "user:1", "no").Detail(),
+			parse:  func(err error) (any, bool) { return PreconditionFailureDetail(err) },
+			want: NewPreconditionFailure(
+				PreconditionViolation{Type: "TOS", Subject: "user:1", Description: "no"},
+			),
+			binary: "\n\x11\n\x03TOS\x12\x06user:1\x1a\x02no",
+			json:   `{"violations":[{"type":"TOS","subject":"user:1","description":"no"}]}`,
+		},
+		{
+			name:   "google.rpc.QuotaFailure",
+			detail: NewQuotaFailure().AddViolation("project:a", "out").Detail(),
+			parse:  func(err error) (any, bool) { return QuotaFailureDetail(err) },
+			want:   NewQuotaFailure(QuotaViolation{Subject: "project:a", Description: "out"}),
+			binary: "\n\x10\n\tproject:a\x12\x03out",
+			json:   `{"violations":[{"subject":"project:a","description":"out"}]}`,
+		},
+		{
+			name: "google.rpc.ErrorInfo",
+			detail: (&ErrorInfo{
+				Reason:   "BAD",
+				Domain:   "acme.com",
+				Metadata: map[string]string{"b": "2", "a": "1"},
+			}).Detail(),
+			parse: func(err error) (any, bool) { return ErrorInfoDetail(err) },
+			want: &ErrorInfo{
+				Reason:   "BAD",
+				Domain:   "acme.com",
+				Metadata: map[string]string{"a": "1", "b": "2"},
+			},
+			binary: "\n\x03BAD\x12\x08acme.com\x1a\x06\n\x01a\x12\x011\x1a\x06\n\x01b\x12\x012",
+			json:   `{"reason":"BAD","domain":"acme.com","metadata":{"a":"1","b":"2"}}`,
+		},
+		{
+			name:   "google.rpc.LocalizedMessage",
+			detail: (&LocalizedMessage{Locale: "en", Message: "hi"}).Detail(),
+			parse:  func(err error) (any, bool) { return LocalizedMessageDetail(err) },
+			want:   &LocalizedMessage{Locale: "en", Message: "hi"},
+			binary: "\n\x02en\x12\x02hi",
+			json:   `{"locale":"en","message":"hi"}`,
+		},
+	}
+	types, err := standardDetailTypes()
+	assert.Nil(t, err)
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			// Encoding.
+			encoded, ok := test.detail.(*anypb.Any)
+			assert.True(t, ok)
+			assert.Equal(t, encoded.GetTypeUrl(), anyTypeURLPrefix+test.name)
+			assert.Equal(t, encoded.GetValue(), []byte(test.binary))
+			// Decoding.
+			connectErr := NewError(CodeUnknown, nil)
+			connectErr.AddDetail(&anypb.Any{TypeUrl: anyTypeURLPrefix + test.name, Value: []byte(test.binary)})
+			got, ok := test.parse(connectErr)
+			assert.True(t, ok)
+			assert.Equal(t, got, test.want)
+			// The dynamic types used for JSON match the canonical descriptors.
+			messageType, err := types.FindMessageByName(protoreflect.FullName(test.name))
+			assert.Nil(t, err)
+			message := messageType.New().Interface()
+			assert.Nil(t, proto.Unmarshal([]byte(test.binary), message))
+			remarshaled, err := proto.MarshalOptions{Deterministic: true}.Marshal(message)
+			assert.Nil(t, err)
+			assert.Equal(t, remarshaled, []byte(test.binary))
+			jsonData, err := protojson.Marshal(message)
+			assert.Nil(t, err)
+			var gotJSON, wantJSON any
+			assert.Nil(t, json.Unmarshal(jsonData, &gotJSON))
+			assert.Nil(t, json.Unmarshal([]byte(test.json), &wantJSON))
+			assert.Equal(t, gotJSON, wantJSON)
+			fromJSON := messageType.New().Interface()
+			assert.Nil(t, protojson.Unmarshal([]byte(test.json), fromJSON))
+			assert.True(t, proto.Equal(fromJSON, message))
+		})
+	}
+}
+
+func TestStandardErrorDetailsRoundTrip(t *testing.T) {
+	t.Parallel()
+	newError := func() *Error {
+		err := NewError(CodeInvalidArgument, errors.New("invalid book"))
+		err.AddDetail(NewBadRequest().AddFieldViolation("title", "must not be empty").Detail())
+		err.AddDetail((&ErrorInfo{
+			Reason:   "BOOK_INVALID",
+			Domain:   "library.acme.com",
+			Metadata: map[string]string{"shelf": "3"},
+		}).Detail())
+		return err
+	}
+	const unary, stream = "/connect.test.v1.BookService/Add", "/connect.test.v1.BookService/List"
+	mux := http.NewServeMux()
+	mux.Handle(unary, NewUnaryHandler(
+		unary,
+		func(context.Context, *Request[emptypb.Empty]) (*Response[emptypb.Empty], error) {
+			return nil, newError()
+		},
+	))
+	mux.Handle(stream, NewServerStreamHandler(
+		stream,
+		func(context.Context, *Request[emptypb.Empty], *ServerStream[emptypb.Empty]) error {
+			return newError()
+		},
+	))
+	server := httptest.NewUnstartedServer(mux)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	assertDetails := func(tb testing.TB, err error) {
+		tb.Helper()
+		assert.Equal(tb, CodeOf(err), CodeInvalidArgument)
+		connectErr, ok := asError(err)
+		assert.True(tb, ok)
+		if ok {
+			assert.Equal(tb, connectErr.Message(), "invalid book")
+		}
+		badRequest, ok := BadRequestDetail(err)
+		assert.True(tb, ok)
+		if ok {
+			assert.Equal(tb, badRequest.FieldViolations, []FieldViolation{
+				{Field: "title", Description: "must not be empty"},
+			})
+		}
+		info, ok := ErrorInfoDetail(err)
+		assert.True(tb, ok)
+		assert.Equal(tb, info, &ErrorInfo{
+			Reason:   "BOOK_INVALID",
+			Domain:   "library.acme.com",
+			Metadata: map[string]string{"shelf": "3"},
+		})
+	}
+	for _, protocol := range []string{ProtocolConnect, ProtocolGRPC, ProtocolGRPCWeb} {
+		protocol := protocol
+		t.Run(protocol, func(t *testing.T) {
+			t.Parallel()
+			client := NewClient[emptypb.Empty, emptypb.Empty](
+				server.Client(),
+				server.URL+unary,
+				WithProtocol(protocol),
+			)
+			_, err := client.CallUnary(context.Background(), NewRequest(&emptypb.Empty{}))
+			assertDetails(t, err)
+		})
+	}
+	t.Run("connect_stream", func(t *testing.T) {
+		t.Parallel()
+		client := NewClient[emptypb.Empty, emptypb.Empty](server.Client(), server.URL+stream)
+		responses, err := client.CallServerStream(context.Background(), NewRequest(&emptypb.Empty{}))
+		assert.Nil(t, err)
+		assert.False(t, responses.Receive())
+		assertDetails(t, responses.Err())
+		assert.Nil(t, responses.Close())
+	})
+}
+
 func TestErrorIs(t *testing.T) {
 	t.Parallel()
 	// errors.New and fmt.Errorf return *errors.errorString. errors.Is
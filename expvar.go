@@ -0,0 +1,159 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"io"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// WithExpvarMetrics publishes per-procedure counters to an expvar.Map, so
+// that basic metrics are available from the standard library's /debug/vars
+// endpoint without any external collectors. For each procedure, the map
+// contains a nested map with these keys:
+//
+//   - "requests": the number of calls or streams started.
+//   - "errors": a map from error code (for example, "not_found") to count.
+//   - "bytes_sent" and "bytes_received": the size of the Protobuf messages sent
+//     and received, before compression and framing. Messages that aren't
+//     Protobuf messages aren't counted.
+//
+// Use separate maps for clients and handlers. Since expvar.NewMap panics if
+// called twice with the same name, create the map once and share it:
+//
+//	var handlerMetrics = expvar.NewMap("connect_handlers")
+//
+//	pingv1connect.NewPingServiceHandler(
+//	  &pingServer{},
+//	  connect.WithExpvarMetrics(handlerMetrics),
+//	)
+func WithExpvarMetrics(metrics *expvar.Map) Option {
+	return WithInterceptors(&expvarInterceptor{metrics: metrics})
+}
+
+type expvarInterceptor struct {
+	metrics *expvar.Map
+	mu      sync.Mutex // serializes creation of per-procedure maps
+}
+
+func (i *expvarInterceptor) WrapUnary(next UnaryFunc) UnaryFunc {
+	return func(ctx context.Context, request AnyRequest) (AnyResponse, error) {
+		counters := i.procedure(request.Spec().Procedure)
+		counters.Add("requests", 1)
+		sentKey, receivedKey := "bytes_received", "bytes_sent"
+		if request.Spec().IsClient {
+			sentKey, receivedKey = receivedKey, sentKey
+		}
+		counters.Add(receivedKey, messageSize(request.Any()))
+		response, err := next(ctx, request)
+		if err != nil {
+			expvarError(counters, err)
+			return response, err
+		}
+		counters.Add(sentKey, messageSize(response.Any()))
+		return response, nil
+	}
+}
+
+func (i *expvarInterceptor) WrapStreamContext(ctx context.Context) context.Context {
+	return ctx
+}
+
+func (i *expvarInterceptor) WrapStreamSender(_ context.Context, sender Sender) Sender {
+	counters := i.procedure(sender.Spec().Procedure)
+	counters.Add("requests", 1)
+	return &expvarSender{Sender: sender, counters: counters}
+}
+
+func (i *expvarInterceptor) WrapStreamReceiver(_ context.Context, receiver Receiver) Receiver {
+	counters := i.procedure(receiver.Spec().Procedure)
+	return &expvarReceiver{Receiver: receiver, counters: counters}
+}
+
+func (i *expvarInterceptor) procedure(name string) *expvar.Map {
+	if counters, ok := i.metrics.Get(name).(*expvar.Map); ok {
+		return counters
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if counters, ok := i.metrics.Get(name).(*expvar.Map); ok {
+		return counters
+	}
+	counters := new(expvar.Map).Init()
+	counters.Set("errors", new(expvar.Map).Init())
+	i.metrics.Set(name, counters)
+	return counters
+}
+
+type expvarSender struct {
+	Sender
+
+	counters *expvar.Map
+}
+
+func (s *expvarSender) Send(message any) error {
+	if err := s.Sender.Send(message); err != nil {
+		return err
+	}
+	s.counters.Add("bytes_sent", messageSize(message))
+	return nil
+}
+
+func (s *expvarSender) Close(err error) error {
+	// Handlers close the Sender with the RPC's final error.
+	if err != nil && !s.Spec().IsClient {
+		expvarError(s.counters, err)
+	}
+	return s.Sender.Close(err)
+}
+
+type expvarReceiver struct {
+	Receiver
+
+	counters *expvar.Map
+	failed   bool
+}
+
+func (r *expvarReceiver) Receive(message any) error {
+	err := r.Receiver.Receive(message)
+	if err == nil {
+		r.counters.Add("bytes_received", messageSize(message))
+		return nil
+	}
+	// Clients learn the RPC's final error from the Receiver.
+	if r.Spec().IsClient && !r.failed && !errors.Is(err, io.EOF) {
+		r.failed = true
+		expvarError(r.counters, err)
+	}
+	return err
+}
+
+func expvarError(counters *expvar.Map, err error) {
+	if errs, ok := counters.Get("errors").(*expvar.Map); ok {
+		errs.Add(CodeOf(err).String(), 1)
+	}
+}
+
+func messageSize(message any) int64 {
+	if protoMessage, ok := message.(proto.Message); ok {
+		return int64(proto.Size(protoMessage))
+	}
+	return 0
+}
@@ -0,0 +1,266 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultFailoverProbeInterval    = 5 * time.Second
+	defaultFailoverFailureThreshold = 3
+)
+
+// FailoverClientConfig configures a FailoverClient.
+type FailoverClientConfig struct {
+	// Targets are the base URLs of the endpoints, in order of preference, like
+	// "https://foo.us-east-1.acme.internal" followed by
+	// "https://foo.us-west-2.acme.internal".
+	Targets []string
+	// HTTPClient sends the requests and the default probes. It defaults to
+	// http.DefaultClient.
+	HTTPClient HTTPClient
+	// FailureThreshold is the number of consecutive failed requests after
+	// which a target is considered unhealthy. A request fails if it can't be
+	// sent or its response has an HTTP status of 502, 503, or 504. It defaults
+	// to 3.
+	FailureThreshold int
+	// ProbeInterval is how often unhealthy targets are probed. It's also the
+	// timeout for each probe. It defaults to 5 seconds.
+	ProbeInterval time.Duration
+	// Probe checks whether an unhealthy target has recovered, returning nil if
+	// it has. It's called with the target's base URL, exactly as it appears in
+	// Targets. By default, targets are probed with a HEAD request to the base
+	// URL, and are healthy if it succeeds with an HTTP status other than 502,
+	// 503, or 504.
+	Probe func(ctx context.Context, target string) error
+	// Clock schedules probes. It defaults to the system clock.
+	Clock Clock
+}
+
+// FailoverClient is an HTTPClient that sends each request to the first
+// healthy target in an ordered list, so clients survive the outage of a
+// primary endpoint (like a regional deployment) without an external load
+// balancer:
+//
+//	failover, err := connect.NewFailoverClient(connect.FailoverClientConfig{
+//	  Targets: []string{
+//	    "https://foo.us-east-1.acme.internal",
+//	    "https://foo.us-west-2.acme.internal",
+//	  },
+//	})
+//	defer failover.Close()
+//	client := foov1connect.NewFooServiceClient(failover, "https://foo.acme.internal")
+//
+// Like TrafficSplitter, it replaces the scheme and host of each request's
+// URL. Targets that fail too many requests in a row are marked unhealthy and
+// probed in the background; once a probe succeeds, the target is healthy
+// again and traffic fails back to it if it's preferred. If every target is
+// unhealthy, requests go to the most preferred one.
+//
+// A FailoverClient doesn't resend failed requests: their bodies may have been
+// consumed, and they may not be safe to repeat. Use it with a retry policy to
+// retry idempotent calls. A FailoverClient is safe to use concurrently.
+type FailoverClient struct {
+	httpClient HTTPClient
+	threshold  int32
+	interval   time.Duration
+	probe      func(context.Context, string) error
+	clock      Clock
+	targets    []*failoverTarget
+
+	ctx    context.Context //nolint:containedctx // canceled by Close
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	closed bool
+	stops  map[*failoverTarget]func() bool
+}
+
+type failoverTarget struct {
+	raw       string
+	url       *url.URL
+	unhealthy int32 // atomic, 1 while awaiting a successful probe
+	failures  int32 // atomic, consecutive failed requests
+}
+
+// NewFailoverClient constructs a FailoverClient. It returns an error if there
+// are no targets or a target URL is invalid.
+func NewFailoverClient(config FailoverClientConfig) (*FailoverClient, error) {
+	if len(config.Targets) == 0 {
+		return nil, errors.New("failover client has no targets")
+	}
+	if config.FailureThreshold < 0 {
+		return nil, fmt.Errorf("negative failure threshold %d", config.FailureThreshold)
+	}
+	if config.ProbeInterval < 0 {
+		return nil, fmt.Errorf("negative probe interval %v", config.ProbeInterval)
+	}
+	failover := &FailoverClient{
+		httpClient: config.HTTPClient,
+		threshold:  int32(config.FailureThreshold),
+		interval:   config.ProbeInterval,
+		probe:      config.Probe,
+		clock:      config.Clock,
+		targets:    make([]*failoverTarget, 0, len(config.Targets)),
+		stops:      make(map[*failoverTarget]func() bool),
+	}
+	if failover.httpClient == nil {
+		failover.httpClient = http.DefaultClient
+	}
+	if failover.threshold == 0 {
+		failover.threshold = defaultFailoverFailureThreshold
+	}
+	if failover.interval == 0 {
+		failover.interval = defaultFailoverProbeInterval
+	}
+	if failover.probe == nil {
+		failover.probe = failover.defaultProbe
+	}
+	if failover.clock == nil {
+		failover.clock = systemClock{}
+	}
+	for _, target := range config.Targets {
+		parsed, err := parseTarget(target)
+		if err != nil {
+			return nil, err
+		}
+		failover.targets = append(failover.targets, &failoverTarget{raw: target, url: parsed})
+	}
+	failover.ctx, failover.cancel = context.WithCancel(context.Background())
+	return failover, nil
+}
+
+// Active returns the target that new requests are sent to.
+func (f *FailoverClient) Active() string {
+	return f.pick().raw
+}
+
+// Healthy reports whether each target is currently healthy, keyed by target
+// URL as it appears in the config.
+func (f *FailoverClient) Healthy() map[string]bool {
+	healthy := make(map[string]bool, len(f.targets))
+	for _, target := range f.targets {
+		healthy[target.raw] = atomic.LoadInt32(&target.unhealthy) == 0
+	}
+	return healthy
+}
+
+// Close stops probing unhealthy targets. Requests can still be sent, but
+// targets marked unhealthy afterwards stay unhealthy.
+func (f *FailoverClient) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	for target, stop := range f.stops {
+		stop()
+		delete(f.stops, target)
+	}
+	f.cancel()
+}
+
+// Do implements HTTPClient.
+func (f *FailoverClient) Do(request *http.Request) (*http.Response, error) {
+	target := f.pick()
+	response, err := f.httpClient.Do(retarget(request, target.url))
+	switch {
+	case err != nil && request.Context().Err() == nil:
+		f.failed(target)
+	case err == nil && isGatewayFailure(response.StatusCode):
+		f.failed(target)
+	case err == nil:
+		atomic.StoreInt32(&target.failures, 0)
+	}
+	return response, err
+}
+
+func (f *FailoverClient) pick() *failoverTarget {
+	for _, target := range f.targets {
+		if atomic.LoadInt32(&target.unhealthy) == 0 {
+			return target
+		}
+	}
+	return f.targets[0]
+}
+
+// failed records a failed request, marking the target unhealthy and starting
+// to probe it once it's failed too many times in a row.
+func (f *FailoverClient) failed(target *failoverTarget) {
+	if atomic.AddInt32(&target.failures, 1) < f.threshold {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&target.unhealthy, 0, 1) {
+		f.scheduleProbe(target)
+	}
+}
+
+func (f *FailoverClient) scheduleProbe(target *failoverTarget) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return
+	}
+	f.stops[target] = f.clock.AfterFunc(f.interval, func() {
+		f.runProbe(target)
+	})
+}
+
+func (f *FailoverClient) runProbe(target *failoverTarget) {
+	ctx, cancel := withTimeout(f.ctx, f.clock, f.interval)
+	err := f.probe(ctx, target.raw)
+	cancel()
+	if err != nil {
+		f.scheduleProbe(target)
+		return
+	}
+	f.mu.Lock()
+	delete(f.stops, target)
+	f.mu.Unlock()
+	atomic.StoreInt32(&target.failures, 0)
+	atomic.StoreInt32(&target.unhealthy, 0)
+}
+
+func (f *FailoverClient) defaultProbe(ctx context.Context, target string) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodHead, target, http.NoBody)
+	if err != nil {
+		return err
+	}
+	response, err := f.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	_ = response.Body.Close()
+	if isGatewayFailure(response.StatusCode) {
+		return fmt.Errorf("probe of %s: %s", target, response.Status)
+	}
+	return nil
+}
+
+// isGatewayFailure reports whether the HTTP status suggests that the endpoint
+// as a whole, rather than a single call, is failing.
+func isGatewayFailure(status int) bool {
+	switch status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
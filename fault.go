@@ -0,0 +1,228 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// FaultConfig configures NewFaultInterceptor. Each faulted call is delayed,
+// failed, or truncated as described by the fields below; the zero value
+// injects no faults.
+type FaultConfig struct {
+	// Match, if non-nil, limits faults to calls it returns true for. It's
+	// called with the call's Spec and request headers, so faults can be
+	// targeted by procedure or by a header set by a test client. It must be
+	// safe to call concurrently.
+	Match func(spec Spec, header http.Header) bool
+	// Rate is the fraction of matching calls to fault, between 0 and 1. Zero
+	// faults no calls, and 1 faults every matching call.
+	Rate float64
+	// Delay is added to the start of each faulted call.
+	Delay time.Duration
+	// Clock measures the delay. It defaults to the system clock.
+	Clock Clock
+	// Code, if non-zero, fails faulted calls with an error with this code.
+	// Unless TruncateAfter is set, the call fails before it reaches the
+	// handler (or, on clients, the network).
+	Code Code
+	// TruncateAfter, if positive, lets faulted streams deliver this many
+	// response messages before failing them with Code, or CodeUnavailable if
+	// Code is zero. It has no effect on unary calls.
+	TruncateAfter int
+}
+
+// NewFaultInterceptor constructs an interceptor that injects latency, errors,
+// and stream truncation into a fraction of calls, so the resilience of callers
+// can be tested in staging:
+//
+//	connect.WithInterceptors(connect.NewFaultInterceptor(connect.FaultConfig{
+//	  Match: func(spec connect.Spec, header http.Header) bool {
+//	    return header.Get("Chaos") != ""
+//	  },
+//	  Rate:  0.1,
+//	  Delay: time.Second,
+//	  Code:  connect.CodeUnavailable,
+//	}))
+//
+// Injected errors are indistinguishable from real ones to the caller. The
+// interceptor works on both clients and handlers.
+func NewFaultInterceptor(config FaultConfig) Interceptor {
+	if config.Clock == nil {
+		config.Clock = systemClock{}
+	}
+	return &faultInterceptor{config: config}
+}
+
+type faultInterceptor struct {
+	config FaultConfig
+}
+
+func (i *faultInterceptor) WrapUnary(next UnaryFunc) UnaryFunc {
+	return func(ctx context.Context, request AnyRequest) (AnyResponse, error) {
+		if !i.sample(request.Spec(), request.Header()) {
+			return next(ctx, request)
+		}
+		if err := i.delay(ctx); err != nil {
+			return nil, err
+		}
+		if i.config.Code != 0 {
+			return nil, i.error()
+		}
+		return next(ctx, request)
+	}
+}
+
+func (i *faultInterceptor) WrapStreamContext(ctx context.Context) context.Context {
+	// Each interceptor keys its streams by its own address, so stacked fault
+	// interceptors don't share state.
+	return context.WithValue(ctx, i, &faultStream{interceptor: i, ctx: ctx})
+}
+
+func (i *faultInterceptor) WrapStreamSender(ctx context.Context, sender Sender) Sender {
+	stream := i.stream(ctx)
+	if sender.Spec().IsClient {
+		stream.spec, stream.requestHeader = sender.Spec(), sender.Header()
+	}
+	return &faultSender{Sender: sender, stream: stream}
+}
+
+func (i *faultInterceptor) WrapStreamReceiver(ctx context.Context, receiver Receiver) Receiver {
+	stream := i.stream(ctx)
+	if !receiver.Spec().IsClient {
+		stream.spec, stream.requestHeader = receiver.Spec(), receiver.Header()
+	}
+	return &faultReceiver{Receiver: receiver, stream: stream}
+}
+
+// stream returns the state shared by a stream's Sender and Receiver.
+func (i *faultInterceptor) stream(ctx context.Context) *faultStream {
+	if stream, ok := ctx.Value(i).(*faultStream); ok {
+		return stream
+	}
+	// WrapStreamContext wasn't called with this context, so the Sender and
+	// Receiver can't share state. Fall back to a stream that's never faulted.
+	return &faultStream{interceptor: i, ctx: ctx, skip: true}
+}
+
+func (i *faultInterceptor) sample(spec Spec, header http.Header) bool {
+	if i.config.Match != nil && !i.config.Match(spec, header) {
+		return false
+	}
+	return i.config.Rate >= 1 || rand.Float64() < i.config.Rate //nolint:gosec
+}
+
+func (i *faultInterceptor) delay(ctx context.Context) error {
+	if i.config.Delay <= 0 {
+		return nil
+	}
+	return wrapIfContextError(sleep(ctx, i.config.Clock, i.config.Delay))
+}
+
+func (i *faultInterceptor) error() error {
+	code := i.config.Code
+	if code == 0 {
+		code = CodeUnavailable
+	}
+	return errorf(code, "injected fault")
+}
+
+// faultStream decides whether to fault a stream when it's first used, since
+// clients may add request headers after the stream is created.
+type faultStream struct {
+	interceptor   *faultInterceptor
+	ctx           context.Context
+	skip          bool
+	spec          Spec
+	requestHeader http.Header
+
+	once      sync.Once
+	faulted   bool
+	err       error
+	responses int // only touched by the side that handles responses
+}
+
+func (s *faultStream) start() error {
+	s.once.Do(func() {
+		if s.skip || s.requestHeader == nil {
+			return
+		}
+		config := s.interceptor.config
+		if s.faulted = s.interceptor.sample(s.spec, s.requestHeader); !s.faulted {
+			return
+		}
+		if s.err = s.interceptor.delay(s.ctx); s.err != nil {
+			return
+		}
+		if config.Code != 0 && config.TruncateAfter <= 0 {
+			s.err = s.interceptor.error()
+		}
+	})
+	return s.err
+}
+
+// response counts a response message, returning an error if it's past the
+// point where the stream should be truncated.
+func (s *faultStream) response() error {
+	if !s.faulted || s.interceptor.config.TruncateAfter <= 0 {
+		return nil
+	}
+	s.responses++
+	if s.responses > s.interceptor.config.TruncateAfter {
+		return s.interceptor.error()
+	}
+	return nil
+}
+
+type faultSender struct {
+	Sender
+
+	stream *faultStream
+}
+
+func (s *faultSender) Send(msg any) error {
+	if err := s.stream.start(); err != nil {
+		return err
+	}
+	if !s.Spec().IsClient {
+		if err := s.stream.response(); err != nil {
+			return err
+		}
+	}
+	return s.Sender.Send(msg)
+}
+
+type faultReceiver struct {
+	Receiver
+
+	stream *faultStream
+}
+
+func (r *faultReceiver) Receive(msg any) error {
+	if err := r.stream.start(); err != nil {
+		return err
+	}
+	if err := r.Receiver.Receive(msg); err != nil {
+		return err
+	}
+	if r.Spec().IsClient {
+		return r.stream.response()
+	}
+	return nil
+}
@@ -0,0 +1,632 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package graphql exposes Connect services as a GraphQL API, for frontend
+// teams that standardize on GraphQL. Unary procedures become queries or
+// mutations, and server streaming procedures become subscriptions. Each field
+// takes its request message as a single optional "input" argument and returns
+// the response message:
+//
+//	query {
+//	  ping(input: {text: "hello"}) { text }
+//	}
+//
+// Fields are usually backed by generated clients. With the graphql=true
+// parameter, protoc-gen-connect-go generates a constructor for each service,
+// like NewPingServiceGraphQLFields, that makes idempotent unary procedures
+// (those with the NO_SIDE_EFFECTS or IDEMPOTENT idempotency_level) queries,
+// other unary procedures mutations, and server streaming procedures
+// subscriptions. Client and bidirectional streaming procedures can't be
+// expressed in GraphQL, so they're skipped. Fields can also be constructed
+// by hand with NewQuery, NewMutation, and NewSubscription.
+//
+// The GraphQL schema is derived from the Protobuf schema, and messages use the
+// same JSON representation as Connect's JSON codec: fields use their JSON
+// names, 64-bit integers and bytes are strings, and enums use their value
+// names. Schema.SDL renders the schema for tooling.
+//
+// The gateway implements a subset of GraphQL: operations, variables, aliases,
+// and field arguments are supported, but fragments, directives, and
+// introspection queries aren't. Queries and mutations are served over HTTP
+// POST with JSON bodies of up to 1 MiB, and documents may nest selections and
+// values up to 64 levels deep. Subscriptions are served as server-sent events,
+// using the "distinct connections" mode of the GraphQL over SSE protocol.
+//
+// The gateway calls backends with a fresh Connect request for each field. By
+// default, only the Authorization header is copied from the GraphQL request
+// onto these calls; use Schema.ForwardHeaders to forward others.
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/bufbuild/connect-go"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// maxRequestBytes limits the size of GraphQL request bodies.
+const maxRequestBytes = 1 << 20 // 1 MiB
+
+// OperationType is the type of GraphQL operation a field belongs to.
+type OperationType int
+
+// The GraphQL operation types.
+const (
+	Query OperationType = iota + 1
+	Mutation
+	Subscription
+)
+
+func (t OperationType) String() string {
+	switch t {
+	case Query:
+		return "Query"
+	case Mutation:
+		return "Mutation"
+	case Subscription:
+		return "Subscription"
+	}
+	return "OperationType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// A Field is a root field of the GraphQL schema, backed by a Connect
+// procedure.
+type Field struct {
+	name      string
+	operation OperationType
+	input     protoreflect.MessageType
+	output    protoreflect.MessageType
+	resolve   func(context.Context, http.Header, proto.Message) (proto.Message, error)
+	subscribe func(context.Context, http.Header, proto.Message, func(proto.Message) error) error
+}
+
+// NewQuery constructs a query field backed by a unary procedure, typically a
+// method of a generated client. Queries shouldn't have side effects.
+func NewQuery[Req, Res any](
+	name string,
+	call func(context.Context, *connect.Request[Req]) (*connect.Response[Res], error),
+) Field {
+	return newUnaryField(name, Query, call)
+}
+
+// NewMutation constructs a mutation field backed by a unary procedure,
+// typically a method of a generated client.
+func NewMutation[Req, Res any](
+	name string,
+	call func(context.Context, *connect.Request[Req]) (*connect.Response[Res], error),
+) Field {
+	return newUnaryField(name, Mutation, call)
+}
+
+// NewSubscription constructs a subscription field backed by a server
+// streaming procedure, typically a method of a generated client. Each response
+// message is sent to the subscriber as a separate result.
+func NewSubscription[Req, Res any](
+	name string,
+	call func(context.Context, *connect.Request[Req]) (*connect.ServerStreamForClient[Res], error),
+) Field {
+	return Field{
+		name:      name,
+		operation: Subscription,
+		input:     messageType[Req](),
+		output:    messageType[Res](),
+		subscribe: func(
+			ctx context.Context,
+			header http.Header,
+			input proto.Message,
+			send func(proto.Message) error,
+		) error {
+			request, ok := any(input).(*Req)
+			if !ok {
+				return connect.NewError(connect.CodeInternal, fmt.Errorf("unexpected input type %T", input))
+			}
+			stream, err := call(ctx, newRequest(request, header))
+			if err != nil {
+				return err
+			}
+			defer stream.Close()
+			for stream.Receive() {
+				msg, ok := any(stream.Msg()).(proto.Message)
+				if !ok {
+					return connect.NewError(connect.CodeInternal, fmt.Errorf("%T isn't a Protobuf message", stream.Msg()))
+				}
+				if err := send(msg); err != nil {
+					return err
+				}
+			}
+			return stream.Err()
+		},
+	}
+}
+
+func newUnaryField[Req, Res any](
+	name string,
+	operation OperationType,
+	call func(context.Context, *connect.Request[Req]) (*connect.Response[Res], error),
+) Field {
+	return Field{
+		name:      name,
+		operation: operation,
+		input:     messageType[Req](),
+		output:    messageType[Res](),
+		resolve: func(ctx context.Context, header http.Header, input proto.Message) (proto.Message, error) {
+			request, ok := any(input).(*Req)
+			if !ok {
+				return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("unexpected input type %T", input))
+			}
+			response, err := call(ctx, newRequest(request, header))
+			if err != nil {
+				return nil, err
+			}
+			msg, ok := any(response.Msg).(proto.Message)
+			if !ok {
+				return nil, connect.NewError(
+					connect.CodeInternal,
+					fmt.Errorf("%T isn't a Protobuf message", response.Msg),
+				)
+			}
+			return msg, nil
+		},
+	}
+}
+
+// newRequest constructs a request to a backend, with the headers forwarded
+// from the GraphQL request.
+func newRequest[Req any](msg *Req, header http.Header) *connect.Request[Req] {
+	request := connect.NewRequest(msg)
+	for key, values := range header {
+		request.Header()[key] = append([]string(nil), values...)
+	}
+	return request
+}
+
+// messageType returns the Protobuf type of T, or nil if *T isn't a generated
+// message. NewSchema reports the error.
+func messageType[T any]() protoreflect.MessageType {
+	if msg, ok := any(new(T)).(proto.Message); ok {
+		return msg.ProtoReflect().Type()
+	}
+	return nil
+}
+
+// Schema is a GraphQL API backed by Connect procedures. It's an http.Handler
+// that serves GraphQL requests.
+type Schema struct {
+	fields  map[OperationType]map[string]*Field
+	order   []*Field
+	forward []string // canonical header keys
+}
+
+var _ http.Handler = (*Schema)(nil)
+
+// NewSchema constructs a Schema from root fields. Field names must be valid
+// GraphQL names and unique within each operation type, so fields from
+// services with overlapping method names need to be renamed.
+func NewSchema(fields ...Field) (*Schema, error) {
+	schema := &Schema{
+		fields:  make(map[OperationType]map[string]*Field),
+		forward: []string{"Authorization"},
+	}
+	for i := range fields {
+		field := &fields[i]
+		if !isName(field.name) {
+			return nil, fmt.Errorf("invalid GraphQL field name %q", field.name)
+		}
+		if field.input == nil || field.output == nil {
+			return nil, fmt.Errorf("field %q: request and response must be Protobuf messages", field.name)
+		}
+		byName, ok := schema.fields[field.operation]
+		if !ok {
+			byName = make(map[string]*Field)
+			schema.fields[field.operation] = byName
+		}
+		if _, ok := byName[field.name]; ok {
+			return nil, fmt.Errorf("duplicate %v field %q", field.operation, field.name)
+		}
+		byName[field.name] = field
+		schema.order = append(schema.order, field)
+	}
+	return schema, nil
+}
+
+// ForwardHeaders sets the request headers that are copied from each GraphQL
+// request onto the calls it makes to backends, replacing the default of
+// Authorization. Headers not listed aren't forwarded, so that headers meant
+// for the gateway (like cookies) don't leak to backends. ForwardHeaders must
+// be called before the Schema serves requests.
+func (s *Schema) ForwardHeaders(keys ...string) {
+	s.forward = make([]string, len(keys))
+	for i, key := range keys {
+		s.forward[i] = http.CanonicalHeaderKey(key)
+	}
+}
+
+// forwardedHeader returns the headers to copy onto calls to backends.
+func (s *Schema) forwardedHeader(header http.Header) http.Header {
+	forwarded := make(http.Header, len(s.forward))
+	for _, key := range s.forward {
+		if values, ok := header[key]; ok {
+			forwarded[key] = values
+		}
+	}
+	return forwarded
+}
+
+type request struct {
+	Query         string          `json:"query"`
+	OperationName string          `json:"operationName"`
+	Variables     json.RawMessage `json:"variables"`
+}
+
+type response struct {
+	Data   json.Marshaler `json:"data,omitempty"`
+	Errors []*queryError  `json:"errors,omitempty"`
+}
+
+type queryError struct {
+	Message    string            `json:"message"`
+	Path       []string          `json:"path,omitempty"`
+	Extensions map[string]string `json:"extensions,omitempty"`
+}
+
+func newQueryError(err error, path ...string) *queryError {
+	queryErr := &queryError{Message: err.Error(), Path: path}
+	var connectErr *connect.Error
+	if errors.As(err, &connectErr) {
+		queryErr.Message = connectErr.Message()
+		queryErr.Extensions = map[string]string{"code": connectErr.Code().String()}
+	}
+	return queryErr
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Schema) ServeHTTP(responseWriter http.ResponseWriter, httpRequest *http.Request) {
+	if httpRequest.Method != http.MethodPost {
+		responseWriter.Header().Set("Allow", http.MethodPost)
+		responseWriter.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var req request
+	body := http.MaxBytesReader(responseWriter, httpRequest.Body, maxRequestBytes)
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		writeResponse(responseWriter, http.StatusBadRequest, &response{
+			Errors: []*queryError{{Message: "invalid request body: " + err.Error()}},
+		})
+		return
+	}
+	op, variables, err := s.prepare(&req)
+	if err != nil {
+		writeResponse(responseWriter, http.StatusBadRequest, &response{Errors: []*queryError{newQueryError(err)}})
+		return
+	}
+	ctx := httpRequest.Context()
+	forwarded := s.forwardedHeader(httpRequest.Header)
+	if op.Type == Subscription {
+		s.subscribe(ctx, responseWriter, forwarded, op, variables)
+		return
+	}
+	data := make(object, 0, len(op.Selections))
+	var queryErrors []*queryError
+	// We execute fields serially, as GraphQL requires for mutations.
+	for _, sel := range op.Selections {
+		if sel.Name == "__typename" {
+			data = append(data, member{sel.Alias, op.Type.String()})
+			continue
+		}
+		field := s.fields[op.Type][sel.Name]
+		result, err := s.execute(ctx, forwarded, field, sel, variables)
+		if err != nil {
+			queryErrors = append(queryErrors, newQueryError(err, sel.Alias))
+			data = append(data, member{sel.Alias, nil})
+			continue
+		}
+		data = append(data, member{sel.Alias, result})
+	}
+	writeResponse(responseWriter, http.StatusOK, &response{Data: data, Errors: queryErrors})
+}
+
+// prepare parses and validates the request, returning the operation to
+// execute and its variables.
+func (s *Schema) prepare(req *request) (*operation, map[string]any, error) {
+	operations, err := parse(req.Query)
+	if err != nil {
+		return nil, nil, err
+	}
+	var op *operation
+	for _, candidate := range operations {
+		if req.OperationName == "" || candidate.Name == req.OperationName {
+			if op != nil {
+				return nil, nil, errors.New("operationName is required for documents with several operations")
+			}
+			op = candidate
+		}
+	}
+	if op == nil {
+		return nil, nil, fmt.Errorf("unknown operation %q", req.OperationName)
+	}
+	variables := make(map[string]any)
+	if len(req.Variables) > 0 && !bytes.Equal(req.Variables, []byte("null")) {
+		decoder := json.NewDecoder(bytes.NewReader(req.Variables))
+		decoder.UseNumber()
+		if err := decoder.Decode(&variables); err != nil {
+			return nil, nil, fmt.Errorf("invalid variables: %w", err)
+		}
+	}
+	for name, defaultValue := range op.Defaults {
+		if _, ok := variables[name]; !ok {
+			resolved, err := resolve(defaultValue, nil)
+			if err != nil {
+				return nil, nil, err
+			}
+			variables[name] = resolved
+		}
+	}
+	if op.Type == Subscription && len(op.Selections) != 1 {
+		return nil, nil, errors.New("subscriptions must select exactly one root field")
+	}
+	for _, sel := range op.Selections {
+		if sel.Name == "__typename" && op.Type != Subscription {
+			continue
+		}
+		field, ok := s.fields[op.Type][sel.Name]
+		if !ok {
+			return nil, nil, fmt.Errorf("cannot query field %q on type %q", sel.Name, op.Type)
+		}
+		for name := range sel.Arguments {
+			if name != "input" {
+				return nil, nil, fmt.Errorf("unknown argument %q on field %q", name, sel.Name)
+			}
+		}
+		if err := validateSelections(field.output.Descriptor(), sel, op.Type.String()+"."+sel.Name); err != nil {
+			return nil, nil, err
+		}
+	}
+	return op, variables, nil
+}
+
+// validateSelections checks the selections made on a field of message type.
+func validateSelections(message protoreflect.MessageDescriptor, sel *selection, path string) error {
+	if isScalarMessage(message) {
+		if sel.Selections != nil {
+			return fmt.Errorf("field %q is a scalar and can't have selections", path)
+		}
+		return nil
+	}
+	if sel.Selections == nil {
+		return fmt.Errorf("field %q must have a selection of subfields", path)
+	}
+	for _, sub := range sel.Selections {
+		if len(sub.Arguments) > 0 {
+			return fmt.Errorf("field %q doesn't take arguments", path+"."+sub.Name)
+		}
+		if sub.Name == "__typename" {
+			continue
+		}
+		field := message.Fields().ByJSONName(sub.Name)
+		if field == nil {
+			return fmt.Errorf("cannot query field %q on type %q", sub.Name, outputTypeName(message))
+		}
+		if field.Message() != nil && !field.IsMap() {
+			if err := validateSelections(field.Message(), sub, path+"."+sub.Name); err != nil {
+				return err
+			}
+		} else if sub.Selections != nil {
+			return fmt.Errorf("field %q is a scalar and can't have selections", path+"."+sub.Name)
+		}
+	}
+	return nil
+}
+
+func (s *Schema) input(field *Field, sel *selection, variables map[string]any) (proto.Message, error) {
+	input := field.input.New().Interface()
+	argument, ok := sel.Arguments["input"]
+	if !ok {
+		return input, nil
+	}
+	resolved, err := resolve(argument, variables)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	if resolved == nil {
+		return input, nil
+	}
+	data, err := json.Marshal(resolved)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	if err := protojson.Unmarshal(data, input); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid input: %w", err))
+	}
+	return input, nil
+}
+
+func (s *Schema) execute(
+	ctx context.Context,
+	forwarded http.Header,
+	field *Field,
+	sel *selection,
+	variables map[string]any,
+) (any, error) {
+	input, err := s.input(field, sel, variables)
+	if err != nil {
+		return nil, err
+	}
+	output, err := field.resolve(ctx, forwarded, input)
+	if err != nil {
+		return nil, err
+	}
+	return project(output, sel)
+}
+
+func (s *Schema) subscribe(
+	ctx context.Context,
+	responseWriter http.ResponseWriter,
+	forwarded http.Header,
+	op *operation,
+	variables map[string]any,
+) {
+	sel := op.Selections[0]
+	field := s.fields[Subscription][sel.Name]
+	input, err := s.input(field, sel, variables)
+	if err != nil {
+		writeResponse(responseWriter, http.StatusBadRequest, &response{
+			Errors: []*queryError{newQueryError(err, sel.Alias)},
+		})
+		return
+	}
+	header := responseWriter.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	responseWriter.WriteHeader(http.StatusOK)
+	flusher, _ := responseWriter.(http.Flusher)
+	writeEvent := func(event string, payload *response) error {
+		data := []byte{}
+		if payload != nil {
+			var err error
+			if data, err = json.Marshal(payload); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(responseWriter, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+	err = field.subscribe(ctx, forwarded, input, func(msg proto.Message) error {
+		result, err := project(msg, sel)
+		if err != nil {
+			return err
+		}
+		return writeEvent("next", &response{Data: object{{sel.Alias, result}}})
+	})
+	if err != nil && ctx.Err() == nil {
+		_ = writeEvent("next", &response{Errors: []*queryError{newQueryError(err, sel.Alias)}})
+	}
+	_ = writeEvent("complete", nil)
+}
+
+// project marshals msg to JSON and keeps only the selected fields, in the
+// order they were selected.
+func project(msg proto.Message, sel *selection) (any, error) {
+	data, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(msg)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("marshal response: %w", err))
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var decoded any
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("decode response: %w", err))
+	}
+	return projectMessage(msg.ProtoReflect().Descriptor(), decoded, sel), nil
+}
+
+func projectMessage(message protoreflect.MessageDescriptor, decoded any, sel *selection) any {
+	if isScalarMessage(message) {
+		return decoded
+	}
+	fields, ok := decoded.(map[string]any)
+	if !ok {
+		// Null, since validation has ruled out other scalars.
+		return decoded
+	}
+	result := make(object, 0, len(sel.Selections))
+	for _, sub := range sel.Selections {
+		if sub.Name == "__typename" {
+			result = append(result, member{sub.Alias, outputTypeName(message)})
+			continue
+		}
+		field := message.Fields().ByJSONName(sub.Name)
+		value := fields[sub.Name]
+		if field.Message() != nil && !field.IsMap() {
+			if list, ok := value.([]any); ok {
+				projected := make([]any, len(list))
+				for i, item := range list {
+					projected[i] = projectMessage(field.Message(), item, sub)
+				}
+				value = projected
+			} else {
+				value = projectMessage(field.Message(), value, sub)
+			}
+		}
+		result = append(result, member{sub.Alias, value})
+	}
+	return result
+}
+
+// object is a JSON object that preserves the order of its members, since
+// GraphQL results follow the order of the selections.
+type object []member
+
+type member struct {
+	name  string
+	value any
+}
+
+func (o object) MarshalJSON() ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.WriteByte('{')
+	for i, m := range o {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		name, err := json.Marshal(m.name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(m.value)
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(name)
+		buffer.WriteByte(':')
+		buffer.Write(value)
+	}
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
+
+func writeResponse(responseWriter http.ResponseWriter, status int, payload *response) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		status = http.StatusInternalServerError
+		data, _ = json.Marshal(&response{Errors: []*queryError{{Message: err.Error()}}})
+	}
+	responseWriter.Header().Set("Content-Type", "application/json")
+	responseWriter.WriteHeader(status)
+	_, _ = responseWriter.Write(data)
+}
+
+func isName(name string) bool {
+	if name == "" || isDigit(name[0]) {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; c != '_' && !isLetter(c) && !isDigit(c) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,253 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graphql_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/bufbuild/connect-go/graphql"
+	"github.com/bufbuild/connect-go/internal/assert"
+	pingv1 "github.com/bufbuild/connect-go/internal/gen/connect/ping/v1"
+	"github.com/bufbuild/connect-go/internal/gen/connect/ping/v1/pingv1connect"
+)
+
+type pingServer struct {
+	pingv1connect.UnimplementedPingServiceHandler
+}
+
+func (pingServer) Ping(
+	_ context.Context,
+	request *connect.Request[pingv1.PingRequest],
+) (*connect.Response[pingv1.PingResponse], error) {
+	text := request.Msg.Text
+	if text == "whoami" {
+		text = request.Header().Get("Authorization") + ";" + request.Header().Get("Cookie")
+	}
+	return connect.NewResponse(&pingv1.PingResponse{
+		Number: request.Msg.Number,
+		Text:   text,
+	}), nil
+}
+
+func (pingServer) Fail(
+	_ context.Context,
+	request *connect.Request[pingv1.FailRequest],
+) (*connect.Response[pingv1.FailResponse], error) {
+	return nil, connect.NewError(connect.Code(request.Msg.Code), errors.New("oh no"))
+}
+
+func (pingServer) CountUp(
+	_ context.Context,
+	request *connect.Request[pingv1.CountUpRequest],
+	stream *connect.ServerStream[pingv1.CountUpResponse],
+) error {
+	if request.Msg.Number < 0 {
+		return connect.NewError(connect.CodeInvalidArgument, errors.New("number must be positive"))
+	}
+	for i := int64(1); i <= request.Msg.Number; i++ {
+		if err := stream.Send(&pingv1.CountUpResponse{Number: i}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestGraphQL(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}))
+	backend := httptest.NewServer(mux)
+	t.Cleanup(backend.Close)
+	client := pingv1connect.NewPingServiceClient(backend.Client(), backend.URL)
+	schema, err := graphql.NewSchema(
+		graphql.NewQuery("ping", client.Ping),
+		graphql.NewMutation("fail", client.Fail),
+		graphql.NewSubscription("countUp", client.CountUp),
+	)
+	assert.Nil(t, err)
+	server := httptest.NewServer(schema)
+	t.Cleanup(server.Close)
+	post := func(t *testing.T, query string, variables map[string]any) (*http.Response, string) {
+		t.Helper()
+		body, err := json.Marshal(map[string]any{"query": query, "variables": variables})
+		assert.Nil(t, err)
+		response, err := server.Client().Post(server.URL, "application/json", bytes.NewReader(body))
+		assert.Nil(t, err)
+		defer response.Body.Close()
+		data, err := io.ReadAll(response.Body)
+		assert.Nil(t, err)
+		return response, string(data)
+	}
+
+	t.Run("query", func(t *testing.T) {
+		t.Parallel()
+		response, body := post(t, `
+			query Ping($text: String = "default") {
+				first: ping(input: {number: 42, text: $text}) { text number }
+				second: ping(input: {text: "literal"}) { text __typename }
+				__typename
+			}`,
+			map[string]any{"text": "variable"},
+		)
+		assert.Equal(t, response.StatusCode, http.StatusOK)
+		assert.Equal(
+			t,
+			body,
+			`{"data":{"first":{"text":"variable","number":"42"},`+
+				`"second":{"text":"literal","__typename":"connect_ping_v1_PingResponse"},`+
+				`"__typename":"Query"}}`,
+		)
+	})
+	t.Run("variable_default", func(t *testing.T) {
+		t.Parallel()
+		_, body := post(t, `query ($text: String = "default") { ping(input: {text: $text}) { text } }`, nil)
+		assert.Equal(t, body, `{"data":{"ping":{"text":"default"}}}`)
+	})
+	t.Run("mutation_error", func(t *testing.T) {
+		t.Parallel()
+		response, body := post(t, `mutation { fail(input: {code: 5}) }`, nil)
+		assert.Equal(t, response.StatusCode, http.StatusOK)
+		assert.Equal(
+			t,
+			body,
+			`{"data":{"fail":null},"errors":[{"message":"oh no","path":["fail"],"extensions":{"code":"not_found"}}]}`,
+		)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+		for _, query := range []string{
+			`{ ping { nope } }`,
+			`{ ping }`,
+			`{ fail(input: {}) }`,
+			`mutation { fail(input: {}) { code } }`,
+			`{ ping(other: 1) { text } }`,
+			`{ ...fragment }`,
+			`{ ping(input: {text: "unterminated) { text } }`,
+		} {
+			response, body := post(t, query, nil)
+			assert.Equal(t, response.StatusCode, http.StatusBadRequest, assert.Sprintf("query %q", query))
+			assert.True(t, strings.HasPrefix(body, `{"errors":[`), assert.Sprintf("body %s", body))
+		}
+	})
+	t.Run("nesting", func(t *testing.T) {
+		t.Parallel()
+		nested := func(depth int) string {
+			value := strings.Repeat("[", depth) + `"x"` + strings.Repeat("]", depth)
+			return `{ ping(input: {text: ` + value + `}) { text } }`
+		}
+		response, body := post(t, nested(10), nil)
+		assert.Equal(t, response.StatusCode, http.StatusOK)
+		assert.True(t, strings.Contains(body, `"code":"invalid_argument"`), assert.Sprintf("body %s", body))
+		response, body = post(t, nested(100), nil)
+		assert.Equal(t, response.StatusCode, http.StatusBadRequest)
+		assert.True(t, strings.Contains(body, "nested more than 64 levels"), assert.Sprintf("body %s", body))
+		response, body = post(t, nested(5_000_000), nil)
+		assert.Equal(t, response.StatusCode, http.StatusBadRequest)
+		assert.True(t, strings.Contains(body, "too large"), assert.Sprintf("body %s", body))
+	})
+	t.Run("headers", func(t *testing.T) {
+		t.Parallel()
+		body, err := json.Marshal(map[string]any{"query": `{ ping(input: {text: "whoami"}) { text } }`})
+		assert.Nil(t, err)
+		request, err := http.NewRequestWithContext(
+			context.Background(),
+			http.MethodPost,
+			server.URL,
+			bytes.NewReader(body),
+		)
+		assert.Nil(t, err)
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("Authorization", "Bearer token")
+		request.Header.Set("Cookie", "session=secret")
+		response, err := server.Client().Do(request)
+		assert.Nil(t, err)
+		defer response.Body.Close()
+		data, err := io.ReadAll(response.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, string(data), `{"data":{"ping":{"text":"Bearer token;"}}}`)
+	})
+	t.Run("invalid_input", func(t *testing.T) {
+		t.Parallel()
+		_, body := post(t, `{ ping(input: {unknown: 1}) { text } }`, nil)
+		assert.True(
+			t,
+			strings.Contains(body, `"extensions":{"code":"invalid_argument"}`),
+			assert.Sprintf("body %s", body),
+		)
+	})
+	t.Run("subscription", func(t *testing.T) {
+		t.Parallel()
+		response, body := post(t, `subscription { countUp(input: {number: 2}) { number } }`, nil)
+		assert.Equal(t, response.Header.Get("Content-Type"), "text/event-stream")
+		assert.Equal(
+			t,
+			body,
+			"event: next\ndata: {\"data\":{\"countUp\":{\"number\":\"1\"}}}\n\n"+
+				"event: next\ndata: {\"data\":{\"countUp\":{\"number\":\"2\"}}}\n\n"+
+				"event: complete\ndata: \n\n",
+		)
+		_, body = post(t, `subscription { countUp(input: {number: -1}) { number } }`, nil)
+		assert.True(t, strings.Contains(body, `"code":"invalid_argument"`), assert.Sprintf("body %s", body))
+		assert.True(t, strings.HasSuffix(body, "event: complete\ndata: \n\n"))
+	})
+	t.Run("sdl", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, schema.SDL(), `type Query {
+  ping(input: connect_ping_v1_PingRequestInput): connect_ping_v1_PingResponse
+}
+type Mutation {
+  fail(input: connect_ping_v1_FailRequestInput): JSON
+}
+type Subscription {
+  countUp(input: connect_ping_v1_CountUpRequestInput): connect_ping_v1_CountUpResponse
+}
+input connect_ping_v1_CountUpRequestInput {
+  number: String
+}
+type connect_ping_v1_CountUpResponse {
+  number: String
+}
+input connect_ping_v1_FailRequestInput {
+  code: Int
+}
+input connect_ping_v1_PingRequestInput {
+  number: String
+  text: String
+}
+type connect_ping_v1_PingResponse {
+  number: String
+  text: String
+}
+scalar JSON
+`)
+	})
+	t.Run("duplicate", func(t *testing.T) {
+		t.Parallel()
+		_, err := graphql.NewSchema(
+			graphql.NewQuery("ping", client.Ping),
+			graphql.NewQuery("ping", client.Ping),
+		)
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,580 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// This file implements the subset of the GraphQL query language that the
+// gateway executes: operations with variables, fields, aliases, and
+// arguments. Fragments and directives aren't supported.
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunctuator
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of document"
+	}
+	return strconv.Quote(t.value)
+}
+
+type lexer struct {
+	source string
+	pos    int
+}
+
+func (l *lexer) next() (token, error) {
+	l.skipIgnored()
+	if l.pos >= len(l.source) {
+		return token{kind: tokenEOF, pos: l.pos}, nil
+	}
+	start := l.pos
+	c := l.source[l.pos]
+	switch {
+	case strings.HasPrefix(l.source[l.pos:], "..."):
+		l.pos += 3
+		return token{kind: tokenPunctuator, value: "...", pos: start}, nil
+	case strings.IndexByte("!$&():=@[]{}|", c) >= 0:
+		l.pos++
+		return token{kind: tokenPunctuator, value: string(c), pos: start}, nil
+	case c == '_' || isLetter(c):
+		for l.pos < len(l.source) && isNameContinue(l.source[l.pos]) {
+			l.pos++
+		}
+		return token{kind: tokenName, value: l.source[start:l.pos], pos: start}, nil
+	case c == '-' || isDigit(c):
+		return l.number()
+	case c == '"':
+		return l.string()
+	}
+	r, _ := utf8.DecodeRuneInString(l.source[l.pos:])
+	return token{}, fmt.Errorf("syntax error at offset %d: unexpected character %q", start, r)
+}
+
+func (l *lexer) skipIgnored() {
+	for l.pos < len(l.source) {
+		switch c := l.source[l.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			l.pos++
+		case c == '#':
+			for l.pos < len(l.source) && l.source[l.pos] != '\n' && l.source[l.pos] != '\r' {
+				l.pos++
+			}
+		case strings.HasPrefix(l.source[l.pos:], "\ufeff"):
+			l.pos += len("\ufeff")
+		default:
+			return
+		}
+	}
+}
+
+func (l *lexer) number() (token, error) {
+	start := l.pos
+	kind := tokenInt
+	if l.source[l.pos] == '-' {
+		l.pos++
+	}
+	digits := func() int {
+		begin := l.pos
+		for l.pos < len(l.source) && isDigit(l.source[l.pos]) {
+			l.pos++
+		}
+		return l.pos - begin
+	}
+	if digits() == 0 {
+		return token{}, fmt.Errorf("syntax error at offset %d: invalid number", start)
+	}
+	if l.pos < len(l.source) && l.source[l.pos] == '.' {
+		kind = tokenFloat
+		l.pos++
+		if digits() == 0 {
+			return token{}, fmt.Errorf("syntax error at offset %d: invalid number", start)
+		}
+	}
+	if l.pos < len(l.source) && (l.source[l.pos] == 'e' || l.source[l.pos] == 'E') {
+		kind = tokenFloat
+		l.pos++
+		if l.pos < len(l.source) && (l.source[l.pos] == '+' || l.source[l.pos] == '-') {
+			l.pos++
+		}
+		if digits() == 0 {
+			return token{}, fmt.Errorf("syntax error at offset %d: invalid number", start)
+		}
+	}
+	return token{kind: kind, value: l.source[start:l.pos], pos: start}, nil
+}
+
+func (l *lexer) string() (token, error) {
+	start := l.pos
+	if strings.HasPrefix(l.source[l.pos:], `"""`) {
+		end := strings.Index(l.source[l.pos+3:], `"""`)
+		if end < 0 {
+			return token{}, fmt.Errorf("syntax error at offset %d: unterminated string", start)
+		}
+		value := l.source[l.pos+3 : l.pos+3+end]
+		l.pos += end + 6
+		return token{kind: tokenString, value: blockString(value), pos: start}, nil
+	}
+	l.pos++
+	for l.pos < len(l.source) {
+		switch l.source[l.pos] {
+		case '\\':
+			l.pos += 2
+		case '"':
+			l.pos++
+			// GraphQL string escapes are a subset of JSON's.
+			var value string
+			if err := json.Unmarshal([]byte(l.source[start:l.pos]), &value); err != nil {
+				return token{}, fmt.Errorf("syntax error at offset %d: invalid string: %w", start, err)
+			}
+			return token{kind: tokenString, value: value, pos: start}, nil
+		case '\n', '\r':
+			return token{}, fmt.Errorf("syntax error at offset %d: unterminated string", start)
+		default:
+			l.pos++
+		}
+	}
+	return token{}, fmt.Errorf("syntax error at offset %d: unterminated string", start)
+}
+
+// blockString strips the common indentation and surrounding blank lines from
+// a block string, as the GraphQL specification requires.
+func blockString(raw string) string {
+	lines := strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" {
+			continue
+		}
+		if n := len(line) - len(trimmed); indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	for i := 1; i < len(lines) && indent > 0; i++ {
+		if len(lines[i]) >= indent {
+			lines[i] = lines[i][indent:]
+		}
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.ReplaceAll(strings.Join(lines, "\n"), `\"""`, `"""`)
+}
+
+func isLetter(c byte) bool       { return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') }
+func isDigit(c byte) bool        { return c >= '0' && c <= '9' }
+func isNameContinue(c byte) bool { return c == '_' || isLetter(c) || isDigit(c) }
+
+type operation struct {
+	Type       OperationType
+	Name       string
+	Defaults   map[string]value
+	Selections []*selection
+}
+
+type selection struct {
+	Alias      string
+	Name       string
+	Arguments  map[string]value
+	Selections []*selection
+}
+
+// value is a literal or variable in a query. Literals are parsed to
+// JSON-compatible Go values (string, json.Number, bool, nil, []value, and
+// map[string]value); enum values become strings.
+type value any
+
+type variable string
+
+// maxDepth limits how deeply selection sets, values, and types may nest. The
+// parser is recursive, so without a limit a small document could exhaust the
+// stack, which crashes the process.
+const maxDepth = 64
+
+type parser struct {
+	lexer lexer
+	token token
+	depth int
+}
+
+func parse(source string) ([]*operation, error) {
+	p := &parser{lexer: lexer{source: source}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	var operations []*operation
+	for p.token.kind != tokenEOF {
+		op, err := p.operation()
+		if err != nil {
+			return nil, err
+		}
+		operations = append(operations, op)
+	}
+	if len(operations) == 0 {
+		return nil, fmt.Errorf("syntax error: document has no operations")
+	}
+	return operations, nil
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.token = tok
+	return nil
+}
+
+func (p *parser) is(punctuator string) bool {
+	return p.token.kind == tokenPunctuator && p.token.value == punctuator
+}
+
+func (p *parser) expect(punctuator string) error {
+	if !p.is(punctuator) {
+		return p.unexpected()
+	}
+	return p.advance()
+}
+
+func (p *parser) name() (string, error) {
+	if p.token.kind != tokenName {
+		return "", p.unexpected()
+	}
+	name := p.token.value
+	return name, p.advance()
+}
+
+// nest enters a nested selection set, value, or type, returning a function
+// that leaves it.
+func (p *parser) nest() (func(), error) {
+	if p.depth >= maxDepth {
+		return nil, fmt.Errorf(
+			"syntax error at offset %d: document is nested more than %d levels deep",
+			p.token.pos,
+			maxDepth,
+		)
+	}
+	p.depth++
+	return func() { p.depth-- }, nil
+}
+
+func (p *parser) unexpected() error {
+	return fmt.Errorf("syntax error at offset %d: unexpected %v", p.token.pos, p.token)
+}
+
+func (p *parser) operation() (*operation, error) {
+	op := &operation{Type: Query}
+	if p.is("{") {
+		selections, err := p.selectionSet()
+		op.Selections = selections
+		return op, err
+	}
+	if p.token.kind != tokenName {
+		return nil, p.unexpected()
+	}
+	switch p.token.value {
+	case "query":
+		op.Type = Query
+	case "mutation":
+		op.Type = Mutation
+	case "subscription":
+		op.Type = Subscription
+	case "fragment":
+		return nil, fmt.Errorf("fragments aren't supported")
+	default:
+		return nil, p.unexpected()
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.token.kind == tokenName {
+		op.Name = p.token.value
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if p.is("(") {
+		defaults, err := p.variableDefinitions()
+		if err != nil {
+			return nil, err
+		}
+		op.Defaults = defaults
+	}
+	if p.is("@") {
+		return nil, fmt.Errorf("directives aren't supported")
+	}
+	selections, err := p.selectionSet()
+	op.Selections = selections
+	return op, err
+}
+
+// variableDefinitions parses the operation's variables, returning their
+// default values. The gateway checks variables against the Protobuf schema
+// when it unmarshals inputs, so we skip over the declared types.
+func (p *parser) variableDefinitions() (map[string]value, error) {
+	defaults := make(map[string]value)
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	for !p.is(")") {
+		if err := p.expect("$"); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if err := p.typeReference(); err != nil {
+			return nil, err
+		}
+		if p.is("=") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			value, err := p.value(true /* constant */)
+			if err != nil {
+				return nil, err
+			}
+			defaults[name] = value
+		}
+	}
+	return defaults, p.advance()
+}
+
+func (p *parser) typeReference() error {
+	leave, err := p.nest()
+	if err != nil {
+		return err
+	}
+	defer leave()
+	if p.is("[") {
+		if err := p.advance(); err != nil {
+			return err
+		}
+		if err := p.typeReference(); err != nil {
+			return err
+		}
+		if err := p.expect("]"); err != nil {
+			return err
+		}
+	} else if _, err := p.name(); err != nil {
+		return err
+	}
+	if p.is("!") {
+		return p.advance()
+	}
+	return nil
+}
+
+func (p *parser) selectionSet() ([]*selection, error) {
+	leave, err := p.nest()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var selections []*selection
+	for !p.is("}") {
+		if p.is("...") {
+			return nil, fmt.Errorf("fragments aren't supported")
+		}
+		sel, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, sel)
+	}
+	if len(selections) == 0 {
+		return nil, p.unexpected()
+	}
+	return selections, p.advance()
+}
+
+func (p *parser) selection() (*selection, error) {
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	sel := &selection{Alias: name, Name: name}
+	if p.is(":") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if sel.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if p.is("(") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		sel.Arguments = make(map[string]value)
+		for !p.is(")") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			value, err := p.value(false /* constant */)
+			if err != nil {
+				return nil, err
+			}
+			sel.Arguments[name] = value
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if p.is("@") {
+		return nil, fmt.Errorf("directives aren't supported")
+	}
+	if p.is("{") {
+		if sel.Selections, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return sel, nil
+}
+
+func (p *parser) value(constant bool) (value, error) {
+	leave, err := p.nest()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+	tok := p.token
+	switch {
+	case p.is("$") && !constant:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		return variable(name), err
+	case p.is("["):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		list := []value{}
+		for !p.is("]") {
+			item, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, p.advance()
+	case p.is("{"):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		object := make(map[string]value)
+		for !p.is("}") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			if object[name], err = p.value(constant); err != nil {
+				return nil, err
+			}
+		}
+		return object, p.advance()
+	case tok.kind == tokenInt || tok.kind == tokenFloat:
+		return json.Number(tok.value), p.advance()
+	case tok.kind == tokenString:
+		return tok.value, p.advance()
+	case tok.kind == tokenName:
+		var literal value
+		switch tok.value {
+		case "true":
+			literal = true
+		case "false":
+			literal = false
+		case "null":
+			literal = nil
+		default:
+			literal = tok.value // enum value
+		}
+		return literal, p.advance()
+	}
+	return nil, p.unexpected()
+}
+
+// resolve substitutes variables into a value, producing a value that
+// encoding/json can marshal.
+func resolve(v value, variables map[string]any) (any, error) {
+	switch v := v.(type) {
+	case variable:
+		resolved, ok := variables[string(v)]
+		if !ok {
+			return nil, fmt.Errorf("variable $%s isn't defined", string(v))
+		}
+		return resolved, nil
+	case []value:
+		list := make([]any, len(v))
+		for i, item := range v {
+			resolved, err := resolve(item, variables)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = resolved
+		}
+		return list, nil
+	case map[string]value:
+		object := make(map[string]any, len(v))
+		for name, item := range v {
+			resolved, err := resolve(item, variables)
+			if err != nil {
+				return nil, err
+			}
+			object[name] = resolved
+		}
+		return object, nil
+	}
+	return v, nil
+}
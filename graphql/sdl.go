@@ -0,0 +1,255 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graphql
+
+import (
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// jsonScalar is the custom scalar for values without a fixed GraphQL shape,
+// like maps, google.protobuf.Struct, and google.protobuf.Any.
+const jsonScalar = "JSON"
+
+// wellKnownScalars maps well-known types to the GraphQL scalars that match
+// their JSON representations.
+var wellKnownScalars = map[protoreflect.FullName]string{ //nolint:gochecknoglobals
+	"google.protobuf.Timestamp":   "String",
+	"google.protobuf.Duration":    "String",
+	"google.protobuf.FieldMask":   "String",
+	"google.protobuf.StringValue": "String",
+	"google.protobuf.BytesValue":  "String",
+	"google.protobuf.Int64Value":  "String",
+	"google.protobuf.UInt64Value": "String",
+	"google.protobuf.Int32Value":  "Int",
+	"google.protobuf.UInt32Value": "Int",
+	"google.protobuf.FloatValue":  "Float",
+	"google.protobuf.DoubleValue": "Float",
+	"google.protobuf.BoolValue":   "Boolean",
+	"google.protobuf.Struct":      jsonScalar,
+	"google.protobuf.Value":       jsonScalar,
+	"google.protobuf.ListValue":   jsonScalar,
+	"google.protobuf.Any":         jsonScalar,
+	"google.protobuf.Empty":       jsonScalar,
+}
+
+// isScalarMessage reports whether a message is represented as a GraphQL
+// scalar rather than an object type. GraphQL object types must have fields,
+// so empty messages are scalars too.
+func isScalarMessage(message protoreflect.MessageDescriptor) bool {
+	_, ok := wellKnownScalars[message.FullName()]
+	return ok || message.Fields().Len() == 0
+}
+
+func scalarMessageName(message protoreflect.MessageDescriptor) string {
+	if name, ok := wellKnownScalars[message.FullName()]; ok {
+		return name
+	}
+	return jsonScalar
+}
+
+// typeName turns a Protobuf full name into a GraphQL name. Using the full
+// name keeps types from different packages distinct.
+func typeName(name protoreflect.FullName) string {
+	return strings.ReplaceAll(string(name), ".", "_")
+}
+
+func outputTypeName(message protoreflect.MessageDescriptor) string {
+	if isScalarMessage(message) {
+		return scalarMessageName(message)
+	}
+	return typeName(message.FullName())
+}
+
+func inputTypeName(message protoreflect.MessageDescriptor) string {
+	if isScalarMessage(message) {
+		return scalarMessageName(message)
+	}
+	return typeName(message.FullName()) + "Input"
+}
+
+// SDL renders the schema in the GraphQL schema definition language, for
+// clients and code generators. Root fields appear in the order they were
+// passed to NewSchema; other types are sorted by name.
+func (s *Schema) SDL() string {
+	renderer := &sdlRenderer{
+		outputs: make(map[string]protoreflect.MessageDescriptor),
+		inputs:  make(map[string]protoreflect.MessageDescriptor),
+		enums:   make(map[string]protoreflect.EnumDescriptor),
+	}
+	var builder strings.Builder
+	for _, operation := range []OperationType{Query, Mutation, Subscription} {
+		var fields []*Field
+		for _, field := range s.order {
+			if field.operation == operation {
+				fields = append(fields, field)
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		builder.WriteString("type " + operation.String() + " {\n")
+		for _, field := range fields {
+			input, output := field.input.Descriptor(), field.output.Descriptor()
+			builder.WriteString(
+				"  " + field.name + "(input: " + renderer.input(input) + "): " + renderer.output(output) + "\n",
+			)
+		}
+		builder.WriteString("}\n")
+	}
+	renderer.render(&builder)
+	return builder.String()
+}
+
+// sdlRenderer collects the types reachable from the root fields.
+type sdlRenderer struct {
+	outputs    map[string]protoreflect.MessageDescriptor
+	inputs     map[string]protoreflect.MessageDescriptor
+	enums      map[string]protoreflect.EnumDescriptor
+	jsonScalar bool
+}
+
+func (r *sdlRenderer) output(message protoreflect.MessageDescriptor) string {
+	name := outputTypeName(message)
+	if isScalarMessage(message) {
+		r.jsonScalar = r.jsonScalar || name == jsonScalar
+		return name
+	}
+	if _, ok := r.outputs[name]; !ok {
+		r.outputs[name] = message
+		r.fields(message, r.output)
+	}
+	return name
+}
+
+func (r *sdlRenderer) input(message protoreflect.MessageDescriptor) string {
+	name := inputTypeName(message)
+	if isScalarMessage(message) {
+		r.jsonScalar = r.jsonScalar || name == jsonScalar
+		return name
+	}
+	if _, ok := r.inputs[name]; !ok {
+		r.inputs[name] = message
+		r.fields(message, r.input)
+	}
+	return name
+}
+
+// fields visits the types of a message's fields.
+func (r *sdlRenderer) fields(
+	message protoreflect.MessageDescriptor,
+	visit func(protoreflect.MessageDescriptor) string,
+) {
+	fields := message.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		r.fieldType(fields.Get(i), visit)
+	}
+}
+
+func (r *sdlRenderer) fieldType(
+	field protoreflect.FieldDescriptor,
+	visit func(protoreflect.MessageDescriptor) string,
+) string {
+	if field.IsMap() {
+		r.jsonScalar = true
+		return jsonScalar
+	}
+	var name string
+	switch field.Kind() {
+	case protoreflect.BoolKind:
+		name = "Boolean"
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		name = "Int"
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		name = "Float"
+	case protoreflect.EnumKind:
+		enum := field.Enum()
+		if enum.FullName() == "google.protobuf.NullValue" {
+			r.jsonScalar = true
+			name = jsonScalar
+		} else {
+			name = typeName(enum.FullName())
+			r.enums[name] = enum
+		}
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		name = visit(field.Message())
+	default:
+		// Strings, bytes, and 64-bit integers are all JSON strings.
+		name = "String"
+	}
+	if field.IsList() {
+		return "[" + name + "!]"
+	}
+	return name
+}
+
+func (r *sdlRenderer) render(builder *strings.Builder) {
+	// Rendering fields may discover more types, so keep going until we've
+	// rendered everything.
+	rendered := make(map[string]bool)
+	for {
+		var pending []string
+		for name := range r.outputs {
+			if !rendered["type "+name] {
+				pending = append(pending, "type "+name)
+			}
+		}
+		for name := range r.inputs {
+			if !rendered["input "+name] {
+				pending = append(pending, "input "+name)
+			}
+		}
+		if len(pending) == 0 {
+			break
+		}
+		sort.Slice(pending, func(i, j int) bool {
+			return strings.SplitN(pending[i], " ", 2)[1] < strings.SplitN(pending[j], " ", 2)[1]
+		})
+		for _, key := range pending {
+			rendered[key] = true
+			kind, name, _ := strings.Cut(key, " ")
+			message, visit := r.outputs[name], r.output
+			if kind == "input" {
+				message, visit = r.inputs[name], r.input
+			}
+			builder.WriteString(kind + " " + name + " {\n")
+			fields := message.Fields()
+			for i := 0; i < fields.Len(); i++ {
+				field := fields.Get(i)
+				builder.WriteString("  " + field.JSONName() + ": " + r.fieldType(field, visit) + "\n")
+			}
+			builder.WriteString("}\n")
+		}
+	}
+	enumNames := make([]string, 0, len(r.enums))
+	for name := range r.enums {
+		enumNames = append(enumNames, name)
+	}
+	sort.Strings(enumNames)
+	for _, name := range enumNames {
+		builder.WriteString("enum " + name + " {\n")
+		values := r.enums[name].Values()
+		for i := 0; i < values.Len(); i++ {
+			builder.WriteString("  " + string(values.Get(i).Name()) + "\n")
+		}
+		builder.WriteString("}\n")
+	}
+	if r.jsonScalar {
+		builder.WriteString("scalar " + jsonScalar + "\n")
+	}
+}
@@ -17,6 +17,9 @@ package connect
 import (
 	"context"
 	"net/http"
+	"time"
+
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
 // A Handler is the server-side implementation of a single RPC defined by a
@@ -24,55 +27,64 @@ import (
 //
 // By default, Handlers support the Connect, gRPC, and gRPC-Web protocols with
 // the binary Protobuf and JSON codecs. They support gzip compression using the
-// standard library's compress/gzip.
+// standard library's compress/gzip. Handlers choose a protocol for each request
+// by inspecting its Content-Type, so a single route serves clients using any
+// of the supported protocols. Bidirectional streaming requires HTTP/2, but all
+// other stream types work over HTTP/1.1 too.
+//
+// If the client disconnects or the call's deadline passes, the context passed
+// to the implementation is canceled immediately. Pending and subsequent calls
+// to Send and Receive fail with CodeCanceled or CodeDeadlineExceeded, so
+// long-running streaming handlers can stop promptly.
 type Handler struct {
 	spec             Spec
 	interceptor      Interceptor
 	implementation   func(context.Context, Sender, Receiver, error /* client-visible */)
 	protocolHandlers []protocolHandler
-	acceptPost       string // Accept-Post header
+	acceptPost       string          // Accept-Post header
+	slowRequestLog   *slowRequestLog // nil unless logging slow requests
+	onFinish         *onFinish       // nil unless configured
+	contextValues    func(context.Context, Spec) context.Context
+	validator        Validator          // nil unless validating requests
+	idleTimeout      *idleStreamTimeout // nil unless configured
+	memoryBudget     *MemoryBudget      // nil unless configured
+	scheduler        *PriorityScheduler // nil unless configured
+	maxHeaderBytes   int                // zero unless configured
+	writeTimeout     *writeTimeout      // nil unless configured
+	bandwidth        *bandwidthLimit    // nil unless configured
+	httpBody         protocolHandler    // nil unless serving raw google.api.HttpBody calls
 }
 
+var _ http.Handler = (*Handler)(nil)
+
 // NewUnaryHandler constructs a Handler for a request-response procedure.
+//
+// To serve google.api.HttpBody requests or responses to plain HTTP callers,
+// see WithHTTPBody.
 func NewUnaryHandler[Req, Res any](
 	procedure string,
 	unary func(context.Context, *Request[Req]) (*Response[Res], error),
 	options ...HandlerOption,
 ) *Handler {
 	config := newHandlerConfig(procedure, options)
+	cache := config.idempotencyCache()
+	unary = withUnaryResponseMetadata(unary)
 	// Given a (possibly failed) stream, how should we call the unary function?
 	implementation := func(ctx context.Context, sender Sender, receiver Receiver, clientVisibleError error) {
 		defer receiver.Close()
 
-		var request *Request[Req]
-		if clientVisibleError != nil {
-			// The protocol implementation failed to establish a stream. To make the
-			// resulting error visible to the interceptor stack, we still want to
-			// call the wrapped unary Func. To do that safely, we need a useful
-			// Message struct. (Note that we do *not* actually calling the handler's
-			// implementation.)
-			request = &Request[Req]{
-				Msg:    new(Req),
-				spec:   receiver.Spec(),
-				header: receiver.Header(),
-			}
-		} else {
-			var msg Req
-			if err := receiver.Receive(&msg); err != nil {
-				// Interceptors should see this error too. Just as above, they need a
-				// useful Message.
+		// If the protocol implementation failed to establish a stream, we still
+		// want to call the wrapped unary Func to make the resulting error visible
+		// to the interceptor stack. (Note that we do *not* actually call the
+		// handler's implementation.) Either way, interceptors need a useful
+		// Message.
+		request := newReceivedRequest[Req](receiver.Spec(), receiver.Header())
+		if clientVisibleError == nil {
+			if err := receiver.Receive(request.Msg); err != nil {
+				// Interceptors should see this error too. Rather than showing them a
+				// partially-unmarshaled message, start over with an empty one.
 				clientVisibleError = err
-				request = &Request[Req]{
-					Msg:    new(Req),
-					spec:   receiver.Spec(),
-					header: receiver.Header(),
-				}
-			} else {
-				request = &Request[Req]{
-					Msg:    &msg,
-					spec:   receiver.Spec(),
-					header: receiver.Header(),
-				}
+				request.Msg = new(Req)
 			}
 		}
 
@@ -89,7 +101,7 @@ func NewUnaryHandler[Req, Res any](
 			if !ok {
 				return nil, errorf(CodeInternal, "unexpected handler request type %T", request)
 			}
-			res, err := unary(ctx, typed)
+			res, err := callIdempotent(ctx, cache, typed, unary)
 			if err != nil {
 				return nil, err
 			}
@@ -112,12 +124,26 @@ func NewUnaryHandler[Req, Res any](
 	}
 
 	protocolHandlers := config.newProtocolHandlers(StreamTypeUnary)
+	var httpBody protocolHandler
+	if config.HTTPBody && (isHTTPBody(new(Req)) || isHTTPBody(new(Res))) {
+		httpBody = config.newHTTPBodyHandler()
+	}
 	return &Handler{
 		spec:             config.newSpec(StreamTypeUnary),
 		interceptor:      nil, // already applied
 		implementation:   implementation,
 		protocolHandlers: protocolHandlers,
 		acceptPost:       sortedAcceptPostValue(protocolHandlers),
+		slowRequestLog:   config.SlowRequestLog,
+		onFinish:         config.onFinish(),
+		contextValues:    config.ContextValues,
+		validator:        config.validator(),
+		memoryBudget:     config.BufferPool.budget,
+		scheduler:        config.PriorityScheduler,
+		maxHeaderBytes:   config.MaxHeaderBytes,
+		writeTimeout:     config.writeTimeout(),
+		bandwidth:        config.bandwidthLimit(),
+		httpBody:         httpBody,
 	}
 }
 
@@ -126,12 +152,22 @@ func NewClientStreamHandler[Req, Res any](
 	procedure string,
 	implementation func(context.Context, *ClientStream[Req]) (*Response[Res], error),
 	options ...HandlerOption,
+) *Handler {
+	return newClientStreamHandler(newHandlerConfig(procedure, options), implementation)
+}
+
+func newClientStreamHandler[Req, Res any](
+	config *handlerConfig,
+	implementation func(context.Context, *ClientStream[Req]) (*Response[Res], error),
 ) *Handler {
 	return newStreamHandler(
-		procedure,
+		config,
 		StreamTypeClient,
 		func(ctx context.Context, sender Sender, receiver Receiver) {
 			stream := &ClientStream[Req]{receiver: receiver}
+			if config.lazyUnmarshal() {
+				stream.lazy = &lazyMessage{}
+			}
 			res, err := implementation(ctx, stream)
 			if err != nil {
 				_ = receiver.Close()
@@ -148,7 +184,6 @@ func NewClientStreamHandler[Req, Res any](
 			}
 			_ = sender.Close(sender.Send(res.Msg))
 		},
-		options...,
 	)
 }
 
@@ -157,9 +192,16 @@ func NewServerStreamHandler[Req, Res any](
 	procedure string,
 	implementation func(context.Context, *Request[Req], *ServerStream[Res]) error,
 	options ...HandlerOption,
+) *Handler {
+	return newServerStreamHandler(newHandlerConfig(procedure, options), implementation)
+}
+
+func newServerStreamHandler[Req, Res any](
+	config *handlerConfig,
+	implementation func(context.Context, *Request[Req], *ServerStream[Res]) error,
 ) *Handler {
 	return newStreamHandler(
-		procedure,
+		config,
 		StreamTypeServer,
 		func(ctx context.Context, sender Sender, receiver Receiver) {
 			stream := &ServerStream[Res]{sender: sender}
@@ -181,7 +223,6 @@ func NewServerStreamHandler[Req, Res any](
 			err := implementation(ctx, request, stream)
 			_ = sender.Close(err)
 		},
-		options...,
 	)
 }
 
@@ -192,7 +233,7 @@ func NewBidiStreamHandler[Req, Res any](
 	options ...HandlerOption,
 ) *Handler {
 	return newStreamHandler(
-		procedure,
+		newHandlerConfig(procedure, options),
 		StreamTypeBidi,
 		func(ctx context.Context, sender Sender, receiver Receiver) {
 			stream := &BidiStream[Req, Res]{sender: sender, receiver: receiver}
@@ -200,7 +241,6 @@ func NewBidiStreamHandler[Req, Res any](
 			_ = receiver.Close()
 			_ = sender.Close(err)
 		},
-		options...,
 	)
 }
 
@@ -210,25 +250,37 @@ func (h *Handler) ServeHTTP(responseWriter http.ResponseWriter, request *http.Re
 	// EOF: the stream we construct later on already does that, and we only
 	// return early when dealing with misbehaving clients. In those cases, it's
 	// okay if we can't re-use the connection.
+	// The gRPC-HTTP2, gRPC-Web, and Connect protocols are all POST-only. Check
+	// the method first, so that scanners and health checkers probing with GET
+	// always get a 405 with an Allow header, whatever the stream type.
+	if request.Method != http.MethodPost {
+		responseWriter.Header().Set("Allow", http.MethodPost)
+		responseWriter.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
 	isBidi := (h.spec.StreamType & StreamTypeBidi) == StreamTypeBidi
 	if isBidi && request.ProtoMajor < 2 {
 		responseWriter.WriteHeader(http.StatusHTTPVersionNotSupported)
 		return
 	}
 
-	// The gRPC-HTTP2, gRPC-Web, and Connect protocols are all POST-only.
-	if request.Method != http.MethodPost {
-		responseWriter.Header().Set("Allow", http.MethodPost)
-		responseWriter.WriteHeader(http.StatusMethodNotAllowed)
-		return
+	// Clients and proxies may add parameters (like charset) to the
+	// Content-Type or vary its case. Protocol handlers rely on the canonical
+	// form to choose a codec, so rewrite the header if necessary.
+	contentType := canonicalizeContentType(request.Header.Get(headerContentType))
+	if contentType != request.Header.Get(headerContentType) {
+		request.Header.Set(headerContentType, contentType)
 	}
-
-	contentType := request.Header.Get("Content-Type")
 	var protocolHandler protocolHandler
-	for _, handler := range h.protocolHandlers {
-		if _, ok := handler.ContentTypes()[contentType]; ok {
-			protocolHandler = handler
-			break
+	if h.httpBody != nil && isHTTPBodyContentType(contentType) {
+		protocolHandler = h.httpBody
+	} else {
+		for _, handler := range h.protocolHandlers {
+			if _, ok := handler.ContentTypes()[contentType]; ok {
+				protocolHandler = handler
+				break
+			}
 		}
 	}
 	if protocolHandler == nil {
@@ -243,8 +295,23 @@ func (h *Handler) ServeHTTP(responseWriter http.ResponseWriter, request *http.Re
 	if cancel != nil {
 		defer cancel()
 	}
+	var idle *idleTimer
+	if idleTimeout := h.idleTimeout; idleTimeout != nil {
+		var stop func()
+		ctx, idle, stop = idleTimeout.start(ctx, request.Body)
+		defer stop()
+	}
+	ctx = withIdempotencyKey(ctx, request.Header)
+	ctx = withCaller(ctx, Caller{
+		UserAgent: request.Header.Get(headerUserAgent),
+		Protocol:  protocolHandler.Protocol(),
+		Codec:     protocolHandler.CodecName(contentType),
+	})
+	if contextValues := h.contextValues; contextValues != nil {
+		ctx = contextValues(ctx, h.spec)
+	}
 	if ic := h.interceptor; ic != nil {
-		ctx = ic.WrapStreamContext(ctx)
+		ctx = ic.WrapStreamContext(withSpec(ctx, h.spec))
 	}
 	// Most errors returned from protocolHandler.NewStream are caused by
 	// invalid requests. For example, the client may have specified an invalid
@@ -268,24 +335,171 @@ func (h *Handler) ServeHTTP(responseWriter http.ResponseWriter, request *http.Re
 	if clientVisibleError != nil && receiver == nil {
 		receiver = newNopReceiver(h.spec, request.Header, request.Trailer)
 	}
+	sender = newReservedHeaderSender(sender)
+	if h.writeTimeout != nil {
+		sender = &writeTimeoutSender{Sender: sender, writer: responseWriter, config: h.writeTimeout}
+	}
+	h.serveWithOptions(ctx, sender, receiver, idle, request.RemoteAddr, clientVisibleError)
+}
+
+// serveWithOptions applies the handler options that don't depend on the
+// transport to a stream, then calls the implementation. Both ServeHTTP and
+// ServeStream use it, so the options apply to streams however they arrive.
+func (h *Handler) serveWithOptions(
+	ctx context.Context,
+	sender Sender,
+	receiver Receiver,
+	idle *idleTimer,
+	peer string,
+	clientVisibleError error,
+) {
+	if clientVisibleError == nil && h.maxHeaderBytes > 0 {
+		if size := headerSize(receiver.Header()); size > h.maxHeaderBytes {
+			clientVisibleError = errorf(
+				CodeResourceExhausted, "request headers are %d bytes, exceeding limit of %d", size, h.maxHeaderBytes,
+			)
+		}
+	}
+	if clientVisibleError == nil && h.memoryBudget.exhausted() {
+		clientVisibleError = errorf(CodeResourceExhausted, "server memory budget exhausted")
+	}
+	if scheduler := h.scheduler; scheduler != nil && clientVisibleError == nil {
+		release, err := scheduler.acquire(ctx, priorityFromHeader(receiver.Header()))
+		if err != nil {
+			clientVisibleError = err
+		} else {
+			defer release()
+		}
+	}
+	if idle != nil {
+		sender = &idleSender{Sender: sender, timer: idle}
+		receiver = &idleReceiver{Receiver: receiver, timer: idle}
+	}
+	if bandwidth := h.bandwidth; bandwidth != nil {
+		sender, receiver = bandwidth.wrap(ctx, sender, receiver)
+	}
+	if validator := h.validator; validator != nil {
+		receiver = &validatingReceiver{Receiver: receiver, validator: validator}
+	}
+	if onFinish := h.onFinish; onFinish != nil {
+		// Below the interceptors, so we see exactly what's sent to the client.
+		var recorder *finishRecorder
+		sender, receiver, recorder = onFinish.start(sender, receiver)
+		defer recorder.finish(ctx, h.spec)
+	}
 	if interceptor := h.interceptor; interceptor != nil {
 		// Unary interceptors were handled in NewUnaryHandler.
 		sender = interceptor.WrapStreamSender(ctx, sender)
 		receiver = interceptor.WrapStreamReceiver(ctx, receiver)
 	}
+	if slowLog := h.slowRequestLog; slowLog != nil {
+		finish := slowLog.start(ctx, h.spec, peer)
+		if h.spec.StreamType == StreamTypeUnary {
+			defer finish()
+		} else {
+			sender = &slowLogSender{Sender: sender, finish: finish}
+		}
+	}
 	h.implementation(ctx, sender, receiver, clientVisibleError)
 }
 
+// Procedure returns the procedure served by the Handler, in the form
+// "/acme.foo.v1.FooService/Bar".
+func (h *Handler) Procedure() string {
+	return h.spec.Procedure
+}
+
+// Path returns the URL path the Handler expects to be mounted on. Handlers
+// are http.Handlers, so to wrap individual procedures in their own middleware
+// or mount them on a third-party router, use the Path as the route:
+//
+//	handler := connect.NewUnaryHandler("/acme.foo.v1.FooService/Bar", bar)
+//	mux.Handle(handler.Path(), auditMiddleware(handler))
+//
+// Clients append the procedure to their base URL, so the Path is always the
+// same as the Procedure.
+func (h *Handler) Path() string {
+	return h.spec.Procedure
+}
+
+// ServeStream serves a stream carried by a custom Transport. The Sender
+// delivers response messages, headers, and trailers to the client, and the
+// Receiver delivers request messages and headers from the client. ServeStream
+// applies the Handler's interceptors and options and returns when the RPC is
+// complete, after closing both the Sender and the Receiver.
+//
+// Options that act on the HTTP connection don't apply: WithWriteTimeout needs
+// an http.ResponseWriter, and timeouts requested by clients are enforced by
+// the Transport. When the idle stream timeout expires, ServeStream closes the
+// Receiver to interrupt pending calls to Receive.
+//
+// Handlers served over HTTP don't need to call ServeStream.
+func (h *Handler) ServeStream(ctx context.Context, sender Sender, receiver Receiver) {
+	h.serveStream(ctx, newReservedHeaderSender(sender), receiver)
+}
+
+// serveStream is ServeStream without the guard on reserved headers, for
+// adapters like NewDownloadHandler that don't speak an RPC protocol and let
+// implementations set the Content-Type.
+func (h *Handler) serveStream(ctx context.Context, sender Sender, receiver Receiver) {
+	var idle *idleTimer
+	if idleTimeout := h.idleTimeout; idleTimeout != nil {
+		var stop func()
+		ctx, idle, stop = idleTimeout.start(ctx, receiver)
+		defer stop()
+	}
+	ctx = withIdempotencyKey(ctx, receiver.Header())
+	if contextValues := h.contextValues; contextValues != nil {
+		ctx = contextValues(ctx, h.spec)
+	}
+	if interceptor := h.interceptor; interceptor != nil {
+		ctx = interceptor.WrapStreamContext(withSpec(ctx, h.spec))
+	}
+	sender, receiver = wrapHandlerStreamWithCodedErrors(ctx, sender, receiver)
+	h.serveWithOptions(ctx, sender, receiver, idle, "" /* peer */, nil /* clientVisibleError */)
+}
+
 type handlerConfig struct {
-	CompressionPools map[string]*compressionPool
-	CompressionNames []string
-	Codecs           map[string]Codec
-	CompressMinBytes int
-	Interceptor      Interceptor
-	Procedure        string
-	HandleGRPC       bool
-	HandleGRPCWeb    bool
-	BufferPool       *bufferPool
+	CompressionPools    map[string]*compressionPool
+	CompressionNames    []string
+	Codecs              map[string]Codec
+	CompressMinBytes    int
+	CompressionSelector func(procedure string, acceptEncoding []string) string
+	Interceptor         Interceptor
+	Procedure           string
+	HandleGRPC          bool
+	HandleGRPCWeb       bool
+	LazyUnmarshal       bool
+	SlowRequestLog      *slowRequestLog
+	BufferPool          *bufferPool
+	Clock               Clock
+	Checksums           bool
+	MessageTransformer  *messageTransformer
+	OnFinish            func(context.Context, Spec, error, Stats)
+	ContextValues       func(context.Context, Spec) context.Context
+	Validator           Validator
+	Validation          bool
+	IdleStreamTimeout   time.Duration
+	MemoryBudget        *MemoryBudget
+	PriorityScheduler   *PriorityScheduler
+	MaxHeaderBytes      int
+	AllowedCodecs       []string // nil allows all
+	AllowedCompression  []string // nil allows all
+	ResponseCompression []string // nil allows all
+	RequireCompression  bool
+	IdempotencyStore    IdempotencyStore
+	IdempotencyTTL      time.Duration
+	IdempotencyScope    func(context.Context, AnyRequest) (string, error)
+	IdempotencyLevel    IdempotencyLevel
+	WriteTimeout        time.Duration
+	BandwidthLimiter    *BandwidthLimiter
+	StreamBandwidth     int64
+	Types               *protoregistry.Types
+	HTTPBody            bool
+	DownloadFilename    string
+	DownloadContentType string
+	UploadChunkSize     int
+	UploadMaxBytes      int64
 }
 
 func newHandlerConfig(procedure string, options []HandlerOption) *handlerConfig {
@@ -297,6 +511,7 @@ func newHandlerConfig(procedure string, options []HandlerOption) *handlerConfig
 		HandleGRPC:       true,
 		HandleGRPCWeb:    true,
 		BufferPool:       newBufferPool(),
+		Clock:            systemClock{},
 	}
 	withProtoBinaryCodec().applyToHandler(&config)
 	withProtoJSONCodec().applyToHandler(&config)
@@ -304,14 +519,125 @@ func newHandlerConfig(procedure string, options []HandlerOption) *handlerConfig
 	for _, opt := range options {
 		opt.applyToHandler(&config)
 	}
+	config.BufferPool.budget = config.MemoryBudget
+	config.restrictEncodings()
+	config.resolveTypes()
+	if config.SlowRequestLog != nil {
+		config.SlowRequestLog.clock = config.Clock
+	}
 	return &config
 }
 
+func (c *handlerConfig) idempotencyCache() *idempotencyCache {
+	if c.IdempotencyStore == nil || c.IdempotencyTTL <= 0 {
+		return nil
+	}
+	return &idempotencyCache{
+		store: c.IdempotencyStore,
+		ttl:   c.IdempotencyTTL,
+		scope: c.IdempotencyScope,
+		clock: c.Clock,
+		codec: newReadOnlyCodecs(c.Codecs).Protobuf(),
+	}
+}
+
+// restrictEncodings removes any codecs and compressors that aren't allowed.
+// It runs after all options are applied, so it doesn't matter whether
+// WithAllowedCodecs and WithAllowedCompression come before or after the
+// options registering codecs and compressors.
+func (c *handlerConfig) restrictEncodings() {
+	if c.AllowedCodecs != nil {
+		allowed := stringSet(c.AllowedCodecs)
+		for name := range c.Codecs {
+			if _, ok := allowed[name]; !ok {
+				delete(c.Codecs, name)
+			}
+		}
+	}
+	if c.AllowedCompression != nil {
+		allowed := stringSet(c.AllowedCompression)
+		names := c.CompressionNames[:0]
+		for _, name := range c.CompressionNames {
+			if _, ok := allowed[name]; ok {
+				names = append(names, name)
+			} else {
+				delete(c.CompressionPools, name)
+			}
+		}
+		c.CompressionNames = names
+		_, identity := allowed[compressionIdentity]
+		c.RequireCompression = !identity
+	}
+}
+
+// resolveTypes configures the default JSON codec to resolve Any fields with
+// the types registered by WithTypeRegistry. Custom codecs are left alone.
+func (c *handlerConfig) resolveTypes() {
+	if c.Types == nil {
+		return
+	}
+	if _, ok := c.Codecs[codecNameJSON].(*protoJSONCodec); ok {
+		c.Codecs[codecNameJSON] = &protoJSONCodec{types: c.Types}
+	}
+}
+
+func stringSet(values []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		set[value] = struct{}{}
+	}
+	return set
+}
+
+// lazyUnmarshal reports whether streams should defer unmarshaling. Like
+// clientConfig.lazyUnmarshal, it's disabled when there are interceptors or a
+// validator.
+func (c *handlerConfig) lazyUnmarshal() bool {
+	return c.LazyUnmarshal && c.Interceptor == nil && c.validator() == nil
+}
+
 func (c *handlerConfig) newSpec(streamType StreamType) Spec {
 	return Spec{
-		Procedure:  c.Procedure,
-		StreamType: streamType,
+		Procedure:        c.Procedure,
+		StreamType:       streamType,
+		IdempotencyLevel: c.IdempotencyLevel,
+	}
+}
+
+// validator returns the Validator to use, or nil if validation is disabled.
+func (c *handlerConfig) validator() Validator {
+	if !c.Validation {
+		return nil
+	}
+	return c.Validator
+}
+
+func (c *handlerConfig) idleStreamTimeout() *idleStreamTimeout {
+	if c.IdleStreamTimeout <= 0 {
+		return nil
+	}
+	return &idleStreamTimeout{timeout: c.IdleStreamTimeout, clock: c.Clock}
+}
+
+func (c *handlerConfig) writeTimeout() *writeTimeout {
+	if c.WriteTimeout <= 0 {
+		return nil
 	}
+	return &writeTimeout{timeout: c.WriteTimeout, clock: c.Clock}
+}
+
+func (c *handlerConfig) bandwidthLimit() *bandwidthLimit {
+	if c.BandwidthLimiter == nil && c.StreamBandwidth <= 0 {
+		return nil
+	}
+	return &bandwidthLimit{shared: c.BandwidthLimiter, perStream: c.StreamBandwidth, clock: c.Clock}
+}
+
+func (c *handlerConfig) onFinish() *onFinish {
+	if c.OnFinish == nil {
+		return nil
+	}
+	return &onFinish{callback: c.OnFinish, clock: c.Clock}
 }
 
 func (c *handlerConfig) newProtocolHandlers(streamType StreamType) []protocolHandler {
@@ -323,30 +649,64 @@ func (c *handlerConfig) newProtocolHandlers(streamType StreamType) []protocolHan
 		protocols = append(protocols, &protocolGRPC{web: true})
 	}
 	handlers := make([]protocolHandler, 0, len(protocols))
+	params := c.newProtocolHandlerParams(streamType)
+	for _, protocol := range protocols {
+		handlers = append(handlers, protocol.NewHandler(params))
+	}
+	return handlers
+}
+
+// newHTTPBodyHandler constructs the handler for raw google.api.HttpBody
+// calls. Messages that aren't HttpBodies are read and written as JSON.
+func (c *handlerConfig) newHTTPBodyHandler() protocolHandler {
+	params := c.newProtocolHandlerParams(StreamTypeUnary)
+	fallback := params.Codecs.Get(codecNameJSON)
+	if fallback == nil {
+		fallback = &protoJSONCodec{}
+	}
+	return newHTTPBodyHandler(params, fallback)
+}
+
+func (c *handlerConfig) newProtocolHandlerParams(streamType StreamType) *protocolHandlerParams {
 	codecs := newReadOnlyCodecs(c.Codecs)
+	if c.MessageTransformer != nil {
+		codecs = newTransformingCodecs(c.Codecs, c.MessageTransformer)
+	}
 	compressors := newReadOnlyCompressionPools(
 		c.CompressionPools,
 		c.CompressionNames,
 	)
-	for _, protocol := range protocols {
-		handlers = append(handlers, protocol.NewHandler(&protocolHandlerParams{
-			Spec:             c.newSpec(streamType),
-			Codecs:           codecs,
-			CompressionPools: compressors,
-			CompressMinBytes: c.CompressMinBytes,
-			BufferPool:       c.BufferPool,
-		}))
+	responseCompressors := compressors
+	if c.ResponseCompression != nil {
+		allowed := stringSet(c.ResponseCompression)
+		pools := make(map[string]*compressionPool, len(c.CompressionPools))
+		for name, pool := range c.CompressionPools {
+			if _, ok := allowed[name]; ok {
+				pools[name] = pool
+			}
+		}
+		responseCompressors = newReadOnlyCompressionPools(pools, nil /* reversedNames */)
+	}
+	return &protocolHandlerParams{
+		Spec:                     c.newSpec(streamType),
+		Codecs:                   codecs,
+		CompressionPools:         compressors,
+		ResponseCompressionPools: responseCompressors,
+		CompressMinBytes:         c.CompressMinBytes,
+		CompressionSelector:      c.CompressionSelector,
+		BufferPool:               c.BufferPool,
+		Clock:                    c.Clock,
+		Checksums:                c.Checksums,
+		RequireCompression:       c.RequireCompression,
+		Types:                    c.Types,
 	}
-	return handlers
 }
 
 func newStreamHandler(
-	procedure string,
+	config *handlerConfig,
 	streamType StreamType,
 	implementation func(context.Context, Sender, Receiver),
-	options ...HandlerOption,
 ) *Handler {
-	config := newHandlerConfig(procedure, options)
 	protocolHandlers := config.newProtocolHandlers(streamType)
 	return &Handler{
 		spec:        config.newSpec(streamType),
@@ -357,9 +717,19 @@ func newStreamHandler(
 				_ = sender.Close(clientVisibleErr)
 				return
 			}
-			implementation(ctx, sender, receiver)
+			implementation(withStreamResponseMetadata(ctx, sender), sender, receiver)
 		},
 		protocolHandlers: protocolHandlers,
 		acceptPost:       sortedAcceptPostValue(protocolHandlers),
+		slowRequestLog:   config.SlowRequestLog,
+		onFinish:         config.onFinish(),
+		contextValues:    config.ContextValues,
+		validator:        config.validator(),
+		idleTimeout:      config.idleStreamTimeout(),
+		memoryBudget:     config.BufferPool.budget,
+		scheduler:        config.PriorityScheduler,
+		maxHeaderBytes:   config.MaxHeaderBytes,
+		writeTimeout:     config.writeTimeout(),
+		bandwidth:        config.bandwidthLimit(),
 	}
 }
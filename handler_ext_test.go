@@ -15,17 +15,32 @@
 package connect_test
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"math/rand"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/bufbuild/connect-go"
 	"github.com/bufbuild/connect-go/internal/assert"
 	pingv1 "github.com/bufbuild/connect-go/internal/gen/connect/ping/v1"
 	"github.com/bufbuild/connect-go/internal/gen/connect/ping/v1/pingv1connect"
+	apipb "github.com/bufbuild/connect-go/internal/gen/connectext/google/api"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 func TestHandler_ServeHTTP(t *testing.T) {
@@ -50,6 +65,23 @@ func TestHandler_ServeHTTP(t *testing.T) {
 		assert.Equal(t, resp.Header.Get("Allow"), http.MethodPost)
 	})
 
+	t.Run("method_not_allowed_other_methods", func(t *testing.T) {
+		t.Parallel()
+		const cumSumProcedure = "/" + pingv1connect.PingServiceName + "/CumSum"
+		// Bidi procedures require HTTP/2, but the method is checked first.
+		for _, procedure := range []string{pingProcedure, cumSumProcedure} {
+			for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete} {
+				req, err := http.NewRequest(method, server.URL+procedure, nil)
+				assert.Nil(t, err)
+				resp, err := client.Do(req)
+				assert.Nil(t, err)
+				resp.Body.Close()
+				assert.Equal(t, resp.StatusCode, http.StatusMethodNotAllowed, assert.Sprintf("%s %s", method, procedure))
+				assert.Equal(t, resp.Header.Get("Allow"), http.MethodPost)
+			}
+		}
+	})
+
 	t.Run("unsupported_content_type", func(t *testing.T) {
 		t.Parallel()
 		resp, err := client.Post(server.URL+pingProcedure, "application/x-custom-json", strings.NewReader("{}"))
@@ -68,6 +100,15 @@ func TestHandler_ServeHTTP(t *testing.T) {
 		}, ", "))
 	})
 
+	t.Run("non_canonical_content_type", func(t *testing.T) {
+		t.Parallel()
+		resp, err := client.Post(server.URL+pingProcedure, "Application/JSON; charset=utf-8", strings.NewReader("{}"))
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, resp.StatusCode, http.StatusOK)
+		assert.Equal(t, resp.Header.Get("Content-Type"), "application/json")
+	})
+
 	t.Run("unsupported_content_encoding", func(t *testing.T) {
 		t.Parallel()
 		req, err := http.NewRequest(http.MethodPost, server.URL+pingProcedure, strings.NewReader("{}"))
@@ -97,3 +138,1927 @@ type successPingServer struct {
 func (successPingServer) Ping(context.Context, *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
 	return &connect.Response[pingv1.PingResponse]{}, nil
 }
+
+func TestHandlerPath(t *testing.T) {
+	t.Parallel()
+	const pingProcedure = "/" + pingv1connect.PingServiceName + "/Ping"
+	handler := connect.NewUnaryHandler(
+		strings.TrimPrefix(pingProcedure, "/"),
+		successPingServer{}.Ping,
+	)
+	assert.Equal(t, handler.Procedure(), pingProcedure)
+	assert.Equal(t, handler.Path(), pingProcedure)
+
+	// Handlers can be mounted individually, each with its own middleware.
+	var intercepted bool
+	mux := http.NewServeMux()
+	mux.Handle(handler.Path(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		intercepted = true
+		handler.ServeHTTP(w, r)
+	}))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL)
+	_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+	assert.Nil(t, err)
+	assert.True(t, intercepted)
+}
+
+func TestServeMux(t *testing.T) {
+	t.Parallel()
+	mux := connect.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL)
+	ping := func() (*connect.Response[pingv1.PingResponse], error) {
+		return client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 1}))
+	}
+
+	_, err := ping()
+	assert.Equal(t, connect.CodeOf(err), connect.CodeUnimplemented)
+
+	servicePath, serviceHandler := pingv1connect.NewPingServiceHandler(pingServer{})
+	mux.Handle(servicePath, serviceHandler)
+	response, err := ping()
+	assert.Nil(t, err)
+	assert.Equal(t, response.Msg.Number, 1)
+
+	// Procedures take precedence over services.
+	override := connect.NewUnaryHandler(
+		"/"+pingv1connect.PingServiceName+"/Ping",
+		func(context.Context, *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+			return connect.NewResponse(&pingv1.PingResponse{Number: 42}), nil
+		},
+	)
+	mux.Handle(override.Path(), override)
+	response, err = ping()
+	assert.Nil(t, err)
+	assert.Equal(t, response.Msg.Number, 42)
+
+	assert.True(t, mux.Remove(override.Path()))
+	assert.False(t, mux.Remove(override.Path()))
+	response, err = ping()
+	assert.Nil(t, err)
+	assert.Equal(t, response.Msg.Number, 1)
+	assert.True(t, mux.Remove(servicePath))
+	_, err = ping()
+	assert.Equal(t, connect.CodeOf(err), connect.CodeUnimplemented)
+
+	// Paths outside any service are matched exactly.
+	mux.Handle("/healthz", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	for path, status := range map[string]int{
+		"/healthz":   http.StatusNoContent,
+		"/healthz/":  http.StatusNotFound,
+		"/healthzz":  http.StatusNotFound,
+		"/a/healthz": http.StatusNotFound,
+	} {
+		response, err := server.Client().Get(server.URL + path)
+		assert.Nil(t, err)
+		assert.Equal(t, response.StatusCode, status, assert.Sprintf(path))
+		response.Body.Close()
+	}
+
+	// Changes are safe while requests are in flight.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				mux.Handle(servicePath, serviceHandler)
+				_, _ = ping()
+				mux.Remove(servicePath)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestServeMuxLifecycle(t *testing.T) {
+	t.Parallel()
+	mux := connect.NewServeMux()
+	started, release := make(chan struct{}), make(chan struct{})
+	blocking := connect.NewUnaryHandler(
+		"/"+pingv1connect.PingServiceName+"/Ping",
+		func(context.Context, *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+			close(started)
+			<-release
+			return connect.NewResponse(&pingv1.PingResponse{Number: 1}), nil
+		},
+	)
+	mux.Handle(blocking.Path(), blocking)
+
+	var mu sync.Mutex
+	var served, flushed []string
+	mux.OnServe(func(procedure string) {
+		mu.Lock()
+		defer mu.Unlock()
+		served = append(served, procedure)
+	})
+	mux.OnShutdown(func(_ context.Context, procedure string) {
+		mu.Lock()
+		defer mu.Unlock()
+		flushed = append(flushed, procedure)
+	})
+	servicePath, serviceHandler := pingv1connect.NewPingServiceHandler(pingServer{})
+	mux.Handle(servicePath, serviceHandler)
+	// The service prefix is expanded into its procedures, except for Ping,
+	// which has its own route.
+	procedures := []string{blocking.Path()}
+	methods := pingv1.File_connect_ping_v1_ping_proto.Services().ByName("PingService").Methods()
+	for i := 0; i < methods.Len(); i++ {
+		if procedure := servicePath + string(methods.Get(i).Name()); procedure != blocking.Path() {
+			procedures = append(procedures, procedure)
+		}
+	}
+	mu.Lock()
+	assert.Equal(t, served, procedures)
+	mu.Unlock()
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL)
+	pingErr := make(chan error, 1)
+	go func() {
+		_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		pingErr <- err
+	}()
+	<-started
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- mux.Shutdown(context.Background())
+	}()
+	// Wait for Shutdown to start draining, then check that new requests are
+	// rejected while the in-flight request is still running.
+	for {
+		_, err := client.Sum(context.Background()).CloseAndReceive()
+		if connect.CodeOf(err) == connect.CodeUnavailable {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	mu.Lock()
+	assert.Zero(t, len(flushed))
+	mu.Unlock()
+
+	close(release)
+	assert.Nil(t, <-pingErr)
+	assert.Nil(t, <-shutdownErr)
+	mu.Lock()
+	sort.Strings(procedures)
+	assert.Equal(t, flushed, procedures)
+	mu.Unlock()
+
+	// Later calls don't rerun the hooks.
+	assert.Nil(t, mux.Shutdown(context.Background()))
+	mu.Lock()
+	assert.Equal(t, len(flushed), len(procedures))
+	mu.Unlock()
+}
+
+func TestServeMuxOnServeConcurrent(t *testing.T) {
+	t.Parallel()
+	const paths = 50
+	mux := connect.NewServeMux()
+	var mu sync.Mutex
+	served := make(map[string]int)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		mux.OnServe(func(procedure string) {
+			mu.Lock()
+			defer mu.Unlock()
+			served[procedure]++
+		})
+	}()
+	for i := 0; i < paths; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			mux.Handle("/acme.foo.v1.FooService/Method"+strconv.Itoa(i), http.NotFoundHandler())
+		}(i)
+	}
+	wg.Wait()
+	// Whether each path was registered before or after the hook, the hook
+	// runs for it exactly once.
+	assert.Equal(t, len(served), paths)
+	for procedure, count := range served {
+		assert.Equal(t, count, 1, assert.Sprintf("%s served %d times", procedure, count))
+	}
+}
+
+func TestRegisterAll(t *testing.T) {
+	t.Parallel()
+	mux := connect.NewServeMux()
+	pingv1connect.RegisterAll(
+		mux,
+		pingv1connect.Handlers{PingService: pingServer{}},
+		connect.WithCompressMinBytes(1),
+	)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL)
+	response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 1}))
+	assert.Nil(t, err)
+	assert.Equal(t, response.Msg.Number, 1)
+
+	// Services without implementations aren't mounted.
+	empty := connect.NewServeMux()
+	pingv1connect.RegisterAll(empty, pingv1connect.Handlers{})
+	emptyServer := httptest.NewServer(empty)
+	t.Cleanup(emptyServer.Close)
+	client = pingv1connect.NewPingServiceClient(emptyServer.Client(), emptyServer.URL)
+	_, err = client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 1}))
+	assert.Equal(t, connect.CodeOf(err), connect.CodeUnimplemented)
+}
+
+func TestTenantMux(t *testing.T) {
+	t.Parallel()
+	const tenantHeader = "Tenant"
+	pingReturning := func(number int64) *connect.Handler {
+		return connect.NewUnaryHandler(
+			"/"+pingv1connect.PingServiceName+"/Ping",
+			func(context.Context, *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				return connect.NewResponse(&pingv1.PingResponse{Number: number}), nil
+			},
+		)
+	}
+	mux := connect.NewTenantMux(connect.TenantFromHeader(tenantHeader))
+	for tenant, number := range map[string]int64{"": 1, "acme": 2, "globex": 3} {
+		handler := pingReturning(number)
+		mux.Tenant(tenant).Handle(handler.Path(), handler)
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL)
+	ping := func(tenant string) (int64, error) {
+		request := connect.NewRequest(&pingv1.PingRequest{})
+		if tenant != "" {
+			request.Header().Set(tenantHeader, tenant)
+		}
+		response, err := client.Ping(context.Background(), request)
+		if err != nil {
+			return 0, err
+		}
+		return response.Msg.Number, nil
+	}
+	for tenant, want := range map[string]int64{"": 1, "acme": 2, "globex": 3, "initech": 1} {
+		got, err := ping(tenant)
+		assert.Nil(t, err)
+		assert.Equal(t, got, want, assert.Sprintf("tenant %q", tenant))
+	}
+	assert.True(t, mux.RemoveTenant(""))
+	_, err := ping("initech")
+	assert.Equal(t, connect.CodeOf(err), connect.CodeUnimplemented)
+	got, err := ping("acme")
+	assert.Nil(t, err)
+	assert.Equal(t, got, 2)
+
+	request := httptest.NewRequest(http.MethodPost, "https://acme.example.com/", nil)
+	assert.Equal(t, connect.TenantFromSNI(request), "acme.example.com")
+	request.TLS = nil
+	assert.Equal(t, connect.TenantFromSNI(request), "")
+}
+
+func TestContextValues(t *testing.T) {
+	t.Parallel()
+	type key struct{}
+	appendValue := func(value string) func(context.Context, connect.Spec) context.Context {
+		return func(ctx context.Context, spec connect.Spec) context.Context {
+			previous, _ := ctx.Value(key{}).(string)
+			return context.WithValue(ctx, key{}, previous+value+spec.Procedure)
+		}
+	}
+	const want = "a/connect.ping.v1.PingService/Ping" + "b/connect.ping.v1.PingService/Ping"
+	var intercepted string
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{
+			ping: func(ctx context.Context, _ *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				value, _ := ctx.Value(key{}).(string)
+				return connect.NewResponse(&pingv1.PingResponse{Text: value}), nil
+			},
+		},
+		connect.WithContextValues(appendValue("a")),
+		connect.WithContextValues(appendValue("b")),
+		connect.WithInterceptors(connect.UnaryInterceptorFunc(func(next connect.UnaryFunc) connect.UnaryFunc {
+			return func(ctx context.Context, request connect.AnyRequest) (connect.AnyResponse, error) {
+				// Values are injected before interceptors run.
+				intercepted, _ = ctx.Value(key{}).(string)
+				return next(ctx, request)
+			}
+		})),
+	))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL)
+	response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+	assert.Nil(t, err)
+	assert.Equal(t, response.Msg.Text, want)
+	assert.Equal(t, intercepted, want)
+}
+
+func TestNewAliasHandler(t *testing.T) {
+	t.Parallel()
+	const alias = "/acme.ping.v1.PingService/"
+	path, handler := pingv1connect.NewPingServiceHandler(successPingServer{})
+	mux := http.NewServeMux()
+	mux.Handle(path, handler)
+	mux.Handle(connect.NewAliasHandler(alias, path, handler, connect.WithDeprecationHeader()))
+	server := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		server.Close()
+	})
+
+	t.Run("alias", func(t *testing.T) {
+		t.Parallel()
+		resp, err := server.Client().Post(server.URL+alias+"Ping", "application/json", strings.NewReader("{}"))
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, resp.StatusCode, http.StatusOK)
+		assert.Equal(t, resp.Header.Get("Deprecation"), "true")
+	})
+	t.Run("canonical", func(t *testing.T) {
+		t.Parallel()
+		resp, err := server.Client().Post(server.URL+path+"Ping", "application/json", strings.NewReader("{}"))
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, resp.StatusCode, http.StatusOK)
+		assert.Equal(t, resp.Header.Get("Deprecation"), "")
+	})
+	t.Run("client", func(t *testing.T) {
+		t.Parallel()
+		client := connect.NewClient[pingv1.PingRequest, pingv1.PingResponse](
+			server.Client(),
+			server.URL+alias+"Ping",
+			connect.WithGRPC(),
+		)
+		response, err := client.CallUnary(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Nil(t, err)
+		assert.Equal(t, response.Header().Get("Deprecation"), "true")
+	})
+}
+
+func TestWithProcedureOptions(t *testing.T) {
+	t.Parallel()
+	var (
+		mu        sync.Mutex
+		intercept []string
+	)
+	interceptor := connect.UnaryInterceptorFunc(func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, request connect.AnyRequest) (connect.AnyResponse, error) {
+			mu.Lock()
+			intercept = append(intercept, request.Spec().Procedure)
+			mu.Unlock()
+			return next(ctx, request)
+		}
+	})
+	const pingProcedure = "/" + pingv1connect.PingServiceName + "/Ping"
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		pingServer{},
+		connect.WithProcedureOptions(pingProcedure, connect.WithInterceptors(interceptor)),
+	))
+	server := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		server.Close()
+	})
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL)
+	_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+	assert.Nil(t, err)
+	_, err = client.Fail(context.Background(), connect.NewRequest(&pingv1.FailRequest{Code: int32(connect.CodeInternal)}))
+	assert.NotNil(t, err)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, intercept, []string{pingProcedure})
+}
+
+func TestDebugPage(t *testing.T) {
+	t.Parallel()
+	debug := connect.NewDebugPage()
+	mux := http.NewServeMux()
+	clock := newFakeClock(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}, debug, connect.WithClock(clock)))
+	mux.Handle("/debug/connect", debug)
+	server := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		server.Close()
+	})
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL)
+	_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+	assert.Nil(t, err)
+	_, err = client.Fail(context.Background(), connect.NewRequest(&pingv1.FailRequest{Code: int32(connect.CodeResourceExhausted)}))
+	assert.NotNil(t, err)
+	stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 2}))
+	assert.Nil(t, err)
+	for stream.Receive() {
+	}
+	assert.Nil(t, stream.Close())
+
+	response, err := server.Client().Get(server.URL + "/debug/connect")
+	assert.Nil(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, response.StatusCode, http.StatusOK)
+	body, err := io.ReadAll(response.Body)
+	assert.Nil(t, err)
+	page := string(body)
+	for _, want := range []string{
+		"/connect.ping.v1.PingService/Ping",
+		"/connect.ping.v1.PingService/CumSum", // registered, but never called
+		"resource_exhausted: 1",
+		"server streaming",
+		"100.00%",
+		"2020-01-02T03:04:05.000Z", // timed by the handler's clock
+	} {
+		assert.True(t, strings.Contains(page, want), assert.Sprintf("page missing %q", want))
+	}
+}
+
+func TestExpvarMetrics(t *testing.T) {
+	t.Parallel()
+	handlerMetrics := new(expvar.Map).Init()
+	clientMetrics := new(expvar.Map).Init()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		pingServer{},
+		connect.WithExpvarMetrics(handlerMetrics),
+	))
+	server := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		server.Close()
+	})
+	client := pingv1connect.NewPingServiceClient(
+		server.Client(),
+		server.URL,
+		connect.WithExpvarMetrics(clientMetrics),
+	)
+	request := &pingv1.PingRequest{Number: 42}
+	_, err := client.Ping(context.Background(), connect.NewRequest(request))
+	assert.Nil(t, err)
+	_, err = client.Fail(context.Background(), connect.NewRequest(&pingv1.FailRequest{Code: int32(connect.CodeResourceExhausted)}))
+	assert.NotNil(t, err)
+	stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 0}))
+	assert.Nil(t, err)
+	assert.False(t, stream.Receive())
+	assert.Nil(t, stream.Close())
+
+	counter := func(metrics *expvar.Map, procedure, key string) string {
+		procedureMetrics, ok := metrics.Get("/" + pingv1connect.PingServiceName + "/" + procedure).(*expvar.Map)
+		assert.True(t, ok)
+		if value := procedureMetrics.Get(key); value != nil {
+			return value.String()
+		}
+		return ""
+	}
+	size := strconv.Itoa(proto.Size(request))
+	for _, metrics := range []*expvar.Map{handlerMetrics, clientMetrics} {
+		assert.Equal(t, counter(metrics, "Ping", "requests"), "1")
+		assert.Equal(t, counter(metrics, "Fail", "errors"), `{"resource_exhausted": 1}`)
+		assert.Equal(t, counter(metrics, "CountUp", "errors"), `{"invalid_argument": 1}`)
+	}
+	assert.Equal(t, counter(handlerMetrics, "Ping", "bytes_received"), size)
+	assert.Equal(t, counter(handlerMetrics, "Ping", "bytes_sent"), size)
+	assert.Equal(t, counter(clientMetrics, "Ping", "bytes_sent"), size)
+	assert.Equal(t, counter(clientMetrics, "Ping", "bytes_received"), size)
+}
+
+func TestSlowRequestLog(t *testing.T) {
+	t.Parallel()
+	handlerLog := make(logLines, 10)
+	clientLog := make(logLines, 10)
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		pingServer{},
+		connect.WithSlowRequestLog(time.Nanosecond, handlerLog.log),
+	))
+	server := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		server.Close()
+	})
+	client := pingv1connect.NewPingServiceClient(
+		server.Client(),
+		server.URL,
+		connect.WithSlowRequestLog(time.Nanosecond, clientLog.log),
+	)
+	const pingProcedure = "/" + pingv1connect.PingServiceName + "/Ping"
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	_, err := client.Ping(ctx, connect.NewRequest(&pingv1.PingRequest{}))
+	assert.Nil(t, err)
+	line := clientLog.next(t)
+	assert.True(t, strings.HasPrefix(line, "slow unary call "+pingProcedure+": "))
+	assert.True(t, strings.Contains(line, "peer "+strings.TrimPrefix(server.URL, "http://")))
+	assert.True(t, strings.Contains(line, "deadline "))
+	line = handlerLog.next(t)
+	assert.True(t, strings.HasPrefix(line, "slow unary call "+pingProcedure+": "))
+	assert.True(t, strings.Contains(line, "peer 127.0.0.1:"))
+
+	stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 1}))
+	assert.Nil(t, err)
+	assert.True(t, stream.Receive())
+	assert.True(t, strings.HasSuffix(clientLog.next(t), "no deadline"))
+	assert.False(t, stream.Receive())
+	assert.Nil(t, stream.Close())
+	assert.True(t, strings.HasPrefix(handlerLog.next(t), "slow stream setup /"+pingv1connect.PingServiceName+"/CountUp: "))
+	select {
+	case line := <-clientLog:
+		t.Fatalf("stream setup logged twice: %q", line)
+	default:
+	}
+
+	quietLog := make(logLines, 10)
+	quietClient := pingv1connect.NewPingServiceClient(
+		server.Client(),
+		server.URL,
+		connect.WithSlowRequestLog(time.Hour, quietLog.log),
+	)
+	_, err = quietClient.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+	assert.Nil(t, err)
+	assert.Equal(t, len(quietLog), 0)
+
+	// Calls are timed with the configured clock.
+	clock := newFakeClock(time.Now())
+	clockLog := make(logLines, 10)
+	clockMux := http.NewServeMux()
+	clockMux.Handle(pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{
+			ping: func(context.Context, *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				clock.Advance(2 * time.Hour)
+				return connect.NewResponse(&pingv1.PingResponse{}), nil
+			},
+		},
+		connect.WithSlowRequestLog(time.Hour, clockLog.log),
+		connect.WithClock(clock),
+	))
+	clockServer := httptest.NewServer(clockMux)
+	t.Cleanup(clockServer.Close)
+	clockClient := pingv1connect.NewPingServiceClient(clockServer.Client(), clockServer.URL)
+	_, err = clockClient.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+	assert.Nil(t, err)
+	assert.True(t, strings.Contains(clockLog.next(t), "took 2h0m0s (threshold 1h0m0s)"))
+}
+
+// logLines delivers each slow request log message as a line.
+type logLines chan string
+
+func (l logLines) log(_ context.Context, line string) {
+	l <- line
+}
+
+func (l logLines) next(t *testing.T) string {
+	t.Helper()
+	select {
+	case line := <-l:
+		return line
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for log line")
+		return ""
+	}
+}
+
+func TestHandlerClientDisconnect(t *testing.T) {
+	t.Parallel()
+	received := make(chan struct{}, 1)
+	handlerErrs := make(chan error, 1)
+	mux := http.NewServeMux()
+	mux.Handle("/"+pingv1connect.PingServiceName+"/CumSum", connect.NewBidiStreamHandler(
+		"/"+pingv1connect.PingServiceName+"/CumSum",
+		func(ctx context.Context, stream *connect.BidiStream[pingv1.CumSumRequest, pingv1.CumSumResponse]) error {
+			_, err := stream.Receive()
+			assert.Nil(t, err)
+			received <- struct{}{}
+			_, err = stream.Receive()
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+				t.Error("handler context not canceled after client disconnect")
+			}
+			handlerErrs <- err
+			return err
+		},
+	))
+	server := httptest.NewUnstartedServer(mux)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(func() {
+		server.Close()
+	})
+	for _, opts := range []connect.ClientOption{connect.WithGRPC(), connect.WithOptions()} {
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL, opts)
+		ctx, cancel := context.WithCancel(context.Background())
+		stream := client.CumSum(ctx)
+		assert.Nil(t, stream.Send(&pingv1.CumSumRequest{Number: 1}))
+		<-received
+		cancel()
+		select {
+		case err := <-handlerErrs:
+			assert.Equal(t, connect.CodeOf(err), connect.CodeCanceled)
+		case <-time.After(5 * time.Second):
+			t.Fatal("handler Receive didn't unblock after client disconnect")
+		}
+		_ = stream.CloseSend()
+		_ = stream.CloseReceive()
+	}
+}
+
+func TestClock(t *testing.T) {
+	t.Parallel()
+	const pingProcedure = "/" + pingv1connect.PingServiceName + "/Ping"
+	handlerClock := newFakeClock(time.Now())
+	started := make(chan struct{})
+	timeouts := make(chan string, 1)
+	mux := http.NewServeMux()
+	mux.Handle(pingProcedure, connect.NewUnaryHandler(
+		pingProcedure,
+		func(ctx context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+			timeouts <- request.Header().Get("Connect-Timeout-Ms")
+			if request.Msg.Number == 0 {
+				return connect.NewResponse(&pingv1.PingResponse{}), nil
+			}
+			close(started)
+			// Contexts derived from the handler's see the deadline, too.
+			child, cancel := context.WithCancel(ctx)
+			defer cancel()
+			<-child.Done()
+			return nil, child.Err()
+		},
+		connect.WithClock(handlerClock),
+	))
+	server := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		server.Close()
+	})
+
+	// The client computes the timeout using its own clock, not the system's.
+	clientNow := time.Now().Add(time.Hour)
+	client := pingv1connect.NewPingServiceClient(
+		server.Client(),
+		server.URL,
+		connect.WithClock(newFakeClock(clientNow)),
+	)
+	ctx, cancel := context.WithDeadline(context.Background(), clientNow.Add(3*time.Second))
+	defer cancel()
+	_, err := client.Ping(ctx, connect.NewRequest(&pingv1.PingRequest{}))
+	assert.Nil(t, err)
+	assert.Equal(t, <-timeouts, "3000")
+
+	// The handler enforces the timeout using its clock, so advancing the clock
+	// expires the call without sleeping.
+	client = pingv1connect.NewPingServiceClient(server.Client(), server.URL)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	go func() {
+		<-started
+		handlerClock.Advance(2 * time.Minute)
+	}()
+	_, err = client.Ping(ctx, connect.NewRequest(&pingv1.PingRequest{Number: 1}))
+	assert.Equal(t, connect.CodeOf(err), connect.CodeDeadlineExceeded)
+}
+
+func TestReservedHeaders(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{
+			ping: func(_ context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				response := connect.NewResponse(&pingv1.PingResponse{Number: request.Msg.Number})
+				if request.Msg.Number > 0 {
+					response.Header().Set("Content-Type", "text/plain")
+				}
+				return response, nil
+			},
+		},
+	))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	for _, protocol := range []string{connect.ProtocolConnect, connect.ProtocolGRPCWeb} {
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL, connect.WithProtocol(protocol))
+		// Requests can be reused, even though the protocol added headers.
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		request := connect.NewRequest(&pingv1.PingRequest{})
+		_, err := client.Ping(ctx, request)
+		assert.Nil(t, err, assert.Sprintf(protocol))
+		_, err = client.Ping(ctx, request)
+		cancel()
+		assert.Nil(t, err, assert.Sprintf(protocol))
+		// Callers can't set reserved headers on unary requests.
+		for _, key := range []string{"Content-Type", "Grpc-Timeout"} {
+			request := connect.NewRequest(&pingv1.PingRequest{})
+			request.Header().Set(key, "1n")
+			_, err = client.Ping(context.Background(), request)
+			assert.Equal(t, connect.CodeOf(err), connect.CodeInternal, assert.Sprintf("%s: %v", protocol, err))
+			assert.True(t, strings.Contains(err.Error(), key), assert.Sprintf("%s: %v", protocol, err))
+		}
+		// Neither can interceptors.
+		interceptedClient := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL,
+			connect.WithProtocol(protocol),
+			connect.WithInterceptors(connect.UnaryInterceptorFunc(func(next connect.UnaryFunc) connect.UnaryFunc {
+				return func(ctx context.Context, request connect.AnyRequest) (connect.AnyResponse, error) {
+					request.Header().Set("Content-Type", "text/plain")
+					return next(ctx, request)
+				}
+			})),
+		)
+		_, err = interceptedClient.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Equal(t, connect.CodeOf(err), connect.CodeInternal, assert.Sprintf("%s: %v", protocol, err))
+		assert.True(t, strings.Contains(err.Error(), "Content-Type"), assert.Sprintf("%s: %v", protocol, err))
+		// Handlers can't change them.
+		_, err = client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 1}))
+		assert.Equal(t, connect.CodeOf(err), connect.CodeInternal, assert.Sprintf(protocol))
+		assert.True(t, strings.Contains(err.Error(), "Content-Type"), assert.Sprintf("%s: %v", protocol, err))
+	}
+}
+
+func TestOnFinish(t *testing.T) {
+	t.Parallel()
+	type finished struct {
+		procedure string
+		err       error
+		stats     connect.Stats
+	}
+	calls := make(chan finished, 10)
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		pingServer{},
+		connect.WithOnFinish(func(_ context.Context, spec connect.Spec, err error, stats connect.Stats) {
+			calls <- finished{procedure: spec.Procedure, err: err, stats: stats}
+		}),
+	))
+	server := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		server.Close()
+	})
+	for _, protocol := range []string{connect.ProtocolConnect, connect.ProtocolGRPCWeb} {
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL, connect.WithProtocol(protocol))
+
+		_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 1}))
+		assert.Nil(t, err)
+		call := <-calls
+		assert.Equal(t, call.procedure, "/connect.ping.v1.PingService/Ping")
+		assert.Nil(t, call.err)
+		assert.Equal(t, call.stats.MessagesReceived, 1)
+		assert.Equal(t, call.stats.MessagesSent, 1)
+		assert.True(t, call.stats.Duration > 0)
+
+		_, err = client.Fail(context.Background(), connect.NewRequest(&pingv1.FailRequest{Code: int32(connect.CodeResourceExhausted)}))
+		assert.NotNil(t, err)
+		call = <-calls
+		assert.Equal(t, call.procedure, "/connect.ping.v1.PingService/Fail")
+		assert.Equal(t, connect.CodeOf(call.err), connect.CodeResourceExhausted)
+		assert.Equal(t, call.stats.MessagesSent, 0)
+
+		stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 3}))
+		assert.Nil(t, err)
+		for stream.Receive() {
+		}
+		assert.Nil(t, stream.Err())
+		assert.Nil(t, stream.Close())
+		call = <-calls
+		assert.Equal(t, call.procedure, "/connect.ping.v1.PingService/CountUp")
+		assert.Nil(t, call.err)
+		assert.Equal(t, call.stats.MessagesReceived, 1)
+		assert.Equal(t, call.stats.MessagesSent, 3)
+	}
+	select {
+	case call := <-calls:
+		t.Fatalf("unexpected extra call to %s", call.procedure)
+	default:
+	}
+}
+
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) func() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	timer := &fakeTimer{at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, timer)
+	return func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		wasPending := !timer.stopped
+		timer.stopped = true
+		return wasPending
+	}
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	for _, timer := range c.timers {
+		if !timer.stopped && !timer.at.After(c.now) {
+			timer.stopped = true
+			go timer.f()
+		}
+	}
+}
+
+func TestIdleStreamTimeout(t *testing.T) {
+	t.Parallel()
+	const timeout = 200 * time.Millisecond
+	procedure := "/" + pingv1connect.PingServiceName + "/CumSum"
+	handlerErrs := make(chan error, 3)
+	mux := http.NewServeMux()
+	mux.Handle(procedure, connect.NewBidiStreamHandler(
+		procedure,
+		func(_ context.Context, stream *connect.BidiStream[pingv1.CumSumRequest, pingv1.CumSumResponse]) error {
+			err := func() error {
+				var sum int64
+				for {
+					msg, err := stream.Receive()
+					if err != nil {
+						return err
+					}
+					sum += msg.Number
+					if err := stream.Send(&pingv1.CumSumResponse{Sum: sum}); err != nil {
+						return err
+					}
+				}
+			}()
+			handlerErrs <- err
+			return err
+		},
+		connect.WithIdleStreamTimeout(timeout),
+	))
+	server := httptest.NewUnstartedServer(mux)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	for _, protocol := range []string{connect.ProtocolConnect, connect.ProtocolGRPC, connect.ProtocolGRPCWeb} {
+		client := connect.NewClient[pingv1.CumSumRequest, pingv1.CumSumResponse](
+			server.Client(),
+			server.URL+procedure,
+			connect.WithProtocol(protocol),
+		)
+		t.Run(protocol, func(t *testing.T) {
+			stream := client.CallBidiStream(context.Background())
+			// Activity keeps the stream alive past the timeout.
+			for i := 1; i <= 4; i++ {
+				assert.Nil(t, stream.Send(&pingv1.CumSumRequest{Number: 1}))
+				msg, err := stream.Receive()
+				assert.Nil(t, err)
+				assert.Equal(t, msg.Sum, int64(i))
+				time.Sleep(timeout / 2)
+			}
+			// Then the client goes quiet without closing the stream.
+			_, err := stream.Receive()
+			assert.Equal(t, connect.CodeOf(err), connect.CodeDeadlineExceeded)
+			assert.Equal(t, connect.CodeOf(<-handlerErrs), connect.CodeDeadlineExceeded)
+			_ = stream.CloseSend()
+			_ = stream.CloseReceive()
+		})
+	}
+}
+
+func TestMemoryBudget(t *testing.T) {
+	t.Parallel()
+	const limit = 1024
+	budget := connect.NewMemoryBudget(limit)
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		pingServer{},
+		connect.WithMemoryBudget(budget),
+	))
+	server := httptest.NewUnstartedServer(mux)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	for _, protocol := range []string{connect.ProtocolConnect, connect.ProtocolGRPC, connect.ProtocolGRPCWeb} {
+		client := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL,
+			connect.WithProtocol(protocol),
+		)
+		t.Run(protocol, func(t *testing.T) {
+			response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Text: "small"}))
+			assert.Nil(t, err)
+			assert.Equal(t, response.Msg.Text, "small")
+			_, err = client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{
+				Text: strings.Repeat("a", 2*limit),
+			}))
+			assert.Equal(t, connect.CodeOf(err), connect.CodeResourceExhausted)
+			assert.Equal(t, budget.InUse(), int64(0))
+		})
+	}
+	t.Run("responses", func(t *testing.T) {
+		t.Parallel()
+		budget := connect.NewMemoryBudget(limit)
+		path, handler := pingv1connect.NewPingServiceHandler(pingServer{}, connect.WithMemoryBudget(budget))
+		message, err := proto.Marshal(&pingv1.CountUpRequest{Number: 3})
+		assert.Nil(t, err)
+		body := append([]byte{0, 0, 0, 0, byte(len(message))}, message...)
+		request := httptest.NewRequest(http.MethodPost, path+"CountUp", bytes.NewReader(body))
+		request.Header.Set("Content-Type", "application/grpc")
+		writer := &budgetRecorder{ResponseRecorder: httptest.NewRecorder(), budget: budget}
+		handler.ServeHTTP(writer, request)
+		result := writer.Result()
+		assert.Nil(t, result.Body.Close())
+		assert.Equal(t, result.Trailer.Get("Grpc-Status"), "0")
+		assert.True(t, writer.maxInUse > 0)
+		assert.Equal(t, budget.InUse(), int64(0))
+	})
+}
+
+// budgetRecorder records the most memory in use while writing the response.
+type budgetRecorder struct {
+	*httptest.ResponseRecorder
+
+	budget   *connect.MemoryBudget
+	maxInUse int64
+}
+
+func (r *budgetRecorder) Write(data []byte) (int, error) {
+	if inUse := r.budget.InUse(); inUse > r.maxInUse {
+		r.maxInUse = inUse
+	}
+	return r.ResponseRecorder.Write(data)
+}
+
+func TestCallerFromContext(t *testing.T) {
+	t.Parallel()
+	callers := make(chan connect.Caller, 1)
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(&pluggablePingServer{
+		ping: func(ctx context.Context, _ *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+			caller, ok := connect.CallerFromContext(ctx)
+			assert.True(t, ok)
+			callers <- caller
+			return connect.NewResponse(&pingv1.PingResponse{}), nil
+		},
+	}))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	for _, protocol := range []string{connect.ProtocolConnect, connect.ProtocolGRPCWeb} {
+		for _, codec := range []string{"proto", "json"} {
+			options := []connect.ClientOption{connect.WithProtocol(protocol), connect.WithUserAgent("app/1.0")}
+			if codec == "json" {
+				options = append(options, connect.WithProtoJSON())
+			}
+			client := pingv1connect.NewPingServiceClient(server.Client(), server.URL, options...)
+			_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+			assert.Nil(t, err)
+			caller := <-callers
+			assert.Equal(t, caller.Protocol, protocol)
+			assert.Equal(t, caller.Codec, codec)
+			assert.True(t, strings.HasSuffix(caller.UserAgent, " app/1.0"))
+		}
+	}
+	_, ok := connect.CallerFromContext(context.Background())
+	assert.False(t, ok)
+}
+
+func TestSpecCompression(t *testing.T) {
+	t.Parallel()
+	compressions := make(chan connect.Compression, 2)
+	record := connect.UnaryInterceptorFunc(func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, request connect.AnyRequest) (connect.AnyResponse, error) {
+			compressions <- request.Spec().Compression()
+			return next(ctx, request)
+		}
+	})
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}, connect.WithInterceptors(record)))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	for _, protocol := range []string{connect.ProtocolConnect, connect.ProtocolGRPCWeb} {
+		client := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL,
+			connect.WithProtocol(protocol),
+			connect.WithSendGzip(),
+			connect.WithInterceptors(record),
+		)
+		_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+		assert.Nil(t, err)
+		clientSide, handlerSide := <-compressions, <-compressions
+		assert.Equal(t, clientSide, connect.Compression{Request: "gzip"})
+		assert.Equal(t, handlerSide, connect.Compression{Request: "gzip", Response: "gzip"})
+	}
+}
+
+func TestAllowedEncodings(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		pingServer{},
+		connect.WithAllowedCodecs("proto"),
+		connect.WithAllowedCompression("gzip"),
+	))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	ping := func(options ...connect.ClientOption) error {
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL, options...)
+		_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 42}))
+		return err
+	}
+	for _, protocol := range []string{connect.ProtocolConnect, connect.ProtocolGRPCWeb} {
+		assert.Nil(t, ping(connect.WithProtocol(protocol), connect.WithSendGzip()))
+		err := ping(connect.WithProtocol(protocol))
+		assert.Equal(t, connect.CodeOf(err), connect.CodeUnimplemented)
+		err = ping(connect.WithProtocol(protocol), connect.WithSendGzip(), connect.WithProtoJSON())
+		assert.NotNil(t, err)
+	}
+
+	// Uncompressed JSON is rejected outright.
+	response, err := server.Client().Post(
+		server.URL+"/"+pingv1connect.PingServiceName+"/Ping",
+		"application/json",
+		strings.NewReader("{}"),
+	)
+	assert.Nil(t, err)
+	assert.Nil(t, response.Body.Close())
+	assert.Equal(t, response.StatusCode, http.StatusUnsupportedMediaType)
+}
+
+func TestIdempotencyCache(t *testing.T) {
+	t.Parallel()
+	var calls int64
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{
+			ping: func(_ context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				count := atomic.AddInt64(&calls, 1)
+				if request.Msg.Number < 0 {
+					return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("negative"))
+				}
+				response := connect.NewResponse(&pingv1.PingResponse{Number: count})
+				response.Header().Set("X-Count", strconv.FormatInt(count, 10))
+				return response, nil
+			},
+		},
+		connect.WithIdempotencyCache(connect.NewMemoryIdempotencyStore(), time.Minute),
+	))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL)
+	ping := func(key string, number int64) (*connect.Response[pingv1.PingResponse], error) {
+		request := connect.NewRequest(&pingv1.PingRequest{Number: number})
+		if key != "" {
+			request.Header().Set("Idempotency-Key", key)
+		}
+		return client.Ping(context.Background(), request)
+	}
+
+	first, err := ping("first", 1)
+	assert.Nil(t, err)
+	replayed, err := ping("first", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, replayed.Msg.Number, first.Msg.Number)
+	assert.Equal(t, replayed.Header().Get("X-Count"), "1")
+	assert.Equal(t, atomic.LoadInt64(&calls), int64(1))
+
+	second, err := ping("second", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, second.Msg.Number, int64(2))
+	_, err = ping("", 1)
+	assert.Nil(t, err)
+	_, err = ping("", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, atomic.LoadInt64(&calls), int64(4))
+
+	// Failures aren't cached.
+	_, err = ping("failed", -1)
+	assert.Equal(t, connect.CodeOf(err), connect.CodeInvalidArgument)
+	_, err = ping("failed", -1)
+	assert.Equal(t, connect.CodeOf(err), connect.CodeInvalidArgument)
+	assert.Equal(t, atomic.LoadInt64(&calls), int64(6))
+
+	// Keys can't be reused for different requests.
+	_, err = ping("first", 2)
+	assert.Equal(t, connect.CodeOf(err), connect.CodeInvalidArgument)
+	assert.True(t, strings.Contains(err.Error(), "different request"))
+	assert.Equal(t, atomic.LoadInt64(&calls), int64(6))
+}
+
+func TestIdempotencyCacheScope(t *testing.T) {
+	t.Parallel()
+	var calls int64
+	clock := newFakeClock(time.Now())
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{
+			ping: func(context.Context, *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				return connect.NewResponse(&pingv1.PingResponse{Number: atomic.AddInt64(&calls, 1)}), nil
+			},
+		},
+		connect.WithIdempotencyCache(connect.NewMemoryIdempotencyStore(), time.Minute),
+		connect.WithIdempotencyScope(func(_ context.Context, request connect.AnyRequest) (string, error) {
+			user := request.Header().Get("X-User")
+			if user == "" {
+				return "", connect.NewError(connect.CodeUnauthenticated, errors.New("no user"))
+			}
+			return user, nil
+		}),
+		connect.WithClock(clock),
+	))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL)
+	ping := func(user string) (int64, error) {
+		request := connect.NewRequest(&pingv1.PingRequest{})
+		request.Header().Set("Idempotency-Key", "shared")
+		if user != "" {
+			request.Header().Set("X-User", user)
+		}
+		response, err := client.Ping(context.Background(), request)
+		if err != nil {
+			return 0, err
+		}
+		return response.Msg.Number, nil
+	}
+
+	number, err := ping("alice")
+	assert.Nil(t, err)
+	assert.Equal(t, number, int64(1))
+	number, err = ping("alice")
+	assert.Nil(t, err)
+	assert.Equal(t, number, int64(1))
+	// Other callers using the same key don't see alice's response.
+	number, err = ping("bob")
+	assert.Nil(t, err)
+	assert.Equal(t, number, int64(2))
+	_, err = ping("")
+	assert.Equal(t, connect.CodeOf(err), connect.CodeUnauthenticated)
+	// Results expire according to the handler's clock.
+	clock.Advance(time.Minute)
+	number, err = ping("alice")
+	assert.Nil(t, err)
+	assert.Equal(t, number, int64(3))
+}
+
+func TestWriteTimeout(t *testing.T) {
+	t.Parallel()
+	const procedure = "/" + pingv1connect.PingServiceName + "/CountUp"
+	sendErrs := make(chan error, 1)
+	clock := newFakeClock(time.Now())
+	mux := http.NewServeMux()
+	mux.Handle(procedure, connect.NewServerStreamHandler(
+		procedure,
+		func(
+			ctx context.Context,
+			_ *connect.Request[pingv1.CountUpRequest],
+			stream *connect.ServerStream[pingv1.PingResponse],
+		) error {
+			// The client never reads, so eventually the connection's buffers fill
+			// up and a write stalls. Use incompressible text so that compression
+			// doesn't hide the backlog.
+			text := make([]byte, 1<<16)
+			for i := range text {
+				text[i] = byte('a' + rand.Intn(26)) //nolint:gosec // test data
+			}
+			large := &pingv1.PingResponse{Text: string(text)}
+			for {
+				if err := stream.Send(large); err != nil {
+					sendErrs <- err
+					return err
+				}
+			}
+		},
+		connect.WithWriteTimeout(time.Minute),
+		connect.WithClock(clock),
+	))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	client := connect.NewClient[pingv1.CountUpRequest, pingv1.PingResponse](server.Client(), server.URL+procedure)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.CallServerStream(ctx, connect.NewRequest(&pingv1.CountUpRequest{}))
+	assert.Nil(t, err)
+	giveUp := time.After(10 * time.Second)
+	for done := false; !done; {
+		select {
+		case err := <-sendErrs:
+			assert.Equal(t, connect.CodeOf(err), connect.CodeDeadlineExceeded)
+			done = true
+		case <-time.After(10 * time.Millisecond):
+			// Only a write that's still in progress times out.
+			clock.Advance(time.Minute)
+		case <-giveUp:
+			t.Fatal("handler never timed out")
+		}
+	}
+	cancel()
+	assert.Nil(t, stream.Close())
+}
+
+func TestMaxHeaderBytes(t *testing.T) {
+	t.Parallel()
+	const limit = 1024
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		pingServer{},
+		connect.WithMaxHeaderBytes(limit),
+	))
+	server := httptest.NewUnstartedServer(mux)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	for _, protocol := range []string{connect.ProtocolConnect, connect.ProtocolGRPC, connect.ProtocolGRPCWeb} {
+		client := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL,
+			connect.WithProtocol(protocol),
+		)
+		t.Run(protocol, func(t *testing.T) {
+			request := connect.NewRequest(&pingv1.PingRequest{Text: "small"})
+			request.Header().Set("X-Small", "value")
+			_, err := client.Ping(context.Background(), request)
+			assert.Nil(t, err)
+			request = connect.NewRequest(&pingv1.PingRequest{Text: "small"})
+			request.Header().Set("X-Large", strings.Repeat("a", limit))
+			_, err = client.Ping(context.Background(), request)
+			assert.Equal(t, connect.CodeOf(err), connect.CodeResourceExhausted)
+		})
+	}
+}
+
+func TestLoadShedder(t *testing.T) {
+	t.Parallel()
+	const (
+		target  = 10 * time.Millisecond
+		latency = 50 * time.Millisecond
+	)
+	clock := newFakeClock(time.Now())
+	shedder := connect.NewLoadShedder(connect.LoadShedderConfig{
+		Target: target,
+		Clock:  clock,
+	})
+	// serve admits a batch of concurrent requests, which take the given time,
+	// and returns how many were shed.
+	serve := func(concurrent int, took time.Duration) int {
+		var shed int
+		var done []func()
+		for i := 0; i < concurrent; i++ {
+			finish, ok := shedder.Admit(nil)
+			if !ok {
+				shed++
+				continue
+			}
+			done = append(done, finish)
+		}
+		clock.Advance(took)
+		for _, finish := range done {
+			finish()
+		}
+		return shed
+	}
+
+	// Requests that are slow but don't queue are never shed, however many are
+	// in flight.
+	for i := 0; i < 20; i++ {
+		assert.Zero(t, serve(8, latency))
+	}
+
+	// Once requests queue up behind each other and take longer than usual, the
+	// shedder rejects some of them.
+	var shed int
+	for i := 0; i < 10; i++ {
+		shed += serve(8, 3*latency)
+	}
+	assert.Equal(t, shed, 51)
+
+	// When the queue drains, the shedder gradually admits more requests.
+	shed = 0
+	for i := 0; i < 10; i++ {
+		shed += serve(8, latency)
+	}
+	assert.Equal(t, shed, 46)
+	assert.Zero(t, serve(8, latency))
+
+	// The ServeMux fails rejected requests with CodeUnavailable.
+	mux := connect.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}))
+	mux.SetAdmissionController(rejectingAdmissionController{})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL)
+	ping := func() error {
+		_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 1}))
+		return err
+	}
+	assert.Equal(t, connect.CodeOf(ping()), connect.CodeUnavailable)
+	mux.SetAdmissionController(nil)
+	assert.Nil(t, ping())
+}
+
+type rejectingAdmissionController struct{}
+
+func (rejectingAdmissionController) Admit(*http.Request) (func(), bool) {
+	return nil, false
+}
+
+func TestPriorityScheduler(t *testing.T) {
+	t.Parallel()
+	scheduler := connect.NewPriorityScheduler(connect.PrioritySchedulerConfig{
+		MaxConcurrent: 1,
+		MaxQueued:     2,
+	})
+	served := make(chan int64, 4)
+	unblock := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{
+			ping: func(_ context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+				served <- request.Msg.Number
+				if request.Msg.Number == 0 {
+					<-unblock
+				}
+				return connect.NewResponse(&pingv1.PingResponse{Number: request.Msg.Number}), nil
+			},
+		},
+		connect.WithPriorityScheduler(scheduler),
+	))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL)
+	ping := func(number int64, priority string) error {
+		request := connect.NewRequest(&pingv1.PingRequest{Number: number})
+		request.Header().Set("Connect-Priority", priority)
+		_, err := client.Ping(context.Background(), request)
+		return err
+	}
+	errs := make(map[int64]chan error)
+	start := func(number int64, priority string, queued int) {
+		errc := make(chan error, 1)
+		errs[number] = errc
+		go func() { errc <- ping(number, priority) }()
+		for scheduler.Queued() != queued {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	start(0, "normal", 0)
+	assert.Equal(t, <-served, 0)
+	start(1, "low", 1)
+	start(2, "normal", 2)
+	// The queue is full, so the critical request evicts the low one.
+	start(3, "critical", 2)
+	assert.Equal(t, connect.CodeOf(<-errs[1]), connect.CodeResourceExhausted)
+	// Nothing has lower priority than a low request, so it's rejected.
+	assert.Equal(t, connect.CodeOf(ping(4, "low")), connect.CodeResourceExhausted)
+
+	close(unblock)
+	assert.Nil(t, <-errs[0])
+	assert.Equal(t, <-served, 3)
+	assert.Equal(t, <-served, 2)
+	assert.Nil(t, <-errs[2])
+	assert.Nil(t, <-errs[3])
+	assert.Equal(t, scheduler.Queued(), 0)
+}
+
+func TestBandwidthLimit(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock(time.Now())
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		pingServer{},
+		connect.WithClock(clock),
+		connect.WithBandwidthLimiter(connect.NewBandwidthLimiter(1000, 1000)),
+		connect.WithStreamBandwidthLimit(10_000),
+	))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL)
+	ping := func(size int) <-chan error {
+		errc := make(chan error, 1)
+		go func() {
+			request := connect.NewRequest(&pingv1.PingRequest{Text: strings.Repeat("a", size)})
+			_, err := client.Ping(context.Background(), request)
+			errc <- err
+		}()
+		return errc
+	}
+
+	// The request and response fit in the burst.
+	assert.Nil(t, <-ping(400))
+	// The second call drains the bucket, so the handler waits for it to
+	// refill.
+	errc := ping(400)
+	select {
+	case err := <-errc:
+		t.Fatalf("call finished without waiting for bandwidth: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	clock.Advance(time.Second)
+	assert.Nil(t, <-errc)
+}
+
+func TestSetResponseMetadata(t *testing.T) {
+	t.Parallel()
+	sentinel := connect.NewError(connect.CodeFailedPrecondition, errors.New("negative"))
+	sentinel.Meta().Set("Set-Header", "sentinel")
+	// Interceptors see the implementation's error with its wrapping intact,
+	// and errors.As finds the metadata.
+	checkWrapping := connect.UnaryInterceptorFunc(func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, request connect.AnyRequest) (connect.AnyResponse, error) {
+			response, err := next(ctx, request)
+			if err != nil {
+				assert.True(t, errors.Is(err, sentinel))
+				assert.True(t, strings.HasPrefix(err.Error(), "wrapped: "))
+				var connectErr *connect.Error
+				assert.True(t, errors.As(err, &connectErr))
+				assert.Equal(t, connectErr.Meta().Values("Set-Header"), []string{"header"})
+			}
+			return response, err
+		}
+	})
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		&pluggablePingServer{
+			ping: func(
+				ctx context.Context,
+				request *connect.Request[pingv1.PingRequest],
+			) (*connect.Response[pingv1.PingResponse], error) {
+				assert.Nil(t, connect.SetResponseHeader(ctx, "Set-Header", "header"))
+				assert.Nil(t, connect.SetResponseTrailer(ctx, "Set-Trailer", "trailer"))
+				if request.Msg.Number < 0 {
+					return nil, fmt.Errorf("wrapped: %w", sentinel)
+				}
+				response := connect.NewResponse(&pingv1.PingResponse{Number: request.Msg.Number})
+				response.Header().Set("Set-Header", "response")
+				return response, nil
+			},
+		},
+		connect.WithInterceptors(checkWrapping),
+	))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	for _, protocol := range []connect.ClientOption{connect.WithProtocol(connect.ProtocolConnect), connect.WithGRPC()} {
+		client := pingv1connect.NewPingServiceClient(server.Client(), server.URL, protocol)
+		response, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 1}))
+		assert.Nil(t, err)
+		// Values set from the context replace the ones set on the response.
+		assert.Equal(t, response.Header().Values("Set-Header"), []string{"header"})
+		assert.Equal(t, response.Trailer().Get("Set-Trailer"), "trailer")
+
+		_, err = client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: -1}))
+		var connectErr *connect.Error
+		assert.True(t, errors.As(err, &connectErr))
+		assert.Equal(t, connectErr.Code(), connect.CodeFailedPrecondition)
+		assert.Equal(t, connectErr.Meta().Values("Set-Header"), []string{"header"})
+		assert.Equal(t, connectErr.Meta().Get("Set-Trailer"), "trailer")
+	}
+	// The shared error isn't modified.
+	assert.Equal(t, sentinel.Meta().Values("Set-Header"), []string{"sentinel"})
+	assert.NotNil(t, connect.SetResponseHeader(context.Background(), "Set-Header", "header"))
+
+	streamServer := httptest.NewServer(connect.NewServerStreamHandler(
+		"/"+pingv1connect.PingServiceName+"/CountUp",
+		func(ctx context.Context, _ *connect.Request[pingv1.CountUpRequest], stream *connect.ServerStream[pingv1.CountUpResponse]) error {
+			assert.Nil(t, connect.SetResponseHeader(ctx, "Set-Header", "header"))
+			assert.Nil(t, connect.SetResponseTrailer(ctx, "Set-Trailer", "trailer"))
+			return stream.Send(&pingv1.CountUpResponse{Number: 1})
+		},
+	))
+	t.Cleanup(streamServer.Close)
+	client := pingv1connect.NewPingServiceClient(streamServer.Client(), streamServer.URL)
+	stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 1}))
+	assert.Nil(t, err)
+	for stream.Receive() {
+	}
+	assert.Nil(t, stream.Err())
+	assert.Equal(t, stream.ResponseHeader().Get("Set-Header"), "header")
+	assert.Equal(t, stream.ResponseTrailer().Get("Set-Trailer"), "trailer")
+	assert.Nil(t, stream.Close())
+}
+
+func TestHTTPBody(t *testing.T) {
+	t.Parallel()
+	const procedure = "/connect.test.Blobs/Thumbnail"
+	mux := http.NewServeMux()
+	mux.Handle(procedure, connect.NewUnaryHandler(
+		procedure,
+		func(_ context.Context, request *connect.Request[apipb.HttpBody]) (*connect.Response[apipb.HttpBody], error) {
+			if len(request.Msg.Data) == 0 {
+				return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("empty body"))
+			}
+			return connect.NewResponse(&apipb.HttpBody{
+				ContentType: "text/csv",
+				Data:        []byte(request.Msg.ContentType + "," + strings.ToUpper(string(request.Msg.Data))),
+			}), nil
+		},
+		connect.WithHTTPBody(),
+	))
+	const downloadProcedure = "/connect.test.Blobs/Download"
+	mux.Handle(downloadProcedure, connect.NewUnaryHandler(
+		downloadProcedure,
+		func(_ context.Context, request *connect.Request[wrapperspb.StringValue]) (*connect.Response[apipb.HttpBody], error) {
+			return connect.NewResponse(&apipb.HttpBody{Data: []byte(request.Msg.Value)}), nil
+		},
+		connect.WithHTTPBody(),
+	))
+	const optOutProcedure = "/connect.test.Blobs/OptOut"
+	mux.Handle(optOutProcedure, connect.NewUnaryHandler(
+		optOutProcedure,
+		func(_ context.Context, request *connect.Request[apipb.HttpBody]) (*connect.Response[apipb.HttpBody], error) {
+			return connect.NewResponse(request.Msg), nil
+		},
+	))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	postTo := func(t *testing.T, path, contentType, body string) (*http.Response, string) {
+		t.Helper()
+		request, err := http.NewRequestWithContext(
+			context.Background(),
+			http.MethodPost,
+			server.URL+path,
+			strings.NewReader(body),
+		)
+		assert.Nil(t, err)
+		request.Header.Set("Content-Type", contentType)
+		response, err := server.Client().Do(request)
+		assert.Nil(t, err)
+		defer response.Body.Close()
+		data, err := io.ReadAll(response.Body)
+		assert.Nil(t, err)
+		return response, string(data)
+	}
+	post := func(t *testing.T, contentType, body string) (*http.Response, string) {
+		t.Helper()
+		return postTo(t, procedure, contentType, body)
+	}
+	t.Run("raw", func(t *testing.T) {
+		t.Parallel()
+		response, body := post(t, "image/png", "pixels")
+		assert.Equal(t, response.StatusCode, http.StatusOK)
+		assert.Equal(t, response.Header.Get("Content-Type"), "text/csv")
+		assert.Equal(t, body, "image/png,PIXELS")
+	})
+	t.Run("raw_json", func(t *testing.T) {
+		t.Parallel()
+		response, body := post(t, "application/json", `{"a":1}`)
+		assert.Equal(t, response.StatusCode, http.StatusOK)
+		assert.Equal(t, body, `application/json,{"A":1}`)
+	})
+	t.Run("raw_response", func(t *testing.T) {
+		t.Parallel()
+		response, body := postTo(t, downloadProcedure, "application/json", `"report"`)
+		assert.Equal(t, response.StatusCode, http.StatusOK)
+		assert.Equal(t, response.Header.Get("Content-Type"), "application/octet-stream")
+		assert.Equal(t, body, "report")
+	})
+	t.Run("unknown_protocol", func(t *testing.T) {
+		t.Parallel()
+		for _, contentType := range []string{"application/grpc+xml", "application/grpc-web+xml", "application/connect+xml"} {
+			response, _ := post(t, contentType, "pixels")
+			assert.Equal(t, response.StatusCode, http.StatusUnsupportedMediaType, assert.Sprintf("%s", contentType))
+		}
+	})
+	t.Run("opt_out", func(t *testing.T) {
+		t.Parallel()
+		response, _ := postTo(t, optOutProcedure, "image/png", "pixels")
+		assert.Equal(t, response.StatusCode, http.StatusUnsupportedMediaType)
+	})
+	t.Run("raw_error", func(t *testing.T) {
+		t.Parallel()
+		response, body := post(t, "image/png", "")
+		assert.Equal(t, response.StatusCode, http.StatusBadRequest)
+		assert.Equal(t, response.Header.Get("Content-Type"), "application/json")
+		assert.True(t, strings.Contains(body, "empty body"))
+	})
+	t.Run("connect", func(t *testing.T) {
+		t.Parallel()
+		client := connect.NewClient[apipb.HttpBody, apipb.HttpBody](server.Client(), server.URL+procedure)
+		response, err := client.CallUnary(
+			context.Background(),
+			connect.NewRequest(&apipb.HttpBody{ContentType: "text/plain", Data: []byte("hi")}),
+		)
+		assert.Nil(t, err)
+		assert.Equal(t, response.Msg.ContentType, "text/csv")
+		assert.Equal(t, string(response.Msg.Data), "text/plain,HI")
+	})
+	t.Run("not_http_body", func(t *testing.T) {
+		t.Parallel()
+		mux := http.NewServeMux()
+		mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}, connect.WithHTTPBody()))
+		server := httptest.NewServer(mux)
+		t.Cleanup(server.Close)
+		response, err := server.Client().Post(
+			server.URL+"/"+pingv1connect.PingServiceName+"/Ping",
+			"image/png",
+			strings.NewReader("pixels"),
+		)
+		assert.Nil(t, err)
+		assert.Nil(t, response.Body.Close())
+		assert.Equal(t, response.StatusCode, http.StatusUnsupportedMediaType)
+	})
+}
+
+func TestDownloadHandler(t *testing.T) {
+	t.Parallel()
+	const procedure = "/connect.test.Files/Export"
+	handler := connect.NewDownloadHandler(
+		procedure,
+		func(request *http.Request) (*wrapperspb.StringValue, error) {
+			return wrapperspb.String(request.URL.Query().Get("name")), nil
+		},
+		func(
+			_ context.Context,
+			request *connect.Request[wrapperspb.StringValue],
+			stream *connect.ServerStream[wrapperspb.BytesValue],
+		) error {
+			switch request.Msg.Value {
+			case "missing":
+				return connect.NewError(connect.CodeNotFound, errors.New("no such export"))
+			case "custom.txt":
+				stream.ResponseHeader().Set("Content-Type", "text/plain")
+				stream.ResponseHeader().Set("Content-Disposition", `attachment; filename="custom.txt"`)
+			}
+			for _, row := range []string{"a,b\n", "1,2\n"} {
+				if err := stream.Send(wrapperspb.Bytes([]byte(row))); err != nil {
+					return err
+				}
+			}
+			if request.Msg.Value == "broken" {
+				return connect.NewError(connect.CodeDataLoss, errors.New("export failed"))
+			}
+			return nil
+		},
+		(*wrapperspb.BytesValue).GetValue,
+		connect.WithDownloadFilename("export.csv"),
+		connect.WithDownloadContentType("text/csv"),
+		connect.WithInterceptors(authInterceptor{}),
+	)
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	getAs := func(t *testing.T, name, authorization string) (*http.Response, string, error) {
+		t.Helper()
+		request, err := http.NewRequestWithContext(
+			context.Background(),
+			http.MethodGet,
+			server.URL+procedure+"?name="+name,
+			http.NoBody,
+		)
+		assert.Nil(t, err)
+		request.Header.Set("Authorization", authorization)
+		response, err := server.Client().Do(request)
+		assert.Nil(t, err)
+		defer response.Body.Close()
+		body, err := io.ReadAll(response.Body)
+		return response, string(body), err
+	}
+	get := func(t *testing.T, name string) (*http.Response, string, error) {
+		t.Helper()
+		return getAs(t, name, "Bearer token")
+	}
+	t.Run("success", func(t *testing.T) {
+		t.Parallel()
+		response, body, err := get(t, "all")
+		assert.Nil(t, err)
+		assert.Equal(t, response.StatusCode, http.StatusOK)
+		assert.Equal(t, response.Header.Get("Content-Type"), "text/csv")
+		assert.Equal(t, response.Header.Get("Content-Disposition"), "attachment; filename=export.csv")
+		assert.Equal(t, body, "a,b\n1,2\n")
+	})
+	t.Run("custom_headers", func(t *testing.T) {
+		t.Parallel()
+		response, _, err := get(t, "custom.txt")
+		assert.Nil(t, err)
+		assert.Equal(t, response.Header.Get("Content-Type"), "text/plain")
+		assert.Equal(t, response.Header.Get("Content-Disposition"), `attachment; filename="custom.txt"`)
+	})
+	t.Run("error_before_body", func(t *testing.T) {
+		t.Parallel()
+		response, body, err := get(t, "missing")
+		assert.Nil(t, err)
+		assert.Equal(t, response.StatusCode, http.StatusNotFound)
+		assert.Equal(t, response.Header.Get("Content-Type"), "application/json")
+		assert.Equal(t, response.Header.Get("Content-Disposition"), "")
+		assert.True(t, strings.Contains(body, "no such export"))
+	})
+	t.Run("interceptors", func(t *testing.T) {
+		t.Parallel()
+		response, body, err := getAs(t, "all", "")
+		assert.Nil(t, err)
+		assert.Equal(t, response.StatusCode, http.StatusUnauthorized)
+		assert.Equal(t, response.Header.Get("Content-Type"), "application/json")
+		assert.Equal(t, response.Header.Get("Content-Disposition"), "")
+		assert.True(t, strings.Contains(body, "missing credentials"))
+	})
+	t.Run("error_during_body", func(t *testing.T) {
+		t.Parallel()
+		response, _, err := get(t, "broken")
+		assert.Equal(t, response.StatusCode, http.StatusOK)
+		assert.NotNil(t, err)
+	})
+	t.Run("method", func(t *testing.T) {
+		t.Parallel()
+		request, err := http.NewRequestWithContext(context.Background(), http.MethodDelete, server.URL+procedure, nil)
+		assert.Nil(t, err)
+		response, err := server.Client().Do(request)
+		assert.Nil(t, err)
+		assert.Nil(t, response.Body.Close())
+		assert.Equal(t, response.StatusCode, http.StatusMethodNotAllowed)
+	})
+}
+
+func TestUploadHandler(t *testing.T) {
+	t.Parallel()
+	const procedure = "/connect.test.Files/Ingest"
+	handler := connect.NewUploadHandler(
+		procedure,
+		func(
+			_ context.Context,
+			stream *connect.ClientStream[wrapperspb.StringValue],
+		) (*connect.Response[wrapperspb.StringValue], error) {
+			var (
+				files []string
+				sizes = make(map[string]string)
+			)
+			for stream.Receive() {
+				name, chunk, _ := strings.Cut(stream.Msg().Value, "=")
+				if len(chunk) > 4 {
+					return nil, connect.NewError(connect.CodeInternal, errors.New("chunk too large"))
+				}
+				if _, ok := sizes[name]; !ok {
+					files = append(files, name)
+				}
+				sizes[name] += chunk
+			}
+			if err := stream.Err(); err != nil {
+				return nil, err
+			}
+			if len(files) == 0 {
+				return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("no files"))
+			}
+			var summary []string
+			for _, name := range files {
+				summary = append(summary, name+":"+sizes[name])
+			}
+			return connect.NewResponse(wrapperspb.String(strings.Join(summary, ";"))), nil
+		},
+		func(part connect.UploadPart, chunk []byte) *wrapperspb.StringValue {
+			return wrapperspb.String(part.FormName + "/" + part.Filename + "=" + string(chunk))
+		},
+		connect.WithUploadChunkSize(4),
+		connect.WithUploadMaxBytes(1024),
+		connect.WithInterceptors(authInterceptor{}),
+	)
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	send := func(t *testing.T, method, contentType string, body io.Reader) (*http.Response, string) {
+		t.Helper()
+		request, err := http.NewRequestWithContext(context.Background(), method, server.URL+procedure, body)
+		assert.Nil(t, err)
+		request.Header.Set("Content-Type", contentType)
+		if contentType != "text/unauthenticated" {
+			request.Header.Set("Authorization", "Bearer token")
+		}
+		if method == http.MethodPut {
+			request.Header.Set("Content-Disposition", `attachment; filename="raw.txt"`)
+		}
+		response, err := server.Client().Do(request)
+		assert.Nil(t, err)
+		defer response.Body.Close()
+		data, err := io.ReadAll(response.Body)
+		assert.Nil(t, err)
+		return response, string(data)
+	}
+	t.Run("multipart", func(t *testing.T) {
+		t.Parallel()
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		file, err := form.CreateFormFile("upload", "a.txt")
+		assert.Nil(t, err)
+		_, err = file.Write([]byte("hello world"))
+		assert.Nil(t, err)
+		_, err = form.CreateFormFile("upload", "empty.txt")
+		assert.Nil(t, err)
+		assert.Nil(t, form.Close())
+		response, got := send(t, http.MethodPost, form.FormDataContentType(), &body)
+		assert.Equal(t, response.StatusCode, http.StatusOK)
+		assert.Equal(t, response.Header.Get("Content-Type"), "application/json")
+		assert.Equal(t, got, `"upload/a.txt:hello world;upload/empty.txt:"`)
+	})
+	t.Run("raw", func(t *testing.T) {
+		t.Parallel()
+		response, got := send(t, http.MethodPut, "text/plain", strings.NewReader("raw bytes"))
+		assert.Equal(t, response.StatusCode, http.StatusOK)
+		assert.Equal(t, got, `"/raw.txt:raw bytes"`)
+	})
+	t.Run("error", func(t *testing.T) {
+		t.Parallel()
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		assert.Nil(t, form.Close())
+		response, got := send(t, http.MethodPost, form.FormDataContentType(), &body)
+		assert.Equal(t, response.StatusCode, http.StatusBadRequest)
+		assert.True(t, strings.Contains(got, "no files"))
+	})
+	t.Run("interceptors", func(t *testing.T) {
+		t.Parallel()
+		response, got := send(t, http.MethodPut, "text/unauthenticated", strings.NewReader("raw bytes"))
+		assert.Equal(t, response.StatusCode, http.StatusUnauthorized)
+		assert.True(t, strings.Contains(got, "missing credentials"))
+	})
+	t.Run("too_large", func(t *testing.T) {
+		t.Parallel()
+		response, got := send(t, http.MethodPut, "text/plain", strings.NewReader(strings.Repeat("x", 2048)))
+		assert.Equal(t, response.StatusCode, http.StatusTooManyRequests)
+		assert.True(t, strings.Contains(got, "upload exceeds 1024 bytes"))
+	})
+	t.Run("too_large_multipart", func(t *testing.T) {
+		t.Parallel()
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		file, err := form.CreateFormFile("upload", "a.txt")
+		assert.Nil(t, err)
+		_, err = file.Write([]byte(strings.Repeat("x", 2048)))
+		assert.Nil(t, err)
+		assert.Nil(t, form.Close())
+		response, got := send(t, http.MethodPost, form.FormDataContentType(), &body)
+		assert.Equal(t, response.StatusCode, http.StatusTooManyRequests)
+		assert.True(t, strings.Contains(got, "upload exceeds 1024 bytes"))
+	})
+	t.Run("not_proto", func(t *testing.T) {
+		t.Parallel()
+		type chunk struct {
+			Data []byte
+		}
+		handler := connect.NewUploadHandler(
+			procedure,
+			func(
+				_ context.Context,
+				stream *connect.ClientStream[chunk],
+			) (*connect.Response[wrapperspb.StringValue], error) {
+				var chunks [][]byte
+				for stream.Receive() {
+					chunks = append(chunks, stream.Msg().Data)
+				}
+				if err := stream.Err(); err != nil {
+					return nil, err
+				}
+				return connect.NewResponse(wrapperspb.String(string(bytes.Join(chunks, []byte("|"))))), nil
+			},
+			func(_ connect.UploadPart, data []byte) *chunk {
+				return &chunk{Data: data}
+			},
+			connect.WithUploadChunkSize(4),
+		)
+		server := httptest.NewServer(handler)
+		t.Cleanup(server.Close)
+		response, err := server.Client().Post(server.URL+procedure, "text/plain", strings.NewReader("abcdefghij"))
+		assert.Nil(t, err)
+		defer response.Body.Close()
+		got, err := io.ReadAll(response.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, string(got), `"abcd|efgh|ij"`)
+	})
+}
+
+// authInterceptor rejects streams without an Authorization header.
+type authInterceptor struct{}
+
+func (authInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return next
+}
+
+func (authInterceptor) WrapStreamContext(ctx context.Context) context.Context {
+	return ctx
+}
+
+func (authInterceptor) WrapStreamSender(_ context.Context, sender connect.Sender) connect.Sender {
+	return sender
+}
+
+func (authInterceptor) WrapStreamReceiver(_ context.Context, receiver connect.Receiver) connect.Receiver {
+	return &authReceiver{Receiver: receiver}
+}
+
+type authReceiver struct {
+	connect.Receiver
+}
+
+func (r *authReceiver) Receive(message any) error {
+	if r.Header().Get("Authorization") == "" {
+		return connect.NewError(connect.CodeUnauthenticated, errors.New("missing credentials"))
+	}
+	return r.Receiver.Receive(message)
+}
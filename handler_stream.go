@@ -28,6 +28,9 @@ type ClientStream[Req any] struct {
 	receiver Receiver
 	msg      Req
 	err      error
+	// Non-nil if the handler was constructed with WithLazyUnmarshal.
+	lazy    *lazyMessage
+	decoded bool
 }
 
 // RequestHeader returns the headers received from the client.
@@ -44,6 +47,11 @@ func (c *ClientStream[Req]) Receive() bool {
 	if c.err != nil {
 		return false
 	}
+	if c.lazy != nil {
+		c.decoded = false
+		c.err = c.receiver.Receive(c.lazy)
+		return c.err == nil
+	}
 	c.err = c.receiver.Receive(&c.msg)
 	return c.err == nil
 }
@@ -51,10 +59,37 @@ func (c *ClientStream[Req]) Receive() bool {
 // Msg returns the most recent message unmarshaled by a call to Receive. The
 // returned message points to data that will be overwritten by the next call to
 // Receive.
+//
+// If the handler was constructed with WithLazyUnmarshal, the message is
+// unmarshaled on the first call to Msg. If unmarshaling fails, the next call
+// to Receive returns false and Err returns the unmarshaling error.
 func (c *ClientStream[Req]) Msg() *Req {
+	if c.lazy != nil && !c.decoded && c.err == nil {
+		c.decoded = true
+		if err := c.lazy.Unmarshal(&c.msg); err != nil {
+			c.err = err
+		}
+	}
 	return &c.msg
 }
 
+// ReceiveBatch receives up to limit messages. Like BidiStream.ReceiveBatch, it
+// blocks until limit messages have arrived or the stream stops, and returns
+// the messages received so far along with the error that stopped the stream,
+// if any. The error wraps io.EOF once the client is done sending, and Err
+// reports it like an error from Receive.
+//
+// Batched messages are always unmarshaled eagerly, even if the handler was
+// constructed with WithLazyUnmarshal.
+func (c *ClientStream[Req]) ReceiveBatch(limit int) ([]*Req, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	batch, err := receiveBatch[Req](c.receiver, limit)
+	c.err = err
+	return batch, err
+}
+
 // Err returns the first non-EOF error that was encountered by Receive.
 func (c *ClientStream[Req]) Err() error {
 	if c.err == nil || errors.Is(c.err, io.EOF) {
@@ -92,10 +127,30 @@ func (s *ServerStream[Res]) Send(msg *Res) error {
 	return s.sender.Send(msg)
 }
 
+// SendBatch sends multiple messages to the client, coalescing their writes and
+// flushing the response once. If any message fails to marshal, none of the
+// batch is sent. Like Send, the first call also sends the response headers.
+func (s *ServerStream[Res]) SendBatch(msgs []*Res) error {
+	return sendBatch(s.sender, anySlice(msgs))
+}
+
+// SendUncompressed is like Send, but never compresses the message, even if
+// the rest of the stream is compressed. It's useful for payloads that are
+// already compressed, like JPEG chunks. If interceptors wrap the stream's
+// Sender, the message is sent with Send instead.
+func (s *ServerStream[Res]) SendUncompressed(msg *Res) error {
+	return sendUncompressed(s.sender, msg)
+}
+
 // BidiStream is the handler's view of a bidirectional streaming RPC.
 //
 // It's constructed as part of Handler invocation, but doesn't currently have
 // an exported constructor.
+//
+// One goroutine may call Send and SendBatch while another calls Receive and
+// ReceiveBatch. Calls on the same side of the stream must not be made
+// concurrently, and handlers must not return until any goroutines they've
+// started have stopped using the stream.
 type BidiStream[Req, Res any] struct {
 	sender   Sender
 	receiver Receiver
@@ -136,3 +191,26 @@ func (b *BidiStream[Req, Res]) ResponseTrailer() http.Header {
 func (b *BidiStream[Req, Res]) Send(msg *Res) error {
 	return b.sender.Send(msg)
 }
+
+// SendBatch sends multiple messages to the client, coalescing their writes and
+// flushing the response once. If any message fails to marshal, none of the
+// batch is sent. Like Send, the first call also sends the response headers.
+func (b *BidiStream[Req, Res]) SendBatch(msgs []*Res) error {
+	return sendBatch(b.sender, anySlice(msgs))
+}
+
+// SendUncompressed is like Send, but never compresses the message. See
+// ServerStream.SendUncompressed.
+func (b *BidiStream[Req, Res]) SendUncompressed(msg *Res) error {
+	return sendUncompressed(b.sender, msg)
+}
+
+// ReceiveBatch receives up to limit messages, blocking until limit messages
+// have arrived or the stream stops. It returns the messages received so far
+// along with the error that stopped the stream, if any. When the client is
+// done sending messages, the error wraps io.EOF. Once a stream has received a
+// batch, its reads from the network are buffered, so a single read can return
+// several messages.
+func (b *BidiStream[Req, Res]) ReceiveBatch(limit int) ([]*Req, error) {
+	return receiveBatch[Req](b.receiver, limit)
+}
@@ -16,7 +16,46 @@ package connect
 
 import (
 	"encoding/base64"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers and trailers used by the Connect and gRPC protocols. Keys are in
+// canonical form. Handlers and interceptors may read them, but connect manages
+// their values: most are reserved and can't be changed by application code.
+const (
+	// HeaderConnectTimeout carries a Connect RPC's timeout in milliseconds.
+	HeaderConnectTimeout = "Connect-Timeout-Ms"
+	// HeaderConnectContentEncoding names the compression applied to messages
+	// in Connect streams. Unary Connect RPCs use Content-Encoding instead.
+	HeaderConnectContentEncoding = "Connect-Content-Encoding"
+	// HeaderConnectAcceptEncoding lists the compression algorithms a Connect
+	// streaming client accepts. Unary Connect RPCs use Accept-Encoding instead.
+	HeaderConnectAcceptEncoding = "Connect-Accept-Encoding"
+	// HeaderGRPCTimeout carries a gRPC or gRPC-Web RPC's timeout.
+	HeaderGRPCTimeout = "Grpc-Timeout"
+	// HeaderGRPCEncoding names the compression applied to gRPC messages.
+	HeaderGRPCEncoding = "Grpc-Encoding"
+	// HeaderGRPCAcceptEncoding lists the compression algorithms a gRPC peer
+	// accepts.
+	HeaderGRPCAcceptEncoding = "Grpc-Accept-Encoding"
+	// HeaderGRPCStatus is the trailer carrying a gRPC RPC's status code.
+	HeaderGRPCStatus = "Grpc-Status"
+	// HeaderGRPCMessage is the trailer carrying a gRPC error's percent-encoded
+	// message.
+	HeaderGRPCMessage = "Grpc-Message"
+	// HeaderGRPCStatusDetails is the binary trailer carrying a serialized
+	// google.rpc.Status, including error details.
+	HeaderGRPCStatusDetails = "Grpc-Status-Details-Bin"
+	// HeaderGRPCRetryPushback may be sent by servers to tell clients how long
+	// to wait before retrying, or to stop retrying.
+	HeaderGRPCRetryPushback = "Grpc-Retry-Pushback-Ms"
+	// HeaderEndpointLoadMetrics is the binary trailer carrying a handler's
+	// LoadReport, in the ORCA format.
+	HeaderEndpointLoadMetrics = "Endpoint-Load-Metrics-Bin"
 )
 
 // EncodeBinaryHeader base64-encodes the data. It always emits unpadded values.
@@ -45,8 +84,373 @@ func DecodeBinaryHeader(data string) ([]byte, error) {
 	return base64.StdEncoding.DecodeString(data)
 }
 
+// HeaderInt parses the first value of a header as a base-10 integer. The
+// boolean reports whether the header is set: if it isn't, HeaderInt returns
+// zero, false, and a nil error. Malformed values produce an *Error with
+// CodeInvalidArgument, which handlers can return directly.
+func HeaderInt(header http.Header, key string) (int64, bool, error) {
+	value, ok := headerValue(header, key)
+	if !ok {
+		return 0, false, nil
+	}
+	parsed, err := strconv.ParseInt(value, 10 /* base */, 64 /* bitsize */)
+	if err != nil {
+		return 0, true, errorf(CodeInvalidArgument, "invalid %s header %q: not an integer", key, value)
+	}
+	return parsed, true, nil
+}
+
+// HeaderBool parses the first value of a header as a boolean, accepting the
+// same values as strconv.ParseBool. Missing headers and malformed values are
+// handled as in HeaderInt.
+func HeaderBool(header http.Header, key string) (bool, bool, error) {
+	value, ok := headerValue(header, key)
+	if !ok {
+		return false, false, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, true, errorf(CodeInvalidArgument, "invalid %s header %q: not a boolean", key, value)
+	}
+	return parsed, true, nil
+}
+
+// HeaderTime parses the first value of a header as a time, using a layout
+// understood by time.Parse (for example, http.TimeFormat or time.RFC3339).
+// Missing headers and malformed values are handled as in HeaderInt.
+func HeaderTime(header http.Header, key, layout string) (time.Time, bool, error) {
+	value, ok := headerValue(header, key)
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	parsed, err := time.Parse(layout, value)
+	if err != nil {
+		return time.Time{}, true, errorf(
+			CodeInvalidArgument, "invalid %s header %q: not a time in layout %q", key, value, layout,
+		)
+	}
+	return parsed, true, nil
+}
+
+// headerValue returns the first value of a header, with surrounding
+// whitespace trimmed.
+func headerValue(header http.Header, key string) (string, bool) {
+	values := header.Values(key)
+	if len(values) == 0 {
+		return "", false
+	}
+	return strings.TrimSpace(values[0]), true
+}
+
+// HeaderValues returns all the values of a header. Unlike http.Header's Values
+// method, it also finds values stored under non-canonical spellings of the key
+// (for example, lower-case keys copied from gRPC metadata). Comma-separated
+// values of binary headers, with keys ending in "-Bin", are split into separate
+// values, so each result can be passed to DecodeBinaryHeader.
+func HeaderValues(header http.Header, key string) []string {
+	var values []string
+	for headerKey, headerValues := range header {
+		if strings.EqualFold(headerKey, key) {
+			values = append(values, headerValues...)
+		}
+	}
+	if !isBinaryHeader(key) {
+		return values
+	}
+	split := make([]string, 0, len(values))
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			split = append(split, strings.TrimSpace(part))
+		}
+	}
+	return split
+}
+
+// AppendHeader adds values to a header, keeping any existing values. Values
+// stored under non-canonical spellings of the key are moved to the canonical
+// key, so that HeaderValues and http.Header's methods see the values in order.
+func AppendHeader(header http.Header, key string, values ...string) {
+	canonical := http.CanonicalHeaderKey(key)
+	for headerKey, headerValues := range header {
+		if headerKey != canonical && strings.EqualFold(headerKey, canonical) {
+			header[canonical] = append(header[canonical], headerValues...)
+			delete(header, headerKey)
+		}
+	}
+	header[canonical] = append(header[canonical], values...)
+}
+
+// metadataKey converts a header key to its gRPC metadata form. Metadata keys
+// are always lower-case, including the "-bin" suffix of binary keys.
+func metadataKey(key string) string {
+	return strings.ToLower(key)
+}
+
+// HeaderToMetadata converts headers or trailers to the format used by
+// grpc-go's metadata package, so that middleware and libraries written for
+// grpc-go can be reused. Keys are lower-cased, binary headers (with keys ending
+// in "-Bin") are base64-decoded, and protocol headers are omitted. The result
+// converts directly to a metadata.MD:
+//
+//	md := metadata.MD(connect.HeaderToMetadata(request.Header()))
+func HeaderToMetadata(header http.Header) (map[string][]string, error) {
+	md := make(map[string][]string, len(header))
+	for key, values := range header {
+		if isProtocolHeader(http.CanonicalHeaderKey(key)) {
+			continue
+		}
+		mdKey := metadataKey(key)
+		if !isBinaryHeader(mdKey) {
+			md[mdKey] = append(md[mdKey], values...)
+			continue
+		}
+		for _, value := range values {
+			// Binary values may be comma-separated, as in DecodeBinaryHeader.
+			for _, part := range strings.Split(value, ",") {
+				decoded, err := DecodeBinaryHeader(strings.TrimSpace(part))
+				if err != nil {
+					return nil, fmt.Errorf("invalid binary header %s: %w", key, err)
+				}
+				md[mdKey] = append(md[mdKey], string(decoded))
+			}
+		}
+	}
+	return md, nil
+}
+
+// MetadataToHeader converts grpc-go metadata (a metadata.MD, or any map in the
+// same format) to headers or trailers. It reverses HeaderToMetadata:
+// binary values are base64-encoded, and keys are canonicalized. To attach the
+// result to a request or response, merge it into the existing headers:
+//
+//	for key, values := range connect.MetadataToHeader(md) {
+//	  request.Header()[key] = append(request.Header()[key], values...)
+//	}
+func MetadataToHeader(md map[string][]string) http.Header {
+	header := make(http.Header, len(md))
+	for key, values := range md {
+		headerKey := http.CanonicalHeaderKey(key)
+		if !isBinaryHeader(key) {
+			header[headerKey] = append(header[headerKey], values...)
+			continue
+		}
+		for _, value := range values {
+			header[headerKey] = append(header[headerKey], EncodeBinaryHeader([]byte(value)))
+		}
+	}
+	return header
+}
+
+// isBinaryHeader reports whether a header's values are base64-encoded binary
+// data.
+func isBinaryHeader(key string) bool {
+	return len(key) > 4 && strings.EqualFold(key[len(key)-4:], "-bin")
+}
+
+// headerSize returns the total length of a header's keys and values. Keys
+// are counted once per value, as they are on the wire.
+func headerSize(header http.Header) int {
+	var size int
+	for key, values := range header {
+		for _, value := range values {
+			size += len(key) + len(value)
+		}
+	}
+	return size
+}
+
 func mergeHeaders(into, from http.Header) {
 	for k, vals := range from {
 		into[k] = append(into[k], vals...)
 	}
 }
+
+// isProtocolHeader reports whether the header is used by the Connect, gRPC,
+// or gRPC-Web protocols (or by HTTP itself) rather than by the application.
+// The key must be in canonical form.
+func isProtocolHeader(key string) bool {
+	switch key {
+	case headerContentType, "Content-Length", headerUserAgent,
+		"Connection", "Keep-Alive", "Te", "Trailer", "Transfer-Encoding",
+		connectUnaryHeaderCompression, connectUnaryHeaderAcceptCompression,
+		connectStreamingHeaderCompression, connectStreamingHeaderAcceptCompression,
+		connectHeaderTimeout,
+		grpcHeaderCompression, grpcHeaderAcceptCompression, grpcHeaderTimeout,
+		grpcHeaderStatus, grpcHeaderMessage, grpcHeaderDetails:
+		return true
+	}
+	return false
+}
+
+// mergeNonProtocolHeaders is like mergeHeaders, but skips protocol headers.
+func mergeNonProtocolHeaders(into, from http.Header) {
+	for k, vals := range from {
+		if isProtocolHeader(k) {
+			continue
+		}
+		into[k] = append(into[k], vals...)
+	}
+}
+
+// isReservedHeader reports whether the protocol relies on controlling the
+// header, so application code must not set it. Unlike isProtocolHeader, it
+// doesn't include headers that applications may legitimately override, like
+// User-Agent. The key must be in canonical form.
+func isReservedHeader(key string) bool {
+	switch key {
+	case headerContentType, "Te", "Connect-Protocol-Version",
+		connectUnaryHeaderCompression, connectStreamingHeaderCompression,
+		connectHeaderTimeout, grpcHeaderCompression, grpcHeaderTimeout,
+		grpcHeaderStatus, grpcHeaderMessage, grpcHeaderDetails:
+		return true
+	}
+	return false
+}
+
+// reservedHeaders copies the reserved headers, if any.
+func reservedHeaders(header http.Header) http.Header {
+	var reserved http.Header
+	for key, values := range header {
+		if isReservedHeader(http.CanonicalHeaderKey(key)) {
+			if reserved == nil {
+				reserved = make(http.Header)
+			}
+			reserved[key] = append([]string(nil), values...)
+		}
+	}
+	return reserved
+}
+
+// changedReservedHeader returns the first reserved header whose values differ
+// from the snapshot, or an empty string if none have changed.
+func changedReservedHeader(header, snapshot http.Header) string {
+	if key := conflictingReservedHeader(header, snapshot); key != "" {
+		return key
+	}
+	for key := range snapshot {
+		if _, ok := header[key]; !ok {
+			return key
+		}
+	}
+	return ""
+}
+
+// conflictingReservedHeader returns the first reserved header whose values
+// differ from the snapshot, ignoring headers missing from header, or an empty
+// string if there are none.
+func conflictingReservedHeader(header, snapshot http.Header) string {
+	for key, values := range header {
+		if !isReservedHeader(http.CanonicalHeaderKey(key)) {
+			continue
+		}
+		if !stringSlicesEqual(values, snapshot[key]) {
+			return key
+		}
+	}
+	return ""
+}
+
+// restoreReservedHeaders reverts any changes to reserved headers.
+func restoreReservedHeaders(header, snapshot http.Header) {
+	for key := range header {
+		if isReservedHeader(http.CanonicalHeaderKey(key)) {
+			delete(header, key)
+		}
+	}
+	for key, values := range snapshot {
+		header[key] = append([]string(nil), values...)
+	}
+}
+
+func stringSlicesEqual(left, right []string) bool {
+	if len(left) != len(right) {
+		return false
+	}
+	for i := range left {
+		if left[i] != right[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func errReservedHeader(key string) *Error {
+	return errorf(
+		CodeInternal,
+		"header %q is reserved for use by the protocol and can't be set by application code",
+		http.CanonicalHeaderKey(key),
+	)
+}
+
+// reservedHeaderSender stops application code from corrupting the protocol
+// by changing reserved headers or trailers. It must wrap the protocol's
+// Sender directly, so that it sees changes made by interceptors too.
+// Violations are reverted and reported as errors.
+type reservedHeaderSender struct {
+	Sender
+
+	header        http.Header // reserved headers when the stream was created
+	trailer       http.Header // likewise for trailers
+	checkedHeader bool
+}
+
+func newReservedHeaderSender(sender Sender) *reservedHeaderSender {
+	guarded := &reservedHeaderSender{
+		Sender: sender,
+		header: reservedHeaders(sender.Header()),
+	}
+	if trailer, ok := sender.Trailer(); ok {
+		guarded.trailer = reservedHeaders(trailer)
+	}
+	return guarded
+}
+
+func (s *reservedHeaderSender) Send(msg any) error {
+	if err := s.checkHeader(); err != nil {
+		return err
+	}
+	return s.Sender.Send(msg)
+}
+
+func (s *reservedHeaderSender) sendUncompressed(msg any) error {
+	if err := s.checkHeader(); err != nil {
+		return err
+	}
+	return sendUncompressed(s.Sender, msg)
+}
+
+func (s *reservedHeaderSender) sendBatch(messages []any) error {
+	if err := s.checkHeader(); err != nil {
+		return err
+	}
+	return sendBatch(s.Sender, messages)
+}
+
+func (s *reservedHeaderSender) Close(err error) error {
+	if headerErr := s.checkHeader(); headerErr != nil && err == nil {
+		err = headerErr
+	}
+	if trailer, ok := s.Sender.Trailer(); ok {
+		if key := changedReservedHeader(trailer, s.trailer); key != "" {
+			restoreReservedHeaders(trailer, s.trailer)
+			if err == nil {
+				err = errReservedHeader(key)
+			}
+		}
+	}
+	return s.Sender.Close(err)
+}
+
+// checkHeader verifies the headers before they're sent with the first
+// message. Later changes have no effect, so there's no need to check again.
+func (s *reservedHeaderSender) checkHeader() *Error {
+	if s.checkedHeader {
+		return nil
+	}
+	s.checkedHeader = true
+	if key := changedReservedHeader(s.Sender.Header(), s.header); key != "" {
+		restoreReservedHeaders(s.Sender.Header(), s.header)
+		return errReservedHeader(key)
+	}
+	return nil
+}
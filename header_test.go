@@ -19,6 +19,7 @@ import (
 	"net/http"
 	"testing"
 	"testing/quick"
+	"time"
 
 	"github.com/bufbuild/connect-go/internal/assert"
 )
@@ -56,3 +57,86 @@ func TestHeaderMerge(t *testing.T) {
 	}
 	assert.Equal(t, header, expect)
 }
+
+func TestMetadataInterop(t *testing.T) {
+	t.Parallel()
+	binary := []byte{0, 1, 2, 0xff}
+	header := http.Header{
+		"Foo":           []string{"one", "two"},
+		"Trace-Bin":     []string{EncodeBinaryHeader(binary)},
+		"Content-Type":  []string{"application/grpc"},
+		"Grpc-Timeout":  []string{"1S"},
+		"Multi-Bin":     []string{EncodeBinaryHeader([]byte("a")) + "," + EncodeBinaryHeader([]byte("b"))},
+		"Lowercase-Key": nil,
+	}
+	md, err := HeaderToMetadata(header)
+	assert.Nil(t, err)
+	assert.Equal(t, md, map[string][]string{
+		"foo":           {"one", "two"},
+		"trace-bin":     {string(binary)},
+		"multi-bin":     {"a", "b"},
+		"lowercase-key": nil,
+	})
+	assert.Equal(t, MetadataToHeader(md), http.Header{
+		"Foo":           []string{"one", "two"},
+		"Trace-Bin":     []string{EncodeBinaryHeader(binary)},
+		"Multi-Bin":     []string{EncodeBinaryHeader([]byte("a")), EncodeBinaryHeader([]byte("b"))},
+		"Lowercase-Key": nil,
+	})
+	_, err = HeaderToMetadata(http.Header{"Bad-Bin": []string{"!!!"}})
+	assert.NotNil(t, err)
+}
+
+func TestTypedHeaders(t *testing.T) {
+	t.Parallel()
+	header := http.Header{}
+	header.Set("Retries", " 3 ")
+	header.Set("Dry-Run", "true")
+	header.Set("Not-Before", "Mon, 02 Jan 2006 15:04:05 GMT")
+	header.Set("Bad", "nope")
+
+	retries, ok, err := HeaderInt(header, "retries")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, retries, int64(3))
+	dryRun, ok, err := HeaderBool(header, "Dry-Run")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.True(t, dryRun)
+	notBefore, ok, err := HeaderTime(header, "Not-Before", http.TimeFormat)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, notBefore.Year(), 2006)
+
+	_, ok, err = HeaderInt(header, "Missing")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	_, ok, err = HeaderInt(header, "Bad")
+	assert.True(t, ok)
+	assert.Equal(t, CodeOf(err), CodeInvalidArgument)
+	_, _, err = HeaderBool(header, "Bad")
+	assert.Equal(t, CodeOf(err), CodeInvalidArgument)
+	_, _, err = HeaderTime(header, "Bad", time.RFC3339)
+	assert.Equal(t, CodeOf(err), CodeInvalidArgument)
+	assert.Match(t, err.Error(), `invalid Bad header "nope"`)
+}
+
+func TestHeaderValues(t *testing.T) {
+	t.Parallel()
+	header := http.Header{
+		"X-Foo":     []string{"one"},
+		"x-foo":     []string{"two"},
+		"X-Foo-Bin": []string{"YQ, Yg", "Yw"},
+	}
+	assert.Equal(t, len(HeaderValues(header, "X-FOO")), 2)
+	assert.Equal(t, HeaderValues(header, "x-foo-bin"), []string{"YQ", "Yg", "Yw"})
+	assert.Zero(t, HeaderValues(header, "X-Bar"))
+
+	AppendHeader(header, "x-foo", "three", "four")
+	_, ok := header["x-foo"]
+	assert.False(t, ok)
+	assert.Equal(t, len(header.Values("X-Foo")), 4)
+	assert.Equal(t, header.Values("X-Foo")[3], "four")
+	assert.Equal(t, metadataKey(HeaderGRPCStatusDetails), "grpc-status-details-bin")
+}
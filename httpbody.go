@@ -0,0 +1,188 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"net/http"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	httpBodyName             protoreflect.FullName = "google.api.HttpBody"
+	httpBodyFieldContentType protoreflect.Name     = "content_type"
+	httpBodyFieldData        protoreflect.Name     = "data"
+	httpBodyDefaultType                            = "application/octet-stream"
+)
+
+// httpBodyMessage returns the reflective view of message if it's a
+// google.api.HttpBody. We match on the message's name rather than importing
+// genproto, so any generated copy of httpbody.proto works.
+func httpBodyMessage(message any) (protoreflect.Message, bool) {
+	protoMessage, ok := message.(proto.Message)
+	if !ok {
+		return nil, false
+	}
+	reflected := protoMessage.ProtoReflect()
+	if reflected.Descriptor().FullName() != httpBodyName {
+		return nil, false
+	}
+	return reflected, true
+}
+
+func isHTTPBody(message any) bool {
+	_, ok := httpBodyMessage(message)
+	return ok
+}
+
+// isHTTPBodyContentType reports whether a request with the Content-Type
+// should be served raw by procedures using WithHTTPBody. The RPC protocols
+// keep their own Content-Types, even ones the handler doesn't support, so
+// that misconfigured RPC clients get an HTTP 415 rather than a confusing
+// error from the implementation. Binary Protobuf stays with Connect, so
+// Connect clients can still call these procedures.
+func isHTTPBodyContentType(contentType string) bool {
+	switch {
+	case contentType == connectUnaryContentTypePrefix+codecNameProto:
+		return false
+	case strings.HasPrefix(contentType, connectStreamingContentTypePrefix):
+		return false
+	case strings.HasPrefix(contentType, grpcContentTypeDefault):
+		// Includes gRPC-Web.
+		return false
+	}
+	return true
+}
+
+func httpBodyField(message protoreflect.Message, name protoreflect.Name) protoreflect.FieldDescriptor {
+	return message.Descriptor().Fields().ByName(name)
+}
+
+// httpBodyHandler serves unary procedures using WithHTTPBody to callers that
+// aren't using an RPC protocol at all. An HttpBody request is populated from
+// the request's Content-Type and raw body, and an HttpBody response is
+// written as a raw body with the declared Content-Type
+// (application/octet-stream if unset). Other messages are read and written
+// as JSON. Errors, timeouts, and compression work as they do for unary
+// Connect.
+type httpBodyHandler struct {
+	connectHandler
+}
+
+func newHTTPBodyHandler(params *protocolHandlerParams, fallback Codec) *httpBodyHandler {
+	handler := &httpBodyHandler{connectHandler: connectHandler{protocolHandlerParams: *params}}
+	handler.Codecs = &httpBodyCodecs{
+		readOnlyCodecs: params.Codecs,
+		codec:          &httpBodyCodec{fallback: fallback},
+	}
+	return handler
+}
+
+// ContentTypes is empty: Handler chooses httpBodyHandler with
+// isHTTPBodyContentType instead.
+func (h *httpBodyHandler) ContentTypes() map[string]struct{} {
+	return nil
+}
+
+// CodecName is empty, since raw bodies aren't encoded with a Codec.
+func (h *httpBodyHandler) CodecName(string) string {
+	return ""
+}
+
+func (h *httpBodyHandler) NewStream(
+	responseWriter http.ResponseWriter,
+	request *http.Request,
+) (Sender, Receiver, error) {
+	sender, receiver, err := h.connectHandler.NewStream(responseWriter, request)
+	contentType := request.Header.Get(headerContentType)
+	return &httpBodySender{Sender: sender}, &httpBodyReceiver{Receiver: receiver, contentType: contentType}, err
+}
+
+// httpBodySender sets the response's Content-Type from the message before
+// writing it.
+type httpBodySender struct {
+	Sender
+}
+
+func (s *httpBodySender) Send(message any) error {
+	contentType := connectUnaryContentTypeJSON
+	if body, ok := httpBodyMessage(message); ok {
+		contentType = body.Get(httpBodyField(body, httpBodyFieldContentType)).String()
+		if contentType == "" {
+			contentType = httpBodyDefaultType
+		}
+	}
+	s.Header().Set(headerContentType, contentType)
+	return s.Sender.Send(message)
+}
+
+// httpBodyReceiver fills in the HttpBody's content_type field, since the
+// codec only sees the body.
+type httpBodyReceiver struct {
+	Receiver
+
+	contentType string
+}
+
+func (r *httpBodyReceiver) Receive(message any) error {
+	if err := r.Receiver.Receive(message); err != nil {
+		return err
+	}
+	if body, ok := httpBodyMessage(message); ok {
+		body.Set(httpBodyField(body, httpBodyFieldContentType), protoreflect.ValueOfString(r.contentType))
+	}
+	return nil
+}
+
+// httpBodyCodecs uses the same codec whatever the Content-Type.
+type httpBodyCodecs struct {
+	readOnlyCodecs
+
+	codec Codec
+}
+
+func (c *httpBodyCodecs) Get(string) Codec {
+	return c.codec
+}
+
+// httpBodyCodec marshals HttpBody messages to and from their data field,
+// deferring to the fallback codec for other messages.
+type httpBodyCodec struct {
+	fallback Codec
+}
+
+var _ Codec = (*httpBodyCodec)(nil)
+
+func (c *httpBodyCodec) Name() string { return c.fallback.Name() }
+
+func (c *httpBodyCodec) Marshal(message any) ([]byte, error) {
+	body, ok := httpBodyMessage(message)
+	if !ok {
+		return c.fallback.Marshal(message)
+	}
+	return body.Get(httpBodyField(body, httpBodyFieldData)).Bytes(), nil
+}
+
+func (c *httpBodyCodec) Unmarshal(data []byte, message any) error {
+	body, ok := httpBodyMessage(message)
+	if !ok {
+		return c.fallback.Unmarshal(data, message)
+	}
+	// The data is backed by a pooled buffer, so we must copy it.
+	body.Set(httpBodyField(body, httpBodyFieldData), protoreflect.ValueOfBytes(append([]byte(nil), data...)))
+	return nil
+}
@@ -0,0 +1,298 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// An IdempotencyLevel describes whether a procedure is safe to call more than
+// once. It mirrors the idempotency_level option on Protobuf methods, which
+// protoc-gen-connect-go passes to clients and handlers with WithIdempotency.
+// Clients only retry procedures that are marked as safe to repeat; see
+// NewRetryInterceptor.
+type IdempotencyLevel int
+
+const (
+	// IdempotencyUnknown is the default level: the procedure may have side
+	// effects, so it isn't safe to call more than once.
+	IdempotencyUnknown IdempotencyLevel = iota
+	// IdempotencyNoSideEffects means the procedure has no side effects, like
+	// an HTTP GET.
+	IdempotencyNoSideEffects
+	// IdempotencyIdempotent means the procedure may have side effects, but
+	// calling it more than once has the same effect as calling it once, like
+	// an HTTP PUT or DELETE.
+	IdempotencyIdempotent
+)
+
+func (l IdempotencyLevel) String() string {
+	switch l {
+	case IdempotencyUnknown:
+		return "idempotency_unknown"
+	case IdempotencyNoSideEffects:
+		return "no_side_effects"
+	case IdempotencyIdempotent:
+		return "idempotent"
+	}
+	return fmt.Sprintf("idempotency_level_%d", int(l))
+}
+
+// safeToRepeat reports whether calls at this level may be sent more than once.
+func (l IdempotencyLevel) safeToRepeat() bool {
+	return l == IdempotencyNoSideEffects || l == IdempotencyIdempotent
+}
+
+const headerIdempotencyKey = "Idempotency-Key"
+
+type idempotencyKeyContextKey struct{}
+
+// IdempotencyKeyFromContext returns the Idempotency-Key sent by the client, if
+// any. Handlers can use the key to recognize retries of a call they've already
+// processed and return the original result instead of repeating side effects.
+//
+// Clients attach keys to unary calls when constructed with WithIdempotencyKey.
+func IdempotencyKeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(idempotencyKeyContextKey{}).(string)
+	return key, ok
+}
+
+// withIdempotencyKey stores the request's Idempotency-Key, if any, in the
+// context.
+func withIdempotencyKey(ctx context.Context, header http.Header) context.Context {
+	key := header.Get(headerIdempotencyKey)
+	if key == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, idempotencyKeyContextKey{}, key)
+}
+
+type outgoingIdempotencyKeyContextKey struct{}
+
+// withOutgoingIdempotencyKey generates an Idempotency-Key for a call, unless
+// the caller supplied one in the request headers, and stores it in the
+// context. The key isn't written to the caller's Request: requests may be
+// sent again as new logical calls, which need new keys.
+func withOutgoingIdempotencyKey(ctx context.Context, header http.Header) (context.Context, error) {
+	if header.Get(headerIdempotencyKey) != "" {
+		return ctx, nil
+	}
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return ctx, errorf(CodeInternal, "generate idempotency key: %w", err)
+	}
+	return context.WithValue(ctx, outgoingIdempotencyKeyContextKey{}, hex.EncodeToString(raw[:])), nil
+}
+
+// outgoingHeader returns a copy of the headers to send for one attempt of a
+// call, adding the call's generated Idempotency-Key, if any. Every attempt of
+// the call shares the key, so retries are recognizable. The protocol adds
+// per-attempt headers, like timeouts, to the copy, so the request's own
+// headers stay reusable.
+func outgoingHeader(ctx context.Context, header http.Header) http.Header {
+	header = header.Clone()
+	if key, ok := ctx.Value(outgoingIdempotencyKeyContextKey{}).(string); ok {
+		header.Set(headerIdempotencyKey, key)
+	}
+	return header
+}
+
+// An IdempotencyStore holds the results of calls made with an
+// Idempotency-Key, so that handlers can replay them when clients retry or
+// queues redeliver the call. Stores may be shared between handlers and
+// between processes: keys include the procedure. Implementations must be safe
+// to call concurrently.
+type IdempotencyStore interface {
+	// Load returns the result stored under the key, and whether one was found.
+	// Expired results must not be returned.
+	Load(ctx context.Context, key string) ([]byte, bool, error)
+	// Store saves a result under the key for the supplied duration.
+	Store(ctx context.Context, key string, result []byte, ttl time.Duration) error
+}
+
+// MemoryIdempotencyStore is an IdempotencyStore that keeps results in memory.
+// Expired results are evicted lazily. Handlers check expiration with their
+// own Clock, so the store's use of the system clock only affects when memory
+// is reclaimed.
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	results   map[string]memoryIdempotencyResult
+	sweepSize int // sweep expired results when the map grows past this size
+}
+
+type memoryIdempotencyResult struct {
+	result  []byte
+	expires time.Time
+}
+
+// NewMemoryIdempotencyStore constructs an empty MemoryIdempotencyStore.
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		results:   make(map[string]memoryIdempotencyResult),
+		sweepSize: 1024,
+	}
+}
+
+// Load implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Load(_ context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.results[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if !time.Now().Before(stored.expires) {
+		delete(s.results, key)
+		return nil, false, nil
+	}
+	return stored.result, true, nil
+}
+
+// Store implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Store(_ context.Context, key string, result []byte, ttl time.Duration) error {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results[key] = memoryIdempotencyResult{result: result, expires: now.Add(ttl)}
+	if len(s.results) > s.sweepSize {
+		for key, stored := range s.results {
+			if !now.Before(stored.expires) {
+				delete(s.results, key)
+			}
+		}
+		// Sweep again once the live results have doubled, so sweeping stays
+		// cheap on average.
+		s.sweepSize = 2 * len(s.results)
+		if s.sweepSize < 1024 {
+			s.sweepSize = 1024
+		}
+	}
+	return nil
+}
+
+// idempotencyCache replays the results of unary calls with an
+// Idempotency-Key.
+type idempotencyCache struct {
+	store IdempotencyStore
+	ttl   time.Duration
+	scope func(context.Context, AnyRequest) (string, error) // nil unless configured
+	clock Clock
+	codec Codec
+}
+
+// idempotentResult is the serialized form of a cached response.
+type idempotentResult struct {
+	// RequestHash identifies the request message, so that keys reused for
+	// other requests are rejected.
+	RequestHash string      `json:"request_hash"`
+	Expires     time.Time   `json:"expires"`
+	Header      http.Header `json:"header,omitempty"`
+	Trailer     http.Header `json:"trailer,omitempty"`
+	Message     []byte      `json:"message"`
+}
+
+// callIdempotent calls unary, unless the cache already holds a result for the
+// request's Idempotency-Key. Only successful responses are cached, so retries
+// of failed calls run again. Store failures are ignored: the cache is an
+// optimization, and the call proceeds as though nothing was stored.
+func callIdempotent[Req, Res any](
+	ctx context.Context,
+	cache *idempotencyCache,
+	request *Request[Req],
+	unary func(context.Context, *Request[Req]) (*Response[Res], error),
+) (*Response[Res], error) {
+	idempotencyKey, ok := IdempotencyKeyFromContext(ctx)
+	if cache == nil || !ok {
+		return unary(ctx, request)
+	}
+	var scope string
+	if cache.scope != nil {
+		var err error
+		if scope, err = cache.scope(ctx, request); err != nil {
+			return nil, err
+		}
+	}
+	requestHash, err := cache.hash(request.Msg)
+	if err != nil {
+		return unary(ctx, request)
+	}
+	// Quote the scope, so that scopes and keys containing spaces can't collide.
+	key := request.Spec().Procedure + " " + strconv.Quote(scope) + " " + idempotencyKey
+	if raw, found, err := cache.store.Load(ctx, key); err == nil && found {
+		var result idempotentResult
+		if json.Unmarshal(raw, &result) == nil && cache.clock.Now().Before(result.Expires) {
+			if result.RequestHash != requestHash {
+				return nil, errorf(
+					CodeInvalidArgument,
+					"%s %q was already used for a different request",
+					headerIdempotencyKey, idempotencyKey,
+				)
+			}
+			response := &Response[Res]{Msg: new(Res)}
+			if cache.codec.Unmarshal(result.Message, response.Msg) == nil {
+				response.header = result.Header
+				response.trailer = result.Trailer
+				return response, nil
+			}
+		}
+	}
+	response, err := unary(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	message, err := cache.codec.Marshal(response.Msg)
+	if err != nil {
+		return response, nil //nolint:nilerr // uncacheable responses are still valid
+	}
+	raw, err := json.Marshal(&idempotentResult{
+		RequestHash: requestHash,
+		Expires:     cache.clock.Now().Add(cache.ttl),
+		Header:      response.header,
+		Trailer:     response.trailer,
+		Message:     message,
+	})
+	if err == nil {
+		_ = cache.store.Store(ctx, key, raw, cache.ttl)
+	}
+	return response, nil
+}
+
+// hash identifies a request message. Protobuf messages are marshaled
+// deterministically, so equal messages have equal hashes.
+func (c *idempotencyCache) hash(message any) (string, error) {
+	var data []byte
+	var err error
+	if protoMessage, ok := message.(proto.Message); ok {
+		data, err = proto.MarshalOptions{Deterministic: true}.Marshal(protoMessage)
+	} else {
+		data, err = c.codec.Marshal(message)
+	}
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,173 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// idleStreamTimeout aborts handler streams that send and receive no messages
+// for longer than the timeout.
+type idleStreamTimeout struct {
+	timeout time.Duration
+	clock   Clock
+}
+
+// start arms the timeout for a stream. When it expires, it cancels the
+// returned context and closes the request body, which unblocks pending calls
+// to Receive. The returned stop function disarms the timeout and must be
+// called once the stream is finished.
+func (t *idleStreamTimeout) start(
+	ctx context.Context,
+	body io.Closer,
+) (context.Context, *idleTimer, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	timer := &idleTimer{
+		timeout: t.timeout,
+		clock:   t.clock,
+		expire: func() {
+			cancel()
+			_ = body.Close()
+		},
+	}
+	timer.touch()
+	timer.schedule(t.timeout)
+	return ctx, timer, func() {
+		timer.stop()
+		cancel()
+	}
+}
+
+// idleTimer tracks the most recent message activity on a stream. Rather than
+// resetting a timer for every message, it checks for activity when the timer
+// fires and reschedules itself if the stream hasn't been idle long enough.
+type idleTimer struct {
+	// 64-bit atomics must be 64-bit aligned, which is only guaranteed for the
+	// first word of an allocated struct on 32-bit platforms.
+	lastActivity int64 // atomic, Unix nanoseconds
+	expired      int32 // atomic, 1 once the timeout has expired
+
+	timeout time.Duration
+	clock   Clock
+	expire  func()
+
+	mu      sync.Mutex
+	stopped bool
+	cancel  func() bool
+}
+
+func (t *idleTimer) touch() {
+	atomic.StoreInt64(&t.lastActivity, t.clock.Now().UnixNano())
+}
+
+func (t *idleTimer) schedule(after time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped {
+		return
+	}
+	t.cancel = t.clock.AfterFunc(after, t.check)
+}
+
+func (t *idleTimer) check() {
+	idle := time.Duration(t.clock.Now().UnixNano() - atomic.LoadInt64(&t.lastActivity))
+	if idle < t.timeout {
+		t.schedule(t.timeout - idle)
+		return
+	}
+	t.mu.Lock()
+	stopped := t.stopped
+	t.mu.Unlock()
+	if stopped {
+		return
+	}
+	atomic.StoreInt32(&t.expired, 1)
+	t.expire()
+}
+
+func (t *idleTimer) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopped = true
+	if t.cancel != nil {
+		t.cancel()
+	}
+}
+
+// wrap replaces errors caused by the timeout with CodeDeadlineExceeded.
+func (t *idleTimer) wrap(err error) error {
+	if err == nil || atomic.LoadInt32(&t.expired) == 0 {
+		return err
+	}
+	return errorf(CodeDeadlineExceeded, "stream idle for more than %v", t.timeout)
+}
+
+// idleSender records outbound message activity.
+type idleSender struct {
+	Sender
+
+	timer *idleTimer
+}
+
+func (s *idleSender) Send(msg any) error {
+	err := s.Sender.Send(msg)
+	if err == nil {
+		s.timer.touch()
+	}
+	return s.timer.wrap(err)
+}
+
+func (s *idleSender) sendUncompressed(msg any) error {
+	err := sendUncompressed(s.Sender, msg)
+	if err == nil {
+		s.timer.touch()
+	}
+	return s.timer.wrap(err)
+}
+
+func (s *idleSender) sendBatch(messages []any) error {
+	err := sendBatch(s.Sender, messages)
+	if err == nil {
+		s.timer.touch()
+	}
+	return s.timer.wrap(err)
+}
+
+func (s *idleSender) Close(err error) error {
+	return s.Sender.Close(s.timer.wrap(err))
+}
+
+// idleReceiver records inbound message activity.
+type idleReceiver struct {
+	Receiver
+
+	timer *idleTimer
+}
+
+func (r *idleReceiver) Receive(msg any) error {
+	err := r.Receiver.Receive(msg)
+	if err == nil {
+		r.timer.touch()
+	}
+	return r.timer.wrap(err)
+}
+
+func (r *idleReceiver) readAhead() {
+	readAhead(r.Receiver)
+}
@@ -85,6 +85,73 @@ func (f UnaryInterceptorFunc) WrapStreamReceiver(_ context.Context, receiver Rec
 	return receiver
 }
 
+// InterceptIf applies an interceptor only to calls whose Spec matches, so
+// expensive interceptors like authentication or validation can skip health
+// checks and reflection without splitting services across muxes:
+//
+//	connect.WithInterceptors(connect.InterceptIf(
+//	  func(spec connect.Spec) bool {
+//	    return !strings.HasPrefix(spec.Procedure, "/grpc.health.v1.Health/")
+//	  },
+//	  authInterceptor,
+//	))
+//
+// The matcher must be safe to call concurrently.
+func InterceptIf(matcher func(Spec) bool, interceptor Interceptor) Interceptor {
+	return &conditionalInterceptor{matcher: matcher, interceptor: interceptor}
+}
+
+type conditionalInterceptor struct {
+	matcher     func(Spec) bool
+	interceptor Interceptor
+}
+
+func (c *conditionalInterceptor) WrapUnary(next UnaryFunc) UnaryFunc {
+	wrapped := c.interceptor.WrapUnary(next)
+	return func(ctx context.Context, request AnyRequest) (AnyResponse, error) {
+		if c.matcher(request.Spec()) {
+			return wrapped(ctx, request)
+		}
+		return next(ctx, request)
+	}
+}
+
+func (c *conditionalInterceptor) WrapStreamContext(ctx context.Context) context.Context {
+	// If the caller didn't tell us which procedure this is, err on the side of
+	// applying the interceptor.
+	if spec, ok := specFromContext(ctx); ok && !c.matcher(spec) {
+		return ctx
+	}
+	return c.interceptor.WrapStreamContext(ctx)
+}
+
+func (c *conditionalInterceptor) WrapStreamSender(ctx context.Context, sender Sender) Sender {
+	if !c.matcher(sender.Spec()) {
+		return sender
+	}
+	return c.interceptor.WrapStreamSender(ctx, sender)
+}
+
+func (c *conditionalInterceptor) WrapStreamReceiver(ctx context.Context, receiver Receiver) Receiver {
+	if !c.matcher(receiver.Spec()) {
+		return receiver
+	}
+	return c.interceptor.WrapStreamReceiver(ctx, receiver)
+}
+
+type specContextKey struct{}
+
+// withSpec makes the Spec visible to WrapStreamContext, which doesn't
+// otherwise know which procedure it's wrapping.
+func withSpec(ctx context.Context, spec Spec) context.Context {
+	return context.WithValue(ctx, specContextKey{}, spec)
+}
+
+func specFromContext(ctx context.Context) (Spec, bool) {
+	spec, ok := ctx.Value(specContextKey{}).(Spec)
+	return spec, ok
+}
+
 // A chain composes multiple interceptors into one.
 type chain struct {
 	interceptors []Interceptor
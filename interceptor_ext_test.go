@@ -16,10 +16,13 @@ package connect_test
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/bufbuild/connect-go"
 	"github.com/bufbuild/connect-go/internal/assert"
@@ -207,6 +210,411 @@ type headerInterceptor struct {
 
 // newHeaderInterceptor constructs a headerInterceptor. Nil function pointers
 // are treated as no-ops.
+func TestRetryInterceptor(t *testing.T) {
+	t.Parallel()
+	const pingProcedure = "/" + pingv1connect.PingServiceName + "/Ping"
+	var attempts int32
+	mux := http.NewServeMux()
+	mux.Handle(pingProcedure, connect.NewUnaryHandler(
+		pingProcedure,
+		func(_ context.Context, request *connect.Request[pingv1.PingRequest]) (*connect.Response[pingv1.PingResponse], error) {
+			attempt := atomic.AddInt32(&attempts, 1)
+			switch request.Msg.Text {
+			case "succeed-third":
+				if attempt < 3 {
+					return nil, connect.NewError(connect.CodeUnavailable, errors.New("try again"))
+				}
+				return connect.NewResponse(&pingv1.PingResponse{Number: int64(attempt)}), nil
+			case "pushback":
+				err := connect.NewError(connect.CodeUnavailable, errors.New("try again"))
+				if attempt == 1 {
+					err.Meta().Set("Grpc-Retry-Pushback-Ms", "50")
+					return nil, err
+				}
+				return connect.NewResponse(&pingv1.PingResponse{Number: int64(attempt)}), nil
+			case "stop":
+				err := connect.NewError(connect.CodeUnavailable, errors.New("go away"))
+				err.Meta().Set("Grpc-Retry-Pushback-Ms", "-1")
+				return nil, err
+			case "invalid":
+				return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("bad request"))
+			case "ok":
+				return connect.NewResponse(&pingv1.PingResponse{Number: int64(attempt)}), nil
+			}
+			return nil, connect.NewError(connect.CodeUnavailable, errors.New("down"))
+		},
+	))
+	server := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		server.Close()
+	})
+	retry := connect.NewRetryInterceptor(connect.RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+	})
+	for _, protocol := range []string{connect.ProtocolConnect, connect.ProtocolGRPC} {
+		client := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL,
+			connect.WithProtocol(protocol),
+			connect.WithIdempotency(connect.IdempotencyNoSideEffects),
+			connect.WithInterceptors(retry),
+		)
+		ping := func(text string) (*connect.Response[pingv1.PingResponse], error) {
+			atomic.StoreInt32(&attempts, 0)
+			return client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Text: text}))
+		}
+		response, err := ping("succeed-third")
+		assert.Nil(t, err, assert.Sprintf("%s: succeed-third", protocol))
+		assert.Equal(t, response.Msg.Number, 3)
+
+		start := time.Now()
+		response, err = ping("pushback")
+		assert.Nil(t, err, assert.Sprintf("%s: pushback", protocol))
+		assert.Equal(t, response.Msg.Number, 2)
+		assert.True(t, time.Since(start) >= 50*time.Millisecond)
+
+		_, err = ping("stop")
+		assert.Equal(t, connect.CodeOf(err), connect.CodeUnavailable)
+		assert.Equal(t, atomic.LoadInt32(&attempts), 1)
+
+		_, err = ping("invalid")
+		assert.Equal(t, connect.CodeOf(err), connect.CodeInvalidArgument)
+		assert.Equal(t, atomic.LoadInt32(&attempts), 1)
+
+		_, err = ping("down")
+		assert.Equal(t, connect.CodeOf(err), connect.CodeUnavailable)
+		assert.Equal(t, atomic.LoadInt32(&attempts), 4)
+	}
+	t.Run("clock", func(t *testing.T) {
+		// Backoff delays are timed with the policy's clock, so advancing it
+		// retries without sleeping.
+		clock := newFakeClock(time.Now())
+		client := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL,
+			connect.WithIdempotency(connect.IdempotencyNoSideEffects),
+			connect.WithInterceptors(connect.NewRetryInterceptor(connect.RetryPolicy{
+				InitialBackoff: time.Hour,
+				MaxBackoff:     time.Hour,
+				Clock:          clock,
+			})),
+		)
+		atomic.StoreInt32(&attempts, 0)
+		done := make(chan error, 1)
+		go func() {
+			_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Text: "succeed-third"}))
+			done <- err
+		}()
+		for {
+			select {
+			case err := <-done:
+				assert.Nil(t, err)
+				assert.Equal(t, atomic.LoadInt32(&attempts), 3)
+				return
+			case <-time.After(time.Millisecond):
+				clock.Advance(time.Hour)
+			}
+		}
+	})
+	t.Run("idempotency", func(t *testing.T) {
+		ping := func(client pingv1connect.PingServiceClient) int32 {
+			atomic.StoreInt32(&attempts, 0)
+			_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Text: "down"}))
+			assert.Equal(t, connect.CodeOf(err), connect.CodeUnavailable)
+			return atomic.LoadInt32(&attempts)
+		}
+		// Procedures that aren't marked as safe to repeat aren't retried.
+		unsafe := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL,
+			connect.WithInterceptors(retry),
+		)
+		assert.Equal(t, ping(unsafe), 1)
+		// Policies can override the level of individual procedures, in either
+		// direction.
+		overridden := func(level connect.IdempotencyLevel, options ...connect.ClientOption) pingv1connect.PingServiceClient {
+			options = append(options, connect.WithInterceptors(connect.NewRetryInterceptor(connect.RetryPolicy{
+				MaxAttempts:    2,
+				InitialBackoff: time.Millisecond,
+				MaxBackoff:     time.Millisecond,
+				Idempotency:    map[string]connect.IdempotencyLevel{pingProcedure: level},
+			})))
+			return pingv1connect.NewPingServiceClient(server.Client(), server.URL, options...)
+		}
+		assert.Equal(t, ping(overridden(connect.IdempotencyIdempotent)), 2)
+		assert.Equal(t, ping(overridden(
+			connect.IdempotencyUnknown,
+			connect.WithIdempotency(connect.IdempotencyIdempotent),
+		)), 1)
+	})
+	t.Run("throttle", func(t *testing.T) {
+		client := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL,
+			connect.WithIdempotency(connect.IdempotencyNoSideEffects),
+			connect.WithInterceptors(connect.NewRetryInterceptor(connect.RetryPolicy{
+				MaxAttempts:    5,
+				InitialBackoff: time.Millisecond,
+				MaxBackoff:     time.Millisecond,
+				Throttle:       connect.NewRetryThrottle(4, 1),
+			})),
+		)
+		ping := func(text string) error {
+			atomic.StoreInt32(&attempts, 0)
+			_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Text: text}))
+			return err
+		}
+		// Tokens go from 4 to 3 (retry allowed), then to 2 (throttled).
+		assert.NotNil(t, ping("down"))
+		assert.Equal(t, atomic.LoadInt32(&attempts), 2)
+		assert.NotNil(t, ping("down"))
+		assert.Equal(t, atomic.LoadInt32(&attempts), 1)
+		// Three successes bring the tokens from 1 back to 4, so one retry is
+		// allowed again.
+		assert.Nil(t, ping("ok"))
+		assert.Nil(t, ping("ok"))
+		assert.Nil(t, ping("ok"))
+		assert.NotNil(t, ping("down"))
+		assert.Equal(t, atomic.LoadInt32(&attempts), 2)
+	})
+}
+
+func TestInterceptIf(t *testing.T) {
+	t.Parallel()
+	pingProcedure := "/" + pingv1connect.PingServiceName + "/Ping"
+	skipPing := func(spec connect.Spec) bool {
+		return spec.Procedure != pingProcedure
+	}
+	handlerCounts := &countingInterceptor{}
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		pingServer{},
+		connect.WithInterceptors(connect.InterceptIf(skipPing, handlerCounts)),
+	))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	clientCounts := &countingInterceptor{}
+	client := pingv1connect.NewPingServiceClient(
+		server.Client(),
+		server.URL,
+		connect.WithInterceptors(connect.InterceptIf(skipPing, clientCounts)),
+	)
+
+	_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{}))
+	assert.Nil(t, err)
+	for _, counts := range []*countingInterceptor{handlerCounts, clientCounts} {
+		assert.Equal(t, counts.total(), 0)
+	}
+
+	_, err = client.Fail(context.Background(), connect.NewRequest(&pingv1.FailRequest{Code: int32(connect.CodeInternal)}))
+	assert.Equal(t, connect.CodeOf(err), connect.CodeInternal)
+	stream := client.Sum(context.Background())
+	assert.Nil(t, stream.Send(&pingv1.SumRequest{Number: 1}))
+	_, err = stream.CloseAndReceive()
+	assert.Nil(t, err)
+	for _, counts := range []*countingInterceptor{handlerCounts, clientCounts} {
+		assert.Equal(t, atomic.LoadInt32(&counts.unary), 1)
+		assert.Equal(t, atomic.LoadInt32(&counts.streamContext), 1)
+		assert.Equal(t, atomic.LoadInt32(&counts.sender), 1)
+		assert.Equal(t, atomic.LoadInt32(&counts.receiver), 1)
+	}
+}
+
+func TestShadowInterceptor(t *testing.T) {
+	t.Parallel()
+	received := make(chan *connect.Request[pingv1.PingRequest], 10)
+	shadowMux := http.NewServeMux()
+	shadowMux.Handle(pingv1connect.NewPingServiceHandler(shadowPingServer{received: received}))
+	shadow := httptest.NewServer(shadowMux)
+	t.Cleanup(shadow.Close)
+
+	completed := make(chan error, 10)
+	mux := http.NewServeMux()
+	mux.Handle(pingv1connect.NewPingServiceHandler(
+		pingServer{},
+		connect.WithInterceptors(connect.NewShadowInterceptor(connect.ShadowConfig{
+			// HTTPClient defaults to http.DefaultClient.
+			BaseURL:    shadow.URL + "/",
+			Options:    []connect.ClientOption{connect.WithGRPC()},
+			SampleRate: 1,
+			OnComplete: func(spec connect.Spec, err error) {
+				assert.False(t, spec.IsClient)
+				completed <- err
+			},
+		})),
+	))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	client := pingv1connect.NewPingServiceClient(server.Client(), server.URL)
+
+	request := connect.NewRequest(&pingv1.PingRequest{Number: 42})
+	request.Header().Set("Shadow-Test", "mirrored")
+	request.Header().Set("Authorization", "Bearer production")
+	response, err := client.Ping(context.Background(), request)
+	assert.Nil(t, err)
+	assert.Equal(t, response.Msg.Number, 42)
+	assert.Nil(t, <-completed)
+	mirrored := <-received
+	assert.Equal(t, mirrored.Msg.Number, 42)
+	assert.Equal(t, mirrored.Header().Get("Shadow-Test"), "mirrored")
+	// Credentials are stripped by default.
+	assert.Zero(t, mirrored.Header().Values("Authorization"))
+
+	// Failures in the shadow don't affect the caller.
+	response, err = client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: -1}))
+	assert.Nil(t, err)
+	assert.Equal(t, response.Msg.Number, -1)
+	assert.Equal(t, connect.CodeOf(<-completed), connect.CodeInternal)
+	<-received
+
+	// Streams aren't mirrored.
+	stream := client.Sum(context.Background())
+	assert.Nil(t, stream.Send(&pingv1.SumRequest{Number: 1}))
+	_, err = stream.CloseAndReceive()
+	assert.Nil(t, err)
+	select {
+	case <-received:
+		t.Fatal("stream was mirrored")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// A zero sample rate mirrors nothing.
+	unsampledMux := http.NewServeMux()
+	unsampledMux.Handle(pingv1connect.NewPingServiceHandler(
+		pingServer{},
+		connect.WithInterceptors(connect.NewShadowInterceptor(connect.ShadowConfig{
+			HTTPClient: shadow.Client(),
+			BaseURL:    shadow.URL,
+		})),
+	))
+	unsampled := httptest.NewServer(unsampledMux)
+	t.Cleanup(unsampled.Close)
+	client = pingv1connect.NewPingServiceClient(unsampled.Client(), unsampled.URL)
+	_, err = client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 1}))
+	assert.Nil(t, err)
+	select {
+	case <-received:
+		t.Fatal("request was mirrored with a zero sample rate")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestFaultInterceptor(t *testing.T) {
+	t.Parallel()
+	newClient := func(t *testing.T, config connect.FaultConfig) pingv1connect.PingServiceClient {
+		t.Helper()
+		mux := http.NewServeMux()
+		mux.Handle(pingv1connect.NewPingServiceHandler(
+			pingServer{},
+			connect.WithInterceptors(connect.NewFaultInterceptor(config)),
+		))
+		server := httptest.NewServer(mux)
+		t.Cleanup(server.Close)
+		return pingv1connect.NewPingServiceClient(server.Client(), server.URL)
+	}
+	chaos := func(_ connect.Spec, header http.Header) bool {
+		return header.Get("Chaos") != ""
+	}
+	t.Run("unary", func(t *testing.T) {
+		t.Parallel()
+		clock := newFakeClock(time.Now())
+		client := newClient(t, connect.FaultConfig{
+			Match: chaos,
+			Rate:  1,
+			Delay: time.Hour,
+			Clock: clock,
+			Code:  connect.CodeResourceExhausted,
+		})
+		_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 1}))
+		assert.Nil(t, err)
+		request := connect.NewRequest(&pingv1.PingRequest{Number: 1})
+		request.Header().Set("Chaos", "1")
+		errs := make(chan error, 1)
+		go func() {
+			_, err := client.Ping(context.Background(), request)
+			errs <- err
+		}()
+		// The delay starts asynchronously, so keep advancing the clock until
+		// the call fails.
+		for i := 0; i < 100 && len(errs) == 0; i++ {
+			clock.Advance(time.Hour)
+			time.Sleep(10 * time.Millisecond)
+		}
+		select {
+		case err := <-errs:
+			assert.Equal(t, connect.CodeOf(err), connect.CodeResourceExhausted)
+		default:
+			t.Fatal("fault delay didn't use the clock")
+		}
+	})
+	t.Run("zero_rate", func(t *testing.T) {
+		t.Parallel()
+		client := newClient(t, connect.FaultConfig{Code: connect.CodeAborted})
+		_, err := client.Ping(context.Background(), connect.NewRequest(&pingv1.PingRequest{Number: 1}))
+		assert.Nil(t, err)
+	})
+	t.Run("stream_error", func(t *testing.T) {
+		t.Parallel()
+		client := newClient(t, connect.FaultConfig{Rate: 1, Code: connect.CodeAborted})
+		stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 3}))
+		assert.Nil(t, err)
+		assert.False(t, stream.Receive())
+		assert.Equal(t, connect.CodeOf(stream.Err()), connect.CodeAborted)
+		assert.Nil(t, stream.Close())
+	})
+	t.Run("stream_truncation", func(t *testing.T) {
+		t.Parallel()
+		client := newClient(t, connect.FaultConfig{
+			Match: func(spec connect.Spec, _ http.Header) bool {
+				return spec.Procedure == "/"+pingv1connect.PingServiceName+"/CountUp"
+			},
+			Rate:          1,
+			TruncateAfter: 2,
+		})
+		stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 5}))
+		assert.Nil(t, err)
+		var received []int64
+		for stream.Receive() {
+			received = append(received, stream.Msg().Number)
+		}
+		assert.Equal(t, received, []int64{1, 2})
+		assert.Equal(t, connect.CodeOf(stream.Err()), connect.CodeUnavailable)
+		assert.Nil(t, stream.Close())
+
+		// Streams that end before the limit are unaffected.
+		stream, err = client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 2}))
+		assert.Nil(t, err)
+		for stream.Receive() {
+		}
+		assert.Nil(t, stream.Err())
+		assert.Nil(t, stream.Close())
+	})
+	t.Run("client", func(t *testing.T) {
+		t.Parallel()
+		mux := http.NewServeMux()
+		mux.Handle(pingv1connect.NewPingServiceHandler(pingServer{}))
+		server := httptest.NewServer(mux)
+		t.Cleanup(server.Close)
+		client := pingv1connect.NewPingServiceClient(
+			server.Client(),
+			server.URL,
+			connect.WithInterceptors(connect.NewFaultInterceptor(connect.FaultConfig{
+				Rate:          1,
+				TruncateAfter: 1,
+				Code:          connect.CodeDataLoss,
+			})),
+		)
+		stream, err := client.CountUp(context.Background(), connect.NewRequest(&pingv1.CountUpRequest{Number: 3}))
+		assert.Nil(t, err)
+		assert.True(t, stream.Receive())
+		assert.False(t, stream.Receive())
+		assert.Equal(t, connect.CodeOf(stream.Err()), connect.CodeDataLoss)
+		assert.Nil(t, stream.Close())
+	})
+}
+
 func newHeaderInterceptor(
 	inspectRequestHeader func(connect.Spec, http.Header),
 	inspectResponseHeader func(connect.Spec, http.Header),
@@ -317,3 +725,57 @@ func (i *assertCalledInterceptor) WrapStreamReceiver(_ context.Context, receiver
 	*i.called = true
 	return receiver
 }
+
+// countingInterceptor counts how often each of its methods is called. Unary
+// calls are counted per call, not per WrapUnary.
+type countingInterceptor struct {
+	unary, streamContext, sender, receiver int32 // atomic
+}
+
+func (i *countingInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, request connect.AnyRequest) (connect.AnyResponse, error) {
+		atomic.AddInt32(&i.unary, 1)
+		return next(ctx, request)
+	}
+}
+
+func (i *countingInterceptor) WrapStreamContext(ctx context.Context) context.Context {
+	atomic.AddInt32(&i.streamContext, 1)
+	return ctx
+}
+
+func (i *countingInterceptor) WrapStreamSender(_ context.Context, sender connect.Sender) connect.Sender {
+	atomic.AddInt32(&i.sender, 1)
+	return sender
+}
+
+func (i *countingInterceptor) WrapStreamReceiver(_ context.Context, receiver connect.Receiver) connect.Receiver {
+	atomic.AddInt32(&i.receiver, 1)
+	return receiver
+}
+
+func (i *countingInterceptor) total() int32 {
+	return atomic.LoadInt32(&i.unary) +
+		atomic.LoadInt32(&i.streamContext) +
+		atomic.LoadInt32(&i.sender) +
+		atomic.LoadInt32(&i.receiver)
+}
+
+// shadowPingServer reports the unary requests it receives, and fails those
+// with negative numbers.
+type shadowPingServer struct {
+	pingv1connect.UnimplementedPingServiceHandler
+
+	received chan<- *connect.Request[pingv1.PingRequest]
+}
+
+func (s shadowPingServer) Ping(
+	_ context.Context,
+	request *connect.Request[pingv1.PingRequest],
+) (*connect.Response[pingv1.PingResponse], error) {
+	s.received <- request
+	if request.Msg.Number < 0 {
+		return nil, connect.NewError(connect.CodeInternal, errors.New("shadow failed"))
+	}
+	return connect.NewResponse(&pingv1.PingResponse{Number: request.Msg.Number}), nil
+}
@@ -0,0 +1,380 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interop
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	testingpb "github.com/bufbuild/connect-go/internal/gen/connectext/grpc/testing"
+	"github.com/bufbuild/connect-go/internal/gen/connectext/grpc/testing/testingconnect"
+)
+
+// Clients are the clients used by the test cases.
+type Clients struct {
+	Test          testingconnect.TestServiceClient
+	Unimplemented testingconnect.UnimplementedServiceClient
+}
+
+// NewClients constructs clients for a server at baseURL.
+func NewClients(httpClient connect.HTTPClient, baseURL string, options ...connect.ClientOption) *Clients {
+	return &Clients{
+		Test:          testingconnect.NewTestServiceClient(httpClient, baseURL, options...),
+		Unimplemented: testingconnect.NewUnimplementedServiceClient(httpClient, baseURL, options...),
+	}
+}
+
+// The sizes used by the large_unary, client_streaming, server_streaming, and
+// ping_pong test cases.
+const (
+	largeRequestSize  = 271828
+	largeResponseSize = 314159
+)
+
+var (
+	requestSizes  = []int32{27182, 8, 1828, 45904} //nolint:gochecknoglobals
+	responseSizes = []int32{31415, 9, 2653, 58979} //nolint:gochecknoglobals
+)
+
+//nolint:gochecknoglobals
+var testCases = map[string]func(context.Context, *Clients) error{
+	"empty_unary":                 emptyUnary,
+	"large_unary":                 largeUnary,
+	"client_streaming":            clientStreaming,
+	"server_streaming":            serverStreaming,
+	"ping_pong":                   pingPong,
+	"empty_stream":                emptyStream,
+	"timeout_on_sleeping_server":  timeoutOnSleepingServer,
+	"cancel_after_begin":          cancelAfterBegin,
+	"cancel_after_first_response": cancelAfterFirstResponse,
+	"custom_metadata":             customMetadata,
+	"status_code_and_message":     statusCodeAndMessage,
+	"special_status_message":      specialStatusMessage,
+	"unimplemented_method":        unimplementedMethod,
+	"unimplemented_service":       unimplementedService,
+}
+
+// TestCases returns the names of the supported test cases, sorted.
+func TestCases() []string {
+	names := make([]string, 0, len(testCases))
+	for name := range testCases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Run runs the named test case.
+func Run(ctx context.Context, clients *Clients, name string) error {
+	testCase, ok := testCases[name]
+	if !ok {
+		return fmt.Errorf("unknown test case %q", name)
+	}
+	return testCase(ctx, clients)
+}
+
+func emptyUnary(ctx context.Context, clients *Clients) error {
+	_, err := clients.Test.EmptyCall(ctx, connect.NewRequest(&testingpb.Empty{}))
+	return err
+}
+
+func largeUnary(ctx context.Context, clients *Clients) error {
+	response, err := clients.Test.UnaryCall(ctx, connect.NewRequest(&testingpb.SimpleRequest{
+		ResponseType: testingpb.PayloadType_COMPRESSABLE,
+		ResponseSize: largeResponseSize,
+		Payload:      mustPayload(largeRequestSize),
+	}))
+	if err != nil {
+		return err
+	}
+	return expectPayload(response.Msg.Payload, largeResponseSize)
+}
+
+func clientStreaming(ctx context.Context, clients *Clients) error {
+	stream := clients.Test.StreamingInputCall(ctx)
+	var total int32
+	for _, size := range requestSizes {
+		total += size
+		if err := stream.Send(&testingpb.StreamingInputCallRequest{Payload: mustPayload(size)}); err != nil {
+			return err
+		}
+	}
+	response, err := stream.CloseAndReceive()
+	if err != nil {
+		return err
+	}
+	if got := response.Msg.AggregatedPayloadSize; got != total {
+		return fmt.Errorf("aggregated payload size %d, want %d", got, total)
+	}
+	return nil
+}
+
+func serverStreaming(ctx context.Context, clients *Clients) error {
+	request := &testingpb.StreamingOutputCallRequest{ResponseType: testingpb.PayloadType_COMPRESSABLE}
+	for _, size := range responseSizes {
+		request.ResponseParameters = append(request.ResponseParameters, &testingpb.ResponseParameters{Size: size})
+	}
+	stream, err := clients.Test.StreamingOutputCall(ctx, connect.NewRequest(request))
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	var received int
+	for stream.Receive() {
+		if received >= len(responseSizes) {
+			return fmt.Errorf("received more than %d responses", len(responseSizes))
+		}
+		if err := expectPayload(stream.Msg().Payload, responseSizes[received]); err != nil {
+			return err
+		}
+		received++
+	}
+	if err := stream.Err(); err != nil {
+		return err
+	}
+	if received != len(responseSizes) {
+		return fmt.Errorf("received %d responses, want %d", received, len(responseSizes))
+	}
+	return nil
+}
+
+func pingPong(ctx context.Context, clients *Clients) error {
+	stream := clients.Test.FullDuplexCall(ctx)
+	for i, size := range responseSizes {
+		if err := stream.Send(&testingpb.StreamingOutputCallRequest{
+			ResponseType:       testingpb.PayloadType_COMPRESSABLE,
+			ResponseParameters: []*testingpb.ResponseParameters{{Size: size}},
+			Payload:            mustPayload(requestSizes[i]),
+		}); err != nil {
+			return err
+		}
+		response, err := stream.Receive()
+		if err != nil {
+			return err
+		}
+		if err := expectPayload(response.Payload, size); err != nil {
+			return err
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+	return expectEndOfStream(stream)
+}
+
+func emptyStream(ctx context.Context, clients *Clients) error {
+	stream := clients.Test.FullDuplexCall(ctx)
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+	return expectEndOfStream(stream)
+}
+
+func timeoutOnSleepingServer(ctx context.Context, clients *Clients) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	stream := clients.Test.FullDuplexCall(ctx)
+	// The deadline may pass before the request is sent, so ignore errors here
+	// and check the code when receiving.
+	_ = stream.Send(&testingpb.StreamingOutputCallRequest{
+		ResponseType: testingpb.PayloadType_COMPRESSABLE,
+		Payload:      mustPayload(requestSizes[0]),
+	})
+	_, err := stream.Receive()
+	_ = stream.CloseSend()
+	_ = stream.CloseReceive()
+	return expectCode(err, connect.CodeDeadlineExceeded)
+}
+
+func cancelAfterBegin(ctx context.Context, clients *Clients) error {
+	ctx, cancel := context.WithCancel(ctx)
+	stream := clients.Test.StreamingInputCall(ctx)
+	cancel()
+	_, err := stream.CloseAndReceive()
+	return expectCode(err, connect.CodeCanceled)
+}
+
+func cancelAfterFirstResponse(ctx context.Context, clients *Clients) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream := clients.Test.FullDuplexCall(ctx)
+	if err := stream.Send(&testingpb.StreamingOutputCallRequest{
+		ResponseType:       testingpb.PayloadType_COMPRESSABLE,
+		ResponseParameters: []*testingpb.ResponseParameters{{Size: responseSizes[0]}},
+		Payload:            mustPayload(requestSizes[0]),
+	}); err != nil {
+		return err
+	}
+	if _, err := stream.Receive(); err != nil {
+		return err
+	}
+	cancel()
+	_, err := stream.Receive()
+	_ = stream.CloseSend()
+	_ = stream.CloseReceive()
+	return expectCode(err, connect.CodeCanceled)
+}
+
+func customMetadata(ctx context.Context, clients *Clients) error {
+	const initialValue = "test_initial_metadata_value"
+	trailingValue := connect.EncodeBinaryHeader([]byte{0xab, 0xab, 0xab})
+	check := func(header, trailer http.Header) error {
+		if got := header.Get(echoInitialHeader); got != initialValue {
+			return fmt.Errorf("%s header %q, want %q", echoInitialHeader, got, initialValue)
+		}
+		if got := trailer.Get(echoTrailingHeader); got != trailingValue {
+			return fmt.Errorf("%s trailer %q, want %q", echoTrailingHeader, got, trailingValue)
+		}
+		return nil
+	}
+
+	request := connect.NewRequest(&testingpb.SimpleRequest{
+		ResponseType: testingpb.PayloadType_COMPRESSABLE,
+		ResponseSize: largeResponseSize,
+		Payload:      mustPayload(largeRequestSize),
+	})
+	request.Header().Set(echoInitialHeader, initialValue)
+	request.Header().Set(echoTrailingHeader, trailingValue)
+	response, err := clients.Test.UnaryCall(ctx, request)
+	if err != nil {
+		return err
+	}
+	if err := check(response.Header(), response.Trailer()); err != nil {
+		return fmt.Errorf("unary: %w", err)
+	}
+
+	stream := clients.Test.FullDuplexCall(ctx)
+	stream.RequestHeader().Set(echoInitialHeader, initialValue)
+	stream.RequestHeader().Set(echoTrailingHeader, trailingValue)
+	if err := stream.Send(&testingpb.StreamingOutputCallRequest{
+		ResponseType:       testingpb.PayloadType_COMPRESSABLE,
+		ResponseParameters: []*testingpb.ResponseParameters{{Size: largeResponseSize}},
+		Payload:            mustPayload(largeRequestSize),
+	}); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+	if _, err := stream.Receive(); err != nil {
+		return err
+	}
+	if err := expectEndOfStream(stream); err != nil {
+		return err
+	}
+	if err := check(stream.ResponseHeader(), stream.ResponseTrailer()); err != nil {
+		return fmt.Errorf("full duplex: %w", err)
+	}
+	return nil
+}
+
+func statusCodeAndMessage(ctx context.Context, clients *Clients) error {
+	return checkStatus(ctx, clients, "test status message")
+}
+
+func specialStatusMessage(ctx context.Context, clients *Clients) error {
+	return checkStatus(ctx, clients, "\t\ntest with whitespace\r\nand Unicode BMP ☺ and non-BMP 😈\t\n")
+}
+
+// checkStatus verifies that an error code and message round-trip on unary
+// and full duplex calls.
+func checkStatus(ctx context.Context, clients *Clients, message string) error {
+	status := &testingpb.EchoStatus{Code: int32(connect.CodeUnknown), Message: message}
+	_, err := clients.Test.UnaryCall(ctx, connect.NewRequest(&testingpb.SimpleRequest{ResponseStatus: status}))
+	if err := expectError(err, connect.CodeUnknown, message); err != nil {
+		return fmt.Errorf("unary: %w", err)
+	}
+
+	stream := clients.Test.FullDuplexCall(ctx)
+	if err := stream.Send(&testingpb.StreamingOutputCallRequest{ResponseStatus: status}); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+	_, err = stream.Receive()
+	_ = stream.CloseReceive()
+	if err := expectError(err, connect.CodeUnknown, message); err != nil {
+		return fmt.Errorf("full duplex: %w", err)
+	}
+	return nil
+}
+
+func unimplementedMethod(ctx context.Context, clients *Clients) error {
+	_, err := clients.Test.UnimplementedCall(ctx, connect.NewRequest(&testingpb.Empty{}))
+	return expectCode(err, connect.CodeUnimplemented)
+}
+
+func unimplementedService(ctx context.Context, clients *Clients) error {
+	_, err := clients.Unimplemented.UnimplementedCall(ctx, connect.NewRequest(&testingpb.Empty{}))
+	return expectCode(err, connect.CodeUnimplemented)
+}
+
+func mustPayload(size int32) *testingpb.Payload {
+	payload, err := newPayload(size)
+	if err != nil {
+		panic(err) //nolint:forbidigo // sizes are constants
+	}
+	return payload
+}
+
+func expectPayload(payload *testingpb.Payload, size int32) error {
+	if got := len(payload.GetBody()); got != int(size) {
+		return fmt.Errorf("payload has %d bytes, want %d", got, size)
+	}
+	if !bytes.Equal(payload.GetBody(), make([]byte, size)) {
+		return errors.New("payload isn't all zeros")
+	}
+	return nil
+}
+
+func expectEndOfStream(stream *connect.BidiStreamForClient[testingpb.StreamingOutputCallRequest, testingpb.StreamingOutputCallResponse]) error {
+	_, err := stream.Receive()
+	if closeErr := stream.CloseReceive(); closeErr != nil && err == nil {
+		return closeErr
+	}
+	if !errors.Is(err, io.EOF) {
+		return fmt.Errorf("expected end of stream, got %v", err)
+	}
+	return nil
+}
+
+func expectCode(err error, want connect.Code) error {
+	if err == nil {
+		return fmt.Errorf("expected %v error, got nil", want)
+	}
+	if got := connect.CodeOf(err); got != want {
+		return fmt.Errorf("expected %v error, got %w", want, err)
+	}
+	return nil
+}
+
+func expectError(err error, code connect.Code, message string) error {
+	if err := expectCode(err, code); err != nil {
+		return err
+	}
+	var connectErr *connect.Error
+	if !errors.As(err, &connectErr) || connectErr.Message() != message {
+		return fmt.Errorf("expected message %q, got %w", message, err)
+	}
+	return nil
+}
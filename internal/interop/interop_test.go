@@ -0,0 +1,48 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interop
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/bufbuild/connect-go/internal/assert"
+)
+
+func TestInterop(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewUnstartedServer(NewHandler())
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	protocols := map[string][]connect.ClientOption{
+		connect.ProtocolConnect: nil,
+		connect.ProtocolGRPC:    {connect.WithGRPC()},
+		connect.ProtocolGRPCWeb: {connect.WithGRPCWeb()},
+	}
+	for protocol, options := range protocols {
+		clients := NewClients(server.Client(), server.URL, options...)
+		for _, name := range TestCases() {
+			protocol, name := protocol, name
+			t.Run(protocol+"/"+name, func(t *testing.T) {
+				t.Parallel()
+				assert.Nil(t, Run(context.Background(), clients, name))
+			})
+		}
+	}
+	assert.NotNil(t, Run(context.Background(), nil, "no_such_test"))
+}
@@ -0,0 +1,228 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package interop implements the standard gRPC interoperability tests, as
+// described in
+// https://github.com/grpc/grpc/blob/master/doc/interop-test-descriptions.md.
+// It's used by the connect-interop-server and connect-interop-client
+// commands.
+package interop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	testingpb "github.com/bufbuild/connect-go/internal/gen/connectext/grpc/testing"
+	"github.com/bufbuild/connect-go/internal/gen/connectext/grpc/testing/testingconnect"
+)
+
+const (
+	// Clients send these headers, and the server echoes them back.
+	echoInitialHeader  = "X-Grpc-Test-Echo-Initial"
+	echoTrailingHeader = "X-Grpc-Test-Echo-Trailing-Bin"
+)
+
+// NewHandler returns an http.Handler that serves grpc.testing.TestService.
+// Like other interop servers, it doesn't serve
+// grpc.testing.UnimplementedService.
+func NewHandler(options ...connect.HandlerOption) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(testingconnect.NewTestServiceHandler(&testServer{}, options...))
+	return mux
+}
+
+type testServer struct {
+	testingconnect.UnimplementedTestServiceHandler
+}
+
+func (*testServer) EmptyCall(
+	_ context.Context,
+	request *connect.Request[testingpb.Empty],
+) (*connect.Response[testingpb.Empty], error) {
+	response := connect.NewResponse(&testingpb.Empty{})
+	echoMetadata(request.Header(), response.Header(), response.Trailer())
+	return response, nil
+}
+
+func (*testServer) UnaryCall(
+	_ context.Context,
+	request *connect.Request[testingpb.SimpleRequest],
+) (*connect.Response[testingpb.SimpleResponse], error) {
+	if err := statusError(request.Msg.ResponseStatus, request.Header()); err != nil {
+		return nil, err
+	}
+	payload, err := newPayload(request.Msg.ResponseSize)
+	if err != nil {
+		return nil, err
+	}
+	response := connect.NewResponse(&testingpb.SimpleResponse{Payload: payload})
+	echoMetadata(request.Header(), response.Header(), response.Trailer())
+	return response, nil
+}
+
+func (*testServer) StreamingOutputCall(
+	ctx context.Context,
+	request *connect.Request[testingpb.StreamingOutputCallRequest],
+	stream *connect.ServerStream[testingpb.StreamingOutputCallResponse],
+) error {
+	echoMetadata(request.Header(), stream.ResponseHeader(), stream.ResponseTrailer())
+	for _, parameters := range request.Msg.ResponseParameters {
+		response, err := newStreamingResponse(ctx, parameters)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(response); err != nil {
+			return err
+		}
+	}
+	return statusError(request.Msg.ResponseStatus, nil)
+}
+
+func (*testServer) StreamingInputCall(
+	_ context.Context,
+	stream *connect.ClientStream[testingpb.StreamingInputCallRequest],
+) (*connect.Response[testingpb.StreamingInputCallResponse], error) {
+	var size int
+	for stream.Receive() {
+		size += len(stream.Msg().GetPayload().GetBody())
+	}
+	if err := stream.Err(); err != nil {
+		return nil, err
+	}
+	response := connect.NewResponse(&testingpb.StreamingInputCallResponse{
+		AggregatedPayloadSize: int32(size),
+	})
+	echoMetadata(stream.RequestHeader(), response.Header(), response.Trailer())
+	return response, nil
+}
+
+func (*testServer) FullDuplexCall(
+	ctx context.Context,
+	stream *connect.BidiStream[testingpb.StreamingOutputCallRequest, testingpb.StreamingOutputCallResponse],
+) error {
+	echoMetadata(stream.RequestHeader(), stream.ResponseHeader(), stream.ResponseTrailer())
+	for {
+		request, err := stream.Receive()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := statusError(request.ResponseStatus, nil); err != nil {
+			return err
+		}
+		for _, parameters := range request.ResponseParameters {
+			response, err := newStreamingResponse(ctx, parameters)
+			if err != nil {
+				return err
+			}
+			if err := stream.Send(response); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (*testServer) HalfDuplexCall(
+	ctx context.Context,
+	stream *connect.BidiStream[testingpb.StreamingOutputCallRequest, testingpb.StreamingOutputCallResponse],
+) error {
+	echoMetadata(stream.RequestHeader(), stream.ResponseHeader(), stream.ResponseTrailer())
+	var requests []*testingpb.StreamingOutputCallRequest
+	for {
+		request, err := stream.Receive()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+		requests = append(requests, request)
+	}
+	for _, request := range requests {
+		if err := statusError(request.ResponseStatus, nil); err != nil {
+			return err
+		}
+		for _, parameters := range request.ResponseParameters {
+			response, err := newStreamingResponse(ctx, parameters)
+			if err != nil {
+				return err
+			}
+			if err := stream.Send(response); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// echoMetadata copies the echo headers sent by the client to the response.
+func echoMetadata(request, header, trailer http.Header) {
+	if value := request.Get(echoInitialHeader); value != "" {
+		header.Set(echoInitialHeader, value)
+	}
+	if value := request.Get(echoTrailingHeader); value != "" {
+		trailer.Set(echoTrailingHeader, value)
+	}
+}
+
+// statusError returns the error requested by the client, if any. Unary errors
+// also carry the echoed metadata.
+func statusError(status *testingpb.EchoStatus, request http.Header) error {
+	if status == nil || status.Code == 0 {
+		return nil
+	}
+	err := connect.NewError(connect.Code(status.Code), errors.New(status.Message))
+	if request != nil {
+		echoMetadata(request, err.Meta(), err.Meta())
+	}
+	return err
+}
+
+func newStreamingResponse(
+	ctx context.Context,
+	parameters *testingpb.ResponseParameters,
+) (*testingpb.StreamingOutputCallResponse, error) {
+	if interval := time.Duration(parameters.IntervalUs) * time.Microsecond; interval > 0 {
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	payload, err := newPayload(parameters.Size)
+	if err != nil {
+		return nil, err
+	}
+	return &testingpb.StreamingOutputCallResponse{Payload: payload}, nil
+}
+
+func newPayload(size int32) (*testingpb.Payload, error) {
+	if size < 0 {
+		return nil, connect.NewError(
+			connect.CodeInvalidArgument,
+			fmt.Errorf("requested a payload with negative size %d", size),
+		)
+	}
+	return &testingpb.Payload{
+		Type: testingpb.PayloadType_COMPRESSABLE,
+		Body: make([]byte, size),
+	}, nil
+}
@@ -0,0 +1,42 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.24
+
+package interop
+
+import (
+	"crypto/tls"
+	"net/http"
+)
+
+// ConfigureServer lets server accept gRPC over cleartext HTTP/2 with prior
+// knowledge, which is how interop clients connect when TLS is disabled.
+func ConfigureServer(server *http.Server) {
+	server.Protocols = new(http.Protocols)
+	server.Protocols.SetHTTP1(true)
+	server.Protocols.SetHTTP2(true)
+	server.Protocols.SetUnencryptedHTTP2(true)
+}
+
+// NewTransport returns a transport for interop clients. Without a TLS
+// configuration, it uses cleartext HTTP/2 with prior knowledge.
+func NewTransport(tlsConfig *tls.Config) *http.Transport {
+	transport := &http.Transport{TLSClientConfig: tlsConfig, ForceAttemptHTTP2: true}
+	if tlsConfig == nil {
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return transport
+}
@@ -0,0 +1,35 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !go1.24
+
+package interop
+
+import (
+	"crypto/tls"
+	"net/http"
+)
+
+// Before Go 1.24, the standard library doesn't support HTTP/2 with prior
+// knowledge, so cleartext connections use HTTP/1.1. That's enough for unary
+// and server streaming calls over the Connect and gRPC-Web protocols, but
+// gRPC interop requires TLS.
+
+// ConfigureServer is a no-op.
+func ConfigureServer(*http.Server) {}
+
+// NewTransport returns a transport for interop clients.
+func NewTransport(tlsConfig *tls.Config) *http.Transport {
+	return &http.Transport{TLSClientConfig: tlsConfig, ForceAttemptHTTP2: true}
+}
@@ -0,0 +1,58 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rawproto lets clients send and receive serialized Protobuf messages
+// without decoding them, for callers that marshal messages themselves or don't
+// have the generated types.
+package rawproto
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Message is a serialized Protobuf message.
+type Message []byte
+
+// Codec passes Messages through unchanged. It replaces the default Protobuf
+// codec, so it also handles the ordinary Protobuf messages connect uses
+// internally, like gRPC error details.
+type Codec struct{}
+
+// Name implements connect.Codec.
+func (Codec) Name() string { return "proto" }
+
+// Marshal implements connect.Codec.
+func (Codec) Marshal(message any) ([]byte, error) {
+	switch typed := message.(type) {
+	case *Message:
+		return *typed, nil
+	case proto.Message:
+		return proto.Marshal(typed)
+	}
+	return nil, fmt.Errorf("%T isn't a raw or Protobuf message", message)
+}
+
+// Unmarshal implements connect.Codec.
+func (Codec) Unmarshal(data []byte, message any) error {
+	switch typed := message.(type) {
+	case *Message:
+		*typed = append((*typed)[:0], data...)
+		return nil
+	case proto.Message:
+		return proto.Unmarshal(data, typed)
+	}
+	return fmt.Errorf("%T isn't a raw or Protobuf message", message)
+}
@@ -0,0 +1,439 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync/atomic"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// A LoadReport describes a backend's load after serving a call. Handlers
+// attach it to responses with SetLoadReport, and clients read it with
+// LoadReportFromTrailer or balance calls with a LeastLoadedBalancer.
+//
+// On the wire, it's a binary xds.data.orca.v3.OrcaLoadReport in the
+// Endpoint-Load-Metrics-Bin trailer, so it interoperates with gRPC's ORCA
+// support. Like the standard error details, it's encoded by hand to avoid a
+// dependency on the xDS protobuf packages.
+type LoadReport struct {
+	// CPUUtilization is the fraction of the backend's CPU in use, usually
+	// between 0 and 1.
+	CPUUtilization float64
+	// MemUtilization is the fraction of the backend's memory in use, between
+	// 0 and 1.
+	MemUtilization float64
+	// ApplicationUtilization is an application-defined utilization, like
+	// queue depth relative to capacity. Balancers prefer it to CPUUtilization
+	// when it's set.
+	ApplicationUtilization float64
+	// RPS is the backend's queries per second.
+	RPS float64
+	// EPS is the backend's errors per second.
+	EPS float64
+	// RequestCost holds costs specific to this call, like database queries
+	// made.
+	RequestCost map[string]float64
+	// Utilization holds other named utilizations, between 0 and 1.
+	Utilization map[string]float64
+	// NamedMetrics holds any other metrics.
+	NamedMetrics map[string]float64
+}
+
+// Field numbers of xds.data.orca.v3.OrcaLoadReport.
+const (
+	orcaCPUUtilization         protowire.Number = 1
+	orcaMemUtilization         protowire.Number = 2
+	orcaRPS                    protowire.Number = 3 // deprecated integer RPS
+	orcaRequestCost            protowire.Number = 4
+	orcaUtilization            protowire.Number = 5
+	orcaRPSFractional          protowire.Number = 6
+	orcaEPS                    protowire.Number = 7
+	orcaNamedMetrics           protowire.Number = 8
+	orcaApplicationUtilization protowire.Number = 9
+)
+
+// SetLoadReport attaches a LoadReport to the response of the handler that
+// owns ctx, replacing any earlier report. Like SetResponseTrailer, it returns
+// an error if ctx isn't (and isn't derived from) a handler's context.
+func SetLoadReport(ctx context.Context, report *LoadReport) error {
+	return SetResponseTrailer(ctx, HeaderEndpointLoadMetrics, EncodeBinaryHeader(report.marshal()))
+}
+
+// LoadReportFromTrailer parses the LoadReport in a response's trailers (or,
+// for failed calls, an error's metadata). It reports false if there isn't one
+// or it's malformed.
+func LoadReportFromTrailer(trailer http.Header) (*LoadReport, bool) {
+	value := trailer.Get(HeaderEndpointLoadMetrics)
+	if value == "" {
+		return nil, false
+	}
+	data, err := DecodeBinaryHeader(value)
+	if err != nil {
+		return nil, false
+	}
+	report := &LoadReport{}
+	if !report.unmarshal(data) {
+		return nil, false
+	}
+	return report, true
+}
+
+func (r *LoadReport) marshal() []byte {
+	var data []byte
+	data = appendDoubleField(data, orcaCPUUtilization, r.CPUUtilization)
+	data = appendDoubleField(data, orcaMemUtilization, r.MemUtilization)
+	data = appendDoubleMap(data, orcaRequestCost, r.RequestCost)
+	data = appendDoubleMap(data, orcaUtilization, r.Utilization)
+	data = appendDoubleField(data, orcaRPSFractional, r.RPS)
+	data = appendDoubleField(data, orcaEPS, r.EPS)
+	data = appendDoubleMap(data, orcaNamedMetrics, r.NamedMetrics)
+	data = appendDoubleField(data, orcaApplicationUtilization, r.ApplicationUtilization)
+	return data
+}
+
+func (r *LoadReport) unmarshal(data []byte) bool {
+	for len(data) > 0 {
+		number, wireType, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return false
+		}
+		data = data[n:]
+		switch {
+		case wireType == protowire.Fixed64Type:
+			bits, n := protowire.ConsumeFixed64(data)
+			if n < 0 {
+				return false
+			}
+			data = data[n:]
+			r.setDouble(number, math.Float64frombits(bits))
+			continue
+		case wireType == protowire.VarintType && number == orcaRPS:
+			rps, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				return false
+			}
+			data = data[n:]
+			if r.RPS == 0 {
+				r.RPS = float64(rps)
+			}
+			continue
+		case wireType == protowire.BytesType:
+			entry, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return false
+			}
+			data = data[n:]
+			if !r.setMapEntry(number, entry) {
+				return false
+			}
+			continue
+		}
+		n = protowire.ConsumeFieldValue(number, wireType, data)
+		if n < 0 {
+			return false
+		}
+		data = data[n:]
+	}
+	return true
+}
+
+func (r *LoadReport) setDouble(number protowire.Number, value float64) {
+	switch number {
+	case orcaCPUUtilization:
+		r.CPUUtilization = value
+	case orcaMemUtilization:
+		r.MemUtilization = value
+	case orcaRPSFractional:
+		r.RPS = value
+	case orcaEPS:
+		r.EPS = value
+	case orcaApplicationUtilization:
+		r.ApplicationUtilization = value
+	}
+}
+
+func (r *LoadReport) setMapEntry(number protowire.Number, entry []byte) bool {
+	var target *map[string]float64
+	switch number {
+	case orcaRequestCost:
+		target = &r.RequestCost
+	case orcaUtilization:
+		target = &r.Utilization
+	case orcaNamedMetrics:
+		target = &r.NamedMetrics
+	default:
+		return true
+	}
+	var key string
+	var value float64
+	for len(entry) > 0 {
+		number, wireType, n := protowire.ConsumeTag(entry)
+		if n < 0 {
+			return false
+		}
+		entry = entry[n:]
+		switch {
+		case number == 1 && wireType == protowire.BytesType:
+			key, n = protowire.ConsumeString(entry)
+		case number == 2 && wireType == protowire.Fixed64Type:
+			var bits uint64
+			bits, n = protowire.ConsumeFixed64(entry)
+			value = math.Float64frombits(bits)
+		default:
+			n = protowire.ConsumeFieldValue(number, wireType, entry)
+		}
+		if n < 0 {
+			return false
+		}
+		entry = entry[n:]
+	}
+	if *target == nil {
+		*target = make(map[string]float64)
+	}
+	(*target)[key] = value
+	return true
+}
+
+func appendDoubleField(data []byte, number protowire.Number, value float64) []byte {
+	if value == 0 {
+		return data
+	}
+	data = protowire.AppendTag(data, number, protowire.Fixed64Type)
+	return protowire.AppendFixed64(data, math.Float64bits(value))
+}
+
+// appendDoubleMap appends a map<string, double> field, with keys in sorted
+// order so the encoding is deterministic.
+func appendDoubleMap(data []byte, number protowire.Number, values map[string]float64) []byte {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var entry []byte
+		entry = appendStringField(entry, 1, key)
+		entry = appendDoubleField(entry, 2, values[key])
+		data = appendMessageField(data, number, entry)
+	}
+	return data
+}
+
+// LeastLoadedBalancerConfig configures a LeastLoadedBalancer.
+type LeastLoadedBalancerConfig struct {
+	// Targets are the base URLs of the backends, like
+	// "https://foo-1.acme.internal:8443".
+	Targets []string
+	// HTTPClient sends the requests. It defaults to http.DefaultClient.
+	HTTPClient HTTPClient
+	// Load scores a backend from its latest LoadReport; lower scores are
+	// less loaded. It defaults to the report's ApplicationUtilization, or
+	// its CPUUtilization if ApplicationUtilization isn't set.
+	Load func(*LoadReport) float64
+}
+
+// LeastLoadedBalancer spreads calls across backends using the load they
+// report with SetLoadReport, so calls go to the backends with the most
+// headroom rather than round-robin. It's both an HTTPClient, which picks a
+// backend for each request, and an Interceptor, which reads the LoadReport
+// from each response; clients must use it as both:
+//
+//	balancer, err := connect.NewLeastLoadedBalancer(connect.LeastLoadedBalancerConfig{
+//	  Targets: []string{"https://foo-1.acme.internal", "https://foo-2.acme.internal"},
+//	})
+//	client := foov1connect.NewFooServiceClient(
+//	  balancer,
+//	  "https://foo.acme.internal",
+//	  connect.WithInterceptors(balancer),
+//	)
+//
+// Like TrafficSplitter, it replaces the scheme and host of each request's
+// URL. Each request compares two randomly chosen backends and goes to the
+// less loaded one, so bursts between reports don't all land on a single
+// backend. Backends that haven't reported yet have a load of zero. A
+// LeastLoadedBalancer is safe to use concurrently.
+type LeastLoadedBalancer struct {
+	httpClient HTTPClient
+	load       func(*LoadReport) float64
+	targets    []*balancedTarget
+}
+
+type balancedTarget struct {
+	// 64-bit atomics must be 64-bit aligned, which is only guaranteed for the
+	// first word of an allocated struct on 32-bit platforms.
+	load uint64 // atomic, math.Float64bits of the latest score
+	url  *url.URL
+}
+
+// NewLeastLoadedBalancer constructs a LeastLoadedBalancer. It returns an
+// error if there are no targets or a target URL is invalid.
+func NewLeastLoadedBalancer(config LeastLoadedBalancerConfig) (*LeastLoadedBalancer, error) {
+	if len(config.Targets) == 0 {
+		return nil, errors.New("least-loaded balancer has no targets")
+	}
+	balancer := &LeastLoadedBalancer{
+		httpClient: config.HTTPClient,
+		load:       config.Load,
+		targets:    make([]*balancedTarget, 0, len(config.Targets)),
+	}
+	if balancer.httpClient == nil {
+		balancer.httpClient = http.DefaultClient
+	}
+	if balancer.load == nil {
+		balancer.load = defaultLoad
+	}
+	for _, target := range config.Targets {
+		parsed, err := parseTarget(target)
+		if err != nil {
+			return nil, err
+		}
+		balancer.targets = append(balancer.targets, &balancedTarget{url: parsed})
+	}
+	return balancer, nil
+}
+
+// Loads returns the latest load score of each target, keyed by target URL.
+func (b *LeastLoadedBalancer) Loads() map[string]float64 {
+	loads := make(map[string]float64, len(b.targets))
+	for _, target := range b.targets {
+		loads[target.url.String()] = target.currentLoad()
+	}
+	return loads
+}
+
+// Do implements HTTPClient.
+func (b *LeastLoadedBalancer) Do(request *http.Request) (*http.Response, error) {
+	target := b.pick()
+	if slot, ok := request.Context().Value(b).(*atomic.Value); ok {
+		slot.Store(target)
+	}
+	return b.httpClient.Do(retarget(request, target.url))
+}
+
+// WrapUnary implements Interceptor.
+func (b *LeastLoadedBalancer) WrapUnary(next UnaryFunc) UnaryFunc {
+	return func(ctx context.Context, request AnyRequest) (AnyResponse, error) {
+		if !request.Spec().IsClient {
+			return next(ctx, request)
+		}
+		ctx = b.WrapStreamContext(ctx)
+		response, err := next(ctx, request)
+		if err != nil {
+			var connectErr *Error
+			if errors.As(err, &connectErr) {
+				b.observe(ctx, connectErr.Meta())
+			}
+			return response, err
+		}
+		b.observe(ctx, response.Trailer())
+		return response, nil
+	}
+}
+
+// WrapStreamContext implements Interceptor.
+func (b *LeastLoadedBalancer) WrapStreamContext(ctx context.Context) context.Context {
+	// Do records the chosen target in the slot, so the interceptor knows
+	// which target the response's LoadReport belongs to.
+	return context.WithValue(ctx, b, &atomic.Value{})
+}
+
+// WrapStreamSender implements Interceptor.
+func (b *LeastLoadedBalancer) WrapStreamSender(_ context.Context, sender Sender) Sender {
+	return sender
+}
+
+// WrapStreamReceiver implements Interceptor.
+func (b *LeastLoadedBalancer) WrapStreamReceiver(ctx context.Context, receiver Receiver) Receiver {
+	if !receiver.Spec().IsClient {
+		return receiver
+	}
+	return &loadReportReceiver{Receiver: receiver, ctx: ctx, balancer: b}
+}
+
+func (b *LeastLoadedBalancer) pick() *balancedTarget {
+	if len(b.targets) == 1 {
+		return b.targets[0]
+	}
+	// Compare two distinct targets, chosen at random.
+	i := rand.Intn(len(b.targets))     //nolint:gosec
+	j := rand.Intn(len(b.targets) - 1) //nolint:gosec
+	if j >= i {
+		j++
+	}
+	first, second := b.targets[i], b.targets[j]
+	if second.currentLoad() < first.currentLoad() {
+		return second
+	}
+	return first
+}
+
+// observe records the LoadReport in the trailers, if any, as the load of the
+// target that served the call.
+func (b *LeastLoadedBalancer) observe(ctx context.Context, trailer http.Header) {
+	slot, ok := ctx.Value(b).(*atomic.Value)
+	if !ok {
+		return
+	}
+	target, ok := slot.Load().(*balancedTarget)
+	if !ok {
+		return
+	}
+	report, ok := LoadReportFromTrailer(trailer)
+	if !ok {
+		return
+	}
+	atomic.StoreUint64(&target.load, math.Float64bits(b.load(report)))
+}
+
+func (t *balancedTarget) currentLoad() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&t.load))
+}
+
+func defaultLoad(report *LoadReport) float64 {
+	if report.ApplicationUtilization > 0 {
+		return report.ApplicationUtilization
+	}
+	return report.CPUUtilization
+}
+
+// loadReportReceiver reads the LoadReport from the stream's trailers once
+// the stream ends.
+type loadReportReceiver struct {
+	Receiver
+
+	ctx      context.Context
+	balancer *LeastLoadedBalancer
+}
+
+func (r *loadReportReceiver) Receive(msg any) error {
+	err := r.Receiver.Receive(msg)
+	if err == nil {
+		return nil
+	}
+	if trailer, ok := r.Receiver.Trailer(); ok && trailer.Get(HeaderEndpointLoadMetrics) != "" {
+		r.balancer.observe(r.ctx, trailer)
+	} else if connectErr, ok := asError(err); ok {
+		r.balancer.observe(r.ctx, connectErr.Meta())
+	}
+	return err
+}
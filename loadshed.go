@@ -0,0 +1,198 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// loadShedderSmoothing weights new samples in the LoadShedder's moving
+	// averages.
+	loadShedderSmoothing = 0.1
+	// maxShedFraction keeps some requests flowing even under extreme load, so
+	// the LoadShedder keeps observing latency and notices when load subsides.
+	maxShedFraction = 0.9
+	// defaultLoadShedderWindow is the default LoadShedderConfig.Window.
+	defaultLoadShedderWindow = 10 * time.Second
+)
+
+// An AdmissionController decides whether a ServeMux should serve a request.
+// Admission controllers protect servers from overload by rejecting some
+// requests before they're dispatched to a handler, when rejecting them is
+// still cheap.
+type AdmissionController interface {
+	// Admit reports whether to serve the request. If it admits the request, the
+	// ServeMux calls done once the handler returns.
+	Admit(request *http.Request) (done func(), ok bool)
+}
+
+// LoadShedderConfig configures a LoadShedder.
+type LoadShedderConfig struct {
+	// Target is the queueing delay the LoadShedder tolerates: how much longer
+	// than the fastest recent request requests may take before it starts
+	// shedding. If Target isn't positive, the LoadShedder admits every request.
+	Target time.Duration
+	// Window is how long the LoadShedder remembers the fastest request it's
+	// seen. It defaults to ten seconds.
+	Window time.Duration
+	// Clock measures request latency. It defaults to the system clock.
+	Clock Clock
+}
+
+// LoadShedder is an AdmissionController that sheds a fraction of requests
+// when the server is overloaded. Rather than enforcing a fixed concurrency
+// limit, it watches for requests queueing up. The fastest request in the
+// recent window shows how long a request takes when it doesn't wait for
+// anything, so the time requests take beyond that is queueing delay. The
+// LoadShedder predicts the queueing delay of a new request: the recent
+// average, scaled up by however much deeper the queue of in-flight requests
+// is than usual. Once the prediction exceeds the target, it rejects enough
+// requests to bring it back down: at twice the target, it rejects half.
+// Handlers that are slow but steady don't queue, so they're never shed.
+// Rejected requests fail with CodeUnavailable, which clients may safely
+// retry.
+//
+// Latencies include the whole RPC, so long-lived streams skew the average.
+// Serve streaming procedures from a ServeMux without a LoadShedder.
+type LoadShedder struct {
+	target time.Duration
+	window time.Duration
+	clock  Clock
+
+	mu        sync.Mutex
+	inFlight  int
+	depth     float64 // moving average of in-flight requests
+	delay     float64 // moving average of queueing delay, in nanoseconds
+	floor     time.Duration
+	nextFloor time.Duration // fastest request in the current window
+	windowEnd time.Time
+	debt      float64 // fraction of a request we owe to shedding
+}
+
+var _ AdmissionController = (*LoadShedder)(nil)
+
+// NewLoadShedder constructs a LoadShedder.
+func NewLoadShedder(config LoadShedderConfig) *LoadShedder {
+	if config.Window <= 0 {
+		config.Window = defaultLoadShedderWindow
+	}
+	if config.Clock == nil {
+		config.Clock = systemClock{}
+	}
+	return &LoadShedder{
+		target: config.Target,
+		window: config.Window,
+		clock:  config.Clock,
+	}
+}
+
+// Admit implements AdmissionController.
+func (s *LoadShedder) Admit(*http.Request) (func(), bool) {
+	s.mu.Lock()
+	fraction := s.shedFraction()
+	if fraction == 0 {
+		s.debt = 0
+	}
+	// Accumulate fractional rejections rather than rolling dice, so we shed
+	// exactly the intended share of requests.
+	s.debt += fraction
+	if s.debt >= 1 {
+		s.debt--
+		s.mu.Unlock()
+		return nil, false
+	}
+	s.inFlight++
+	s.depth = smooth(s.depth, float64(s.inFlight))
+	s.mu.Unlock()
+	start := s.clock.Now()
+	return func() {
+		now := s.clock.Now()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.inFlight--
+		s.observe(now, now.Sub(start))
+	}, true
+}
+
+// ShedFraction returns the fraction of new requests the LoadShedder is
+// currently rejecting.
+func (s *LoadShedder) ShedFraction() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shedFraction()
+}
+
+// observe records a finished request's latency. It must be called with the
+// mutex held.
+func (s *LoadShedder) observe(now time.Time, latency time.Duration) {
+	// Track the fastest request over a sliding window of one to two windows,
+	// so the floor rises again if the handler gets slower for good.
+	switch {
+	case s.windowEnd.IsZero():
+		s.floor, s.nextFloor = latency, latency
+		s.windowEnd = now.Add(s.window)
+	case !now.Before(s.windowEnd):
+		s.floor = minDuration(s.nextFloor, latency)
+		s.nextFloor = latency
+		s.windowEnd = now.Add(s.window)
+	default:
+		s.floor = minDuration(s.floor, latency)
+		s.nextFloor = minDuration(s.nextFloor, latency)
+	}
+	delay := float64(latency - s.floor)
+	s.delay += loadShedderSmoothing * (delay - s.delay)
+}
+
+// shedFraction must be called with the mutex held.
+func (s *LoadShedder) shedFraction() float64 {
+	if s.delay <= 0 || s.target <= 0 || s.inFlight == 0 {
+		// Requests aren't queueing, we have nothing to go on, or there's nothing
+		// for a new request to queue behind.
+		return 0
+	}
+	depth := s.depth
+	if depth < 1 {
+		depth = 1
+	}
+	predicted := s.delay * float64(s.inFlight+1) / depth
+	fraction := 1 - float64(s.target)/predicted
+	switch {
+	case fraction < 0:
+		return 0
+	case fraction > maxShedFraction:
+		return maxShedFraction
+	default:
+		return fraction
+	}
+}
+
+// smooth folds a sample into an exponentially weighted moving average. The
+// first sample initializes the average.
+func smooth(average, sample float64) float64 {
+	if average == 0 {
+		return sample
+	}
+	return average + loadShedderSmoothing*(sample-average)
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,519 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package longrunning serves and calls the google.longrunning.Operations
+// service, so that services can start work that outlives a single RPC and let
+// clients get, wait for, and cancel it.
+//
+// Handlers start operations with a Manager and return the resulting
+// Operation to the client. Mount the Manager's handler, built with
+// NewHandler, next to your own services; clients then use a Client to wait
+// for the operation and Result to unpack its response.
+//
+// The Operation and Status messages in
+// github.com/bufbuild/connect-go/proto/connect/longrunning/v1 are
+// wire-compatible with google.longrunning.Operation and google.rpc.Status, so
+// the handler interoperates with other clients of the standard service.
+package longrunning
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	longrunningv1 "github.com/bufbuild/connect-go/proto/connect/longrunning/v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// OperationsServiceName is the fully-qualified name of the Operations service.
+const OperationsServiceName = "google.longrunning.Operations"
+
+const (
+	getOperationProcedure    = "/" + OperationsServiceName + "/GetOperation"
+	listOperationsProcedure  = "/" + OperationsServiceName + "/ListOperations"
+	deleteOperationProcedure = "/" + OperationsServiceName + "/DeleteOperation"
+	cancelOperationProcedure = "/" + OperationsServiceName + "/CancelOperation"
+	waitOperationProcedure   = "/" + OperationsServiceName + "/WaitOperation"
+
+	defaultPageSize = 100
+	// defaultRetention is how long finished operations are kept by default.
+	defaultRetention = time.Hour
+	// maxWaitTimeout bounds each WaitOperation call made by Client.Wait, so
+	// that proxies don't give up on idle requests.
+	maxWaitTimeout = time.Minute
+)
+
+// A Manager tracks long-running operations in memory. Operations are
+// forgotten when they're deleted, when they've been finished for longer than
+// the retention period, or when the process exits.
+type Manager struct {
+	retention time.Duration
+	clock     connect.Clock
+
+	mu         sync.Mutex
+	operations map[string]*operation
+}
+
+type operation struct {
+	proto  *longrunningv1.Operation // guarded by Manager.mu
+	cancel context.CancelFunc
+	done   chan struct{} // closed once the operation finishes
+	evict  func() bool   // guarded by Manager.mu, set once the operation finishes
+}
+
+// A ManagerOption configures a Manager.
+type ManagerOption interface {
+	applyToManager(*Manager)
+}
+
+// WithRetention sets how long a Manager keeps operations after they finish,
+// so that clients have time to fetch the results. Finished operations are
+// then forgotten, as though they'd been deleted. By default, they're kept for
+// an hour.
+func WithRetention(retention time.Duration) ManagerOption {
+	return &retentionOption{retention: retention}
+}
+
+// WithClock sets the Clock a Manager uses to expire finished operations. It
+// defaults to the system clock.
+func WithClock(clock connect.Clock) ManagerOption {
+	return &clockOption{clock: clock}
+}
+
+// NewManager constructs a Manager with no operations.
+func NewManager(options ...ManagerOption) *Manager {
+	manager := &Manager{
+		retention:  defaultRetention,
+		clock:      systemClock{},
+		operations: make(map[string]*operation),
+	}
+	for _, opt := range options {
+		opt.applyToManager(manager)
+	}
+	return manager
+}
+
+// Start runs work in its own goroutine and tracks it as a new operation. The
+// work's context isn't derived from any request, so the operation outlives
+// the RPC that started it; CancelOperation and DeleteOperation cancel it. The
+// work receives the operation's name, which it can use to report progress
+// with SetMetadata.
+//
+// If the work returns an error, the operation fails with the error's code.
+// Otherwise, the work's response, which may be nil, becomes the operation's
+// response. Start returns a snapshot of the new operation, which handlers
+// usually send to the client.
+func (m *Manager) Start(
+	work func(ctx context.Context, name string) (proto.Message, error),
+) (*longrunningv1.Operation, error) {
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("generate operation name: %w", err))
+	}
+	name := "operations/" + hex.EncodeToString(raw[:])
+	ctx, cancel := context.WithCancel(context.Background())
+	op := &operation{
+		proto:  &longrunningv1.Operation{Name: name},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	m.mu.Lock()
+	m.operations[name] = op
+	snapshot := proto.Clone(op.proto).(*longrunningv1.Operation) //nolint:forcetypeassert
+	m.mu.Unlock()
+	go func() {
+		defer cancel()
+		response, err := work(ctx, name)
+		m.finish(op, response, err)
+	}()
+	return snapshot, nil
+}
+
+func (m *Manager) finish(op *operation, response proto.Message, err error) {
+	if err == nil && response == nil {
+		response = &emptypb.Empty{}
+	}
+	var packed *anypb.Any
+	if err == nil {
+		var marshalErr error
+		packed, marshalErr = anypb.New(response)
+		if marshalErr != nil {
+			err = connect.NewError(connect.CodeInternal, fmt.Errorf("marshal response: %w", marshalErr))
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	op.proto.Done = true
+	if err != nil {
+		op.proto.Result = &longrunningv1.Operation_Error{Error: statusFromError(err)}
+	} else {
+		op.proto.Result = &longrunningv1.Operation_Response{Response: packed}
+	}
+	close(op.done)
+	if _, ok := m.operations[op.proto.Name]; ok {
+		op.evict = m.clock.AfterFunc(m.retention, func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if m.operations[op.proto.Name] == op {
+				delete(m.operations, op.proto.Name)
+			}
+		})
+	}
+}
+
+// SetMetadata updates an operation's metadata, which usually describes its
+// progress.
+func (m *Manager) SetMetadata(name string, metadata proto.Message) error {
+	packed, err := anypb.New(metadata)
+	if err != nil {
+		return connect.NewError(connect.CodeInternal, fmt.Errorf("marshal metadata: %w", err))
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	op, ok := m.operations[name]
+	if !ok {
+		return notFound(name)
+	}
+	op.proto.Metadata = packed
+	return nil
+}
+
+// Get returns a snapshot of an operation.
+func (m *Manager) Get(name string) (*longrunningv1.Operation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	op, ok := m.operations[name]
+	if !ok {
+		return nil, notFound(name)
+	}
+	return proto.Clone(op.proto).(*longrunningv1.Operation), nil //nolint:forcetypeassert
+}
+
+// Cancel cancels an operation's context. The operation finishes once its work
+// returns, usually with CodeCanceled. Canceling a finished operation has no
+// effect.
+func (m *Manager) Cancel(name string) error {
+	m.mu.Lock()
+	op, ok := m.operations[name]
+	m.mu.Unlock()
+	if !ok {
+		return notFound(name)
+	}
+	op.cancel()
+	return nil
+}
+
+// Delete forgets an operation, canceling it if it's still running.
+func (m *Manager) Delete(name string) error {
+	m.mu.Lock()
+	op, ok := m.operations[name]
+	delete(m.operations, name)
+	if ok && op.evict != nil {
+		op.evict()
+	}
+	m.mu.Unlock()
+	if !ok {
+		return notFound(name)
+	}
+	op.cancel()
+	return nil
+}
+
+// Wait blocks until the operation finishes, the timeout elapses, or the
+// context ends, then returns a snapshot of the operation. A non-positive
+// timeout waits indefinitely.
+func (m *Manager) Wait(ctx context.Context, name string, timeout time.Duration) (*longrunningv1.Operation, error) {
+	m.mu.Lock()
+	op, ok := m.operations[name]
+	m.mu.Unlock()
+	if !ok {
+		return nil, notFound(name)
+	}
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-op.done:
+	case <-expired:
+	case <-ctx.Done():
+		return nil, connect.NewError(codeOf(ctx.Err()), ctx.Err())
+	}
+	return m.Get(name)
+}
+
+// list returns a page of operations whose names start with prefix, in order.
+func (m *Manager) list(prefix string, pageSize int, pageToken string) ([]*longrunningv1.Operation, string) {
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.operations))
+	for name := range m.operations {
+		if strings.HasPrefix(name, prefix) && name > pageToken {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var next string
+	if len(names) > pageSize {
+		names = names[:pageSize]
+		next = names[pageSize-1]
+	}
+	operations := make([]*longrunningv1.Operation, len(names))
+	for i, name := range names {
+		operations[i] = proto.Clone(m.operations[name].proto).(*longrunningv1.Operation) //nolint:forcetypeassert
+	}
+	return operations, next
+}
+
+// NewHandler builds an HTTP handler that serves the google.longrunning.Operations
+// service from the Manager's operations. It returns the path on which to mount
+// the handler and the handler itself.
+func NewHandler(manager *Manager, opts ...connect.HandlerOption) (string, http.Handler) {
+	mux := http.NewServeMux()
+	mux.Handle(getOperationProcedure, connect.NewUnaryHandler(
+		getOperationProcedure,
+		func(_ context.Context, request *connect.Request[longrunningv1.GetOperationRequest]) (*connect.Response[longrunningv1.Operation], error) {
+			op, err := manager.Get(request.Msg.Name)
+			if err != nil {
+				return nil, err
+			}
+			return connect.NewResponse(op), nil
+		},
+		opts...,
+	))
+	mux.Handle(listOperationsProcedure, connect.NewUnaryHandler(
+		listOperationsProcedure,
+		func(_ context.Context, request *connect.Request[longrunningv1.ListOperationsRequest]) (*connect.Response[longrunningv1.ListOperationsResponse], error) {
+			if request.Msg.Filter != "" {
+				return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("filters aren't supported"))
+			}
+			operations, next := manager.list(request.Msg.Name, int(request.Msg.PageSize), request.Msg.PageToken)
+			return connect.NewResponse(&longrunningv1.ListOperationsResponse{
+				Operations:    operations,
+				NextPageToken: next,
+			}), nil
+		},
+		opts...,
+	))
+	mux.Handle(deleteOperationProcedure, connect.NewUnaryHandler(
+		deleteOperationProcedure,
+		func(_ context.Context, request *connect.Request[longrunningv1.DeleteOperationRequest]) (*connect.Response[emptypb.Empty], error) {
+			if err := manager.Delete(request.Msg.Name); err != nil {
+				return nil, err
+			}
+			return connect.NewResponse(&emptypb.Empty{}), nil
+		},
+		opts...,
+	))
+	mux.Handle(cancelOperationProcedure, connect.NewUnaryHandler(
+		cancelOperationProcedure,
+		func(_ context.Context, request *connect.Request[longrunningv1.CancelOperationRequest]) (*connect.Response[emptypb.Empty], error) {
+			if err := manager.Cancel(request.Msg.Name); err != nil {
+				return nil, err
+			}
+			return connect.NewResponse(&emptypb.Empty{}), nil
+		},
+		opts...,
+	))
+	mux.Handle(waitOperationProcedure, connect.NewUnaryHandler(
+		waitOperationProcedure,
+		func(ctx context.Context, request *connect.Request[longrunningv1.WaitOperationRequest]) (*connect.Response[longrunningv1.Operation], error) {
+			op, err := manager.Wait(ctx, request.Msg.Name, request.Msg.Timeout.AsDuration())
+			if err != nil {
+				return nil, err
+			}
+			return connect.NewResponse(op), nil
+		},
+		opts...,
+	))
+	return "/" + OperationsServiceName + "/", mux
+}
+
+// Client calls the google.longrunning.Operations service.
+type Client struct {
+	get    *connect.Client[longrunningv1.GetOperationRequest, longrunningv1.Operation]
+	delete *connect.Client[longrunningv1.DeleteOperationRequest, emptypb.Empty]
+	cancel *connect.Client[longrunningv1.CancelOperationRequest, emptypb.Empty]
+	wait   *connect.Client[longrunningv1.WaitOperationRequest, longrunningv1.Operation]
+}
+
+// NewClient constructs a Client. Like generated clients, the URL should be the
+// base URL of the server.
+func NewClient(httpClient connect.HTTPClient, baseURL string, opts ...connect.ClientOption) *Client {
+	baseURL = strings.TrimRight(baseURL, "/")
+	return &Client{
+		get: connect.NewClient[longrunningv1.GetOperationRequest, longrunningv1.Operation](
+			httpClient, baseURL+getOperationProcedure, opts...,
+		),
+		delete: connect.NewClient[longrunningv1.DeleteOperationRequest, emptypb.Empty](
+			httpClient, baseURL+deleteOperationProcedure, opts...,
+		),
+		cancel: connect.NewClient[longrunningv1.CancelOperationRequest, emptypb.Empty](
+			httpClient, baseURL+cancelOperationProcedure, opts...,
+		),
+		wait: connect.NewClient[longrunningv1.WaitOperationRequest, longrunningv1.Operation](
+			httpClient, baseURL+waitOperationProcedure, opts...,
+		),
+	}
+}
+
+// Get returns the current state of an operation.
+func (c *Client) Get(ctx context.Context, name string) (*longrunningv1.Operation, error) {
+	response, err := c.get.CallUnary(ctx, connect.NewRequest(&longrunningv1.GetOperationRequest{Name: name}))
+	if err != nil {
+		return nil, err
+	}
+	return response.Msg, nil
+}
+
+// Cancel asks the server to cancel an operation. Cancellation is
+// asynchronous: use Wait to find out how the operation finished.
+func (c *Client) Cancel(ctx context.Context, name string) error {
+	_, err := c.cancel.CallUnary(ctx, connect.NewRequest(&longrunningv1.CancelOperationRequest{Name: name}))
+	return err
+}
+
+// Delete tells the server that the client is no longer interested in an
+// operation.
+func (c *Client) Delete(ctx context.Context, name string) error {
+	_, err := c.delete.CallUnary(ctx, connect.NewRequest(&longrunningv1.DeleteOperationRequest{Name: name}))
+	return err
+}
+
+// Wait blocks until an operation finishes or the context ends. Use Result to
+// unpack the finished operation.
+func (c *Client) Wait(ctx context.Context, name string) (*longrunningv1.Operation, error) {
+	for {
+		timeout := maxWaitTimeout
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+			timeout = time.Until(deadline)
+		}
+		response, err := c.wait.CallUnary(ctx, connect.NewRequest(&longrunningv1.WaitOperationRequest{
+			Name:    name,
+			Timeout: durationpb.New(timeout),
+		}))
+		if err != nil {
+			return nil, err
+		}
+		if response.Msg.Done {
+			return response.Msg, nil
+		}
+	}
+}
+
+// Result unpacks a finished operation's response into the supplied message.
+// If the operation failed, Result returns its error as a *connect.Error.
+func Result(op *longrunningv1.Operation, response proto.Message) error {
+	if !op.GetDone() {
+		return connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("operation %s isn't done", op.GetName()))
+	}
+	if status := op.GetError(); status != nil {
+		return errorFromStatus(status)
+	}
+	if err := op.GetResponse().UnmarshalTo(response); err != nil {
+		return connect.NewError(connect.CodeInternal, fmt.Errorf("unmarshal response: %w", err))
+	}
+	return nil
+}
+
+func statusFromError(err error) *longrunningv1.Status {
+	status := &longrunningv1.Status{
+		Code:    int32(codeOf(err)),
+		Message: err.Error(),
+	}
+	var connectErr *connect.Error
+	if errors.As(err, &connectErr) {
+		status.Message = connectErr.Message()
+		for _, detail := range connectErr.Details() {
+			if packed, ok := detail.(*anypb.Any); ok {
+				status.Details = append(status.Details, packed)
+			}
+		}
+	}
+	return status
+}
+
+func errorFromStatus(status *longrunningv1.Status) *connect.Error {
+	var underlying error
+	if status.Message != "" {
+		underlying = errors.New(status.Message)
+	}
+	err := connect.NewError(connect.Code(status.Code), underlying)
+	for _, detail := range status.Details {
+		err.AddDetail(detail)
+	}
+	return err
+}
+
+// codeOf is like connect.CodeOf, but it also recognizes context errors returned by
+// canceled or expired work.
+func codeOf(err error) connect.Code {
+	var connectErr *connect.Error
+	switch {
+	case errors.As(err, &connectErr):
+		return connectErr.Code()
+	case errors.Is(err, context.Canceled):
+		return connect.CodeCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return connect.CodeDeadlineExceeded
+	default:
+		return connect.CodeUnknown
+	}
+}
+
+type retentionOption struct {
+	retention time.Duration
+}
+
+func (o *retentionOption) applyToManager(manager *Manager) {
+	manager.retention = o.retention
+}
+
+type clockOption struct {
+	clock connect.Clock
+}
+
+func (o *clockOption) applyToManager(manager *Manager) {
+	manager.clock = o.clock
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) func() bool {
+	return time.AfterFunc(d, f).Stop
+}
+
+func notFound(name string) *connect.Error {
+	return connect.NewError(connect.CodeNotFound, fmt.Errorf("operation %q not found", name))
+}
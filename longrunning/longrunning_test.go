@@ -0,0 +1,171 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package longrunning_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/bufbuild/connect-go/internal/assert"
+	"github.com/bufbuild/connect-go/longrunning"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestOperations(t *testing.T) {
+	t.Parallel()
+	manager := longrunning.NewManager()
+	mux := http.NewServeMux()
+	mux.Handle(longrunning.NewHandler(manager))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	client := longrunning.NewClient(server.Client(), server.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	t.Run("success", func(t *testing.T) {
+		t.Parallel()
+		release := make(chan struct{})
+		op, err := manager.Start(func(_ context.Context, name string) (proto.Message, error) {
+			if err := manager.SetMetadata(name, wrapperspb.String("working")); err != nil {
+				return nil, err
+			}
+			<-release
+			return wrapperspb.String("done"), nil
+		})
+		assert.Nil(t, err)
+		assert.False(t, op.Done)
+		got, err := client.Get(ctx, op.Name)
+		assert.Nil(t, err)
+		assert.Equal(t, got.Name, op.Name)
+		assert.False(t, got.Done)
+		close(release)
+		finished, err := client.Wait(ctx, op.Name)
+		assert.Nil(t, err)
+		assert.True(t, finished.Done)
+		var metadata wrapperspb.StringValue
+		assert.Nil(t, finished.Metadata.UnmarshalTo(&metadata))
+		assert.Equal(t, metadata.Value, "working")
+		var response wrapperspb.StringValue
+		assert.Nil(t, longrunning.Result(finished, &response))
+		assert.Equal(t, response.Value, "done")
+	})
+	t.Run("cancel", func(t *testing.T) {
+		t.Parallel()
+		op, err := manager.Start(func(ctx context.Context, _ string) (proto.Message, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+		assert.Nil(t, err)
+		assert.Nil(t, client.Cancel(ctx, op.Name))
+		finished, err := client.Wait(ctx, op.Name)
+		assert.Nil(t, err)
+		err = longrunning.Result(finished, &wrapperspb.StringValue{})
+		assert.Equal(t, connect.CodeOf(err), connect.CodeCanceled)
+	})
+	t.Run("delete", func(t *testing.T) {
+		t.Parallel()
+		op, err := manager.Start(func(_ context.Context, _ string) (proto.Message, error) {
+			return nil, connect.NewError(connect.CodeFailedPrecondition, nil)
+		})
+		assert.Nil(t, err)
+		finished, err := client.Wait(ctx, op.Name)
+		assert.Nil(t, err)
+		err = longrunning.Result(finished, &wrapperspb.StringValue{})
+		assert.Equal(t, connect.CodeOf(err), connect.CodeFailedPrecondition)
+		assert.Nil(t, client.Delete(ctx, op.Name))
+		_, err = client.Get(ctx, op.Name)
+		assert.Equal(t, connect.CodeOf(err), connect.CodeNotFound)
+	})
+}
+
+func TestRetention(t *testing.T) {
+	t.Parallel()
+	clock := &fakeClock{now: time.Now()}
+	manager := longrunning.NewManager(
+		longrunning.WithRetention(time.Minute),
+		longrunning.WithClock(clock),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	op, err := manager.Start(func(context.Context, string) (proto.Message, error) {
+		return wrapperspb.String("done"), nil
+	})
+	assert.Nil(t, err)
+	finished, err := manager.Wait(ctx, op.Name, 0)
+	assert.Nil(t, err)
+	assert.True(t, finished.Done)
+	clock.Advance(time.Minute - time.Nanosecond)
+	_, err = manager.Get(op.Name)
+	assert.Nil(t, err)
+	clock.Advance(time.Nanosecond)
+	_, err = manager.Get(op.Name)
+	assert.Equal(t, connect.CodeOf(err), connect.CodeNotFound)
+}
+
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) func() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	timer := &fakeTimer{at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, timer)
+	return func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		wasPending := !timer.stopped
+		timer.stopped = true
+		return wasPending
+	}
+}
+
+// Advance moves the clock forward, synchronously running any timers that
+// expire.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var expired []func()
+	for _, timer := range c.timers {
+		if !timer.stopped && !timer.at.After(c.now) {
+			timer.stopped = true
+			expired = append(expired, timer.f)
+		}
+	}
+	c.mu.Unlock()
+	for _, f := range expired {
+		f()
+	}
+}
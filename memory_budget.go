@@ -0,0 +1,126 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// A MemoryBudget caps the total number of message bytes buffered by a
+// server across all in-flight RPCs. Share a single MemoryBudget between all
+// your handlers with WithMemoryBudget.
+//
+// Handlers reserve space in the budget for each request message as it's read
+// off the wire, and count each response message against it while it's being
+// written. They release the space when they're done with the message. While
+// the budget is exhausted, handlers reject new RPCs with
+// CodeResourceExhausted, and in-flight RPCs fail to receive messages that
+// don't fit. Response messages have already been allocated by the time
+// they're counted, so they're never rejected. Request sizes are measured on
+// the wire, before decompression, and response sizes before compression.
+//
+// A nil *MemoryBudget imposes no limit.
+type MemoryBudget struct {
+	limit int64
+	inUse int64 // atomic
+}
+
+// NewMemoryBudget constructs a MemoryBudget that allows at most limit bytes
+// of buffered messages.
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	return &MemoryBudget{limit: limit}
+}
+
+// Limit returns the maximum number of bytes the budget allows.
+func (b *MemoryBudget) Limit() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.limit
+}
+
+// InUse returns the number of bytes currently reserved.
+func (b *MemoryBudget) InUse() int64 {
+	if b == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&b.inUse)
+}
+
+// exhausted reports whether the budget has no room left.
+func (b *MemoryBudget) exhausted() bool {
+	return b != nil && atomic.LoadInt64(&b.inUse) >= b.limit
+}
+
+// reserve claims n bytes, failing if they don't fit.
+func (b *MemoryBudget) reserve(n int64) *Error {
+	if b == nil || n <= 0 {
+		return nil
+	}
+	for {
+		inUse := atomic.LoadInt64(&b.inUse)
+		if inUse+n > b.limit {
+			return errorf(
+				CodeResourceExhausted,
+				"server memory budget exhausted: %d bytes in use, %d more requested, limit %d",
+				inUse, n, b.limit,
+			)
+		}
+		if atomic.CompareAndSwapInt64(&b.inUse, inUse, inUse+n) {
+			return nil
+		}
+	}
+}
+
+// add claims n bytes unconditionally. It's used for memory that's already
+// been allocated, like marshaled response messages.
+func (b *MemoryBudget) add(n int64) {
+	if b == nil || n <= 0 {
+		return
+	}
+	atomic.AddInt64(&b.inUse, n)
+}
+
+func (b *MemoryBudget) release(n int64) {
+	if b == nil || n <= 0 {
+		return
+	}
+	atomic.AddInt64(&b.inUse, -n)
+}
+
+// budgetReader reserves space in a MemoryBudget for each chunk it reads. It's
+// used when the message size isn't known up front.
+type budgetReader struct {
+	reader   io.Reader
+	budget   *MemoryBudget
+	reserved int64
+}
+
+func (r *budgetReader) Read(data []byte) (int, error) {
+	n, err := r.reader.Read(data)
+	if n > 0 {
+		if reserveErr := r.budget.reserve(int64(n)); reserveErr != nil {
+			return n, reserveErr
+		}
+		r.reserved += int64(n)
+	}
+	return n, err
+}
+
+func (r *budgetReader) release() {
+	r.budget.release(r.reserved)
+	r.reserved = 0
+}
@@ -0,0 +1,439 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// ServeMux routes requests to Handlers by procedure. Unlike http.ServeMux,
+// handlers may be added and removed while the server is running, so servers
+// that load services at runtime (from plugins, for example) don't need to
+// restart.
+//
+// Routing is lock-free and doesn't allocate: each change rebuilds the routing
+// table, and in-flight requests keep using the table they started with.
+// Changes are expected to be rare relative to requests, so servers with
+// hundreds of services pay for the rebuild at registration time.
+//
+// Services can use OnServe and OnShutdown to warm and flush per-procedure
+// state. To shut down gracefully, call Shutdown before shutting down the
+// http.Server: it rejects new requests, so clients retry against other
+// servers, and waits for in-flight requests before running the OnShutdown
+// hooks.
+//
+//	mux.Shutdown(ctx)
+//	server.Shutdown(ctx)
+type ServeMux struct {
+	routes    copyOnWriteMap[http.Handler]
+	table     atomic.Value // *routeTable, rebuilt from routes on each change
+	admission atomic.Value // admissionHolder
+
+	inFlight    int64         // atomic
+	draining    int32         // atomic, 1 once Shutdown is called
+	drained     chan struct{} // closed once draining with no requests in flight
+	drainedOnce sync.Once
+
+	hooksMu    sync.Mutex // also serializes Handle, so hooks run once per registration
+	onServe    []func(procedure string)
+	onShutdown []func(ctx context.Context, procedure string)
+}
+
+// admissionHolder lets us store a possibly-nil AdmissionController in an
+// atomic.Value, which requires a consistent concrete type.
+type admissionHolder struct {
+	controller AdmissionController
+}
+
+// NewServeMux constructs an empty ServeMux.
+func NewServeMux() *ServeMux {
+	return &ServeMux{drained: make(chan struct{})}
+}
+
+var _ http.Handler = (*ServeMux)(nil)
+
+// Handle registers a handler for a path, replacing any handler already
+// registered for it. Paths are either a single procedure, like
+// "/acme.foo.v1.FooService/Bar", or a service prefix ending in a slash, like
+// "/acme.foo.v1.FooService/". Generated constructors return paths of the
+// second form:
+//
+//	mux.Handle(foov1connect.NewFooServiceHandler(fooService))
+func (m *ServeMux) Handle(path string, handler http.Handler) {
+	m.hooksMu.Lock()
+	defer m.hooksMu.Unlock()
+	for _, procedure := range proceduresAt(path, m.routes.load()) {
+		for _, hook := range m.onServe {
+			hook(procedure)
+		}
+	}
+	m.routes.update(func(routes map[string]http.Handler) {
+		routes[path] = handler
+		m.table.Store(newRouteTable(routes))
+	})
+}
+
+// Remove unregisters the handler for a path, reporting whether one was
+// registered. Requests already being served complete normally. Remove doesn't
+// run the OnShutdown hooks for the removed procedures, since it doesn't wait
+// for their requests to finish, and Shutdown won't run them later either.
+func (m *ServeMux) Remove(path string) bool {
+	var removed bool
+	m.routes.update(func(routes map[string]http.Handler) {
+		_, removed = routes[path]
+		delete(routes, path)
+		m.table.Store(newRouteTable(routes))
+	})
+	return removed
+}
+
+// SetAdmissionController installs an AdmissionController, like a
+// LoadShedder, that decides which requests to dispatch. Requests it rejects
+// get a 503, which clients of all protocols see as CodeUnavailable. Passing
+// nil removes the controller. Requests already being served are unaffected.
+func (m *ServeMux) SetAdmissionController(controller AdmissionController) {
+	m.admission.Store(admissionHolder{controller: controller})
+}
+
+// OnServe registers a hook that runs for each procedure the ServeMux serves:
+// immediately for procedures that are already registered, and from Handle for
+// procedures registered later, before they receive any requests. Use it to
+// warm caches.
+//
+// Hooks are called with procedures, like "/acme.foo.v1.FooService/Bar".
+// Service prefixes, like those returned by generated constructors, are
+// expanded into the service's procedures using protoregistry.GlobalFiles; the
+// prefixes of services that aren't registered there are passed as-is. Hooks
+// run while the ServeMux holds a lock, so they mustn't call Handle or OnServe.
+func (m *ServeMux) OnServe(hook func(procedure string)) {
+	m.hooksMu.Lock()
+	defer m.hooksMu.Unlock()
+	m.onServe = append(m.onServe, hook)
+	for _, procedure := range m.procedures() {
+		hook(procedure)
+	}
+}
+
+// OnShutdown registers a hook that Shutdown runs for each procedure once
+// in-flight requests have finished. Procedures are the same as for OnServe.
+// Use it to flush per-procedure state.
+func (m *ServeMux) OnShutdown(hook func(ctx context.Context, procedure string)) {
+	m.hooksMu.Lock()
+	defer m.hooksMu.Unlock()
+	m.onShutdown = append(m.onShutdown, hook)
+}
+
+// Shutdown drains the ServeMux. From then on, it rejects new requests with a
+// 503, which clients of all protocols see as CodeUnavailable. Once in-flight
+// requests finish, it runs the OnShutdown hooks for each procedure.
+//
+// If the context expires first, Shutdown runs the hooks anyway and returns
+// the context's error. Only the first call runs the hooks; later calls just
+// wait for in-flight requests.
+func (m *ServeMux) Shutdown(ctx context.Context) error {
+	first := atomic.CompareAndSwapInt32(&m.draining, 0, 1)
+	if atomic.LoadInt64(&m.inFlight) == 0 {
+		m.drainedOnce.Do(func() { close(m.drained) })
+	}
+	err := m.waitForInFlight(ctx)
+	if !first {
+		return err
+	}
+	m.hooksMu.Lock()
+	onShutdown := m.onShutdown
+	m.hooksMu.Unlock()
+	for _, procedure := range m.procedures() {
+		for _, hook := range onShutdown {
+			hook(ctx, procedure)
+		}
+	}
+	return err
+}
+
+// waitForInFlight waits until no requests are in flight. It must be called
+// after draining is set.
+func (m *ServeMux) waitForInFlight(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-m.drained:
+		return nil
+	}
+}
+
+// finish counts a request as done, waking Shutdown if it was the last one in
+// flight. The request path uses only atomics, so it stays free of locks: once
+// draining is set, whichever of Shutdown and the last request sees the other's
+// write closes the channel.
+func (m *ServeMux) finish() {
+	if atomic.AddInt64(&m.inFlight, -1) == 0 && atomic.LoadInt32(&m.draining) == 1 {
+		m.drainedOnce.Do(func() { close(m.drained) })
+	}
+}
+
+// ServeHTTP dispatches the request to the handler for its procedure, falling
+// back to the handler for its service. If neither is registered, it responds
+// with a 404. Once the ServeMux is shutting down, it responds with a 503.
+func (m *ServeMux) ServeHTTP(responseWriter http.ResponseWriter, request *http.Request) {
+	handler := m.route(request.URL.Path)
+	if handler == nil {
+		http.NotFound(responseWriter, request)
+		return
+	}
+	// Count the request before checking whether we're draining, so Shutdown
+	// can't miss it.
+	atomic.AddInt64(&m.inFlight, 1)
+	defer m.finish()
+	if atomic.LoadInt32(&m.draining) == 1 {
+		http.Error(responseWriter, "server shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if holder, _ := m.admission.Load().(admissionHolder); holder.controller != nil {
+		done, ok := holder.controller.Admit(request)
+		if !ok {
+			http.Error(responseWriter, "server overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		defer done()
+	}
+	handler.ServeHTTP(responseWriter, request)
+}
+
+// route returns the handler for a path, or nil if there isn't one.
+func (m *ServeMux) route(path string) http.Handler {
+	table, _ := m.table.Load().(*routeTable)
+	return table.route(path)
+}
+
+// procedures returns the procedures served by the registered paths, in
+// sorted order.
+func (m *ServeMux) procedures() []string {
+	routes := m.routes.load()
+	var procedures []string
+	for path := range routes {
+		procedures = append(procedures, proceduresAt(path, routes)...)
+	}
+	sort.Strings(procedures)
+	return procedures
+}
+
+// proceduresAt returns the procedures served by the handler registered at
+// path. Service prefixes are expanded using the global registry, skipping
+// procedures that have their own route. If the service isn't registered, the
+// prefix stands in for its procedures.
+func proceduresAt(path string, routes map[string]http.Handler) []string {
+	if !strings.HasSuffix(path, "/") {
+		return []string{path}
+	}
+	name := protoreflect.FullName(strings.Trim(path, "/"))
+	descriptor, err := protoregistry.GlobalFiles.FindDescriptorByName(name)
+	if err != nil {
+		return []string{path}
+	}
+	service, ok := descriptor.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return []string{path}
+	}
+	methods := service.Methods()
+	procedures := make([]string, 0, methods.Len())
+	for i := 0; i < methods.Len(); i++ {
+		procedure := path + string(methods.Get(i).Name())
+		if _, ok := routes[procedure]; !ok {
+			procedures = append(procedures, procedure)
+		}
+	}
+	return procedures
+}
+
+// routeTable is an immutable index of a ServeMux's routes. Nearly every path
+// is a procedure, like "/acme.foo.v1.FooService/Bar", or a service prefix, so
+// the table groups routes by service. Most requests are then resolved with a
+// single map lookup keyed by a substring of the path, rather than a failed
+// lookup of the whole procedure followed by a lookup of its service. In
+// benchmarks, Go's maps beat a perfect hash of the full path.
+type routeTable struct {
+	services map[string]*serviceRoutes // keyed by prefix, like "/acme.foo.v1.FooService/"
+	other    map[string]http.Handler   // paths with no prefix, like "/healthz"
+}
+
+type serviceRoutes struct {
+	handler    http.Handler            // registered for the prefix, may be nil
+	procedures map[string]http.Handler // keyed by method name, nil if there are none
+}
+
+func newRouteTable(routes map[string]http.Handler) *routeTable {
+	table := &routeTable{
+		services: make(map[string]*serviceRoutes),
+		other:    make(map[string]http.Handler),
+	}
+	for path, handler := range routes {
+		i := strings.LastIndexByte(path, '/')
+		if i <= 0 {
+			table.other[path] = handler
+			continue
+		}
+		service, ok := table.services[path[:i+1]]
+		if !ok {
+			service = &serviceRoutes{}
+			table.services[path[:i+1]] = service
+		}
+		if i == len(path)-1 {
+			service.handler = handler
+			continue
+		}
+		if service.procedures == nil {
+			service.procedures = make(map[string]http.Handler)
+		}
+		service.procedures[path[i+1:]] = handler
+	}
+	return table
+}
+
+// route returns the handler registered for the path, falling back to the
+// handler for its prefix. It returns nil if there's neither.
+func (t *routeTable) route(path string) http.Handler {
+	if t == nil {
+		return nil
+	}
+	i := strings.LastIndexByte(path, '/')
+	if i <= 0 {
+		return t.other[path]
+	}
+	service, ok := t.services[path[:i+1]]
+	if !ok {
+		return nil
+	}
+	if service.procedures != nil {
+		if handler, ok := service.procedures[path[i+1:]]; ok {
+			return handler
+		}
+	}
+	return service.handler
+}
+
+// TenantMux hosts a separate ServeMux for each tenant on one listener, so the
+// same procedure can be served by different implementations or versions. A
+// function of the request, like TenantFromHeader or TenantFromSNI, chooses
+// the tenant. Requests for unknown tenants go to the default tenant, whose
+// name is the empty string; if there's no default, they get a 404.
+//
+// Like ServeMux, tenants may be added and removed while the server is running.
+type TenantMux struct {
+	tenantOf func(*http.Request) string
+	tenants  copyOnWriteMap[*ServeMux]
+}
+
+// NewTenantMux constructs a TenantMux with no tenants.
+func NewTenantMux(tenantOf func(*http.Request) string) *TenantMux {
+	return &TenantMux{tenantOf: tenantOf}
+}
+
+var _ http.Handler = (*TenantMux)(nil)
+
+// TenantFromHeader identifies tenants by the value of a request header.
+func TenantFromHeader(name string) func(*http.Request) string {
+	return func(request *http.Request) string {
+		return request.Header.Get(name)
+	}
+}
+
+// TenantFromSNI identifies tenants by the server name the client requested
+// during the TLS handshake. Requests without TLS use the default tenant.
+func TenantFromSNI(request *http.Request) string {
+	if request.TLS == nil {
+		return ""
+	}
+	return request.TLS.ServerName
+}
+
+// Tenant returns the ServeMux for a tenant, creating it if necessary.
+func (m *TenantMux) Tenant(name string) *ServeMux {
+	if mux, ok := m.tenants.load()[name]; ok {
+		return mux
+	}
+	var mux *ServeMux
+	m.tenants.update(func(tenants map[string]*ServeMux) {
+		// Another caller may have created the tenant since we checked.
+		if existing, ok := tenants[name]; ok {
+			mux = existing
+			return
+		}
+		mux = NewServeMux()
+		tenants[name] = mux
+	})
+	return mux
+}
+
+// RemoveTenant removes a tenant and all its handlers, reporting whether the
+// tenant existed. Requests already being served complete normally.
+func (m *TenantMux) RemoveTenant(name string) bool {
+	return m.tenants.remove(name)
+}
+
+// ServeHTTP dispatches the request to its tenant's ServeMux.
+func (m *TenantMux) ServeHTTP(responseWriter http.ResponseWriter, request *http.Request) {
+	tenants := m.tenants.load()
+	mux, ok := tenants[m.tenantOf(request)]
+	if !ok {
+		mux, ok = tenants[""]
+	}
+	if !ok {
+		http.NotFound(responseWriter, request)
+		return
+	}
+	mux.ServeHTTP(responseWriter, request)
+}
+
+// copyOnWriteMap is a map optimized for frequent reads and rare writes. Reads
+// never block: each write copies the map, then swaps it in.
+type copyOnWriteMap[V any] struct {
+	mu    sync.Mutex   // serializes writes
+	value atomic.Value // map[string]V, never mutated once stored
+}
+
+func (m *copyOnWriteMap[V]) load() map[string]V {
+	current, _ := m.value.Load().(map[string]V)
+	return current
+}
+
+// update applies a change to a copy of the map, then swaps it in.
+func (m *copyOnWriteMap[V]) update(change func(map[string]V)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current := m.load()
+	next := make(map[string]V, len(current)+1)
+	for key, value := range current {
+		next[key] = value
+	}
+	change(next)
+	m.value.Store(next)
+}
+
+func (m *copyOnWriteMap[V]) remove(key string) bool {
+	var removed bool
+	m.update(func(values map[string]V) {
+		_, removed = values[key]
+		delete(values, key)
+	})
+	return removed
+}
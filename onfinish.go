@@ -0,0 +1,132 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats describes a completed RPC. Handlers pass it to WithOnFinish
+// callbacks.
+type Stats struct {
+	// Duration is the time between the handler receiving the request headers
+	// and closing the response.
+	Duration time.Duration
+	// MessagesReceived and MessagesSent count the request and response
+	// messages.
+	MessagesReceived int64
+	MessagesSent     int64
+}
+
+type onFinish struct {
+	callback func(context.Context, Spec, error, Stats)
+	clock    Clock
+}
+
+// start wraps a stream so that the callback can report its outcome. Call
+// finish once the RPC is complete.
+func (f *onFinish) start(sender Sender, receiver Receiver) (Sender, Receiver, *finishRecorder) {
+	recorder := &finishRecorder{onFinish: f, start: f.clock.Now()}
+	return &onFinishSender{Sender: sender, recorder: recorder},
+		&onFinishReceiver{Receiver: receiver, recorder: recorder},
+		recorder
+}
+
+type finishRecorder struct {
+	onFinish *onFinish
+	start    time.Time
+	sent     int64 // atomic
+	received int64 // atomic
+
+	closeOnce sync.Once
+	err       error
+}
+
+func (r *finishRecorder) close(err error) {
+	r.closeOnce.Do(func() { r.err = err })
+}
+
+func (r *finishRecorder) finish(ctx context.Context, spec Spec) {
+	// Handlers should always close the response, but we still report
+	// something if they don't.
+	r.close(nil)
+	r.onFinish.callback(ctx, spec, r.err, Stats{
+		Duration:         r.onFinish.clock.Now().Sub(r.start),
+		MessagesReceived: atomic.LoadInt64(&r.received),
+		MessagesSent:     atomic.LoadInt64(&r.sent),
+	})
+}
+
+type onFinishSender struct {
+	Sender
+
+	recorder *finishRecorder
+}
+
+func (s *onFinishSender) Send(msg any) error {
+	err := s.Sender.Send(msg)
+	if err == nil {
+		atomic.AddInt64(&s.recorder.sent, 1)
+	}
+	return err
+}
+
+func (s *onFinishSender) sendUncompressed(msg any) error {
+	err := sendUncompressed(s.Sender, msg)
+	if err == nil {
+		atomic.AddInt64(&s.recorder.sent, 1)
+	}
+	return err
+}
+
+func (s *onFinishSender) sendBatch(messages []any) error {
+	err := sendBatch(s.Sender, messages)
+	if err == nil {
+		atomic.AddInt64(&s.recorder.sent, int64(len(messages)))
+	}
+	return err
+}
+
+func (s *onFinishSender) Close(err error) error {
+	closeErr := s.Sender.Close(err)
+	if err == nil {
+		// If writing the end of the response failed, the client didn't get a
+		// complete response.
+		err = closeErr
+	}
+	s.recorder.close(err)
+	return closeErr
+}
+
+type onFinishReceiver struct {
+	Receiver
+
+	recorder *finishRecorder
+}
+
+func (r *onFinishReceiver) Receive(msg any) error {
+	err := r.Receiver.Receive(msg)
+	if err == nil {
+		atomic.AddInt64(&r.recorder.received, 1)
+	}
+	return err
+}
+
+func (r *onFinishReceiver) readAhead() {
+	readAhead(r.Receiver)
+}
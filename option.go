@@ -16,7 +16,11 @@ package connect
 
 import (
 	"compress/gzip"
-	"io/ioutil"
+	"context"
+	"time"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
 // A ClientOption configures a connect client.
@@ -34,7 +38,8 @@ type ClientOption interface {
 //
 // It's safe to use this option liberally: servers will ignore any
 // compression algorithms they don't support. To compress requests, pair this
-// option with WithSendCompression.
+// option with WithSendCompression. To compress requests without asking for
+// compressed responses, or vice versa, see WithResponseCompression.
 //
 // Clients accept gzipped requests by default, using a compressor backed by the
 // standard library's gzip package with the default compression level. Use
@@ -65,6 +70,105 @@ func WithGRPCWeb() ClientOption {
 	return &grpcOption{web: true}
 }
 
+// WithProtocol configures clients to use the named protocol: ProtocolConnect,
+// ProtocolGRPC, or ProtocolGRPCWeb. It's equivalent to the protocol-specific
+// options, but lets the protocol be chosen at runtime (for example, from a
+// configuration file) so that a single generated client can call Connect
+// servers, gRPC servers, or gRPC-Web proxies.
+//
+// Constructing a client with an unknown protocol name returns an error on
+// every call.
+func WithProtocol(name string) ClientOption {
+	return &protocolOption{Name: name}
+}
+
+// WithTransport configures clients to send and receive messages using a
+// custom Transport rather than HTTP. The HTTPClient passed to NewClient is
+// ignored, and so are protocol, codec, and compression options: the
+// Transport handles serialization itself. Interceptors still apply.
+func WithTransport(transport Transport) ClientOption {
+	return &transportOption{Transport: transport}
+}
+
+// WithGRPCFallback configures gRPC clients to retry unary and server streaming
+// calls using the gRPC-Web protocol when HTTP/2 is unavailable, which usually
+// happens when a proxy between the client and server only speaks HTTP/1.1.
+// Once a call has fallen back, the client uses gRPC-Web for all subsequent
+// unary and server streaming calls. Client and bidirectional streams can't be
+// retried transparently and keep using gRPC.
+//
+// Retried calls pass through the client's interceptors a second time. This
+// option has no effect unless the client also uses WithGRPC.
+func WithGRPCFallback() ClientOption {
+	return &fallbackOption{Protocol: &protocolGRPC{web: true}}
+}
+
+// WithConnectFallback is like WithGRPCFallback, but falls back to the Connect
+// protocol rather than gRPC-Web. The server must support Connect.
+func WithConnectFallback() ClientOption {
+	return &fallbackOption{Protocol: &protocolConnect{}}
+}
+
+// WithDefaultTimeout sets a timeout for calls whose context has no deadline.
+// Calls with a deadline are unaffected. Clients generated by
+// protoc-gen-connect-go use this option for methods with the
+// connect.options.v1.default_timeout option.
+func WithDefaultTimeout(timeout time.Duration) ClientOption {
+	return &defaultTimeoutOption{Timeout: timeout}
+}
+
+// WithIdempotencyKey attaches a randomly generated Idempotency-Key header to
+// each unary call, unless the caller has already set one. The key is generated
+// before any interceptors run, so interceptors that retry the call send the
+// same key with every attempt. The key is only added to the headers sent on
+// the wire, not to the caller's Request, so sending the same Request again
+// makes a new call with a new key. Handlers can retrieve the key with
+// IdempotencyKeyFromContext and use it to deduplicate retried calls.
+func WithIdempotencyKey() ClientOption {
+	return &idempotencyKeyOption{}
+}
+
+// WithUserAgent appends product tokens, like "billing-service/1.2.3", to the
+// User-Agent header sent by the client, so that servers and proxies can
+// attribute traffic to specific applications. The default User-Agent is
+// preserved: it identifies connect-go for the Connect protocol and follows
+// the gRPC specification's grpc-<language>/<version> format for gRPC and
+// gRPC-Web.
+func WithUserAgent(userAgent string) ClientOption {
+	return &userAgentOption{userAgent: userAgent}
+}
+
+// WithRoundTripMiddleware wraps the client's HTTPClient with middleware, which
+// sees every HTTP request the client sends, including retries. Middleware
+// from earlier options wraps middleware from later ones, and within one option
+// the first middleware is the outermost. See RoundTripMiddleware for the
+// constraints on reading request and response bodies.
+func WithRoundTripMiddleware(middleware ...RoundTripMiddleware) ClientOption {
+	return &roundTripMiddlewareOption{middleware: ChainRoundTripMiddleware(middleware...)}
+}
+
+// WithServiceConfig configures the client's timeout, retry policy, and message
+// size limits from the MethodConfig that best matches its procedure. Clients
+// with no matching MethodConfig are unaffected. See ParseServiceConfig to load
+// a gRPC service config from JSON.
+func WithServiceConfig(config *ServiceConfig) ClientOption {
+	return &serviceConfigOption{config: config}
+}
+
+// WithCallStats configures a client to record a CallStats for each unary call,
+// breaking its latency down into time spent waiting for and establishing a
+// connection, waiting for the server, and serializing messages. Retrieve the
+// stats from the response with Response.CallStats, or from the error with
+// Error.CallStats. Errors carry their stats in a wrapper, so use errors.As
+// rather than a type assertion to get the *Error.
+//
+// Recording uses net/http/httptrace, so the connection-level timings are only
+// available when the client's HTTPClient is built on net/http's Transport.
+// Calls are timed with the Clock set by WithClock.
+func WithCallStats() ClientOption {
+	return &callStatsOption{}
+}
+
 // WithProtoJSON configures a client to send JSON-encoded data instead of
 // binary Protobuf. It uses the standard Protobuf JSON mapping as implemented
 // by google.golang.org/protobuf/encoding/protojson: fields are named using
@@ -123,11 +227,226 @@ func WithCompression(
 	}
 }
 
+// WithAllowedCodecs restricts handlers to the named codecs, like "proto" or
+// "json", even if others are registered. Requests using any other codec are
+// rejected with HTTP status 415 Unsupported Media Type, as the protocols
+// require. For example, internal services may disable JSON:
+//
+//	connect.WithAllowedCodecs("proto")
+//
+// The gRPC protocols still use binary Protobuf for error details.
+func WithAllowedCodecs(names ...string) HandlerOption {
+	return &allowedCodecsOption{names: append([]string{}, names...)}
+}
+
+// WithAllowedCompression restricts handlers to the named compression
+// algorithms, even if others are registered. Requests compressed with any
+// other algorithm are rejected with CodeUnimplemented, and responses are only
+// compressed with allowed algorithms. Include "identity" to allow
+// uncompressed requests; without it, requests must declare an allowed
+// algorithm.
+func WithAllowedCompression(names ...string) HandlerOption {
+	return &allowedCompressionOption{names: append([]string{}, names...)}
+}
+
+// WithCompressionSelector lets handlers choose how to compress each response.
+// The selector receives the procedure (for example,
+// "/acme.foo.v1.FooService/Bar") and the compression algorithms the client
+// accepts, and returns the name of the algorithm to use. Returning
+// "identity" or the empty string disables compression, which is useful for
+// procedures whose payloads are already compressed, like images or zstd
+// blobs.
+//
+// If the selector returns an algorithm that the client doesn't accept or the
+// handler doesn't support, it's ignored and the handler negotiates
+// compression as usual.
+func WithCompressionSelector(selector func(procedure string, acceptEncoding []string) string) HandlerOption {
+	return &compressionSelectorOption{Selector: selector}
+}
+
+// WithProcedureOptions applies options only to the handler for a single
+// procedure (for example, "/acme.foo.v1.FooService/Bar"), and is ignored by
+// handlers for all other procedures. Since generated handler constructors
+// apply the same options to every method of a service, this lets operators
+// override options for particular methods:
+//
+//	pingv1connect.NewPingServiceHandler(
+//	  &pingServer{},
+//	  connect.WithCompressMinBytes(1024),
+//	  connect.WithProcedureOptions(
+//	    "/connect.ping.v1.PingService/CountUp",
+//	    connect.WithCompressMinBytes(64),
+//	  ),
+//	)
+//
+// Like other options, later options take precedence over earlier ones.
+func WithProcedureOptions(procedure string, options ...HandlerOption) HandlerOption {
+	return &procedureOptionsOption{
+		Procedure: extractProtoPath(procedure),
+		options:   options,
+	}
+}
+
 // WithHandlerOptions composes multiple HandlerOptions into one.
 func WithHandlerOptions(options ...HandlerOption) HandlerOption {
 	return &handlerOptionsOption{options}
 }
 
+// WithHTTPBody lets plain HTTP callers reach a unary procedure whose request
+// or response is a google.api.HttpBody, so services can accept arbitrary
+// blobs (images, CSV uploads) and serve downloads without an RPC client. It's
+// typically passed with WithProcedureOptions, since generated constructors
+// apply the same options to every method.
+//
+// POSTs with any Content-Type other than those of the RPC protocols are
+// served raw. An HttpBody request is populated from the request's
+// Content-Type and body; other requests are decoded as JSON. An HttpBody
+// response is written as a raw body with its declared Content-Type
+// (application/octet-stream if unset); other responses are written as JSON.
+// Errors, timeouts, and compression work as they do for unary Connect.
+//
+// Since raw callers may send JSON, application/json requests are served raw,
+// so Connect clients must call these procedures with the default Protobuf
+// codec. gRPC and gRPC-Web clients work as usual. Content-Types that belong to
+// the RPC protocols but aren't supported by the handler, like
+// application/grpc+json without a registered JSON codec, are still rejected
+// with HTTP status 415 Unsupported Media Type. For procedures without an
+// HttpBody request or response, WithHTTPBody has no effect.
+func WithHTTPBody() HandlerOption {
+	return &httpBodyOption{}
+}
+
+// WithContextValues registers a function that derives each RPC's context
+// before any interceptors run. Use it to inject request-scoped dependencies,
+// like loggers, database handles, or tenant configuration, without writing an
+// Interceptor:
+//
+//	connect.WithContextValues(func(ctx context.Context, spec connect.Spec) context.Context {
+//	  return context.WithValue(ctx, loggerKey{}, logger.With("procedure", spec.Procedure))
+//	})
+//
+// Repeated WithContextValues options are applied in order.
+func WithContextValues(inject func(context.Context, Spec) context.Context) HandlerOption {
+	return &contextValuesOption{inject: inject}
+}
+
+// WithOnFinish registers a callback that handlers call exactly once per RPC,
+// after closing the response. It receives the RPC's context and Spec, the
+// error sent to the client (or nil), and some basic Stats. It's a cheap way
+// to log or record metrics for every RPC without writing an Interceptor.
+//
+// The callback runs synchronously, so it should return quickly. Later
+// WithOnFinish options replace earlier ones.
+func WithOnFinish(callback func(context.Context, Spec, error, Stats)) HandlerOption {
+	return &onFinishOption{callback: callback}
+}
+
+// WithIdleStreamTimeout aborts streaming RPCs that neither send nor receive a
+// message for longer than the timeout, reclaiming resources from clients
+// that have abandoned a stream but kept the connection alive. When the
+// timeout expires, the handler's context is canceled and pending and future
+// calls to Receive fail with CodeDeadlineExceeded, as does the RPC itself.
+// Handlers should return promptly once that happens.
+//
+// The timeout doesn't apply to unary RPCs. Passing a non-positive timeout
+// disables it.
+func WithIdleStreamTimeout(timeout time.Duration) HandlerOption {
+	return &idleStreamTimeoutOption{timeout: timeout}
+}
+
+// WithWriteTimeout bounds how long handlers may spend writing each response
+// message (and the end of the response) to the client, so that a client that
+// stops reading can't hold a handler goroutine forever. Each write is timed
+// with the handler's Clock, so time spent between messages doesn't count; a
+// write still running when its timeout elapses is interrupted by expiring
+// its deadline with http.ResponseController. Stalled writes fail with
+// CodeDeadlineExceeded, which streaming handlers see as an error from Send.
+//
+// Write deadlines require Go 1.20 or later and a ResponseWriter that
+// supports them, like those of net/http's HTTP/1.1 and HTTP/2 servers. The
+// timeout doesn't apply to streams served with Handler.ServeStream. Passing
+// a non-positive timeout disables it.
+func WithWriteTimeout(timeout time.Duration) HandlerOption {
+	return &writeTimeoutOption{timeout: timeout}
+}
+
+// WithMemoryBudget limits the total bytes of messages buffered by handlers
+// sharing the budget. While the budget is exhausted, new RPCs are rejected
+// with CodeResourceExhausted. To cap memory across a whole server, construct
+// one MemoryBudget and pass it to every handler.
+func WithMemoryBudget(budget *MemoryBudget) HandlerOption {
+	return &memoryBudgetOption{budget: budget}
+}
+
+// WithIdempotencyCache makes unary handlers replay earlier responses to calls
+// with the same Idempotency-Key, so that client retries and redeliveries from
+// at-least-once queues don't repeat side effects. Successful responses,
+// including their headers and trailers, are kept in the store for the
+// supplied duration, as measured by the handler's Clock, under the procedure,
+// caller scope, and key; failed calls aren't cached, so retrying them runs the
+// handler again. Calls without a key are unaffected. Clients send keys when
+// constructed with WithIdempotencyKey.
+//
+// Responses are only replayed to calls with the same request message. Calls
+// that reuse a key with a different message fail with CodeInvalidArgument.
+// Keys are chosen by clients, so handlers serving more than one user should
+// also use WithIdempotencyScope, which keeps callers from replaying each
+// other's responses.
+//
+// Cached responses are replayed below the interceptors, so authentication and
+// logging interceptors still see every call. Concurrent duplicates may both
+// run the handler. Responses are stored in binary Protobuf, so messages must
+// be Protobuf messages. Errors from the store are ignored.
+func WithIdempotencyCache(store IdempotencyStore, ttl time.Duration) HandlerOption {
+	return &idempotencyCacheOption{store: store, ttl: ttl}
+}
+
+// WithIdempotencyScope identifies the caller of each call cached with
+// WithIdempotencyCache, usually by returning the authenticated user or tenant.
+// Cached responses are only replayed to calls with the same scope. If scope
+// returns an error, the call fails with that error. By default, all callers
+// share one scope.
+func WithIdempotencyScope(scope func(context.Context, AnyRequest) (string, error)) HandlerOption {
+	return &idempotencyScopeOption{scope: scope}
+}
+
+// WithMaxHeaderBytes limits the size of request headers, counted as the
+// total length of keys and values. Handlers reject requests with larger
+// headers with CodeResourceExhausted, which clients and interceptors see as an
+// ordinary RPC error. It complements limits enforced by the HTTP server, like
+// http.Server's MaxHeaderBytes and HTTP/2's SETTINGS_MAX_HEADER_LIST_SIZE,
+// which reject requests before they reach the handler.
+//
+// Setting n to zero or a negative number removes the limit.
+func WithMaxHeaderBytes(n int) HandlerOption {
+	return &maxHeaderBytesOption{max: n}
+}
+
+// WithPriorityScheduler limits how many requests handlers sharing the
+// scheduler serve at once. During overload, waiting requests are served in
+// order of the priority clients request with the Connect-Priority header;
+// see PriorityScheduler for details.
+func WithPriorityScheduler(scheduler *PriorityScheduler) HandlerOption {
+	return &prioritySchedulerOption{scheduler: scheduler}
+}
+
+// WithBandwidthLimiter limits the rate at which handlers sharing the limiter
+// send and receive message bytes, across all their streams. To keep bulk
+// transfers from starving other RPCs, give the bulk-transfer handlers a shared
+// limiter and leave latency-sensitive handlers unlimited.
+func WithBandwidthLimiter(limiter *BandwidthLimiter) HandlerOption {
+	return &bandwidthLimiterOption{limiter: limiter}
+}
+
+// WithStreamBandwidthLimit limits the rate at which each of a handler's
+// streams sends and receives message bytes, with bursts of up to one
+// second's worth of bytes. It may be combined with WithBandwidthLimiter, in
+// which case streams are subject to both limits. Passing a non-positive rate
+// removes the limit.
+func WithStreamBandwidthLimit(bytesPerSecond int64) HandlerOption {
+	return &streamBandwidthLimitOption{bytesPerSecond: bytesPerSecond}
+}
+
 // Option implements both ClientOption and HandlerOption, so it can be applied
 // both client-side and server-side.
 type Option interface {
@@ -135,6 +454,33 @@ type Option interface {
 	HandlerOption
 }
 
+// WithChecksums protects each message with a CRC32C checksum, so corruption
+// introduced by misbehaving proxies or middleboxes fails the call with
+// CodeDataLoss instead of going unnoticed. Clients checksum every request
+// message and ask handlers to checksum their responses. Handlers checksum
+// responses only when the client asks, so they remain compatible with clients
+// that don't support checksums.
+//
+// Clients and handlers always verify checksums they receive, even without this
+// option. For streaming protocols, the checksum is appended to each message's
+// envelope. Since other gRPC implementations reject these envelopes, only use
+// WithChecksums on clients when the server is known to use connect-go.
+func WithChecksums() Option {
+	return &checksumsOption{}
+}
+
+// WithClock sets the Clock that a client or handler uses to encode and
+// enforce deadlines and timeouts. Clients use it to compute the remaining time
+// sent in timeout headers, and handlers use it to enforce the timeouts they
+// receive. It's primarily useful in tests: with a synthetic Clock, timeout
+// paths can be exercised without sleeping.
+//
+// By default, clients and handlers use the system clock. Passing a nil Clock
+// restores the default.
+func WithClock(clock Clock) Option {
+	return &clockOption{clock: clock}
+}
+
 // WithCodec registers a serialization method with a client or handler.
 // Handlers may have multiple codecs registered, and use whichever the client
 // chooses. Clients may only have a single codec.
@@ -164,6 +510,79 @@ func WithCompressMinBytes(min int) Option {
 	return &compressMinBytesOption{Min: min}
 }
 
+// WithResponseCompression restricts the compression algorithms used for
+// responses, independently of those used for requests. Payload sizes are often
+// lopsided, like tiny requests and large responses, so it's wasteful to
+// compress both directions the same way.
+//
+// On clients, it limits the algorithms servers are asked to compress
+// responses with, without affecting WithSendCompression. Each name must be
+// registered with WithAcceptCompression, or the client will return errors at
+// runtime. Use "identity" alone to ask for uncompressed responses, even if
+// requests are compressed.
+//
+// On handlers, it limits the algorithms used to compress responses, without
+// affecting which compressed requests are accepted (see
+// WithAllowedCompression). If a request is compressed with any other
+// algorithm, the response uses another algorithm the client accepts or is
+// sent uncompressed. Use "identity" alone to never compress responses.
+//
+// By default, responses may use any registered algorithm.
+func WithResponseCompression(names ...string) Option {
+	return &responseCompressionOption{names: append([]string{}, names...)}
+}
+
+// WithDeflateDictionary registers a DEFLATE (RFC 1951) compression algorithm
+// that uses a preset dictionary. For small messages with repetitive structure,
+// a dictionary trained on representative payloads often improves compression
+// ratios dramatically. Since the algorithm is only useful to peers with the
+// same dictionary, register it under a name that identifies the dictionary.
+//
+// On clients, WithDeflateDictionary asks servers to compress responses with
+// the algorithm; use WithSendCompression to also compress requests. On
+// handlers, use WithProcedureOptions to scope dictionaries to the procedures
+// they were trained for:
+//
+//	connect.WithProcedureOptions(
+//	  "/acme.foo.v1.FooService/Bar",
+//	  connect.WithDeflateDictionary("deflate-bar-v1", barDictionary),
+//	)
+//
+// Connect doesn't bundle zstd. To use zstd dictionaries, pass
+// dictionary-aware constructors from a zstd package to WithCompression and
+// WithAcceptCompression.
+func WithDeflateDictionary(name string, dictionary []byte) Option {
+	return &compressionOption{
+		Name:            name,
+		CompressionPool: newDeflateDictionaryPool(dictionary),
+	}
+}
+
+// WithGzipLevel sets the compression level for gzip, which clients and
+// handlers support by default. Levels are as in the standard library's
+// compress/gzip package; invalid levels use gzip.DefaultCompression. Gzip
+// readers and writers are pooled per level and shared by all clients and
+// handlers.
+func WithGzipLevel(level int) Option {
+	return &compressionOption{
+		Name:            compressionGzip,
+		CompressionPool: gzipCompressionPool(level),
+	}
+}
+
+// WithIdempotency sets the procedure's idempotency level, which is available
+// to interceptors in Spec. protoc-gen-connect-go applies it to the clients and
+// handlers of methods with an idempotency_level option, before any options
+// supplied by the caller.
+//
+// Retry interceptors only retry procedures with IdempotencyNoSideEffects or
+// IdempotencyIdempotent, so clients of other procedures can opt into retries
+// with this option. See RetryPolicy for a way to override the level of
+// individual procedures.
+func WithIdempotency(level IdempotencyLevel) Option {
+	return &idempotencyOption{level: level}
+}
+
 // WithInterceptors configures a client or handler's interceptor stack. Repeated
 // WithInterceptors options are applied in order, so
 //
@@ -215,11 +634,123 @@ func WithInterceptors(interceptors ...Interceptor) Option {
 	return &interceptorsOption{interceptors}
 }
 
+// WithLazyUnmarshal defers unmarshaling of streamed messages until they're
+// first accessed. Server streams on clients and client streams in handlers
+// capture each message's raw bytes in Receive, and only unmarshal them when
+// Msg is called. Consumers that filter or forward streams and discard most
+// messages skip the cost of decoding them.
+//
+// Stream interceptors wrapping the Receiver and validators must see the
+// generated message structs, so clients and handlers with interceptors or
+// validation ignore this option and unmarshal messages in Receive. Unary
+// procedures, bidirectional streams, and the single request of a server
+// streaming procedure are always unmarshaled eagerly.
+func WithLazyUnmarshal() Option {
+	return &lazyUnmarshalOption{}
+}
+
+// WithMessageTransformer transforms each message after it's marshaled and
+// before it's compressed, and reverses the transformation after decompressing
+// and before unmarshaling. It's typically used to encrypt payloads end-to-end
+// when TLS terminates at an intermediary: encode encrypts marshaled messages,
+// and decode decrypts them. Errors from encode fail the call with
+// CodeInternal, and errors from decode fail it with CodeInvalidArgument.
+//
+// Empty messages are sent as empty payloads and aren't transformed. Error
+// details and other protocol-level metadata aren't transformed either.
+// Clients and handlers must be configured with matching transformers.
+func WithMessageTransformer(encode, decode func([]byte) ([]byte, error)) Option {
+	return &messageTransformerOption{
+		transformer: &messageTransformer{encode: encode, decode: decode},
+	}
+}
+
 // WithOptions composes multiple Options into one.
 func WithOptions(options ...Option) Option {
 	return &optionsOption{options}
 }
 
+// WithSlowRequestLog logs unary calls and stream setups that take longer than
+// the threshold. Each log line includes the procedure, the peer, and the
+// time remaining before the call's deadline when it started. For streams,
+// setup ends when handlers first send or close the stream, and when clients
+// first receive a message or error from the server.
+//
+// Each slow call is reported by calling logger with the call's context and a
+// single-line message, so it's easy to adapt to any logging library.
+// Unlike a logging interceptor, WithSlowRequestLog writes nothing for calls
+// that complete quickly, so it's suitable for always-on production use.
+// Passing a non-positive threshold or a nil logger disables slow request
+// logging. Calls are timed with the Clock set by WithClock.
+func WithSlowRequestLog(threshold time.Duration, logger func(context.Context, string)) Option {
+	return &slowRequestLogOption{threshold: threshold, logger: logger}
+}
+
+// WithTypeRegistry registers the message and enum types reachable from the
+// services' methods into types, and uses types to resolve google.protobuf.Any
+// values in JSON. The default JSON codec resolves Any fields of requests and
+// responses with it, and the Connect protocol resolves the details of JSON
+// errors with it, so clients and handlers can exchange Any values whose types
+// aren't linked into the binary or aren't in the global registry.
+//
+// Types that aren't in the registry, like most well-known types, are resolved
+// with the global registry. Types not linked into the binary are registered
+// using google.golang.org/protobuf/types/dynamicpb, and types that are already
+// registered under the same name are left alone.
+//
+// WithTypeRegistry copies the registry once it's registered the services'
+// types, so register any others - for example, the types expected in error
+// details - before calling it. Later changes to types have no effect.
+//
+//	types := new(protoregistry.Types)
+//	client := pingv1connect.NewPingServiceClient(
+//		http.DefaultClient,
+//		"https://api.acme.com",
+//		connect.WithProtoJSON(),
+//		connect.WithTypeRegistry(types, pingv1.File_connect_ping_v1_ping_proto.Services().Get(0)),
+//	)
+//
+// Custom codecs registered under the "json" name are unaffected.
+func WithTypeRegistry(types *protoregistry.Types, services ...protoreflect.ServiceDescriptor) Option {
+	if types != nil {
+		registerServiceTypes(types, services)
+		types = copyTypes(types)
+	}
+	return &typeRegistryOption{types: types}
+}
+
+// WithValidation enables message validation using the Validator configured
+// with WithValidator. Clients validate each request before sending it, and
+// handlers validate each request after receiving it and before calling the
+// implementation. Invalid messages fail the call with CodeInvalidArgument;
+// if the validator's error has a ToProto method returning a Protobuf message
+// (as protovalidate's errors do), the message is attached as an error detail.
+//
+// protoc-gen-connect-go emits WithValidation for methods whose messages carry
+// buf.validate constraints when run with the validate=true parameter. Without
+// a Validator, WithValidation has no effect.
+func WithValidation() Option {
+	return &validationOption{}
+}
+
+// WithValidator configures the Validator used by procedures with validation
+// enabled. It's typically passed to generated constructors, which enable
+// validation for each procedure that needs it:
+//
+//	validator, err := protovalidate.New()
+//	if err != nil {
+//		return err
+//	}
+//	path, handler := pingv1connect.NewPingServiceHandler(
+//		&pingServer{},
+//		connect.WithValidator(validator),
+//	)
+//
+// To validate every message, combine it with WithValidation.
+func WithValidator(validator Validator) Option {
+	return &validatorOption{validator: validator}
+}
+
 type clientOptionsOption struct {
 	options []ClientOption
 }
@@ -230,6 +761,41 @@ func (o *clientOptionsOption) applyToClient(config *clientConfig) {
 	}
 }
 
+type callStatsOption struct{}
+
+func (o *callStatsOption) applyToClient(config *clientConfig) {
+	config.CallStats = true
+}
+
+type checksumsOption struct{}
+
+func (o *checksumsOption) applyToClient(config *clientConfig) {
+	config.Checksums = true
+}
+
+func (o *checksumsOption) applyToHandler(config *handlerConfig) {
+	config.Checksums = true
+}
+
+type clockOption struct {
+	clock Clock
+}
+
+func (o *clockOption) applyToClient(config *clientConfig) {
+	config.Clock = o.resolve()
+}
+
+func (o *clockOption) applyToHandler(config *handlerConfig) {
+	config.Clock = o.resolve()
+}
+
+func (o *clockOption) resolve() Clock {
+	if o.clock == nil {
+		return systemClock{}
+	}
+	return o.clock
+}
+
 type codecOption struct {
 	Codec Codec
 }
@@ -257,16 +823,21 @@ func (o *compressionOption) applyToClient(config *clientConfig) {
 	if o.Name == "" || o.CompressionPool == nil {
 		return
 	}
+	if _, ok := config.CompressionPools[o.Name]; !ok {
+		// Replacing an algorithm, like gzip, shouldn't advertise it twice.
+		config.CompressionNames = append(config.CompressionNames, o.Name)
+	}
 	config.CompressionPools[o.Name] = o.CompressionPool
-	config.CompressionNames = append(config.CompressionNames, o.Name)
 }
 
 func (o *compressionOption) applyToHandler(config *handlerConfig) {
 	if o.Name == "" || o.CompressionPool == nil {
 		return
 	}
+	if _, ok := config.CompressionPools[o.Name]; !ok {
+		config.CompressionNames = append(config.CompressionNames, o.Name)
+	}
 	config.CompressionPools[o.Name] = o.CompressionPool
-	config.CompressionNames = append(config.CompressionNames, o.Name)
 }
 
 type compressMinBytesOption struct {
@@ -281,6 +852,42 @@ func (o *compressMinBytesOption) applyToHandler(config *handlerConfig) {
 	config.CompressMinBytes = o.Min
 }
 
+type responseCompressionOption struct {
+	names []string
+}
+
+func (o *responseCompressionOption) applyToClient(config *clientConfig) {
+	config.ResponseCompression = o.names
+}
+
+func (o *responseCompressionOption) applyToHandler(config *handlerConfig) {
+	config.ResponseCompression = o.names
+}
+
+type allowedCodecsOption struct {
+	names []string
+}
+
+func (o *allowedCodecsOption) applyToHandler(config *handlerConfig) {
+	config.AllowedCodecs = o.names
+}
+
+type allowedCompressionOption struct {
+	names []string
+}
+
+func (o *allowedCompressionOption) applyToHandler(config *handlerConfig) {
+	config.AllowedCompression = o.names
+}
+
+type compressionSelectorOption struct {
+	Selector func(string, []string) string
+}
+
+func (o *compressionSelectorOption) applyToHandler(config *handlerConfig) {
+	config.CompressionSelector = o.Selector
+}
+
 type handlerOptionsOption struct {
 	options []HandlerOption
 }
@@ -291,6 +898,20 @@ func (o *handlerOptionsOption) applyToHandler(config *handlerConfig) {
 	}
 }
 
+type procedureOptionsOption struct {
+	Procedure string
+	options   []HandlerOption
+}
+
+func (o *procedureOptionsOption) applyToHandler(config *handlerConfig) {
+	if config.Procedure != o.Procedure {
+		return
+	}
+	for _, option := range o.options {
+		option.applyToHandler(config)
+	}
+}
+
 type grpcOption struct {
 	web bool
 }
@@ -299,6 +920,194 @@ func (o *grpcOption) applyToClient(config *clientConfig) {
 	config.Protocol = &protocolGRPC{web: o.web}
 }
 
+type protocolOption struct {
+	Name string
+}
+
+func (o *protocolOption) applyToClient(config *clientConfig) {
+	switch o.Name {
+	case ProtocolConnect:
+		config.Protocol = &protocolConnect{}
+	case ProtocolGRPC:
+		config.Protocol = &protocolGRPC{web: false}
+	case ProtocolGRPCWeb:
+		config.Protocol = &protocolGRPC{web: true}
+	default:
+		config.Protocol = nil
+		config.UnknownProtocol = o.Name
+	}
+}
+
+type transportOption struct {
+	Transport Transport
+}
+
+func (o *transportOption) applyToClient(config *clientConfig) {
+	config.Transport = o.Transport
+}
+
+type fallbackOption struct {
+	Protocol protocol
+}
+
+func (o *fallbackOption) applyToClient(config *clientConfig) {
+	config.FallbackProtocol = o.Protocol
+}
+
+type defaultTimeoutOption struct {
+	Timeout time.Duration
+}
+
+func (o *defaultTimeoutOption) applyToClient(config *clientConfig) {
+	config.DefaultTimeout = o.Timeout
+}
+
+type roundTripMiddlewareOption struct {
+	middleware RoundTripMiddleware
+}
+
+func (o *roundTripMiddlewareOption) applyToClient(config *clientConfig) {
+	if config.RoundTripMiddleware == nil {
+		config.RoundTripMiddleware = o.middleware
+		return
+	}
+	config.RoundTripMiddleware = ChainRoundTripMiddleware(config.RoundTripMiddleware, o.middleware)
+}
+
+type userAgentOption struct {
+	userAgent string
+}
+
+func (o *userAgentOption) applyToClient(config *clientConfig) {
+	config.UserAgent = o.userAgent
+}
+
+type idempotencyKeyOption struct{}
+
+func (o *idempotencyKeyOption) applyToClient(config *clientConfig) {
+	config.IdempotencyKey = true
+}
+
+type serviceConfigOption struct {
+	config *ServiceConfig
+}
+
+func (o *serviceConfigOption) applyToClient(config *clientConfig) {
+	config.ServiceConfig = o.config
+}
+
+type idleStreamTimeoutOption struct {
+	timeout time.Duration
+}
+
+func (o *idleStreamTimeoutOption) applyToHandler(config *handlerConfig) {
+	config.IdleStreamTimeout = o.timeout
+}
+
+type writeTimeoutOption struct {
+	timeout time.Duration
+}
+
+func (o *writeTimeoutOption) applyToHandler(config *handlerConfig) {
+	config.WriteTimeout = o.timeout
+}
+
+type memoryBudgetOption struct {
+	budget *MemoryBudget
+}
+
+func (o *memoryBudgetOption) applyToHandler(config *handlerConfig) {
+	config.MemoryBudget = o.budget
+}
+
+type idempotencyCacheOption struct {
+	store IdempotencyStore
+	ttl   time.Duration
+}
+
+func (o *idempotencyCacheOption) applyToHandler(config *handlerConfig) {
+	config.IdempotencyStore = o.store
+	config.IdempotencyTTL = o.ttl
+}
+
+type idempotencyScopeOption struct {
+	scope func(context.Context, AnyRequest) (string, error)
+}
+
+func (o *idempotencyScopeOption) applyToHandler(config *handlerConfig) {
+	config.IdempotencyScope = o.scope
+}
+
+type maxHeaderBytesOption struct {
+	max int
+}
+
+func (o *maxHeaderBytesOption) applyToHandler(config *handlerConfig) {
+	config.MaxHeaderBytes = o.max
+}
+
+type prioritySchedulerOption struct {
+	scheduler *PriorityScheduler
+}
+
+func (o *prioritySchedulerOption) applyToHandler(config *handlerConfig) {
+	config.PriorityScheduler = o.scheduler
+}
+
+type bandwidthLimiterOption struct {
+	limiter *BandwidthLimiter
+}
+
+func (o *bandwidthLimiterOption) applyToHandler(config *handlerConfig) {
+	config.BandwidthLimiter = o.limiter
+}
+
+type streamBandwidthLimitOption struct {
+	bytesPerSecond int64
+}
+
+func (o *streamBandwidthLimitOption) applyToHandler(config *handlerConfig) {
+	config.StreamBandwidth = o.bytesPerSecond
+}
+
+type contextValuesOption struct {
+	inject func(context.Context, Spec) context.Context
+}
+
+func (o *contextValuesOption) applyToHandler(config *handlerConfig) {
+	if o.inject == nil {
+		return
+	}
+	previous := config.ContextValues
+	if previous == nil {
+		config.ContextValues = o.inject
+		return
+	}
+	config.ContextValues = func(ctx context.Context, spec Spec) context.Context {
+		return o.inject(previous(ctx, spec), spec)
+	}
+}
+
+type onFinishOption struct {
+	callback func(context.Context, Spec, error, Stats)
+}
+
+func (o *onFinishOption) applyToHandler(config *handlerConfig) {
+	config.OnFinish = o.callback
+}
+
+type idempotencyOption struct {
+	level IdempotencyLevel
+}
+
+func (o *idempotencyOption) applyToClient(config *clientConfig) {
+	config.IdempotencyLevel = o.level
+}
+
+func (o *idempotencyOption) applyToHandler(config *handlerConfig) {
+	config.IdempotencyLevel = o.level
+}
+
 type interceptorsOption struct {
 	Interceptors []Interceptor
 }
@@ -324,6 +1133,28 @@ func (o *interceptorsOption) chainWith(current Interceptor) Interceptor {
 	return newChain(append([]Interceptor{current}, o.Interceptors...))
 }
 
+type lazyUnmarshalOption struct{}
+
+func (o *lazyUnmarshalOption) applyToClient(config *clientConfig) {
+	config.LazyUnmarshal = true
+}
+
+func (o *lazyUnmarshalOption) applyToHandler(config *handlerConfig) {
+	config.LazyUnmarshal = true
+}
+
+type messageTransformerOption struct {
+	transformer *messageTransformer
+}
+
+func (o *messageTransformerOption) applyToClient(config *clientConfig) {
+	config.MessageTransformer = o.transformer
+}
+
+func (o *messageTransformerOption) applyToHandler(config *handlerConfig) {
+	config.MessageTransformer = o.transformer
+}
+
 type optionsOption struct {
 	options []Option
 }
@@ -348,14 +1179,28 @@ func (o *sendCompressionOption) applyToClient(config *clientConfig) {
 	config.RequestCompressionName = o.Name
 }
 
-func withGzip() Option {
-	return &compressionOption{
-		Name: compressionGzip,
-		CompressionPool: newCompressionPool(
-			func() Decompressor { return &gzip.Reader{} },
-			func() Compressor { return gzip.NewWriter(ioutil.Discard) },
-		),
+type slowRequestLogOption struct {
+	threshold time.Duration
+	logger    func(context.Context, string)
+}
+
+func (o *slowRequestLogOption) applyToClient(config *clientConfig) {
+	config.SlowRequestLog = o.slowRequestLog()
+}
+
+func (o *slowRequestLogOption) applyToHandler(config *handlerConfig) {
+	config.SlowRequestLog = o.slowRequestLog()
+}
+
+func (o *slowRequestLogOption) slowRequestLog() *slowRequestLog {
+	if o.threshold <= 0 || o.logger == nil {
+		return nil
 	}
+	return &slowRequestLog{threshold: o.threshold, logger: o.logger}
+}
+
+func withGzip() Option {
+	return WithGzipLevel(gzip.DefaultCompression)
 }
 
 func withProtoBinaryCodec() Option {
@@ -365,3 +1210,43 @@ func withProtoBinaryCodec() Option {
 func withProtoJSONCodec() HandlerOption {
 	return WithCodec(&protoJSONCodec{})
 }
+
+type typeRegistryOption struct {
+	types *protoregistry.Types
+}
+
+func (o *typeRegistryOption) applyToClient(config *clientConfig) {
+	config.Types = o.types
+}
+
+func (o *typeRegistryOption) applyToHandler(config *handlerConfig) {
+	config.Types = o.types
+}
+
+type httpBodyOption struct{}
+
+func (o *httpBodyOption) applyToHandler(config *handlerConfig) {
+	config.HTTPBody = true
+}
+
+type validationOption struct{}
+
+func (o *validationOption) applyToClient(config *clientConfig) {
+	config.Validation = true
+}
+
+func (o *validationOption) applyToHandler(config *handlerConfig) {
+	config.Validation = true
+}
+
+type validatorOption struct {
+	validator Validator
+}
+
+func (o *validatorOption) applyToClient(config *clientConfig) {
+	config.Validator = o.validator
+}
+
+func (o *validatorOption) applyToHandler(config *handlerConfig) {
+	config.Validator = o.validator
+}
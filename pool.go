@@ -0,0 +1,457 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ConnPoolConfig configures a ConnPool. Zero values use the defaults of
+// net/http's DefaultTransport.
+type ConnPoolConfig struct {
+	// MaxIdleConnsPerHost limits the number of idle connections kept open to
+	// each target. Since HTTP/2 multiplexes streams over a single connection,
+	// HTTP/2 targets rarely need more than one.
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout is how long an idle connection stays in the pool before
+	// it's closed.
+	IdleConnTimeout time.Duration
+	// TLSClientConfig configures TLS for https:// targets.
+	TLSClientConfig *tls.Config
+	// Clock timestamps connections when they're opened. It defaults to the
+	// system clock.
+	Clock Clock
+}
+
+// ConnPool is an HTTPClient with a tunable connection pool. It tracks the
+// connections it opens and the streams in flight on each, so operators can
+// tune HTTP/2 connection reuse and detect leaked streams. Like net/http's
+// DefaultTransport, it uses HTTP/2 for https:// targets when the server
+// supports it.
+//
+// The pool also tracks the connectivity state of each target it connects to;
+// see State, WaitForStateChange, and OnStateChange.
+//
+// A ConnPool is safe to share across goroutines and clients, and it should be
+// reused rather than created per call.
+type ConnPool struct {
+	client    *http.Client
+	transport *http.Transport
+	clock     Clock
+
+	mu          sync.Mutex
+	conns       map[string]*pooledConn  // by local and remote address
+	targets     map[string]*targetState // by host and port
+	subscribers map[int]func(string, ConnectivityState)
+	nextID      int
+}
+
+// NewConnPool constructs a ConnPool.
+func NewConnPool(config ConnPoolConfig) *ConnPool {
+	pool := &ConnPool{
+		clock:       config.Clock,
+		conns:       make(map[string]*pooledConn),
+		targets:     make(map[string]*targetState),
+		subscribers: make(map[int]func(string, ConnectivityState)),
+	}
+	if pool.clock == nil {
+		pool.clock = systemClock{}
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	// Mirror net/http's DefaultTransport.
+	pool.transport = &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			return pool.track(conn), nil
+		},
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	if config.MaxIdleConnsPerHost > 0 {
+		pool.transport.MaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+	}
+	if config.IdleConnTimeout > 0 {
+		pool.transport.IdleConnTimeout = config.IdleConnTimeout
+	}
+	if config.TLSClientConfig != nil {
+		pool.transport.TLSClientConfig = config.TLSClientConfig.Clone()
+	}
+	pool.client = &http.Client{Transport: pool.transport}
+	return pool
+}
+
+// Do implements HTTPClient.
+func (p *ConnPool) Do(request *http.Request) (*http.Response, error) {
+	stream := &pooledStream{pool: p, target: targetOf(request.URL)}
+	trace := &httptrace.ClientTrace{
+		ConnectStart: func(string, string) { p.connecting(stream.target) },
+		GotConn:      stream.gotConn,
+	}
+	request = request.WithContext(httptrace.WithClientTrace(request.Context(), trace))
+	response, err := p.client.Do(request)
+	if err != nil {
+		stream.release()
+		if request.Context().Err() == nil {
+			p.failed(stream.target)
+		}
+		return nil, err
+	}
+	response.Body = &pooledStreamBody{ReadCloser: response.Body, stream: stream}
+	return response, nil
+}
+
+// CloseIdleConnections closes any connections that aren't carrying streams.
+func (p *ConnPool) CloseIdleConnections() {
+	p.transport.CloseIdleConnections()
+}
+
+// Stats returns a snapshot of the pool's connections.
+func (p *ConnPool) Stats() ConnPoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := ConnPoolStats{Conns: make([]ConnStats, 0, len(p.conns))}
+	for _, conn := range p.conns {
+		stats.OpenConns++
+		stats.ActiveStreams += conn.streams
+		stats.Conns = append(stats.Conns, ConnStats{
+			RemoteAddr:    conn.RemoteAddr().String(),
+			ActiveStreams: conn.streams,
+			Opened:        conn.opened,
+		})
+	}
+	sort.Slice(stats.Conns, func(i, j int) bool {
+		return stats.Conns[i].Opened.Before(stats.Conns[j].Opened)
+	})
+	return stats
+}
+
+// ConnectivityState describes a ConnPool's connections to a target, using the
+// same states as gRPC's connectivity semantics.
+type ConnectivityState int
+
+const (
+	// ConnectivityIdle means the pool has no connections to the target and
+	// isn't trying to open one. Targets the pool has never connected to are
+	// idle.
+	ConnectivityIdle ConnectivityState = iota
+	// ConnectivityConnecting means the pool is opening a connection to the
+	// target.
+	ConnectivityConnecting
+	// ConnectivityReady means the pool has at least one open connection to the
+	// target.
+	ConnectivityReady
+	// ConnectivityTransientFailure means the last attempt to reach the target
+	// failed. The pool tries again on the next call.
+	ConnectivityTransientFailure
+)
+
+func (s ConnectivityState) String() string {
+	switch s {
+	case ConnectivityIdle:
+		return "idle"
+	case ConnectivityConnecting:
+		return "connecting"
+	case ConnectivityReady:
+		return "ready"
+	case ConnectivityTransientFailure:
+		return "transient_failure"
+	}
+	return fmt.Sprintf("connectivity_state_%d", int(s))
+}
+
+// State returns the connectivity state of a target, identified by host and
+// port (for example, "acme.com:443").
+func (p *ConnPool) State(target string) ConnectivityState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if state, ok := p.targets[target]; ok {
+		return state.state
+	}
+	return ConnectivityIdle
+}
+
+// WaitForStateChange blocks until the target's state differs from source or
+// the context ends. It reports whether the state changed.
+func (p *ConnPool) WaitForStateChange(ctx context.Context, target string, source ConnectivityState) bool {
+	p.mu.Lock()
+	state := p.target(target)
+	state.waiters++
+	defer func() {
+		p.mu.Lock()
+		state.waiters--
+		p.prune(target, state)
+		p.mu.Unlock()
+	}()
+	for {
+		current, changed := state.state, state.changed
+		p.mu.Unlock()
+		if current != source {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return false
+		}
+		p.mu.Lock()
+	}
+}
+
+// OnStateChange registers a callback that's called whenever any target's
+// state changes. Callbacks run synchronously on the goroutine that caused the
+// change, so they should return quickly. Call the returned function to
+// unregister the callback.
+func (p *ConnPool) OnStateChange(callback func(target string, state ConnectivityState)) (unregister func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	id := p.nextID
+	p.nextID++
+	p.subscribers[id] = callback
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		delete(p.subscribers, id)
+	}
+}
+
+// targetState tracks a target's connectivity. It's guarded by the pool's
+// mutex.
+type targetState struct {
+	state   ConnectivityState
+	conns   int
+	waiters int           // calls to WaitForStateChange
+	changed chan struct{} // closed and replaced on every change
+}
+
+// target returns the state of a target, creating it if necessary. The caller
+// must hold p.mu.
+func (p *ConnPool) target(target string) *targetState {
+	state, ok := p.targets[target]
+	if !ok {
+		state = &targetState{changed: make(chan struct{})}
+		p.targets[target] = state
+	}
+	return state
+}
+
+// prune forgets a target once it's idle and nobody is waiting for it to
+// change, since untracked targets are idle anyway. The caller must hold p.mu.
+func (p *ConnPool) prune(target string, state *targetState) {
+	if state.state == ConnectivityIdle && state.conns == 0 && state.waiters == 0 && p.targets[target] == state {
+		delete(p.targets, target)
+	}
+}
+
+// setState changes a target's state, then notifies subscribers. The caller
+// must hold p.mu, which setState releases.
+func (p *ConnPool) setState(target string, state *targetState, next ConnectivityState) {
+	if state.state == next {
+		p.mu.Unlock()
+		return
+	}
+	state.state = next
+	close(state.changed)
+	state.changed = make(chan struct{})
+	p.prune(target, state)
+	callbacks := make([]func(string, ConnectivityState), 0, len(p.subscribers))
+	for _, callback := range p.subscribers {
+		callbacks = append(callbacks, callback)
+	}
+	p.mu.Unlock()
+	for _, callback := range callbacks {
+		callback(target, next)
+	}
+}
+
+func (p *ConnPool) connecting(target string) {
+	p.mu.Lock()
+	state := p.target(target)
+	if state.conns > 0 {
+		// Opening an extra connection to a ready target.
+		p.mu.Unlock()
+		return
+	}
+	p.setState(target, state, ConnectivityConnecting)
+}
+
+func (p *ConnPool) failed(target string) {
+	p.mu.Lock()
+	state := p.target(target)
+	if state.conns > 0 {
+		// Other connections to the target still work.
+		p.mu.Unlock()
+		return
+	}
+	p.setState(target, state, ConnectivityTransientFailure)
+}
+
+// targetOf identifies a request's target by host and port.
+func targetOf(requestURL *url.URL) string {
+	if requestURL.Port() != "" {
+		return requestURL.Host
+	}
+	port := "80"
+	if requestURL.Scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(requestURL.Hostname(), port)
+}
+
+// ConnPoolStats is a snapshot of a ConnPool's connections.
+type ConnPoolStats struct {
+	OpenConns     int
+	ActiveStreams int
+	// Conns describes each open connection, oldest first.
+	Conns []ConnStats
+}
+
+// ConnStats describes one of a ConnPool's connections. A connection with no
+// active streams is idle.
+type ConnStats struct {
+	RemoteAddr    string
+	ActiveStreams int
+	Opened        time.Time
+}
+
+func (p *ConnPool) track(conn net.Conn) net.Conn {
+	pooled := &pooledConn{Conn: conn, pool: p, opened: p.clock.Now()}
+	p.mu.Lock()
+	p.conns[connKey(conn)] = pooled
+	p.mu.Unlock()
+	return pooled
+}
+
+// lookup finds the tracked connection underlying conn, which net/http may
+// have wrapped (for example, in a *tls.Conn).
+func (p *ConnPool) lookup(conn net.Conn) *pooledConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.conns[connKey(conn)]
+}
+
+func connKey(conn net.Conn) string {
+	return conn.LocalAddr().String() + " " + conn.RemoteAddr().String()
+}
+
+// pooledConn is a connection opened by a ConnPool.
+type pooledConn struct {
+	net.Conn
+
+	pool    *ConnPool
+	opened  time.Time
+	streams int    // guarded by pool.mu
+	target  string // guarded by pool.mu, set by the first stream
+	once    sync.Once
+}
+
+func (c *pooledConn) Close() error {
+	c.once.Do(func() {
+		c.pool.mu.Lock()
+		delete(c.pool.conns, connKey(c.Conn))
+		if c.target == "" {
+			c.pool.mu.Unlock()
+			return
+		}
+		state := c.pool.target(c.target)
+		state.conns--
+		if state.conns > 0 || state.state != ConnectivityReady {
+			c.pool.mu.Unlock()
+			return
+		}
+		c.pool.setState(c.target, state, ConnectivityIdle)
+	})
+	return c.Conn.Close()
+}
+
+// pooledStream counts a request as an active stream on its connection until
+// the response body is closed or exhausted.
+type pooledStream struct {
+	pool   *ConnPool
+	target string
+
+	mu   sync.Mutex
+	conn *pooledConn
+}
+
+func (s *pooledStream) gotConn(info httptrace.GotConnInfo) {
+	conn := s.pool.lookup(info.Conn)
+	if conn == nil {
+		return
+	}
+	// net/http may retry requests on a new connection, so release any
+	// previous one.
+	s.release()
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+	s.pool.mu.Lock()
+	conn.streams++
+	state := s.pool.target(s.target)
+	if conn.target == "" {
+		conn.target = s.target
+		state.conns++
+	}
+	s.pool.setState(s.target, state, ConnectivityReady)
+}
+
+func (s *pooledStream) release() {
+	s.mu.Lock()
+	conn := s.conn
+	s.conn = nil
+	s.mu.Unlock()
+	if conn == nil {
+		return
+	}
+	s.pool.mu.Lock()
+	conn.streams--
+	s.pool.mu.Unlock()
+}
+
+type pooledStreamBody struct {
+	io.ReadCloser
+
+	stream *pooledStream
+}
+
+func (b *pooledStreamBody) Read(data []byte) (int, error) {
+	n, err := b.ReadCloser.Read(data)
+	if err != nil {
+		b.stream.release()
+	}
+	return n, err
+}
+
+func (b *pooledStreamBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.stream.release()
+	return err
+}
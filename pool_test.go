@@ -0,0 +1,61 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"net"
+	"net/http/httptrace"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go/internal/assert"
+)
+
+func TestConnPoolPrunesTargets(t *testing.T) {
+	t.Parallel()
+	const target = "acme.com:443"
+	pool := NewConnPool(ConnPoolConfig{})
+	client, server := net.Pipe()
+	t.Cleanup(func() { _ = server.Close() })
+	conn, ok := pool.track(client).(*pooledConn)
+	assert.True(t, ok)
+	stream := &pooledStream{pool: pool, target: target}
+	pool.connecting(target)
+	stream.gotConn(httptrace.GotConnInfo{Conn: conn})
+	stream.release()
+	assert.Equal(t, pool.State(target), ConnectivityReady)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	changed := make(chan bool, 1)
+	go func() {
+		changed <- pool.WaitForStateChange(ctx, target, ConnectivityReady)
+	}()
+	assert.Nil(t, conn.Close())
+	assert.True(t, <-changed)
+	assert.Equal(t, pool.State(target), ConnectivityIdle)
+	pool.mu.Lock()
+	assert.Equal(t, len(pool.targets), 0)
+	pool.mu.Unlock()
+
+	// Waiting on an unknown target doesn't leave it behind either.
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, pool.WaitForStateChange(canceled, "unknown.com:443", ConnectivityIdle))
+	pool.mu.Lock()
+	assert.Equal(t, len(pool.targets), 0)
+	pool.mu.Unlock()
+}
@@ -0,0 +1,58 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Preflight checks that a server is reachable at a procedure's URL (for
+// example, https://acme.com/acme.foo.v1.FooService/Bar), so that
+// misconfigured URLs, DNS, and TLS fail when a program starts rather than on
+// its first real call. It sends a bodyless GET request, which establishes a
+// connection (completing any TLS and HTTP/2 handshakes) without invoking the
+// procedure: the Connect, gRPC, and gRPC-Web protocols all require POST, so
+// connect handlers answer with 405 Method Not Allowed.
+//
+// Preflight returns an error with CodeUnavailable if the server can't be
+// reached or responds with a 5xx status, and CodeNotFound if nothing is
+// served at the URL. Any other response counts as success.
+func Preflight(ctx context.Context, httpClient HTTPClient, url string) error {
+	if err := validateRequestURL(url); err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return errorf(CodeInvalidArgument, "construct preflight request: %w", err)
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return wrapIfContextError(ctxErr)
+		}
+		return errorf(CodeUnavailable, "preflight %s: %w", url, err)
+	}
+	_ = discard(response.Body)
+	_ = response.Body.Close()
+	switch {
+	case response.StatusCode == http.StatusNotFound:
+		return newHTTPStatusError(CodeNotFound, response, fmt.Errorf("preflight %s: no procedure found", url))
+	case response.StatusCode >= http.StatusInternalServerError:
+		return newHTTPStatusError(CodeUnavailable, response, fmt.Errorf("preflight %s: HTTP status %v", url, response.Status))
+	}
+	return nil
+}
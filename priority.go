@@ -0,0 +1,248 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const headerPriority = "Connect-Priority"
+
+// Priority classifies requests for a PriorityScheduler. Clients choose a
+// request's priority by setting the Connect-Priority header to "low",
+// "normal", "high", or "critical". Requests without the header, or with an
+// unrecognized value, have PriorityNormal.
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+	PriorityCritical
+
+	numPriorities = int(PriorityCritical) + 1
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	case PriorityCritical:
+		return "critical"
+	default:
+		return fmt.Sprintf("priority_%d", int(p))
+	}
+}
+
+// priorityFromHeader parses the request's Connect-Priority header.
+func priorityFromHeader(header http.Header) Priority {
+	value := strings.ToLower(strings.TrimSpace(header.Get(headerPriority)))
+	for priority := PriorityLow; priority <= PriorityCritical; priority++ {
+		if value == priority.String() {
+			return priority
+		}
+	}
+	return PriorityNormal
+}
+
+// PrioritySchedulerConfig configures a PriorityScheduler.
+type PrioritySchedulerConfig struct {
+	// MaxConcurrent limits the number of requests served at once. Additional
+	// requests wait in a queue. If MaxConcurrent isn't positive, requests never
+	// wait.
+	MaxConcurrent int
+	// MaxQueued limits the number of waiting requests. When the queue is full, a
+	// new request evicts the most recently queued request of the lowest
+	// priority below its own; if there isn't one, the new request is rejected.
+	// Zero means no limit.
+	MaxQueued int
+	// Weights sets each Priority's share of the slots that free up while
+	// requests are waiting. Missing or non-positive weights use the defaults:
+	// 8 for PriorityCritical, 4 for PriorityHigh, 2 for PriorityNormal, and 1
+	// for PriorityLow.
+	Weights map[Priority]int
+}
+
+// PriorityScheduler limits the number of requests handlers serve
+// concurrently and, during overload, decides which waiting requests to serve
+// next based on their Priority. Waiting requests are served in weighted
+// round-robin order, so with the default weights critical requests get eight
+// times as many slots as low-priority requests, but low-priority requests
+// still make progress. This keeps health checks and control-plane calls
+// responsive while data-plane traffic floods the server.
+//
+// Requests wait until the handler's context ends, so clients should set
+// timeouts. Evicted and rejected requests fail with CodeResourceExhausted.
+//
+// Share a single PriorityScheduler between handlers with
+// WithPriorityScheduler.
+type PriorityScheduler struct {
+	maxConcurrent int
+	maxQueued     int
+	weights       [numPriorities]int
+
+	mu      sync.Mutex
+	active  int
+	queued  int
+	queues  [numPriorities][]*priorityWaiter
+	current [numPriorities]int // smooth weighted round-robin state
+}
+
+type priorityWaiter struct {
+	ready chan struct{} // closed once the waiter is granted a slot or evicted
+	err   error         // set before ready is closed if the waiter was evicted
+}
+
+// NewPriorityScheduler constructs a PriorityScheduler.
+func NewPriorityScheduler(config PrioritySchedulerConfig) *PriorityScheduler {
+	scheduler := &PriorityScheduler{
+		maxConcurrent: config.MaxConcurrent,
+		maxQueued:     config.MaxQueued,
+		// From PriorityLow to PriorityCritical.
+		weights: [numPriorities]int{1, 2, 4, 8},
+	}
+	for priority, weight := range config.Weights {
+		if priority >= PriorityLow && priority <= PriorityCritical && weight > 0 {
+			scheduler.weights[priority] = weight
+		}
+	}
+	return scheduler
+}
+
+// Queued returns the number of requests waiting to be served.
+func (s *PriorityScheduler) Queued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queued
+}
+
+// acquire waits for a slot to serve a request. On success, the caller must
+// call release once it's done with the slot.
+func (s *PriorityScheduler) acquire(ctx context.Context, priority Priority) (func(), error) {
+	if priority < PriorityLow || priority > PriorityCritical {
+		priority = PriorityNormal
+	}
+	s.mu.Lock()
+	if s.maxConcurrent <= 0 || (s.active < s.maxConcurrent && s.queued == 0) {
+		s.active++
+		s.mu.Unlock()
+		return s.release, nil
+	}
+	if s.maxQueued > 0 && s.queued >= s.maxQueued && !s.evictBelow(priority) {
+		s.mu.Unlock()
+		return nil, errorf(CodeResourceExhausted, "server overloaded: queue full for %v priority requests", priority)
+	}
+	waiter := &priorityWaiter{ready: make(chan struct{})}
+	s.queues[priority] = append(s.queues[priority], waiter)
+	s.queued++
+	s.mu.Unlock()
+
+	select {
+	case <-waiter.ready:
+		if waiter.err != nil {
+			return nil, waiter.err
+		}
+		return s.release, nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		removed := s.remove(priority, waiter)
+		s.mu.Unlock()
+		if !removed && waiter.err == nil {
+			// We were granted a slot just as the context ended, so pass it on.
+			s.release()
+		}
+		return nil, wrapIfContextError(ctx.Err())
+	}
+}
+
+// release hands the caller's slot to the next waiting request, if any.
+func (s *PriorityScheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if waiter := s.next(); waiter != nil {
+		close(waiter.ready)
+		return
+	}
+	s.active--
+}
+
+// next dequeues the next request to serve, using smooth weighted round-robin
+// across the non-empty queues. It must be called with the mutex held.
+func (s *PriorityScheduler) next() *priorityWaiter {
+	best, total := -1, 0
+	// Iterate from the highest priority down, so ties favor higher priorities.
+	for priority := numPriorities - 1; priority >= 0; priority-- {
+		if len(s.queues[priority]) == 0 {
+			s.current[priority] = 0
+			continue
+		}
+		s.current[priority] += s.weights[priority]
+		total += s.weights[priority]
+		if best < 0 || s.current[priority] > s.current[best] {
+			best = priority
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+	s.current[best] -= total
+	waiter := s.queues[best][0]
+	s.queues[best][0] = nil
+	s.queues[best] = s.queues[best][1:]
+	s.queued--
+	return waiter
+}
+
+// evictBelow evicts the most recently queued request with the lowest
+// priority below the given one, reporting whether it found one. It must be
+// called with the mutex held.
+func (s *PriorityScheduler) evictBelow(priority Priority) bool {
+	for lower := PriorityLow; lower < priority; lower++ {
+		queue := s.queues[lower]
+		if len(queue) == 0 {
+			continue
+		}
+		waiter := queue[len(queue)-1]
+		queue[len(queue)-1] = nil
+		s.queues[lower] = queue[:len(queue)-1]
+		s.queued--
+		waiter.err = errorf(CodeResourceExhausted, "server overloaded: evicted by a higher priority request")
+		close(waiter.ready)
+		return true
+	}
+	return false
+}
+
+// remove dequeues a waiter, reporting whether it was still queued. It must
+// be called with the mutex held.
+func (s *PriorityScheduler) remove(priority Priority, waiter *priorityWaiter) bool {
+	queue := s.queues[priority]
+	for i, candidate := range queue {
+		if candidate == waiter {
+			s.queues[priority] = append(queue[:i], queue[i+1:]...)
+			s.queued--
+			return true
+		}
+	}
+	return false
+}
@@ -23,6 +23,8 @@ import (
 	"net/url"
 	"sort"
 	"strings"
+
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
 const (
@@ -32,6 +34,13 @@ const (
 	discardLimit = 1024 * 1024 * 4 // 4MiB
 )
 
+// The names of the supported protocols, for use with WithProtocol.
+const (
+	ProtocolConnect = "connect"
+	ProtocolGRPC    = "grpc"
+	ProtocolGRPCWeb = "grpcweb"
+)
+
 var errNoTimeout = errors.New("no timeout")
 
 // A Protocol defines the HTTP semantics to use when sending and receiving
@@ -63,8 +72,16 @@ type protocolHandlerParams struct {
 	Spec             Spec
 	Codecs           readOnlyCodecs
 	CompressionPools readOnlyCompressionPools
-	CompressMinBytes int
-	BufferPool       *bufferPool
+	// ResponseCompressionPools is the subset of CompressionPools that may be
+	// used to compress responses.
+	ResponseCompressionPools readOnlyCompressionPools
+	CompressMinBytes         int
+	CompressionSelector      func(procedure string, acceptEncoding []string) string
+	BufferPool               *bufferPool
+	Clock                    Clock
+	Checksums                bool
+	RequireCompression       bool
+	Types                    *protoregistry.Types // may be nil
 }
 
 // Handler is the server side of a protocol. HTTP handlers typically support
@@ -74,6 +91,13 @@ type protocolHandler interface {
 	// handle.
 	ContentTypes() map[string]struct{}
 
+	// Protocol returns the protocol's name, like ProtocolConnect.
+	Protocol() string
+
+	// CodecName returns the name of the codec requested by one of the
+	// protocol's Content-Types.
+	CodecName(contentType string) string
+
 	// SetTimeout runs before NewStream. Implementations may inspect the HTTP
 	// request, parse any timeout set by the client, and return a modified
 	// context and cancellation function.
@@ -106,14 +130,33 @@ type protocolClientParams struct {
 	CompressionPools readOnlyCompressionPools
 	Codec            Codec
 	CompressMinBytes int
+	SendMaxBytes     int // zero means no limit
+	ReadMaxBytes     int // zero means no limit
 	HTTPClient       HTTPClient
 	URL              string
 	BufferPool       *bufferPool
+	Clock            Clock
+	Checksums        bool
+	UserAgent        string               // appended to the protocol's default
+	Types            *protoregistry.Types // may be nil
+	// Only set for gRPC clients with a fallback protocol, which need to detect
+	// responses from servers and proxies that don't speak HTTP/2.
+	DetectHTTP2Unavailable bool
 	// The gRPC family of protocols always needs access to a Protobuf codec to
 	// marshal and unmarshal errors.
 	Protobuf Codec
 }
 
+// appendUserAgent adds the application's product tokens, if any, to a
+// protocol's default User-Agent.
+func appendUserAgent(base, application string) string {
+	application = strings.TrimSpace(application)
+	if application == "" {
+		return base
+	}
+	return base + " " + application
+}
+
 // Client is the client side of a protocol. HTTP clients typically use a single
 // protocol, codec, and compressor to send requests.
 type protocolClient interface {
@@ -144,11 +187,97 @@ func (s *errorTranslatingSender) Send(msg any) error {
 	return s.fromWire(s.Sender.Send(msg))
 }
 
+func (s *errorTranslatingSender) sendBatch(messages []any) error {
+	return s.fromWire(sendBatch(s.Sender, messages))
+}
+
+func (s *errorTranslatingSender) sendUncompressed(msg any) error {
+	return s.fromWire(sendUncompressed(s.Sender, msg))
+}
+
 func (s *errorTranslatingSender) Close(err error) error {
 	sendErr := s.Sender.Close(s.toWire(err))
 	return s.fromWire(sendErr)
 }
 
+// batchSender is implemented by Senders that can write many messages more
+// efficiently than repeated calls to Send, usually by coalescing writes and
+// flushing the network connection once.
+type batchSender interface {
+	sendBatch([]any) error
+}
+
+// sendBatch sends all the messages, using the Sender's batch implementation if
+// it has one. Senders wrapped by interceptors fall back to calling Send once
+// per message.
+func sendBatch(sender Sender, messages []any) error {
+	if batcher, ok := sender.(batchSender); ok {
+		return batcher.sendBatch(messages)
+	}
+	for _, message := range messages {
+		if err := sender.Send(message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type uncompressedSender interface {
+	sendUncompressed(any) error
+}
+
+// sendUncompressed sends a message without compressing it, if the Sender
+// supports it. Senders wrapped by interceptors, and unary Senders, fall back
+// to calling Send.
+func sendUncompressed(sender Sender, message any) error {
+	if uncompressed, ok := sender.(uncompressedSender); ok {
+		return uncompressed.sendUncompressed(message)
+	}
+	return sender.Send(message)
+}
+
+// readAheadReceiver is implemented by Receivers that can buffer reads from the
+// network, so that a single read fetches several small messages. Like
+// batchSender, it's forwarded by connect's own wrappers but hidden by
+// interceptors.
+type readAheadReceiver interface {
+	readAhead()
+}
+
+// readAhead enables buffered reads, if the Receiver supports them.
+func readAhead(receiver Receiver) {
+	if reader, ok := receiver.(readAheadReceiver); ok {
+		reader.readAhead()
+	}
+}
+
+// receiveBatch receives up to limit messages of type T. Once a stream has
+// received a batch, its reads are buffered, so each read from the network may
+// return several messages.
+func receiveBatch[T any](receiver Receiver, limit int) ([]*T, error) {
+	if limit < 1 {
+		return nil, nil
+	}
+	readAhead(receiver)
+	batch := make([]*T, 0, limit)
+	for len(batch) < limit {
+		var msg T
+		if err := receiver.Receive(&msg); err != nil {
+			return batch, err
+		}
+		batch = append(batch, &msg)
+	}
+	return batch, nil
+}
+
+func anySlice[T any](messages []*T) []any {
+	untyped := make([]any, len(messages))
+	for i, message := range messages {
+		untyped[i] = message
+	}
+	return untyped
+}
+
 // errorTranslatingReceiver wraps a Receiver to make sure that we always return
 // coded errors from clients.
 //
@@ -166,22 +295,30 @@ func (r *errorTranslatingReceiver) Receive(msg any) error {
 	return nil
 }
 
+func (r *errorTranslatingReceiver) readAhead() {
+	readAhead(r.Receiver)
+}
+
 func (r *errorTranslatingReceiver) Close() error {
 	return r.fromWire(r.Receiver.Close())
 }
 
 // wrapHandlerStreamWithCodedErrors ensures that we (1) automatically code
-// context-related errors correctly when writing them to the network, and (2)
-// return *Errors from all exported APIs.
-func wrapHandlerStreamWithCodedErrors(sender Sender, receiver Receiver) (Sender, Receiver) {
+// context-related errors correctly when writing them to the network, (2) code
+// errors caused by client disconnects and timeouts as CodeCanceled or
+// CodeDeadlineExceeded, and (3) return *Errors from all exported APIs.
+func wrapHandlerStreamWithCodedErrors(ctx context.Context, sender Sender, receiver Receiver) (Sender, Receiver) {
+	fromWire := func(err error) error {
+		return wrapIfUncoded(wrapIfContextDone(ctx, err))
+	}
 	wrappedSender := &errorTranslatingSender{
 		Sender:   sender,
 		toWire:   wrapIfContextError,
-		fromWire: wrapIfUncoded,
+		fromWire: fromWire,
 	}
 	wrappedReceiver := &errorTranslatingReceiver{
 		Receiver: receiver,
-		fromWire: wrapIfUncoded,
+		fromWire: fromWire,
 	}
 	return wrappedSender, wrappedReceiver
 }
@@ -216,10 +353,34 @@ func sortedAcceptPostValue(handlers []protocolHandler) string {
 	return strings.Join(accept, ", ")
 }
 
+// canonicalizeContentType strips any parameters from a Content-Type and
+// lowercases the media type, so "Application/JSON; charset=utf-8" becomes
+// "application/json".
+func canonicalizeContentType(contentType string) string {
+	// Typically, clients send Content-Type in canonical form, without
+	// parameters. In those cases, avoid allocating.
+	if !strings.ContainsAny(contentType, "; ABCDEFGHIJKLMNOPQRSTUVWXYZ") {
+		return contentType
+	}
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
 func isCommaOrSpace(c rune) bool {
 	return c == ',' || c == ' '
 }
 
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
+
 func discard(reader io.Reader) error {
 	if lr, ok := reader.(*io.LimitedReader); ok {
 		_, err := io.Copy(io.Discard, lr)
@@ -250,12 +411,22 @@ func validateRequestURL(uri string) *Error {
 
 // negotiateCompression determines and validates the request compression and
 // response compression using the available compressors and protocol-specific
-// Content-Encoding and Accept-Encoding headers.
+// Content-Encoding and Accept-Encoding headers. Responses are only compressed
+// with responseCompressors.
 func negotiateCompression( // nolint:nonamedreturns
 	availableCompressors readOnlyCompressionPools,
+	responseCompressors readOnlyCompressionPools,
+	requireCompression bool,
 	sent, accept string,
 ) (requestCompression, responseCompression string, clientVisibleErr *Error) {
 	requestCompression = compressionIdentity
+	if requireCompression && (sent == "" || sent == compressionIdentity) {
+		return "", "", errorf(
+			CodeUnimplemented,
+			"uncompressed requests aren't allowed: supported encodings are %v",
+			availableCompressors.CommaSeparatedNames(),
+		)
+	}
 	if sent != "" && sent != compressionIdentity {
 		// We default to identity, so we only care if the client sends something
 		// other than the empty string or compressIdentity.
@@ -276,13 +447,20 @@ func negotiateCompression( // nolint:nonamedreturns
 	}
 	// Support asymmetric compression. This logic follows
 	// https://github.com/grpc/grpc/blob/master/doc/compression.md and common
-	// sense.
-	responseCompression = requestCompression
+	// sense: by default, responses use the request's compression, unless the
+	// client's Accept-Encoding leaves it out.
+	responseCompression = compressionIdentity
+	acceptEncoding := strings.FieldsFunc(accept, isCommaOrSpace)
+	if requestCompression != compressionIdentity &&
+		responseCompressors.Contains(requestCompression) &&
+		(len(acceptEncoding) == 0 || containsString(acceptEncoding, requestCompression)) {
+		responseCompression = requestCompression
+	}
 	// If we're not already planning to compress the response, check whether the
 	// client requested a compression algorithm we support.
-	if responseCompression == compressionIdentity && accept != "" {
-		for _, name := range strings.FieldsFunc(accept, isCommaOrSpace) {
-			if availableCompressors.Contains(name) {
+	if responseCompression == compressionIdentity {
+		for _, name := range acceptEncoding {
+			if responseCompressors.Contains(name) {
 				// We found a mutually supported compression algorithm. Unlike standard
 				// HTTP, there's no preference weighting, so can bail out immediately.
 				responseCompression = name
@@ -293,6 +471,39 @@ func negotiateCompression( // nolint:nonamedreturns
 	return requestCompression, responseCompression, nil
 }
 
+// selectResponseCompression lets a user-supplied selector override the
+// negotiated response compression. Selections that the client didn't accept
+// or the handler doesn't support are ignored.
+func selectResponseCompression(
+	selector func(string, []string) string,
+	procedure string,
+	availableCompressors readOnlyCompressionPools,
+	requestCompression, responseCompression, accept string,
+) string {
+	if selector == nil {
+		return responseCompression
+	}
+	acceptEncoding := strings.FieldsFunc(accept, isCommaOrSpace)
+	if requestCompression != compressionIdentity && len(acceptEncoding) == 0 {
+		// Clients implicitly accept the compression they're using, unless
+		// they say otherwise.
+		acceptEncoding = append(acceptEncoding, requestCompression)
+	}
+	selected := selector(procedure, acceptEncoding)
+	if selected == "" || selected == compressionIdentity {
+		return compressionIdentity
+	}
+	if !availableCompressors.Contains(selected) {
+		return responseCompression
+	}
+	for _, name := range acceptEncoding {
+		if name == selected {
+			return selected
+		}
+	}
+	return responseCompression
+}
+
 func flushResponseWriter(w http.ResponseWriter) {
 	if f, ok := w.(http.Flusher); ok {
 		f.Flush()
@@ -28,15 +28,16 @@ import (
 	"time"
 
 	errorv1 "github.com/bufbuild/connect-go/internal/gen/connect/error/v1"
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
 const (
 	connectUnaryHeaderCompression           = "Content-Encoding"
 	connectUnaryHeaderAcceptCompression     = "Accept-Encoding"
 	connectUnaryTrailerPrefix               = "Trailer-"
-	connectStreamingHeaderCompression       = "Connect-Content-Encoding"
-	connectStreamingHeaderAcceptCompression = "Connect-Accept-Encoding"
-	connectHeaderTimeout                    = "Connect-Timeout-Ms"
+	connectStreamingHeaderCompression       = HeaderConnectContentEncoding
+	connectStreamingHeaderAcceptCompression = HeaderConnectAcceptEncoding
+	connectHeaderTimeout                    = HeaderConnectTimeout
 
 	connectFlagEnvelopeEndStream = 0b00000010
 
@@ -81,7 +82,15 @@ func (h *connectHandler) ContentTypes() map[string]struct{} {
 	return h.accept
 }
 
-func (*connectHandler) SetTimeout(request *http.Request) (context.Context, context.CancelFunc, error) {
+func (h *connectHandler) Protocol() string {
+	return ProtocolConnect
+}
+
+func (h *connectHandler) CodecName(contentType string) string {
+	return connectCodecFromContentType(h.Spec.StreamType, contentType)
+}
+
+func (h *connectHandler) SetTimeout(request *http.Request) (context.Context, context.CancelFunc, error) {
 	timeout := request.Header.Get(connectHeaderTimeout)
 	if timeout == "" {
 		return request.Context(), nil, nil
@@ -93,8 +102,9 @@ func (*connectHandler) SetTimeout(request *http.Request) (context.Context, conte
 	if err != nil {
 		return nil, nil, errorf(CodeInvalidArgument, "parse timeout: %w", err)
 	}
-	ctx, cancel := context.WithTimeout(
+	ctx, cancel := withTimeout(
 		request.Context(),
+		h.Clock,
 		time.Duration(millis)*time.Millisecond,
 	)
 	return ctx, cancel, nil
@@ -116,9 +126,21 @@ func (h *connectHandler) NewStream(
 	}
 	requestCompression, responseCompression, failed := negotiateCompression(
 		h.CompressionPools,
+		h.ResponseCompressionPools,
+		h.RequireCompression,
 		contentEncoding,
 		acceptEncoding,
 	)
+	if failed == nil {
+		responseCompression = selectResponseCompression(
+			h.CompressionSelector,
+			h.Spec.Procedure,
+			h.ResponseCompressionPools,
+			requestCompression,
+			responseCompression,
+			acceptEncoding,
+		)
+	}
 
 	// Write any remaining headers here:
 	// (1) any writes to the stream will implicitly send the headers, so we
@@ -142,15 +164,19 @@ func (h *connectHandler) NewStream(
 	}
 	header[acceptCompressionHeader] = []string{h.CompressionPools.CommaSeparatedNames()}
 
+	spec := h.Spec
+	spec.compression = Compression{Request: requestCompression, Response: responseCompression}
 	codecName := connectCodecFromContentType(
 		h.Spec.StreamType,
 		request.Header.Get(headerContentType),
 	)
 	codec := h.Codecs.Get(codecName) // handler.go guarantees this is not nil
+	checksumResponse := h.Checksums && acceptsChecksums(request.Header)
 	var sender Sender = &connectUnaryHandlerSender{
-		spec:           h.Spec,
+		spec:           spec,
 		responseWriter: responseWriter,
 		trailer:        make(http.Header),
+		types:          h.Types,
 		marshaler: connectUnaryMarshaler{
 			writer:           responseWriter,
 			codec:            codec,
@@ -159,21 +185,23 @@ func (h *connectHandler) NewStream(
 			compressionPool:  h.CompressionPools.Get(responseCompression),
 			bufferPool:       h.BufferPool,
 			header:           responseWriter.Header(),
+			checksum:         checksumResponse,
 		},
 	}
 	var receiver Receiver = &connectUnaryHandlerReceiver{
-		spec:    h.Spec,
+		spec:    spec,
 		request: request,
 		unmarshaler: connectUnaryUnmarshaler{
 			reader:          request.Body,
 			codec:           codec,
 			compressionPool: h.CompressionPools.Get(requestCompression),
 			bufferPool:      h.BufferPool,
+			checksum:        request.Header.Get(headerMessageCRC32C),
 		},
 	}
 	if h.Spec.StreamType != StreamTypeUnary {
 		sender = &connectStreamingHandlerSender{
-			spec:    h.Spec,
+			spec:    spec,
 			writer:  responseWriter,
 			trailer: make(http.Header),
 			marshaler: connectStreamingMarshaler{
@@ -183,11 +211,13 @@ func (h *connectHandler) NewStream(
 					compressMinBytes: h.CompressMinBytes,
 					compressionPool:  h.CompressionPools.Get(responseCompression),
 					bufferPool:       h.BufferPool,
+					checksum:         checksumResponse,
 				},
+				types: h.Types,
 			},
 		}
 		receiver = &connectStreamingHandlerReceiver{
-			spec:    h.Spec,
+			spec:    spec,
 			request: request,
 			unmarshaler: connectStreamingUnmarshaler{
 				envelopeReader: envelopeReader{
@@ -196,10 +226,11 @@ func (h *connectHandler) NewStream(
 					compressionPool: h.CompressionPools.Get(requestCompression),
 					bufferPool:      h.BufferPool,
 				},
+				types: h.Types,
 			},
 		}
 	}
-	sender, receiver = wrapHandlerStreamWithCodedErrors(sender, receiver)
+	sender, receiver = wrapHandlerStreamWithCodedErrors(request.Context(), sender, receiver)
 	// We can't return failed as-is: a nil *Error is non-nil when returned as an
 	// error interface.
 	if failed != nil {
@@ -219,7 +250,7 @@ type connectClient struct {
 func (c *connectClient) WriteRequestHeader(streamType StreamType, header http.Header) {
 	// We know these header keys are in canonical form, so we can bypass all the
 	// checks in Header.Set.
-	header[headerUserAgent] = []string{connectUserAgent()}
+	header[headerUserAgent] = []string{appendUserAgent(connectUserAgent(), c.UserAgent)}
 	header[headerContentType] = []string{
 		connectContentTypeFromCodecName(streamType, c.Codec.Name()),
 	}
@@ -241,6 +272,9 @@ func (c *connectClient) WriteRequestHeader(streamType StreamType, header http.He
 	if acceptCompression := c.CompressionPools.CommaSeparatedNames(); acceptCompression != "" {
 		header[acceptCompressionHeader] = []string{acceptCompression}
 	}
+	if c.Checksums {
+		header[headerAcceptChecksum] = []string{checksumCRC32C}
+	}
 }
 
 func (c *connectClient) NewStream(
@@ -249,7 +283,7 @@ func (c *connectClient) NewStream(
 	header http.Header,
 ) (Sender, Receiver) {
 	if deadline, ok := ctx.Deadline(); ok {
-		millis := int64(time.Until(deadline) / time.Millisecond)
+		millis := int64(until(c.Clock, deadline) / time.Millisecond)
 		if millis > 0 {
 			encoded := strconv.FormatInt(millis, 10 /* base */)
 			if len(encoded) <= 10 {
@@ -257,6 +291,7 @@ func (c *connectClient) NewStream(
 			} // else effectively unbounded
 		}
 	}
+	codec := callStatsCodec(ctx, c.Codec)
 	duplexCall := newDuplexHTTPCall(ctx, c.HTTPClient, c.URL, spec, header)
 	var sender Sender
 	var receiver Receiver
@@ -266,12 +301,14 @@ func (c *connectClient) NewStream(
 			duplexCall: duplexCall,
 			marshaler: &connectUnaryMarshaler{
 				writer:           duplexCall,
-				codec:            c.Codec,
+				codec:            codec,
 				compressMinBytes: c.CompressMinBytes,
 				compressionName:  c.CompressionName,
 				compressionPool:  c.CompressionPools.Get(c.CompressionName),
 				bufferPool:       c.BufferPool,
 				header:           duplexCall.Header(),
+				checksum:         c.Checksums,
+				sendMaxBytes:     c.SendMaxBytes,
 			},
 		}
 		sender = unarySender
@@ -280,12 +317,14 @@ func (c *connectClient) NewStream(
 			duplexCall:       duplexCall,
 			compressionPools: c.CompressionPools,
 			bufferPool:       c.BufferPool,
+			types:            c.Types,
 			header:           make(http.Header),
 			trailer:          make(http.Header),
 			unmarshaler: connectUnaryUnmarshaler{
-				reader:     duplexCall,
-				codec:      c.Codec,
-				bufferPool: c.BufferPool,
+				reader:       duplexCall,
+				codec:        codec,
+				bufferPool:   c.BufferPool,
+				readMaxBytes: c.ReadMaxBytes,
 			},
 		}
 		receiver = unaryReceiver
@@ -297,11 +336,14 @@ func (c *connectClient) NewStream(
 			marshaler: &connectStreamingMarshaler{
 				envelopeWriter: envelopeWriter{
 					writer:           duplexCall,
-					codec:            c.Codec,
+					codec:            codec,
 					compressMinBytes: c.CompressMinBytes,
 					compressionPool:  c.CompressionPools.Get(c.CompressionName),
 					bufferPool:       c.BufferPool,
+					checksum:         c.Checksums,
+					sendMaxBytes:     c.SendMaxBytes,
 				},
+				types: c.Types,
 			},
 		}
 		sender = streamingSender
@@ -309,16 +351,18 @@ func (c *connectClient) NewStream(
 			spec:             spec,
 			bufferPool:       c.BufferPool,
 			compressionPools: c.CompressionPools,
-			codec:            c.Codec,
+			codec:            codec,
 			header:           make(http.Header),
 			trailer:          make(http.Header),
 			duplexCall:       duplexCall,
 			unmarshaler: connectStreamingUnmarshaler{
 				envelopeReader: envelopeReader{
-					reader:     duplexCall,
-					codec:      c.Codec,
-					bufferPool: c.BufferPool,
+					reader:       duplexCall,
+					codec:        codec,
+					bufferPool:   c.BufferPool,
+					readMaxBytes: c.ReadMaxBytes,
 				},
+				types: c.Types,
 			},
 		}
 		receiver = streamingReceiver
@@ -354,7 +398,38 @@ func (s *connectClientSender) Send(message any) error {
 	return nil // must be a literal nil: nil *Error is a non-nil error
 }
 
+func (s *connectClientSender) sendUncompressed(message any) error {
+	// Unary requests aren't enveloped, so they can't skip compression.
+	marshaler, ok := s.marshaler.(interface{ MarshalUncompressed(any) *Error })
+	if !ok {
+		return s.Send(message)
+	}
+	if err := marshaler.MarshalUncompressed(message); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *connectClientSender) sendBatch(messages []any) error {
+	batcher, ok := s.marshaler.(interface{ MarshalBatch([]any) *Error })
+	if !ok {
+		for _, message := range messages {
+			if err := s.Send(message); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := batcher.MarshalBatch(messages); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (s *connectClientSender) Close(err error) error {
+	if releaser, ok := s.marshaler.(interface{ release() }); ok {
+		releaser.release()
+	}
 	return s.duplexCall.CloseWrite()
 }
 
@@ -409,6 +484,10 @@ func (r *connectStreamingClientReceiver) Receive(message any) error {
 	return err
 }
 
+func (r *connectStreamingClientReceiver) readAhead() {
+	r.unmarshaler.readAhead()
+}
+
 func (r *connectStreamingClientReceiver) Close() error {
 	return r.duplexCall.CloseRead()
 }
@@ -416,7 +495,11 @@ func (r *connectStreamingClientReceiver) Close() error {
 // validateResponse is called by duplexHTTPCall in a separate goroutine.
 func (r *connectStreamingClientReceiver) validateResponse(response *http.Response) *Error {
 	if response.StatusCode != http.StatusOK {
-		return errorf(connectHTTPToCode(response.StatusCode), "HTTP status %v", response.Status)
+		return newHTTPStatusError(
+			connectHTTPToCode(response.StatusCode),
+			response,
+			fmt.Errorf("HTTP status %v", response.Status),
+		)
 	}
 	compression := response.Header.Get(connectStreamingHeaderCompression)
 	if compression != "" &&
@@ -449,8 +532,25 @@ func (s *connectStreamingHandlerSender) Send(message any) error {
 	return nil // must be a literal nil: nil *Error is a non-nil error
 }
 
+func (s *connectStreamingHandlerSender) sendUncompressed(message any) error {
+	defer flushResponseWriter(s.writer)
+	if err := s.marshaler.MarshalUncompressed(message); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *connectStreamingHandlerSender) sendBatch(messages []any) error {
+	defer flushResponseWriter(s.writer)
+	if err := s.marshaler.MarshalBatch(messages); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (s *connectStreamingHandlerSender) Close(err error) error {
 	defer flushResponseWriter(s.writer)
+	defer s.marshaler.release()
 	if err := s.marshaler.MarshalEndStream(err, s.trailer); err != nil {
 		return err
 	}
@@ -484,6 +584,10 @@ func (r *connectStreamingHandlerReceiver) Receive(message any) error {
 	return nil // must be a literal nil: nil *Error is a non-nil error
 }
 
+func (r *connectStreamingHandlerReceiver) readAhead() {
+	r.unmarshaler.readAhead()
+}
+
 func (r *connectStreamingHandlerReceiver) Close() error {
 	// We don't want to copy unread portions of the body to /dev/null here: if
 	// the client hasn't closed the request body, we'll block until the server
@@ -517,6 +621,7 @@ type connectUnaryClientReceiver struct {
 	duplexCall       *duplexHTTPCall
 	compressionPools readOnlyCompressionPools
 	bufferPool       *bufferPool
+	types            *protoregistry.Types // resolves error details
 
 	header      http.Header
 	trailer     http.Header
@@ -576,19 +681,21 @@ func (r *connectUnaryClientReceiver) validateResponse(response *http.Response) *
 		}
 		var serverErr Error
 		if err := unmarshaler.UnmarshalFunc(
-			(*connectWireError)(&serverErr),
+			&connectWireError{err: &serverErr, types: r.types},
 			json.Unmarshal,
 		); err == nil {
 			serverErr.meta = r.header.Clone()
 			mergeHeaders(serverErr.meta, r.trailer)
 			return &serverErr
 		}
-		return NewError(
+		return newHTTPStatusError(
 			connectHTTPToCode(response.StatusCode),
+			response,
 			errors.New(response.Status),
 		)
 	}
 	r.unmarshaler.compressionPool = r.compressionPools.Get(compression)
+	r.unmarshaler.checksum = response.Header.Get(headerMessageCRC32C)
 	return nil
 }
 
@@ -598,6 +705,7 @@ type connectUnaryHandlerSender struct {
 	marshaler      connectUnaryMarshaler
 	trailer        http.Header
 	wroteBody      bool
+	types          *protoregistry.Types // resolves error details
 }
 
 func (s *connectUnaryHandlerSender) Spec() Spec {
@@ -628,20 +736,27 @@ func (s *connectUnaryHandlerSender) Close(err error) error {
 	if err == nil {
 		return nil
 	}
-	// In unary Connect, errors always use application/json.
-	s.responseWriter.Header().Set(headerContentType, connectUnaryContentTypeJSON)
-	s.responseWriter.WriteHeader(connectCodeToHTTP(CodeOf(err)))
-	var wire *connectWireError
-	if connectErr, ok := asError(err); ok {
-		wire = (*connectWireError)(connectErr)
-	} else {
-		wire = (*connectWireError)(NewError(CodeUnknown, err))
-	}
-	data, marshalErr := json.Marshal(wire)
+	return writeConnectUnaryError(s.responseWriter, err, s.types)
+}
+
+// writeConnectUnaryError writes err as a unary Connect error: an HTTP status
+// derived from its code and a JSON body. The types resolve the error's
+// details, and may be nil.
+func writeConnectUnaryError(responseWriter http.ResponseWriter, err error, types *protoregistry.Types) error {
+	// Marshal the error before writing the status, so that an error whose
+	// details can't be marshaled is still sent whole, as an internal error.
+	data, marshalErr := json.Marshal(newConnectWireError(err, types))
 	if marshalErr != nil {
-		return errorf(CodeInternal, "marshal error: %w", err)
+		err = errorf(CodeInternal, "marshal error: %w", marshalErr)
+		data, marshalErr = json.Marshal(newConnectWireError(err, types))
+		if marshalErr != nil {
+			return errorf(CodeInternal, "marshal error: %w", marshalErr)
+		}
 	}
-	_, writeErr := s.responseWriter.Write(data)
+	// In unary Connect, errors always use application/json.
+	responseWriter.Header().Set(headerContentType, connectUnaryContentTypeJSON)
+	responseWriter.WriteHeader(connectCodeToHTTP(CodeOf(err)))
+	_, writeErr := responseWriter.Write(data)
 	return writeErr
 }
 
@@ -688,6 +803,8 @@ func (r *connectUnaryHandlerReceiver) Close() error {
 
 type connectStreamingMarshaler struct {
 	envelopeWriter
+
+	types *protoregistry.Types // resolves error details
 }
 
 func (m *connectStreamingMarshaler) MarshalEndStream(err error, trailer http.Header) *Error {
@@ -695,12 +812,15 @@ func (m *connectStreamingMarshaler) MarshalEndStream(err error, trailer http.Hea
 	if err != nil {
 		if connectErr, ok := asError(err); ok {
 			mergeHeaders(end.Trailer, connectErr.meta)
-			end.Error = (*connectWireError)(connectErr)
-		} else {
-			end.Error = (*connectWireError)(NewError(CodeUnknown, err))
 		}
+		end.Error = newConnectWireError(err, m.types)
 	}
 	data, marshalErr := json.Marshal(end)
+	if marshalErr != nil && end.Error != nil {
+		// Send the failure to marshal the error's details in its place.
+		end.Error = newConnectWireError(errorf(CodeInternal, "marshal error: %w", marshalErr), m.types)
+		data, marshalErr = json.Marshal(end)
+	}
 	if marshalErr != nil {
 		return errorf(CodeInternal, "marshal end stream: %w", marshalErr)
 	}
@@ -715,6 +835,7 @@ func (m *connectStreamingMarshaler) MarshalEndStream(err error, trailer http.Hea
 type connectStreamingUnmarshaler struct {
 	envelopeReader
 
+	types        *protoregistry.Types // resolves error details
 	endStreamErr *Error
 	trailer      http.Header
 }
@@ -729,14 +850,16 @@ func (u *connectStreamingUnmarshaler) Unmarshal(message any) *Error {
 	}
 	env := u.envelopeReader.last
 	if !env.IsSet(connectFlagEnvelopeEndStream) {
-		return errorf(CodeInternal, "protocol error: invalid envelope flags %d", env.Flags)
+		return errorf(CodeInternal, "protocol error: invalid envelope flags %d", env.Flags).withEndReason(StreamEndProtocol)
 	}
-	var end connectEndStreamMessage
+	end := connectEndStreamMessage{Error: &connectWireError{types: u.types}}
 	if err := json.Unmarshal(env.Data.Bytes(), &end); err != nil {
-		return errorf(CodeInternal, "unmarshal end stream message: %w", err)
+		return errorf(CodeInternal, "unmarshal end stream message: %w", err).withEndReason(StreamEndProtocol)
 	}
 	u.trailer = end.Trailer
-	u.endStreamErr = (*Error)(end.Error)
+	if end.Error != nil {
+		u.endStreamErr = end.Error.err
+	}
 	return errSpecialEnvelope
 }
 
@@ -756,6 +879,8 @@ type connectUnaryMarshaler struct {
 	compressionPool  *compressionPool
 	bufferPool       *bufferPool
 	header           http.Header
+	checksum         bool // set headerMessageCRC32C
+	sendMaxBytes     int  // zero means no limit
 }
 
 func (m *connectUnaryMarshaler) Marshal(message any) *Error {
@@ -763,6 +888,11 @@ func (m *connectUnaryMarshaler) Marshal(message any) *Error {
 	if err != nil {
 		return errorf(CodeInternal, "marshal message: %w", err)
 	}
+	if m.sendMaxBytes > 0 && len(data) > m.sendMaxBytes {
+		return errorf(CodeResourceExhausted, "message size %d exceeds sendMaxBytes %d", len(data), m.sendMaxBytes)
+	}
+	m.bufferPool.budget.add(int64(len(data)))
+	defer m.bufferPool.budget.release(int64(len(data)))
 	// Can't avoid allocating the slice, but we can reuse it.
 	uncompressed := bytes.NewBuffer(data)
 	defer m.bufferPool.Put(uncompressed)
@@ -779,6 +909,9 @@ func (m *connectUnaryMarshaler) Marshal(message any) *Error {
 }
 
 func (m *connectUnaryMarshaler) write(data []byte) *Error {
+	if m.checksum {
+		m.header.Set(headerMessageCRC32C, encodeUnaryChecksum(data))
+	}
 	if _, err := m.writer.Write(data); err != nil {
 		if connectErr, ok := asError(err); ok {
 			return connectErr
@@ -793,6 +926,8 @@ type connectUnaryUnmarshaler struct {
 	codec           Codec
 	compressionPool *compressionPool
 	bufferPool      *bufferPool
+	checksum        string // from headerMessageCRC32C, if any
+	readMaxBytes    int    // zero means no limit
 	alreadyRead     bool
 }
 
@@ -807,17 +942,36 @@ func (u *connectUnaryUnmarshaler) UnmarshalFunc(message any, unmarshal func([]by
 	u.alreadyRead = true
 	data := u.bufferPool.Get()
 	defer u.bufferPool.Put(data)
+	// Unary messages aren't length-prefixed, so we reserve memory as the body
+	// arrives.
+	reader := &budgetReader{reader: u.reader, budget: u.bufferPool.budget}
+	defer reader.release()
+	var limited io.Reader = reader
+	if u.readMaxBytes > 0 {
+		// Read one byte past the limit, so we can tell if it's exceeded without
+		// reading the whole message.
+		limited = io.LimitReader(reader, int64(u.readMaxBytes)+1)
+	}
 	// ReadFrom ignores io.EOF, so any error here is real.
-	if _, err := data.ReadFrom(u.reader); err != nil {
+	bytesRead, err := data.ReadFrom(limited)
+	if err != nil {
 		if connectErr, ok := asError(err); ok {
 			return connectErr
 		}
 		return errorf(CodeUnknown, "read message: %w", err)
 	}
+	if u.readMaxBytes > 0 && bytesRead > int64(u.readMaxBytes) {
+		return errorf(CodeResourceExhausted, "message size exceeds readMaxBytes %d", u.readMaxBytes)
+	}
+	if u.checksum != "" {
+		if err := verifyUnaryChecksum(data, u.checksum); err != nil {
+			return err
+		}
+	}
 	if data.Len() > 0 && u.compressionPool != nil {
 		decompressed := u.bufferPool.Get()
 		defer u.bufferPool.Put(decompressed)
-		if err := u.compressionPool.Decompress(decompressed, data); err != nil {
+		if err := u.compressionPool.Decompress(decompressed, data, int64(u.readMaxBytes)); err != nil {
 			return err
 		}
 		data = decompressed
@@ -828,30 +982,43 @@ func (u *connectUnaryUnmarshaler) UnmarshalFunc(message any, unmarshal func([]by
 	return nil
 }
 
-type connectWireError Error
+// connectWireError is the JSON representation of an *Error. If types is
+// non-nil, it resolves the types of the error's details before the global
+// registry. Standard error details are resolved even if they're not in either
+// registry.
+type connectWireError struct {
+	err   *Error
+	types *protoregistry.Types
+}
+
+func newConnectWireError(err error, types *protoregistry.Types) *connectWireError {
+	if connectErr, ok := asError(err); ok {
+		return &connectWireError{err: connectErr, types: types}
+	}
+	return &connectWireError{err: NewError(CodeUnknown, err), types: types}
+}
 
 func (e *connectWireError) MarshalJSON() ([]byte, error) {
 	wire := &errorv1.Error{
-		Code:    CodeUnknown.String(),
-		Message: (*Error)(e).Error(),
-	}
-	if connectErr, ok := asError((*Error)(e)); ok {
-		wire.Code = connectErr.Code().String()
-		wire.Message = connectErr.Message()
-		details, err := connectErr.detailsAsAny()
-		if err != nil {
-			return nil, err
-		}
-		wire.Details = details
+		Code:    e.err.Code().String(),
+		Message: e.err.Message(),
 	}
-	return (&protoJSONCodec{}).Marshal(wire)
+	details, err := e.err.detailsAsAny()
+	if err != nil {
+		return nil, err
+	}
+	wire.Details = details
+	return (&protoJSONCodec{types: e.types}).Marshal(wire)
 }
 
 func (e *connectWireError) UnmarshalJSON(data []byte) error {
 	var wire errorv1.Error
-	if err := (&protoJSONCodec{}).Unmarshal(data, &wire); err != nil {
+	if err := (&protoJSONCodec{types: e.types}).Unmarshal(data, &wire); err != nil {
 		return err
 	}
+	if e.err == nil {
+		e.err = &Error{}
+	}
 	if wire.Code == "" {
 		return nil
 	}
@@ -859,14 +1026,15 @@ func (e *connectWireError) UnmarshalJSON(data []byte) error {
 	if err := code.UnmarshalText([]byte(wire.Code)); err != nil {
 		return err
 	}
-	e.code = code
+	e.err.code = code
+	e.err.endReason = StreamEndPeerError
 	if wire.Message != "" {
-		e.err = errors.New(wire.Message)
+		e.err.err = errors.New(wire.Message)
 	}
 	if len(wire.Details) > 0 {
-		e.details = make([]ErrorDetail, len(wire.Details))
+		e.err.details = make([]ErrorDetail, len(wire.Details))
 		for i, detail := range wire.Details {
-			e.details[i] = detail
+			e.err.details[i] = detail
 		}
 	}
 	return nil
@@ -32,12 +32,12 @@ import (
 )
 
 const (
-	grpcHeaderCompression       = "Grpc-Encoding"
-	grpcHeaderAcceptCompression = "Grpc-Accept-Encoding"
-	grpcHeaderTimeout           = "Grpc-Timeout"
-	grpcHeaderStatus            = "Grpc-Status"
-	grpcHeaderMessage           = "Grpc-Message"
-	grpcHeaderDetails           = "Grpc-Status-Details-Bin"
+	grpcHeaderCompression       = HeaderGRPCEncoding
+	grpcHeaderAcceptCompression = HeaderGRPCAcceptEncoding
+	grpcHeaderTimeout           = HeaderGRPCTimeout
+	grpcHeaderStatus            = HeaderGRPCStatus
+	grpcHeaderMessage           = HeaderGRPCMessage
+	grpcHeaderDetails           = HeaderGRPCStatusDetails
 
 	grpcFlagEnvelopeTrailer = 0b10000000
 
@@ -117,7 +117,18 @@ func (g *grpcHandler) ContentTypes() map[string]struct{} {
 	return g.accept
 }
 
-func (*grpcHandler) SetTimeout(request *http.Request) (context.Context, context.CancelFunc, error) {
+func (g *grpcHandler) Protocol() string {
+	if g.web {
+		return ProtocolGRPCWeb
+	}
+	return ProtocolGRPC
+}
+
+func (g *grpcHandler) CodecName(contentType string) string {
+	return grpcCodecFromContentType(g.web, contentType)
+}
+
+func (g *grpcHandler) SetTimeout(request *http.Request) (context.Context, context.CancelFunc, error) {
 	timeout, err := grpcParseTimeout(request.Header.Get(grpcHeaderTimeout))
 	if err != nil && !errors.Is(err, errNoTimeout) {
 		// Errors here indicate that the client sent an invalid timeout header, so
@@ -127,7 +138,7 @@ func (*grpcHandler) SetTimeout(request *http.Request) (context.Context, context.
 		// err wraps errNoTimeout, nothing to do.
 		return request.Context(), nil, nil
 	}
-	ctx, cancel := context.WithTimeout(request.Context(), timeout)
+	ctx, cancel := withTimeout(request.Context(), g.Clock, timeout)
 	return ctx, cancel, nil
 }
 
@@ -139,9 +150,21 @@ func (g *grpcHandler) NewStream(
 	// send the error to the client later on.
 	requestCompression, responseCompression, failed := negotiateCompression(
 		g.CompressionPools,
+		g.ResponseCompressionPools,
+		g.RequireCompression,
 		request.Header.Get(grpcHeaderCompression),
 		request.Header.Get(grpcHeaderAcceptCompression),
 	)
+	if failed == nil {
+		responseCompression = selectResponseCompression(
+			g.CompressionSelector,
+			g.Spec.Procedure,
+			g.ResponseCompressionPools,
+			requestCompression,
+			responseCompression,
+			request.Header.Get(grpcHeaderAcceptCompression),
+		)
+	}
 
 	// Write any remaining headers here:
 	// (1) any writes to the stream will implicitly send the headers, so we
@@ -157,9 +180,11 @@ func (g *grpcHandler) NewStream(
 		header[grpcHeaderCompression] = []string{responseCompression}
 	}
 
+	spec := g.Spec
+	spec.compression = Compression{Request: requestCompression, Response: responseCompression}
 	codecName := grpcCodecFromContentType(g.web, request.Header.Get(headerContentType))
-	sender, receiver := wrapHandlerStreamWithCodedErrors(newGRPCHandlerStream(
-		g.Spec,
+	grpcSender, grpcReceiver := newGRPCHandlerStream(
+		spec,
 		g.web,
 		responseWriter,
 		request,
@@ -169,7 +194,9 @@ func (g *grpcHandler) NewStream(
 		g.CompressionPools.Get(requestCompression),
 		g.CompressionPools.Get(responseCompression),
 		g.BufferPool,
-	))
+		g.Checksums && acceptsChecksums(request.Header),
+	)
+	sender, receiver := wrapHandlerStreamWithCodedErrors(request.Context(), grpcSender, grpcReceiver)
 	if failed != nil {
 		// Negotiation failed, so we can't establish a stream. To make the
 		// request's HTTP trailers visible to interceptors, we should try to read
@@ -189,7 +216,7 @@ type grpcClient struct {
 func (g *grpcClient) WriteRequestHeader(_ StreamType, header http.Header) {
 	// We know these header keys are in canonical form, so we can bypass all the
 	// checks in Header.Set.
-	header[headerUserAgent] = []string{grpcUserAgent()}
+	header[headerUserAgent] = []string{appendUserAgent(grpcUserAgent(), g.UserAgent)}
 	header[headerContentType] = []string{grpcContentTypeFromCodecName(g.web, g.Codec.Name())}
 	// gRPC handles compression on a per-message basis, so we don't want to
 	// compress the whole stream. By default, http.Client will ask the server
@@ -206,6 +233,9 @@ func (g *grpcClient) WriteRequestHeader(_ StreamType, header http.Header) {
 		// don't support HTTP trailers.
 		header["Te"] = []string{"trailers"}
 	}
+	if g.Checksums {
+		header[headerAcceptChecksum] = []string{checksumCRC32C}
+	}
 }
 
 func (g *grpcClient) NewStream(
@@ -214,12 +244,13 @@ func (g *grpcClient) NewStream(
 	header http.Header,
 ) (Sender, Receiver) {
 	if deadline, ok := ctx.Deadline(); ok {
-		if encodedDeadline, err := grpcEncodeTimeout(time.Until(deadline)); err == nil {
+		if encodedDeadline, err := grpcEncodeTimeout(until(g.Clock, deadline)); err == nil {
 			// Tests verify that the error in encodeTimeout is unreachable, so we
 			// don't need to handle the error case.
 			header[grpcHeaderTimeout] = []string{encodedDeadline}
 		}
 	}
+	codec := callStatsCodec(ctx, g.Codec)
 	duplexCall := newDuplexHTTPCall(
 		ctx,
 		g.HTTPClient,
@@ -234,9 +265,11 @@ func (g *grpcClient) NewStream(
 			envelopeWriter: envelopeWriter{
 				writer:           duplexCall,
 				compressionPool:  g.CompressionPools.Get(g.CompressionName),
-				codec:            g.Codec,
+				codec:            codec,
 				compressMinBytes: g.CompressMinBytes,
 				bufferPool:       g.BufferPool,
+				checksum:         g.Checksums,
+				sendMaxBytes:     g.SendMaxBytes,
 			},
 		},
 	}
@@ -253,9 +286,10 @@ func (g *grpcClient) NewStream(
 			unmarshaler: grpcUnmarshaler{
 				web: true,
 				envelopeReader: envelopeReader{
-					reader:     duplexCall,
-					codec:      g.Codec,
-					bufferPool: g.BufferPool,
+					reader:       duplexCall,
+					codec:        codec,
+					bufferPool:   g.BufferPool,
+					readMaxBytes: g.ReadMaxBytes,
 				},
 			},
 		}
@@ -273,11 +307,13 @@ func (g *grpcClient) NewStream(
 			unmarshaler: grpcUnmarshaler{
 				web: false,
 				envelopeReader: envelopeReader{
-					reader:     duplexCall,
-					codec:      g.Codec,
-					bufferPool: g.BufferPool,
+					reader:       duplexCall,
+					codec:        codec,
+					bufferPool:   g.BufferPool,
+					readMaxBytes: g.ReadMaxBytes,
 				},
 			},
+			detectHTTP2: g.DetectHTTP2Unavailable,
 		}
 		receiver = grpcReceiver
 		duplexCall.SetValidateResponse(grpcReceiver.validateResponse)
@@ -313,7 +349,22 @@ func (s *grpcClientSender) Send(message any) error {
 	return nil // must be a literal nil: nil *Error is a non-nil error
 }
 
+func (s *grpcClientSender) sendUncompressed(message any) error {
+	if err := s.marshaler.MarshalUncompressed(message); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *grpcClientSender) sendBatch(messages []any) error {
+	if err := s.marshaler.MarshalBatch(messages); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (s *grpcClientSender) Close(_ error) error {
+	s.marshaler.release()
 	return s.duplexCall.CloseWrite()
 }
 
@@ -326,6 +377,7 @@ type grpcClientReceiver struct {
 	trailer          http.Header
 	duplexCall       *duplexHTTPCall
 	unmarshaler      grpcUnmarshaler
+	detectHTTP2      bool
 }
 
 func (r *grpcClientReceiver) Spec() Spec {
@@ -369,12 +421,21 @@ func (r *grpcClientReceiver) Receive(message any) error {
 	return err
 }
 
+func (r *grpcClientReceiver) readAhead() {
+	r.unmarshaler.envelopeReader.readAhead()
+}
+
 func (r *grpcClientReceiver) Close() error {
 	return r.duplexCall.CloseRead()
 }
 
 // validateResponse is called by duplexHTTPCall in a separate goroutine.
 func (r *grpcClientReceiver) validateResponse(response *http.Response) *Error {
+	if r.detectHTTP2 {
+		if err := grpcValidateHTTP2(response); err != nil {
+			return err
+		}
+	}
 	if err := grpcValidateResponse(
 		response,
 		r.header,
@@ -434,6 +495,10 @@ func (r *grpcWebClientReceiver) Receive(message any) error {
 	return err
 }
 
+func (r *grpcWebClientReceiver) readAhead() {
+	r.unmarshaler.envelopeReader.readAhead()
+}
+
 func (r *grpcWebClientReceiver) Close() error {
 	return r.duplexCall.CloseRead()
 }
@@ -479,8 +544,32 @@ func (hs *grpcHandlerSender) Send(message any) error {
 	return nil // must be a literal nil: nil *Error is a non-nil error
 }
 
-func (hs *grpcHandlerSender) Close(err error) error {
+func (hs *grpcHandlerSender) sendUncompressed(message any) error {
 	defer flushResponseWriter(hs.writer)
+	if !hs.wroteToBody {
+		mergeHeaders(hs.writer.Header(), hs.header)
+		hs.wroteToBody = true
+	}
+	if err := hs.marshaler.MarshalUncompressed(message); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (hs *grpcHandlerSender) sendBatch(messages []any) error {
+	defer flushResponseWriter(hs.writer)
+	if !hs.wroteToBody {
+		mergeHeaders(hs.writer.Header(), hs.header)
+		hs.wroteToBody = true
+	}
+	if err := hs.marshaler.MarshalBatch(messages); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (hs *grpcHandlerSender) Close(err error) error {
+	defer hs.marshaler.release()
 	// If we haven't written the headers yet, do so.
 	if !hs.wroteToBody {
 		mergeHeaders(hs.writer.Header(), hs.header)
@@ -500,8 +589,23 @@ func (hs *grpcHandlerSender) Close(err error) error {
 		// so we emulate Envoy's behavior and put the trailing metadata in the HTTP
 		// headers.
 		mergeHeaders(hs.writer.Header(), mergedTrailers)
+		flushResponseWriter(hs.writer)
+		return nil
+	}
+	if !hs.wroteToBody && err != nil {
+		// We're using standard gRPC, and the handler failed before sending any
+		// messages. The gRPC specification allows a "trailers-only" response:
+		// a single HEADERS frame carrying the status and trailing metadata,
+		// with END_STREAM set. We put the metadata in the headers, declare no
+		// trailers, and don't flush: when the handler returns without writing
+		// a body, net/http sends the headers and ends the stream in one frame.
+		// Strict clients (and Envoy) handle these responses better than an
+		// empty body followed by trailers.
+		mergeHeaders(hs.writer.Header(), mergedTrailers)
+		hs.writer.WriteHeader(http.StatusOK)
 		return nil
 	}
+	defer flushResponseWriter(hs.writer)
 	if hs.web {
 		// We're using gRPC-Web and we've already sent the headers, so we write
 		// trailing metadata to the HTTP body.
@@ -510,14 +614,11 @@ func (hs *grpcHandlerSender) Close(err error) error {
 		}
 		return nil // must be a literal nil: nil *Error is a non-nil error
 	}
-	// We're using standard gRPC. Even if we haven't written to the body and
-	// we're sending a "trailers-only" response, we must send trailing metadata
-	// as HTTP trailers. (If we had frame-level control of the HTTP/2 layer, we
-	// could send trailers-only responses as a single HEADER frame and no DATA
-	// frames, but net/http doesn't expose APIs that low-level.) In net/http's
-	// ResponseWriter API, we send HTTP trailers by writing to the headers map
-	// with a special prefix. This prefixing is an implementation detail, so we
-	// should hide it and _not_ mutate the user-visible headers.
+	// We're using standard gRPC and we've either sent messages or are closing
+	// a successful stream, so we send trailing metadata as HTTP trailers. In
+	// net/http's ResponseWriter API, we send HTTP trailers by writing to the
+	// headers map with a special prefix. This prefixing is an implementation
+	// detail, so we should hide it and _not_ mutate the user-visible headers.
 	//
 	// Note that this is _very_ finicky and difficult to test with net/http,
 	// since correctness depends on low-level framing details. Breaking this
@@ -559,6 +660,7 @@ func newGRPCHandlerStream(
 	requestCompressionPools *compressionPool,
 	responseCompressionPools *compressionPool,
 	bufferPool *bufferPool,
+	checksum bool,
 ) (*grpcHandlerSender, *grpcHandlerReceiver) {
 	sender := &grpcHandlerSender{
 		spec: spec,
@@ -570,6 +672,7 @@ func newGRPCHandlerStream(
 				codec:            codec,
 				compressMinBytes: compressMinBytes,
 				bufferPool:       bufferPool,
+				checksum:         checksum,
 			},
 		},
 		protobuf:   protobuf,
@@ -601,6 +704,10 @@ func (hr *grpcHandlerReceiver) Receive(message any) error {
 	return nil // must be a literal nil: nil *Error is a non-nil error
 }
 
+func (hr *grpcHandlerReceiver) readAhead() {
+	hr.unmarshaler.envelopeReader.readAhead()
+}
+
 func (hr *grpcHandlerReceiver) Close() error {
 	// We don't want to copy unread portions of the body to /dev/null here: if
 	// the client hasn't closed the request body, we'll block until the server
@@ -628,9 +735,16 @@ type grpcMarshaler struct {
 }
 
 func (m *grpcMarshaler) MarshalWebTrailers(trailer http.Header) *Error {
+	// Like HTTP/2 headers and gRPC metadata, gRPC-Web trailers have lower-case
+	// keys. Clients aren't required to canonicalize them.
+	lowerTrailer := make(http.Header, len(trailer))
+	for key, values := range trailer {
+		lowerKey := metadataKey(key)
+		lowerTrailer[lowerKey] = append(lowerTrailer[lowerKey], values...)
+	}
 	raw := m.envelopeWriter.bufferPool.Get()
 	defer m.envelopeWriter.bufferPool.Put(raw)
-	if err := trailer.Write(raw); err != nil {
+	if err := lowerTrailer.Write(raw); err != nil {
 		return errorf(CodeInternal, "format trailers: %w", err)
 	}
 	return m.Write(&envelope{
@@ -655,7 +769,7 @@ func (u *grpcUnmarshaler) Unmarshal(message any) *Error {
 	}
 	env := u.envelopeReader.last
 	if !u.web || !env.IsSet(grpcFlagEnvelopeTrailer) {
-		return errorf(CodeInternal, "protocol error: invalid envelope flags %d", env.Flags)
+		return errorf(CodeInternal, "protocol error: invalid envelope flags %d", env.Flags).withEndReason(StreamEndProtocol)
 	}
 
 	// Per the gRPC-Web specification, trailers should be encoded as an HTTP/1
@@ -672,7 +786,7 @@ func (u *grpcUnmarshaler) Unmarshal(message any) *Error {
 			CodeInternal,
 			"gRPC-Web protocol error: trailers invalid: %w",
 			mimeErr,
-		)
+		).withEndReason(StreamEndProtocol)
 	}
 	u.webTrailer = http.Header(mimeHeader)
 	return errSpecialEnvelope
@@ -682,6 +796,35 @@ func (u *grpcUnmarshaler) WebTrailer() http.Header {
 	return u.webTrailer
 }
 
+// grpcValidateHTTP2 catches HTTP/1.x responses that clearly didn't come from a
+// gRPC server, usually because a proxy along the way doesn't support HTTP/2.
+// Only protocol mismatches are reported: an HTTP 505 or a successful response
+// that isn't gRPC. Other failures, like authentication errors from a proxy,
+// are left to grpcValidateResponse so that they aren't retried with the
+// fallback protocol. Servers that do speak gRPC over HTTP/1.1 are left alone.
+func grpcValidateHTTP2(response *http.Response) *Error {
+	if response.ProtoMajor >= 2 {
+		return nil
+	}
+	if response.StatusCode == http.StatusHTTPVersionNotSupported {
+		return newHTTPStatusError(
+			grpcHTTPToCode(response.StatusCode),
+			response,
+			&http2UnavailableError{fmt.Errorf("HTTP status %v", response.Status)},
+		)
+	}
+	contentType := response.Header.Get(headerContentType)
+	if response.StatusCode != http.StatusOK || strings.HasPrefix(contentType, grpcContentTypeDefault) {
+		return nil
+	}
+	return NewError(CodeUnknown, &http2UnavailableError{fmt.Errorf(
+		"HTTP/%d.%d response has content-type %q: gRPC requires HTTP/2",
+		response.ProtoMajor,
+		response.ProtoMinor,
+		contentType,
+	)})
+}
+
 func grpcValidateResponse(
 	response *http.Response,
 	header, trailer http.Header,
@@ -690,7 +833,11 @@ func grpcValidateResponse(
 	protobuf Codec,
 ) *Error {
 	if response.StatusCode != http.StatusOK {
-		return errorf(grpcHTTPToCode(response.StatusCode), "HTTP status %v", response.Status)
+		return newHTTPStatusError(
+			grpcHTTPToCode(response.StatusCode),
+			response,
+			fmt.Errorf("HTTP status %v", response.Status),
+		)
 	}
 	if compression := response.Header.Get(grpcHeaderCompression); compression != "" &&
 		compression != compressionIdentity &&
@@ -758,20 +905,20 @@ func grpcErrorFromTrailer(bufferPool *bufferPool, protobuf Codec, trailer http.H
 
 	code, err := strconv.ParseUint(codeHeader, 10 /* base */, 32 /* bitsize */)
 	if err != nil {
-		return errorf(CodeInternal, "gRPC protocol error: invalid error code %q", codeHeader)
+		return errorf(CodeInternal, "gRPC protocol error: invalid error code %q", codeHeader).withEndReason(StreamEndProtocol)
 	}
 	message := grpcPercentDecode(bufferPool, trailer.Get(grpcHeaderMessage))
-	retErr := NewError(Code(code), errors.New(message))
+	retErr := NewError(Code(code), errors.New(message)).withEndReason(StreamEndPeerError)
 
 	detailsBinaryEncoded := trailer.Get(grpcHeaderDetails)
 	if len(detailsBinaryEncoded) > 0 {
 		detailsBinary, err := DecodeBinaryHeader(detailsBinaryEncoded)
 		if err != nil {
-			return errorf(CodeInternal, "server returned invalid grpc-status-details-bin trailer: %w", err)
+			return errorf(CodeInternal, "server returned invalid grpc-status-details-bin trailer: %w", err).withEndReason(StreamEndProtocol)
 		}
 		var status statusv1.Status
 		if err := protobuf.Unmarshal(detailsBinary, &status); err != nil {
-			return errorf(CodeInternal, "server returned invalid protobuf for error details: %w", err)
+			return errorf(CodeInternal, "server returned invalid protobuf for error details: %w", err).withEndReason(StreamEndProtocol)
 		}
 		for _, d := range status.Details {
 			retErr.details = append(retErr.details, d)
@@ -913,6 +1060,8 @@ func grpcStatusFromError(err error) (*statusv1.Status, error) {
 	return status, nil
 }
 
+const upperhex = "0123456789ABCDEF"
+
 // grpcPercentEncode follows RFC 3986 Section 2.1 and the gRPC HTTP/2 spec.
 // It's a variant of URL-encoding with fewer reserved characters. It's intended
 // to take UTF-8 encoded text and escape non-ASCII bytes so that they're valid
@@ -944,7 +1093,9 @@ func grpcPercentEncodeSlow(bufferPool *bufferPool, msg string, offset int) strin
 	for i := offset; i < len(msg); i++ {
 		c := msg[i]
 		if c < ' ' || c > '~' || c == '%' {
-			out.WriteString(fmt.Sprintf("%%%02X", c))
+			out.WriteByte('%')
+			out.WriteByte(upperhex[c>>4])
+			out.WriteByte(upperhex[c&15])
 			continue
 		}
 		out.WriteByte(c)
@@ -952,6 +1103,10 @@ func grpcPercentEncodeSlow(bufferPool *bufferPool, msg string, offset int) strin
 	return out.String()
 }
 
+// grpcPercentDecode reverses grpcPercentEncode. The gRPC spec forbids
+// discarding messages that aren't validly encoded, so malformed escapes are
+// left as-is and messages that don't decode to valid UTF-8 are returned in
+// their raw, percent-encoded form.
 func grpcPercentDecode(bufferPool *bufferPool, encoded string) string {
 	for i := 0; i < len(encoded); i++ {
 		if c := encoded[i]; c == '%' && i+2 < len(encoded) {
@@ -973,13 +1128,29 @@ func grpcPercentDecodeSlow(bufferPool *bufferPool, encoded string, offset int) s
 			out.WriteByte(c)
 			continue
 		}
-		parsed, err := strconv.ParseUint(encoded[i+1:i+3], 16 /* hex */, 8 /* bitsize */)
-		if err != nil {
-			out.WriteRune(utf8.RuneError)
-		} else {
-			out.WriteByte(byte(parsed))
+		hi, hiOK := unhex(encoded[i+1])
+		lo, loOK := unhex(encoded[i+2])
+		if !hiOK || !loOK {
+			out.WriteByte(c)
+			continue
 		}
+		out.WriteByte(hi<<4 | lo)
 		i += 2
 	}
+	if !utf8.Valid(out.Bytes()) {
+		return encoded
+	}
 	return out.String()
 }
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
@@ -60,6 +60,65 @@ func TestGRPCHandlerSender(t *testing.T) {
 	})
 }
 
+func TestGRPCHandlerSenderTrailersOnly(t *testing.T) {
+	t.Parallel()
+	newSender := func(responseWriter http.ResponseWriter) *grpcHandlerSender {
+		protobufCodec := &protoBinaryCodec{}
+		bufferPool := newBufferPool()
+		return &grpcHandlerSender{
+			marshaler: grpcMarshaler{
+				envelopeWriter: envelopeWriter{
+					writer:     responseWriter,
+					codec:      protobufCodec,
+					bufferPool: bufferPool,
+				},
+			},
+			protobuf:   protobufCodec,
+			writer:     responseWriter,
+			header:     make(http.Header),
+			trailer:    make(http.Header),
+			bufferPool: bufferPool,
+		}
+	}
+	t.Run("error", func(t *testing.T) {
+		t.Parallel()
+		recorder := httptest.NewRecorder()
+		sender := newSender(recorder)
+		sender.trailer.Set("Some-Trailer", "foo")
+		assert.Nil(t, sender.Close(NewError(CodeUnavailable, errors.New("oh no"))))
+		result := recorder.Result()
+		assert.Equal(t, result.Header.Get("Grpc-Status"), "14")
+		assert.Equal(t, result.Header.Get("Some-Trailer"), "foo")
+		assert.Equal(t, len(result.Trailer), 0)
+		assert.Equal(t, recorder.Body.Len(), 0)
+	})
+	t.Run("http2", func(t *testing.T) {
+		t.Parallel()
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_ = newSender(w).Close(NewError(CodeUnavailable, errors.New("oh no")))
+		}))
+		server.EnableHTTP2 = true
+		server.StartTLS()
+		t.Cleanup(server.Close)
+		response, err := server.Client().Get(server.URL)
+		assert.Nil(t, err)
+		defer response.Body.Close()
+		assert.Equal(t, response.ProtoMajor, 2)
+		assert.Equal(t, response.Header.Get("Grpc-Status"), "14")
+		// The HTTP/2 client only uses http.NoBody when the HEADERS frame ends
+		// the stream.
+		assert.True(t, response.Body == http.NoBody)
+	})
+	t.Run("success", func(t *testing.T) {
+		t.Parallel()
+		recorder := httptest.NewRecorder()
+		assert.Nil(t, newSender(recorder).Close(nil))
+		result := recorder.Result()
+		assert.Equal(t, result.Header.Get("Grpc-Status"), "")
+		assert.Equal(t, result.Trailer.Get("Grpc-Status"), "0")
+	})
+}
+
 func testGRPCHandlerSenderMetadata(t *testing.T, sender Sender) {
 	// Closing the sender shouldn't unpredictably mutate user-visible headers or
 	// trailers.
@@ -160,4 +219,14 @@ func TestGRPCPercentEncoding(t *testing.T) {
 	roundtrip("foo bar")
 	roundtrip(`foo%bar`)
 	roundtrip("fiancée")
+	roundtrip("línea\nnueva\t😀")
+
+	assert.Equal(t, grpcPercentEncode(pool, "a\x00ñ%"), "a%00%C3%B1%25")
+	// Lowercase hex is valid.
+	assert.Equal(t, grpcPercentDecode(pool, "%c3%b1"), "ñ")
+	// Malformed escapes are left as-is.
+	assert.Equal(t, grpcPercentDecode(pool, "100%"), "100%")
+	assert.Equal(t, grpcPercentDecode(pool, "%zz%41"), "%zzA")
+	// Messages that don't decode to UTF-8 are returned raw.
+	assert.Equal(t, grpcPercentDecode(pool, "%FF%FE"), "%FF%FE")
 }
@@ -0,0 +1,240 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// ReconnectingBidiStream is a long-lived bidirectional stream that
+// transparently re-establishes itself when it fails with a retryable code, so
+// subscription-style clients don't each need their own reconnection loop.
+//
+// Each time a stream is opened, including the first, the resubscribe
+// callback runs before any other messages are sent on it. It should send
+// whatever the server needs to resume, like a subscription request with the
+// last-seen offset:
+//
+//	stream := connect.NewReconnectingBidiStream(
+//	  ctx,
+//	  client.Subscribe,
+//	  func(stream *connect.BidiStreamForClient[foov1.SubscribeRequest, foov1.SubscribeResponse]) error {
+//	    return stream.Send(&foov1.SubscribeRequest{Offset: lastOffset})
+//	  },
+//	  connect.RetryPolicy{MaxBackoff: time.Minute},
+//	)
+//	defer stream.Close()
+//	for {
+//	  msg, err := stream.Receive()
+//	  ...
+//	}
+//
+// Reconnection is driven by Receive. The policy's MaxAttempts limits the
+// number of consecutive failed attempts, and the count resets whenever a
+// message arrives. If the policy has a Throttle, each failed stream counts as
+// a failure and each stream that delivers a message counts as one success. Messages passed to Send while the stream is broken are
+// lost, so resubscribe should replay any state the server needs. A stream the
+// server ends cleanly isn't reopened: Receive returns an error wrapping
+// io.EOF.
+//
+// Like BidiStreamForClient, Send and Receive may be called concurrently from
+// two goroutines, and Close is safe to call from any goroutine.
+type ReconnectingBidiStream[Req, Res any] struct {
+	ctx         context.Context
+	cancel      context.CancelFunc
+	open        func(context.Context) *BidiStreamForClient[Req, Res]
+	resubscribe func(*BidiStreamForClient[Req, Res]) error
+	policy      RetryPolicy
+
+	mu       sync.Mutex
+	stream   *BidiStreamForClient[Req, Res]
+	opened   int
+	credited bool // whether the throttle has counted the stream as a success
+}
+
+// NewReconnectingBidiStream constructs a ReconnectingBidiStream. The open
+// function starts a new stream with the supplied context; usually it's a
+// method of a generated client. The resubscribe function may be nil. The
+// first stream isn't opened until the first call to Send or Receive.
+//
+// Canceling ctx, or calling Close, stops the stream for good.
+func NewReconnectingBidiStream[Req, Res any](
+	ctx context.Context,
+	open func(context.Context) *BidiStreamForClient[Req, Res],
+	resubscribe func(*BidiStreamForClient[Req, Res]) error,
+	policy RetryPolicy,
+) *ReconnectingBidiStream[Req, Res] {
+	ctx, cancel := context.WithCancel(ctx)
+	return &ReconnectingBidiStream[Req, Res]{
+		ctx:         ctx,
+		cancel:      cancel,
+		open:        open,
+		resubscribe: resubscribe,
+		policy:      policy.withDefaults(),
+	}
+}
+
+// Send a message on the current stream, opening one if necessary. If the
+// stream has broken, Send returns an error and the message is lost; the next
+// call to Receive reconnects.
+func (r *ReconnectingBidiStream[Req, Res]) Send(msg *Req) error {
+	stream, err := r.current()
+	if err != nil {
+		return err
+	}
+	return stream.Send(msg)
+}
+
+// Receive a message, reconnecting as many times as the policy allows if the
+// stream fails with a retryable code.
+func (r *ReconnectingBidiStream[Req, Res]) Receive() (*Res, error) {
+	for attempt := 1; ; attempt++ {
+		stream, err := r.current()
+		if err == nil {
+			var msg *Res
+			msg, err = stream.Receive()
+			if err == nil {
+				if r.policy.Throttle != nil && r.credit(stream) {
+					r.policy.Throttle.success()
+				}
+				return msg, nil
+			}
+			if errors.Is(err, io.EOF) {
+				return nil, err
+			}
+		}
+		if r.ctx.Err() != nil || !r.policy.retryable(err) {
+			return nil, err
+		}
+		if r.policy.Throttle != nil && !r.policy.Throttle.failure() {
+			return nil, err
+		}
+		if attempt >= r.policy.MaxAttempts {
+			return nil, err
+		}
+		delay, ok := r.policy.delay(attempt, err)
+		if !ok {
+			return nil, err
+		}
+		r.discard(stream)
+		if sleep(r.ctx, r.policy.Clock, delay) != nil {
+			return nil, err
+		}
+	}
+}
+
+// CloseSend closes the send side of the current stream. Streams opened by
+// later reconnections aren't affected.
+func (r *ReconnectingBidiStream[Req, Res]) CloseSend() error {
+	r.mu.Lock()
+	stream := r.stream
+	r.mu.Unlock()
+	if stream == nil {
+		return nil
+	}
+	return stream.CloseSend()
+}
+
+// Close closes the current stream and stops reconnecting. Pending and future
+// calls to Send and Receive fail.
+func (r *ReconnectingBidiStream[Req, Res]) Close() error {
+	r.cancel()
+	r.mu.Lock()
+	stream := r.stream
+	r.mu.Unlock()
+	if stream == nil {
+		return nil
+	}
+	_ = stream.CloseSend()
+	return stream.CloseReceive()
+}
+
+// Reconnects returns the number of times the stream has been reopened after
+// the first connection.
+func (r *ReconnectingBidiStream[Req, Res]) Reconnects() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.opened == 0 {
+		return 0
+	}
+	return r.opened - 1
+}
+
+// current returns the open stream, opening a new one (and resubscribing) if
+// there isn't one. The lock is held while resubscribing, so concurrent sends
+// wait until the resubscription messages have been sent.
+func (r *ReconnectingBidiStream[Req, Res]) current() (*BidiStreamForClient[Req, Res], error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stream != nil {
+		return r.stream, nil
+	}
+	if err := r.ctx.Err(); err != nil {
+		return nil, wrapIfContextError(err)
+	}
+	stream := r.open(r.ctx)
+	r.opened++
+	r.credited = false
+	if r.resubscribe != nil {
+		if err := r.resubscribe(stream); err != nil {
+			if errors.Is(err, io.EOF) {
+				// Send errors wrap io.EOF, and the real error is on the
+				// receive side.
+				if _, receiveErr := stream.Receive(); receiveErr != nil && !errors.Is(receiveErr, io.EOF) {
+					err = receiveErr
+				} else {
+					err = errorf(CodeUnavailable, "resubscribe failed: %v", err)
+				}
+			}
+			_ = stream.CloseSend()
+			_ = stream.CloseReceive()
+			return nil, err
+		}
+	}
+	r.stream = stream
+	return stream, nil
+}
+
+// credit reports whether the stream has yet to be counted as a success by the
+// throttle, marking it as counted. Crediting every message instead would
+// refill the throttle's tokens faster than streams can fail.
+func (r *ReconnectingBidiStream[Req, Res]) credit(stream *BidiStreamForClient[Req, Res]) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stream != stream || r.credited {
+		return false
+	}
+	r.credited = true
+	return true
+}
+
+// discard closes a broken stream, so the next call to current opens a new
+// one. If another goroutine has already replaced it, discard does nothing.
+func (r *ReconnectingBidiStream[Req, Res]) discard(stream *BidiStreamForClient[Req, Res]) {
+	if stream == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stream != stream {
+		return
+	}
+	_ = stream.CloseSend()
+	_ = stream.CloseReceive()
+	r.stream = nil
+}
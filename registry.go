@@ -0,0 +1,181 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"errors"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// typeResolver resolves types with the registry set by WithTypeRegistry, if
+// any, falling back to the global registry for the types it doesn't have.
+// Most well-known types and error details are only in the global registry.
+// Message types that aren't registered anywhere may still be standard error
+// details, which are resolved last.
+type typeResolver struct {
+	types *protoregistry.Types
+}
+
+func (r typeResolver) FindMessageByName(name protoreflect.FullName) (protoreflect.MessageType, error) {
+	if r.types != nil {
+		messageType, err := r.types.FindMessageByName(name)
+		if !errors.Is(err, protoregistry.NotFound) {
+			return messageType, err
+		}
+	}
+	messageType, err := protoregistry.GlobalTypes.FindMessageByName(name)
+	if !errors.Is(err, protoregistry.NotFound) {
+		return messageType, err
+	}
+	standardTypes, err := standardDetailTypes()
+	if err != nil {
+		return nil, err
+	}
+	return standardTypes.FindMessageByName(name)
+}
+
+func (r typeResolver) FindMessageByURL(url string) (protoreflect.MessageType, error) {
+	if r.types != nil {
+		messageType, err := r.types.FindMessageByURL(url)
+		if !errors.Is(err, protoregistry.NotFound) {
+			return messageType, err
+		}
+	}
+	messageType, err := protoregistry.GlobalTypes.FindMessageByURL(url)
+	if !errors.Is(err, protoregistry.NotFound) {
+		return messageType, err
+	}
+	standardTypes, err := standardDetailTypes()
+	if err != nil {
+		return nil, err
+	}
+	return standardTypes.FindMessageByURL(url)
+}
+
+func (r typeResolver) FindExtensionByName(name protoreflect.FullName) (protoreflect.ExtensionType, error) {
+	if r.types != nil {
+		extensionType, err := r.types.FindExtensionByName(name)
+		if !errors.Is(err, protoregistry.NotFound) {
+			return extensionType, err
+		}
+	}
+	return protoregistry.GlobalTypes.FindExtensionByName(name)
+}
+
+func (r typeResolver) FindExtensionByNumber(
+	message protoreflect.FullName,
+	field protoreflect.FieldNumber,
+) (protoreflect.ExtensionType, error) {
+	if r.types != nil {
+		extensionType, err := r.types.FindExtensionByNumber(message, field)
+		if !errors.Is(err, protoregistry.NotFound) {
+			return extensionType, err
+		}
+	}
+	return protoregistry.GlobalTypes.FindExtensionByNumber(message, field)
+}
+
+// copyTypes returns a copy of types. protoregistry.Types isn't safe to use
+// while types are being registered, so clients and handlers use a copy that
+// never changes.
+func copyTypes(types *protoregistry.Types) *protoregistry.Types {
+	frozen := new(protoregistry.Types)
+	types.RangeMessages(func(messageType protoreflect.MessageType) bool {
+		_ = frozen.RegisterMessage(messageType)
+		return true
+	})
+	types.RangeEnums(func(enumType protoreflect.EnumType) bool {
+		_ = frozen.RegisterEnum(enumType)
+		return true
+	})
+	types.RangeExtensions(func(extensionType protoreflect.ExtensionType) bool {
+		_ = frozen.RegisterExtension(extensionType)
+		return true
+	})
+	return frozen
+}
+
+// registerServiceTypes registers the message and enum types used by the
+// services' methods, including those reachable through message fields. Types
+// linked into the binary are registered using their generated
+// implementations; the rest use dynamicpb.
+func registerServiceTypes(types *protoregistry.Types, services []protoreflect.ServiceDescriptor) {
+	visited := make(map[protoreflect.FullName]struct{})
+	for _, service := range services {
+		methods := service.Methods()
+		for i := 0; i < methods.Len(); i++ {
+			method := methods.Get(i)
+			registerMessageType(types, method.Input(), visited)
+			registerMessageType(types, method.Output(), visited)
+		}
+	}
+}
+
+func registerMessageType(
+	types *protoregistry.Types,
+	message protoreflect.MessageDescriptor,
+	visited map[protoreflect.FullName]struct{},
+) {
+	if _, ok := visited[message.FullName()]; ok {
+		return
+	}
+	visited[message.FullName()] = struct{}{}
+	// Map entries are synthetic, so they're never registered, but their values
+	// may still be messages or enums.
+	if !message.IsMapEntry() {
+		if _, err := types.FindMessageByName(message.FullName()); err != nil {
+			messageType, err := protoregistry.GlobalTypes.FindMessageByName(message.FullName())
+			if err != nil {
+				messageType = dynamicpb.NewMessageType(message)
+			}
+			// Registration only fails if the name conflicts with another type,
+			// in which case the existing type wins.
+			_ = types.RegisterMessage(messageType)
+		}
+	}
+	fields := message.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		if fieldMessage := field.Message(); fieldMessage != nil {
+			registerMessageType(types, fieldMessage, visited)
+		}
+		if fieldEnum := field.Enum(); fieldEnum != nil {
+			registerEnumType(types, fieldEnum, visited)
+		}
+	}
+}
+
+func registerEnumType(
+	types *protoregistry.Types,
+	enum protoreflect.EnumDescriptor,
+	visited map[protoreflect.FullName]struct{},
+) {
+	if _, ok := visited[enum.FullName()]; ok {
+		return
+	}
+	visited[enum.FullName()] = struct{}{}
+	if _, err := types.FindEnumByName(enum.FullName()); err == nil {
+		return
+	}
+	enumType, err := protoregistry.GlobalTypes.FindEnumByName(enum.FullName())
+	if err != nil {
+		enumType = dynamicpb.NewEnumType(enum)
+	}
+	// As with messages, the existing type wins any conflict.
+	_ = types.RegisterEnum(enumType)
+}
@@ -0,0 +1,153 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+type responseMetadataContextKey struct{}
+
+// responseMetadata holds the headers and trailers set with SetResponseHeader
+// and SetResponseTrailer. Unary handlers merge them into the response once
+// the implementation returns; streaming handlers write them directly to the
+// stream.
+type responseMetadata struct {
+	header  http.Header
+	trailer http.Header
+}
+
+var errNoResponseMetadata = errors.New("context doesn't belong to a handler")
+
+// SetResponseHeader sets a response header from within a handler, replacing
+// any existing values. It lets implementations that only have a context,
+// like helpers shared between procedures, attach metadata without access to
+// the Response or stream.
+//
+// For unary procedures, the header is set on the response (or the returned
+// error's metadata) when the implementation returns, replacing any values the
+// implementation set itself. For streaming
+// procedures, it's like setting the stream's ResponseHeader: headers set after
+// the first message is sent are ignored. SetResponseHeader returns an error
+// if ctx isn't (and isn't derived from) a handler's context.
+func SetResponseHeader(ctx context.Context, key, value string) error {
+	metadata, ok := ctx.Value(responseMetadataContextKey{}).(*responseMetadata)
+	if !ok {
+		return errNoResponseMetadata
+	}
+	metadata.header.Set(key, value)
+	return nil
+}
+
+// SetResponseTrailer sets a response trailer from within a handler, replacing
+// any existing values. Like SetResponseHeader, it returns an error if ctx
+// isn't (and isn't derived from) a handler's context.
+func SetResponseTrailer(ctx context.Context, key, value string) error {
+	metadata, ok := ctx.Value(responseMetadataContextKey{}).(*responseMetadata)
+	if !ok {
+		return errNoResponseMetadata
+	}
+	metadata.trailer.Set(key, value)
+	return nil
+}
+
+// withResponseMetadata attaches a responseMetadata that writes to the
+// supplied maps.
+func withResponseMetadata(ctx context.Context, header, trailer http.Header) (context.Context, *responseMetadata) {
+	metadata := &responseMetadata{header: header, trailer: trailer}
+	return context.WithValue(ctx, responseMetadataContextKey{}, metadata), metadata
+}
+
+// withStreamResponseMetadata lets SetResponseHeader and SetResponseTrailer
+// write directly to a stream's headers and trailers.
+func withStreamResponseMetadata(ctx context.Context, sender Sender) context.Context {
+	trailer, ok := sender.Trailer()
+	if !ok {
+		// Protocols without trailers send errors' metadata another way, and
+		// have nowhere to put successful streams' trailers.
+		trailer = make(http.Header)
+	}
+	ctx, _ = withResponseMetadata(ctx, sender.Header(), trailer)
+	return ctx
+}
+
+// withUnaryResponseMetadata wraps a unary implementation so that metadata set
+// from its context is set on its response or error.
+func withUnaryResponseMetadata[Req, Res any](
+	unary func(context.Context, *Request[Req]) (*Response[Res], error),
+) func(context.Context, *Request[Req]) (*Response[Res], error) {
+	return func(ctx context.Context, request *Request[Req]) (*Response[Res], error) {
+		ctx, metadata := withResponseMetadata(ctx, make(http.Header), make(http.Header))
+		response, err := unary(ctx, request)
+		if err != nil {
+			return response, metadata.mergeIntoError(err)
+		}
+		if response != nil {
+			replaceHeaders(response.Header(), metadata.header)
+			replaceHeaders(response.Trailer(), metadata.trailer)
+		}
+		return response, nil
+	}
+}
+
+// mergeIntoError sets the metadata on a copy of the error's metadata, so
+// sentinel errors shared between calls aren't modified. The copy is attached
+// with a wrapper, so the error keeps its original wrapping chain.
+func (m *responseMetadata) mergeIntoError(err error) error {
+	if len(m.header) == 0 && len(m.trailer) == 0 {
+		return err
+	}
+	connectErr, ok := asError(wrapIfUncoded(err))
+	if !ok {
+		return err
+	}
+	clone := *connectErr
+	clone.meta = connectErr.Meta().Clone()
+	replaceHeaders(clone.meta, m.header)
+	replaceHeaders(clone.meta, m.trailer)
+	return &responseMetadataError{error: err, withMetadata: &clone}
+}
+
+// responseMetadataError attaches metadata to an error. Like callStatsError,
+// it's transparent except to errors.As, which finds the copy of the *Error
+// with the metadata set.
+type responseMetadataError struct {
+	error
+
+	withMetadata *Error
+}
+
+func (e *responseMetadataError) Unwrap() error {
+	return e.error
+}
+
+func (e *responseMetadataError) As(target any) bool {
+	connectErr, ok := target.(**Error)
+	if !ok {
+		return false
+	}
+	*connectErr = e.withMetadata
+	return true
+}
+
+// replaceHeaders copies each key in from to into, replacing any existing
+// values.
+func replaceHeaders(into, from http.Header) {
+	for key, values := range from {
+		into[key] = values
+	}
+}
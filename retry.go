@@ -0,0 +1,246 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const headerRetryPushback = HeaderGRPCRetryPushback
+
+const (
+	defaultRetryMaxAttempts     = 3
+	defaultRetryInitialBackoff  = 100 * time.Millisecond
+	defaultRetryMaxBackoff      = 5 * time.Second
+	defaultRetryBackoffMultiple = 2
+	// Like gRPC, we limit retries to prevent misconfigured clients from
+	// overwhelming servers.
+	maxRetryAttempts = 5
+)
+
+// RetryPolicy configures how a client retries failed unary calls. It follows
+// the design of gRPC's retry policies. Zero values use the defaults documented
+// on each field.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the original
+	// call. It defaults to 3, and values greater than 5 are treated as 5.
+	MaxAttempts int
+	// InitialBackoff, MaxBackoff, and BackoffMultiplier control the delay
+	// between attempts. Before the nth retry, clients wait for a random
+	// duration between zero and min(InitialBackoff*BackoffMultiplier^(n-1),
+	// MaxBackoff). They default to 100ms, 5s, and 2.
+	InitialBackoff    time.Duration
+	MaxBackoff        time.Duration
+	BackoffMultiplier float64
+	// RetryableCodes lists the error codes that are safe to retry. It defaults
+	// to CodeUnavailable.
+	RetryableCodes []Code
+	// Throttle, if non-nil, limits retries when many calls are failing. To
+	// make clients collectively back off from a struggling backend, share one
+	// RetryThrottle between all the policies that call the same target.
+	Throttle *RetryThrottle
+	// Idempotency overrides the idempotency level of individual procedures,
+	// keyed by procedure (for example, "/acme.foo.v1.FooService/Bar"). Calls
+	// are only retried if their procedure's level is IdempotencyNoSideEffects
+	// or IdempotencyIdempotent; procedures not listed here use the level in
+	// their Spec (see WithIdempotency). List a procedure to retry it even
+	// though it isn't marked as safe to repeat, or map it to
+	// IdempotencyUnknown to stop retrying it.
+	Idempotency map[string]IdempotencyLevel
+	// Clock times the delay between attempts. It defaults to the system clock.
+	Clock Clock
+}
+
+// RetryThrottle implements gRPC's token-based retry throttling. Each throttle
+// starts with maxTokens tokens. Every attempt that fails with a retryable code
+// removes one token, and every successful call adds tokenRatio tokens (up to
+// maxTokens). Once the number of tokens is at or below half of maxTokens,
+// failed calls aren't retried until enough calls succeed.
+//
+// A RetryThrottle is safe to use concurrently.
+type RetryThrottle struct {
+	// Like gRPC, we store tokens in thousandths to avoid floating-point drift.
+	maxTokens  int64
+	threshold  int64
+	tokenRatio int64
+
+	mu     sync.Mutex
+	tokens int64
+}
+
+// NewRetryThrottle constructs a RetryThrottle. As in gRPC, maxTokens must be in
+// (0, 1000] and tokenRatio must be positive; out-of-range values are clamped.
+// Only three decimal places of tokenRatio are significant.
+func NewRetryThrottle(maxTokens int, tokenRatio float64) *RetryThrottle {
+	if maxTokens <= 0 {
+		maxTokens = 1
+	}
+	if maxTokens > 1000 {
+		maxTokens = 1000
+	}
+	ratio := int64(tokenRatio * 1000)
+	if ratio <= 0 {
+		ratio = 1
+	}
+	limit := int64(maxTokens) * 1000
+	return &RetryThrottle{
+		maxTokens:  limit,
+		threshold:  limit / 2,
+		tokenRatio: ratio,
+		tokens:     limit,
+	}
+}
+
+// failure records a failed attempt and reports whether retries are still
+// allowed.
+func (t *RetryThrottle) failure() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tokens -= 1000
+	if t.tokens < 0 {
+		t.tokens = 0
+	}
+	return t.tokens > t.threshold
+}
+
+func (t *RetryThrottle) success() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tokens += t.tokenRatio
+	if t.tokens > t.maxTokens {
+		t.tokens = t.maxTokens
+	}
+}
+
+// NewRetryInterceptor constructs a client-side Interceptor that retries unary
+// calls that fail with a retryable code. Calls aren't retried after their
+// context is done, and streaming calls are never retried.
+//
+// Retrying a call with side effects may repeat them, so only procedures that
+// are safe to repeat are retried. Code generated by protoc-gen-connect-go
+// marks methods with an idempotency_level of NO_SIDE_EFFECTS or IDEMPOTENT;
+// other procedures can be marked with WithIdempotency or the policy's
+// Idempotency overrides.
+//
+// Servers may control retries with the grpc-retry-pushback-ms header or
+// trailer. A non-negative integer tells the client to wait that many
+// milliseconds before the next attempt, instead of using the policy's backoff.
+// Any other value tells the client not to retry.
+//
+// Retries reuse the request's headers and message, so clients constructed
+// with WithIdempotencyKey send the same key with every attempt.
+func NewRetryInterceptor(policy RetryPolicy) Interceptor {
+	policy = policy.withDefaults()
+	return UnaryInterceptorFunc(func(next UnaryFunc) UnaryFunc {
+		return func(ctx context.Context, request AnyRequest) (AnyResponse, error) {
+			if !policy.eligible(request.Spec()) {
+				return next(ctx, request)
+			}
+			for attempt := 1; ; attempt++ {
+				response, err := next(ctx, request)
+				if err == nil {
+					if policy.Throttle != nil {
+						policy.Throttle.success()
+					}
+					return response, err
+				}
+				if !policy.retryable(err) {
+					return response, err
+				}
+				if policy.Throttle != nil && !policy.Throttle.failure() {
+					return response, err
+				}
+				if attempt >= policy.MaxAttempts {
+					return response, err
+				}
+				delay, ok := policy.delay(attempt, err)
+				if !ok {
+					return response, err
+				}
+				if sleep(ctx, policy.Clock, delay) != nil {
+					return response, err
+				}
+			}
+		}
+	})
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = defaultRetryMaxAttempts
+	}
+	if p.Clock == nil {
+		p.Clock = systemClock{}
+	}
+	if p.MaxAttempts > maxRetryAttempts {
+		p.MaxAttempts = maxRetryAttempts
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = defaultRetryInitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = defaultRetryMaxBackoff
+	}
+	if p.BackoffMultiplier <= 0 {
+		p.BackoffMultiplier = defaultRetryBackoffMultiple
+	}
+	if len(p.RetryableCodes) == 0 {
+		p.RetryableCodes = []Code{CodeUnavailable}
+	}
+	return p
+}
+
+// eligible reports whether calls to the procedure may be retried.
+func (p RetryPolicy) eligible(spec Spec) bool {
+	level, ok := p.Idempotency[spec.Procedure]
+	if !ok {
+		level = spec.IdempotencyLevel
+	}
+	return level.safeToRepeat()
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	code := CodeOf(err)
+	for _, retryable := range p.RetryableCodes {
+		if code == retryable {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns how long to wait before the next attempt, and false if the
+// server asked us not to retry.
+func (p RetryPolicy) delay(attempt int, err error) (time.Duration, bool) {
+	if connectErr, ok := asError(err); ok {
+		if values := connectErr.Meta().Values(headerRetryPushback); len(values) > 0 {
+			millis, parseErr := strconv.ParseInt(values[0], 10 /* base */, 64 /* bitsize */)
+			if parseErr != nil || millis < 0 {
+				return 0, false
+			}
+			return time.Duration(millis) * time.Millisecond, true
+		}
+	}
+	backoff := float64(p.InitialBackoff) * math.Pow(p.BackoffMultiplier, float64(attempt-1))
+	if backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1)), true //nolint:gosec // jitter doesn't need a secure RNG
+}
@@ -0,0 +1,63 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import "net/http"
+
+// HTTPClientFunc is an adapter that lets ordinary functions act as
+// HTTPClients.
+type HTTPClientFunc func(*http.Request) (*http.Response, error)
+
+// Do implements HTTPClient.
+func (f HTTPClientFunc) Do(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
+
+// RoundTripMiddleware wraps the HTTPClient used by a connect client, so it
+// can inspect or modify every HTTP request and response: for example, to sign
+// requests, inject headers, or record traffic. Unlike interceptors,
+// middleware sees the raw HTTP exchange, including protocol headers.
+//
+// Request bodies are streamed through a pipe while the call is in progress.
+// Middleware may read the whole body of unary and client streaming calls, as
+// long as it replaces request.Body before calling the next HTTPClient, but
+// reading the whole body of a bidirectional stream blocks forever. Likewise,
+// response bodies should be wrapped rather than read up front.
+type RoundTripMiddleware func(next HTTPClient) HTTPClient
+
+// ChainRoundTripMiddleware composes middleware into one. The first
+// middleware is the outermost: it sees requests first and responses last.
+func ChainRoundTripMiddleware(middleware ...RoundTripMiddleware) RoundTripMiddleware {
+	return func(next HTTPClient) HTTPClient {
+		for i := len(middleware) - 1; i >= 0; i-- {
+			if middleware[i] != nil {
+				next = middleware[i](next)
+			}
+		}
+		return next
+	}
+}
+
+// HeaderMiddleware returns middleware that adds the supplied headers to every
+// request, keeping any values already set.
+func HeaderMiddleware(header http.Header) RoundTripMiddleware {
+	header = header.Clone()
+	return func(next HTTPClient) HTTPClient {
+		return HTTPClientFunc(func(request *http.Request) (*http.Response, error) {
+			mergeHeaders(request.Header, header)
+			return next.Do(request)
+		})
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ServiceConfig configures clients on a per-method basis, in the style of
+// gRPC's service config. Use it with WithServiceConfig, which lets operators
+// tune timeouts, retries, and message sizes without changing code.
+type ServiceConfig struct {
+	// MethodConfigs lists the per-method settings. Each client uses the most
+	// specific MethodConfig that matches its procedure: one that names both
+	// the service and method, then one that names only the service, then one
+	// with an empty MethodName.
+	MethodConfigs []MethodConfig
+	// RetryThrottle, if non-nil, is shared by every retry policy in the config
+	// that doesn't have its own throttle.
+	RetryThrottle *RetryThrottle
+}
+
+// MethodConfig holds the settings for one or more methods. Zero values leave
+// the client's default behavior unchanged.
+type MethodConfig struct {
+	Names []MethodName
+	// Timeout caps the duration of each call. If the call's context has an
+	// earlier deadline, that deadline is used instead.
+	Timeout time.Duration
+	// RetryPolicy, if non-nil, retries failed unary calls. See
+	// NewRetryInterceptor.
+	RetryPolicy *RetryPolicy
+	// MaxRequestBytes and MaxResponseBytes limit the size of each marshaled,
+	// uncompressed message. Oversized messages fail with
+	// CodeResourceExhausted.
+	MaxRequestBytes  int
+	MaxResponseBytes int
+}
+
+// MethodName identifies the methods a MethodConfig applies to. Service is the
+// fully-qualified Protobuf service name (for example, "acme.foo.v1.FooService")
+// and Method is the method name. Leaving Method empty matches every method of
+// the service, and leaving both empty matches every method.
+type MethodName struct {
+	Service string `json:"service"`
+	Method  string `json:"method"`
+}
+
+// ParseServiceConfig parses a gRPC service config in its JSON form. It
+// supports the methodConfig (name, timeout, maxRequestMessageBytes,
+// maxResponseMessageBytes, and retryPolicy) and retryThrottling fields, and it
+// ignores the rest. For example:
+//
+//	{
+//	  "methodConfig": [{
+//	    "name": [{"service": "acme.foo.v1.FooService"}],
+//	    "timeout": "1.5s",
+//	    "retryPolicy": {
+//	      "maxAttempts": 4,
+//	      "initialBackoff": "0.1s",
+//	      "maxBackoff": "1s",
+//	      "backoffMultiplier": 2,
+//	      "retryableStatusCodes": ["UNAVAILABLE"]
+//	    }
+//	  }],
+//	  "retryThrottling": {"maxTokens": 10, "tokenRatio": 0.1}
+//	}
+func ParseServiceConfig(data []byte) (*ServiceConfig, error) {
+	var raw serviceConfigJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid service config: %w", err)
+	}
+	config := &ServiceConfig{}
+	if throttling := raw.RetryThrottling; throttling != nil {
+		config.RetryThrottle = NewRetryThrottle(throttling.MaxTokens, throttling.TokenRatio)
+	}
+	for i, rawMethod := range raw.MethodConfig {
+		method := MethodConfig{
+			Names:            rawMethod.Name,
+			MaxRequestBytes:  rawMethod.MaxRequestMessageBytes,
+			MaxResponseBytes: rawMethod.MaxResponseMessageBytes,
+		}
+		var err error
+		if method.Timeout, err = parseServiceConfigDuration(rawMethod.Timeout); err != nil {
+			return nil, fmt.Errorf("invalid service config: methodConfig[%d].timeout: %w", i, err)
+		}
+		if rawMethod.RetryPolicy != nil {
+			if method.RetryPolicy, err = rawMethod.RetryPolicy.policy(); err != nil {
+				return nil, fmt.Errorf("invalid service config: methodConfig[%d].retryPolicy: %w", i, err)
+			}
+		}
+		config.MethodConfigs = append(config.MethodConfigs, method)
+	}
+	return config, nil
+}
+
+// methodConfig returns the most specific MethodConfig for the procedure, or
+// nil if none match.
+func (c *ServiceConfig) methodConfig(procedure string) *MethodConfig {
+	service, method := procedure, ""
+	if i := strings.LastIndexByte(procedure, '/'); i >= 0 {
+		service, method = strings.TrimPrefix(procedure[:i], "/"), procedure[i+1:]
+	}
+	var serviceMatch, defaultMatch *MethodConfig
+	for i := range c.MethodConfigs {
+		config := &c.MethodConfigs[i]
+		for _, name := range config.Names {
+			switch {
+			case name.Service == service && name.Method == method:
+				return config
+			case name.Service == service && name.Method == "" && serviceMatch == nil:
+				serviceMatch = config
+			case name.Service == "" && name.Method == "" && defaultMatch == nil:
+				defaultMatch = config
+			}
+		}
+	}
+	if serviceMatch != nil {
+		return serviceMatch
+	}
+	return defaultMatch
+}
+
+// retryPolicy returns the method's retry policy, using the service config's
+// throttle if the policy doesn't have one.
+func (c *ServiceConfig) retryPolicy(method *MethodConfig) *RetryPolicy {
+	if method.RetryPolicy == nil {
+		return nil
+	}
+	policy := *method.RetryPolicy
+	if policy.Throttle == nil {
+		policy.Throttle = c.RetryThrottle
+	}
+	return &policy
+}
+
+type serviceConfigJSON struct {
+	MethodConfig []struct {
+		Name                    []MethodName     `json:"name"`
+		Timeout                 string           `json:"timeout"`
+		MaxRequestMessageBytes  int              `json:"maxRequestMessageBytes"`
+		MaxResponseMessageBytes int              `json:"maxResponseMessageBytes"`
+		RetryPolicy             *retryPolicyJSON `json:"retryPolicy"`
+	} `json:"methodConfig"`
+	RetryThrottling *struct {
+		MaxTokens  int     `json:"maxTokens"`
+		TokenRatio float64 `json:"tokenRatio"`
+	} `json:"retryThrottling"`
+}
+
+type retryPolicyJSON struct {
+	MaxAttempts          int               `json:"maxAttempts"`
+	InitialBackoff       string            `json:"initialBackoff"`
+	MaxBackoff           string            `json:"maxBackoff"`
+	BackoffMultiplier    float64           `json:"backoffMultiplier"`
+	RetryableStatusCodes []json.RawMessage `json:"retryableStatusCodes"`
+}
+
+func (r *retryPolicyJSON) policy() (*RetryPolicy, error) {
+	policy := &RetryPolicy{
+		MaxAttempts:       r.MaxAttempts,
+		BackoffMultiplier: r.BackoffMultiplier,
+	}
+	var err error
+	if policy.InitialBackoff, err = parseServiceConfigDuration(r.InitialBackoff); err != nil {
+		return nil, fmt.Errorf("initialBackoff: %w", err)
+	}
+	if policy.MaxBackoff, err = parseServiceConfigDuration(r.MaxBackoff); err != nil {
+		return nil, fmt.Errorf("maxBackoff: %w", err)
+	}
+	for _, rawCode := range r.RetryableStatusCodes {
+		code, err := parseServiceConfigCode(rawCode)
+		if err != nil {
+			return nil, fmt.Errorf("retryableStatusCodes: %w", err)
+		}
+		policy.RetryableCodes = append(policy.RetryableCodes, code)
+	}
+	return policy, nil
+}
+
+// parseServiceConfigDuration parses durations in the Protobuf JSON format,
+// like "1.5s". Empty strings are zero.
+func parseServiceConfigDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if !strings.HasSuffix(value, "s") {
+		return 0, fmt.Errorf("duration %q must end in \"s\"", value)
+	}
+	return time.ParseDuration(value)
+}
+
+// parseServiceConfigCode parses status codes, which gRPC allows to be either
+// integers or upper-case names like "UNAVAILABLE".
+func parseServiceConfigCode(raw json.RawMessage) (Code, error) {
+	var number int
+	if err := json.Unmarshal(raw, &number); err == nil {
+		if number < int(minCode) || number > int(maxCode) {
+			return 0, fmt.Errorf("invalid code %d", number)
+		}
+		return Code(number), nil
+	}
+	var name string
+	if err := json.Unmarshal(raw, &name); err != nil {
+		return 0, fmt.Errorf("invalid code %s", raw)
+	}
+	var code Code
+	if err := code.UnmarshalText([]byte(strings.ToLower(name))); err != nil {
+		return 0, err
+	}
+	return code, nil
+}
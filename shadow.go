@@ -0,0 +1,175 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bufbuild/connect-go/internal/rawproto"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultShadowTimeout        = 10 * time.Second
+	defaultShadowMaxConcurrency = 100
+)
+
+// ShadowConfig configures NewShadowInterceptor. Zero values use the defaults
+// documented on each field, except for SampleRate: nothing is mirrored until
+// it's set.
+type ShadowConfig struct {
+	// HTTPClient and BaseURL identify the shadow backend, as in NewClient. A
+	// mirrored call to "/acme.foo.v1.FooService/Bar" goes to
+	// BaseURL+"/acme.foo.v1.FooService/Bar". HTTPClient defaults to
+	// http.DefaultClient.
+	HTTPClient HTTPClient
+	BaseURL    string
+	// Options configure the clients that call the shadow backend. Mirrored
+	// requests are always sent as binary Protobuf, so codec options are
+	// ignored.
+	Options []ClientOption
+	// SampleRate is the fraction of requests to mirror, between 0 and 1. Zero
+	// mirrors no requests, and 1 mirrors every request.
+	SampleRate float64
+	// StripHeaders are the request headers that aren't mirrored, so that
+	// credentials for the production service don't reach the shadow. It
+	// defaults to Authorization, Proxy-Authorization, and Cookie. To mirror
+	// every header, set it to an empty, non-nil slice.
+	StripHeaders []string
+	// Timeout bounds each mirrored call. It defaults to 10 seconds.
+	Timeout time.Duration
+	// MaxConcurrency limits the number of mirrored calls in flight. Once it's
+	// reached, requests aren't mirrored until some calls finish, so a slow
+	// shadow can't exhaust the server's memory. It defaults to 100.
+	MaxConcurrency int
+	// OnComplete, if non-nil, is called with the outcome of each mirrored
+	// call, for example to count errors from the shadow. It's called from the
+	// mirrored call's goroutine.
+	OnComplete func(spec Spec, err error)
+}
+
+// NewShadowInterceptor constructs a handler interceptor that asynchronously
+// mirrors a sample of unary requests, including their headers other than
+// credentials, to a shadow backend. Responses from the shadow are discarded,
+// and the mirrored calls never delay or affect the response to the original
+// caller, so production traffic can safely soak-test a new implementation.
+//
+// Only unary requests with Protobuf messages are mirrored. The interceptor
+// has no effect on clients.
+func NewShadowInterceptor(config ShadowConfig) Interceptor {
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+	if config.StripHeaders == nil {
+		config.StripHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultShadowTimeout
+	}
+	if config.MaxConcurrency <= 0 {
+		config.MaxConcurrency = defaultShadowMaxConcurrency
+	}
+	shadow := &shadowInterceptor{
+		config:    config,
+		baseURL:   strings.TrimRight(config.BaseURL, "/"),
+		strip:     make(map[string]struct{}, len(config.StripHeaders)),
+		semaphore: make(chan struct{}, config.MaxConcurrency),
+	}
+	for _, key := range config.StripHeaders {
+		shadow.strip[http.CanonicalHeaderKey(key)] = struct{}{}
+	}
+	return UnaryInterceptorFunc(shadow.wrapUnary)
+}
+
+type shadowInterceptor struct {
+	config    ShadowConfig
+	baseURL   string
+	strip     map[string]struct{} // canonical header keys
+	semaphore chan struct{}
+	clients   copyOnWriteMap[*Client[rawproto.Message, rawproto.Message]]
+}
+
+func (s *shadowInterceptor) wrapUnary(next UnaryFunc) UnaryFunc {
+	return func(ctx context.Context, request AnyRequest) (AnyResponse, error) {
+		if !request.Spec().IsClient {
+			s.mirror(request)
+		}
+		return next(ctx, request)
+	}
+}
+
+// mirror starts a call to the shadow backend if the request is sampled and
+// there's capacity. It marshals the request before returning, since the
+// handler may mutate the message.
+func (s *shadowInterceptor) mirror(request AnyRequest) {
+	if s.config.SampleRate < 1 && rand.Float64() >= s.config.SampleRate { //nolint:gosec
+		return
+	}
+	message, ok := request.Any().(proto.Message)
+	if !ok {
+		return
+	}
+	data, err := proto.Marshal(message)
+	if err != nil {
+		return
+	}
+	select {
+	case s.semaphore <- struct{}{}:
+	default:
+		return
+	}
+	spec := request.Spec()
+	shadowRequest := NewRequest((*rawproto.Message)(&data))
+	for key, values := range request.Header() {
+		if _, ok := s.strip[key]; ok || isProtocolHeader(key) || isReservedHeader(key) {
+			continue
+		}
+		shadowRequest.Header()[key] = append([]string(nil), values...)
+	}
+	go func() {
+		defer func() { <-s.semaphore }()
+		// The original request's context is canceled as soon as the handler
+		// returns, so the mirrored call gets its own.
+		ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
+		defer cancel()
+		_, err := s.client(spec.Procedure).CallUnary(ctx, shadowRequest)
+		if s.config.OnComplete != nil {
+			s.config.OnComplete(spec, err)
+		}
+	}()
+}
+
+func (s *shadowInterceptor) client(procedure string) *Client[rawproto.Message, rawproto.Message] {
+	if client, ok := s.clients.load()[procedure]; ok {
+		return client
+	}
+	var client *Client[rawproto.Message, rawproto.Message]
+	s.clients.update(func(clients map[string]*Client[rawproto.Message, rawproto.Message]) {
+		if existing, ok := clients[procedure]; ok {
+			client = existing
+			return
+		}
+		options := make([]ClientOption, 0, len(s.config.Options)+1)
+		options = append(options, s.config.Options...)
+		options = append(options, WithCodec(rawproto.Codec{}))
+		client = NewClient[rawproto.Message, rawproto.Message](s.config.HTTPClient, s.baseURL+procedure, options...)
+		clients[procedure] = client
+	})
+	return client
+}
@@ -0,0 +1,122 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// slowRequestLog logs unary calls and stream setups that take longer than a
+// threshold. Unlike a logging interceptor, it only writes when something is
+// slow, so it's cheap enough to leave enabled in production.
+type slowRequestLog struct {
+	threshold time.Duration
+	logger    func(context.Context, string)
+	clock     Clock // set once all options are applied
+}
+
+// start returns a function that logs the operation if it's slow. Deadline
+// information is captured immediately, since the context's remaining time
+// shrinks while the operation runs.
+func (l *slowRequestLog) start(ctx context.Context, spec Spec, peer string) func() {
+	start := l.clock.Now()
+	deadline := "no deadline"
+	if at, ok := ctx.Deadline(); ok {
+		deadline = fmt.Sprintf("deadline %v", at.Sub(start).Round(time.Millisecond))
+	}
+	return func() {
+		elapsed := l.clock.Now().Sub(start)
+		if elapsed < l.threshold {
+			return
+		}
+		what := "stream setup"
+		if spec.StreamType == StreamTypeUnary {
+			what = "unary call"
+		}
+		if peer == "" {
+			peer = "unknown"
+		}
+		l.logger(ctx, fmt.Sprintf(
+			"slow %s %s: took %v (threshold %v), peer %s, %s",
+			what,
+			spec.Procedure,
+			elapsed.Round(time.Millisecond),
+			l.threshold,
+			peer,
+			deadline,
+		))
+	}
+}
+
+// slowLogSender logs slow stream setups on the server. The stream is set up
+// once the handler sends its first message or closes the stream.
+type slowLogSender struct {
+	Sender
+
+	once   sync.Once
+	finish func()
+}
+
+func (s *slowLogSender) Send(msg any) error {
+	s.once.Do(s.finish)
+	return s.Sender.Send(msg)
+}
+
+func (s *slowLogSender) sendUncompressed(msg any) error {
+	s.once.Do(s.finish)
+	return sendUncompressed(s.Sender, msg)
+}
+
+func (s *slowLogSender) sendBatch(messages []any) error {
+	s.once.Do(s.finish)
+	return sendBatch(s.Sender, messages)
+}
+
+func (s *slowLogSender) Close(err error) error {
+	s.once.Do(s.finish)
+	return s.Sender.Close(err)
+}
+
+// slowLogReceiver logs slow stream setups on the client. The stream is set up
+// once the first message (or error) arrives from the server.
+type slowLogReceiver struct {
+	Receiver
+
+	once   sync.Once
+	finish func()
+}
+
+func (r *slowLogReceiver) Receive(msg any) error {
+	err := r.Receiver.Receive(msg)
+	r.once.Do(r.finish)
+	return err
+}
+
+func (r *slowLogReceiver) readAhead() {
+	readAhead(r.Receiver)
+}
+
+// peerFromURL returns the host (and port, if any) that a client dials.
+func peerFromURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return parsed.Host
+}
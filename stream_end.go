@@ -0,0 +1,130 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// A StreamEndReason describes why a stream (or unary call) ended. Codes alone
+// can't tell a server that finished with CodeUnavailable apart from a
+// connection that died, so consumers that reconnect, retry, or alert
+// differently in those cases should use StreamEndReasonOf instead.
+type StreamEndReason uint8
+
+const (
+	// StreamEndUnknown means the reason couldn't be determined, for example
+	// because the error didn't come from connect.
+	StreamEndUnknown StreamEndReason = iota
+	// StreamEndPeerClosed means the peer finished the stream cleanly: the
+	// server returned successfully, or the client closed its send side.
+	StreamEndPeerClosed
+	// StreamEndPeerError means the peer deliberately ended the stream with an
+	// error, whatever its code.
+	StreamEndPeerError
+	// StreamEndLocalClose means this side closed the stream before the peer
+	// finished it, for example by calling CloseResponse or CloseReceive early.
+	StreamEndLocalClose
+	// StreamEndDeadline means the call's deadline expired.
+	StreamEndDeadline
+	// StreamEndCanceled means the call's context was canceled.
+	StreamEndCanceled
+	// StreamEndNetwork means the connection failed: it couldn't be
+	// established, or it broke while the stream was in progress.
+	StreamEndNetwork
+	// StreamEndProtocol means the peer sent data that violates the protocol,
+	// like a malformed envelope or trailers.
+	StreamEndProtocol
+)
+
+func (r StreamEndReason) String() string {
+	switch r {
+	case StreamEndUnknown:
+		return "unknown"
+	case StreamEndPeerClosed:
+		return "peer_closed"
+	case StreamEndPeerError:
+		return "peer_error"
+	case StreamEndLocalClose:
+		return "local_close"
+	case StreamEndDeadline:
+		return "deadline"
+	case StreamEndCanceled:
+		return "canceled"
+	case StreamEndNetwork:
+		return "network"
+	case StreamEndProtocol:
+		return "protocol"
+	}
+	return fmt.Sprintf("stream_end_reason_%d", uint8(r))
+}
+
+// StreamEndReasonOf returns the reason a stream ended, given the final error
+// from Receive, CloseResponse, CloseRequest, or a unary call. A nil error or
+// one wrapping io.EOF means the peer finished cleanly. Errors from Send wrap
+// io.EOF whenever the peer has ended the stream, so use the error from the
+// receive side to learn why.
+func StreamEndReasonOf(err error) StreamEndReason {
+	if err == nil {
+		return StreamEndPeerClosed
+	}
+	if connectErr, ok := asError(err); ok && connectErr.endReason != StreamEndUnknown {
+		return connectErr.endReason
+	}
+	if errors.Is(err, io.EOF) {
+		return StreamEndPeerClosed
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), CodeOf(err) == CodeDeadlineExceeded:
+		return StreamEndDeadline
+	case errors.Is(err, context.Canceled), CodeOf(err) == CodeCanceled:
+		return StreamEndCanceled
+	}
+	var netErr net.Error
+	if isTransportError(err) || errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return StreamEndNetwork
+	}
+	return StreamEndUnknown
+}
+
+// withEndReason records why the stream ended. It's only used where the
+// reason can't be inferred from the error's code or cause.
+func (e *Error) withEndReason(reason StreamEndReason) *Error {
+	e.endReason = reason
+	return e
+}
+
+// transportError wraps errors from reading the response body, so they can be
+// told apart from errors produced by connect itself.
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transportError) Unwrap() error {
+	return e.err
+}
+
+func isTransportError(err error) bool {
+	var transportErr *transportError
+	return errors.As(err, &transportErr)
+}
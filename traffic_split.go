@@ -0,0 +1,261 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// TargetGroup is a set of interchangeable targets, like the instances running
+// one version of a service.
+type TargetGroup struct {
+	// Name identifies the group in SetWeights, for example "stable" or
+	// "canary".
+	Name string
+	// Targets are the base URLs of the group's instances, like
+	// "https://canary-1.acme.internal:8443". Requests are spread across them
+	// round-robin.
+	Targets []string
+	// Weight is the group's share of traffic, relative to the other groups.
+	// Percentages are usually clearest: a canary rollout might start with
+	// weights of 99 and 1.
+	Weight int
+}
+
+// TrafficSplitterConfig configures a TrafficSplitter.
+type TrafficSplitterConfig struct {
+	// Groups are the target groups to split traffic between.
+	Groups []TargetGroup
+	// StickyHeader, if set, names a request header (like a user or session ID)
+	// whose value consistently selects the same group. Requests without the
+	// header are assigned randomly.
+	StickyHeader string
+	// HTTPClient sends the requests. It defaults to http.DefaultClient.
+	HTTPClient HTTPClient
+}
+
+// TrafficSplitter is an HTTPClient that splits traffic between groups of
+// targets by weight, so canary rollouts can be controlled from the calling
+// side without a service mesh or load balancer. It replaces the scheme and
+// host of each request's URL with those of a target from the chosen group, so
+// clients using it can be constructed with any base URL:
+//
+//	splitter, err := connect.NewTrafficSplitter(connect.TrafficSplitterConfig{
+//	  Groups: []connect.TargetGroup{
+//	    {Name: "stable", Targets: []string{"https://stable.acme.internal"}, Weight: 95},
+//	    {Name: "canary", Targets: []string{"https://canary.acme.internal"}, Weight: 5},
+//	  },
+//	  StickyHeader: "User-Id",
+//	})
+//	client := foov1connect.NewFooServiceClient(splitter, "https://foo.acme.internal")
+//
+// Weights can be adjusted while requests are in flight with SetWeights. A
+// TrafficSplitter is safe to use concurrently.
+type TrafficSplitter struct {
+	httpClient   HTTPClient
+	stickyHeader string
+	groups       []*splitGroup
+
+	mu      sync.Mutex   // serializes SetWeights
+	weights atomic.Value // []int, cumulative weights indexed like groups
+}
+
+type splitGroup struct {
+	// 64-bit atomics must be 64-bit aligned, which is only guaranteed for the
+	// first word of an allocated struct on 32-bit platforms.
+	next    uint64 // atomic, round-robin counter
+	name    string
+	targets []*url.URL
+}
+
+// NewTrafficSplitter constructs a TrafficSplitter. It returns an error if
+// there are no groups, if a group has no targets or an invalid target URL, or
+// if the weights are invalid.
+func NewTrafficSplitter(config TrafficSplitterConfig) (*TrafficSplitter, error) {
+	if len(config.Groups) == 0 {
+		return nil, errors.New("traffic splitter has no target groups")
+	}
+	splitter := &TrafficSplitter{
+		httpClient:   config.HTTPClient,
+		stickyHeader: config.StickyHeader,
+		groups:       make([]*splitGroup, 0, len(config.Groups)),
+	}
+	if splitter.httpClient == nil {
+		splitter.httpClient = http.DefaultClient
+	}
+	weights := make(map[string]int, len(config.Groups))
+	for _, group := range config.Groups {
+		if _, ok := weights[group.Name]; ok {
+			return nil, fmt.Errorf("duplicate target group %q", group.Name)
+		}
+		weights[group.Name] = group.Weight
+		if len(group.Targets) == 0 {
+			return nil, fmt.Errorf("target group %q has no targets", group.Name)
+		}
+		split := &splitGroup{name: group.Name}
+		for _, target := range group.Targets {
+			parsed, err := parseTarget(target)
+			if err != nil {
+				return nil, fmt.Errorf("target group %q: %w", group.Name, err)
+			}
+			split.targets = append(split.targets, parsed)
+		}
+		splitter.groups = append(splitter.groups, split)
+	}
+	if err := splitter.SetWeights(weights); err != nil {
+		return nil, err
+	}
+	return splitter, nil
+}
+
+// SetWeights changes the weights of the named groups. Groups not mentioned
+// keep their current weights. Weights must not be negative, and at least one
+// group must have a positive weight. Requests already in flight are
+// unaffected.
+func (s *TrafficSplitter) SetWeights(weights map[string]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current := s.currentWeights()
+	next := make([]int, len(s.groups))
+	total := 0
+	for i, group := range s.groups {
+		weight, ok := weights[group.name]
+		if !ok {
+			weight = current[i]
+		}
+		if weight < 0 {
+			return fmt.Errorf("target group %q has negative weight %d", group.name, weight)
+		}
+		total += weight
+		next[i] = total
+	}
+	for name := range weights {
+		if s.group(name) == nil {
+			return fmt.Errorf("unknown target group %q", name)
+		}
+	}
+	if total <= 0 {
+		return errors.New("at least one target group must have a positive weight")
+	}
+	s.weights.Store(next)
+	return nil
+}
+
+// Weights returns the current weight of each group, by name.
+func (s *TrafficSplitter) Weights() map[string]int {
+	weights := s.currentWeights()
+	byName := make(map[string]int, len(s.groups))
+	for i, group := range s.groups {
+		byName[group.name] = weights[i]
+	}
+	return byName
+}
+
+// Do implements HTTPClient.
+func (s *TrafficSplitter) Do(request *http.Request) (*http.Response, error) {
+	return s.httpClient.Do(retarget(request, s.pick(request)))
+}
+
+// retarget clones the request, replacing its scheme and host with the
+// target's and prefixing its path with the target's path.
+func retarget(request *http.Request, target *url.URL) *http.Request {
+	request = request.Clone(request.Context())
+	request.URL.Scheme = target.Scheme
+	request.URL.Host = target.Host
+	request.URL.Path = target.Path + request.URL.Path
+	if request.URL.RawPath != "" {
+		request.URL.RawPath = target.EscapedPath() + request.URL.RawPath
+	}
+	request.Host = ""
+	return request
+}
+
+// parseTarget parses a base URL, which must have a scheme and host.
+func parseTarget(target string) (*url.URL, error) {
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme == "" || parsed.Host == "" {
+		return nil, fmt.Errorf("target %q must have a scheme and host", target)
+	}
+	parsed.Path = strings.TrimSuffix(parsed.Path, "/")
+	return parsed, nil
+}
+
+// pick chooses a group by weight, then a target from the group.
+func (s *TrafficSplitter) pick(request *http.Request) *url.URL {
+	weights := s.loadWeights()
+	total := weights[len(weights)-1]
+	var point int
+	if key := s.stickyKey(request); key != "" {
+		hash := fnv.New64a()
+		_, _ = hash.Write([]byte(key))
+		point = int(hash.Sum64() % uint64(total))
+	} else {
+		point = rand.Intn(total) //nolint:gosec
+	}
+	group := s.groups[len(s.groups)-1]
+	for i, cumulative := range weights {
+		if point < cumulative {
+			group = s.groups[i]
+			break
+		}
+	}
+	n := atomic.AddUint64(&group.next, 1)
+	return group.targets[(n-1)%uint64(len(group.targets))]
+}
+
+func (s *TrafficSplitter) stickyKey(request *http.Request) string {
+	if s.stickyHeader == "" {
+		return ""
+	}
+	return request.Header.Get(s.stickyHeader)
+}
+
+func (s *TrafficSplitter) group(name string) *splitGroup {
+	for _, group := range s.groups {
+		if group.name == name {
+			return group
+		}
+	}
+	return nil
+}
+
+func (s *TrafficSplitter) loadWeights() []int {
+	weights, _ := s.weights.Load().([]int)
+	return weights
+}
+
+// currentWeights converts the cumulative weights back to per-group weights.
+// Before the first call to SetWeights, all weights are zero.
+func (s *TrafficSplitter) currentWeights() []int {
+	cumulative := s.loadWeights()
+	weights := make([]int, len(s.groups))
+	previous := 0
+	for i := range cumulative {
+		weights[i] = cumulative[i] - previous
+		previous = cumulative[i]
+	}
+	return weights
+}
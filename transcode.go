@@ -0,0 +1,203 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// NewUnaryTranscoder returns a Handler for a unary procedure that forwards
+// each call to an upstream Client. Since Handlers accept requests using any
+// supported protocol and codec, while the Client uses whichever protocol and
+// codec it was constructed with, a transcoder lets Connect+JSON callers reach
+// a gRPC+Protobuf backend (or vice versa) without writing any forwarding code.
+//
+// Application headers and trailers are forwarded in both directions;
+// protocol-specific headers, like Content-Type and Grpc-Timeout, are
+// regenerated on each side. Deadlines propagate through the context, and
+// errors from the upstream (including their codes, details, and metadata) are
+// returned to the caller as-is.
+func NewUnaryTranscoder[Req, Res any](
+	procedure string,
+	upstream *Client[Req, Res],
+	options ...HandlerOption,
+) *Handler {
+	return NewUnaryHandler(
+		procedure,
+		func(ctx context.Context, request *Request[Req]) (*Response[Res], error) {
+			upstreamRequest := NewRequest(request.Msg)
+			mergeNonProtocolHeaders(upstreamRequest.Header(), request.Header())
+			upstreamResponse, err := upstream.CallUnary(ctx, upstreamRequest)
+			if err != nil {
+				return nil, transcodeError(err)
+			}
+			response := NewResponse(upstreamResponse.Msg)
+			mergeNonProtocolHeaders(response.Header(), upstreamResponse.Header())
+			mergeNonProtocolHeaders(response.Trailer(), upstreamResponse.Trailer())
+			return response, nil
+		},
+		options...,
+	)
+}
+
+// NewClientStreamTranscoder is like NewUnaryTranscoder, but for client
+// streaming procedures. Messages are forwarded upstream as they arrive.
+func NewClientStreamTranscoder[Req, Res any](
+	procedure string,
+	upstream *Client[Req, Res],
+	options ...HandlerOption,
+) *Handler {
+	return NewClientStreamHandler(
+		procedure,
+		func(ctx context.Context, stream *ClientStream[Req]) (*Response[Res], error) {
+			upstreamStream := upstream.CallClientStream(ctx)
+			mergeNonProtocolHeaders(upstreamStream.RequestHeader(), stream.RequestHeader())
+			for stream.Receive() {
+				// If the upstream fails, Send returns an error wrapping io.EOF and
+				// CloseAndReceive returns the upstream's error.
+				if err := upstreamStream.Send(stream.Msg()); err != nil {
+					break
+				}
+			}
+			if err := stream.Err(); err != nil {
+				_, _ = upstreamStream.CloseAndReceive()
+				return nil, err
+			}
+			upstreamResponse, err := upstreamStream.CloseAndReceive()
+			if err != nil {
+				return nil, transcodeError(err)
+			}
+			response := NewResponse(upstreamResponse.Msg)
+			mergeNonProtocolHeaders(response.Header(), upstreamResponse.Header())
+			mergeNonProtocolHeaders(response.Trailer(), upstreamResponse.Trailer())
+			return response, nil
+		},
+		options...,
+	)
+}
+
+// NewServerStreamTranscoder is like NewUnaryTranscoder, but for server
+// streaming procedures. Messages are forwarded to the caller as they arrive.
+func NewServerStreamTranscoder[Req, Res any](
+	procedure string,
+	upstream *Client[Req, Res],
+	options ...HandlerOption,
+) *Handler {
+	return NewServerStreamHandler(
+		procedure,
+		func(ctx context.Context, request *Request[Req], stream *ServerStream[Res]) error {
+			upstreamRequest := NewRequest(request.Msg)
+			mergeNonProtocolHeaders(upstreamRequest.Header(), request.Header())
+			upstreamStream, err := upstream.CallServerStream(ctx, upstreamRequest)
+			if err != nil {
+				return transcodeError(err)
+			}
+			defer upstreamStream.Close()
+			copiedHeader := false
+			for upstreamStream.Receive() {
+				if !copiedHeader {
+					mergeNonProtocolHeaders(stream.ResponseHeader(), upstreamStream.ResponseHeader())
+					copiedHeader = true
+				}
+				if err := stream.Send(upstreamStream.Msg()); err != nil {
+					return err
+				}
+			}
+			if err := upstreamStream.Err(); err != nil {
+				return transcodeError(err)
+			}
+			if !copiedHeader {
+				mergeNonProtocolHeaders(stream.ResponseHeader(), upstreamStream.ResponseHeader())
+			}
+			mergeNonProtocolHeaders(stream.ResponseTrailer(), upstreamStream.ResponseTrailer())
+			return nil
+		},
+		options...,
+	)
+}
+
+// NewBidiStreamTranscoder is like NewUnaryTranscoder, but for bidirectional
+// streaming procedures. Messages are forwarded in both directions as they
+// arrive. Like all bidirectional streams, both the caller and the upstream
+// must use HTTP/2.
+func NewBidiStreamTranscoder[Req, Res any](
+	procedure string,
+	upstream *Client[Req, Res],
+	options ...HandlerOption,
+) *Handler {
+	return NewBidiStreamHandler(
+		procedure,
+		func(ctx context.Context, stream *BidiStream[Req, Res]) error {
+			upstreamStream := upstream.CallBidiStream(ctx)
+			mergeNonProtocolHeaders(upstreamStream.RequestHeader(), stream.RequestHeader())
+			pumped := make(chan struct{})
+			go func() {
+				defer close(pumped)
+				for {
+					msg, err := stream.Receive()
+					if err != nil {
+						break
+					}
+					if err := upstreamStream.Send(msg); err != nil {
+						break
+					}
+				}
+				_ = upstreamStream.CloseSend()
+			}()
+			defer func() {
+				// Handlers can't read the request body after returning, so
+				// unblock any pending reads and wait for the pump to finish.
+				_ = stream.receiver.Close()
+				<-pumped
+			}()
+			defer upstreamStream.CloseReceive()
+			copiedHeader := false
+			for {
+				msg, err := upstreamStream.Receive()
+				if !copiedHeader {
+					mergeNonProtocolHeaders(stream.ResponseHeader(), upstreamStream.ResponseHeader())
+					copiedHeader = true
+				}
+				if errors.Is(err, io.EOF) {
+					break
+				} else if err != nil {
+					return transcodeError(err)
+				}
+				if err := stream.Send(msg); err != nil {
+					return err
+				}
+			}
+			mergeNonProtocolHeaders(stream.ResponseTrailer(), upstreamStream.ResponseTrailer())
+			return nil
+		},
+		options...,
+	)
+}
+
+// transcodeError strips the upstream's protocol headers from an error's
+// metadata, so that they don't conflict with the headers written by the
+// transcoding Handler.
+func transcodeError(err error) error {
+	if connectErr, ok := asError(err); ok && connectErr.meta != nil {
+		for key := range connectErr.meta {
+			if isProtocolHeader(key) {
+				delete(connectErr.meta, key)
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+// messageTransformer transforms marshaled messages before they're compressed
+// and sent, and reverses the transformation on receipt.
+type messageTransformer struct {
+	encode func([]byte) ([]byte, error)
+	decode func([]byte) ([]byte, error)
+}
+
+// transformingCodec applies a messageTransformer to the output of another
+// Codec. Empty payloads are passed through unchanged, since the streaming
+// protocols never pass empty messages to codecs.
+type transformingCodec struct {
+	Codec
+
+	transformer *messageTransformer
+}
+
+var _ Codec = (*transformingCodec)(nil)
+
+func (c *transformingCodec) Marshal(message any) ([]byte, error) {
+	data, err := c.Codec.Marshal(message)
+	if err != nil || len(data) == 0 {
+		return data, err
+	}
+	return c.transformer.encode(data)
+}
+
+func (c *transformingCodec) Unmarshal(data []byte, message any) error {
+	if len(data) == 0 {
+		return c.Codec.Unmarshal(data, message)
+	}
+	decoded, err := c.transformer.decode(data)
+	if err != nil {
+		return err
+	}
+	return c.Codec.Unmarshal(decoded, message)
+}
+
+// newTransformingCodecs wraps each codec with the transformer. Protocol-level
+// messages, like gRPC's error details, still use the untransformed Protobuf
+// codec.
+func newTransformingCodecs(nameToCodec map[string]Codec, transformer *messageTransformer) readOnlyCodecs {
+	original := newReadOnlyCodecs(nameToCodec)
+	transformed := make(map[string]Codec, len(nameToCodec))
+	for name, codec := range nameToCodec {
+		transformed[name] = &transformingCodec{Codec: codec, transformer: transformer}
+	}
+	return &codecMap{
+		nameToCodec: transformed,
+		protobuf:    original.Protobuf(),
+	}
+}
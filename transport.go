@@ -0,0 +1,162 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// A Transport carries the messages for a single RPC without any dependence on
+// HTTP. Most clients should use the default HTTP transport, configured by the
+// HTTPClient passed to NewClient. Custom transports (in-memory, QUIC, or
+// message broker-based, for example) can implement Transport directly rather
+// than faking *http.Response objects.
+//
+// OpenStream opens a stream for the procedure described by the Spec. The
+// header contains the caller's request metadata; as with HTTP, callers may
+// keep modifying it until the first call to the Sender's Send or Close
+// methods, so transports shouldn't read it before then. The returned Sender
+// carries request messages to the server, and the returned Receiver delivers
+// response messages, response headers, and trailers. Unless the Transport
+// is also a UnaryTransport, unary calls use the same API: the client sends
+// one message, closes the Sender, and then expects exactly one response
+// message before the Receiver returns an error wrapping io.EOF.
+//
+// Since messages are passed to the Sender and Receiver as Go values, the
+// Transport is responsible for serializing them if necessary. Errors should
+// be *Errors; other errors are wrapped with CodeUnknown. On the server side of
+// a custom transport, use Handler.ServeStream to dispatch streams to handlers.
+type Transport interface {
+	OpenStream(ctx context.Context, spec Spec, header http.Header) (Sender, Receiver)
+}
+
+// A UnaryTransport is a Transport that sends unary calls in a single round
+// trip rather than opening a stream.
+//
+// RoundTrip sends the request message and the caller's request header, then
+// unmarshals the response message into response, which is a pointer to a
+// zero value of the response type. It returns the response's headers and
+// trailers, which may be nil. As with OpenStream, errors should be *Errors.
+type UnaryTransport interface {
+	Transport
+
+	RoundTrip(
+		ctx context.Context,
+		spec Spec,
+		header http.Header,
+		request, response any,
+	) (responseHeader, responseTrailer http.Header, err error)
+}
+
+// transportClient adapts a Transport to the protocolClient interface.
+type transportClient struct {
+	transport Transport
+}
+
+func (c *transportClient) WriteRequestHeader(StreamType, http.Header) {}
+
+func (c *transportClient) NewStream(ctx context.Context, spec Spec, header http.Header) (Sender, Receiver) {
+	if unary, ok := c.transport.(UnaryTransport); ok && spec.StreamType == StreamTypeUnary {
+		call := &unaryTransportCall{
+			ctx:       ctx,
+			transport: unary,
+			spec:      spec,
+			header:    header,
+
+			responseHeader:  make(http.Header),
+			responseTrailer: make(http.Header),
+		}
+		return wrapClientStreamWithCodedErrors(call, &unaryTransportReceiver{call: call})
+	}
+	return wrapClientStreamWithCodedErrors(c.transport.OpenStream(ctx, spec, header))
+}
+
+// unaryTransportCall adapts a UnaryTransport's RoundTrip to a Sender. The
+// round trip happens on the first call to Receive, since that's when the
+// response message is available to unmarshal into.
+type unaryTransportCall struct {
+	ctx       context.Context //nolint:containedctx // used for the round trip
+	transport UnaryTransport
+	spec      Spec
+	header    http.Header
+
+	request         any
+	sent            bool
+	received        bool
+	responseHeader  http.Header
+	responseTrailer http.Header
+}
+
+func (c *unaryTransportCall) Send(message any) error {
+	if c.sent {
+		return errorf(CodeInternal, "unary call already has a request message")
+	}
+	c.request = message
+	c.sent = true
+	return nil
+}
+
+func (c *unaryTransportCall) Close(error) error {
+	return nil
+}
+
+func (c *unaryTransportCall) Spec() Spec {
+	return c.spec
+}
+
+func (c *unaryTransportCall) Header() http.Header {
+	return c.header
+}
+
+func (c *unaryTransportCall) Trailer() (http.Header, bool) {
+	return nil, false
+}
+
+type unaryTransportReceiver struct {
+	call *unaryTransportCall
+}
+
+func (r *unaryTransportReceiver) Receive(message any) error {
+	call := r.call
+	if call.received {
+		return io.EOF
+	}
+	call.received = true
+	if !call.sent {
+		return errorf(CodeInternal, "unary call has no request message")
+	}
+	header, trailer, err := call.transport.RoundTrip(call.ctx, call.spec, call.header, call.request, message)
+	mergeHeaders(call.responseHeader, header)
+	mergeHeaders(call.responseTrailer, trailer)
+	return err
+}
+
+func (r *unaryTransportReceiver) Close() error {
+	return nil
+}
+
+func (r *unaryTransportReceiver) Spec() Spec {
+	return r.call.spec
+}
+
+func (r *unaryTransportReceiver) Header() http.Header {
+	return r.call.responseHeader
+}
+
+func (r *unaryTransportReceiver) Trailer() (http.Header, bool) {
+	return r.call.responseTrailer, true
+}
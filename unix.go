@@ -0,0 +1,82 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// unixReadHeaderTimeout bounds how long servers from NewUnixServer wait for
+// request headers, so slow clients can't hold connections open indefinitely.
+const unixReadHeaderTimeout = 10 * time.Second
+
+// DialUnix returns an HTTP client that sends all requests over the unix
+// domain socket at path, regardless of the requests' URLs. Use any base URL
+// with the http scheme when constructing clients:
+//
+//	client := pingv1connect.NewPingServiceClient(
+//	  connect.DialUnix("/run/ping.sock"),
+//	  "http://localhost",
+//	)
+//
+// When built with Go 1.24 or later, the client uses HTTP/2 with prior
+// knowledge (h2c), so all stream types are supported; the server should be
+// constructed with NewUnixServer. With earlier versions of Go, the client uses
+// HTTP/1.1 and bidirectional streaming isn't available.
+func DialUnix(path string) *http.Client {
+	var dialer net.Dialer
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", path)
+		},
+	}
+	configureUnixTransport(transport)
+	return &http.Client{Transport: transport}
+}
+
+// ListenUnix listens on the unix domain socket at path. If a stale socket
+// file from a previous process exists, it's removed first.
+func ListenUnix(path string) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil && info.Mode()&fs.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return net.Listen("unix", path)
+}
+
+// NewUnixServer returns an HTTP server for handler that's suitable for
+// serving clients created with DialUnix. Serve connections by passing a
+// listener (usually from ListenUnix) to the server's Serve method.
+//
+// When built with Go 1.24 or later, the server accepts both HTTP/1.1 and
+// HTTP/2 with prior knowledge (h2c). Clients must send their request headers
+// within ten seconds; adjust the server's ReadHeaderTimeout if necessary.
+func NewUnixServer(handler http.Handler) *http.Server {
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: unixReadHeaderTimeout,
+	}
+	configureUnixServer(server)
+	return server
+}
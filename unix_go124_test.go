@@ -0,0 +1,45 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.24
+
+package connect_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/bufbuild/connect-go/internal/assert"
+	pingv1 "github.com/bufbuild/connect-go/internal/gen/connect/ping/v1"
+)
+
+func TestUnixSocketBidi(t *testing.T) {
+	t.Parallel()
+	client := startUnixServer(t)
+	stream := client.CumSum(context.Background())
+	stream.RequestHeader().Set(clientHeader, headerValue)
+	var got []int64
+	for _, n := range []int64{3, 5, 1} {
+		assert.Nil(t, stream.Send(&pingv1.CumSumRequest{Number: n}))
+		response, err := stream.Receive()
+		assert.Nil(t, err)
+		got = append(got, response.Sum)
+	}
+	assert.Nil(t, stream.CloseSend())
+	_, err := stream.Receive()
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Nil(t, stream.CloseReceive())
+	assert.Equal(t, got, []int64{3, 8, 9})
+}
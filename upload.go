@@ -0,0 +1,352 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// UploadPart describes the file that an upload chunk belongs to.
+type UploadPart struct {
+	// FormName is the name of the multipart form field. It's empty for raw
+	// uploads.
+	FormName string
+	// Filename is the name of the uploaded file, if the client sent one. For
+	// raw uploads, it comes from the request's Content-Disposition header.
+	Filename string
+	// ContentType is the file's Content-Type, if the client sent one.
+	ContentType string
+}
+
+// defaultUploadMaxBytes is the default limit on the size of uploads.
+const defaultUploadMaxBytes = 32 << 20 // 32 MiB
+
+// WithUploadChunkSize sets the maximum size of the chunk in each message of
+// an upload served by NewUploadHandler. The default is DefaultChunkSize.
+// Other handlers ignore it.
+func WithUploadChunkSize(size int) HandlerOption {
+	return &uploadChunkSizeOption{size: size}
+}
+
+// WithUploadMaxBytes limits the total size of the request body of an upload
+// served by NewUploadHandler, including any multipart framing. Larger
+// uploads fail with CodeResourceExhausted once the limit is reached. The
+// default is 32 MiB; passing a negative number removes the limit, and
+// passing zero restores the default. Other handlers ignore it.
+func WithUploadMaxBytes(n int64) HandlerOption {
+	return &uploadMaxBytesOption{max: n}
+}
+
+// NewUploadHandler lets browsers and other plain HTTP clients upload files
+// into a client streaming procedure without custom glue. It accepts either a
+// multipart/form-data POST, like the ones browsers send for forms with file
+// inputs, or a raw request body sent with PUT or POST. Each part (or the
+// whole raw body) is split into chunks, and wrap builds the request message
+// that carries each chunk. Wrap is called with an empty chunk for empty
+// parts, so the implementation sees every part. Typically, wrap sets a bytes
+// field and, for multi-file uploads, the part's filename:
+//
+//	connect.NewUploadHandler(
+//		filev1connect.FileServiceIngestProcedure,
+//		svc.Ingest,
+//		func(part connect.UploadPart, chunk []byte) *filev1.IngestRequest {
+//			return &filev1.IngestRequest{Filename: part.Filename, Data: chunk}
+//		},
+//	)
+//
+// The implementation is the same function passed to NewClientStreamHandler,
+// and so are the handler options: interceptors (for authentication, say),
+// validation, and the other handler options apply to uploads too. Options
+// that only make sense for the RPC protocols, like codecs and compression,
+// have no effect. Chunks are read from the request body as the
+// implementation receives them, so memory use doesn't grow with the size of
+// the upload. Its response is written as JSON, and errors are written like
+// unary Connect errors, with an HTTP status derived from the error code and
+// a JSON body.
+func NewUploadHandler[Req, Res any](
+	procedure string,
+	implementation func(context.Context, *ClientStream[Req]) (*Response[Res], error),
+	wrap func(part UploadPart, chunk []byte) *Req,
+	options ...HandlerOption,
+) http.Handler {
+	config := newHandlerConfig(procedure, options)
+	chunkSize := config.UploadChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	maxBytes := config.UploadMaxBytes
+	if maxBytes == 0 {
+		maxBytes = defaultUploadMaxBytes
+	}
+	codec := config.Codecs[codecNameJSON]
+	if codec == nil {
+		codec = &protoJSONCodec{}
+	}
+	// Uploads deliver their own messages, so there's nothing to unmarshal
+	// lazily.
+	config.LazyUnmarshal = false
+	handler := newClientStreamHandler(config, implementation)
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost && request.Method != http.MethodPut {
+			responseWriter.Header().Set("Allow", http.MethodPost+", "+http.MethodPut)
+			responseWriter.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		body := &uploadBody{ReadCloser: request.Body, max: maxBytes, remaining: maxBytes}
+		request.Body = body
+		sender := &uploadSender{
+			spec:   handler.spec,
+			writer: responseWriter,
+			codec:  codec,
+			types:  config.Types,
+		}
+		receiver := &uploadReceiver[Req]{
+			spec:    handler.spec,
+			request: request,
+			body:    body,
+			wrap:    wrap,
+			buffer:  make([]byte, chunkSize),
+		}
+		mediaType, _, _ := mime.ParseMediaType(request.Header.Get(headerContentType))
+		if mediaType == "multipart/form-data" {
+			parts, err := request.MultipartReader()
+			if err != nil {
+				err = errorf(CodeInvalidArgument, "read upload: %w", err)
+				_ = writeConnectUnaryError(responseWriter, err, config.Types)
+				return
+			}
+			receiver.multipart = parts
+		}
+		handler.serveStream(request.Context(), sender, receiver)
+	})
+}
+
+type uploadChunkSizeOption struct {
+	size int
+}
+
+func (o *uploadChunkSizeOption) applyToHandler(config *handlerConfig) {
+	config.UploadChunkSize = o.size
+}
+
+type uploadMaxBytesOption struct {
+	max int64
+}
+
+func (o *uploadMaxBytesOption) applyToHandler(config *handlerConfig) {
+	config.UploadMaxBytes = o.max
+}
+
+// uploadBody fails reads with CodeResourceExhausted once more than max bytes
+// have been read. Like http.MaxBytesReader, it remembers the failure, so it
+// can be reported even if a multipart reader obscures it.
+type uploadBody struct {
+	io.ReadCloser
+
+	max       int64 // non-positive means no limit
+	remaining int64
+	err       *Error
+}
+
+func (b *uploadBody) Read(data []byte) (int, error) {
+	if b.max <= 0 {
+		return b.ReadCloser.Read(data)
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	if int64(len(data)) > b.remaining+1 {
+		// Read one byte past the limit, so we know if it's exceeded.
+		data = data[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(data)
+	if int64(n) <= b.remaining {
+		b.remaining -= int64(n)
+		return n, err
+	}
+	n = int(b.remaining)
+	b.remaining = 0
+	b.err = errorf(CodeResourceExhausted, "upload exceeds %d bytes", b.max)
+	return n, b.err
+}
+
+// uploadSender writes the implementation's response as JSON, or its error
+// as a unary Connect error.
+type uploadSender struct {
+	spec   Spec
+	writer http.ResponseWriter
+	codec  Codec
+	types  *protoregistry.Types
+	wrote  bool
+}
+
+func (s *uploadSender) Spec() Spec {
+	return s.spec
+}
+
+func (s *uploadSender) Header() http.Header {
+	return s.writer.Header()
+}
+
+// Trailer returns false, since plain HTTP responses don't have trailers.
+func (s *uploadSender) Trailer() (http.Header, bool) {
+	return nil, false
+}
+
+func (s *uploadSender) Send(message any) error {
+	data, err := s.codec.Marshal(message)
+	if err != nil {
+		return errorf(CodeInternal, "marshal response: %w", err)
+	}
+	s.wrote = true
+	s.writer.Header().Set(headerContentType, connectUnaryContentTypeJSON)
+	if _, err := s.writer.Write(data); err != nil {
+		return errorf(CodeUnknown, "write response: %w", err)
+	}
+	return nil
+}
+
+func (s *uploadSender) Close(err error) error {
+	if err == nil || s.wrote {
+		return nil
+	}
+	return writeConnectUnaryError(s.writer, err, s.types)
+}
+
+// uploadReceiver turns the parts of an upload into a stream of messages, one
+// per chunk.
+type uploadReceiver[Req any] struct {
+	spec      Spec
+	request   *http.Request
+	body      *uploadBody
+	wrap      func(UploadPart, []byte) *Req
+	buffer    []byte
+	multipart *multipart.Reader // nil for raw uploads
+
+	part      UploadPart
+	reader    io.Reader // nil between parts
+	announced bool      // whether the current part has produced a message
+	done      bool      // whether the raw body has been consumed
+}
+
+func (r *uploadReceiver[Req]) Spec() Spec {
+	return r.spec
+}
+
+func (r *uploadReceiver[Req]) Header() http.Header {
+	return r.request.Header
+}
+
+func (r *uploadReceiver[Req]) Trailer() (http.Header, bool) {
+	return nil, false
+}
+
+func (r *uploadReceiver[Req]) Close() error {
+	return r.request.Body.Close()
+}
+
+func (r *uploadReceiver[Req]) Receive(message any) error {
+	typed, ok := message.(*Req)
+	if !ok {
+		return errorf(CodeInternal, "expected message of type %T, got %T", (*Req)(nil), message)
+	}
+	for {
+		if r.reader == nil {
+			if err := r.nextPart(); err != nil {
+				return err
+			}
+		}
+		n, err := io.ReadFull(r.reader, r.buffer)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return r.readError(CodeUnknown, err)
+		}
+		if err != nil {
+			// We've reached the end of this part.
+			r.reader = nil
+			if n == 0 && r.announced {
+				continue
+			}
+		}
+		r.announced = true
+		chunk := r.buffer[:n]
+		if _, ok := message.(proto.Message); !ok {
+			// The buffer is reused for the next chunk. Merging Protobuf messages
+			// copies their bytes, but copying other types would alias it.
+			chunk = append([]byte(nil), chunk...)
+		}
+		copyMessage(typed, r.wrap(r.part, chunk))
+		return nil
+	}
+}
+
+func (r *uploadReceiver[Req]) nextPart() error {
+	r.announced = false
+	if r.multipart == nil {
+		if r.done {
+			return NewError(CodeUnknown, io.EOF)
+		}
+		r.done = true
+		r.part = UploadPart{ContentType: r.request.Header.Get(headerContentType)}
+		if _, params, err := mime.ParseMediaType(r.request.Header.Get("Content-Disposition")); err == nil {
+			r.part.Filename = params["filename"]
+		}
+		r.reader = r.request.Body
+		return nil
+	}
+	part, err := r.multipart.NextPart()
+	if errors.Is(err, io.EOF) {
+		return NewError(CodeUnknown, io.EOF)
+	} else if err != nil {
+		return r.readError(CodeInvalidArgument, err)
+	}
+	r.part = UploadPart{
+		FormName:    part.FormName(),
+		Filename:    part.FileName(),
+		ContentType: part.Header.Get(headerContentType),
+	}
+	r.reader = part
+	return nil
+}
+
+// readError reports a failure to read the upload. If the upload is too
+// large, it reports that instead, since multipart readers don't preserve
+// the underlying error.
+func (r *uploadReceiver[Req]) readError(code Code, err error) error {
+	if r.body.err != nil {
+		return r.body.err
+	}
+	return errorf(code, "read upload: %w", err)
+}
+
+// copyMessage overwrites dst with the contents of src. Protobuf messages
+// mustn't be copied by value, so we use proto.Merge when we can.
+func copyMessage[T any](dst, src *T) {
+	if dstMessage, ok := any(dst).(proto.Message); ok {
+		if srcMessage, ok := any(src).(proto.Message); ok {
+			proto.Reset(dstMessage)
+			proto.Merge(dstMessage, srcMessage)
+			return
+		}
+	}
+	*dst = *src
+}
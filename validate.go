@@ -0,0 +1,125 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"reflect"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// A Validator checks the semantic constraints of Protobuf messages, like
+// those expressed with buf.validate annotations. Validators built with
+// github.com/bufbuild/protovalidate-go satisfy this interface.
+//
+// See WithValidator and WithValidation for details.
+type Validator interface {
+	Validate(proto.Message) error
+}
+
+// validatingSender validates outbound messages before sending them. Clients
+// use it to reject invalid requests without a round trip to the server.
+type validatingSender struct {
+	Sender
+
+	validator Validator
+}
+
+func (s *validatingSender) Send(msg any) error {
+	if err := validate(s.validator, msg); err != nil {
+		return err
+	}
+	return s.Sender.Send(msg)
+}
+
+func (s *validatingSender) sendUncompressed(msg any) error {
+	if err := validate(s.validator, msg); err != nil {
+		return err
+	}
+	return sendUncompressed(s.Sender, msg)
+}
+
+func (s *validatingSender) sendBatch(messages []any) error {
+	for _, msg := range messages {
+		if err := validate(s.validator, msg); err != nil {
+			return err
+		}
+	}
+	return sendBatch(s.Sender, messages)
+}
+
+// validatingReceiver validates inbound messages after unmarshaling them.
+// Handlers use it to reject invalid requests before application code sees
+// them.
+type validatingReceiver struct {
+	Receiver
+
+	validator Validator
+}
+
+func (r *validatingReceiver) Receive(msg any) error {
+	if err := r.Receiver.Receive(msg); err != nil {
+		return err
+	}
+	return validate(r.validator, msg)
+}
+
+func (r *validatingReceiver) readAhead() {
+	readAhead(r.Receiver)
+}
+
+// validate runs the validator on Protobuf messages, ignoring other types. It
+// wraps failures with CodeInvalidArgument and, if the validator's error can
+// describe its violations as a Protobuf message, attaches them as a detail.
+func validate(validator Validator, msg any) error {
+	protoMsg, ok := msg.(proto.Message)
+	if !ok {
+		return nil
+	}
+	err := validator.Validate(protoMsg)
+	if err == nil {
+		return nil
+	}
+	if connectErr, ok := asError(err); ok {
+		return connectErr
+	}
+	validationErr := NewError(CodeInvalidArgument, err)
+	if violations := violationsAsProto(err); violations != nil {
+		if detail, anyErr := anypb.New(violations); anyErr == nil {
+			validationErr.AddDetail(detail)
+		}
+	}
+	return validationErr
+}
+
+// violationsAsProto calls the error's ToProto method, if any, and returns the
+// result if it's a Protobuf message. protovalidate's ValidationError returns
+// a concrete *validate.Violations, so we can't use a type assertion.
+func violationsAsProto(err error) proto.Message {
+	method := reflect.ValueOf(err).MethodByName("ToProto")
+	if !method.IsValid() || method.Type().NumIn() != 0 || method.Type().NumOut() != 1 {
+		return nil
+	}
+	result := method.Call(nil)[0]
+	if result.Kind() == reflect.Pointer && result.IsNil() {
+		return nil
+	}
+	violations, ok := result.Interface().(proto.Message)
+	if !ok {
+		return nil
+	}
+	return violations
+}
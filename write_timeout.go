@@ -0,0 +1,93 @@
+// Copyright 2021-2022 Buf Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// writeTimeout is the configuration for WithWriteTimeout.
+type writeTimeout struct {
+	timeout time.Duration
+	clock   Clock
+}
+
+// writeTimeoutSender bounds how long each write to the client may take, so
+// that a client that stops reading can't pin the handler's goroutine.
+type writeTimeoutSender struct {
+	Sender
+
+	writer http.ResponseWriter
+	config *writeTimeout
+}
+
+func (s *writeTimeoutSender) Send(msg any) error {
+	return s.withDeadline(func() error { return s.Sender.Send(msg) })
+}
+
+func (s *writeTimeoutSender) sendUncompressed(msg any) error {
+	return s.withDeadline(func() error { return sendUncompressed(s.Sender, msg) })
+}
+
+func (s *writeTimeoutSender) sendBatch(messages []any) error {
+	return s.withDeadline(func() error { return sendBatch(s.Sender, messages) })
+}
+
+func (s *writeTimeoutSender) Close(err error) error {
+	return s.withDeadline(func() error { return s.Sender.Close(err) })
+}
+
+// withDeadline runs write, interrupting it if it's still running once the
+// timeout elapses on the clock. Since the clock may not be the system clock,
+// writes are interrupted by moving the write deadline into the past rather
+// than by setting a deadline up front. The deadline is cleared afterwards, so
+// time spent between messages doesn't count against the next write.
+// ResponseWriters that don't support deadlines can't be interrupted.
+func (s *writeTimeoutSender) withDeadline(write func() error) error {
+	var (
+		mu       sync.Mutex
+		finished bool
+		expired  bool
+	)
+	stop := s.config.clock.AfterFunc(s.config.timeout, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if finished {
+			return
+		}
+		expired = true
+		_ = setWriteDeadline(s.writer, time.Unix(1, 0))
+	})
+	err := write()
+	stop()
+	mu.Lock()
+	finished = true
+	interrupted := expired
+	mu.Unlock()
+	if !interrupted {
+		return err
+	}
+	_ = setWriteDeadline(s.writer, time.Time{})
+	// The write may have finished just before its deadline expired, but the
+	// expired deadline can still break the connection for the next write, so
+	// report the timeout either way.
+	if err == nil {
+		return NewError(CodeDeadlineExceeded, fmt.Errorf("write stalled for more than %v", s.config.timeout))
+	}
+	return NewError(CodeDeadlineExceeded, fmt.Errorf("write stalled for more than %v: %w", s.config.timeout, err))
+}